@@ -13,10 +13,18 @@ import (
 	"strings"
 
 	"github.com/linuxboot/fiano/pkg/cbfs"
+	"github.com/linuxboot/fiano/pkg/measuredboot"
 	flag "github.com/spf13/pflag"
 )
 
-var debug = flag.BoolP("debug", "d", false, "enable debug prints")
+var (
+	debug   = flag.BoolP("debug", "d", false, "enable debug prints")
+	region  = flag.StringP("region", "r", cbfs.DefaultRegion, "FMAP area holding the CBFS")
+	verbose = flag.BoolP("verbose", "v", false, "print details of every file")
+	fitMax  = flag.IntP("fit-entries", "j", 0, "number of FIT entries besides the header for fit-add-microcode")
+	pcrHash = flag.String("pcr-hash", "sha256", "hash of the TPM bank for pcr")
+	pcr     = flag.Int("pcr", measuredboot.CorebootSRTMPCR, "PCR coreboot measures into for pcr")
+)
 
 func main() {
 	flag.Parse()
@@ -27,17 +35,82 @@ func main() {
 
 	a := flag.Args()
 	if len(a) < 2 {
-		log.Fatal("Usage: cbfs <firmware-file> <json,list,extract <directory-name>>")
+		log.Fatal("Usage: cbfs <firmware-file> <json,list,print,layout,regions,verify,fit,pcr,fit-add-microcode <output>,fit-clear <output>,extract <directory-name>>")
 	}
 
-	i, err := cbfs.Open(a[0])
+	f, err := os.Open(a[0])
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer f.Close()
+	i, err := cbfs.NewImageRegion(f, *region)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range i.Warnings {
+		log.Printf("Warning: %v", w)
+	}
 
 	switch a[1] {
 	case "list":
 		fmt.Printf("%s", i.String())
+	case "print":
+		if err := i.Print(os.Stdout, *verbose); err != nil {
+			log.Fatal(err)
+		}
+	case "layout":
+		if err := i.Layout(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	case "regions":
+		for _, r := range i.Regions() {
+			fmt.Println(r)
+		}
+	case "verify":
+		if err := i.VerifyHashes(); err != nil {
+			log.Fatal(err)
+		}
+		if err := i.VerifyMetadataHash(); err != nil && err != cbfs.ErrNoMetadataHashAnchor {
+			log.Fatal(err)
+		}
+	case "fit":
+		t, _, err := i.FIT()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s", t.String())
+	case "pcr":
+		h, err := measuredboot.ParseHash(*pcrHash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := measuredboot.Coreboot(i.Data, *region, h, *pcr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l.Print(os.Stdout)
+	case "fit-add-microcode", "fit-clear":
+		if len(a) != 3 {
+			log.Fatal("provide an output file name")
+		}
+		if a[1] == "fit-clear" {
+			err = i.ClearFIT()
+		} else {
+			var n int
+			n, err = i.AddFITMicrocode(cbfs.MicrocodeBlobName, *fitMax)
+			if err == nil {
+				log.Printf("Added %d microcode entries", n)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := i.Update(); err != nil {
+			log.Fatal(err)
+		}
+		if err := i.WriteFile(a[2], 0644); err != nil {
+			log.Fatal(err)
+		}
 	case "json":
 		j, err := json.MarshalIndent(i, "  ", "  ")
 		if err != nil {
@@ -6,18 +6,27 @@
 //
 // Synopsis:
 //     fmap checksum [md5|sha1|sha256] FILE
+//     fmap create LAYOUT FILE
 //     fmap extract [index|name] FILE
+//     fmap fmd FILE
 //     fmap jget JSONFILE FILE
 //     fmap jput JSONFILE FILE
+//     fmap scan FILE
 //     fmap summary FILE
 //     fmap usage FILE
 //     fmap verify FILE
 //
 // Description:
 //     checksum: Print a checksum using the given hash function.
+//     create:   Write an fmap created from LAYOUT into its FMAP area. LAYOUT
+//               is in the fmd format of coreboot's fmaptool if it ends in
+//               .fmd, else in flashrom's layout format. FILE is created if
+//               it doesn't exist.
 //     extract:  Print the i-th area or area name from the flash.
+//     fmd:      Print the flash map in the fmd format.
 //     jget:     Write json representation of the fmap to JSONFILE.
 //     jput:     Replace current fmap with json representation in JSONFILE.
+//     scan:     List all fmap signatures and whether they are valid.
 //     summary:  Print a human readable summary.
 //     usage:    Print human readable usage stats.
 //     verify:   Return 1 if the flash map is invalid.
@@ -32,11 +41,14 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/linuxboot/fiano/pkg/fmap"
@@ -49,9 +61,12 @@ var cmds = map[string]struct {
 	f                   func(a cmdArgs) error
 }{
 	"checksum": {1, true, true, checksum},
+	"create":   {1, false, false, create},
 	"extract":  {1, true, true, extract},
+	"fmd":      {0, true, true, fmd},
 	"jget":     {1, true, true, jsonGet},
 	"jput":     {1, false, false, jsonPut},
+	"scan":     {0, true, false, scan},
 	"summary":  {0, true, true, summary},
 	"usage":    {0, true, false, usage},
 	"jusage":   {0, true, false, jusage},
@@ -60,6 +75,8 @@ var cmds = map[string]struct {
 
 type cmdArgs struct {
 	args []string
+	// file is the path of FILE.
+	file string
 	f    *fmap.FMap     // optional
 	m    *fmap.Metadata // optional
 	r    *os.File
@@ -94,6 +111,71 @@ func checksum(a cmdArgs) error {
 	return nil
 }
 
+// Create an fmap from a layout file and write it into its FMAP area.
+func create(a cmdArgs) error {
+	l, err := os.Open(a.args[0])
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	name := a.file
+	flash, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var f *fmap.FMap
+	if strings.HasSuffix(a.args[0], ".fmd") {
+		if f, _, err = fmap.ParseFMD(l); err != nil {
+			return err
+		}
+		if flash != nil && uint32(len(flash)) != f.Size {
+			return fmt.Errorf("layout is for a flash of %#x bytes, %s has %#x", f.Size, name, len(flash))
+		}
+	} else {
+		size := uint32(len(flash))
+		if flash == nil {
+			size = math.MaxUint32
+		}
+		if f, err = fmap.New("FLASH", 0, size); err != nil {
+			return err
+		}
+		if err := f.ParseLayout(l); err != nil {
+			return err
+		}
+		if flash == nil {
+			f.Size = 0
+			for _, v := range f.Areas {
+				if uint32(v.End()) > f.Size {
+					f.Size = uint32(v.End())
+				}
+			}
+		}
+	}
+	if flash == nil {
+		flash = bytes.Repeat([]byte{0xff}, int(f.Size))
+	}
+
+	i := f.IndexOfArea("FMAP")
+	if i == -1 {
+		return errors.New("layout has no FMAP area")
+	}
+	b, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if uint32(len(b)) > f.Areas[i].Size {
+		return fmt.Errorf("fmap of %#x bytes does not fit into FMAP area of %#x bytes", len(b), f.Areas[i].Size)
+	}
+	copy(flash[f.Areas[i].Offset:], b)
+	return os.WriteFile(name, flash, 0666)
+}
+
+// Print the fmap in the fmd format of coreboot's fmaptool.
+func fmd(a cmdArgs) error {
+	return fmap.WriteFMD(os.Stdout, a.f, nil)
+}
+
 // Print the i-th area of the flash.
 func extract(a cmdArgs) error {
 	i, err := strconv.Atoi(a.args[0])
@@ -123,7 +205,7 @@ func jsonGet(a cmdArgs) error {
 
 // Replace current fmap with json representation in JSONFILE.
 func jsonPut(a cmdArgs) error {
-	r, err := os.OpenFile(os.Args[len(os.Args)-1], os.O_WRONLY, 0666)
+	r, err := os.OpenFile(a.file, os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
@@ -140,6 +222,22 @@ func jsonPut(a cmdArgs) error {
 	return fmap.Write(r, j.FMap, j.Metadata)
 }
 
+// List all fmap signatures and whether they are valid.
+func scan(a cmdArgs) error {
+	data, err := io.ReadAll(a.r)
+	if err != nil {
+		return err
+	}
+	for _, c := range fmap.Scan(data) {
+		if c.Err != nil {
+			fmt.Printf("%#x: invalid: %v\n", c.Start, c.Err)
+			continue
+		}
+		fmt.Printf("%#x: %s, size %#x, %d areas\n", c.Start, c.FMap.Name.String(), c.FMap.Size, c.FMap.NAreas)
+	}
+	return nil
+}
+
 // Print a human readable summary.
 func summary(a cmdArgs) error {
 	const desc = `Fmap found at {{printf "%#x" .Metadata.Start}}:
@@ -323,29 +421,33 @@ func printUsage() {
 }
 
 func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+
 	// Validate args.
-	if len(os.Args) <= 2 {
+	if flag.NArg() < 2 {
 		printUsage()
 	}
-	cmd, ok := cmds[os.Args[1]]
+	cmd, ok := cmds[flag.Arg(0)]
 	if !ok {
-		log.Errorf("Invalid command %#v\n", os.Args[1])
+		log.Errorf("Invalid command %#v\n", flag.Arg(0))
 		printUsage()
 	}
-	if len(os.Args) != cmd.nArgs+3 {
-		log.Errorf("Expected %d arguments, got %d\n", cmd.nArgs+3, len(os.Args))
+	if flag.NArg() != cmd.nArgs+2 {
+		log.Errorf("Expected %d arguments, got %d\n", cmd.nArgs+2, flag.NArg())
 		printUsage()
 	}
 
 	// Args passed to the command.
 	a := cmdArgs{
-		args: os.Args[2 : len(os.Args)-1],
+		args: flag.Args()[1 : flag.NArg()-1],
+		file: flag.Arg(flag.NArg() - 1),
 	}
 
 	// Open file, but only for specific commands.
 	if cmd.openFile {
 		// Open file.
-		r, err := os.Open(os.Args[len(os.Args)-1])
+		r, err := os.Open(a.file)
 		if err != nil {
 			log.Fatalf("%v", err)
 		}
@@ -0,0 +1,206 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// libfiano is a C library parsing, editing and assembling UEFI images.
+//
+// Synopsis:
+//
+//	go build -buildmode=c-shared -o libfiano.so ./cmds/libfiano
+//
+// This writes libfiano.so and its header, libfiano.h. Images are handles
+// from fiano_parse, freed with fiano_close. Functions return 0, or a handle,
+// on success; on failure they return -1, or 0 for fiano_parse, and set
+// *err, if err is not NULL, to the message. Strings and buffers returned
+// are allocated with malloc and freed with fiano_free.
+//
+//	uintptr_t fiano_parse(void *buf, size_t len, char **err);
+//	void      fiano_close(uintptr_t image);
+//	char     *fiano_list_files(uintptr_t image, char **err);
+//	int       fiano_extract(uintptr_t image, char *query, void **buf, size_t *len, char **err);
+//	int       fiano_replace(uintptr_t image, char *query, void *buf, size_t len, char **err);
+//	int       fiano_assemble(uintptr_t image, void **buf, size_t *len, char **err);
+//	void      fiano_free(void *p);
+//
+// Queries are GUIDs, names or queries, like those of utk find. The list of
+// files is a JSON array of objects with the GUID, Name, Type and Size of
+// each file. fiano_extract returns the bytes of the first file matching,
+// and fiano_replace replaces the one file matching with a file, its
+// header included.
+//
+// Example, from Python:
+//
+//	lib = ctypes.CDLL("./libfiano.so")
+//	lib.fiano_parse.restype = ctypes.c_size_t
+//	lib.fiano_list_files.restype = ctypes.c_void_p
+//	img = lib.fiano_parse(data, len(data), None)
+//	p = lib.fiano_list_files(ctypes.c_size_t(img), None)
+//	files = json.loads(ctypes.string_at(p))
+//	lib.fiano_free(ctypes.c_void_p(p))
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+
+	"github.com/linuxboot/fiano/pkg/firmware"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+func main() {}
+
+// fileInfo is an element of the list of fiano_list_files.
+type fileInfo struct {
+	GUID string
+	Name string
+	Type string
+	Size uint64
+}
+
+func listFiles(im *firmware.Image) ([]byte, error) {
+	files, err := im.Files()
+	if err != nil {
+		return nil, err
+	}
+	infos := []fileInfo{}
+	for _, f := range files {
+		info := fileInfo{GUID: f.Header.GUID.String(), Type: f.Type, Size: uint64(len(f.Buf()))}
+		for _, s := range f.Sections {
+			if s.Header.Type == uefi.SectionTypeUserInterface {
+				info.Name = s.Name
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+	return json.Marshal(infos)
+}
+
+func extract(im *firmware.Image, query string) ([]byte, error) {
+	matches, err := im.Find(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no file matches %q", query)
+	}
+	return matches[0].Buf(), nil
+}
+
+func replace(im *firmware.Image, query string, buf []byte) error {
+	file, err := uefi.NewFile(buf)
+	if err != nil {
+		return err
+	}
+	return im.Insert(file, visitors.InsertTypeReplaceFFS, query)
+}
+
+// setErr sets *errp to the message of err, if errp is not NULL.
+func setErr(errp **C.char, err error) {
+	if errp != nil {
+		*errp = C.CString(err.Error())
+	}
+}
+
+// image returns the image of the handle.
+func image(h C.uintptr_t) (im *firmware.Image, err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("invalid image handle %d", uintptr(h))
+		}
+	}()
+	return cgo.Handle(h).Value().(*firmware.Image), nil
+}
+
+// setBuf sets *buf and *n to a copy of b allocated with malloc.
+func setBuf(b []byte, buf *unsafe.Pointer, n *C.size_t) {
+	*buf = C.CBytes(b)
+	*n = C.size_t(len(b))
+}
+
+//export fiano_parse
+func fiano_parse(buf unsafe.Pointer, n C.size_t, errp **C.char) C.uintptr_t {
+	im, err := firmware.Read(bytes.NewReader(C.GoBytes(buf, C.int(n))))
+	if err != nil {
+		setErr(errp, err)
+		return 0
+	}
+	return C.uintptr_t(cgo.NewHandle(im))
+}
+
+//export fiano_close
+func fiano_close(h C.uintptr_t) {
+	if _, err := image(h); err == nil {
+		cgo.Handle(h).Delete()
+	}
+}
+
+//export fiano_list_files
+func fiano_list_files(h C.uintptr_t, errp **C.char) *C.char {
+	im, err := image(h)
+	if err != nil {
+		setErr(errp, err)
+		return nil
+	}
+	b, err := listFiles(im)
+	if err != nil {
+		setErr(errp, err)
+		return nil
+	}
+	return C.CString(string(b))
+}
+
+//export fiano_extract
+func fiano_extract(h C.uintptr_t, query *C.char, buf *unsafe.Pointer, n *C.size_t, errp **C.char) C.int {
+	im, err := image(h)
+	if err == nil {
+		var b []byte
+		if b, err = extract(im, C.GoString(query)); err == nil {
+			setBuf(b, buf, n)
+			return 0
+		}
+	}
+	setErr(errp, err)
+	return -1
+}
+
+//export fiano_replace
+func fiano_replace(h C.uintptr_t, query *C.char, buf unsafe.Pointer, n C.size_t, errp **C.char) C.int {
+	im, err := image(h)
+	if err == nil {
+		if err = replace(im, C.GoString(query), C.GoBytes(buf, C.int(n))); err == nil {
+			return 0
+		}
+	}
+	setErr(errp, err)
+	return -1
+}
+
+//export fiano_assemble
+func fiano_assemble(h C.uintptr_t, buf *unsafe.Pointer, n *C.size_t, errp **C.char) C.int {
+	im, err := image(h)
+	if err == nil {
+		var b []byte
+		if b, err = im.Bytes(); err == nil {
+			setBuf(b, buf, n)
+			return 0
+		}
+	}
+	setErr(errp, err)
+	return -1
+}
+
+//export fiano_free
+func fiano_free(p unsafe.Pointer) {
+	C.free(p)
+}
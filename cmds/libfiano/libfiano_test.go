@@ -0,0 +1,68 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/firmware"
+)
+
+func TestLibrary(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im, err := firmware.Read(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := listFiles(im)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []fileInfo
+	if err := json.Unmarshal(b, &files); err != nil {
+		t.Fatal(err)
+	}
+	var shell *fileInfo
+	for i := range files {
+		if files[i].Name == "Shell" {
+			shell = &files[i]
+		}
+	}
+	if shell == nil || shell.Type != "EFI_FV_FILETYPE_APPLICATION" {
+		t.Fatalf("got the Shell %+v in the list, want an application", shell)
+	}
+
+	blob, err := extract(im, shell.GUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(blob)) != shell.Size {
+		t.Errorf("extracted %d bytes, want the %d of the file", len(blob), shell.Size)
+	}
+	if _, err := extract(im, "no-such-file"); err == nil {
+		t.Errorf("extracting a missing file succeeded, want an error")
+	}
+
+	if err := replace(im, "Shell", blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := replace(im, "Shell", []byte("not a file")); err == nil {
+		t.Errorf("replacing with an invalid file succeeded, want an error")
+	}
+	out, err := im.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, image) {
+		t.Errorf("replacing the Shell by itself changed the image")
+	}
+}
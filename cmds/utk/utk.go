@@ -14,15 +14,57 @@
 //     # Dump a single file to JSON (using regex):
 //     utk winterfell.rom find Shell
 //
+//     # Dump drivers with Usb in their name to JSON (using a query):
+//     utk winterfell.rom find '//FV/File[type=DRIVER][name~="Usb"]'
+//
 //     # Dump GUIDs and sizes to a compact table:
 //     utk winterfell.rom table
 //
+//     # Dump the table as YAML for scripts (also toml or json):
+//     utk -format yaml winterfell.rom table
+//
 //     # Extract everything into a directory:
 //     utk winterfell.rom extract winterfell/
 //
 //     # Re-assemble the directory into an image:
 //     utk winterfell/ save winterfell2.rom
 //
+//     # Navigate the image and apply operations in a shell, where begin,
+//     # commit and rollback group them in a transaction:
+//     utk -i winterfell.rom
+//
+//     # Run the operations of a YAML or JSON script file:
+//     utk winterfell.rom -script ops.yaml
+//
+//     # Assemble with erased pad files, for reproducible padding:
+//     utk -pad-files erase winterfell/ save winterfell2.rom
+//
+//     # Compress LZMA sections with the xz of the vendor toolchain:
+//     utk -compressor 'LZMA=/opt/vendor/xz --format=lzma -9 --stdout' \
+//       winterfell/ save winterfell2.rom
+//
+//     # Keep PEIMs as opaque blobs, parsing the sections of other files:
+//     utk -opaque-types PEIM winterfell.rom table
+//
+//     # Write validation findings and errors as JSON lines on stderr:
+//     utk -json-errors winterfell.rom validate
+//
+//     # Run an operation written out of tree on the files matching Shell:
+//     utk -operation 'sign:1=/opt/vendor/sign-tool --key k.pem' \
+//       winterfell.rom sign Shell save winterfell2.rom
+//
+//     # Remove a file from the flash chip, writing back the changed regions:
+//     utk -flashrom internal remove Shell flash
+//
+//     # Serve the operations over HTTP, on images uploaded to /images:
+//     utk serve localhost:8080
+//
+//     # Fail rather than save if an operation changes the descriptor or ME:
+//     utk -protect ifd,ME winterfell.rom remove Shell save winterfell2.rom
+//
+//     # Show what removing a file would change, without saving anything:
+//     utk -dry-run winterfell.rom remove Shell save winterfell2.rom
+//
 //     # Remove two files by their GUID and replace shell with Linux:
 //     utk winterfell.rom \
 //       remove 12345678-9abc-def0-1234-567890abcdef \
@@ -31,13 +73,76 @@
 //       save winterfell2.rom
 //
 // Operations:
+//     `-script FILE`: Run the operations of a script, a YAML or JSON file
+//                     with a list `ops` of operations, each a string or a
+//                     list of arguments, and a map `vars` of variables the
+//                     arguments may use as $name or ${name}.
 //     `json`: Dump the entire parsed image (excluding binary data) as JSON to
 //             stdout.
+//     `json_opts OPTS`: Like `json`, with comma separated options: `bodies`
+//                       includes binary data as base64, `sorted` sorts keys,
+//                       `omit=TYPE` leaves out nodes of a type and `depth=N`
+//                       limits the levels written.
 //     `table`: Dump GUIDs and sizes to a compact table. This is only for human
 //              consumption and the format may change without notice.
+//     `table_opts OPTS`: Like `table`, with comma separated options:
+//                        `col=COLUMN` selects columns (depth, node, name,
+//                        type, offset, size, ui, compression, checksum),
+//                        `sort=[-]COLUMN` sorts rows, `depth=N` limits the
+//                        levels, and `csv` or `tsv` write separated values.
 //     `find (GUID|NAME)`: Dump the JSON of one or more files. The file is
 //                         found by a regex match to its GUID or name in the UI
 //                         section.
+//     `body (GUID|NAME|QUERY) FILE`: Write the body of a file, or of any node
+//                                   a query selects, to FILE or `-` for
+//                                   stdout. `body_decompressed` decodes
+//                                   compressed sections.
+//     `du`: Print the size, uncompressed size and percentage of the parent of
+//           every node, largest first. `du_depth N` stops at depth N.
+//     `stats`: Count and size files and sections by type, compression and
+//              GUID prefix, and sum the free space of volumes.
+//     `check_layout`: Cross-check the regions of the flash descriptor, the
+//                     fmap areas, the firmware volumes and the FIT entries
+//                     against each other and the image size, printing
+//                     overlaps and dangling pointers, and fail if any.
+//     `executables`: Dump the GUID, UI name, file type, SHA-256 and build
+//                    metadata of every PE32 and TE section as JSON.
+//     `ucode`: Print the CPUID, platform IDs, revision, date and checksum of
+//              the Intel microcode patches of the image and its FIT.
+//              `ucode_extract DIR` also extracts them to DIR.
+//     `amd_ucode`: Print the processor revision, ID and date of the AMD
+//                  microcode patches of the BIOS directories.
+//                  `amd_ucode_replace FILE` replaces those of the processor
+//                  revision of the patch FILE, alone or in containers.
+//     `amd_versions`: Print the AGESA version and the versions of the PSP
+//                     bootloaders, SMU firmware and ABLs of an AMD image.
+//     `apcb`: Print the groups and tokens of the APCBs of an AMD image.
+//             `apcb_set TOKEN VALUE` sets the token, named or by its
+//             hexadecimal ID, and fixes the APCB checksums.
+//     `soft_straps`: Print the PCH and processor soft straps of the flash
+//                    descriptor, decoding the fields known for its
+//                    platform, like the ME disable and HAP bits.
+//     `me_info`: Print whether the ME region holds client (CSME) or server
+//                (SPS) firmware, its version and the versions of its
+//                operational and recovery partitions.
+//     `apple_info`: Print the model and version of an Apple image, from its
+//                   ROM version section or BIOS ID, and the variables of
+//                   its VSS, Fsys and Gaid stores with bad CRC32s.
+//     `txt`: Print the TXT policy record of the FIT and the date, versions
+//            and supported chipsets of the BIOS and SINIT ACMs.
+//            `txt_sinit_replace FILE` replaces the SINIT ACMs with FILE and
+//            updates the FIT entries pointing to them.
+//     `bootguard_audit`: Print the Boot Guard profile the image boots with,
+//                        the KM public key hash and KM ID the fuses must
+//                        hold, and fail on mismatched key hashes,
+//                        signatures or IBB digests, or on reset vector,
+//                        FIT or volumes left out of the IBB.
+//     `bootguard_sign BPM_KEY`: Update the IBB digests of the boot policy
+//                               manifest after changes to the IBB and sign it
+//                               with the PEM private key BPM_KEY, which the key
+//                               manifest trusts. `bootguard_sign_km KM_KEY
+//                               BPM_KEY` also makes the key manifest trust
+//                               BPM_KEY and signs it with KM_KEY.
 //     `remove (GUID|NAME)`: Remove the first file which matches the given GUID
 //                           or NAME. The same matching rules and exit status
 //                           are used as `find`.
@@ -45,19 +150,63 @@
 //                                 given GUID or NAME with the contents of
 //                                 FILE. The same matching rules and exit
 //                                 status are used as `find`.
+//     `dump_pe (GUID|NAME) FILE`: Write the PE32 or TE section of the file
+//                                 which matches to FILE as a PE32 image.
+//                                 `replace_pe32` converts it back to TE for
+//                                 files with a TE section.
+//     `dump_fv (GUID|NAME) FILE`: Write the firmware volume which matches,
+//                                 or the first one nested in the file which
+//                                 matches, to FILE as a standalone volume.
+//     `insert (fv|compressed_fv) FILE WHERE (GUID|NAME) SIZE`: Wrap the
+//         volume in FILE in a new FIRMWARE_VOLUME_IMAGE file, LZMA
+//         compressed for `compressed_fv`, and insert it like `insert file`.
+//     `set_fv_name GUID NAME`: Set the name GUID of the volumes which match
+//                              GUID, adding an extended header to volumes
+//                              without one. The entries of extended headers,
+//                              like OEM file types, can be edited in JSON.
+//     `set_ui (GUID|NAME) NAME`: Set the name in the UI section of the files
+//                                which match the given GUID or NAME.
+//     `set_version (GUID|NAME) BUILD STRING`: Set the build number and string
+//                                             of the version section of the
+//                                             files which match.
+//     `sync_backup`: Copy the volumes the BIOS region boots from over their
+//                    backup copy on dual BIOS images, shown as a `BIOS
+//                    Backup` node, after edits.
 //     `save FILE`: Save the current state of the image to the give file.
 //                  Remember that operations are applied left-to-right, so only
 //                  the operations to the left are included in the new image.
-//     `extract DIR`: Extract the BIOS to the given directory. Remember that
+//     `attest FILE`: Write the SHA256 of every region, volume, file and
+//                    section to the manifest FILE. `attest_signed FILE KEY`
+//                    signs it with an Ed25519 private key in PEM.
+//     `verify FILE`: List the nodes which changed, were added or removed
+//                    since the manifest FILE of attest, and fail if any.
+//                    `verify_signed FILE KEY` checks its signature first.
+//     `lvfs METADATA OUT`: Package the image as an update of LVFS described
+//                          by the JSON file METADATA, as a cabinet if OUT
+//                          ends in .cab, else as a directory.
+//     `flash`: With -flashrom, write the regions of the flash descriptor or
+//              fmap of the chip which differ from the image to the chip.
+//     `extract DIR`: Extract the BIOS to the given directory, or to a single
+//                    archive if DIR ends in .tar or .zip. Remember that
 //                    operations are applied left-to-right, so only the
 //                    operations to the left are included in the new image.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/flashrom"
+	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/log"
 	"github.com/linuxboot/fiano/pkg/uefi"
 	"github.com/linuxboot/fiano/pkg/utk"
@@ -66,6 +215,77 @@ import (
 
 type config struct {
 	ErasePolarity *byte
+	Format        string
+	DryRun        bool
+	Flashrom      string
+	Interactive   bool
+	JSONErrors    bool
+	Timeout       time.Duration
+	ParseOptions  uefi.ParseOptions
+	Protect       visitors.Policy
+	// AssembleOptions are set on the context of the operations.
+	AssembleOptions visitors.AssembleOptions
+}
+
+// externalFlag collects the NAME=COMMAND values of -compressor or
+// -decompressor into External compressors by GUID.
+type externalFlag struct {
+	externals map[guid.GUID]*compression.External
+	decoder   bool
+}
+
+func (f *externalFlag) String() string { return "" }
+
+func (f *externalFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("want NAME=COMMAND, got %q", v)
+	}
+	g, err := compression.GUIDFromName(kv[0])
+	if err != nil {
+		return err
+	}
+	cmd, err := compression.ParseExternalCommand(kv[1])
+	if err != nil {
+		return err
+	}
+	e, ok := f.externals[g]
+	if !ok {
+		e = &compression.External{}
+		f.externals[g] = e
+	}
+	if f.decoder {
+		e.Decoder = cmd
+	} else {
+		e.Encoder = cmd
+	}
+	return nil
+}
+
+// operationFlag registers the NAME[:N]=COMMAND values of -operation as
+// External operations taking N arguments.
+type operationFlag struct{}
+
+func (operationFlag) String() string { return "" }
+
+func (operationFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("want NAME[:N]=COMMAND, got %q", v)
+	}
+	name, numArgs := kv[0], 0
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		n, err := strconv.Atoi(name[i+1:])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of arguments in %q", name)
+		}
+		name, numArgs = name[:i], n
+	}
+	cmd, err := compression.ParseExternalCommand(kv[1])
+	if err != nil {
+		return err
+	}
+	return visitors.RegisterExternal(name, numArgs, cmd)
 }
 
 func parseArguments() (config, []string, error) {
@@ -75,12 +295,106 @@ func parseArguments() (config, []string, error) {
 		fmt.Fprintf(flag.CommandLine.Output(), "\nOperations:\n%s", visitors.ListCLI())
 	}
 	erasePolarityFlag := flag.String("erase-polarity", "", "set erase polarity; possible values: '', '0x00', '0xFF'")
+	formatFlag := flag.String("format", "", "write reports as json, yaml or toml; '' for the usual output of each operation")
+	interactiveFlag := flag.Bool("i", false, "run a shell on the image, to navigate it and apply operations without parsing it again")
+	jsonErrorsFlag := flag.Bool("json-errors", false, "write warnings, validation findings and errors as JSON lines with a level, code, node path, offset and message on stderr")
+	flashromFlag := flag.String("flashrom", "", "read the image from the flash chip with flashrom and this programmer, like internal, instead of a file; the flash operation writes the changed regions back")
+	dryRunFlag := flag.Bool("dry-run", false, "print what the operations change, as text or in the -format, without writing any file")
+	timeoutFlag := flag.Duration("timeout", 0, "stop parsing and operations after this long, like 10m; 0 for no limit")
+	maxDepthFlag := flag.Int("max-depth", uefi.DefaultMaxDepth, "fail on images nesting volumes, files and sections deeper; 0 for no limit")
+	maxDecompressedFlag := flag.String("max-decompressed", "", "fail on images decompressing to more in all, like 256MiB; '' for no limit")
+	maxNodeSizeFlag := flag.String("max-node-size", "", "fail on images with a section decompressing to more, like 64MiB; '' for no limit")
+	deterministicFlag := flag.Bool("deterministic", false, "assemble identical trees to identical images on any system, using the internal compressors")
+	padFilesFlag := flag.String("pad-files", visitors.PadPreserve, "on save, keep pad files as they are (preserve), erase them (erase), or merge them and drop the ones before the free space (minimize)")
+	strictFFS2Flag := flag.Bool("strict-ffs2", false, "fail to save files of 16MiB or more into FFSv2 volumes, rather than changing them to FFSv3")
+	rebaseFlag := flag.Bool("rebase", false, "on save, rebase the SEC and PEI executables running in place from flash whose file or volume moved")
+	externals := map[guid.GUID]*compression.External{}
+	flag.Var(&externalFlag{externals: externals}, "compressor",
+		"compress LZMA, LZMAX86, ZLIB, Tiano or GUID sections with a command, NAME=COMMAND, where {in} and {out} are the input and output files, else stdin and stdout; repeatable")
+	flag.Var(&externalFlag{externals: externals, decoder: true}, "decompressor",
+		"like -compressor, to decompress; the internal decompressor is used without it")
+	flag.Var(operationFlag{}, "operation",
+		"add an operation running a command, NAME[:N]=COMMAND, which gets the N arguments of the operation, the JSON tree with bodies on stdin and the tree extracted to $"+visitors.ExternalDirEnv+", read back if changed; repeatable")
+	parseTypesFlag := flag.String("parse-types", "", "parse the sections of files of these comma separated types only, like PEIM,DRIVER; '' for the default types")
+	opaqueTypesFlag := flag.String("opaque-types", "", "do not parse the sections of files of these comma separated types, like PEIM")
+	protectFlag := flag.String("protect", "", "fail the operations which change these comma separated nodes: ifd for the descriptor, regions like ME or GbE, and files as in find")
 	flag.Parse()
-	if len(flag.Args()) == 0 || flag.Args()[0] == "help" {
+	if len(flag.Args()) == 0 && *flashromFlag == "" || len(flag.Args()) > 0 && flag.Args()[0] == "help" {
 		flag.Usage()
 	}
 
 	var cfg config
+	cfg.AssembleOptions.Deterministic = *deterministicFlag
+	cfg.AssembleOptions.StrictFFS2 = *strictFFS2Flag
+	cfg.AssembleOptions.Rebase = *rebaseFlag
+	if err := visitors.CheckFormat(*formatFlag); err != nil {
+		return config{}, nil, fmt.Errorf("unable to parse -format: %w", err)
+	}
+	cfg.Format = *formatFlag
+	if err := visitors.CheckPadPolicy(*padFilesFlag); err != nil {
+		return config{}, nil, fmt.Errorf("unable to parse -pad-files: %w", err)
+	}
+	cfg.AssembleOptions.PadPolicy = *padFilesFlag
+	cfg.DryRun = *dryRunFlag
+	cfg.Flashrom = *flashromFlag
+	cfg.Interactive = *interactiveFlag
+	cfg.JSONErrors = *jsonErrorsFlag
+	cfg.Timeout = *timeoutFlag
+	cfg.ParseOptions.Limits = &uefi.ParseLimits{MaxDepth: *maxDepthFlag}
+	cfg.ParseOptions.Externals = externals
+	cfg.AssembleOptions.Externals = externals
+	if cfg.AssembleOptions.Deterministic {
+		for _, e := range externals {
+			if e.Encoder != nil {
+				return config{}, nil, fmt.Errorf("-deterministic does not use the commands of -compressor")
+			}
+		}
+	}
+	for _, l := range []struct {
+		flag  string
+		value *string
+		limit *uint64
+	}{
+		{"max-decompressed", maxDecompressedFlag, &cfg.ParseOptions.Limits.MaxDecompressedSize},
+		{"max-node-size", maxNodeSizeFlag, &cfg.ParseOptions.Limits.MaxNodeSize},
+	} {
+		if *l.value == "" {
+			continue
+		}
+		n, err := humanize.ParseBytes(*l.value)
+		if err != nil {
+			return config{}, nil, fmt.Errorf("unable to parse -%s '%s': %w", l.flag, *l.value, err)
+		}
+		*l.limit = n
+	}
+
+	if *parseTypesFlag != "" || *opaqueTypesFlag != "" {
+		supported := uefi.DefaultSupportedFiles()
+		if *parseTypesFlag != "" {
+			types, err := uefi.ParseFileTypes(*parseTypesFlag)
+			if err != nil {
+				return config{}, nil, fmt.Errorf("unable to parse -parse-types '%s': %w", *parseTypesFlag, err)
+			}
+			supported = map[uefi.FVFileType]bool{}
+			for _, t := range types {
+				supported[t] = true
+			}
+		}
+		types, err := uefi.ParseFileTypes(*opaqueTypesFlag)
+		if err != nil {
+			return config{}, nil, fmt.Errorf("unable to parse -opaque-types '%s': %w", *opaqueTypesFlag, err)
+		}
+		for _, t := range types {
+			delete(supported, t)
+		}
+		cfg.ParseOptions.SupportedFiles = supported
+	}
+
+	protect, err := visitors.ParsePolicy(*protectFlag)
+	if err != nil {
+		return config{}, nil, fmt.Errorf("unable to parse -protect: %w", err)
+	}
+	cfg.Protect = protect
 
 	if *erasePolarityFlag != "" {
 		erasePolarity, err := strconv.ParseUint(*erasePolarityFlag, 0, 8)
@@ -105,7 +419,100 @@ func main() {
 		}
 	}
 
-	if err := utk.Run(args...); err != nil {
+	// Interrupting stops parsing or operations at the next node.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	ctx = uefi.WithParseOptions(ctx, &cfg.ParseOptions)
+	ctx = visitors.WithAssembleOptions(ctx, &cfg.AssembleOptions)
+	ctx = visitors.WithOutputFormat(ctx, cfg.Format)
+	if len(cfg.Protect) > 0 {
+		ctx = visitors.WithMiddleware(ctx, visitors.Protect(cfg.Protect))
+	}
+	if cfg.JSONErrors {
+		ctx = visitors.WithDiagnostics(ctx, os.Stderr)
+		ctx = log.WithLogger(ctx, log.New(os.Stderr, log.Options{JSON: true}).With(log.Fields{"code": "log"}))
+	}
+
+	if cfg.Flashrom != "" {
+		fr := &flashrom.Flashrom{Programmer: cfg.Flashrom, Stderr: os.Stderr}
+		ctx = flashrom.WithFlashrom(ctx, fr)
+		path, err := readFlash(ctx, fr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer os.Remove(path)
+		args = append([]string{path}, args...)
+	}
+
+	run := func(args ...string) error { return utk.RunContext(ctx, args...) }
+	if cfg.DryRun {
+		run = func(args ...string) error { return utk.DryRunContext(ctx, os.Stdout, args...) }
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		run = func(args ...string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("serve takes an address, like localhost:8080")
+			}
+			return utk.Serve(ctx, args[1])
+		}
+	}
+	if cfg.Interactive {
+		run = func(args ...string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("-i takes an image and no operations")
+			}
+			return utk.InteractiveContext(ctx, args[0], os.Stdin, os.Stdout)
+		}
+	}
+	if err := run(args...); err != nil {
+		if cfg.JSONErrors {
+			visitors.WriteDiagnostic(os.Stderr, &visitors.Diagnostic{Level: "error", Code: errorCode(err), Msg: err.Error()})
+			os.Exit(1)
+		}
 		log.Fatalf("%v", err)
 	}
 }
+
+// readFlash reads the flash chip into a temporary file and returns its path.
+func readFlash(ctx context.Context, fr *flashrom.Flashrom) (string, error) {
+	image, err := fr.Read(ctx)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "utk-flash-*.rom")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(image); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// errorCode returns the code of the Diagnostic of an error of utk.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, visitors.ErrValidation):
+		return "utk.invalid"
+	case errors.Is(err, visitors.ErrDrift):
+		return "utk.drift"
+	case errors.Is(err, visitors.ErrBadSignature):
+		return "utk.bad_signature"
+	case errors.Is(err, visitors.ErrProtected):
+		return "utk.protected"
+	case errors.Is(err, uefi.ErrParseLimit):
+		return "utk.parse_limit"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "utk.timeout"
+	case errors.Is(err, context.Canceled):
+		return "utk.canceled"
+	}
+	return "utk.error"
+}
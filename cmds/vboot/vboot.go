@@ -0,0 +1,82 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// vboot verifies and re-signs the RW firmware slots of vboot images.
+//
+// Synopsis:
+//
+//	vboot <firmware-file> verify
+//	vboot <firmware-file> resign <slot> <data-key> [keyblock]
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/vboot"
+	flag "github.com/spf13/pflag"
+)
+
+const usage = "Usage: vboot <firmware-file> <verify,resign <slot> <data-key> [keyblock]>"
+
+func main() {
+	flag.Parse()
+
+	a := flag.Args()
+	if len(a) < 2 {
+		log.Fatal(usage)
+	}
+	b, err := os.ReadFile(a[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	i, err := vboot.NewImage(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch a[1] {
+	case "verify":
+		fmt.Printf("HWID: %s\n", i.GBB.HWID)
+		failed := false
+		for _, s := range vboot.Slots {
+			if err := i.Verify(s); err != nil {
+				log.Printf("slot %s: %v", s, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("slot %s: ok\n", s)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "resign":
+		if len(a) != 4 && len(a) != 5 {
+			log.Fatal(usage)
+		}
+		kb, err := os.ReadFile(a[3])
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := vboot.ParsePrivateKey(kb)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var keyblock []byte
+		if len(a) == 5 {
+			if keyblock, err = os.ReadFile(a[4]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := i.Resign(a[2], key, keyblock); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(a[0], i.Data, 0666); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal(usage)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 )
 
@@ -58,6 +59,21 @@ func GetTokenIDString(tokenID TokenID) string {
 	return ""
 }
 
+// ParseTokenID returns the token ID of its literal representation, as
+// returned by GetTokenIDString, or of its hexadecimal value
+func ParseTokenID(s string) (TokenID, error) {
+	for _, id := range []TokenID{TokenIDPSPMeasureConfig, TokenIDPSPEnableDebugMode, TokenIDPSPErrorDisplay, TokenIDPSPStopOnError} {
+		if GetTokenIDString(id) == s {
+			return id, nil
+		}
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown token '%s', want a known token name or a hexadecimal ID", s)
+	}
+	return TokenID(id), nil
+}
+
 // See: AgesaPkg/Addendum/Apcb/Inc/CommonV3/ApcbV3Priority.h
 
 // An APCB token may be saved in different instances or purpose levels and can have instances of the token at
@@ -181,8 +197,16 @@ func ParseAPCBBinaryTokens(apcbBinary []byte) ([]Token, error) {
 	return result, err
 }
 
-// UpsertToken inserts a new token or updates current into apcb binary
+// UpsertToken inserts a new token or updates current into apcb binary, and
+// updates its checksum
 func UpsertToken(tokenID TokenID, priorityMask PriorityMask, boardMask uint16, newValue interface{}, apcbBinary []byte) error {
+	if err := upsertToken(tokenID, priorityMask, boardMask, newValue, apcbBinary); err != nil {
+		return err
+	}
+	return UpdateChecksum(apcbBinary)
+}
+
+func upsertToken(tokenID TokenID, priorityMask PriorityMask, boardMask uint16, newValue interface{}, apcbBinary []byte) error {
 	typeID, numValue, err := parseValue(newValue)
 	if err != nil {
 		return err
@@ -338,6 +362,39 @@ func UpsertToken(tokenID TokenID, priorityMask PriorityMask, boardMask uint16, n
 	return nil
 }
 
+// checkSumByteOffset is the offset of CheckSumByte in headerV2
+const checkSumByteOffset = 16
+
+// ChecksumValid returns whether the bytes of the APCB binary sum to 0, as
+// AGESA requires
+func ChecksumValid(apcbBinary []byte) (bool, error) {
+	header, _, err := parseAPCBHeader(apcbBinary)
+	if err != nil {
+		return false, err
+	}
+	var sum uint8
+	for _, b := range apcbBinary[:header.V2Header.SizeOfAPCB] {
+		sum += b
+	}
+	return sum == 0, nil
+}
+
+// UpdateChecksum sets the checksum byte of the APCB binary so that its bytes
+// sum to 0
+func UpdateChecksum(apcbBinary []byte) error {
+	header, _, err := parseAPCBHeader(apcbBinary)
+	if err != nil {
+		return err
+	}
+	apcbBinary[checkSumByteOffset] = 0
+	var sum uint8
+	for _, b := range apcbBinary[:header.V2Header.SizeOfAPCB] {
+		sum += b
+	}
+	apcbBinary[checkSumByteOffset] = -sum
+	return nil
+}
+
 func constructNewTypeForToken(
 	tokenID TokenID,
 	priorityMask PriorityMask,
@@ -101,6 +101,43 @@ func TestUpsertToken(t *testing.T) {
 	})
 }
 
+func TestUpsertTokenChecksum(t *testing.T) {
+	apcbBinary, err := getFile("apcb_binary.xz")
+	require.NoError(t, err)
+
+	valid, err := ChecksumValid(apcbBinary)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	require.NoError(t, UpsertToken(0x3E7D5274, 0xff, 0xffff, uint32(1234), apcbBinary))
+	valid, err = ChecksumValid(apcbBinary)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	require.NoError(t, UpsertToken(0xFFFFAAAA, 0xff, 0xffff, uint8(12), apcbBinary))
+	valid, err = ChecksumValid(apcbBinary)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	apcbBinary[checkSumByteOffset]++
+	valid, err = ChecksumValid(apcbBinary)
+	require.NoError(t, err)
+	require.False(t, valid)
+}
+
+func TestParseTokenID(t *testing.T) {
+	id, err := ParseTokenID("APCB_TOKEN_UID_PSP_STOP_ON_ERROR")
+	require.NoError(t, err)
+	require.Equal(t, TokenIDPSPStopOnError, id)
+
+	id, err = ParseTokenID("0x3E7D5274")
+	require.NoError(t, err)
+	require.Equal(t, TokenID(0x3E7D5274), id)
+
+	_, err = ParseTokenID("APCB_TOKEN_UID_UNKNOWN")
+	require.Error(t, err)
+}
+
 func findToken(tokenID TokenID, tokens []Token) *Token {
 	for _, token := range tokens {
 		if token.ID == tokenID {
@@ -0,0 +1,80 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apcb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Group describes a group of an APCB binary, such as the tokens group or
+// the memory group holding SPD and board settings.
+type Group struct {
+	Signature string
+	ID        uint16
+	Version   uint16
+	Size      uint32
+	Types     []Type
+}
+
+// Type describes a type of an APCB group.
+type Type struct {
+	ID           uint16
+	InstanceID   uint16
+	Size         uint16
+	PriorityMask PriorityMask
+	BoardMask    uint16
+}
+
+// ParseAPCBBinaryGroups returns the groups of the APCB binary, with their
+// types.
+func ParseAPCBBinaryGroups(apcbBinary []byte) ([]Group, error) {
+	_, remainBytes, err := parseAPCBHeader(apcbBinary)
+	if err != nil {
+		return nil, err
+	}
+	groupHeaderSize := uint32(binary.Size(groupHeader{}))
+	var result []Group
+	for len(remainBytes) > 0 {
+		var gh groupHeader
+		if err := binary.Read(bytes.NewReader(remainBytes), binary.LittleEndian, &gh); err != nil {
+			return nil, fmt.Errorf("failed to read group header: '%v'", err)
+		}
+		if gh.SizeOfGroup < groupHeaderSize {
+			return nil, fmt.Errorf("size of group is less than size of group header: %d < %d", gh.SizeOfGroup, groupHeaderSize)
+		}
+		if uint32(gh.SizeOfHeader) > gh.SizeOfGroup {
+			return nil, fmt.Errorf("size of group header exceeds the size of group: %d > %d", gh.SizeOfHeader, gh.SizeOfGroup)
+		}
+		if gh.SizeOfGroup > uint32(len(remainBytes)) {
+			return nil, fmt.Errorf("size of group exceeds the length of remaining data '%d' > '%d'", gh.SizeOfGroup, len(remainBytes))
+		}
+		signature := make([]byte, 4)
+		binary.LittleEndian.PutUint32(signature, uint32(gh.Signature))
+		group := Group{
+			Signature: string(signature),
+			ID:        uint16(gh.GroupID),
+			Version:   gh.Version,
+			Size:      gh.SizeOfGroup,
+		}
+		err := iterateTypes(remainBytes[gh.SizeOfHeader:gh.SizeOfGroup], func(th typeHeaderV3, offset uint32) error {
+			group.Types = append(group.Types, Type{
+				ID:           uint16(th.TypeID),
+				InstanceID:   th.InstanceID,
+				Size:         th.SizeOfType,
+				PriorityMask: th.PriorityMask,
+				BoardMask:    th.BoardMask,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, group)
+		remainBytes = remainBytes[gh.SizeOfGroup:]
+	}
+	return result, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apcb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsingGroups(t *testing.T) {
+	apcbBinary, err := getFile("apcb_binary.xz")
+	require.NoError(t, err)
+
+	groups, err := ParseAPCBBinaryGroups(apcbBinary)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+
+	require.Equal(t, "DFG ", groups[0].Signature)
+	require.Equal(t, uint16(0x1703), groups[0].ID)
+	require.Len(t, groups[0].Types, 1)
+	require.Equal(t, uint16(0xcc), groups[0].Types[0].ID)
+
+	require.Equal(t, "TOKN", groups[1].Signature)
+	require.Equal(t, uint16(tokensGroupID), groups[1].ID)
+	require.Equal(t, uint32(464), groups[1].Size)
+	require.Len(t, groups[1].Types, 8)
+	require.Equal(t, Type{ID: 1, InstanceID: 1, Size: 256, PriorityMask: CreatePriorityMask(PriorityLevelMedium), BoardMask: 0xffff}, groups[1].Types[3])
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Entry types of the PSP directory holding versioned binaries.
+const (
+	// PSPRecoveryBootloaderEntry denotes the recovery PSP bootloader
+	PSPRecoveryBootloaderEntry PSPDirectoryTableEntryType = 0x03
+	// SMUFirmwareEntry denotes the SMU firmware
+	SMUFirmwareEntry PSPDirectoryTableEntryType = 0x08
+	// SMUFirmware2Entry denotes the second part of the SMU firmware
+	SMUFirmware2Entry PSPDirectoryTableEntryType = 0x12
+	// ABL0Entry denotes the first AGESA bootloader binary, up to ABL7Entry
+	ABL0Entry PSPDirectoryTableEntryType = 0x30
+	// ABL7Entry denotes the last AGESA bootloader binary
+	ABL7Entry PSPDirectoryTableEntryType = 0x37
+)
+
+// AGESAVersionSignature precedes the AGESA version string in the BIOS.
+var AGESAVersionSignature = []byte("AGESA!V")
+
+// BinaryVersion is the version of a binary of a PSP directory.
+type BinaryVersion struct {
+	// Level is the level of the PSP directory, 1 or 2.
+	Level   uint
+	Type    PSPDirectoryTableEntryType
+	Name    string
+	Offset  uint64
+	Size    uint32
+	Version string
+}
+
+// binaryName returns the name of the versioned binaries, or "" for the
+// other entry types.
+func binaryName(t PSPDirectoryTableEntryType) string {
+	switch {
+	case t == PSPBootloaderFirmwareEntry:
+		return "PSP bootloader"
+	case t == PSPRecoveryBootloaderEntry:
+		return "PSP recovery bootloader"
+	case t == SMUFirmwareEntry:
+		return "SMU firmware"
+	case t == SMUFirmware2Entry:
+		return "SMU firmware 2"
+	case t >= ABL0Entry && t <= ABL7Entry:
+		return fmt.Sprintf("ABL%d", t-ABL0Entry)
+	}
+	return ""
+}
+
+// entryOffset returns the offset in the image of the address of a
+// directory entry, which is either an offset or a physical address.
+func entryOffset(firmware Firmware, addr uint64, size uint32) (uint64, bool) {
+	image := firmware.ImageBytes()
+	if addr >= uint64(len(image)) {
+		addr = firmware.PhysAddrToOffset(addr)
+	}
+	if addr > uint64(len(image)) || uint64(size) > uint64(len(image))-addr {
+		return 0, false
+	}
+	return addr, true
+}
+
+// BinaryVersions returns the versions of the PSP bootloaders, SMU firmware
+// and AGESA bootloaders of the PSP directories, read from their PSP
+// header.
+func (a *AMDFirmware) BinaryVersions() []BinaryVersion {
+	image := a.firmware.ImageBytes()
+	var versions []BinaryVersion
+	for i, table := range []*PSPDirectoryTable{a.pspFirmware.PSPDirectoryLevel1, a.pspFirmware.PSPDirectoryLevel2} {
+		if table == nil {
+			continue
+		}
+		for _, e := range table.Entries {
+			name := binaryName(e.Type)
+			if name == "" || uint64(e.Size) < uint64(binary.Size(PSPHeader{})) {
+				continue
+			}
+			off, ok := entryOffset(a.firmware, e.LocationOrValue, e.Size)
+			if !ok {
+				continue
+			}
+			h, err := ParsePSPHeader(bytes.NewReader(image[off:]))
+			if err != nil {
+				continue
+			}
+			v := BinaryVersion{Level: uint(i + 1), Type: e.Type, Name: name, Offset: off, Size: e.Size, Version: h.Version.String()}
+			if e.Type == SMUFirmwareEntry || e.Type == SMUFirmware2Entry {
+				// SMU versions are decimal, without the first byte.
+				v.Version = fmt.Sprintf("%d.%d.%d", h.Version[2], h.Version[1], h.Version[0])
+			}
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// FindAGESAVersion returns the AGESA version string of the image, like
+// "CezannePI-FP6 1.0.0.8", which follows AGESAVersionSignature, its
+// number and a NUL byte.
+func FindAGESAVersion(image []byte) (string, bool) {
+	for {
+		i := bytes.Index(image, AGESAVersionSignature)
+		if i < 0 {
+			return "", false
+		}
+		image = image[i+len(AGESAVersionSignature):]
+		if len(image) < 2 || image[0] < '0' || image[0] > '9' || image[1] != 0 {
+			continue
+		}
+		s := image[2:]
+		n := 0
+		for n < len(s) && n < 64 && s[n] >= ' ' && s[n] < 0x7f {
+			n++
+		}
+		if n > 0 && n < len(s) && s[n] == 0 {
+			return string(s[:n]), true
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// versionsImage returns a 512K image with a PSP directory pointing to a
+// PSP bootloader, an SMU firmware by physical address and an ABL.
+func versionsImage() []byte {
+	image := bytes.Repeat([]byte{0xff}, 0x80000)
+	efs := image[0x20000:0x20040]
+	copy(efs, make([]byte, len(efs)))
+	binary.LittleEndian.PutUint32(efs, EmbeddedFirmwareStructureSignature)
+	binary.LittleEndian.PutUint32(efs[0x14:], 0x21000)
+
+	dir := image[0x21000:]
+	binary.LittleEndian.PutUint32(dir, PSPDirectoryTableCookie)
+	binary.LittleEndian.PutUint32(dir[4:], 0)
+	binary.LittleEndian.PutUint32(dir[8:], 3)
+	binary.LittleEndian.PutUint32(dir[12:], 0)
+	for i, e := range []struct {
+		t       PSPDirectoryTableEntryType
+		loc     uint64
+		version []byte
+	}{
+		{PSPBootloaderFirmwareEntry, 0x22000, []byte{0x57, 0x00, 0x13, 0x00}},
+		{SMUFirmwareEntry, 0xfffa2100, []byte{0x00, 0x41, 0x2e, 0x00}},
+		{ABL0Entry + 1, 0x22200, []byte{0x24, 0x06, 0x21, 0x00}},
+	} {
+		entry := dir[16+16*i:]
+		copy(entry, []byte{byte(e.t), 0, 0, 0})
+		binary.LittleEndian.PutUint32(entry[4:], 0x100)
+		binary.LittleEndian.PutUint64(entry[8:], e.loc)
+		off := 0x22000 + 0x100*i
+		copy(image[off:off+0x100], make([]byte, 0x100))
+		copy(image[off+0x60:], e.version)
+	}
+	copy(image[0x30000:], "AGESA!V9\x00CezannePI-FP6 1.0.0.8\x00")
+	return image
+}
+
+func TestBinaryVersions(t *testing.T) {
+	amd, err := NewAMDFirmware(FirmwareImage(versionsImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BinaryVersion{
+		{Level: 1, Type: PSPBootloaderFirmwareEntry, Name: "PSP bootloader", Offset: 0x22000, Size: 0x100, Version: "0.13.0.57"},
+		{Level: 1, Type: SMUFirmwareEntry, Name: "SMU firmware", Offset: 0x22100, Size: 0x100, Version: "46.65.0"},
+		{Level: 1, Type: ABL0Entry + 1, Name: "ABL1", Offset: 0x22200, Size: 0x100, Version: "0.21.6.24"},
+	}
+	if got := amd.BinaryVersions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got versions %+v, want %+v", got, want)
+	}
+}
+
+func TestFindAGESAVersion(t *testing.T) {
+	for _, tc := range []struct {
+		image   string
+		version string
+		ok      bool
+	}{
+		{"\xffAGESA!V9\x00CezannePI-FP6 1.0.0.8\x00\xff", "CezannePI-FP6 1.0.0.8", true},
+		// A reference to the signature, then the string.
+		{"AGESA!V\x00AGESA!V9\x00ComboAM4v2PI 1.2.0.7\x00", "ComboAM4v2PI 1.2.0.7", true},
+		{"AGESA!V9\x00unterminated", "", false},
+		{"AGESA!V9\x00\x00", "", false},
+		{"", "", false},
+	} {
+		version, ok := FindAGESAVersion([]byte(tc.image))
+		if version != tc.version || ok != tc.ok {
+			t.Errorf("FindAGESAVersion(%q) = %q, %v, want %q, %v", tc.image, version, ok, tc.version, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package microcode parses AMD microcode patches and the containers
+// bundling the patches of a processor family, like those of linux-firmware
+// and of the microcode entries of the BIOS directory of AGESA images.
+package microcode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ContainerMagic starts a microcode container.
+const ContainerMagic = 0x00414d44 // "DMA\0"
+
+// Section types of a container.
+const (
+	EquivalenceTableType = 0
+	PatchType            = 1
+)
+
+// HeaderSize is the size of Header.
+const HeaderSize = 64
+
+// Header is the header of a microcode patch.
+type Header struct {
+	// DataCode is the date of the patch, as 0xYYYYMMDD in BCD.
+	DataCode          uint32
+	PatchID           uint32
+	PatchDataID       uint16
+	PatchDataLen      uint8
+	InitFlag          uint8
+	PatchDataChecksum uint32
+	NbDevID           uint32
+	SbDevID           uint32
+	ProcessorRevID    uint16
+	NbRevID           uint8
+	SbRevID           uint8
+	BiosAPIRev        uint8
+	Reserved1         [3]uint8
+	MatchReg          [8]uint32
+}
+
+// Date returns the date of the patch as YYYY-MM-DD.
+func (h *Header) Date() string {
+	return fmt.Sprintf("%04x-%02x-%02x", h.DataCode>>16, (h.DataCode>>8)&0xff, h.DataCode&0xff)
+}
+
+// Patch is a microcode patch.
+type Patch struct {
+	Header
+	// Buf holds the whole patch, header included.
+	Buf []byte `json:"-"`
+}
+
+func (p *Patch) String() string {
+	return fmt.Sprintf("rev=0x%04x, patch=0x%08x, date=%s, size=0x%x",
+		p.ProcessorRevID, p.PatchID, p.Date(), len(p.Buf))
+}
+
+// ParsePatch parses the microcode patch of buf.
+func ParsePatch(buf []byte) (*Patch, error) {
+	if len(buf) < HeaderSize {
+		return nil, fmt.Errorf("patch of %d bytes is smaller than its header", len(buf))
+	}
+	p := &Patch{Buf: buf}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &p.Header); err != nil {
+		return nil, err
+	}
+	if p.ProcessorRevID == 0 {
+		return nil, fmt.Errorf("patch %#x has no processor revision", p.PatchID)
+	}
+	return p, nil
+}
+
+// EquivalenceEntry maps a CPUID to the processor revision of its patches.
+type EquivalenceEntry struct {
+	InstalledCPU       uint32
+	FixedErrataMask    uint32
+	FixedErrataCompare uint32
+	EquivCPU           uint16
+	Reserved           uint16
+}
+
+// Family returns the processor family of the CPUID of the entry.
+func (e *EquivalenceEntry) Family() uint32 {
+	return (e.InstalledCPU>>8)&0xf + (e.InstalledCPU>>20)&0xff
+}
+
+// Container is a microcode container, holding an equivalence table and the
+// patches for the processors of a family.
+type Container struct {
+	EquivalenceTable []EquivalenceEntry
+	Patches          []*Patch
+}
+
+// ParseContainer parses the containers of buf. Several containers, such
+// as those of several families, are merged into one. Erased 0xff bytes
+// after the containers, padding them in flash, are ignored.
+func ParseContainer(buf []byte) (*Container, error) {
+	c := &Container{}
+	for len(buf) > 0 && len(bytes.Trim(buf, "\xff")) > 0 {
+		if len(buf) < 12 || binary.LittleEndian.Uint32(buf) != ContainerMagic {
+			return nil, fmt.Errorf("no microcode container magic")
+		}
+		if t := binary.LittleEndian.Uint32(buf[4:]); t != EquivalenceTableType {
+			return nil, fmt.Errorf("container starts with section type %d, want the equivalence table", t)
+		}
+		size := binary.LittleEndian.Uint32(buf[8:])
+		if uint64(size) > uint64(len(buf)-12) || size%16 != 0 {
+			return nil, fmt.Errorf("invalid equivalence table size %#x", size)
+		}
+		for table := buf[12 : 12+size]; len(table) >= 16; table = table[16:] {
+			var e EquivalenceEntry
+			// Reading from enough bytes cannot fail.
+			_ = binary.Read(bytes.NewReader(table), binary.LittleEndian, &e)
+			if e.InstalledCPU == 0 {
+				break
+			}
+			c.EquivalenceTable = append(c.EquivalenceTable, e)
+		}
+		buf = buf[12+size:]
+		for len(buf) >= 8 && binary.LittleEndian.Uint32(buf) == PatchType {
+			size := binary.LittleEndian.Uint32(buf[4:])
+			if uint64(size) > uint64(len(buf)-8) {
+				return nil, fmt.Errorf("patch of %#x bytes ends past the container", size)
+			}
+			p, err := ParsePatch(buf[8 : 8+size])
+			if err != nil {
+				return nil, err
+			}
+			c.Patches = append(c.Patches, p)
+			buf = buf[8+size:]
+		}
+	}
+	return c, nil
+}
+
+// Bytes returns the container as one equivalence table followed by the
+// patches.
+func (c *Container) Bytes() []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{ContainerMagic, EquivalenceTableType, uint32(16 * (len(c.EquivalenceTable) + 1))})
+	_ = binary.Write(&b, binary.LittleEndian, c.EquivalenceTable)
+	_ = binary.Write(&b, binary.LittleEndian, EquivalenceEntry{})
+	for _, p := range c.Patches {
+		_ = binary.Write(&b, binary.LittleEndian, []uint32{PatchType, uint32(len(p.Buf))})
+		b.Write(p.Buf)
+	}
+	return b.Bytes()
+}
+
+// ProcessorRevID returns the processor revision of the patches of the
+// CPUID, or false if the equivalence table lacks the CPUID.
+func (c *Container) ProcessorRevID(cpuid uint32) (uint16, bool) {
+	for _, e := range c.EquivalenceTable {
+		if e.InstalledCPU == cpuid {
+			return e.EquivCPU, true
+		}
+	}
+	return 0, false
+}
+
+// Patch returns the patch for the processor revision, or nil.
+func (c *Container) Patch(rev uint16) *Patch {
+	for _, p := range c.Patches {
+		if p.ProcessorRevID == rev {
+			return p
+		}
+	}
+	return nil
+}
+
+// Replace replaces the patch of the container for the processor revision
+// of p by p. It returns the replaced patch.
+func (c *Container) Replace(p *Patch) (*Patch, error) {
+	for i, q := range c.Patches {
+		if q.ProcessorRevID == p.ProcessorRevID {
+			c.Patches[i] = p
+			return q, nil
+		}
+	}
+	return nil, fmt.Errorf("no patch for processor revision %#04x in the container", p.ProcessorRevID)
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package microcode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testPatch returns a patch of 0x80 bytes for the processor revision.
+func testPatch(rev uint16, id uint32) []byte {
+	b := make([]byte, 0x80)
+	binary.LittleEndian.PutUint32(b, 0x20211021)
+	binary.LittleEndian.PutUint32(b[4:], id)
+	binary.LittleEndian.PutUint16(b[24:], rev)
+	return b
+}
+
+func testContainer() []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{ContainerMagic, EquivalenceTableType, 48})
+	_ = binary.Write(&b, binary.LittleEndian, []EquivalenceEntry{
+		{InstalledCPU: 0x00a00f11, EquivCPU: 0xa011},
+		{InstalledCPU: 0x00a00f12, EquivCPU: 0xa012},
+		{},
+	})
+	for _, p := range [][]byte{testPatch(0xa011, 0x0a0011d1), testPatch(0xa012, 0x0a001238)} {
+		_ = binary.Write(&b, binary.LittleEndian, []uint32{PatchType, uint32(len(p))})
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+func TestParseContainer(t *testing.T) {
+	buf := testContainer()
+	c, err := ParseContainer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.EquivalenceTable) != 2 || c.EquivalenceTable[1].Family() != 0x19 {
+		t.Errorf("got equivalence table %+v, want two family 19h entries", c.EquivalenceTable)
+	}
+	if len(c.Patches) != 2 {
+		t.Fatalf("got %d patches, want 2", len(c.Patches))
+	}
+	if got, want := c.Patches[0].String(), "rev=0xa011, patch=0x0a0011d1, date=2021-10-21, size=0x80"; got != want {
+		t.Errorf("got patch %q, want %q", got, want)
+	}
+	if rev, ok := c.ProcessorRevID(0x00a00f12); !ok || rev != 0xa012 {
+		t.Errorf("got processor revision %#x, %v for a00f12, want 0xa012", rev, ok)
+	}
+	if !bytes.Equal(c.Bytes(), buf) {
+		t.Errorf("container changed when serialized")
+	}
+	// Families concatenated in one file are merged.
+	c, err = ParseContainer(append(buf, buf...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.EquivalenceTable) != 4 || len(c.Patches) != 4 {
+		t.Errorf("got %d entries and %d patches from two containers, want 4 and 4", len(c.EquivalenceTable), len(c.Patches))
+	}
+}
+
+func TestParseContainerErrors(t *testing.T) {
+	buf := testContainer()
+	for name, b := range map[string][]byte{
+		"magic":     buf[4:],
+		"table":     buf[:40],
+		"truncated": buf[:len(buf)-1],
+		"trailing":  append(append([]byte{}, buf...), 1, 2, 3),
+	} {
+		if _, err := ParseContainer(b); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	c, err := ParseContainer(testContainer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePatch(testPatch(0xa012, 0x0a001239))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := c.Replace(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.PatchID != 0x0a001238 || c.Patch(0xa012).PatchID != 0x0a001239 {
+		t.Errorf("replaced patch %#x by %#x, want 0xa001238 by 0xa001239", old.PatchID, c.Patch(0xa012).PatchID)
+	}
+	p, _ = ParsePatch(testPatch(0x8012, 1))
+	if _, err := c.Replace(p); err == nil {
+		t.Errorf("replaced the patch of a revision the container lacks")
+	}
+}
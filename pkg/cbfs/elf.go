@@ -0,0 +1,157 @@
+// Copyright 2018-2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// NewPayloadFromELF creates a SELF payload named n from the loadable
+// segments of the ELF in b, like cbfstool add-payload. Code and data
+// segments are compressed with c.
+func NewPayloadFromELF(n string, b []byte, c Compression) (*PayloadRecord, error) {
+	e, err := elf.NewFile(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	p := &PayloadRecord{File: File{Name: n, FileHeader: FileHeader{Type: TypeSELF}}}
+	copy(p.Magic[:], FileMagic)
+	var data [][]byte
+	for _, prog := range e.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Memsz == 0 {
+			continue
+		}
+		h := PayloadHeader{LoadAddress: prog.Paddr, MemSize: uint32(prog.Memsz)}
+		var d []byte
+		switch {
+		case prog.Filesz == 0:
+			h.Type = SegBSS
+		case prog.Flags&elf.PF_X != 0:
+			h.Type = SegCode
+		default:
+			h.Type = SegData
+		}
+		if prog.Filesz != 0 {
+			d = make([]byte, prog.Filesz)
+			if _, err := io.ReadFull(prog.Open(), d); err != nil {
+				return nil, fmt.Errorf("reading segment at %#x: %v", prog.Paddr, err)
+			}
+		}
+		p.Segs = append(p.Segs, h)
+		data = append(data, d)
+	}
+	if len(p.Segs) == 0 {
+		return nil, fmt.Errorf("%q: ELF has no loadable segments", n)
+	}
+	p.Segs = append(p.Segs, PayloadHeader{Type: SegEntry, LoadAddress: e.Entry})
+	data = append(data, nil)
+	if err := p.setSegments(data, c); err != nil {
+		return nil, err
+	}
+	p.updateOffsets()
+	return p, nil
+}
+
+// ELF reconstructs an ELF executable for machine m from the segments of
+// the payload. Code, data and BSS segments become loadable segments and
+// the entry segment the entry point. The class is derived from m.
+func (p *PayloadRecord) ELF(m elf.Machine) ([]byte, error) {
+	var class elf.Class
+	switch m {
+	case elf.EM_386, elf.EM_ARM, elf.EM_MIPS:
+		class = elf.ELFCLASS32
+	case elf.EM_X86_64, elf.EM_AARCH64, elf.EM_RISCV, elf.EM_PPC64:
+		class = elf.ELFCLASS64
+	default:
+		return nil, fmt.Errorf("unsupported machine %v", m)
+	}
+
+	type load struct {
+		h    PayloadHeader
+		data []byte
+	}
+	var loads []load
+	var entry uint64
+	for n, h := range p.Segs {
+		switch h.Type {
+		case SegEntry:
+			entry = h.LoadAddress
+			continue
+		case SegCode, SegData, SegBSS:
+		default:
+			continue
+		}
+		d, err := p.SegmentData(n)
+		if err != nil {
+			return nil, err
+		}
+		loads = append(loads, load{h: h, data: d})
+	}
+
+	var ehdrLen, phdrLen int
+	if class == elf.ELFCLASS32 {
+		ehdrLen, phdrLen = binary.Size(elf.Header32{}), binary.Size(elf.Prog32{})
+	} else {
+		ehdrLen, phdrLen = binary.Size(elf.Header64{}), binary.Size(elf.Prog64{})
+	}
+	ident := [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(class), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)}
+
+	var hdr, body bytes.Buffer
+	off := uint64(ehdrLen + len(loads)*phdrLen)
+	var phdrs []interface{}
+	for _, l := range loads {
+		flags := elf.PF_R | elf.PF_W
+		if l.h.Type == SegCode {
+			flags = elf.PF_R | elf.PF_X
+		}
+		memsz := uint64(l.h.MemSize)
+		if memsz < uint64(len(l.data)) {
+			memsz = uint64(len(l.data))
+		}
+		fileOff := off + uint64(body.Len())
+		if class == elf.ELFCLASS32 {
+			phdrs = append(phdrs, elf.Prog32{
+				Type: uint32(elf.PT_LOAD), Flags: uint32(flags), Off: uint32(fileOff),
+				Vaddr: uint32(l.h.LoadAddress), Paddr: uint32(l.h.LoadAddress),
+				Filesz: uint32(len(l.data)), Memsz: uint32(memsz), Align: 1,
+			})
+		} else {
+			phdrs = append(phdrs, elf.Prog64{
+				Type: uint32(elf.PT_LOAD), Flags: uint32(flags), Off: fileOff,
+				Vaddr: l.h.LoadAddress, Paddr: l.h.LoadAddress,
+				Filesz: uint64(len(l.data)), Memsz: memsz, Align: 1,
+			})
+		}
+		body.Write(l.data)
+	}
+	if class == elf.ELFCLASS32 {
+		if err := binary.Write(&hdr, binary.LittleEndian, elf.Header32{
+			Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(m), Version: uint32(elf.EV_CURRENT),
+			Entry: uint32(entry), Phoff: uint32(ehdrLen), Ehsize: uint16(ehdrLen),
+			Phentsize: uint16(phdrLen), Phnum: uint16(len(phdrs)),
+		}); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := binary.Write(&hdr, binary.LittleEndian, elf.Header64{
+			Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(m), Version: uint32(elf.EV_CURRENT),
+			Entry: entry, Phoff: uint64(ehdrLen), Ehsize: uint16(ehdrLen),
+			Phentsize: uint16(phdrLen), Phnum: uint16(len(phdrs)),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, ph := range phdrs {
+		if err := binary.Write(&hdr, binary.LittleEndian, ph); err != nil {
+			return nil, err
+		}
+	}
+	hdr.Write(body.Bytes())
+	return hdr.Bytes(), nil
+}
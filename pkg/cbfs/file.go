@@ -127,6 +127,48 @@ func ReadData(r io.ReadSeeker, f *File) error {
 	return nil
 }
 
+// writeHeader writes the file header, the NUL padded name and the
+// attributes. Like cbfstool, the name is NUL padded to 16 bytes and the
+// remaining space up to SubHeaderOffset, where the data starts, is 0xff.
+func (f *File) writeHeader(w io.Writer) error {
+	hdrLen := uint32(binary.Size(FileHeader{}))
+	nameEnd := f.SubHeaderOffset
+	if f.AttrOffset != 0 {
+		nameEnd = f.AttrOffset
+	}
+	if nameEnd < hdrLen || f.SubHeaderOffset < nameEnd+uint32(len(f.Attr)) {
+		return fmt.Errorf("%q: inconsistent header offsets: attributes at %#x, data at %#x, %d bytes of attributes",
+			f.Name, f.AttrOffset, f.SubHeaderOffset, len(f.Attr))
+	}
+	if uint32(len(f.Name)) >= nameEnd-hdrLen {
+		return fmt.Errorf("%q: name does not fit in %d bytes", f.Name, nameEnd-hdrLen)
+	}
+	b := ffbyte(f.SubHeaderOffset - hdrLen)
+	nameLen := align(uint32(len(f.Name))+1, nameAlign)
+	if nameLen > nameEnd-hdrLen {
+		nameLen = nameEnd - hdrLen
+	}
+	copy(b, make([]byte, nameLen))
+	copy(b, f.Name)
+	copy(b[nameEnd-hdrLen:], f.Attr)
+	if err := Write(w, f.FileHeader); err != nil {
+		return err
+	}
+	return Write(w, b)
+}
+
+// recordLen returns the length of the whole record, i.e. header, name,
+// attributes and data, excluding the alignment padding.
+func (f *File) recordLen() uint32 {
+	return f.SubHeaderOffset + f.Size
+}
+
+// HasAttribute returns true if the file has an attribute with the given tag.
+func (f *File) HasAttribute(t Tag) bool {
+	_, err := f.FindAttribute(t)
+	return err == nil
+}
+
 // FindAttribute returns the attribute with given tag as
 // []byte. It has the size as specified by the tag.
 // Returns an error if not found or could not read in total.
@@ -185,12 +227,123 @@ func (f *File) Decompress() ([]byte, error) {
 	c := f.Compression()
 	if c == None {
 		return f.FData, nil
-	} else if c == LZMA {
-		compressor := compression.LZMA{}
-		return compressor.Decode(f.FData)
-	} else if c == LZ4 {
-		compressor := compression.LZ4{}
-		return compressor.Decode(f.FData)
+	}
+	compressor, err := compressor(c)
+	if err != nil {
+		return nil, err
+	}
+	return compressor.Decode(f.FData)
+}
+
+// SetData compresses data with c and stores the result in FData. The
+// compression attribute and the header offsets and size are updated
+// accordingly. This only makes sense for file types whose record writes
+// FData as is, e.g. raw files.
+func (f *File) SetData(data []byte, c Compression) error {
+	if c == None {
+		f.removeAttribute(Compressed)
+		f.FData = data
+	} else {
+		compressor, err := compressor(c)
+		if err != nil {
+			return err
+		}
+		enc, err := compressor.Encode(data)
+		if err != nil {
+			return fmt.Errorf("compressing %q with %v: %v", f.Name, c, err)
+		}
+		var b bytes.Buffer
+		attr := FileAttrCompression{
+			Tag:              Compressed,
+			Size:             uint32(binary.Size(FileAttrCompression{})),
+			Compression:      c,
+			DecompressedSize: uint32(len(data)),
+		}
+		if err := Write(&b, attr); err != nil {
+			return err
+		}
+		f.setAttribute(Compressed, b.Bytes())
+		f.FData = enc
+	}
+	f.Size = uint32(len(f.FData))
+	f.updateOffsets()
+	return nil
+}
+
+// SetCompression recompresses FData with c.
+func (f *File) SetCompression(c Compression) error {
+	if c == f.Compression() {
+		return nil
+	}
+	data, err := f.Decompress()
+	if err != nil {
+		return err
+	}
+	return f.SetData(data, c)
+}
+
+// attributes splits Attr into the raw attributes, each including its tag
+// and size. Parsing stops at the first unused or malformed tag.
+func (f *File) attributes() [][]byte {
+	var attrs [][]byte
+	hdrLen := uint32(binary.Size(FileAttr{}))
+	for b := f.Attr; uint32(len(b)) >= hdrLen; {
+		tag := Endian.Uint32(b[0:])
+		size := Endian.Uint32(b[4:])
+		if tag == uint32(Unused) || tag == uint32(Unused2) || size < hdrLen || size > uint32(len(b)) {
+			break
+		}
+		attrs = append(attrs, b[:size])
+		b = b[size:]
+	}
+	return attrs
+}
+
+// setAttribute replaces the attribute with tag t by attr, which must
+// include the tag and size. If there is no such attribute, it is appended.
+func (f *File) setAttribute(t Tag, attr []byte) {
+	var b []byte
+	found := false
+	for _, a := range f.attributes() {
+		if Tag(Endian.Uint32(a)) == t {
+			a, found = attr, true
+		}
+		b = append(b, a...)
+	}
+	if !found {
+		b = append(b, attr...)
+	}
+	f.Attr = b
+}
+
+// removeAttribute removes the attribute with tag t, if any.
+func (f *File) removeAttribute(t Tag) {
+	var b []byte
+	for _, a := range f.attributes() {
+		if Tag(Endian.Uint32(a)) != t {
+			b = append(b, a...)
+		}
+	}
+	f.Attr = b
+}
+
+// updateOffsets recomputes AttrOffset and SubHeaderOffset from the name
+// and the attributes, the way cbfstool lays out a file header.
+func (f *File) updateOffsets() {
+	off := uint32(binary.Size(FileHeader{})) + align(uint32(len(f.Name))+1, nameAlign)
+	f.AttrOffset = 0
+	if len(f.Attr) != 0 {
+		f.AttrOffset = off
+	}
+	f.SubHeaderOffset = align(off+uint32(len(f.Attr)), attributeAlign)
+}
+
+func compressor(c Compression) (compression.Compressor, error) {
+	switch c {
+	case LZMA:
+		return &compression.LZMA{}, nil
+	case LZ4:
+		return &compression.LZ4{}, nil
 	}
 	return nil, fmt.Errorf("Unknown compression")
 }
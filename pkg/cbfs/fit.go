@@ -0,0 +1,188 @@
+// Copyright 2018-2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/microcode"
+)
+
+// MicrocodeBlobName is the CBFS file holding the microcode updates of
+// coreboot images for Intel platforms.
+const MicrocodeBlobName = "cpu_microcode_blob.bin"
+
+// fitVersion is the version of the FIT header and microcode entries
+// written by coreboot's ifittool.
+const fitVersion = 0x100
+
+// fitEntryLen is the size of a FIT entry.
+const fitEntryLen = 16
+
+// FIT returns the Firmware Interface Table of the image, which is found
+// through the FIT pointer at the end of x86 images, and its offset in Data.
+// On coreboot images, the FIT is part of the bootblock.
+func (i *Image) FIT() (fit.Table, uint32, error) {
+	start, end, err := fit.GetHeadersTableRangeFrom(bytes.NewReader(i.Data))
+	if err != nil {
+		return nil, 0, err
+	}
+	t, err := fit.ParseTable(i.Data[start:end])
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(t) == 0 || t[0].Type() != fit.EntryTypeFITHeaderEntry {
+		return nil, 0, fmt.Errorf("FIT at %#x does not start with a header entry", start)
+	}
+	return t, uint32(start), nil
+}
+
+// writeFIT writes t at off, updating the entry count and the checksum of
+// the header. Entries of the old table which are not used anymore, up to
+// old entries, are cleared.
+func (i *Image) writeFIT(off uint32, t fit.Table, old int) error {
+	t[0].Size.SetUint32(uint32(len(t)))
+	t[0].Checksum = 0
+	var b bytes.Buffer
+	if _, err := t.WriteTo(&b); err != nil {
+		return err
+	}
+	if t[0].IsChecksumValid() {
+		var sum uint8
+		for _, c := range b.Bytes() {
+			sum += c
+		}
+		t[0].Checksum = -sum
+		b.Reset()
+		if _, err := t.WriteTo(&b); err != nil {
+			return err
+		}
+	}
+	if old > len(t) {
+		b.Write(make([]byte, (old-len(t))*fitEntryLen))
+	}
+	if uint64(off)+uint64(b.Len()) > uint64(len(i.Data)) {
+		return fmt.Errorf("FIT at %#x with %d entries exceeds the image", off, len(t))
+	}
+	i.patch(off, b.Bytes())
+	return nil
+}
+
+// microcodeOffsets returns the offsets of the microcode updates in blob.
+// The blob ends at the first invalid update, e.g. the padding.
+func microcodeOffsets(blob []byte) []uint32 {
+	var offs []uint32
+	for off := 0; off < len(blob); {
+		m, err := microcode.ParseIntelMicrocode(bytes.NewReader(blob[off:]))
+		if err != nil {
+			Debug("microcodeOffsets: no update at %#x: %v", off, err)
+			break
+		}
+		size := m.HeaderTotalSize
+		if m.HeaderDataSize == 0 {
+			size = microcode.DefaultTotalSize
+		}
+		offs = append(offs, uint32(off))
+		off += int(size)
+	}
+	return offs
+}
+
+// AddFITMicrocode adds a microcode update entry to the FIT for every update
+// in the CBFS file name, like ifittool -a. Updates already in the FIT are
+// skipped. The FIT has room for max entries besides the header; updates
+// which don't fit are dropped. It returns the number of entries added.
+// Update must be called to write the result to the bootblock.
+func (i *Image) AddFITMicrocode(name string, max int) (int, error) {
+	var f *File
+	for _, s := range i.Segs {
+		if s.GetFile().Name == name && !s.GetFile().Deleted() {
+			f = s.GetFile()
+		}
+	}
+	if f == nil {
+		return 0, os.ErrNotExist
+	}
+	if f.Compression() != None {
+		return 0, fmt.Errorf("%q: microcode must not be compressed, is %v", name, f.Compression())
+	}
+	offs := microcodeOffsets(f.FData)
+	if len(offs) == 0 {
+		return 0, fmt.Errorf("%q: no microcode updates found", name)
+	}
+	t, start, err := i.FIT()
+	if err != nil {
+		return 0, err
+	}
+	old := len(t)
+	have := map[uint64]bool{}
+	for _, e := range t[1:] {
+		if e.Type() == fit.EntryTypeMicrocodeUpdateEntry {
+			have[e.Address.Pointer()] = true
+		}
+	}
+	var n int
+	for x, o := range offs {
+		var e fit.EntryHeaders
+		e.Address.SetOffset(uint64(i.Area.Offset+f.RecordStart+f.SubHeaderOffset+o), uint64(len(i.Data)))
+		if have[e.Address.Pointer()] {
+			continue
+		}
+		if len(t)-1 >= max {
+			Debug("AddFITMicrocode: FIT is full, dropping %d of %d updates", len(offs)-x, len(offs))
+			break
+		}
+		e.Version = fitVersion
+		e.TypeAndIsChecksumValid.SetType(fit.EntryTypeMicrocodeUpdateEntry)
+		t = append(t, e)
+		n++
+	}
+	sortFIT(t)
+	return n, i.writeFIT(start, t, old)
+}
+
+// RemoveFITEntries removes all entries of type typ from the FIT, like
+// ifittool -d, and returns how many were removed. The header can't be
+// removed. Update must be called to write the result to the bootblock.
+func (i *Image) RemoveFITEntries(typ fit.EntryType) (int, error) {
+	if typ == fit.EntryTypeFITHeaderEntry {
+		return 0, os.ErrPermission
+	}
+	t, start, err := i.FIT()
+	if err != nil {
+		return 0, err
+	}
+	old := len(t)
+	n := t[:1]
+	for _, e := range t[1:] {
+		if e.Type() != typ {
+			n = append(n, e)
+		}
+	}
+	return old - len(n), i.writeFIT(start, n, old)
+}
+
+// ClearFIT removes all entries but the header from the FIT, like ifittool
+// -c. Update must be called to write the result to the bootblock.
+func (i *Image) ClearFIT() error {
+	t, start, err := i.FIT()
+	if err != nil {
+		return err
+	}
+	return i.writeFIT(start, t[:1], len(t))
+}
+
+// sortFIT sorts the entries following the header by type, as required by
+// the FIT specification.
+func sortFIT(t fit.Table) {
+	e := t[1:]
+	sort.SliceStable(e, func(a, b int) bool {
+		return e[a].Type() < e[b].Type()
+	})
+}
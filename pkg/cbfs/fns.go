@@ -62,6 +62,24 @@ func (c Compression) String() string {
 	return "unknown"
 }
 
+func (h HashAlgorithm) String() string {
+	switch h {
+	case HashNone:
+		return "none"
+	case HashSHA1:
+		return "sha1"
+	case HashSHA256:
+		return "sha256"
+	case HashSHA512:
+		return "sha512"
+	case HashSHA224:
+		return "sha224"
+	case HashSHA384:
+		return "sha384"
+	}
+	return "unknown"
+}
+
 func (f FileType) String() string {
 	switch f {
 	case TypeDeleted2:
@@ -137,3 +155,7 @@ func ffbyte(s uint32) []byte {
 	}
 	return b
 }
+
+func align(v, a uint32) uint32 {
+	return (v + a - 1) &^ (a - 1)
+}
@@ -0,0 +1,149 @@
+// Copyright 2018-2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ErrHashMismatch is returned when the data of a file does not match the
+// digest stored in its hash attribute.
+var ErrHashMismatch = errors.New("CBFS file hash mismatch")
+
+// hashAttrHeaderLen is the length of the hash attribute without digest.
+var hashAttrHeaderLen = uint32(binary.Size(FileAttr{}) + binary.Size(HashAlgorithm(0)))
+
+func (h HashAlgorithm) new() (hash.Hash, error) {
+	switch h {
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashSHA224:
+		return sha256.New224(), nil
+	case HashSHA384:
+		return sha512.New384(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %v", h)
+}
+
+// Sum returns the digest of data using algorithm h.
+func (h HashAlgorithm) Sum(data []byte) ([]byte, error) {
+	s, err := h.new()
+	if err != nil {
+		return nil, err
+	}
+	s.Write(data)
+	return s.Sum(nil), nil
+}
+
+// Hash returns the hash attribute of the file. If the file has none, it
+// returns an error.
+func (f *File) Hash() (*FileAttrHash, error) {
+	b, err := f.FindAttribute(Hash)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(b)) < hashAttrHeaderLen {
+		return nil, fmt.Errorf("%q: hash attribute too short: %d bytes", f.Name, len(b))
+	}
+	h := &FileAttrHash{
+		Tag:      Tag(Endian.Uint32(b[0:])),
+		Size:     Endian.Uint32(b[4:]),
+		HashType: HashAlgorithm(Endian.Uint32(b[8:])),
+		Data:     b[hashAttrHeaderLen:],
+	}
+	return h, nil
+}
+
+// SetHash adds a hash attribute with algorithm h over FData or replaces
+// the existing one. The header offsets are updated if the attributes
+// change in size.
+func (f *File) SetHash(h HashAlgorithm) error {
+	n := len(f.Attr)
+	if err := f.setHash(h, f.FData); err != nil {
+		return err
+	}
+	if len(f.Attr) != n {
+		f.updateOffsets()
+	}
+	return nil
+}
+
+// setHash stores the digest of data in the hash attribute, leaving the
+// header offsets alone.
+func (f *File) setHash(h HashAlgorithm, data []byte) error {
+	sum, err := h.Sum(data)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := Write(&b, FileAttr{Tag: uint32(Hash), Size: hashAttrHeaderLen + uint32(len(sum))}); err != nil {
+		return err
+	}
+	if err := Write(&b, h); err != nil {
+		return err
+	}
+	b.Write(sum)
+	f.setAttribute(Hash, b.Bytes())
+	return nil
+}
+
+// VerifyHash checks FData against the hash attribute of the file. Files
+// without a hash attribute are considered valid.
+func (f *File) VerifyHash() error {
+	return f.verifyHash(f.FData)
+}
+
+func (f *File) verifyHash(data []byte) error {
+	if !f.HasAttribute(Hash) {
+		return nil
+	}
+	h, err := f.Hash()
+	if err != nil {
+		return err
+	}
+	sum, err := h.HashType.Sum(data)
+	if err != nil {
+		return fmt.Errorf("%q: %v", f.Name, err)
+	}
+	if !bytes.Equal(sum, h.Data) {
+		return fmt.Errorf("%q: %w: %v is %#x, want %#x", f.Name, ErrHashMismatch, h.HashType, sum, h.Data)
+	}
+	return nil
+}
+
+// VerifyHashes checks the hash attributes of all files in the image and
+// returns an error listing all mismatches.
+func (i *Image) VerifyHashes() error {
+	var result *multierror.Error
+	for _, s := range i.Segs {
+		if err := s.GetFile().VerifyHash(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// hashed returns true if any file in the image carries a hash attribute.
+func (i *Image) hashed() bool {
+	for _, s := range i.Segs {
+		if s.GetFile().HasAttribute(Hash) {
+			return true
+		}
+	}
+	return false
+}
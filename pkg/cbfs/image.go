@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/linuxboot/fiano/pkg/fmap"
 )
@@ -31,7 +32,16 @@ func RegisterFileReader(f *SegReader) error {
 	return nil
 }
 
+// DefaultRegion is the FMAP area holding the main, read-only CBFS.
+const DefaultRegion = "COREBOOT"
+
 func NewImage(rs io.ReadSeeker) (*Image, error) {
+	return NewImageRegion(rs, DefaultRegion)
+}
+
+// NewImageRegion reads the CBFS in the FMAP area named region, e.g.
+// FW_MAIN_A for a vboot RW slot.
+func NewImageRegion(rs io.ReadSeeker, region string) (*Image, error) {
 	// Suck the image in. Todo: write a thing that implements
 	// ReadSeeker on a []byte.
 	b, err := io.ReadAll(rs)
@@ -45,23 +55,52 @@ func NewImage(rs io.ReadSeeker) (*Image, error) {
 	}
 	Debug("Fmap %v", f)
 	var i = &Image{FMAP: f, FMAPMetadata: m, Data: b}
-	for _, a := range f.Areas {
-		Debug("Check %v", a.Name.String())
-		if a.Name.String() == "COREBOOT" {
-			i.Area = &a
-			break
-		}
+	return i.Region(region)
+}
+
+// Region returns the CBFS in the FMAP area named region. The returned
+// Image shares Data with i, so after an Update of either, i.Data holds the
+// changes.
+func (i *Image) Region(region string) (*Image, error) {
+	x := i.FMAP.IndexOfArea(region)
+	if x == -1 {
+		return nil, fmt.Errorf("No %s CBFS in fmap", region)
 	}
-	if i.Area == nil {
-		return nil, fmt.Errorf("No CBFS in fmap")
+	a := i.FMAP.Areas[x]
+	r := &Image{FMAP: i.FMAP, FMAPMetadata: i.FMAPMetadata, Area: &a, Data: i.Data}
+	if err := r.readSegs(bytes.NewReader(r.Data)); err != nil {
+		return nil, err
+	}
+	if err := r.VerifyMetadataHash(); err != nil && err != ErrNoMetadataHashAnchor {
+		Debug("%v", err)
 	}
+	return r, nil
+}
+
+// Regions returns the names of all FMAP areas which hold a CBFS, i.e.
+// start with a CBFS file header.
+func (i *Image) Regions() []string {
+	var regions []string
+	for _, a := range i.FMAP.Areas {
+		if uint64(a.Offset)+uint64(len(FileMagic)) > uint64(len(i.Data)) {
+			continue
+		}
+		if string(i.Data[a.Offset:a.Offset+uint32(len(FileMagic))]) == FileMagic {
+			regions = append(regions, a.Name.String())
+		}
+	}
+	return regions
+}
+
+// readSegs reads the files in the CBFS area.
+func (i *Image) readSegs(in io.ReaderAt) error {
 	r := io.NewSectionReader(in, int64(i.Area.Offset), int64(i.Area.Size))
 
 	for off := int64(0); off < int64(i.Area.Size); {
 		var f *File
 
 		if _, err := r.Seek(off, io.SeekStart); err != nil {
-			return nil, err
+			return err
 		}
 		f, err := NewFile(r)
 		if err == CbfsHeaderMagicNotFound {
@@ -69,10 +108,10 @@ func NewImage(rs io.ReadSeeker) (*Image, error) {
 			continue
 		}
 		if err == io.EOF {
-			return i, nil
+			return nil
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		Debug("It is %v type %v", f, f.Type)
@@ -85,22 +124,25 @@ func NewImage(rs io.ReadSeeker) (*Image, error) {
 		}
 		s, err := sr.New(f)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		Debug("Segment: %v", s)
 		if err := s.Read(bytes.NewReader(f.FData)); err != nil {
-			return nil, fmt.Errorf("Reading %#x byte subheader, type %v: %v", len(f.FData), f.Type, err)
+			return fmt.Errorf("Reading %#x byte subheader, type %v: %v", len(f.FData), f.Type, err)
 		}
 		Debug("Segment was readable")
+		if err := f.VerifyHash(); err != nil {
+			i.Warnings = append(i.Warnings, err)
+		}
 		i.Segs = append(i.Segs, s)
 		off, err = r.Seek(0, io.SeekCurrent)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		// Force alignment.
 		off = (off + 15) & (^15)
 	}
-	return i, nil
+	return nil
 }
 
 func (i *Image) WriteFile(name string, perm os.FileMode) error {
@@ -113,28 +155,60 @@ func (i *Image) WriteFile(name string, perm os.FileMode) error {
 // Update creates a new []byte for the cbfs. It is complicated a lot
 // by the fact that endianness is not consistent in cbfs images.
 func (i *Image) Update() error {
-	//FIXME: Support additional regions
-	for _, s := range i.Segs {
-		var b bytes.Buffer
-		if err := Write(&b, s.GetFile().FileHeader); err != nil {
+	if m, err := i.Master(); err == nil && m.Magic == MasterHeaderMagic {
+		if err := i.UpdateMasterHeader(); err != nil {
 			return err
 		}
-		if _, err := b.Write(s.GetFile().Attr); err != nil {
-			return fmt.Errorf("Writing attr to cbfs record for %v: %v", s, err)
+		// The pointer is part of the bootblock, so set it before that
+		// is written and hashed.
+		if i.pointsToHeader() {
+			if err := i.SetHeaderPointer(); err != nil {
+				return err
+			}
 		}
-		if err := s.Write(&b); err != nil {
+	}
+	for x, s := range i.Segs {
+		var b, d bytes.Buffer
+		if err := s.Write(&d); err != nil {
 			return err
 		}
+		// The data may have changed, so regenerate its hash. Its
+		// size only depends on the algorithm, so the offsets stay.
+		if f := s.GetFile(); f.HasAttribute(Hash) {
+			h, err := f.Hash()
+			if err != nil {
+				return err
+			}
+			if err := f.setHash(h.HashType, d.Bytes()); err != nil {
+				return err
+			}
+		}
+		if err := s.GetFile().writeHeader(&b); err != nil {
+			return fmt.Errorf("Writing header to cbfs record for %v: %v", s, err)
+		}
+		b.Write(d.Bytes())
 		// This error should not happen but we need to check just in case.
 		end := uint32(len(b.Bytes())) + s.GetFile().RecordStart
 		if end > i.Area.Size {
 			return fmt.Errorf("Region [%#x, %#x] outside of CBFS [%#x, %#x]", s.GetFile().RecordStart, end, s.GetFile().RecordStart, i.Area.Size)
 		}
+		// A record which grew must not overwrite the next one.
+		if end > i.recordEnd(x) {
+			return fmt.Errorf("%q [%#x, %#x] overlaps the next record at %#x", s.GetFile().Name, s.GetFile().RecordStart, end, i.recordEnd(x))
+		}
 
 		Debug("Copy %s %d bytes to i.Data[%d]", s.GetFile().Type.String(), len(b.Bytes()), i.Area.Offset+s.GetFile().RecordStart)
 		copy(i.Data[i.Area.Offset+s.GetFile().RecordStart:], b.Bytes())
+
+		// Erase the alignment padding up to the next record, so that
+		// records which moved don't leave stale bytes behind.
+		if x+1 < len(i.Segs) {
+			if next := i.Segs[x+1].GetFile().RecordStart; next > end {
+				copy(i.Data[i.Area.Offset+end:], ffbyte(next-end))
+			}
+		}
 	}
-	return nil
+	return i.UpdateMetadataHash()
 }
 
 type mImage struct {
@@ -147,7 +221,7 @@ func (i *Image) MarshalJSON() ([]byte, error) {
 }
 
 func (i *Image) String() string {
-	var s = "FMAP REGIOName: COREBOOT\n"
+	var s = "FMAP REGIOName: " + i.Area.Name.String() + "\n"
 
 	s += fmt.Sprintf("%-32s %-8s   %-24s %-8s   %-4s\n", "Name", "Offset", "Type", "Size", "Comp")
 	for _, seg := range i.Segs {
@@ -161,6 +235,52 @@ func (h *FileHeader) Deleted() bool {
 	return t == TypeDeleted || t == TypeDeleted2
 }
 
+// Add places r into the first empty record it fits into. The rest of
+// the empty space is kept as a new empty record. If the image contains
+// hashed files, a SHA-256 hash attribute is added to r.
+// As with Remove, Update must be called to write the result to Data.
+func (i *Image) Add(r ReadWriter) error {
+	f := r.GetFile()
+	for _, s := range i.Segs {
+		if s.GetFile().Name == f.Name && !s.GetFile().Deleted() {
+			return os.ErrExist
+		}
+	}
+	// Keep images with verified files consistent: if others are
+	// hashed, hash the new file too.
+	if !f.HasAttribute(Hash) && i.hashed() {
+		if err := f.SetHash(HashSHA256); err != nil {
+			return err
+		}
+	}
+	for x, s := range i.Segs {
+		if !s.GetFile().Deleted() {
+			continue
+		}
+		start, end := s.GetFile().RecordStart, i.recordEnd(x)
+		if start+f.recordLen() > end {
+			continue
+		}
+		f.RecordStart = start
+		segs := append([]ReadWriter{}, i.Segs[:x]...)
+		segs = append(segs, r)
+		if rest := align(start+f.recordLen(), Alignment); rest < end && end-rest >= emptyHeaderLen {
+			del, err := i.emptyRecord(rest, end)
+			if err != nil {
+				return err
+			}
+			segs = append(segs, del)
+		}
+		i.Segs = append(segs, i.Segs[x+1:]...)
+		Debug("Add: %s", r.String())
+		return nil
+	}
+	return fmt.Errorf("no room for %q (%#x bytes) in CBFS", f.Name, f.recordLen())
+}
+
+// Remove converts the file named n into empty space and merges it with
+// any adjacent empty space. The master header and the x86 bootblock can't
+// be removed.
 func (i *Image) Remove(n string) error {
 	found := -1
 	for x, s := range i.Segs {
@@ -169,37 +289,160 @@ func (i *Image) Remove(n string) error {
 		}
 	}
 	if found == -1 {
-		return os.ErrExist
+		return os.ErrNotExist
 	}
+	f := i.Segs[found].GetFile()
 	// You can not remove the master header
 	// Just remake the cbfs if you're doing that kind of surgery.
-	if found == 0 {
+	if f.Type == TypeMaster {
 		return os.ErrPermission
 	}
 	// Bootblock on x86 is at the end of CBFS and shall stay untouched.
-	if found == len(i.Segs)-1 && i.Segs[found].GetFile().Type == TypeBootBlock {
+	if found == len(i.Segs)-1 && f.Type == TypeBootBlock {
 		return os.ErrPermission
 	}
-	start, end := found, found+1
-	if i.Segs[start-1].GetFile().Deleted() {
-		start = start - 1
-	}
-	if i.Segs[end].GetFile().Deleted() {
-		end = end + 1
-	}
-	Debug("Remove: empty range [%d:%d]", start, end)
-	base := i.Segs[start].GetFile().RecordStart
-	top := i.Segs[end].GetFile().RecordStart
-	Debug("Remove: base %#x top %#x", base, top)
-	// 0x28: header size + 16-byte-aligned-size name
-	s := top - base - 0x28
-	i.Segs[found].GetFile().SubHeaderOffset = 0x28
-	i.Segs[found].GetFile().Size = s
-	del, _ := NewEmptyRecord(i.Segs[found].GetFile())
-	Debug("Offset is 0x28, Size is %#x", s)
-	Debug("Remove: Replace %d..%d with %s", start, end, del.String())
-	// At most, there will be an Empty record before us since
-	// things come pre-merged
-	i.Segs = append(append(i.Segs[:start], del), i.Segs[end:]...)
+	del, err := i.emptyRecord(f.RecordStart, i.recordEnd(found))
+	if err != nil {
+		return err
+	}
+	Debug("Remove: Replace %d with %s", found, del.String())
+	i.Segs[found] = del
+	return i.Compact(false)
+}
+
+// Compact merges adjacent empty records into one. If repack is set, all
+// files that may be moved are packed towards the start of the CBFS, so
+// that the free space ends up in as few contiguous records as possible.
+// The master header, the bootblock and files with a fixed position or
+// alignment attribute are not moved.
+// As with Remove, Update must be called to write the result to Data.
+func (i *Image) Compact(repack bool) error {
+	if repack {
+		if err := i.repack(); err != nil {
+			return err
+		}
+	}
+	var segs []ReadWriter
+	for x := 0; x < len(i.Segs); x++ {
+		s := i.Segs[x]
+		if !s.GetFile().Deleted() {
+			segs = append(segs, s)
+			continue
+		}
+		last := x
+		for last+1 < len(i.Segs) && i.Segs[last+1].GetFile().Deleted() {
+			last++
+		}
+		if last == x {
+			segs = append(segs, s)
+			continue
+		}
+		del, err := i.emptyRecord(s.GetFile().RecordStart, i.recordEnd(last))
+		if err != nil {
+			return err
+		}
+		Debug("Compact: merge empty range [%d:%d] into %s", x, last, del.String())
+		segs = append(segs, del)
+		x = last
+	}
+	i.Segs = segs
+	return nil
+}
+
+// repack moves all movable files towards the start of the CBFS, skipping
+// over the fixed ones, and fills the holes with empty records.
+func (i *Image) repack() error {
+	var fixed, movable []ReadWriter
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		switch {
+		case f.Deleted():
+		case f.Type == TypeMaster || f.Type == TypeBootBlock:
+			fixed = append(fixed, s)
+		case f.HasAttribute(PSCB) || f.HasAttribute(ALCB):
+			fixed = append(fixed, s)
+		default:
+			movable = append(movable, s)
+		}
+	}
+	sort.SliceStable(fixed, func(a, b int) bool {
+		return fixed[a].GetFile().RecordStart < fixed[b].GetFile().RecordStart
+	})
+
+	var segs []ReadWriter
+	var cursor uint32
+	// place appends s at its own RecordStart, filling the gap before it.
+	place := func(s ReadWriter) error {
+		f := s.GetFile()
+		if f.RecordStart < cursor {
+			return fmt.Errorf("repack: %q at %#x overlaps previous record ending at %#x", f.Name, f.RecordStart, cursor)
+		}
+		if f.RecordStart-cursor >= emptyHeaderLen {
+			del, err := i.emptyRecord(cursor, f.RecordStart)
+			if err != nil {
+				return err
+			}
+			segs = append(segs, del)
+		}
+		segs = append(segs, s)
+		cursor = align(f.RecordStart+f.recordLen(), Alignment)
+		return nil
+	}
+	for _, s := range movable {
+		f := s.GetFile()
+		for len(fixed) > 0 && cursor+f.recordLen() > fixed[0].GetFile().RecordStart {
+			if err := place(fixed[0]); err != nil {
+				return err
+			}
+			fixed = fixed[1:]
+		}
+		Debug("repack: move %q from %#x to %#x", f.Name, f.RecordStart, cursor)
+		f.RecordStart = cursor
+		if err := place(s); err != nil {
+			return err
+		}
+	}
+	for _, s := range fixed {
+		if err := place(s); err != nil {
+			return err
+		}
+	}
+	if cursor > i.Area.Size {
+		return fmt.Errorf("repack: files end at %#x, beyond the CBFS size %#x", cursor, i.Area.Size)
+	}
+	if i.Area.Size-cursor >= emptyHeaderLen {
+		del, err := i.emptyRecord(cursor, i.Area.Size)
+		if err != nil {
+			return err
+		}
+		segs = append(segs, del)
+	}
+	i.Segs = segs
 	return nil
 }
+
+// recordEnd returns the offset at which the space owned by the record
+// at index x ends, i.e. the start of the next record or the end of CBFS.
+func (i *Image) recordEnd(x int) uint32 {
+	if x+1 < len(i.Segs) {
+		return i.Segs[x+1].GetFile().RecordStart
+	}
+	return i.Area.Size
+}
+
+// emptyRecord creates an empty record covering [start, end).
+func (i *Image) emptyRecord(start, end uint32) (ReadWriter, error) {
+	if end < start || end-start < emptyHeaderLen {
+		return nil, fmt.Errorf("no room for an empty record in [%#x, %#x]", start, end)
+	}
+	f := &File{
+		FileHeader: FileHeader{
+			Size:            end - start - emptyHeaderLen,
+			Type:            TypeDeleted2,
+			SubHeaderOffset: emptyHeaderLen,
+		},
+		RecordStart: start,
+	}
+	copy(f.Magic[:], FileMagic)
+	return NewEmptyRecord(f)
+}
@@ -6,12 +6,17 @@ package cbfs
 
 import (
 	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
 )
 
 func TestReadFile(t *testing.T) {
@@ -239,3 +244,852 @@ func TestRemovePayload(t *testing.T) {
 	*/
 
 }
+
+func TestUpdateRoundTrip(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, i.Data) {
+		t.Errorf("Update of an unmodified image changed its contents")
+	}
+}
+
+func TestRemoveCompact(t *testing.T) {
+	Debug = t.Logf
+	for _, repack := range []bool{false, true} {
+		t.Run(fmt.Sprintf("repack=%v", repack), func(t *testing.T) {
+			b, err := os.ReadFile("testdata/coreboot.rom")
+			if err != nil {
+				t.Fatal(err)
+			}
+			i, err := NewImage(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := i.Remove("does/not/exist"); err != os.ErrNotExist {
+				t.Errorf("Remove of missing file: got %v, want %v", err, os.ErrNotExist)
+			}
+			if err := i.Remove("cbfs master header"); err != os.ErrPermission {
+				t.Errorf("Remove of master header: got %v, want %v", err, os.ErrPermission)
+			}
+			for _, n := range []string{"config", "fallback/payload"} {
+				if err := i.Remove(n); err != nil {
+					t.Fatalf("Remove(%q): %v", n, err)
+				}
+			}
+			if err := i.Compact(repack); err != nil {
+				t.Fatal(err)
+			}
+			if err := i.Update(); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := NewImage(bytes.NewReader(i.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			var empty int
+			for x, s := range n.Segs {
+				f := s.GetFile()
+				if f.Deleted() {
+					empty++
+					if x > 0 && n.Segs[x-1].GetFile().Deleted() {
+						t.Errorf("adjacent empty records at %#x and %#x", n.Segs[x-1].GetFile().RecordStart, f.RecordStart)
+					}
+					continue
+				}
+				names = append(names, f.Name)
+			}
+			want := []string{"cbfs master header", "fallback/romstage", "fallback/ramstage", "revision",
+				"cmos_layout.bin", "fallback/dsdt.aml", "compression_test1", "compression_test2", "bootblock"}
+			if !reflect.DeepEqual(names, want) {
+				t.Errorf("files after Remove: got %q, want %q", names, want)
+			}
+			if repack && empty != 1 {
+				t.Errorf("got %d empty records after repacking, want 1", empty)
+			}
+			for _, s := range n.Segs {
+				f := s.GetFile()
+				if f.Name != "compression_test2" {
+					continue
+				}
+				d, err := f.Decompress()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(d, []byte(strings.Repeat("FIANO ROCKS!\n", 1024))) {
+					t.Errorf("%s has unexpected contents after Compact", f.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestAddCompressed(t *testing.T) {
+	Debug = t.Logf
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(strings.Repeat("FIANO ROCKS!\n", 1024))
+	for _, c := range []Compression{None, LZMA, LZ4} {
+		r, err := NewRawFile("add_test_"+c.String(), data, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := i.Add(r); err != nil {
+			t.Fatalf("Add(%v): %v", c, err)
+		}
+	}
+	if err := i.Add(&RawRecord{File: File{Name: "config"}}); err != os.ErrExist {
+		t.Errorf("Add of existing file: got %v, want %v", err, os.ErrExist)
+	}
+	// Convert an existing file from LZ4 to LZMA.
+	for _, s := range i.Segs {
+		if f := s.GetFile(); f.Name == "compression_test1" {
+			if err := f.SetCompression(LZMA); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := i.Compact(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Compression{
+		"add_test_none":     None,
+		"add_test_lzma":     LZMA,
+		"add_test_lz4":      LZ4,
+		"compression_test1": LZMA,
+		"compression_test2": LZMA,
+	}
+	for _, s := range n.Segs {
+		f := s.GetFile()
+		c, ok := want[f.Name]
+		if !ok {
+			continue
+		}
+		delete(want, f.Name)
+		if f.Compression() != c {
+			t.Errorf("%s: got compression %v, want %v", f.Name, f.Compression(), c)
+		}
+		d, err := f.Decompress()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(d, data) {
+			t.Errorf("%s: unexpected contents after decompression", f.Name)
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("files missing after Add: %v", want)
+	}
+}
+
+func TestPayloadCompress(t *testing.T) {
+	code := []byte(strings.Repeat("\x90", 4096))
+	p := &PayloadRecord{Segs: []PayloadHeader{
+		{Type: SegCode, LoadAddress: 0x100000, MemSize: uint32(len(code))},
+		{Type: SegBSS, LoadAddress: 0x200000, MemSize: 0x1000},
+		{Type: SegEntry, LoadAddress: 0x100000},
+	}}
+	if err := p.setSegments([][]byte{code, nil, nil}, None); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Compression{LZMA, LZ4, None} {
+		if err := p.Compress(c); err != nil {
+			t.Fatalf("Compress(%v): %v", c, err)
+		}
+		if p.Segs[0].Compression != c {
+			t.Errorf("Compress(%v): code segment has compression %v", c, p.Segs[0].Compression)
+		}
+		if p.Size != 3*28+p.Segs[0].Size {
+			t.Errorf("Compress(%v): payload size %#x does not match segments", c, p.Size)
+		}
+		d, err := p.SegmentData(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, code) {
+			t.Errorf("Compress(%v): code segment changed", c)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f *File
+	for _, s := range i.Segs {
+		if s.GetFile().Name == "config" {
+			f = s.GetFile()
+		}
+	}
+	if f == nil {
+		t.Fatal("config not found")
+	}
+	if err := f.SetHash(HashSHA256); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.VerifyHash(); err != nil {
+		t.Fatal(err)
+	}
+	// Files added to an image with hashed files get hashed as well.
+	r, err := NewRawFile("hash_test", []byte("hash me"), LZ4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.HasAttribute(Hash) {
+		t.Errorf("added file has no hash attribute")
+	}
+	// Modified data gets rehashed on Update.
+	f.FData = append([]byte{}, f.FData...)
+	f.FData[0] ^= 0xff
+	if err := f.VerifyHash(); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("VerifyHash of modified data: got %v, want %v", err, ErrHashMismatch)
+	}
+	if err := i.Compact(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.VerifyHashes(); err != nil {
+		t.Fatal(err)
+	}
+	var hashed int
+	for _, s := range n.Segs {
+		if s.GetFile().HasAttribute(Hash) {
+			hashed++
+		}
+	}
+	if hashed != 2 {
+		t.Errorf("got %d hashed files, want 2", hashed)
+	}
+
+	// Corrupt the data behind the hash.
+	f = n.Segs[0].GetFile()
+	for _, s := range n.Segs {
+		if s.GetFile().Name == "hash_test" {
+			f = s.GetFile()
+		}
+	}
+	n.Data[n.Area.Offset+f.RecordStart+f.SubHeaderOffset] ^= 0xff
+	n, err = NewImage(bytes.NewReader(n.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.VerifyHashes(); err == nil {
+		t.Errorf("VerifyHashes of corrupted image: got nil, want error")
+	}
+	if len(n.Warnings) != 1 || !errors.Is(n.Warnings[0], ErrHashMismatch) {
+		t.Errorf("warnings of corrupted image: got %v, want a %v", n.Warnings, ErrHashMismatch)
+	}
+}
+
+func TestMetadataHash(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.VerifyMetadataHash(); err != ErrNoMetadataHashAnchor {
+		t.Fatalf("VerifyMetadataHash without anchor: got %v, want %v", err, ErrNoMetadataHashAnchor)
+	}
+	// Plant an anchor with CBFS and FMAP SHA-256 hashes in the bootblock.
+	anchor := []byte(MetadataHashAnchorMagic)
+	for x := 0; x < 2; x++ {
+		anchor = append(anchor, 0, 0, 0, byte(HashSHA256))
+		anchor = append(anchor, make([]byte, 32)...)
+	}
+	for _, s := range i.Segs {
+		if f := s.GetFile(); f.Type == TypeBootBlock {
+			copy(f.FData[0x100:], anchor)
+		}
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.VerifyMetadataHash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Remove("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.VerifyMetadataHash(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := n.MetadataHashAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.CBFSHashType != HashSHA256 || a.FMAPHashType != HashSHA256 {
+		t.Errorf("got anchor hash types %v and %v, want %v", a.CBFSHashType, a.FMAPHashType, HashSHA256)
+	}
+
+	// Rename a file behind the anchor's back.
+	f := n.Segs[1].GetFile()
+	n.Data[n.Area.Offset+f.RecordStart+uint32(binary.Size(FileHeader{}))] ^= 0x20
+	n, err = NewImage(bytes.NewReader(n.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.VerifyMetadataHash(); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("VerifyMetadataHash of modified header: got %v, want %v", err, ErrHashMismatch)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Double the image and add a copy of COREBOOT as RW slot.
+	rom := append(append([]byte{}, b...), ffbyte(uint32(len(b)))...)
+	co := i.Area
+	copy(rom[uint32(len(b))+co.Offset:], b[co.Offset:co.Offset+co.Size])
+	fm := *i.FMAP
+	fm.Size *= 2
+	fm.Areas = append(append([]fmap.Area{}, fm.Areas...), fmap.Area{Offset: uint32(len(b)) + co.Offset, Size: co.Size})
+	copy(fm.Areas[len(fm.Areas)-1].Name.Value[:], "FW_MAIN_A")
+	fm.NAreas++
+	var fb bytes.Buffer
+	if err := binary.Write(&fb, binary.LittleEndian, fm.Header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&fb, binary.LittleEndian, fm.Areas); err != nil {
+		t.Fatal(err)
+	}
+	copy(rom[i.FMAPMetadata.Start:], fb.Bytes())
+
+	i, err = NewImage(bytes.NewReader(rom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := i.Regions(), []string{"COREBOOT", "FW_MAIN_A"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Regions: got %v, want %v", got, want)
+	}
+	rw, err := i.Region("FW_MAIN_A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Remove("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	has := func(i *Image, n string) bool {
+		for _, s := range i.Segs {
+			if s.GetFile().Name == n {
+				return true
+			}
+		}
+		return false
+	}
+	ro, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has(ro, "config") {
+		t.Errorf("config removed from COREBOOT")
+	}
+	rw, err = NewImageRegion(bytes.NewReader(i.Data), "FW_MAIN_A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has(rw, "config") {
+		t.Errorf("config not removed from FW_MAIN_A")
+	}
+	if _, err := i.Region("FW_MAIN_B"); err == nil {
+		t.Errorf("Region of missing area: got nil, want error")
+	}
+}
+
+func TestStages(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage := func(i *Image, n string) Stage {
+		for _, s := range i.Segs {
+			if st, ok := s.(Stage); ok && s.GetFile().Name == n {
+				return st
+			}
+		}
+		t.Fatalf("no stage %q", n)
+		return nil
+	}
+	rs := stage(i, "fallback/romstage")
+	info := rs.Info()
+	if info.Entry < info.LoadAddress || info.MemSize == 0 {
+		t.Fatalf("implausible romstage %v", info)
+	}
+	data, err := rs.StageData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Compression = LZMA
+	if err := rs.SetStageData(data, info); err != nil {
+		t.Fatal(err)
+	}
+	want := StageInfo{LoadAddress: 0x2000000, Entry: 0x2000010, MemSize: 0x2000, Compression: LZ4}
+	prog := bytes.Repeat([]byte("\xf4\x90"), 0x400)
+	ns, err := NewStageFile("fallback/postcar", prog, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(ns); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		info StageInfo
+		data []byte
+	}{
+		{"fallback/romstage", info, data},
+		{"fallback/postcar", want, prog},
+	} {
+		s := stage(n, c.name)
+		if s.Info() != c.info {
+			t.Errorf("%s: got %v, want %v", c.name, s.Info(), c.info)
+		}
+		d, err := s.StageData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, c.data) {
+			t.Errorf("%s: program changed", c.name)
+		}
+	}
+
+	// Growing a stage beyond its record must not clobber the next one.
+	rs = stage(n, "fallback/romstage")
+	info.Compression = None
+	info.MemSize = 0
+	if err := rs.SetStageData(append(data, make([]byte, 0x4000)...), info); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Update(); err == nil {
+		t.Errorf("Update with overlapping stage: got nil, want error")
+	}
+	if err := rs.SetStageData(data, StageInfo{LoadAddress: 0x1000, Entry: 0x800}); err == nil {
+		t.Errorf("SetStageData with entry before load address: got nil, want error")
+	}
+}
+
+func TestPayloadELF(t *testing.T) {
+	code := bytes.Repeat([]byte("\x90\x90\xf4"), 0x300)
+	data := []byte("payload data")
+	p := &PayloadRecord{Segs: []PayloadHeader{
+		{Type: SegCode, LoadAddress: 0x100000, MemSize: uint32(len(code))},
+		{Type: SegData, LoadAddress: 0x200000, MemSize: 0x100},
+		{Type: SegBSS, LoadAddress: 0x300000, MemSize: 0x1000},
+		{Type: SegEntry, LoadAddress: 0x100010},
+	}}
+	if err := p.setSegments([][]byte{code, data, nil, nil}, LZMA); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []elf.Machine{elf.EM_386, elf.EM_X86_64} {
+		b, err := p.ELF(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := elf.NewFile(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: %v", m, err)
+		}
+		if e.Entry != 0x100010 || e.Machine != m || len(e.Progs) != 3 {
+			t.Fatalf("%v: got entry %#x, machine %v, %d segments", m, e.Entry, e.Machine, len(e.Progs))
+		}
+
+		n, err := NewPayloadFromELF("fallback/payload", b, LZ4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(n.Segs) != len(p.Segs) {
+			t.Fatalf("%v: got %d segments, want %d", m, len(n.Segs), len(p.Segs))
+		}
+		for x, h := range n.Segs {
+			w := p.Segs[x]
+			if h.Type != w.Type || h.LoadAddress != w.LoadAddress || h.MemSize != w.MemSize {
+				t.Errorf("%v: segment %d: got %v, want %v", m, x, h.String(), w.String())
+			}
+			if (h.Type == SegCode || h.Type == SegData) && h.Compression != LZ4 {
+				t.Errorf("%v: segment %d: got compression %v, want %v", m, x, h.Compression, LZ4)
+			}
+			got, err := n.SegmentData(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := p.SegmentData(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%v: segment %d: data changed", m, x)
+			}
+		}
+	}
+	if _, err := p.ELF(elf.EM_SPARC); err == nil {
+		t.Errorf("ELF for unsupported machine: got nil, want error")
+	}
+}
+
+func TestUpdateOffsets(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		attr      int
+		attrOff   uint32
+		subHdrOff uint32
+	}{
+		{"fallback/payload", 0, 0, 0x38},
+		{"fallback/payload", 6, 0x38, 0x40},
+		{"config", 0, 0, 0x28},
+	} {
+		f := &File{Name: test.name, Attr: make([]byte, test.attr)}
+		f.updateOffsets()
+		if f.AttrOffset != test.attrOff || f.SubHeaderOffset != test.subHdrOff {
+			t.Errorf("%q with %d bytes of attributes: got offsets %#x and %#x, want %#x and %#x",
+				test.name, test.attr, f.AttrOffset, f.SubHeaderOffset, test.attrOff, test.subHdrOff)
+		}
+	}
+}
+
+func TestReplaceSegment(t *testing.T) {
+	kernel := bytes.Repeat([]byte("kernel"), 0x100)
+	initrd := bytes.Repeat([]byte("initrd"), 0x100)
+	p := &PayloadRecord{File: File{Name: "fallback/payload", FileHeader: FileHeader{Type: TypeSELF}}, Segs: []PayloadHeader{
+		{Type: SegCode, LoadAddress: 0x100000, MemSize: uint32(len(kernel))},
+		{Type: SegData, LoadAddress: 0x4000000, MemSize: uint32(len(initrd))},
+		{Type: SegEntry, LoadAddress: 0x100200},
+	}}
+	copy(p.Magic[:], FileMagic)
+	if err := p.setSegments([][]byte{kernel, initrd, nil}, LZMA); err != nil {
+		t.Fatal(err)
+	}
+	p.updateOffsets()
+
+	newKernel := bytes.Repeat([]byte("new kernel"), 0x200)
+	if err := p.ReplaceSegment(0, newKernel, 0x200000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if p.Segs[2].LoadAddress != 0x200200 {
+		t.Errorf("entry: got %#x, want %#x", p.Segs[2].LoadAddress, 0x200200)
+	}
+	if p.Segs[0].MemSize != uint32(len(newKernel)) || p.Segs[0].Compression != LZMA {
+		t.Errorf("kernel segment: got %v", p.Segs[0].String())
+	}
+	if err := p.ReplaceSegment(1, initrd, 0x201000, 0); err == nil {
+		t.Errorf("ReplaceSegment overlapping the kernel: got nil, want error")
+	}
+	if err := p.ReplaceSegment(2, initrd, 0x4000000, 0); err == nil {
+		t.Errorf("ReplaceSegment of the entry: got nil, want error")
+	}
+
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Remove("fallback/payload"); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range n.Segs {
+		r, ok := s.(*PayloadRecord)
+		if !ok || r.Name != "fallback/payload" {
+			continue
+		}
+		for x, want := range [][]byte{newKernel, initrd} {
+			got, err := r.SegmentData(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("segment %d changed", x)
+			}
+		}
+		return
+	}
+	t.Errorf("payload not found")
+}
+
+func TestMasterHeader(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := i.Master()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := i.HeaderPointer(); err != nil || p != i.headerOffset(m) {
+		t.Fatalf("HeaderPointer: got %#x, %v, want %#x", p, err, i.headerOffset(m))
+	}
+
+	// Put the image at the top of a flash twice the size.
+	size := uint32(len(b))
+	rom := append(ffbyte(size), b...)
+	fm := *i.FMAP
+	fm.Size *= 2
+	fm.Areas = append([]fmap.Area{}, fm.Areas...)
+	for x := range fm.Areas {
+		fm.Areas[x].Offset += size
+	}
+	var fb bytes.Buffer
+	if err := binary.Write(&fb, binary.LittleEndian, fm.Header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&fb, binary.LittleEndian, fm.Areas); err != nil {
+		t.Fatal(err)
+	}
+	copy(rom[uint64(size)+i.FMAPMetadata.Start:], fb.Bytes())
+	// Break the pointer, too.
+	copy(rom[len(rom)-4:], []byte{0, 0, 0, 0})
+
+	i, err = NewImage(bytes.NewReader(rom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err = n.Master(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset != size+0x200 || m.RomSize != 2*size {
+		t.Errorf("master header: got offset %#x, romsize %#x; want %#x, %#x", m.Offset, m.RomSize, size+0x200, 2*size)
+	}
+	if p, err := n.HeaderPointer(); err != nil || p != n.headerOffset(m) {
+		t.Errorf("HeaderPointer: got %#x, %v, want %#x", p, err, n.headerOffset(m))
+	}
+}
+
+func TestPrint(t *testing.T) {
+	f, err := os.Open("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	i, err := NewImage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := i.Print(&b, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `FMAP REGION: COREBOOT
+Name                           Offset     Type           Size   Comp
+cbfs master header             0x0        cbfs header        32 none
+fallback/romstage              0x80       stage           15812 none
+fallback/ramstage              0x3ec0     stage           52417 none
+config                         0x10bc0    raw               355 none
+`
+	if !strings.HasPrefix(b.String(), want) {
+		t.Errorf("Print: got\n%s\nwant prefix\n%s", b.String(), want)
+	}
+	if !strings.Contains(b.String(), "compression_test2              0x12f80    raw                74 LZMA\n") {
+		t.Errorf("Print: no compression_test2 in\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := i.Print(&b, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"compression_test1              0x12ec0    raw                90 LZ4 (13312 decompressed)\n",
+		" cbfs_file=0x12ec0, offset=0x12efc, size=0x5a\n",
+		"    compression: LZ4, 13312 -> 90 bytes (0.7%)\n",
+		"    none compression, entry: 0xfffc0320, load: 0xfffc0300, memlen: 15784\n",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("Print -v: no %q in\n%s", s, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := i.Layout(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"'FMAP' (size 512, offset 0)\n", "'COREBOOT' (CBFS, size 261632, offset 512)\n"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("Layout: no %q in\n%s", s, b.String())
+		}
+	}
+}
+
+// microcodeUpdate returns a minimal Intel microcode update with a valid
+// checksum.
+func microcodeUpdate(rev uint32) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint32(b[0:], 1)
+	binary.LittleEndian.PutUint32(b[4:], rev)
+	binary.LittleEndian.PutUint32(b[20:], 1)
+	binary.LittleEndian.PutUint32(b[28:], 16)
+	binary.LittleEndian.PutUint32(b[32:], 64)
+	var sum uint32
+	for x := 0; x < len(b); x += 4 {
+		sum += binary.LittleEndian.Uint32(b[x:])
+	}
+	binary.LittleEndian.PutUint32(b[16:], -sum)
+	return b
+}
+
+func TestFIT(t *testing.T) {
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put an empty FIT with room for 2 entries into the bootblock and
+	// point to it.
+	const fitOff, max = 0x3fca0, 2
+	copy(b[fitOff:], append([]byte("_FIT_   \x01\x00\x00\x00\x00\x01\x00\x00"), make([]byte, max*16)...))
+	binary.LittleEndian.PutUint64(b[len(b)-0x40:], 1<<32-uint64(len(b))+fitOff)
+
+	i, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tab, off, err := i.FIT(); err != nil || off != fitOff || len(tab) != 1 {
+		t.Fatalf("FIT: got %v, %#x, %v, want 1 entry at %#x", tab, off, err, fitOff)
+	}
+	if _, err := i.AddFITMicrocode(MicrocodeBlobName, max); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("AddFITMicrocode without blob: got %v, want %v", err, os.ErrNotExist)
+	}
+	blob := append(append(microcodeUpdate(1), microcodeUpdate(2)...), microcodeUpdate(3)...)
+	r, err := NewRawFile(MicrocodeBlobName, append(blob, ffbyte(64)...), None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Type = TypeMicroCode
+	if err := i.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	n, err := i.AddFITMicrocode(MicrocodeBlobName, max)
+	if err != nil || n != max {
+		t.Fatalf("AddFITMicrocode: got %d, %v, want %d", n, err, max)
+	}
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	i, err = NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bootblock must hold the new table, so it survives an Update.
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	tab, _, err := i.FIT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tab) != max+1 {
+		t.Fatalf("FIT: got %d entries, want %d", len(tab), max+1)
+	}
+	var ucode *File
+	for _, s := range i.Segs {
+		if s.GetFile().Name == MicrocodeBlobName {
+			ucode = s.GetFile()
+		}
+	}
+	for x, e := range tab[1:] {
+		off := e.Address.Offset(uint64(len(i.Data)))
+		want := uint64(i.Area.Offset + ucode.RecordStart + ucode.SubHeaderOffset + uint32(x*64))
+		if e.Type() != 1 || off != want {
+			t.Errorf("entry %d: got type %v at %#x, want microcode at %#x", x+1, e.Type(), off, want)
+		}
+	}
+	if n, err := i.RemoveFITEntries(1); err != nil || n != max {
+		t.Fatalf("RemoveFITEntries: got %d, %v, want %d", n, err, max)
+	}
+	if tab, _, err := i.FIT(); err != nil || len(tab) != 1 {
+		t.Errorf("FIT after RemoveFITEntries: got %v, %v, want only the header", tab, err)
+	}
+	if !bytes.Equal(i.Data[fitOff+16:fitOff+16+max*16], make([]byte, max*16)) {
+		t.Errorf("removed entries were not cleared")
+	}
+}
@@ -5,11 +5,19 @@
 package cbfs
 
 import (
-	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"log"
 )
 
+// MasterHeaderMagic is the magic of the master header, "ORBC".
+const MasterHeaderMagic = 0x4f524243
+
+// headerPointerLen is the size of the pointer to the master header at the
+// end of x86 images.
+const headerPointerLen = 4
+
 func init() {
 	if err := RegisterFileReader(&SegReader{Type: 2, Name: "CBFSMaster", New: NewMaster}); err != nil {
 		log.Fatal(err)
@@ -23,12 +31,6 @@ func NewMaster(f *File) (ReadWriter, error) {
 }
 
 func (r *MasterRecord) Read(in io.ReadSeeker) error {
-	dump := &bytes.Buffer{}
-	n, err := io.Copy(dump, in)
-	if err != nil {
-		return err
-	}
-	Debug("MasterRecord (%d bytes)\n   %+v\n   %x", n, r, dump)
 	Debug("MasterRecord Header %v at %v", r.MasterHeader, r.Offset)
 	if err := Read(in, &r.MasterHeader); err != nil {
 		Debug("MasterRecord read from %v: %v", r.Offset, err)
@@ -51,3 +53,94 @@ func (r *MasterRecord) Write(w io.Writer) error {
 func (r *MasterRecord) GetFile() *File {
 	return &r.File
 }
+
+// Master returns the master header record of the image.
+func (i *Image) Master() (*MasterRecord, error) {
+	for _, s := range i.Segs {
+		if m, ok := s.(*MasterRecord); ok {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no CBFS master header in %s", i.Area.Name.String())
+}
+
+// headerOffset returns the offset of the master header within Data.
+func (i *Image) headerOffset(m *MasterRecord) uint32 {
+	return i.Area.Offset + m.RecordStart + m.SubHeaderOffset
+}
+
+// HeaderPointer returns the offset within Data of the master header, as
+// found through the pointer in the last 4 bytes of the image. The pointer
+// is a signed offset relative to the end of the image, which on x86 equals
+// the memory mapped address of the header.
+func (i *Image) HeaderPointer() (uint32, error) {
+	if len(i.Data) < headerPointerLen {
+		return 0, fmt.Errorf("image too small for a master header pointer")
+	}
+	rel := int64(int32(binary.LittleEndian.Uint32(i.Data[len(i.Data)-headerPointerLen:])))
+	off := int64(len(i.Data)) + rel
+	if off < 0 || off+MasterHeaderLen > int64(len(i.Data)) {
+		return 0, fmt.Errorf("master header pointer %#x points outside of the image", uint32(rel))
+	}
+	return uint32(off), nil
+}
+
+// pointsToHeader returns true if the image ends with a pointer to the
+// master header, i.e. it is an x86 image with the bootblock at the end of
+// the CBFS, and the CBFS at the end of the image.
+func (i *Image) pointsToHeader() bool {
+	if len(i.Segs) == 0 || uint64(i.Area.Offset)+uint64(i.Area.Size) != uint64(len(i.Data)) {
+		return false
+	}
+	if _, err := i.Master(); err != nil {
+		return false
+	}
+	return i.Segs[len(i.Segs)-1].GetFile().Type == TypeBootBlock
+}
+
+// SetHeaderPointer points the pointer at the end of the image to the
+// master header. On x86 images, the pointer is within the bootblock, which
+// is patched as well.
+func (i *Image) SetHeaderPointer() error {
+	m, err := i.Master()
+	if err != nil {
+		return err
+	}
+	if len(i.Data) < headerPointerLen {
+		return fmt.Errorf("image too small for a master header pointer")
+	}
+	ptr := make([]byte, headerPointerLen)
+	binary.LittleEndian.PutUint32(ptr, uint32(int32(int64(i.headerOffset(m))-int64(len(i.Data)))))
+	i.patch(uint32(len(i.Data)-headerPointerLen), ptr)
+	Debug("SetHeaderPointer: master header at %#x", i.headerOffset(m))
+	return nil
+}
+
+// UpdateMasterHeader makes the master header describe the CBFS area: its
+// offset and where it ends. It is called by Update, which also keeps the
+// pointer to the header at the end of x86 images up to date.
+func (i *Image) UpdateMasterHeader() error {
+	m, err := i.Master()
+	if err != nil {
+		return err
+	}
+	if m.Magic != MasterHeaderMagic {
+		return fmt.Errorf("master header magic %#x, want %#x", m.Magic, MasterHeaderMagic)
+	}
+	m.Offset = i.Area.Offset
+	m.RomSize = i.Area.Offset + i.Area.Size
+	return nil
+}
+
+// patch writes b to Data at off. If that is within the bootblock, its file
+// data is patched as well, so that Update writes and hashes the new bytes.
+func (i *Image) patch(off uint32, b []byte) {
+	copy(i.Data[off:], b)
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		start := i.Area.Offset + f.RecordStart + f.SubHeaderOffset
+		if f.Type == TypeBootBlock && off >= start && uint64(off)+uint64(len(b)) <= uint64(start)+uint64(len(f.FData)) {
+			copy(f.FData[off-start:], b)
+		}
+	}
+}
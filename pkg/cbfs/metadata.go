@@ -0,0 +1,203 @@
+// Copyright 2018-2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+)
+
+// MetadataHashAnchorMagic starts the metadata hash anchor, which coreboot
+// compiles into the bootblock when CONFIG_CBFS_VERIFICATION is enabled.
+const MetadataHashAnchorMagic = "\xadMdtHsh\x15"
+
+// ErrNoMetadataHashAnchor is returned if the image has no metadata hash
+// anchor, i.e. it was built without CBFS verification.
+var ErrNoMetadataHashAnchor = errors.New("no CBFS metadata hash anchor found")
+
+// MetadataHashAnchor holds the hash over the CBFS metadata, i.e. the
+// headers, names and attributes of all files, and optionally the hash of
+// the FMAP. The FMAP hash directly follows the CBFS digest, so the layout
+// depends on the CBFS hash algorithm.
+type MetadataHashAnchor struct {
+	CBFSHashType HashAlgorithm
+	CBFSHash     []byte
+	FMAPHashType HashAlgorithm
+	FMAPHash     []byte
+}
+
+// parseAnchor parses the anchor at the start of b.
+func parseAnchor(b []byte) (*MetadataHashAnchor, error) {
+	var a MetadataHashAnchor
+	off := len(MetadataHashAnchorMagic)
+	hash := func() (HashAlgorithm, []byte, error) {
+		if len(b) < off+4 {
+			return HashNone, nil, fmt.Errorf("metadata hash anchor truncated")
+		}
+		t := HashAlgorithm(Endian.Uint32(b[off:]))
+		off += 4
+		if t == HashNone {
+			return t, nil, nil
+		}
+		h, err := t.new()
+		if err != nil {
+			return t, nil, err
+		}
+		if len(b) < off+h.Size() {
+			return t, nil, fmt.Errorf("metadata hash anchor truncated")
+		}
+		d := b[off : off+h.Size()]
+		off += h.Size()
+		return t, d, nil
+	}
+	var err error
+	if a.CBFSHashType, a.CBFSHash, err = hash(); err != nil {
+		return nil, err
+	}
+	if a.CBFSHashType == HashNone {
+		return nil, fmt.Errorf("metadata hash anchor without CBFS hash")
+	}
+	// The FMAP hash is optional. Anything but a known algorithm means
+	// there is none.
+	if a.FMAPHashType, a.FMAPHash, err = hash(); err != nil {
+		a.FMAPHashType, a.FMAPHash = HashNone, nil
+	}
+	return &a, nil
+}
+
+// anchor returns the buffer holding the metadata hash anchor and its
+// offset within. The anchor lives in the bootblock, which is either a
+// CBFS file or, on non-x86 platforms, the BOOTBLOCK FMAP area.
+// Only the read-only CBFS is covered by the anchor; RW slots are verified
+// by the vboot preamble.
+func (i *Image) anchor() (*File, []byte, int, error) {
+	if i.Area.Name.String() != DefaultRegion {
+		return nil, nil, 0, ErrNoMetadataHashAnchor
+	}
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		if f.Type != TypeBootBlock {
+			continue
+		}
+		if off := bytes.Index(f.FData, []byte(MetadataHashAnchorMagic)); off != -1 {
+			return f, f.FData, off, nil
+		}
+	}
+	if x := i.FMAP.IndexOfArea("BOOTBLOCK"); x != -1 {
+		a := i.FMAP.Areas[x]
+		if uint64(a.Offset)+uint64(a.Size) <= uint64(len(i.Data)) {
+			b := i.Data[a.Offset : a.Offset+a.Size]
+			if off := bytes.Index(b, []byte(MetadataHashAnchorMagic)); off != -1 {
+				return nil, b, off, nil
+			}
+		}
+	}
+	return nil, nil, 0, ErrNoMetadataHashAnchor
+}
+
+// MetadataHashAnchor returns the metadata hash anchor of the image.
+func (i *Image) MetadataHashAnchor() (*MetadataHashAnchor, error) {
+	_, b, off, err := i.anchor()
+	if err != nil {
+		return nil, err
+	}
+	return parseAnchor(b[off:])
+}
+
+// MetadataHash returns the hash over the metadata of all non-empty files,
+// the way coreboot's cbfs_walk computes it.
+func (i *Image) MetadataHash(h HashAlgorithm) ([]byte, error) {
+	var b bytes.Buffer
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		if f.Deleted() {
+			continue
+		}
+		if err := f.writeHeader(&b); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(b.Bytes())
+}
+
+// fmapHash returns the hash over the FMAP as found in Data.
+func (i *Image) fmapHash(h HashAlgorithm) ([]byte, error) {
+	start := i.FMAPMetadata.Start
+	end := start + uint64(binary.Size(fmap.Header{})+int(i.FMAP.NAreas)*binary.Size(fmap.Area{}))
+	if end > uint64(len(i.Data)) {
+		return nil, fmt.Errorf("FMAP at %#x exceeds the image", start)
+	}
+	return h.Sum(i.Data[start:end])
+}
+
+// VerifyMetadataHash checks the hashes in the metadata hash anchor. If the
+// image has none, ErrNoMetadataHashAnchor is returned.
+func (i *Image) VerifyMetadataHash() error {
+	a, err := i.MetadataHashAnchor()
+	if err != nil {
+		return err
+	}
+	sum, err := i.MetadataHash(a.CBFSHashType)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, a.CBFSHash) {
+		return fmt.Errorf("CBFS metadata %w: %v is %#x, want %#x", ErrHashMismatch, a.CBFSHashType, sum, a.CBFSHash)
+	}
+	if a.FMAPHashType == HashNone {
+		return nil
+	}
+	if sum, err = i.fmapHash(a.FMAPHashType); err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, a.FMAPHash) {
+		return fmt.Errorf("FMAP %w: %v is %#x, want %#x", ErrHashMismatch, a.FMAPHashType, sum, a.FMAPHash)
+	}
+	return nil
+}
+
+// UpdateMetadataHash recomputes the hashes in the metadata hash anchor
+// from the current headers and FMAP and patches the anchor, in the
+// bootblock file as well as in Data. Images without an anchor are left
+// alone. It is called by Update.
+func (i *Image) UpdateMetadataHash() error {
+	f, b, off, err := i.anchor()
+	if err == ErrNoMetadataHashAnchor {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Patching the anchor changes the bootblock, and with it its hash
+	// and thus the metadata. There is no way to get this consistent.
+	if f != nil && f.HasAttribute(Hash) {
+		return fmt.Errorf("%q holds the metadata hash anchor and can not be hashed itself", f.Name)
+	}
+	a, err := parseAnchor(b[off:])
+	if err != nil {
+		return err
+	}
+	sum, err := i.MetadataHash(a.CBFSHashType)
+	if err != nil {
+		return err
+	}
+	Debug("UpdateMetadataHash: CBFS metadata %v is %#x", a.CBFSHashType, sum)
+	off += len(MetadataHashAnchorMagic) + 4
+	copy(b[off:], sum)
+	if a.FMAPHashType != HashNone {
+		if sum, err = i.fmapHash(a.FMAPHashType); err != nil {
+			return err
+		}
+		copy(b[off+len(a.CBFSHash)+4:], sum)
+	}
+	if f != nil {
+		copy(i.Data[i.Area.Offset+f.RecordStart+f.SubHeaderOffset:], f.FData)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@
 package cbfs
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
@@ -48,6 +49,7 @@ func (p *PayloadRecord) Read(in io.ReadSeeker) error {
 	// This _may_ happen. E.g. with the test payload here. Silently ignore.
 	if bodySize == 0 {
 		Debug("Payload empty, nothing to read")
+		p.FData = nil
 		return nil
 	}
 	p.FData = make([]byte, bodySize)
@@ -78,6 +80,174 @@ func (r *PayloadHeader) String() string {
 		r.MemSize)
 }
 
+// headerLen returns the length of the segment table in front of the body.
+func (p *PayloadRecord) headerLen() uint32 {
+	return uint32(len(p.Segs) * binary.Size(PayloadHeader{}))
+}
+
+// SegmentData returns the decompressed data of segment n.
+// Segments without data, like BSS or entry, return nil.
+func (p *PayloadRecord) SegmentData(n int) ([]byte, error) {
+	if n < 0 || n >= len(p.Segs) {
+		return nil, fmt.Errorf("segment %d out of range [0, %d)", n, len(p.Segs))
+	}
+	h := p.Segs[n]
+	b, err := p.rawSegment(n)
+	if err != nil || b == nil || h.Compression == None {
+		return b, err
+	}
+	c, err := compressor(h.Compression)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(b)
+}
+
+// Compress recompresses the code and data segments with c, like
+// cbfstool does when adding a payload, and lays out the body again.
+func (p *PayloadRecord) Compress(c Compression) error {
+	var data [][]byte
+	for n := range p.Segs {
+		d, err := p.SegmentData(n)
+		if err != nil {
+			return err
+		}
+		data = append(data, d)
+	}
+	return p.setSegments(data, c)
+}
+
+// setSegments rebuilds the body from the decompressed data of each
+// segment, compressing code and data segments with c, and updates the
+// segment offsets and sizes as well as the file size.
+func (p *PayloadRecord) setSegments(data [][]byte, c Compression) error {
+	raw := make([][]byte, len(p.Segs))
+	for n := range p.Segs {
+		h := &p.Segs[n]
+		if h.Type != SegCode && h.Type != SegData {
+			continue
+		}
+		h.Compression = c
+		b, err := p.encodeSegment(n, data[n])
+		if err != nil {
+			return err
+		}
+		raw[n] = b
+	}
+	p.layout(raw)
+	return nil
+}
+
+// encodeSegment compresses data as given by segment n.
+func (p *PayloadRecord) encodeSegment(n int, data []byte) ([]byte, error) {
+	c := p.Segs[n].Compression
+	if c == None {
+		return data, nil
+	}
+	comp, err := compressor(c)
+	if err != nil {
+		return nil, err
+	}
+	b, err := comp.Encode(data)
+	if err != nil {
+		return nil, fmt.Errorf("compressing segment %d with %v: %v", n, c, err)
+	}
+	return b, nil
+}
+
+// rawSegment returns the data of segment n as stored in the body.
+func (p *PayloadRecord) rawSegment(n int) ([]byte, error) {
+	h := p.Segs[n]
+	if h.Type != SegCode && h.Type != SegData {
+		return nil, nil
+	}
+	start := int64(h.Offset) - int64(p.headerLen())
+	if start < 0 || start+int64(h.Size) > int64(len(p.FData)) {
+		return nil, fmt.Errorf("segment %d [%#x, %#x) outside of payload body", n, h.Offset, h.Offset+h.Size)
+	}
+	return p.FData[start : start+int64(h.Size)], nil
+}
+
+// layout builds the body from the stored data of each segment and updates
+// the segment offsets and sizes as well as the file size.
+func (p *PayloadRecord) layout(raw [][]byte) {
+	var body []byte
+	off := p.headerLen()
+	for n := range p.Segs {
+		h := &p.Segs[n]
+		if h.Type != SegCode && h.Type != SegData {
+			continue
+		}
+		h.Offset = off + uint32(len(body))
+		h.Size = uint32(len(raw[n]))
+		body = append(body, raw[n]...)
+	}
+	p.FData = body
+	p.Size = p.headerLen() + uint32(len(body))
+}
+
+// ReplaceSegment replaces the data of code or data segment n, e.g. the
+// kernel or the initrd of a LinuxBoot payload, keeping its compression.
+// The segment is loaded at load and takes memSize bytes of memory, at
+// least len(data). If the entry point lies within the segment, it moves
+// along with it. The other segments are kept as they are, only their
+// offsets change.
+func (p *PayloadRecord) ReplaceSegment(n int, data []byte, load uint64, memSize uint32) error {
+	if n < 0 || n >= len(p.Segs) {
+		return fmt.Errorf("segment %d out of range [0, %d)", n, len(p.Segs))
+	}
+	old := p.Segs[n]
+	if old.Type != SegCode && old.Type != SegData {
+		return fmt.Errorf("segment %d is a %v segment, not code or data", n, old.Type)
+	}
+	if uint64(memSize) < uint64(len(data)) {
+		memSize = uint32(len(data))
+	}
+	end := load + uint64(memSize)
+	for x, h := range p.Segs {
+		if x == n || (h.Type != SegCode && h.Type != SegData && h.Type != SegBSS) {
+			continue
+		}
+		if load < h.LoadAddress+uint64(h.MemSize) && h.LoadAddress < end {
+			return fmt.Errorf("segment %d [%#x, %#x) would overlap segment %d [%#x, %#x)",
+				n, load, end, x, h.LoadAddress, h.LoadAddress+uint64(h.MemSize))
+		}
+	}
+	raw := make([][]byte, len(p.Segs))
+	for x := range p.Segs {
+		b, err := p.rawSegment(x)
+		if err != nil {
+			return err
+		}
+		raw[x] = b
+	}
+	b, err := p.encodeSegment(n, data)
+	if err != nil {
+		return err
+	}
+	raw[n] = b
+	for x, h := range p.Segs {
+		if h.Type == SegEntry && h.LoadAddress >= old.LoadAddress && h.LoadAddress < old.LoadAddress+uint64(old.MemSize) {
+			p.Segs[x].LoadAddress = h.LoadAddress - old.LoadAddress + load
+		}
+	}
+	p.Segs[n].LoadAddress = load
+	p.Segs[n].MemSize = memSize
+	p.layout(raw)
+	return nil
+}
+
+// SetEntry sets the entry point of the payload.
+func (p *PayloadRecord) SetEntry(entry uint64) error {
+	for x, h := range p.Segs {
+		if h.Type == SegEntry {
+			p.Segs[x].LoadAddress = entry
+			return nil
+		}
+	}
+	return fmt.Errorf("%q has no entry segment", p.Name)
+}
+
 func (r *PayloadRecord) Write(w io.Writer) error {
 	if err := Write(w, r.Segs); err != nil {
 		return err
@@ -88,3 +258,65 @@ func (r *PayloadRecord) Write(w io.Writer) error {
 func (r *PayloadRecord) GetFile() *File {
 	return &r.File
 }
+
+// Info describes a SELF holding a stage. The load address and compression
+// are those of the first code or data segment and the memory size spans
+// all loaded segments.
+func (r *PayloadRecord) Info() StageInfo {
+	var i StageInfo
+	var end uint64
+	first := true
+	for _, h := range r.Segs {
+		switch h.Type {
+		case SegEntry:
+			i.Entry = h.LoadAddress
+			continue
+		case SegCode, SegData:
+			if first {
+				i.Compression = h.Compression
+			}
+		case SegBSS:
+		default:
+			continue
+		}
+		if first || h.LoadAddress < i.LoadAddress {
+			i.LoadAddress = h.LoadAddress
+		}
+		if e := h.LoadAddress + uint64(h.MemSize); e > end {
+			end = e
+		}
+		first = false
+	}
+	i.MemSize = uint32(end - i.LoadAddress)
+	return i
+}
+
+// StageData returns the program of a SELF with a single code segment.
+func (r *PayloadRecord) StageData() ([]byte, error) {
+	n := -1
+	for x, h := range r.Segs {
+		if h.Type != SegCode && h.Type != SegData {
+			continue
+		}
+		if n != -1 {
+			return nil, fmt.Errorf("%q has more than one code or data segment", r.Name)
+		}
+		n = x
+	}
+	if n == -1 {
+		return nil, fmt.Errorf("%q has no code segment", r.Name)
+	}
+	return r.SegmentData(n)
+}
+
+// SetStageData replaces all segments by one code and one entry segment.
+func (r *PayloadRecord) SetStageData(data []byte, info StageInfo) error {
+	if err := checkStage(data, &info); err != nil {
+		return err
+	}
+	r.Segs = []PayloadHeader{
+		{Type: SegCode, LoadAddress: info.LoadAddress, MemSize: info.MemSize},
+		{Type: SegEntry, LoadAddress: info.Entry},
+	}
+	return r.setSegments([][]byte{data, nil}, info.Compression)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018-2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+)
+
+// cbfstoolTypes are the file type names used by cbfstool print.
+var cbfstoolTypes = map[FileType]string{
+	TypeDeleted2:    "null",
+	TypeDeleted:     "deleted",
+	TypeMaster:      "cbfs header",
+	TypeBootBlock:   "bootblock",
+	TypeLegacyStage: "stage",
+	TypeStage:       "stage",
+	TypeSELF:        "simple elf",
+	TypeFIT:         "fit",
+	TypeOptionRom:   "optionrom",
+	TypeBootSplash:  "bootsplash",
+	TypeRaw:         "raw",
+	TypeVSA:         "vsa",
+	TypeMBI:         "mbi",
+	TypeMicroCode:   "microcode",
+	TypeFSP:         "fsp",
+	TypeMRC:         "mrc",
+	TypeMMA:         "mma",
+	TypeEFI:         "efi",
+	TypeStruct:      "struct",
+	TypeCMOS:        "cmos_default",
+	TypeSPD:         "spd",
+	TypeMRCCache:    "mrc_cache",
+	TypeCMOSLayout:  "cmos_layout",
+}
+
+// cbfstoolType returns the cbfstool name of t.
+func cbfstoolType(t FileType) string {
+	if n, ok := cbfstoolTypes[t]; ok {
+		return n
+	}
+	return "(unknown)"
+}
+
+// cbfstoolCompression returns the compression name used by cbfstool.
+func cbfstoolCompression(c Compression) string {
+	switch c {
+	case None:
+		return "none"
+	case LZMA:
+		return "LZMA"
+	case LZ4:
+		return "LZ4"
+	}
+	return "(unknown)"
+}
+
+// Print writes the list of files like cbfstool print. If verbose is set,
+// it adds the details of cbfstool print -v: the position of header and
+// data, stage and payload information, attributes, hashes and compression
+// ratios.
+func (i *Image) Print(w io.Writer, verbose bool) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "FMAP REGION: %s\n", i.Area.Name.String())
+	fmt.Fprintf(b, "%-30s %-10s %-14s %-6s %s\n", "Name", "Offset", "Type", "Size", "Comp")
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		name := f.Name
+		if f.Deleted() {
+			name = "(empty)"
+		}
+		comp := cbfstoolCompression(f.Compression())
+		if verbose && f.Compression() != None {
+			if a, err := f.compressionAttribute(); err == nil {
+				comp = fmt.Sprintf("%s (%d decompressed)", comp, a.DecompressedSize)
+			}
+		}
+		fmt.Fprintf(b, "%-30s 0x%-8x %-14s %6d %s\n", name, f.RecordStart, cbfstoolType(f.Type), f.Size, comp)
+		if verbose {
+			printDetails(b, s)
+		}
+	}
+	return b.Flush()
+}
+
+// printDetails writes the verbose information about s.
+func printDetails(w io.Writer, s ReadWriter) {
+	f := s.GetFile()
+	fmt.Fprintf(w, " cbfs_file=0x%x, offset=0x%x, size=0x%x\n", f.RecordStart, f.RecordStart+f.SubHeaderOffset, f.Size)
+	switch r := s.(type) {
+	case *PayloadRecord:
+		for n, h := range r.Segs {
+			if h.Type == SegEntry {
+				fmt.Fprintf(w, "    %s (entry: 0x%x)\n", h.Type, h.LoadAddress)
+				continue
+			}
+			fmt.Fprintf(w, "    %s (%s compression, offset: 0x%x, load: 0x%x, length: %d/%d)\n",
+				h.Type, cbfstoolCompression(h.Compression), h.Offset, h.LoadAddress, h.Size, h.MemSize)
+			if h.Compression != None {
+				if d, err := r.SegmentData(n); err == nil {
+					fmt.Fprintf(w, "      %s\n", ratio(h.Size, len(d)))
+				}
+			}
+		}
+	case Stage:
+		info := r.Info()
+		fmt.Fprintf(w, "    %s compression, entry: 0x%x, load: 0x%x, memlen: %d\n",
+			cbfstoolCompression(info.Compression), info.Entry, info.LoadAddress, info.MemSize)
+	}
+	for _, a := range f.attributes() {
+		t := Tag(Endian.Uint32(a))
+		switch t {
+		case Compressed:
+			if c, err := f.compressionAttribute(); err == nil && c.Compression != None {
+				fmt.Fprintf(w, "    compression: %s, %s\n", cbfstoolCompression(c.Compression), ratio(f.Size, int(c.DecompressedSize)))
+			}
+		case Hash:
+			h, err := f.Hash()
+			if err != nil {
+				fmt.Fprintf(w, "    hash: %v\n", err)
+				continue
+			}
+			valid := "valid"
+			if err := f.VerifyHash(); err != nil {
+				valid = "invalid"
+			}
+			fmt.Fprintf(w, "    hash %v:%x %s\n", h.HashType, h.Data, valid)
+		case PSCB:
+			fmt.Fprintf(w, "    position: 0x%x\n", Endian.Uint32(a[8:]))
+		case ALCB:
+			fmt.Fprintf(w, "    alignment: 0x%x\n", Endian.Uint32(a[8:]))
+		case SHCB:
+			// Shown with the stage above.
+		default:
+			fmt.Fprintf(w, "    attribute %#08x, %d bytes\n", uint32(t), len(a))
+		}
+	}
+}
+
+// ratio describes the compression of size bytes down from orig bytes.
+func ratio(size uint32, orig int) string {
+	if orig == 0 {
+		return fmt.Sprintf("%d -> %d bytes", orig, size)
+	}
+	return fmt.Sprintf("%d -> %d bytes (%.1f%%)", orig, size, 100*float64(size)/float64(orig))
+}
+
+// compressionAttribute returns the parsed compression attribute.
+func (f *File) compressionAttribute() (*FileAttrCompression, error) {
+	b, err := f.FindAttribute(Compressed)
+	if err != nil {
+		return nil, err
+	}
+	var a FileAttrCompression
+	if err := Read(bytes.NewReader(b), &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Layout writes the FMAP areas like cbfstool layout, marking those that
+// hold a CBFS.
+func (i *Image) Layout(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	cbfs := map[string]bool{}
+	for _, r := range i.Regions() {
+		cbfs[r] = true
+	}
+	fmt.Fprintf(b, "This image contains the following sections that can be manipulated with this tool:\n\n")
+	for _, a := range i.FMAP.Areas {
+		n := a.Name.String()
+		var kind string
+		if cbfs[n] {
+			kind += "CBFS, "
+		}
+		if a.Flags&fmap.FmapAreaReadOnly != 0 {
+			kind += "read-only, "
+		}
+		fmt.Fprintf(b, "'%s' (%ssize %d, offset %d)\n", n, kind, a.Size, a.Offset)
+	}
+	fmt.Fprintf(b, "\nIt is at least possible to perform the read action on every section listed above.\n")
+	return b.Flush()
+}
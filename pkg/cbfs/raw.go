@@ -20,6 +20,16 @@ func NewRaw(f *File) (ReadWriter, error) {
 	return rec, nil
 }
 
+// NewRawFile creates a raw file named n holding data, compressed with c.
+func NewRawFile(n string, data []byte, c Compression) (*RawRecord, error) {
+	f := File{Name: n, FileHeader: FileHeader{Type: TypeRaw}}
+	copy(f.Magic[:], FileMagic)
+	if err := f.SetData(data, c); err != nil {
+		return nil, err
+	}
+	return &RawRecord{File: f}, nil
+}
+
 func (r *RawRecord) Read(in io.ReadSeeker) error {
 	return nil
 }
@@ -5,6 +5,8 @@
 package cbfs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -20,6 +22,48 @@ func init() {
 	}
 }
 
+// StageInfo describes where a stage is loaded and entered.
+type StageInfo struct {
+	LoadAddress uint64
+	Entry       uint64
+	MemSize     uint32
+	Compression Compression
+}
+
+func (s StageInfo) String() string {
+	return fmt.Sprintf("LoadAddress %#x Entry %#x MemSize %#x Compression %v",
+		s.LoadAddress, s.Entry, s.MemSize, s.Compression)
+}
+
+// Stage is implemented by the records which hold a coreboot stage: legacy
+// stages, stages with a stage header attribute and stages stored as SELF.
+type Stage interface {
+	ReadWriter
+	// Info returns the load address, entry point, memory size and
+	// compression of the stage.
+	Info() StageInfo
+	// StageData returns the decompressed program.
+	StageData() ([]byte, error)
+	// SetStageData replaces the program by data, compressed as given in
+	// info. Update must be called to write the result to the image, and
+	// fails if the record no longer fits.
+	SetStageData(data []byte, info StageInfo) error
+}
+
+// checkStage validates info for data.
+func checkStage(data []byte, info *StageInfo) error {
+	if info.MemSize == 0 {
+		info.MemSize = uint32(len(data))
+	}
+	if uint64(info.MemSize) < uint64(len(data)) {
+		return fmt.Errorf("stage memory size %#x is smaller than its %#x bytes", info.MemSize, len(data))
+	}
+	if info.Entry < info.LoadAddress || info.Entry >= info.LoadAddress+uint64(info.MemSize) {
+		return fmt.Errorf("stage entry %#x outside of [%#x, %#x)", info.Entry, info.LoadAddress, info.LoadAddress+uint64(info.MemSize))
+	}
+	return nil
+}
+
 func NewLegacyStageRecord(f *File) (ReadWriter, error) {
 	r := &LegacyStageRecord{File: *f}
 	return r, nil
@@ -46,6 +90,9 @@ type mStage struct {
 	Size        uint32
 	Type        string
 	Compression string
+	LoadAddress uint64
+	Entry       uint64
+	MemSize     uint32
 }
 
 func (h *LegacyStageRecord) MarshalJSON() ([]byte, error) {
@@ -54,7 +101,10 @@ func (h *LegacyStageRecord) MarshalJSON() ([]byte, error) {
 		Start:       h.RecordStart,
 		Size:        h.Size,
 		Type:        h.Type.String(),
-		Compression: h.File.Compression().String(),
+		Compression: h.StageHeader.Compression.String(),
+		LoadAddress: h.LoadAddress,
+		Entry:       h.Entry,
+		MemSize:     h.MemSize,
 	}
 
 	return json.Marshal(s)
@@ -85,15 +135,103 @@ func (r *LegacyStageRecord) GetFile() *File {
 	return &r.File
 }
 
+func (r *LegacyStageRecord) Info() StageInfo {
+	return StageInfo{
+		LoadAddress: r.LoadAddress,
+		Entry:       r.Entry,
+		MemSize:     r.MemSize,
+		Compression: r.StageHeader.Compression,
+	}
+}
+
+func (r *LegacyStageRecord) StageData() ([]byte, error) {
+	if r.StageHeader.Compression == None {
+		return r.Data, nil
+	}
+	c, err := compressor(r.StageHeader.Compression)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(r.Data)
+}
+
+func (r *LegacyStageRecord) SetStageData(data []byte, info StageInfo) error {
+	if err := checkStage(data, &info); err != nil {
+		return err
+	}
+	enc := data
+	if info.Compression != None {
+		c, err := compressor(info.Compression)
+		if err != nil {
+			return err
+		}
+		if enc, err = c.Encode(data); err != nil {
+			return fmt.Errorf("compressing %q with %v: %v", r.Name, info.Compression, err)
+		}
+	}
+	r.StageHeader = StageHeader{
+		Compression: info.Compression,
+		Entry:       info.Entry,
+		LoadAddress: info.LoadAddress,
+		Size:        uint32(len(enc)),
+		MemSize:     info.MemSize,
+	}
+	r.Data = enc
+	var b bytes.Buffer
+	if err := r.Write(&b); err != nil {
+		return err
+	}
+	r.FData = b.Bytes()
+	r.File.Size = uint32(len(r.FData))
+	return nil
+}
+
 func NewStageRecord(f *File) (ReadWriter, error) {
 	r := &StageRecord{File: *f}
 	return r, nil
 }
 
+// NewStageFile creates a stage named n from the program data.
+func NewStageFile(n string, data []byte, info StageInfo) (*StageRecord, error) {
+	r := &StageRecord{File: File{Name: n, FileHeader: FileHeader{Type: TypeStage}}}
+	copy(r.Magic[:], FileMagic)
+	if err := r.SetStageData(data, info); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read reads the stage header attribute. The file data is the program.
 func (r *StageRecord) Read(in io.ReadSeeker) error {
+	r.Data = r.FData
+	a, err := r.FindAttribute(SHCB)
+	if err != nil {
+		Debug("%q: no stage header: %v", r.Name, err)
+		return nil
+	}
+	if err := Read(bytes.NewReader(a), &r.FileAttrStageHeader); err != nil {
+		return fmt.Errorf("%q: stage header: %v", r.Name, err)
+	}
+	Debug("Got stage header %s", r.FileAttrStageHeader.String())
 	return nil
 }
 
+func (h *StageRecord) MarshalJSON() ([]byte, error) {
+	i := h.Info()
+	s := mStage{
+		Name:        h.File.Name,
+		Start:       h.RecordStart,
+		Size:        h.File.Size,
+		Type:        h.Type.String(),
+		Compression: i.Compression.String(),
+		LoadAddress: i.LoadAddress,
+		Entry:       i.Entry,
+		MemSize:     i.MemSize,
+	}
+
+	return json.Marshal(s)
+}
+
 func (h *FileAttrStageHeader) String() string {
 	return fmt.Sprintf("Size %#x LoadAddress %#x EntryOffset %#x MemSize %#x",
 		h.Size,
@@ -113,3 +251,40 @@ func (r *StageRecord) Write(w io.Writer) error {
 func (r *StageRecord) GetFile() *File {
 	return &r.File
 }
+
+func (r *StageRecord) Info() StageInfo {
+	return StageInfo{
+		LoadAddress: r.LoadAddress,
+		Entry:       r.LoadAddress + uint64(r.EntryOffset),
+		MemSize:     r.MemSize,
+		Compression: r.File.Compression(),
+	}
+}
+
+func (r *StageRecord) StageData() ([]byte, error) {
+	return r.File.Decompress()
+}
+
+func (r *StageRecord) SetStageData(data []byte, info StageInfo) error {
+	if err := checkStage(data, &info); err != nil {
+		return err
+	}
+	if err := r.File.SetData(data, info.Compression); err != nil {
+		return err
+	}
+	r.FileAttrStageHeader = FileAttrStageHeader{
+		Tag:         SHCB,
+		Size:        uint32(binary.Size(FileAttrStageHeader{})),
+		LoadAddress: info.LoadAddress,
+		EntryOffset: uint32(info.Entry - info.LoadAddress),
+		MemSize:     info.MemSize,
+	}
+	var b bytes.Buffer
+	if err := Write(&b, r.FileAttrStageHeader); err != nil {
+		return err
+	}
+	r.setAttribute(SHCB, b.Bytes())
+	r.updateOffsets()
+	r.Data = r.FData
+	return nil
+}
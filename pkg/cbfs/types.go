@@ -86,6 +86,17 @@ const FileMagic = "LARCHIVE"
 
 const FileSize = 24
 
+// nameAlign is the alignment cbfstool pads the NUL terminated names of
+// file headers to, CBFS_FILENAME_ALIGN.
+const nameAlign = 16
+
+// attributeAlign is the alignment of attributes in file headers.
+const attributeAlign = 4
+
+// emptyHeaderLen is the length of the header of an empty record:
+// the file header plus a 16 byte aligned empty name.
+const emptyHeaderLen = 0x28
+
 type FileHeader struct {
 	Magic           [8]byte
 	Size            uint32
@@ -139,10 +150,23 @@ type FileAttrCompression struct {
 type FileAttrHash struct {
 	Tag      Tag
 	Size     uint32 // includes everything including data.
-	HashType uint32
+	HashType HashAlgorithm
 	Data     []byte
 }
 
+// HashAlgorithm is the vboot hash algorithm (enum vb2_hash_algorithm)
+// used in hash attributes.
+type HashAlgorithm uint32
+
+const (
+	HashNone HashAlgorithm = iota
+	HashSHA1
+	HashSHA256
+	HashSHA512
+	HashSHA224
+	HashSHA384
+)
+
 type FileAttrPos struct {
 	Tag  Tag
 	Size uint32 // includes everything including data.
@@ -326,4 +350,7 @@ type Image struct {
 	Area         *fmap.Area
 	// And all the data.
 	Data []byte
+	// Warnings are the problems found reading the CBFS which do not keep
+	// it from being used, like hash attributes not matching their files.
+	Warnings []error `json:"-"`
 }
@@ -11,8 +11,6 @@ import (
 func NewUnknownRecord(f *File) (ReadWriter, error) {
 	r := &UnknownRecord{File: *f}
 	Debug("Got header %v", r.String())
-	r.Attr = make([]byte, 16)
-	r.FData = ffbyte(f.Size)
 	return r, nil
 }
 
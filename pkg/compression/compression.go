@@ -9,7 +9,11 @@
 package compression
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os/exec"
 
 	"github.com/linuxboot/fiano/pkg/guid"
@@ -17,6 +21,24 @@ import (
 
 var xzPath = flag.String("xzPath", "xz", "Path to system xz command used for lzma encoding. If unset, an internal lzma implementation is used.")
 
+// Options select the compressors of the GUIDed sections. The zero Options
+// select those of the package functions.
+type Options struct {
+	// Deterministic selects compressors whose output depends only on their
+	// input, so identical trees assemble to identical images on every
+	// system. Neither the system xz command, whose output changes with its
+	// version, nor External compressors, which run commands of the system,
+	// are used then, but the internal implementations, which only change
+	// with the version of fiano.
+	Deterministic bool
+	// Externals are the External compressors of the sections of their
+	// GUID, with the built-in compressor of the GUID as Fallback.
+	Externals map[guid.GUID]*External
+	// Context is the context of the commands of the Externals, which are
+	// killed once it is done. context.Background() if nil.
+	Context context.Context
+}
+
 // Compressor defines a single compression scheme (such as LZMA).
 type Compressor interface {
 	// Name is typically the name of a class.
@@ -27,20 +49,91 @@ type Compressor interface {
 	Encode(decodedData []byte) ([]byte, error)
 }
 
+// ErrSizeLimit is the error of DecodeLimit for data which decodes to more
+// than the limit.
+var ErrSizeLimit = errors.New("decoded data larger than the limit")
+
+// A LimitedDecoder is a Compressor which stops decoding data which decodes
+// to more than a maximum size, rather than allocating it, so hostile data
+// cannot exhaust memory.
+type LimitedDecoder interface {
+	// DecodeLimit is like Decode, but fails with ErrSizeLimit once the
+	// data decodes to more than max bytes.
+	DecodeLimit(encodedData []byte, max uint64) ([]byte, error)
+}
+
+// DecodeLimit decodes the data with c, failing with ErrSizeLimit if it
+// decodes to more than max bytes. A max of 0 means no limit. Compressors
+// which are not LimitedDecoders decode all the data before it is checked.
+func DecodeLimit(c Compressor, encodedData []byte, max uint64) ([]byte, error) {
+	if max == 0 {
+		return c.Decode(encodedData)
+	}
+	if l, ok := c.(LimitedDecoder); ok {
+		return l.DecodeLimit(encodedData, max)
+	}
+	decodedData, err := c.Decode(encodedData)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(decodedData)) > max {
+		return nil, fmt.Errorf("%s: %w of %d bytes", c.Name(), ErrSizeLimit, max)
+	}
+	return decodedData, nil
+}
+
+// readAllLimit reads r until EOF, failing with ErrSizeLimit after max bytes.
+// A max of 0 means no limit.
+func readAllLimit(name string, r io.Reader, max uint64) ([]byte, error) {
+	if max == 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) > max {
+		return nil, fmt.Errorf("%s: %w of %d bytes", name, ErrSizeLimit, max)
+	}
+	return b, nil
+}
+
 // Well-known GUIDs for GUIDed sections containing compressed data.
 var (
 	LZMAGUID    = *guid.MustParse("EE4E5898-3914-4259-9D6E-DC7BD79403CF")
 	LZMAX86GUID = *guid.MustParse("D42AE6BD-1352-4BFB-909A-CA72A6EAE889")
 	ZLIBGUID    = *guid.MustParse("CE3233F5-2CD6-4D87-9152-4A238BB6D1C4")
+	// TianoGUID is the TIANO_CUSTOM_DECOMPRESS GUID of EDK2, whose sections
+	// hold Tiano, or sometimes EFI 1.1, compressed data.
+	TianoGUID = *guid.MustParse("A31280AD-481E-41B6-95E8-127F4C984779")
 )
 
 // CompressorFromGUID returns a Compressor for the corresponding GUIDed Section.
 func CompressorFromGUID(guid *guid.GUID) Compressor {
-	// Default to system xz command for lzma encoding; if not found, use an
-	// internal lzma implementation.
+	return Options{}.CompressorFromGUID(guid)
+}
+
+// CompressorFromGUID is like the package function, with the options of o:
+// the External compressor of the GUID, if any.
+func (o Options) CompressorFromGUID(guid *guid.GUID) Compressor {
+	if e, ok := o.Externals[*guid]; ok && !o.Deterministic {
+		withFallback := *e
+		withFallback.Fallback = o.builtinCompressor(guid)
+		withFallback.ctx = o.Context
+		return &withFallback
+	}
+	return o.builtinCompressor(guid)
+}
+
+// builtinCompressor returns the Go-based or xz Compressor for the
+// corresponding GUIDed Section.
+func (o Options) builtinCompressor(guid *guid.GUID) Compressor {
+	// Default to system xz command for lzma encoding; if not found, or if
+	// the output must be deterministic, use an internal lzma
+	// implementation.
 	var lzma Compressor
-	if _, err := exec.LookPath(*xzPath); err == nil {
-		lzma = &SystemLZMA{*xzPath}
+	if _, err := exec.LookPath(*xzPath); err == nil && !o.Deterministic {
+		lzma = &SystemLZMA{xzPath: *xzPath}
 	} else {
 		lzma = &LZMA{}
 	}
@@ -54,6 +147,60 @@ func CompressorFromGUID(guid *guid.GUID) Compressor {
 		return &LZMAX86{lzma}
 	case ZLIBGUID:
 		return &ZLIB{}
+	case TianoGUID:
+		return &EFI{Tiano: true}
 	}
 	return nil
 }
+
+// CompressorFromGUIDVariant is like CompressorFromGUID, for a section which
+// selects the encoding variant of its compressor. An empty variant is the
+// default of the GUID. The EFI compressors have variants named as
+// ParseEFIVariant parses them, and the LZMA ones as ParseLZMAVariant does,
+// LZMA sections taking either LZMA or LZMAX86 variants.
+func CompressorFromGUIDVariant(guid *guid.GUID, variant string) (Compressor, error) {
+	return Options{}.CompressorFromGUIDVariant(guid, variant)
+}
+
+// CompressorFromGUIDVariant is like the package function, with the options
+// of o.
+func (o Options) CompressorFromGUIDVariant(guid *guid.GUID, variant string) (Compressor, error) {
+	c := o.CompressorFromGUID(guid)
+	if c == nil || variant == "" {
+		return c, nil
+	}
+	switch c.(type) {
+	case *EFI:
+		return ParseEFIVariant(variant)
+	case *LZMA, *SystemLZMA, *LZMAX86:
+		return lzmaWithVariant(c, variant)
+	}
+	return nil, fmt.Errorf("%s compression has no variant %q", c.Name(), variant)
+}
+
+// DetectVariant returns the variant of the parameters data compressed by c
+// was encoded with, as CompressorFromGUIDVariant takes it, so it can be
+// encoded alike again. It returns "" for the parameters c encodes with by
+// default, and for those the data does not tell, like the EFI ones.
+func DetectVariant(c Compressor, encodedData []byte) string {
+	switch c.(type) {
+	case *LZMA, *SystemLZMA, *LZMAX86:
+		return detectLZMAVariant(c.Name(), encodedData)
+	}
+	return ""
+}
+
+// GUIDFromCompressor returns the GUID of the sections compressed by c, if
+// it has one. It tells LZMA from LZMAX86 sections, which decoders do not
+// tell from the data.
+func GUIDFromCompressor(c Compressor) (guid.GUID, bool) {
+	switch c.(type) {
+	case *LZMA, *SystemLZMA:
+		return LZMAGUID, true
+	case *LZMAX86:
+		return LZMAX86GUID, true
+	case *ZLIB:
+		return ZLIBGUID, true
+	}
+	return guid.GUID{}, false
+}
@@ -5,7 +5,11 @@
 package compression
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"os"
+	"os/exec"
 	"reflect"
 	"testing"
 
@@ -34,7 +38,7 @@ var tests = []struct {
 		name:            "random data SystemLZMA",
 		encodedFilename: "testdata/random.bin.lzma",
 		decodedFilename: "testdata/random.bin",
-		compressor:      &SystemLZMA{"xz"},
+		compressor:      &SystemLZMA{xzPath: "xz"},
 	},
 	{
 		name:            "random data LZMAX86",
@@ -46,7 +50,7 @@ var tests = []struct {
 		name:            "random data SystemLZMAX86",
 		encodedFilename: "testdata/random.bin.lzma86",
 		decodedFilename: "testdata/random.bin",
-		compressor:      &LZMAX86{&SystemLZMA{"xz"}},
+		compressor:      &LZMAX86{&SystemLZMA{xzPath: "xz"}},
 	},
 	{
 		name:            "random data ZLIB",
@@ -81,6 +85,48 @@ func TestEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestEncodePooled(t *testing.T) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.decodedFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, err := tt.compressor.Encode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := append([]byte{}, first...)
+			// Encoding again reuses the scratch buffers, which must not
+			// change what the first call returned.
+			if _, err := tt.compressor.Encode(data[:len(data)/2]); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, kept) {
+				t.Errorf("encoding again changed the data encoded first")
+			}
+		})
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.decodedFilename)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := tt.compressor.Encode(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestDecode(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -106,6 +152,56 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestDecodeLimit(t *testing.T) {
+	for _, tt := range append(tests, struct {
+		name            string
+		encodedFilename string
+		decodedFilename string
+		compressor      Compressor
+	}{"random data EFI", "", "testdata/random.bin", &EFI{}}) {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := os.ReadFile(tt.decodedFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := tt.compressor.Encode(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeLimit(tt.compressor, encoded, uint64(len(want)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("decompressed image did not match, (got: %d bytes, want: %d bytes)", len(got), len(want))
+			}
+			if _, err := DecodeLimit(tt.compressor, encoded, uint64(len(want)-1)); !errors.Is(err, ErrSizeLimit) {
+				t.Errorf("decoding %d bytes with a limit of one less: got %v, want %v", len(want), err, ErrSizeLimit)
+			}
+		})
+	}
+}
+
+func TestDecodeLimitLZMADictionary(t *testing.T) {
+	want, err := os.ReadFile("testdata/random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := (&LZMA{}).Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A 4 GiB dictionary is not allocated.
+	binary.LittleEndian.PutUint32(encoded[1:], 0xffffffff)
+	got, err := (&LZMA{}).DecodeLimit(encoded, uint64(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decompressed image did not match, (got: %d bytes, want: %d bytes)", len(got), len(want))
+	}
+}
+
 func TestCompressorFromGUID(t *testing.T) {
 	var compressors = []struct {
 		name            string
@@ -117,17 +213,23 @@ func TestCompressorFromGUID(t *testing.T) {
 		{
 			name:            "system xz",
 			guid:            &LZMAGUID,
-			expected:        &SystemLZMA{"xz"},
+			expected:        &SystemLZMA{xzPath: "xz"},
 			encodedFilename: "testdata/random.bin.lzma",
 			decodedFilename: "testdata/random.bin",
 		},
 		{
 			name:            "lzma",
 			guid:            &LZMAX86GUID,
-			expected:        &LZMAX86{&SystemLZMA{"xz"}},
+			expected:        &LZMAX86{&SystemLZMA{xzPath: "xz"}},
 			encodedFilename: "testdata/random.bin.lzma86",
 			decodedFilename: "testdata/random.bin",
 		},
+		{
+			name:            "tiano",
+			guid:            &TianoGUID,
+			expected:        &EFI{Tiano: true},
+			decodedFilename: "testdata/random.bin",
+		},
 		{
 			name:            "zlib",
 			guid:            &ZLIBGUID,
@@ -178,3 +280,170 @@ func TestCompressorFromGUID(t *testing.T) {
 
 	}
 }
+
+func TestCompressorFromGUIDDeterministic(t *testing.T) {
+	// Without the option, an xz command found on the system and an
+	// External compressor would be used.
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func(path string) { *xzPath = path }(*xzPath)
+	*xzPath = sh
+	externals := map[guid.GUID]*External{LZMAGUID: {Encoder: []string{"false"}}}
+	if c, ok := (Options{Externals: externals}).CompressorFromGUID(&LZMAGUID).(*External); !ok {
+		t.Fatalf("got compressor %T, want *External", c)
+	}
+	if c, ok := CompressorFromGUID(&LZMAX86GUID).(*LZMAX86); !ok {
+		t.Fatalf("got compressor %T, want *LZMAX86", c)
+	} else if _, ok := c.lzma.(*SystemLZMA); !ok {
+		t.Fatalf("got LZMAX86 with %T, want *SystemLZMA", c.lzma)
+	}
+
+	o := Options{Deterministic: true, Externals: externals}
+	if c, ok := o.CompressorFromGUID(&LZMAGUID).(*LZMA); !ok {
+		t.Fatalf("got compressor %T, want *LZMA", c)
+	}
+	if c, err := o.CompressorFromGUIDVariant(&LZMAGUID, "LZMA,dict=1MiB"); err != nil {
+		t.Fatal(err)
+	} else if _, ok := c.(*LZMA); !ok {
+		t.Fatalf("got compressor %T, want *LZMA", c)
+	}
+	c, ok := o.CompressorFromGUID(&LZMAX86GUID).(*LZMAX86)
+	if !ok {
+		t.Fatalf("got compressor %T, want *LZMAX86", c)
+	}
+	if _, ok := c.lzma.(*LZMA); !ok {
+		t.Fatalf("got LZMAX86 with %T, want *LZMA", c.lzma)
+	}
+
+	data, err := os.ReadFile("testdata/random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := o.CompressorFromGUID(&LZMAX86GUID).Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("encoding the same data twice gave different results")
+	}
+}
+
+func TestCompressorFromGUIDVariant(t *testing.T) {
+	c, err := CompressorFromGUIDVariant(&TianoGUID, "EFI,nosingle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&EFI{NoSingleCodes: true}); !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+	if c, err := CompressorFromGUIDVariant(&TianoGUID, ""); err != nil || c.Name() != "Tiano" {
+		t.Errorf("default variant: got %v, %v, want Tiano", c, err)
+	}
+	if _, err := CompressorFromGUIDVariant(&ZLIBGUID, "EFI"); err == nil {
+		t.Errorf("ZLIB with an EFI variant succeeded, want an error")
+	}
+}
+
+func TestLZMAVariants(t *testing.T) {
+	data, err := os.ReadFile("testdata/random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		variant string
+		guid    guid.GUID
+		dictCap uint32
+		props   byte
+	}{
+		{"LZMA", LZMAGUID, 1 << 24, 0x5d},
+		{"LZMA,dict=1MiB", LZMAGUID, 1 << 20, 0x5d},
+		{"LZMAX86,dict=64KiB,lc=0,lp=2,pb=0", LZMAX86GUID, 1 << 16, 2 * 9},
+	} {
+		c, err := ParseLZMAVariant(tt.variant)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.variant, err)
+		}
+		if got := c.(interface{ Variant() string }).Variant(); got != tt.variant {
+			t.Errorf("ParseLZMAVariant(%q).Variant() = %q", tt.variant, got)
+		}
+		if g, ok := GUIDFromCompressor(c); !ok || g != tt.guid {
+			t.Errorf("%s: GUID %v, want %v", tt.variant, g, tt.guid)
+		}
+		encoded, err := c.Encode(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.variant, err)
+		}
+		// The header holds the properties and the dictionary size.
+		if encoded[0] != tt.props {
+			t.Errorf("%s: properties %#x, want %#x", tt.variant, encoded[0], tt.props)
+		}
+		if got := binary.LittleEndian.Uint32(encoded[1:]); got != tt.dictCap {
+			t.Errorf("%s: dictionary size %#x, want %#x", tt.variant, got, tt.dictCap)
+		}
+		decoded, err := c.Decode(encoded)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.variant, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: Decode(Encode(x)) != x", tt.variant)
+		}
+	}
+
+	for _, variant := range []string{"", "XZ", "LZMA,dict=1", "LZMA,dict=big", "LZMA,lc=9", "LZMA,pb=5", "LZMA,x86", "LZMA,fast=1"} {
+		if _, err := ParseLZMAVariant(variant); err == nil {
+			t.Errorf("ParseLZMAVariant(%q) succeeded, want an error", variant)
+		}
+	}
+	if _, err := CompressorFromGUIDVariant(&LZMAGUID, "EFI"); err == nil {
+		t.Errorf("LZMA with an EFI variant succeeded, want an error")
+	}
+}
+
+func TestDetectVariant(t *testing.T) {
+	data := bytes.Repeat([]byte("fiano"), 1000)
+	for _, variant := range []string{"LZMA", "LZMA,dict=64KiB", "LZMA,lc=0,lp=2,pb=0", "LZMAX86,dict=1MiB,lc=4,lp=0,pb=2"} {
+		c, err := ParseLZMAVariant(variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := c.Encode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := variant
+		if variant == "LZMA" {
+			want = ""
+		}
+		if got := DetectVariant(c, encoded); got != want {
+			t.Errorf("DetectVariant of %s data: got %q, want %q", variant, got, want)
+		}
+	}
+	if got := DetectVariant(&ZLIB{}, data); got != "" {
+		t.Errorf("DetectVariant of ZLIB data: got %q, want none", got)
+	}
+
+	// xz encodes with the parameters too.
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skipf("no xz command: %v", err)
+	}
+	c, err := lzmaWithVariant(&SystemLZMA{xzPath: "xz"}, "LZMA,dict=1MiB,lc=1,lp=1,pb=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*SystemLZMA); !ok {
+		t.Fatalf("got %T, want SystemLZMA", c)
+	}
+	encoded, err := c.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := DetectVariant(c, encoded), "LZMA,dict=1MiB,lc=1,lp=1,pb=1"; got != want {
+		t.Errorf("DetectVariant of xz data: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,706 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EFI 1.1 and Tiano compression, as implemented by EfiCompress and
+// TianoCompress of the EDK2 BaseTools and described in the UEFI
+// Specification, chapter "Compression Algorithm Specification". Both are
+// LZ77 with Huffman coded blocks. They differ in the window size, and so
+// in the number of bits of the position set.
+const (
+	efiHeaderSize = 8
+
+	efiMaxMatch  = 256
+	efiThreshold = 3
+	efiCodeBit   = 16
+	// Char&Len set
+	efiNC   = 0xff + efiMaxMatch + 2 - efiThreshold
+	efiCBit = 9
+	// exTra set
+	efiNT   = efiCodeBit + 3
+	efiTBit = 5
+	// Maximum position set
+	efiMaxNP = 31
+
+	// efiMaxBlockSize is the number of symbols the encoder puts in a block
+	// by default.
+	efiMaxBlockSize = 0x4000
+)
+
+var errEFICorrupt = errors.New("corrupt EFI compressed data")
+
+// EFI implements Compressor for the EFI 1.1 compression format, or the
+// Tiano format if Tiano is set.
+//
+// Decoders accept any valid encoding, but some PEI cores only decode the
+// encodings of the compressor they were built with. The other fields select
+// the variant of the encoding; their zero values encode like EDK2.
+type EFI struct {
+	Tiano bool
+
+	// BlockSize is the number of symbols of each Huffman coded block but
+	// the last, up to 0xFFFF. 0 means 0x4000.
+	BlockSize int
+	// NoSingleCodes gives every Huffman code at least two symbols, for
+	// decoders which do not handle a code of one symbol sent without bits.
+	NoSingleCodes bool
+}
+
+// Name returns the type of compression employed.
+func (c *EFI) Name() string {
+	if c.Tiano {
+		return "Tiano"
+	}
+	return "EFI"
+}
+
+// windowBits returns the size of the sliding dictionary, which determines
+// the position set.
+func (c *EFI) windowBits() uint {
+	if c.Tiano {
+		return 19
+	}
+	return 13
+}
+
+// pBit returns the number of bits of the size of the position set.
+func (c *EFI) pBit() uint {
+	if c.Tiano {
+		return 5
+	}
+	return 4
+}
+
+// Variant returns the name of the encoding variant, as ParseEFIVariant
+// parses it.
+func (c *EFI) Variant() string {
+	v := c.Name()
+	if c.BlockSize != 0 {
+		v += fmt.Sprintf(",block=%d", c.BlockSize)
+	}
+	if c.NoSingleCodes {
+		v += ",nosingle"
+	}
+	return v
+}
+
+// ParseEFIVariant parses the name of an encoding variant: EFI or Tiano,
+// followed by comma separated options:
+//
+//	block=N   put N symbols in each block
+//	nosingle  give every code at least two symbols
+func ParseEFIVariant(variant string) (*EFI, error) {
+	opts := strings.Split(variant, ",")
+	c := &EFI{}
+	switch strings.ToUpper(opts[0]) {
+	case "EFI":
+	case "TIANO":
+		c.Tiano = true
+	default:
+		return nil, fmt.Errorf("unknown EFI compression variant %q, want EFI or Tiano", opts[0])
+	}
+	for _, o := range opts[1:] {
+		switch {
+		case o == "nosingle":
+			c.NoSingleCodes = true
+		case strings.HasPrefix(o, "block="):
+			n, err := strconv.Atoi(strings.TrimPrefix(o, "block="))
+			if err != nil || n <= 0 || n > 0xFFFF {
+				return nil, fmt.Errorf("bad EFI compression block size %q, want 1 to 65535", o)
+			}
+			c.BlockSize = n
+		default:
+			return nil, fmt.Errorf("unknown EFI compression option %q", o)
+		}
+	}
+	return c, nil
+}
+
+// efiReader reads bits MSB first, returning zeros past the end like EDK2.
+type efiReader struct {
+	buf []byte
+	pos uint
+}
+
+func (r *efiReader) peek(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		p := r.pos + i
+		var b uint32
+		if p/8 < uint(len(r.buf)) {
+			b = uint32(r.buf[p/8]>>(7-p%8)) & 1
+		}
+		v = v<<1 | b
+	}
+	return v
+}
+
+func (r *efiReader) bits(n uint) uint32 {
+	v := r.peek(n)
+	r.pos += n
+	return v
+}
+
+// efiHuffman decodes a canonical Huffman code.
+type efiHuffman struct {
+	lens   []uint8
+	count  [17]uint16
+	sorted []uint16
+	// single is the symbol of a code without bits, or -1.
+	single int
+}
+
+func newEFIHuffman(lens []uint8) (*efiHuffman, error) {
+	h := &efiHuffman{lens: lens, single: -1}
+	for _, l := range lens {
+		if l > 16 {
+			return nil, errEFICorrupt
+		}
+		h.count[l]++
+	}
+	var kraft uint32
+	for l := 1; l <= 16; l++ {
+		kraft += uint32(h.count[l]) << (16 - l)
+	}
+	if kraft != 1<<16 {
+		return nil, errEFICorrupt
+	}
+	for l := 1; l <= 16; l++ {
+		for s, sl := range lens {
+			if int(sl) == l {
+				h.sorted = append(h.sorted, uint16(s))
+			}
+		}
+	}
+	return h, nil
+}
+
+func (h *efiHuffman) decode(r *efiReader) int {
+	if h.single >= 0 {
+		return h.single
+	}
+	var code, first, index int
+	for l := 1; l <= 16; l++ {
+		code |= int(r.bits(1))
+		n := int(h.count[l])
+		if code-first < n {
+			return int(h.sorted[index+code-first])
+		}
+		index += n
+		first = (first + n) << 1
+		code <<= 1
+	}
+	return -1
+}
+
+// readPTLen reads the code lengths of the extra or position set.
+func readPTLen(r *efiReader, nn int, nbit uint, special int) (*efiHuffman, error) {
+	n := int(r.bits(nbit))
+	if n == 0 {
+		c := int(r.bits(nbit))
+		if c >= nn {
+			return nil, errEFICorrupt
+		}
+		return &efiHuffman{single: c}, nil
+	}
+	if n > nn {
+		return nil, errEFICorrupt
+	}
+	lens := make([]uint8, nn)
+	for i := 0; i < n; {
+		c := r.bits(3)
+		if c == 7 {
+			for r.bits(1) == 1 {
+				c++
+			}
+		}
+		lens[i] = uint8(c)
+		i++
+		if i == special {
+			for z := r.bits(2); z > 0 && i < n; z-- {
+				lens[i] = 0
+				i++
+			}
+		}
+	}
+	return newEFIHuffman(lens)
+}
+
+// readCLen reads the code lengths of the char&len set.
+func readCLen(r *efiReader, t *efiHuffman) (*efiHuffman, error) {
+	n := int(r.bits(efiCBit))
+	if n == 0 {
+		c := int(r.bits(efiCBit))
+		if c >= efiNC {
+			return nil, errEFICorrupt
+		}
+		return &efiHuffman{single: c}, nil
+	}
+	if n > efiNC {
+		return nil, errEFICorrupt
+	}
+	lens := make([]uint8, efiNC)
+	for i := 0; i < n; {
+		c := t.decode(r)
+		switch {
+		case c < 0:
+			return nil, errEFICorrupt
+		case c <= 2:
+			z := 1
+			if c == 1 {
+				z = int(r.bits(4)) + 3
+			} else if c == 2 {
+				z = int(r.bits(efiCBit)) + 20
+			}
+			for ; z > 0 && i < n; z-- {
+				lens[i] = 0
+				i++
+			}
+		default:
+			lens[i] = uint8(c - 2)
+			i++
+		}
+	}
+	return newEFIHuffman(lens)
+}
+
+// DecodeLimit implements LimitedDecoder. The header holds the decoded size,
+// which Decode allocates up front.
+func (c *EFI) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
+	if len(encodedData) >= efiHeaderSize {
+		if origSize := binary.LittleEndian.Uint32(encodedData[4:]); uint64(origSize) > max {
+			return nil, fmt.Errorf("%s: %w of %d bytes: header says %d", c.Name(), ErrSizeLimit, max, origSize)
+		}
+	}
+	return c.Decode(encodedData)
+}
+
+// Decode decodes a byte slice of EFI or Tiano compressed data.
+func (c *EFI) Decode(encodedData []byte) ([]byte, error) {
+	if len(encodedData) < efiHeaderSize {
+		return nil, fmt.Errorf("%s: missing header", c.Name())
+	}
+	compSize := binary.LittleEndian.Uint32(encodedData)
+	origSize := binary.LittleEndian.Uint32(encodedData[4:])
+	if uint64(compSize)+efiHeaderSize > uint64(len(encodedData)) {
+		return nil, fmt.Errorf("%s: compressed size %#x larger than the %#x bytes of data", c.Name(), compSize, len(encodedData)-efiHeaderSize)
+	}
+	r := &efiReader{buf: encodedData[efiHeaderSize : efiHeaderSize+compSize]}
+	out := make([]byte, 0, origSize)
+	var blockSize uint32
+	var cTree, pTree *efiHuffman
+	for uint32(len(out)) < origSize {
+		if blockSize == 0 {
+			blockSize = r.bits(16)
+			t, err := readPTLen(r, efiNT, efiTBit, 3)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", c.Name(), err)
+			}
+			if cTree, err = readCLen(r, t); err != nil {
+				return nil, fmt.Errorf("%s: %v", c.Name(), err)
+			}
+			if pTree, err = readPTLen(r, efiMaxNP, c.pBit(), -1); err != nil {
+				return nil, fmt.Errorf("%s: %v", c.Name(), err)
+			}
+		}
+		blockSize--
+		sym := cTree.decode(r)
+		if sym < 0 {
+			return nil, fmt.Errorf("%s: %v", c.Name(), errEFICorrupt)
+		}
+		if sym < 0x100 {
+			out = append(out, byte(sym))
+			continue
+		}
+		n := sym - (0x100 - efiThreshold)
+		p := pTree.decode(r)
+		if p < 0 {
+			return nil, fmt.Errorf("%s: %v", c.Name(), errEFICorrupt)
+		}
+		if p > 1 {
+			p = 1<<(p-1) + int(r.bits(uint(p-1)))
+		}
+		from := len(out) - p - 1
+		if from < 0 {
+			return nil, fmt.Errorf("%s: match at distance %d before the start of the data", c.Name(), p+1)
+		}
+		for ; n > 0 && uint32(len(out)) < origSize; n-- {
+			out = append(out, out[from])
+			from++
+		}
+	}
+	return out, nil
+}
+
+// efiWriter writes bits MSB first.
+type efiWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *efiWriter) put(n uint, v uint32) {
+	w.acc = w.acc<<n | uint64(v)&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nbits))
+	}
+}
+
+func (w *efiWriter) flush() {
+	if w.nbits > 0 {
+		w.put(8-w.nbits, 0)
+	}
+}
+
+// efiCode is a canonical Huffman code built from symbol frequencies, with
+// code lengths limited to 16 bits.
+type efiCode struct {
+	lens  []uint8
+	codes []uint16
+	// single is the only symbol used, or -1.
+	single int
+}
+
+func newEFICode(freq []uint32) *efiCode {
+	c := &efiCode{lens: make([]uint8, len(freq)), codes: make([]uint16, len(freq)), single: -1}
+	var syms []int
+	for s, f := range freq {
+		if f != 0 {
+			syms = append(syms, s)
+		}
+	}
+	switch len(syms) {
+	case 0:
+		c.single = 0
+		return c
+	case 1:
+		c.single = syms[0]
+		return c
+	}
+
+	// Build a Huffman tree and take the depths of its leaves.
+	type node struct {
+		freq        uint64
+		sym         int
+		left, right *node
+	}
+	nodes := make([]*node, len(syms))
+	for i, s := range syms {
+		nodes[i] = &node{freq: uint64(freq[s]), sym: s}
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].freq < nodes[j].freq })
+		n := &node{freq: nodes[0].freq + nodes[1].freq, sym: -1, left: nodes[0], right: nodes[1]}
+		nodes = append([]*node{n}, nodes[2:]...)
+	}
+	var lenCount [17]int
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.sym >= 0 {
+			if depth > 16 {
+				depth = 16
+			}
+			lenCount[depth]++
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(nodes[0], 0)
+
+	// Limit the lengths to 16 bits like EDK2 does, keeping the code
+	// complete.
+	var cum uint32
+	for l := 16; l > 0; l-- {
+		cum += uint32(lenCount[l]) << (16 - l)
+	}
+	for cum != 1<<16 {
+		lenCount[16]--
+		for l := 15; l > 0; l-- {
+			if lenCount[l] != 0 {
+				lenCount[l]--
+				lenCount[l+1] += 2
+				break
+			}
+		}
+		cum--
+	}
+
+	// Give the longest codes to the least frequent symbols.
+	sort.SliceStable(syms, func(i, j int) bool { return freq[syms[i]] < freq[syms[j]] })
+	i := 0
+	for l := 16; l > 0; l-- {
+		for k := 0; k < lenCount[l]; k++ {
+			c.lens[syms[i]] = uint8(l)
+			i++
+		}
+	}
+
+	// Assign canonical codes, in symbol order within a length.
+	var start [18]uint16
+	for l := 1; l <= 16; l++ {
+		start[l+1] = (start[l] + uint16(lenCount[l])) << 1
+	}
+	for s, l := range c.lens {
+		if l != 0 {
+			c.codes[s] = start[l]
+			start[l]++
+		}
+	}
+	return c
+}
+
+// addSecondSymbol adds unused symbols to the frequencies until there are
+// two, so their code has bits.
+func addSecondSymbol(freq []uint32) {
+	n := 0
+	for _, f := range freq {
+		if f != 0 {
+			n++
+		}
+	}
+	for s := 0; n < 2 && s < len(freq); s++ {
+		if freq[s] == 0 {
+			freq[s] = 1
+			n++
+		}
+	}
+}
+
+func (c *efiCode) put(w *efiWriter, sym int) {
+	if c.single < 0 {
+		w.put(uint(c.lens[sym]), uint32(c.codes[sym]))
+	}
+}
+
+// writePTLen writes the code lengths of the extra or position set.
+func writePTLen(w *efiWriter, c *efiCode, nbit uint, special int) {
+	if c.single >= 0 {
+		w.put(nbit, 0)
+		w.put(nbit, uint32(c.single))
+		return
+	}
+	n := len(c.lens)
+	for n > 0 && c.lens[n-1] == 0 {
+		n--
+	}
+	w.put(nbit, uint32(n))
+	for i := 0; i < n; {
+		k := uint(c.lens[i])
+		i++
+		if k <= 6 {
+			w.put(3, uint32(k))
+		} else {
+			w.put(k-3, 1<<(k-3)-2)
+		}
+		if i == special {
+			for i < 6 && c.lens[i] == 0 {
+				i++
+			}
+			w.put(2, uint32(i-3)&3)
+		}
+	}
+}
+
+// cLenSymbols returns the extra set symbols which encode the code lengths
+// of the char&len set, each followed by its extra bits.
+func cLenSymbols(c *efiCode) [][3]uint32 {
+	n := len(c.lens)
+	for n > 0 && c.lens[n-1] == 0 {
+		n--
+	}
+	var syms [][3]uint32
+	for i := 0; i < n; {
+		k := c.lens[i]
+		i++
+		if k != 0 {
+			syms = append(syms, [3]uint32{uint32(k) + 2, 0, 0})
+			continue
+		}
+		count := 1
+		for i < n && c.lens[i] == 0 {
+			i++
+			count++
+		}
+		switch {
+		case count <= 2:
+			for ; count > 0; count-- {
+				syms = append(syms, [3]uint32{0, 0, 0})
+			}
+		case count <= 18:
+			syms = append(syms, [3]uint32{1, 4, uint32(count - 3)})
+		case count == 19:
+			syms = append(syms, [3]uint32{0, 0, 0}, [3]uint32{1, 4, 15})
+		default:
+			syms = append(syms, [3]uint32{2, efiCBit, uint32(count - 20)})
+		}
+	}
+	return syms
+}
+
+// efiSymbol is a literal or a match of the LZ77 stage.
+type efiSymbol struct {
+	c   uint16
+	pos uint32
+}
+
+// match finds the longest match for data[i:] in the window using the hash
+// chains.
+func efiMatch(data []byte, i int, head map[uint32]int, prev []int, window int) (int, int) {
+	if i+efiThreshold > len(data) {
+		return 0, 0
+	}
+	bestLen, bestPos := 0, 0
+	max := len(data) - i
+	if max > efiMaxMatch {
+		max = efiMaxMatch
+	}
+	h := uint32(data[i])<<16 | uint32(data[i+1])<<8 | uint32(data[i+2])
+	j, ok := head[h]
+	for chain := 0; ok && j >= 0 && i-j <= window && chain < 256; chain++ {
+		l := 0
+		for l < max && data[j+l] == data[i+l] {
+			l++
+		}
+		if l > bestLen {
+			bestLen, bestPos = l, i-j-1
+			if l == max {
+				break
+			}
+		}
+		j = prev[j]
+	}
+	return bestLen, bestPos
+}
+
+// Encode encodes a byte slice with EFI or Tiano compression.
+func (c *EFI) Encode(decodedData []byte) ([]byte, error) {
+	blockSize := c.BlockSize
+	if blockSize == 0 {
+		blockSize = efiMaxBlockSize
+	}
+	if blockSize < 0 || blockSize > 0xFFFF {
+		return nil, fmt.Errorf("%s: block size %d out of range", c.Name(), c.BlockSize)
+	}
+	window := 1 << c.windowBits()
+	np := int(c.windowBits()) + 1
+
+	// LZ77 stage
+	var syms []efiSymbol
+	head := map[uint32]int{}
+	prev := make([]int, len(decodedData))
+	insert := func(i int) {
+		if i+efiThreshold <= len(decodedData) {
+			h := uint32(decodedData[i])<<16 | uint32(decodedData[i+1])<<8 | uint32(decodedData[i+2])
+			if j, ok := head[h]; ok {
+				prev[i] = j
+			} else {
+				prev[i] = -1
+			}
+			head[h] = i
+		}
+	}
+	for i := 0; i < len(decodedData); {
+		l, p := efiMatch(decodedData, i, head, prev, window)
+		if l >= efiThreshold {
+			syms = append(syms, efiSymbol{c: uint16(l + 0x100 - efiThreshold), pos: uint32(p)})
+		} else {
+			l = 1
+			syms = append(syms, efiSymbol{c: uint16(decodedData[i])})
+		}
+		for ; l > 0; l-- {
+			insert(i)
+			i++
+		}
+	}
+	if len(syms) == 0 {
+		// An empty block is not decodable, so have one which is never
+		// reached.
+		syms = append(syms, efiSymbol{})
+	}
+
+	// Huffman stage
+	w := &efiWriter{}
+	for len(syms) > 0 {
+		block := syms
+		if len(block) > blockSize {
+			block = block[:blockSize]
+		}
+		syms = syms[len(block):]
+
+		cFreq := make([]uint32, efiNC)
+		pFreq := make([]uint32, np)
+		for _, s := range block {
+			cFreq[s.c]++
+			if s.c >= 0x100 {
+				pFreq[bits.Len32(s.pos)]++
+			}
+		}
+		if c.NoSingleCodes {
+			addSecondSymbol(cFreq)
+			addSecondSymbol(pFreq)
+		}
+		cCode := newEFICode(cFreq)
+		pCode := newEFICode(pFreq)
+
+		w.put(16, uint32(len(block)))
+		if cCode.single >= 0 {
+			w.put(efiTBit, 0)
+			w.put(efiTBit, 0)
+			w.put(efiCBit, 0)
+			w.put(efiCBit, uint32(cCode.single))
+		} else {
+			cLens := cLenSymbols(cCode)
+			tFreq := make([]uint32, efiNT)
+			for _, s := range cLens {
+				tFreq[s[0]]++
+			}
+			if c.NoSingleCodes {
+				addSecondSymbol(tFreq)
+			}
+			tCode := newEFICode(tFreq)
+			writePTLen(w, tCode, efiTBit, 3)
+			n := len(cCode.lens)
+			for n > 0 && cCode.lens[n-1] == 0 {
+				n--
+			}
+			w.put(efiCBit, uint32(n))
+			for _, s := range cLens {
+				tCode.put(w, int(s[0]))
+				w.put(uint(s[1]), s[2])
+			}
+		}
+		writePTLen(w, pCode, c.pBit(), -1)
+
+		for _, s := range block {
+			cCode.put(w, int(s.c))
+			if s.c >= 0x100 {
+				p := bits.Len32(s.pos)
+				pCode.put(w, p)
+				if p > 1 {
+					w.put(uint(p-1), s.pos)
+				}
+			}
+		}
+	}
+	w.flush()
+
+	out := make([]byte, efiHeaderSize, efiHeaderSize+len(w.buf))
+	binary.LittleEndian.PutUint32(out, uint32(len(w.buf)))
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(decodedData)))
+	return append(out, w.buf...), nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestEFIEncodeDecode(t *testing.T) {
+	random, err := os.ReadFile("testdata/random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 2000)
+	skewed := make([]byte, 0x30000)
+	r := rand.New(rand.NewSource(1))
+	for i := range skewed {
+		// Fibonacci-like frequencies make deep Huffman trees.
+		skewed[i] = byte(bitsOf(r.Uint32()))
+	}
+	for _, c := range []*EFI{{}, {Tiano: true}} {
+		for name, data := range map[string][]byte{
+			"empty":  {},
+			"byte":   {0x42},
+			"zeros":  make([]byte, 0x12345),
+			"random": random,
+			"text":   text,
+			"skewed": skewed,
+		} {
+			encoded, err := c.Encode(data)
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.Name(), name, err)
+			}
+			if got := binary.LittleEndian.Uint32(encoded); int(got) != len(encoded)-efiHeaderSize {
+				t.Errorf("%s %s: compressed size %#x, want %#x", c.Name(), name, got, len(encoded)-efiHeaderSize)
+			}
+			decoded, err := c.Decode(encoded)
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.Name(), name, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s %s: Decode(Encode(x)) != x", c.Name(), name)
+			}
+			if name == "text" && len(encoded) > len(data)/10 {
+				t.Errorf("%s: text compressed to %#x of %#x bytes", c.Name(), len(encoded), len(data))
+			}
+		}
+	}
+}
+
+// bitsOf returns the number of trailing ones of v.
+func bitsOf(v uint32) int {
+	n := 0
+	for ; v&1 != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+func TestEFIDecodeErrors(t *testing.T) {
+	c := &EFI{}
+	encoded, err := c.Encode(bytes.Repeat([]byte("abc"), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range [][]byte{
+		encoded[:4],
+		encoded[:len(encoded)-1],
+		// A block of lengths describing an incomplete code.
+		{4, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0x0c, 0x20},
+	} {
+		if _, err := c.Decode(b); err == nil {
+			t.Errorf("Decode(% x): got nil, want error", b)
+		}
+	}
+}
+
+func TestEFIVariants(t *testing.T) {
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 2000)
+	for _, variant := range []string{"EFI", "Tiano", "EFI,block=1", "Tiano,block=300", "EFI,nosingle", "Tiano,block=65535,nosingle"} {
+		c, err := ParseEFIVariant(variant)
+		if err != nil {
+			t.Fatalf("%s: %v", variant, err)
+		}
+		if got := c.Variant(); got != variant {
+			t.Errorf("ParseEFIVariant(%q).Variant() = %q", variant, got)
+		}
+		for name, data := range map[string][]byte{
+			"empty": {},
+			"zeros": make([]byte, 0x1234),
+			"text":  text,
+		} {
+			encoded, err := c.Encode(data)
+			if err != nil {
+				t.Fatalf("%s %s: %v", variant, name, err)
+			}
+			// Variants only differ in their encoding.
+			decoded, err := (&EFI{Tiano: c.Tiano}).Decode(encoded)
+			if err != nil {
+				t.Fatalf("%s %s: %v", variant, name, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s %s: Decode(Encode(x)) != x", variant, name)
+			}
+		}
+	}
+
+	// Zeros are one symbol, which is sent without bits unless nosingle.
+	zeros := make([]byte, 0x10)
+	single, err := (&EFI{}).Encode(zeros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSingle, err := (&EFI{NoSingleCodes: true}).Encode(zeros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(single, noSingle) {
+		t.Errorf("nosingle encoded like the default: % x", single)
+	}
+}
+
+func TestParseEFIVariantErrors(t *testing.T) {
+	for _, variant := range []string{"", "LZMA", "EFI,block=0", "Tiano,block=65536", "EFI,block=x", "EFI,fast"} {
+		if _, err := ParseEFIVariant(variant); err == nil {
+			t.Errorf("ParseEFIVariant(%q) succeeded, want an error", variant)
+		}
+	}
+}
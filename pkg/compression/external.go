@@ -0,0 +1,170 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// Placeholders of the command lines of External, replaced by the paths of
+// the input and the output files.
+const (
+	ExternalIn  = "{in}"
+	ExternalOut = "{out}"
+)
+
+// External implements Compressor by running external commands, like xz, 7z
+// or the compressor of a vendor, for streams the Go-based encoders do not
+// reproduce exactly. The output of the commands is taken as is.
+type External struct {
+	// Label is the name of the compression, else the name of Fallback.
+	Label string
+	// Encoder and Decoder are the command lines, the command followed by
+	// its arguments. The ExternalIn argument is replaced by the path of a
+	// file holding the input, else the input is written to the standard
+	// input. The ExternalOut argument is replaced by the path of a file for
+	// the command to write, else its standard output is the output.
+	Encoder []string
+	Decoder []string
+	// Fallback decodes if Decoder is empty, and encodes if Encoder is.
+	Fallback Compressor
+
+	// ctx is the Context of the Options the compressor was selected with.
+	ctx context.Context
+}
+
+// ParseExternalCommand splits a command line of External at spaces. It does
+// not handle quotes.
+func ParseExternalCommand(cmd string) ([]string, error) {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command line %q", cmd)
+	}
+	return args, nil
+}
+
+// Name returns the type of compression employed.
+func (c *External) Name() string {
+	if c.Label != "" {
+		return c.Label
+	}
+	if c.Fallback != nil {
+		return c.Fallback.Name()
+	}
+	return "EXTERNAL"
+}
+
+// Decode decodes a byte slice with the Decoder command, or with Fallback.
+func (c *External) Decode(encodedData []byte) ([]byte, error) {
+	if len(c.Decoder) == 0 {
+		if c.Fallback == nil {
+			return nil, fmt.Errorf("%s: no decoder command", c.Name())
+		}
+		return c.Fallback.Decode(encodedData)
+	}
+	return c.run(c.Decoder, encodedData)
+}
+
+// DecodeLimit implements LimitedDecoder. Only Fallback stops decoding at
+// the limit, the output of a command is checked once it exits.
+func (c *External) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
+	if len(c.Decoder) == 0 && c.Fallback != nil {
+		return DecodeLimit(c.Fallback, encodedData, max)
+	}
+	decodedData, err := c.Decode(encodedData)
+	if err != nil {
+		return nil, err
+	}
+	if max != 0 && uint64(len(decodedData)) > max {
+		return nil, fmt.Errorf("%s: %w of %d bytes", c.Name(), ErrSizeLimit, max)
+	}
+	return decodedData, nil
+}
+
+// Encode encodes a byte slice with the Encoder command, or with Fallback.
+func (c *External) Encode(decodedData []byte) ([]byte, error) {
+	if len(c.Encoder) == 0 {
+		if c.Fallback == nil {
+			return nil, fmt.Errorf("%s: no encoder command", c.Name())
+		}
+		return c.Fallback.Encode(decodedData)
+	}
+	return c.run(c.Encoder, decodedData)
+}
+
+// run runs the command line on the input and returns its output.
+func (c *External) run(cmdline []string, input []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "fiano-external")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+
+	args := make([]string, len(cmdline))
+	var fileIn, fileOut bool
+	for i, a := range cmdline {
+		switch a {
+		case ExternalIn:
+			a, fileIn = in, true
+		case ExternalOut:
+			a, fileOut = out, true
+		}
+		args[i] = a
+	}
+
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if fileIn {
+		if err := os.WriteFile(in, input, 0600); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%s: %s: %w", c.Name(), args[0], ctxErr)
+		}
+		return nil, fmt.Errorf("%s: %s: %v: %s", c.Name(), args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if fileOut {
+		return os.ReadFile(out)
+	}
+	return stdout.Bytes(), nil
+}
+
+// GUIDFromName returns the GUID of the sections compressed with the
+// compression of a name: LZMA, LZMAX86, ZLIB or Tiano, or the GUID itself.
+func GUIDFromName(name string) (guid.GUID, error) {
+	switch strings.ToUpper(name) {
+	case "LZMA":
+		return LZMAGUID, nil
+	case "LZMAX86":
+		return LZMAX86GUID, nil
+	case "ZLIB":
+		return ZLIBGUID, nil
+	case "TIANO":
+		return TianoGUID, nil
+	}
+	g, err := guid.Parse(name)
+	if err != nil {
+		return guid.GUID{}, fmt.Errorf("unknown compression %q, want LZMA, LZMAX86, ZLIB, Tiano or a GUID", name)
+	}
+	return *g, nil
+}
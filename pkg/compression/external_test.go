@@ -0,0 +1,114 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+func TestExternal(t *testing.T) {
+	for _, cmd := range []string{"cp", "tr"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("no %s command: %v", cmd, err)
+		}
+	}
+	c := &External{
+		Label: "ROT13",
+		// Through the standard input and output, and through files.
+		Encoder: []string{"tr", "a-z", "n-za-m"},
+		Decoder: []string{"cp", ExternalIn, ExternalOut},
+	}
+	encoded, err := c.Encode([]byte("fiano"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != "svnab" {
+		t.Errorf("encoded to %q, want %q", encoded, "svnab")
+	}
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, encoded) {
+		t.Errorf("copied to %q, want %q", decoded, encoded)
+	}
+	if _, err := c.DecodeLimit(encoded, 2); !errors.Is(err, ErrSizeLimit) {
+		t.Errorf("DecodeLimit: got %v, want %v", err, ErrSizeLimit)
+	}
+
+	c.Encoder = []string{"cp", "/nonexistent/fiano", ExternalOut}
+	if _, err := c.Encode(nil); err == nil || !strings.Contains(err.Error(), "ROT13: cp") {
+		t.Errorf("failing command: got %v, want an error naming it", err)
+	}
+}
+
+func TestOptionsExternals(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skipf("no cat command: %v", err)
+	}
+	g, err := GUIDFromName("zlib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := Options{Externals: map[guid.GUID]*External{g: {Label: "cat", Encoder: []string{"cat"}}}}
+
+	c := o.CompressorFromGUID(&ZLIBGUID)
+	if c.Name() != "cat" {
+		t.Fatalf("got %s compressor, want cat", c.Name())
+	}
+	encoded, err := (&ZLIB{}).Encode([]byte("fiano"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a decoder command, the ZLIB compressor decodes.
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "fiano" {
+		t.Errorf("decoded to %q, want %q", decoded, "fiano")
+	}
+
+	if c := CompressorFromGUID(&ZLIBGUID); c.Name() != "ZLIB" {
+		t.Errorf("got %s compressor without options, want ZLIB", c.Name())
+	}
+	// Without an encoder command, the ZLIB compressor encodes, and names
+	// the compression.
+	o.Externals[g] = &External{Decoder: []string{"cat"}}
+	if c := o.CompressorFromGUID(&ZLIBGUID); c.Name() != "ZLIB" {
+		t.Errorf("got %s compressor without a label, want ZLIB", c.Name())
+	}
+	if _, err := o.CompressorFromGUID(&ZLIBGUID).Encode([]byte("fiano")); err != nil {
+		t.Errorf("encoding without an encoder command: %v", err)
+	}
+
+	if _, err := GUIDFromName("zstd"); err == nil {
+		t.Errorf("GUIDFromName(zstd) succeeded, want an error")
+	}
+}
+
+func TestExternalContext(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skipf("no sleep command: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	o := Options{Externals: map[guid.GUID]*External{ZLIBGUID: {Encoder: []string{"sleep", "60"}}}, Context: ctx}
+	start := time.Now()
+	if _, err := o.CompressorFromGUID(&ZLIBGUID).Encode([]byte("fiano")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("the command ran for %v after the context was done", d)
+	}
+}
@@ -24,16 +24,25 @@ func (c *LZ4) Decode(encodedData []byte) ([]byte, error) {
 	return io.ReadAll(lz4.NewReader(bytes.NewBuffer(encodedData)))
 }
 
+// DecodeLimit implements LimitedDecoder.
+func (c *LZ4) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
+	return readAllLimit(c.Name(), lz4.NewReader(bytes.NewBuffer(encodedData)), max)
+}
+
 // Encode encodes a byte slice with LZ4.
 func (c *LZ4) Encode(decodedData []byte) ([]byte, error) {
 
-	buf := bytes.Buffer{}
-	w := lz4.NewWriter(&buf)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := lz4.NewWriter(buf)
 	_, err := w.Write(decodedData)
 	if err != nil {
 		return nil, err
 	}
-	w.Flush()
+	// Close, rather than Flush, so that the frame gets its end mark.
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
 
-	return buf.Bytes(), nil
+	return detach(buf), nil
 }
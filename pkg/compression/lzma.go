@@ -6,6 +6,8 @@ package compression
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/ulikunitz/xz/lzma"
@@ -15,8 +17,26 @@ import (
 var lzmaDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
 var compressionLevel = 7
 
+// LZMAProperties are the literal context bits, the literal position bits
+// and the position bits of LZMA.
+type LZMAProperties struct {
+	LC, LP, PB int
+}
+
+// defaultLZMAProperties are those of xz and EDK2.
+var defaultLZMAProperties = LZMAProperties{LC: 3, LP: 0, PB: 2}
+
 // LZMA implements Compressor and uses a Go-based implementation.
-type LZMA struct{}
+//
+// Some decompressors only accept the parameters of the compressor they were
+// built with, so the encoder parameters can be set. Their zero values are
+// those of xz -7.
+type LZMA struct {
+	// DictCap is the dictionary size in the header. 0 means 16 MiB.
+	DictCap int
+	// Properties are lc, lp and pb. nil means lc=3, lp=0 and pb=2.
+	Properties *LZMAProperties
+}
 
 // Name returns the type of compression employed.
 func (c *LZMA) Name() string {
@@ -32,29 +52,62 @@ func (c *LZMA) Decode(encodedData []byte) ([]byte, error) {
 	return io.ReadAll(r)
 }
 
+// DecodeLimit implements LimitedDecoder.
+func (c *LZMA) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
+	if len(encodedData) < lzma.HeaderLen {
+		return c.Decode(encodedData)
+	}
+	// The header holds the properties, the dictionary capacity and the
+	// size, all ones if unknown.
+	header := append([]byte{}, encodedData[:lzma.HeaderLen]...)
+	if size := binary.LittleEndian.Uint64(header[5:]); size != ^uint64(0) && size > max {
+		return nil, fmt.Errorf("%s: %w of %d bytes: header says %d", c.Name(), ErrSizeLimit, max, size)
+	}
+	// The decoder allocates the dictionary up front. Data decoding to at
+	// most max bytes never refers further back, so a larger dictionary is
+	// of no use.
+	if dictCap := binary.LittleEndian.Uint32(header[1:]); uint64(dictCap) > max {
+		binary.LittleEndian.PutUint32(header[1:], uint32(max))
+	}
+	r, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(encodedData[lzma.HeaderLen:])))
+	if err != nil {
+		return nil, err
+	}
+	return readAllLimit(c.Name(), r, max)
+}
+
 // Encode encodes a byte slice with LZMA.
 func (c *LZMA) Encode(decodedData []byte) ([]byte, error) {
 	// These options are supported by the xz's LZMA command and EDK2's LZMA.
+	props := defaultLZMAProperties
+	if c.Properties != nil {
+		props = *c.Properties
+	}
+	dictCap := c.DictCap
+	if dictCap == 0 {
+		dictCap = 1 << lzmaDictCapExps[compressionLevel]
+	}
 	wc := lzma.WriterConfig{
 		SizeInHeader: true,
 		Size:         int64(len(decodedData)),
 		EOSMarker:    false,
-		Properties:   &lzma.Properties{LC: 3, LP: 0, PB: 2},
-		DictCap:      1 << lzmaDictCapExps[compressionLevel],
+		Properties:   &lzma.Properties{LC: props.LC, LP: props.LP, PB: props.PB},
+		DictCap:      dictCap,
 	}
 	if err := wc.Verify(); err != nil {
 		return nil, err
 	}
-	buf := &bytes.Buffer{}
+	buf := getBuffer()
+	defer putBuffer(buf)
 	w, err := wc.NewWriter(buf)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := io.Copy(w, bytes.NewBuffer(decodedData)); err != nil {
+	if _, err := w.Write(decodedData); err != nil {
 		return nil, err
 	}
 	if err := w.Close(); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return detach(buf), nil
 }
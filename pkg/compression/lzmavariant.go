@@ -0,0 +1,169 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Variant returns the name of the encoding parameters, as ParseLZMAVariant
+// parses them.
+func (c *LZMA) Variant() string {
+	return c.Name() + lzmaOptions(c.DictCap, c.Properties)
+}
+
+// Variant returns the name of the encoding parameters, as ParseLZMAVariant
+// parses them.
+func (c *SystemLZMA) Variant() string {
+	return c.Name() + lzmaOptions(c.DictCap, c.Properties)
+}
+
+// Variant returns the name of the encoding parameters, as ParseLZMAVariant
+// parses them.
+func (c *LZMAX86) Variant() string {
+	v := c.Name()
+	switch l := c.lzma.(type) {
+	case *LZMA:
+		v += lzmaOptions(l.DictCap, l.Properties)
+	case *SystemLZMA:
+		v += lzmaOptions(l.DictCap, l.Properties)
+	}
+	return v
+}
+
+func lzmaOptions(dictCap int, props *LZMAProperties) string {
+	var v string
+	if dictCap != 0 {
+		v += ",dict=" + dictCapString(dictCap)
+	}
+	if props != nil {
+		v += fmt.Sprintf(",lc=%d,lp=%d,pb=%d", props.LC, props.LP, props.PB)
+	}
+	return v
+}
+
+// dictCapString writes dictionary sizes in the largest binary unit which
+// divides them, like xz.
+func dictCapString(n int) string {
+	switch {
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return strconv.Itoa(n)
+}
+
+// ParseLZMAVariant parses the name of LZMA encoding parameters: LZMA, or
+// LZMAX86 for the x86 BCJ filter, followed by comma separated options:
+//
+//	dict=SIZE  the dictionary size, like 1MiB
+//	lc=N       the literal context bits, 0 to 8
+//	lp=N       the literal position bits, 0 to 4
+//	pb=N       the position bits, 0 to 4
+//
+// lc, lp and pb not given are those of xz, 3, 0 and 2. The encoder is the
+// Go-based one, whatever the parameters.
+func ParseLZMAVariant(variant string) (Compressor, error) {
+	opts := strings.Split(variant, ",")
+	c := &LZMA{}
+	var x86 bool
+	switch strings.ToUpper(opts[0]) {
+	case "LZMA":
+	case "LZMAX86":
+		x86 = true
+	default:
+		return nil, fmt.Errorf("unknown LZMA variant %q, want LZMA or LZMAX86", opts[0])
+	}
+	for _, o := range opts[1:] {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("unknown LZMA option %q", o)
+		}
+		if kv[0] == "dict" {
+			n, err := humanize.ParseBytes(kv[1])
+			if err != nil || n < 4096 || n > 1<<32-1 {
+				return nil, fmt.Errorf("bad LZMA dictionary size %q, want 4KiB to 4GiB", kv[1])
+			}
+			c.DictCap = int(n)
+			continue
+		}
+		var field *int
+		max := 4
+		if c.Properties == nil {
+			p := defaultLZMAProperties
+			c.Properties = &p
+		}
+		switch kv[0] {
+		case "lc":
+			field, max = &c.Properties.LC, 8
+		case "lp":
+			field = &c.Properties.LP
+		case "pb":
+			field = &c.Properties.PB
+		default:
+			return nil, fmt.Errorf("unknown LZMA option %q", o)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 || n > max {
+			return nil, fmt.Errorf("bad LZMA %s %q, want 0 to %d", kv[0], kv[1], max)
+		}
+		*field = n
+	}
+	if x86 {
+		return &LZMAX86{c}, nil
+	}
+	return c, nil
+}
+
+// lzmaWithVariant returns the compressor of the variant for an LZMA
+// compressor c, with the encoder of c, xz or Go-based.
+func lzmaWithVariant(c Compressor, variant string) (Compressor, error) {
+	v, err := ParseLZMAVariant(variant)
+	if err != nil {
+		return nil, err
+	}
+	sys, ok := c.(*SystemLZMA)
+	if x86, isX86 := c.(*LZMAX86); isX86 {
+		sys, ok = x86.lzma.(*SystemLZMA)
+	}
+	if !ok {
+		return v, nil
+	}
+	switch v := v.(type) {
+	case *LZMA:
+		return &SystemLZMA{xzPath: sys.xzPath, DictCap: v.DictCap, Properties: v.Properties}, nil
+	case *LZMAX86:
+		l := v.lzma.(*LZMA)
+		return &LZMAX86{&SystemLZMA{xzPath: sys.xzPath, DictCap: l.DictCap, Properties: l.Properties}}, nil
+	}
+	return v, nil
+}
+
+// detectLZMAVariant returns the variant of the parameters in the header of
+// LZMA data, or "" for those of xz -7.
+func detectLZMAVariant(name string, encodedData []byte) string {
+	if len(encodedData) < 5 || encodedData[0] >= 9*5*5 {
+		return ""
+	}
+	p := int(encodedData[0])
+	props := &LZMAProperties{LC: p % 9, LP: p / 9 % 5, PB: p / 45}
+	if *props == defaultLZMAProperties {
+		props = nil
+	}
+	dictCap := int(binary.LittleEndian.Uint32(encodedData[1:]))
+	if dictCap == 1<<lzmaDictCapExps[compressionLevel] {
+		dictCap = 0
+	}
+	if options := lzmaOptions(dictCap, props); options != "" {
+		return name + options
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a scratch buffer is dropped
+// rather than pooled, so one large volume does not pin its memory.
+const maxPooledBuffer = 16 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getBuffer returns an empty scratch buffer, to give back with putBuffer.
+func getBuffer() *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
+
+// detach returns a copy of the bytes of a scratch buffer, which the caller
+// keeps after the buffer goes back to the pool.
+func detach(b *bytes.Buffer) []byte {
+	out := make([]byte, b.Len())
+	copy(out, b.Bytes())
+	return out
+}
+
+// zlibWriters holds writers at zlibCompressionLevel, whose tables are
+// large enough to be worth keeping.
+var zlibWriters sync.Pool
+
+func getZlibWriter(w io.Writer) (*zlib.Writer, error) {
+	if zw, ok := zlibWriters.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return zw, nil
+	}
+	return zlib.NewWriterLevel(w, zlibCompressionLevel)
+}
+
+func putZlibWriter(zw *zlib.Writer) {
+	zlibWriters.Put(zw)
+}
@@ -7,6 +7,7 @@ package compression
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"os/exec"
 )
 
@@ -16,6 +17,11 @@ import (
 // implementation.
 type SystemLZMA struct {
 	xzPath string
+
+	// DictCap and Properties are the parameters of the LZMA filter, like
+	// for LZMA, those of xz -7 if not set.
+	DictCap    int
+	Properties *LZMAProperties
 }
 
 // Name returns the type of compression employed.
@@ -36,9 +42,24 @@ func (c *SystemLZMA) Decode(encodedData []byte) ([]byte, error) {
 	return (&LZMA{}).Decode(encodedData)
 }
 
+// DecodeLimit implements LimitedDecoder, with the Go decompressor too.
+func (c *SystemLZMA) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
+	return (&LZMA{}).DecodeLimit(encodedData, max)
+}
+
 // Encode encodes a byte slice with LZMA.
 func (c *SystemLZMA) Encode(decodedData []byte) ([]byte, error) {
-	cmd := exec.Command(c.xzPath, "--format=lzma", "-7", "--stdout")
+	filter := "-7"
+	if c.DictCap != 0 || c.Properties != nil {
+		filter = "--lzma1=preset=7"
+		if c.DictCap != 0 {
+			filter += fmt.Sprintf(",dict=%d", c.DictCap)
+		}
+		if p := c.Properties; p != nil {
+			filter += fmt.Sprintf(",lc=%d,lp=%d,pb=%d", p.LC, p.LP, p.PB)
+		}
+	}
+	cmd := exec.Command(c.xzPath, "--format=lzma", filter, "--stdout")
 	cmd.Stdin = bytes.NewBuffer(decodedData)
 	encodedData, err := cmd.Output()
 	if err != nil {
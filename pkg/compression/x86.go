@@ -27,6 +27,17 @@ func (c *LZMAX86) Decode(encodedData []byte) ([]byte, error) {
 	return decodedData, nil
 }
 
+// DecodeLimit implements LimitedDecoder.
+func (c *LZMAX86) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
+	decodedData, err := DecodeLimit(c.lzma, encodedData, max)
+	if err != nil {
+		return nil, err
+	}
+	var x86State uint32
+	x86Convert(decodedData, uint(len(decodedData)), 0, &x86State, false)
+	return decodedData, nil
+}
+
 // Encode encodes LZMA data with the x86 extension.
 func (c *LZMAX86) Encode(decodedData []byte) ([]byte, error) {
 	// x86Convert modifies the input, so a copy is recommened.
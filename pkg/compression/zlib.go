@@ -9,7 +9,6 @@ import (
 	"compress/zlib"
 	"encoding/binary"
 	"errors"
-	"io"
 )
 
 const (
@@ -29,6 +28,11 @@ func (c *ZLIB) Name() string {
 
 // Decode decodes a byte slice of ZLIB data.
 func (c *ZLIB) Decode(encodedData []byte) ([]byte, error) {
+	return c.DecodeLimit(encodedData, 0)
+}
+
+// DecodeLimit implements LimitedDecoder. A max of 0 means no limit.
+func (c *ZLIB) DecodeLimit(encodedData []byte, max uint64) ([]byte, error) {
 	if len(encodedData) < 256 {
 		return nil, errors.New("Zlib.Decode: missing section header")
 	}
@@ -49,7 +53,7 @@ func (c *ZLIB) Decode(encodedData []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	decodedData, err := io.ReadAll(r)
+	decodedData, err := readAllLimit(c.Name(), r, max)
 	r.Close()
 	if err != nil {
 		return nil, err
@@ -60,24 +64,30 @@ func (c *ZLIB) Decode(encodedData []byte) ([]byte, error) {
 
 // Encode encodes a byte slice with ZLIB.
 func (c *ZLIB) Encode(decodedData []byte) ([]byte, error) {
-	var encodedData bytes.Buffer
+	encodedData := getBuffer()
+	defer putBuffer(encodedData)
+
+	// Leave room for the ZLIB section header, which holds the compressed
+	// size and zero padding.
+	encodedData.Write(make([]byte, zlibSectionHeaderSize))
 
-	w, err := zlib.NewWriterLevel(&encodedData, zlibCompressionLevel)
+	w, err := getZlibWriter(encodedData)
 	if err != nil {
 		return nil, err
 	}
-
 	_, err = w.Write(decodedData)
-	w.Close()
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	putZlibWriter(w)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add ZLIB section header containing the compressed size and zero padding.
-	zlib_header := make([]byte, zlibSectionHeaderSize)
+	out := detach(encodedData)
 	binary.LittleEndian.PutUint32(
-		zlib_header[zlibSizeOffset:],
-		uint32(len(encodedData.Bytes())),
+		out[zlibSizeOffset:],
+		uint32(len(out)-zlibSectionHeaderSize),
 	)
-	return append(zlib_header, encodedData.Bytes()[:]...), nil
+	return out, nil
 }
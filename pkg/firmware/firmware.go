@@ -0,0 +1,203 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package firmware opens, edits and saves firmware images without using the
+// visitors directly. Every method of Image runs the visitor of the utk
+// operation of the same name.
+package firmware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// Image is a parsed firmware image.
+type Image struct {
+	// Root is the root of the tree of the image, which the visitors may
+	// also be run on.
+	Root uefi.Firmware
+
+	ctx context.Context
+}
+
+// Open parses the image, or the directory or archive it was extracted to.
+func Open(path string) (*Image, error) {
+	return OpenContext(context.Background(), path)
+}
+
+// OpenContext is like Open, but parses the image with the options of ctx,
+// and runs the methods of the image with ctx.
+func OpenContext(ctx context.Context, path string) (*Image, error) {
+	f, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDir() || visitors.IsArchive(path) {
+		pd := visitors.ParseDir{BasePath: path}
+		root, err := pd.Parse()
+		if err != nil {
+			return nil, err
+		}
+		// Assemble the tree from the bottom up.
+		a := &visitors.Assemble{}
+		a.SetContext(ctx)
+		if err := a.Run(root); err != nil {
+			return nil, err
+		}
+		return &Image{Root: root, ctx: ctx}, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(ctx, buf)
+}
+
+// Read parses the image read from r.
+func Read(r io.Reader) (*Image, error) {
+	return ReadContext(context.Background(), r)
+}
+
+// ReadContext is like Read, but parses the image with the options of ctx,
+// and runs the methods of the image with ctx.
+func ReadContext(ctx context.Context, r io.Reader) (*Image, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parse(ctx, buf)
+}
+
+func parse(ctx context.Context, buf []byte) (*Image, error) {
+	root, err := uefi.ParseContext(ctx, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Root: root, ctx: ctx}, nil
+}
+
+// Context returns the context the methods of the image run with.
+func (im *Image) Context() context.Context {
+	if im.ctx == nil {
+		return context.Background()
+	}
+	return im.ctx
+}
+
+func (im *Image) run(v uefi.Visitor) error {
+	return visitors.ExecuteCLIContext(im.Context(), im.Root, []uefi.Visitor{v})
+}
+
+// Find returns the files whose GUID or UI section matches the regular
+// expression, or the nodes selected by the query if it starts with "/".
+func (im *Image) Find(query string) ([]uefi.Firmware, error) {
+	pred, err := visitors.FindFilePredicate(query)
+	if err != nil {
+		return nil, err
+	}
+	find := &visitors.Find{Predicate: pred}
+	if err := im.run(find); err != nil {
+		return nil, err
+	}
+	return find.Matches, nil
+}
+
+// Files returns the files of the image in the order of the image, the
+// files nested in a file following it.
+func (im *Image) Files() ([]*uefi.File, error) {
+	find := &visitors.Find{Predicate: func(f uefi.Firmware) bool {
+		_, ok := f.(*uefi.File)
+		return ok
+	}}
+	if err := im.run(find); err != nil {
+		return nil, err
+	}
+	files := make([]*uefi.File, len(find.Matches))
+	for i, m := range find.Matches {
+		files[i] = m.(*uefi.File)
+	}
+	return files, nil
+}
+
+// Insert inserts file relative to the target, a GUID or name like those of
+// Find. The target is a file for InsertTypeAfter, InsertTypeBefore and
+// InsertTypeReplaceFFS, and a volume, or a file inside it, for
+// InsertTypeFront and InsertTypeEnd. InsertTypeDXE ignores the target.
+func (im *Image) Insert(file *uefi.File, where visitors.InsertType, target string) error {
+	var pred visitors.FindPredicate
+	var err error
+	switch where {
+	case visitors.InsertTypeDXE:
+		pred = visitors.FindFileTypePredicate(uefi.FVFileTypeDXECore)
+	case visitors.InsertTypeFront, visitors.InsertTypeEnd:
+		pred, err = visitors.FindFileFVPredicate(target)
+	case visitors.InsertTypeAfter, visitors.InsertTypeBefore, visitors.InsertTypeReplaceFFS:
+		pred, err = visitors.FindFilePredicate(target)
+	default:
+		return fmt.Errorf("unsupported insert type %v", where)
+	}
+	if err != nil {
+		return err
+	}
+	return im.run(&visitors.Insert{Predicate: pred, NewFile: file, InsertType: where})
+}
+
+// Replace replaces the PE32 or TE section of the one file matching the
+// target with the image.
+func (im *Image) Replace(target string, pe32 []byte) error {
+	pred, err := visitors.FindFilePredicate(target)
+	if err != nil {
+		return err
+	}
+	return im.run(&visitors.ReplacePE32{Predicate: pred, NewPE32: pe32})
+}
+
+// ErrNotFound is returned by Remove when no file matches.
+var ErrNotFound = errors.New("no matching file")
+
+// Remove removes the files matching the target. With pad, they are
+// replaced by pad files of the same size, so the other files keep their
+// offsets.
+func (im *Image) Remove(target string, pad bool) error {
+	pred, err := visitors.FindFilePredicate(target)
+	if err != nil {
+		return err
+	}
+	remove := &visitors.Remove{Predicate: pred, Pad: pad}
+	if err := im.run(remove); err != nil {
+		return err
+	}
+	if len(remove.Matches) == 0 {
+		return fmt.Errorf("%w: %q", ErrNotFound, target)
+	}
+	return nil
+}
+
+// Validate returns the problems found in the image, none if it is valid.
+func (im *Image) Validate() ([]error, error) {
+	validate := &visitors.Validate{}
+	if err := im.run(validate); err != nil {
+		return nil, err
+	}
+	return validate.Errors, nil
+}
+
+// Bytes assembles the image and returns it.
+func (im *Image) Bytes() ([]byte, error) {
+	if err := im.run(&visitors.Assemble{}); err != nil {
+		return nil, err
+	}
+	return im.Root.Buf(), nil
+}
+
+// Save assembles the image and writes it to path.
+func (im *Image) Save(path string) error {
+	return im.run(&visitors.Save{DirPath: path})
+}
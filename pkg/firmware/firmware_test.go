@@ -0,0 +1,102 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package firmware
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+const ovmfPath = "../../integration/roms/OVMF.rom"
+
+func TestImageRemoveAndSave(t *testing.T) {
+	im, err := Open(ovmfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell, err := im.Find("Shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shell) != 1 {
+		t.Fatalf("found %d Shell files, want 1", len(shell))
+	}
+	if err := im.Remove("Shell", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Remove("Shell", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing the removed Shell returned %v, want ErrNotFound", err)
+	}
+	if errs, err := im.Validate(); err != nil || len(errs) != 0 {
+		t.Fatalf("validating returned %v, %v, want no errors", errs, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "OVMF.rom")
+	if err := im.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell, err := saved.Find("Shell"); err != nil || len(shell) != 0 {
+		t.Errorf("found %v, %v in the saved image, want no Shell", shell, err)
+	}
+}
+
+func TestImageInsertAndReplace(t *testing.T) {
+	f, err := os.Open(ovmfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	im, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell, err := im.Find("Shell")
+	if err != nil || len(shell) != 1 {
+		t.Fatalf("found %v, %v, want the Shell", shell, err)
+	}
+	file, err := uefi.NewFile(shell[0].Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Header.GUID[0]++
+	if err := im.Insert(file, visitors.InsertTypeAfter, "Shell"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := im.Find(file.Header.GUID.String()); err != nil || len(got) != 1 {
+		t.Fatalf("found %v, %v, want the inserted file", got, err)
+	}
+
+	if err := im.Replace(file.Header.GUID.String(), []byte("MZbanana")); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Replace(file.Header.GUID.String(), []byte("banana")); err == nil {
+		t.Errorf("replacing with an image which is not PE32 succeeded, want an error")
+	}
+	if _, err := im.Bytes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageInvalidArguments(t *testing.T) {
+	im, err := Open(ovmfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := im.Find("/["); err == nil {
+		t.Errorf("finding an invalid query succeeded, want an error")
+	}
+	if err := im.Insert(nil, visitors.InsertTypeInsert, ""); err == nil {
+		t.Errorf("inserting with InsertTypeInsert succeeded, want an error")
+	}
+}
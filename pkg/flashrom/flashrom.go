@@ -0,0 +1,191 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package flashrom reads and writes flash chips with flashrom, writing only
+// the regions of the chip an edit changed.
+package flashrom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// Flashrom runs flashrom with a programmer.
+type Flashrom struct {
+	// Path of flashrom, found in $PATH if empty.
+	Path string
+	// Programmer is the argument of -p, like internal or
+	// ch341a_spi.
+	Programmer string
+	// Args are more arguments, like -c to select the chip.
+	Args []string
+	// Stderr gets the output of flashrom, which is discarded if nil.
+	Stderr io.Writer
+}
+
+func (f *Flashrom) run(ctx context.Context, args ...string) error {
+	path := f.Path
+	if path == "" {
+		path = "flashrom"
+	}
+	args = append(append([]string{"-p", f.Programmer}, f.Args...), args...)
+	cmd := exec.CommandContext(ctx, path, args...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	if f.Stderr != nil {
+		f.Stderr.Write(out.Bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("flashrom %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// Read reads the flash chip.
+func (f *Flashrom) Read(ctx context.Context) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "fiano-flashrom")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flash.bin")
+	if err := f.run(ctx, "-r", path); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// Write writes the named regions of the image to the flash chip, laid out
+// by the regions of layout.
+func (f *Flashrom) Write(ctx context.Context, image []byte, layout []Region, names []string) error {
+	if len(names) == 0 {
+		return errors.New("no region to write")
+	}
+	dir, err := os.MkdirTemp("", "fiano-flashrom")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var l bytes.Buffer
+	if err := WriteLayout(&l, layout); err != nil {
+		return err
+	}
+	layoutPath, imagePath := filepath.Join(dir, "layout.txt"), filepath.Join(dir, "image.bin")
+	if err := os.WriteFile(layoutPath, l.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(imagePath, image, 0644); err != nil {
+		return err
+	}
+	args := []string{"-l", layoutPath}
+	for _, n := range names {
+		args = append(args, "-i", n)
+	}
+	return f.run(ctx, append(args, "-w", imagePath)...)
+}
+
+type flashromKey struct{}
+
+// WithFlashrom returns a context whose Flash visitors write with f.
+func WithFlashrom(ctx context.Context, f *Flashrom) context.Context {
+	return context.WithValue(ctx, flashromKey{}, f)
+}
+
+// FromContext returns the Flashrom of ctx, nil if it has none.
+func FromContext(ctx context.Context) *Flashrom {
+	f, _ := ctx.Value(flashromKey{}).(*Flashrom)
+	return f
+}
+
+// Flash assembles the image and writes the regions of the flash chip which
+// differ from it, reading the chip again to compare. The regions are those
+// of the flash descriptor or the fmap of the chip.
+type Flash struct {
+	visitors.Cancelable
+
+	// Flashrom writes, else the one of the context.
+	Flashrom *Flashrom
+	// W gets the regions written, if not nil.
+	W io.Writer
+
+	// Output
+	Written []Region
+}
+
+func (v *Flash) flashrom() *Flashrom {
+	if v.Flashrom != nil {
+		return v.Flashrom
+	}
+	return FromContext(v.Context())
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Flash) Run(f uefi.Firmware) error {
+	return v.Visit(f)
+}
+
+// Visit writes f to the flash chip.
+func (v *Flash) Visit(f uefi.Firmware) error {
+	fr := v.flashrom()
+	if fr == nil {
+		return errors.New("flash: no flashrom programmer, like utk -flashrom internal")
+	}
+	a := &visitors.Assemble{}
+	a.SetContext(v.Context())
+	if err := a.Run(f); err != nil {
+		return err
+	}
+	old, err := fr.Read(v.Context())
+	if err != nil {
+		return err
+	}
+	layout, err := Layout(old)
+	if err != nil {
+		return err
+	}
+	changed, err := Changed(old, f.Buf(), layout)
+	if err != nil {
+		return err
+	}
+	v.Written = changed
+	if len(changed) == 0 {
+		if v.W != nil {
+			fmt.Fprintln(v.W, "flash: no region changed")
+		}
+		return nil
+	}
+	names := make([]string, len(changed))
+	for i, r := range changed {
+		names[i] = r.Name
+		if v.W != nil {
+			fmt.Fprintf(v.W, "flash: writing %v\n", r)
+		}
+	}
+	return fr.Write(v.Context(), f.Buf(), layout, names)
+}
+
+// Outputs implements visitors.Outputter, so dry runs do not flash.
+func (v *Flash) Outputs() []string {
+	if fr := v.flashrom(); fr != nil {
+		return []string{"flashrom:" + fr.Programmer}
+	}
+	return []string{"flashrom"}
+}
+
+func init() {
+	visitors.RegisterCLI("flash", "write the regions of the flash chip which differ from the image with the programmer of -flashrom", 0, func(args []string) (uefi.Visitor, error) {
+		return &Flash{W: os.Stdout}, nil
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashrom
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// ifdImage returns a 256KiB flash image with a descriptor, a GbE region at
+// 0x1000 and a BIOS region at 0x8000 holding the OVMF SEC volume.
+func ifdImage(t *testing.T) []byte {
+	fv, err := os.ReadFile("../../integration/roms/ovmfSECFV.fv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Repeat([]byte{0xff}, 0x40000)
+	copy(buf[0x8000:], fv)
+	copy(buf, make([]byte, uefi.FlashDescriptorLength))
+	copy(buf[16:], uefi.FlashSignature)
+	copy(buf[20:], []byte{0x03, 0x00, 0x04, 0x00, 0x08, 0x03})
+	for _, r := range []struct {
+		t           uefi.FlashRegionType
+		base, limit uint16
+	}{{uefi.RegionTypeBIOS, 8, 0x3f}, {uefi.RegionTypeGBE, 1, 2}} {
+		binary.LittleEndian.PutUint16(buf[0x44+4*int(r.t):], r.base)
+		binary.LittleEndian.PutUint16(buf[0x46+4*int(r.t):], r.limit)
+	}
+	return buf
+}
+
+func TestLayoutIFD(t *testing.T) {
+	got, err := Layout(ifdImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Region{{"fd", 0, 0x1000}, {"gbe", 0x1000, 0x3000}, {"bios", 0x8000, 0x40000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var l bytes.Buffer
+	if err := WriteLayout(&l, got); err != nil {
+		t.Fatal(err)
+	}
+	wantLayout := "00000000:00000fff fd\n00001000:00002fff gbe\n00008000:0003ffff bios\n"
+	if l.String() != wantLayout {
+		t.Errorf("got layout %q, want %q", l.String(), wantLayout)
+	}
+}
+
+func TestLayoutFMAP(t *testing.T) {
+	buf := bytes.Repeat([]byte{0xff}, 0x40000)
+	var m bytes.Buffer
+	hdr := fmap.Header{VerMajor: 1, Size: 0x40000, NAreas: 3}
+	copy(hdr.Signature[:], fmap.Signature)
+	areas := []fmap.Area{{Offset: 0, Size: 0x20000}, {Offset: 0x1000, Size: 0x1000}, {Offset: 0x20000, Size: 0x20000}}
+	for i, n := range []string{"RO", "FMAP", "RW"} {
+		copy(areas[i].Name.Value[:], n)
+	}
+	for _, d := range []interface{}{&hdr, areas} {
+		if err := binary.Write(&m, binary.LittleEndian, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copy(buf[0x1000:], m.Bytes())
+
+	layout, err := Layout(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Region{{"RO", 0, 0x20000}, {"FMAP", 0x1000, 0x2000}, {"RW", 0x20000, 0x40000}}
+	if !reflect.DeepEqual(layout, want) {
+		t.Fatalf("got %v, want %v", layout, want)
+	}
+
+	// The smallest area holding a change is written.
+	changed := append([]byte{}, buf...)
+	changed[0x1800] = 0
+	changed[0x30000] = 0
+	got, err := Changed(buf, changed, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Region{want[1], want[2]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got changed regions %v, want %v", got, want)
+	}
+
+	if _, err := Layout(bytes.Repeat([]byte{0xff}, 0x1000)); err != ErrNoLayout {
+		t.Errorf("laying out an erased image returned %v, want ErrNoLayout", err)
+	}
+}
+
+func TestChangedOutside(t *testing.T) {
+	buf := ifdImage(t)
+	layout, err := Layout(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := append([]byte{}, buf...)
+	changed[0x4000] = 0
+	if _, err := Changed(buf, changed, layout); err == nil {
+		t.Errorf("changing a byte outside of the regions succeeded, want an error")
+	}
+	if _, err := Changed(buf, buf[:0x1000], layout); err == nil {
+		t.Errorf("comparing images of different sizes succeeded, want an error")
+	}
+}
+
+// fakeFlashrom returns a Flashrom running a script which reads chip, logs
+// its arguments to the returned log, and copies the image and layout it
+// writes next to it.
+func fakeFlashrom(t *testing.T, chip []byte) (*Flashrom, string) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "chip"), chip, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "flashrom")
+	if err := os.WriteFile(script, []byte(`#!/bin/sh
+dir=$(dirname "$0")
+echo "$@" >> "$dir/log"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-r) cp "$dir/chip" "$2"; shift ;;
+	-w) cp "$2" "$dir/written"; shift ;;
+	-l) cp "$2" "$dir/layout"; shift ;;
+	esac
+	shift
+done
+`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &Flashrom{Path: script, Programmer: "dummy"}, dir
+}
+
+func TestFlash(t *testing.T) {
+	chip := ifdImage(t)
+	fr, dir := fakeFlashrom(t, chip)
+	ctx := WithFlashrom(context.Background(), fr)
+
+	read, err := fr.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := uefi.Parse(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flash := &Flash{}
+	if err := visitors.ExecuteCLIContext(ctx, f, []uefi.Visitor{flash}); err != nil {
+		t.Fatal(err)
+	}
+	if len(flash.Written) != 0 {
+		t.Errorf("wrote %v to the unchanged flash, want nothing", flash.Written)
+	}
+
+	remove := &visitors.Remove{Predicate: visitors.FindFileTypePredicate(uefi.FVFileTypeSECCore), Pad: true}
+	flash = &Flash{}
+	if err := visitors.ExecuteCLIContext(ctx, f, []uefi.Visitor{remove, flash}); err != nil {
+		t.Fatal(err)
+	}
+	if len(remove.Matches) != 1 {
+		t.Fatalf("removed %d files, want the SEC core", len(remove.Matches))
+	}
+	if want := []Region{{"bios", 0x8000, 0x40000}}; !reflect.DeepEqual(flash.Written, want) {
+		t.Errorf("wrote %v, want %v", flash.Written, want)
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "-p dummy -l ") || !strings.Contains(last, " -i bios -w ") {
+		t.Errorf("ran flashrom %q, want it to write the bios region", last)
+	}
+	written, err := os.ReadFile(filepath.Join(dir, "written"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, f.Buf()) {
+		t.Errorf("flashrom wrote another image than the assembled one")
+	}
+
+	if err := (&Flash{}).Run(f); err == nil {
+		t.Errorf("flashing without flashrom succeeded, want an error")
+	}
+}
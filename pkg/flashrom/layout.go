@@ -0,0 +1,121 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashrom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Region is a range of the flash chip, named in a layout file.
+type Region struct {
+	Name  string
+	Start uint64
+	// End is the offset after the last byte of the region.
+	End uint64
+}
+
+func (r Region) String() string {
+	return fmt.Sprintf("%s [%#x, %#x)", r.Name, r.Start, r.End)
+}
+
+// ErrNoLayout is returned by Layout for images with neither a flash
+// descriptor nor an fmap.
+var ErrNoLayout = errors.New("no flash descriptor or fmap to lay out the image")
+
+// Layout returns the regions of the flash descriptor of the image, the
+// descriptor itself being fd, or else the areas of its fmap, sorted by
+// their start. Areas of an fmap may hold other areas.
+func Layout(image []byte) ([]Region, error) {
+	var regions []Region
+	if _, err := uefi.FindSignature(image); err == nil && len(image) >= uefi.FlashDescriptorLength {
+		fd := &uefi.FlashDescriptor{}
+		fd.SetBuf(image[:uefi.FlashDescriptorLength])
+		if err := fd.ParseFlashDescriptor(); err != nil {
+			return nil, err
+		}
+		regions = append(regions, Region{Name: "fd", End: uefi.RegionBlockSize})
+		for i, r := range fd.Region.FlashRegions {
+			if !r.Valid() {
+				continue
+			}
+			name := strings.ToLower(uefi.FlashRegionType(i).String())
+			regions = append(regions, Region{Name: name, Start: uint64(r.BaseOffset()), End: uint64(r.EndOffset())})
+		}
+	} else {
+		var fm *fmap.FMap
+		for _, c := range fmap.Scan(image) {
+			if c.FMap != nil && c.Err == nil {
+				fm = c.FMap
+				break
+			}
+		}
+		if fm == nil {
+			return nil, ErrNoLayout
+		}
+		for _, a := range fm.Areas {
+			if a.Size != 0 {
+				regions = append(regions, Region{Name: a.Name.String(), Start: uint64(a.Offset), End: a.End()})
+			}
+		}
+	}
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].Start < regions[j].Start })
+	for _, r := range regions {
+		if r.End > uint64(len(image)) {
+			return nil, fmt.Errorf("region %v ends past the image of %#x bytes", r, len(image))
+		}
+	}
+	return regions, nil
+}
+
+// WriteLayout writes the regions as a layout file of flashrom.
+func WriteLayout(w io.Writer, regions []Region) error {
+	for _, r := range regions {
+		if _, err := fmt.Fprintf(w, "%08x:%08x %s\n", r.Start, r.End-1, r.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changed returns the regions in which the images differ, the smallest
+// region holding each byte which changed, in the order of the regions.
+// Bytes which changed outside of the regions are an error, since writing
+// the regions would not write them.
+func Changed(old, new []byte, regions []Region) ([]Region, error) {
+	if len(old) != len(new) {
+		return nil, fmt.Errorf("the image is %#x bytes, the flash %#x", len(new), len(old))
+	}
+	changed := make([]bool, len(regions))
+	for i := range old {
+		if old[i] == new[i] {
+			continue
+		}
+		best := -1
+		for j, r := range regions {
+			if uint64(i) >= r.Start && uint64(i) < r.End &&
+				(best < 0 || r.End-r.Start < regions[best].End-regions[best].Start) {
+				best = j
+			}
+		}
+		if best < 0 {
+			return nil, fmt.Errorf("byte %#x changed outside of the regions of the layout", i)
+		}
+		changed[best] = true
+	}
+	var out []Region
+	for j, r := range regions {
+		if changed[j] {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
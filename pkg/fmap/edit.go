@@ -0,0 +1,238 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Version of the fmaps created by New.
+const (
+	VerMajor = 1
+	VerMinor = 1
+)
+
+// ErrOverlap is returned when an area partially overlaps another one. Areas
+// may only be disjoint or nested.
+var ErrOverlap = errors.New("fmap areas overlap")
+
+// NewString returns s as a String. The name has to fit into the 32 bytes
+// including the terminating NUL.
+func NewString(s string) (String, error) {
+	var r String
+	if len(s) >= len(r.Value) {
+		return r, fmt.Errorf("name %q is longer than %d bytes", s, len(r.Value)-1)
+	}
+	copy(r.Value[:], s)
+	return r, nil
+}
+
+// New creates an empty fmap named name for a flash of size bytes mapped at
+// base.
+func New(name string, base uint64, size uint32) (*FMap, error) {
+	n, err := NewString(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &FMap{Header: Header{VerMajor: VerMajor, VerMinor: VerMinor, Base: base, Size: size, Name: n}}
+	copy(f.Signature[:], Signature)
+	return f, nil
+}
+
+// End returns the offset of the first byte after the area.
+func (a *Area) End() uint64 {
+	return uint64(a.Offset) + uint64(a.Size)
+}
+
+// check verifies that a fits into the flash and doesn't partially overlap
+// any of the areas but the one at index skip.
+func (f *FMap) check(a *Area, skip int) error {
+	if a.End() > uint64(f.Size) {
+		return fmt.Errorf("area %q [%#x, %#x) exceeds the flash size %#x", a.Name.String(), a.Offset, a.End(), f.Size)
+	}
+	for i := range f.Areas {
+		o := &f.Areas[i]
+		if i == skip {
+			continue
+		}
+		if o.Name.String() == a.Name.String() {
+			return fmt.Errorf("area %q already exists", a.Name.String())
+		}
+		disjoint := a.End() <= uint64(o.Offset) || o.End() <= uint64(a.Offset)
+		nested := (a.Offset >= o.Offset && a.End() <= o.End()) || (o.Offset >= a.Offset && o.End() <= a.End())
+		if !disjoint && !nested {
+			return fmt.Errorf("%w: %q [%#x, %#x) and %q [%#x, %#x)", ErrOverlap,
+				a.Name.String(), a.Offset, a.End(), o.Name.String(), o.Offset, o.End())
+		}
+	}
+	return nil
+}
+
+// Validate checks that all areas fit into the flash, have distinct names
+// and don't partially overlap each other.
+func (f *FMap) Validate() error {
+	if int(f.NAreas) != len(f.Areas) {
+		return fmt.Errorf("fmap has %d areas, NAreas is %d", len(f.Areas), f.NAreas)
+	}
+	for i := range f.Areas {
+		if err := f.check(&f.Areas[i], i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddArea adds an area named name.
+func (f *FMap) AddArea(name string, offset, size uint32, flags uint16) error {
+	n, err := NewString(name)
+	if err != nil {
+		return err
+	}
+	a := Area{Offset: offset, Size: size, Name: n, Flags: flags}
+	if err := f.check(&a, -1); err != nil {
+		return err
+	}
+	f.Areas = append(f.Areas, a)
+	f.NAreas = uint16(len(f.Areas))
+	return nil
+}
+
+// RemoveArea removes the area named name.
+func (f *FMap) RemoveArea(name string) error {
+	i := f.IndexOfArea(name)
+	if i == -1 {
+		return fmt.Errorf("FMAP area %q not found", name)
+	}
+	f.Areas = append(f.Areas[:i], f.Areas[i+1:]...)
+	f.NAreas = uint16(len(f.Areas))
+	return nil
+}
+
+// RenameArea renames the area named name to newName.
+func (f *FMap) RenameArea(name, newName string) error {
+	i := f.IndexOfArea(name)
+	if i == -1 {
+		return fmt.Errorf("FMAP area %q not found", name)
+	}
+	n, err := NewString(newName)
+	if err != nil {
+		return err
+	}
+	a := f.Areas[i]
+	a.Name = n
+	if err := f.check(&a, i); err != nil {
+		return err
+	}
+	f.Areas[i] = a
+	return nil
+}
+
+// ResizeArea moves the area named name to offset and sets its size. The
+// contents of the flash are not touched.
+func (f *FMap) ResizeArea(name string, offset, size uint32) error {
+	i := f.IndexOfArea(name)
+	if i == -1 {
+		return fmt.Errorf("FMAP area %q not found", name)
+	}
+	a := f.Areas[i]
+	a.Offset, a.Size = offset, size
+	if err := f.check(&a, i); err != nil {
+		return err
+	}
+	f.Areas[i] = a
+	return nil
+}
+
+// MarshalBinary returns the fmap as stored in the flash.
+func (f *FMap) MarshalBinary() ([]byte, error) {
+	if int(f.NAreas) != len(f.Areas) {
+		return nil, fmt.Errorf("fmap has %d areas, NAreas is %d", len(f.Areas), f.NAreas)
+	}
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, f.Header); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&b, binary.LittleEndian, f.Areas); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// ParseFlags is the inverse of FlagNames.
+func ParseFlags(s string) (uint16, error) {
+	var flags uint16
+	for _, n := range strings.Split(s, "|") {
+		switch n {
+		case "STATIC":
+			flags |= FmapAreaStatic
+		case "COMPRESSED":
+			flags |= FmapAreaCompressed
+		case "READ_ONLY":
+			flags |= FmapAreaReadOnly
+		case "PRESERVE":
+			flags |= FmapAreaPreserve
+		default:
+			v, err := strconv.ParseUint(n, 0, 16)
+			if err != nil {
+				return 0, fmt.Errorf("unknown flag %q", n)
+			}
+			flags |= uint16(v)
+		}
+	}
+	return flags, nil
+}
+
+// ParseLayout reads a flashrom style layout with lines like
+//
+//	00000000:00000fff FMAP [FLAGS]
+//
+// where start and end are inclusive hex offsets and FLAGS are as printed
+// by FlagNames. Empty lines and lines starting with # are skipped. The
+// areas are added to f.
+func (f *FMap) ParseLayout(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		fields := strings.Fields(l)
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("line %d: want START:END NAME [FLAGS], got %q", n, l)
+		}
+		r := strings.SplitN(fields[0], ":", 2)
+		if len(r) != 2 {
+			return fmt.Errorf("line %d: want START:END, got %q", n, fields[0])
+		}
+		start, err := strconv.ParseUint(r[0], 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		end, err := strconv.ParseUint(r[1], 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if end < start || end-start+1 > 0xffffffff {
+			return fmt.Errorf("line %d: invalid range [%#x, %#x]", n, start, end)
+		}
+		var flags uint16
+		if len(fields) == 3 {
+			if flags, err = ParseFlags(fields[2]); err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+		}
+		if err := f.AddArea(fields[1], uint32(start), uint32(end-start+1), flags); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEdit(t *testing.T) {
+	f, err := New("FLASH", 0xff000000, 0x1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []struct {
+		name         string
+		offset, size uint32
+	}{
+		{"SI_BIOS", 0x200000, 0xe00000},
+		{"FMAP", 0x200000, 0x200},
+		{"COREBOOT", 0x200200, 0xdffe00},
+	} {
+		if err := f.AddArea(a.name, a.offset, a.size, 0); err != nil {
+			t.Fatalf("AddArea(%q): %v", a.name, err)
+		}
+	}
+	if err := f.AddArea("RW", 0x100000, 0x200000, 0); !errors.Is(err, ErrOverlap) {
+		t.Errorf("AddArea of overlapping area: got %v, want %v", err, ErrOverlap)
+	}
+	if err := f.AddArea("BIG", 0, 0x1000001, 0); err == nil {
+		t.Errorf("AddArea exceeding the flash: got nil, want error")
+	}
+	if err := f.AddArea("FMAP", 0, 0x100, 0); err == nil {
+		t.Errorf("AddArea with duplicate name: got nil, want error")
+	}
+	if err := f.ResizeArea("COREBOOT", 0x200200, 0xe00000); err == nil {
+		t.Errorf("ResizeArea beyond the parent: got nil, want error")
+	}
+	if err := f.ResizeArea("COREBOOT", 0x200200, 0x100000); err != nil {
+		t.Error(err)
+	}
+	if err := f.RenameArea("SI_BIOS", "BIOS"); err != nil {
+		t.Error(err)
+	}
+	if err := f.RenameArea("BIOS", "FMAP"); err == nil {
+		t.Errorf("RenameArea to existing name: got nil, want error")
+	}
+	if err := f.RemoveArea("FMAP"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	b, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := Read(bytes.NewReader(append(bytes.Repeat([]byte{0xff}, 0x100), b...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("Read(MarshalBinary()): got %+v, want %+v", got, f)
+	}
+	if got.NAreas != 2 || got.IndexOfArea("BIOS") != 0 || got.Areas[1].Size != 0x100000 {
+		t.Errorf("unexpected areas %+v", got.Areas)
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	f, err := New("FLASH", 0, 0x10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := `# comment
+00000000:0000ffff BIOS
+00000000:000001ff FMAP
+00000200:0000ffff COREBOOT READ_ONLY|STATIC
+`
+	if err := f.ParseLayout(strings.NewReader(l)); err != nil {
+		t.Fatal(err)
+	}
+	want := []Area{{Offset: 0, Size: 0x10000}, {Offset: 0, Size: 0x200}, {Offset: 0x200, Size: 0xfe00, Flags: FmapAreaReadOnly | FmapAreaStatic}}
+	for i, n := range []string{"BIOS", "FMAP", "COREBOOT"} {
+		want[i].Name, _ = NewString(n)
+	}
+	if !reflect.DeepEqual(f.Areas, want) {
+		t.Errorf("ParseLayout: got %+v, want %+v", f.Areas, want)
+	}
+
+	for _, l := range []string{
+		"00000000 BIOS",
+		"00000100:00000000 BIOS",
+		"00000000:000000ff BIOS FAST",
+		"00000000:000fffff BIOS",
+	} {
+		f, _ := New("FLASH", 0, 0x10000)
+		if err := f.ParseLayout(strings.NewReader(l)); err == nil {
+			t.Errorf("ParseLayout(%q): got nil, want error", l)
+		}
+	}
+}
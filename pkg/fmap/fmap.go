@@ -25,6 +25,7 @@ const (
 	FmapAreaStatic = 1 << iota
 	FmapAreaCompressed
 	FmapAreaReadOnly
+	FmapAreaPreserve
 )
 
 // String wraps around byte array to give us more control over how strings are
@@ -108,6 +109,7 @@ func FlagNames(flags uint16) string {
 		{FmapAreaStatic, "STATIC"},
 		{FmapAreaCompressed, "COMPRESSED"},
 		{FmapAreaReadOnly, "READ_ONLY"},
+		{FmapAreaPreserve, "PRESERVE"},
 	}
 	for _, v := range m {
 		if v.val&flags != 0 {
@@ -134,8 +136,34 @@ func readField(r io.Reader, data interface{}) error {
 
 var errSigNotFound = errors.New("cannot find FMAP signature")
 var errMultipleFound = errors.New("found multiple fmap")
+var errInvalidHeader = errors.New("invalid fmap header")
 
-// Read an FMap into the data structure.
+// parseAt parses the fmap at start in data.
+func parseAt(data []byte, start uint64) (*FMap, error) {
+	if start > uint64(len(data)) || !bytes.HasPrefix(data[start:], Signature) {
+		return nil, errSigNotFound
+	}
+	// Reader anchored to the start of the fmap
+	r := bytes.NewReader(data[start:])
+
+	// Read fields.
+	var fmap FMap
+	if err := readField(r, &fmap.Header); err != nil {
+		return nil, err
+	}
+	if !headerValid(&fmap.Header) {
+		return nil, errInvalidHeader
+	}
+	fmap.Areas = make([]Area, fmap.NAreas)
+	if err := readField(r, &fmap.Areas); err != nil {
+		return nil, err
+	}
+	return &fmap, nil
+}
+
+// Read an FMap into the data structure. If there are multiple valid fmaps,
+// an error listing their offsets is returned; use Scan and ReadAt to pick
+// one.
 func Read(f io.Reader) (*FMap, *Metadata, error) {
 	// Read flash into memory.
 	// TODO: it is possible to parse fmap without reading entire file into memory
@@ -146,9 +174,9 @@ func Read(f io.Reader) (*FMap, *Metadata, error) {
 
 	// Loop over __FMAP__ occurrences until a valid header is found
 	start := 0
-	validFmaps := 0
-	var fmap FMap
+	var fmap *FMap
 	var fmapMetadata Metadata
+	var found []string
 	for {
 		if start >= len(data) {
 			break
@@ -160,40 +188,88 @@ func Read(f io.Reader) (*FMap, *Metadata, error) {
 		}
 		start += next
 
-		// Reader anchored to the start of the fmap
-		r := bytes.NewReader(data[start:])
-
-		// Read fields.
-		var testFmap FMap
-		if err := readField(r, &testFmap.Header); err != nil {
-			return nil, nil, err
-		}
-		if !headerValid(&testFmap.Header) {
+		f, err := parseAt(data, uint64(start))
+		switch err {
+		case nil:
+		case errInvalidHeader:
 			start += len(Signature)
 			continue
-		}
-		fmap = testFmap
-		validFmaps++
-
-		fmap.Areas = make([]Area, fmap.NAreas)
-		err := readField(r, &fmap.Areas)
-		if err != nil {
+		default:
 			return nil, nil, err
 		}
+		fmap = f
+		found = append(found, fmt.Sprintf("%#x", start))
 		// Return useful metadata
 		fmapMetadata = Metadata{
 			Start: uint64(start),
 		}
 		start += len(Signature)
 	}
-	if validFmaps >= 2 {
-		return nil, nil, errMultipleFound
-	} else if validFmaps == 1 {
-		return &fmap, &fmapMetadata, nil
+	if len(found) >= 2 {
+		return nil, nil, fmt.Errorf("%w at %s", errMultipleFound, strings.Join(found, ", "))
+	} else if len(found) == 1 {
+		return fmap, &fmapMetadata, nil
 	}
 	return nil, nil, errSigNotFound
 }
 
+// ReadAt reads the FMap starting at offset start of the flash.
+func ReadAt(f io.Reader, start uint64) (*FMap, *Metadata, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmap, err := parseAt(data, start)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fmap at %#x: %w", start, err)
+	}
+	return fmap, &Metadata{Start: start}, nil
+}
+
+// Candidate is an occurrence of the fmap signature in a flash image.
+type Candidate struct {
+	Metadata
+	// FMap is nil if the header could not be parsed.
+	FMap *FMap
+	// Err is nil if the fmap is valid.
+	Err error
+}
+
+// Scan returns every occurrence of the fmap signature in data, e.g. of
+// stale fmaps left behind by a layout change. Each is validated: the
+// header must be valid and all areas must be within the flash size given
+// in the header, which must not exceed len(data).
+func Scan(data []byte) []Candidate {
+	var c []Candidate
+	for start := 0; start < len(data); start += len(Signature) {
+		next := bytes.Index(data[start:], Signature)
+		if next == -1 {
+			break
+		}
+		start += next
+		f, err := parseAt(data, uint64(start))
+		if err == nil {
+			err = f.checkBounds(uint64(len(data)))
+		}
+		c = append(c, Candidate{Metadata: Metadata{Start: uint64(start)}, FMap: f, Err: err})
+	}
+	return c
+}
+
+// checkBounds verifies that the flash fits into size bytes and all areas
+// into the flash.
+func (f *FMap) checkBounds(size uint64) error {
+	if uint64(f.Size) > size {
+		return fmt.Errorf("flash size %#x exceeds the image size %#x", f.Size, size)
+	}
+	for _, a := range f.Areas {
+		if a.End() > uint64(f.Size) {
+			return fmt.Errorf("area %q [%#x, %#x) exceeds the flash size %#x", a.Name.String(), a.Offset, a.End(), f.Size)
+		}
+	}
+	return nil
+}
+
 // Write overwrites the fmap in the flash file.
 func Write(f io.WriteSeeker, fmap *FMap, m *Metadata) error {
 	if _, err := f.Seek(int64(m.Start), io.SeekStart); err != nil {
@@ -7,6 +7,7 @@ package fmap
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -129,7 +130,7 @@ func TestTwoSignatures(t *testing.T) {
 	fakeFlash := bytes.Repeat(fakeFlash, 2)
 	r := bytes.NewReader(fakeFlash)
 	_, _, got := Read(r)
-	if want := errMultipleFound; got != want {
+	if want := errMultipleFound; !errors.Is(got, want) || !strings.HasSuffix(got.Error(), "at 0x5c2cc, 0xb8624") {
 		t.Errorf("Read(%v) = %v, want %v", r, got, want)
 	}
 }
@@ -310,3 +311,58 @@ func TestChecksum(t *testing.T) {
 		t.Errorf("want: %v; got: %v", want, got)
 	}
 }
+
+func TestScan(t *testing.T) {
+	image := bytes.Repeat([]byte{0xff}, 0x1000)
+	put := func(off int, size uint32, ver uint8) {
+		f, err := New("FLASH", 0, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.AddArea("FMAP", uint32(off), 0x100, 0); err != nil {
+			t.Fatal(err)
+		}
+		f.VerMajor = ver
+		b, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(image[off:], b)
+	}
+	put(0x100, 0x1000, 1)
+	put(0x400, 0x2000, 1)
+	put(0x800, 0x1000, 1)
+	put(0xc00, 0x1000, 2)
+
+	c := Scan(image)
+	if len(c) != 4 {
+		t.Fatalf("Scan: got %d candidates, want 4", len(c))
+	}
+	for i, want := range []struct {
+		start uint64
+		valid bool
+	}{{0x100, true}, {0x400, false}, {0x800, true}, {0xc00, false}} {
+		if c[i].Start != want.start || (c[i].Err == nil) != want.valid {
+			t.Errorf("candidate %d: got start %#x, error %v; want start %#x, valid %v", i, c[i].Start, c[i].Err, want.start, want.valid)
+		}
+	}
+	if c[3].FMap != nil || !errors.Is(c[3].Err, errInvalidHeader) {
+		t.Errorf("candidate 3: got %v, %v, want %v", c[3].FMap, c[3].Err, errInvalidHeader)
+	}
+
+	if _, _, err := Read(bytes.NewReader(image)); !errors.Is(err, errMultipleFound) {
+		t.Errorf("Read: got %v, want %v", err, errMultipleFound)
+	}
+	f, m, err := ReadAt(bytes.NewReader(image), 0x800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Start != 0x800 || f.Areas[0].Offset != 0x800 {
+		t.Errorf("ReadAt(0x800): got fmap at %#x with area at %#x", m.Start, f.Areas[0].Offset)
+	}
+	for _, off := range []uint64{0xc00, 0x900, 0x2000} {
+		if _, _, err := ReadAt(bytes.NewReader(image), off); err == nil {
+			t.Errorf("ReadAt(%#x): got nil, want error", off)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CBFSAnnotation marks the sections of an fmd file which hold a CBFS.
+const CBFSAnnotation = "CBFS"
+
+// fmdSection is a section of an fmd file. Offsets are relative to the
+// parent section and nil if they are to be inferred.
+type fmdSection struct {
+	name        string
+	annotations []string
+	offset      *uint64
+	size        *uint64
+	children    []*fmdSection
+}
+
+// fmdParser parses the text of an fmd file.
+type fmdParser struct {
+	toks  []string
+	lines []int
+	pos   int
+}
+
+// tokenizeFMD splits the fmd text into names and numbers, and the symbols
+// @ ( ) { } and ','. Comments start with # and end with the line.
+func tokenizeFMD(r io.Reader) (*fmdParser, error) {
+	p := &fmdParser{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := s.Text()
+		if i := strings.IndexByte(l, '#'); i != -1 {
+			l = l[:i]
+		}
+		for len(l) > 0 {
+			c := rune(l[0])
+			switch {
+			case unicode.IsSpace(c):
+				l = l[1:]
+			case strings.ContainsRune("@(){},", c):
+				p.toks, p.lines = append(p.toks, l[:1]), append(p.lines, n)
+				l = l[1:]
+			case c == '_' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c):
+				e := strings.IndexFunc(l, func(c rune) bool {
+					return !(c == '_' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c))
+				})
+				if e == -1 {
+					e = len(l)
+				}
+				p.toks, p.lines = append(p.toks, l[:e]), append(p.lines, n)
+				l = l[e:]
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q", n, c)
+			}
+		}
+	}
+	return p, s.Err()
+}
+
+func (p *fmdParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *fmdParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *fmdParser) errorf(format string, a ...interface{}) error {
+	l := 0
+	switch {
+	case p.pos < len(p.lines):
+		l = p.lines[p.pos]
+	case len(p.lines) > 0:
+		l = p.lines[len(p.lines)-1]
+	}
+	return fmt.Errorf("line %d: %s", l, fmt.Sprintf(format, a...))
+}
+
+func isNumber(t string) bool {
+	return t != "" && t[0] >= '0' && t[0] <= '9'
+}
+
+// number parses a decimal, hex or octal number with an optional K, M or G
+// suffix.
+func (p *fmdParser) number() (uint64, error) {
+	t := p.next()
+	mul := uint64(1)
+	switch {
+	case strings.HasSuffix(t, "K"):
+		mul = 1 << 10
+	case strings.HasSuffix(t, "M"):
+		mul = 1 << 20
+	case strings.HasSuffix(t, "G"):
+		mul = 1 << 30
+	}
+	if mul != 1 {
+		t = t[:len(t)-1]
+	}
+	v, err := strconv.ParseUint(t, 0, 32)
+	if err != nil || v*mul > 1<<32 {
+		p.pos--
+		return 0, p.errorf("invalid number %q", p.peek())
+	}
+	return v * mul, nil
+}
+
+// section parses NAME[(ANNOTATIONS)][@OFFSET][ SIZE][{ SECTIONS }].
+func (p *fmdParser) section() (*fmdSection, error) {
+	t := p.next()
+	if t == "" || isNumber(t) || strings.ContainsAny(t, "@(){},") {
+		p.pos--
+		return nil, p.errorf("want a section name, got %q", t)
+	}
+	s := &fmdSection{name: t}
+	if p.peek() == "(" {
+		p.next()
+		for {
+			a := p.next()
+			if a == "" || strings.ContainsAny(a, "@(){},") {
+				p.pos--
+				return nil, p.errorf("want an annotation, got %q", a)
+			}
+			s.annotations = append(s.annotations, a)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		if p.next() != ")" {
+			p.pos--
+			return nil, p.errorf("want ), got %q", p.peek())
+		}
+	}
+	if p.peek() == "@" {
+		p.next()
+		v, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		s.offset = &v
+	}
+	if isNumber(p.peek()) {
+		v, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		s.size = &v
+	}
+	if p.peek() == "{" {
+		p.next()
+		for p.peek() != "}" {
+			if p.peek() == "" {
+				return nil, p.errorf("missing } of %s", s.name)
+			}
+			c, err := p.section()
+			if err != nil {
+				return nil, err
+			}
+			s.children = append(s.children, c)
+		}
+		p.next()
+		if len(s.children) == 0 {
+			return nil, p.errorf("%s has no sections", s.name)
+		}
+	}
+	return s, nil
+}
+
+// resolve infers the missing offsets and sizes of the children of s, like
+// fmaptool: a section without offset follows its predecessor, and one
+// without size extends to its successor or the end of s.
+func (s *fmdSection) resolve() error {
+	c := s.children
+	for changed := true; changed; {
+		changed = false
+		for i, x := range c {
+			end := s.size
+			if i+1 < len(c) {
+				end = c[i+1].offset
+			}
+			if x.offset == nil {
+				switch {
+				case i == 0:
+					v := uint64(0)
+					x.offset, changed = &v, true
+				case c[i-1].offset != nil && c[i-1].size != nil:
+					v := *c[i-1].offset + *c[i-1].size
+					x.offset, changed = &v, true
+				case x.size != nil && end != nil && *end >= *x.size:
+					// Align it to its successor.
+					v := *end - *x.size
+					x.offset, changed = &v, true
+				}
+			}
+			if x.size == nil && x.offset != nil && end != nil {
+				if *end < *x.offset {
+					return fmt.Errorf("%s ends before it starts", x.name)
+				}
+				v := *end - *x.offset
+				x.size, changed = &v, true
+			}
+		}
+	}
+	var prev uint64
+	for _, x := range c {
+		if x.offset == nil || x.size == nil {
+			return fmt.Errorf("can't infer the position of %s", x.name)
+		}
+		if *x.offset < prev {
+			return fmt.Errorf("%s at %#x overlaps its predecessor", x.name, *x.offset)
+		}
+		prev = *x.offset + *x.size
+		if prev > *s.size {
+			return fmt.Errorf("%s [%#x, %#x) exceeds %s of size %#x", x.name, *x.offset, prev, s.name, *s.size)
+		}
+		if len(x.children) > 0 {
+			if err := x.resolve(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// add adds the children of s at base to f.
+func (s *fmdSection) add(f *FMap, base uint64, cbfs *[]string) error {
+	for _, x := range s.children {
+		var flags uint16
+		for _, a := range x.annotations {
+			if a == CBFSAnnotation {
+				*cbfs = append(*cbfs, x.name)
+				continue
+			}
+			v, err := ParseFlags(a)
+			if err != nil {
+				return fmt.Errorf("%s: %v", x.name, err)
+			}
+			flags |= v
+		}
+		if err := f.AddArea(x.name, uint32(base+*x.offset), uint32(*x.size), flags); err != nil {
+			return err
+		}
+		if err := x.add(f, base+*x.offset, cbfs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseFMD compiles a flash layout in the fmd format of coreboot's fmaptool
+// into an fmap. The outermost section describes the flash: its name, base
+// address and size. Every nested section becomes an area. Sections
+// annotated with (CBFS) are returned as well; other annotations are flags
+// as printed by FlagNames, e.g. (PRESERVE).
+func ParseFMD(r io.Reader) (*FMap, []string, error) {
+	p, err := tokenizeFMD(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := p.section()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.peek() != "" {
+		return nil, nil, p.errorf("unexpected %q after %s", p.peek(), root.name)
+	}
+	if root.size == nil {
+		return nil, nil, fmt.Errorf("%s has no size", root.name)
+	}
+	if len(root.children) == 0 {
+		return nil, nil, fmt.Errorf("%s has no sections", root.name)
+	}
+	var base uint64
+	if root.offset != nil {
+		base = *root.offset
+	}
+	if *root.size > 0xffffffff {
+		return nil, nil, fmt.Errorf("%s: size %#x too large", root.name, *root.size)
+	}
+	f, err := New(root.name, base, uint32(*root.size))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := root.resolve(); err != nil {
+		return nil, nil, err
+	}
+	var cbfs []string
+	if err := root.add(f, 0, &cbfs); err != nil {
+		return nil, nil, err
+	}
+	return f, cbfs, nil
+}
+
+// WriteFMD decompiles f into the fmd format, nesting the areas that are
+// contained in others. The areas named in cbfs are annotated with (CBFS).
+func WriteFMD(w io.Writer, f *FMap, cbfs []string) error {
+	areas := append([]Area{}, f.Areas...)
+	sort.SliceStable(areas, func(i, j int) bool {
+		if areas[i].Offset != areas[j].Offset {
+			return areas[i].Offset < areas[j].Offset
+		}
+		return areas[i].Size > areas[j].Size
+	})
+	isCBFS := map[string]bool{}
+	for _, n := range cbfs {
+		isCBFS[n] = true
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%s@%#x %#x {\n", f.Name.String(), f.Base, f.Size)
+	// The stack holds the areas enclosing the current one.
+	var stack []*Area
+	closeTo := func(n int) {
+		for len(stack) > n {
+			stack = stack[:len(stack)-1]
+			fmt.Fprintf(b, "%s}\n", strings.Repeat("\t", len(stack)+1))
+		}
+	}
+	for i := range areas {
+		a := &areas[i]
+		for len(stack) > 0 && (uint64(a.Offset) >= stack[len(stack)-1].End() || a.End() > stack[len(stack)-1].End()) {
+			closeTo(len(stack) - 1)
+		}
+		var parent uint32
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].Offset
+		}
+		var ann []string
+		if isCBFS[a.Name.String()] {
+			ann = append(ann, CBFSAnnotation)
+		}
+		if a.Flags != 0 {
+			ann = append(ann, strings.Split(FlagNames(a.Flags), "|")...)
+		}
+		line := a.Name.String()
+		if len(ann) > 0 {
+			line += "(" + strings.Join(ann, ",") + ")"
+		}
+		fmt.Fprintf(b, "%s%s@%#x %#x", strings.Repeat("\t", len(stack)+1), line, a.Offset-parent, a.Size)
+		// Areas with nested areas open a block.
+		if i+1 < len(areas) && uint64(areas[i+1].Offset) < a.End() && areas[i+1].End() <= a.End() {
+			fmt.Fprintf(b, " {\n")
+			stack = append(stack, a)
+			continue
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	closeTo(0)
+	fmt.Fprintf(b, "}\n")
+	return b.Flush()
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFMD = `# A layout like the ones of coreboot's mainboards.
+FLASH@0xff800000 8M {
+	SI_ALL@0x0 0x200000 {
+		SI_DESC@0x0 0x1000
+		SI_ME
+	}
+	SI_BIOS 6M {
+		RW_SECTION_A 0x100000 {
+			VBLOCK_A 64K
+			FW_MAIN_A(CBFS)
+		}
+		RW_MRC_CACHE(PRESERVE) 0x10000
+		FMAP@0x200000 0x800
+		COREBOOT(CBFS, READ_ONLY)
+	}
+}
+`
+
+func TestParseFMD(t *testing.T) {
+	f, cbfs, err := ParseFMD(strings.NewReader(testFMD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.String() != "FLASH" || f.Base != 0xff800000 || f.Size != 8<<20 {
+		t.Errorf("header: got %s@%#x %#x, want FLASH@0xff800000 0x800000", f.Name.String(), f.Base, f.Size)
+	}
+	want := []struct {
+		name         string
+		offset, size uint32
+		flags        uint16
+	}{
+		{"SI_ALL", 0, 0x200000, 0},
+		{"SI_DESC", 0, 0x1000, 0},
+		{"SI_ME", 0x1000, 0x1ff000, 0},
+		{"SI_BIOS", 0x200000, 0x600000, 0},
+		{"RW_SECTION_A", 0x200000, 0x100000, 0},
+		{"VBLOCK_A", 0x200000, 0x10000, 0},
+		{"FW_MAIN_A", 0x210000, 0xf0000, 0},
+		{"RW_MRC_CACHE", 0x300000, 0x10000, FmapAreaPreserve},
+		{"FMAP", 0x400000, 0x800, 0},
+		{"COREBOOT", 0x400800, 0x3ff800, FmapAreaReadOnly},
+	}
+	if len(f.Areas) != len(want) {
+		t.Fatalf("got %d areas, want %d", len(f.Areas), len(want))
+	}
+	for i, w := range want {
+		a := f.Areas[i]
+		if a.Name.String() != w.name || a.Offset != w.offset || a.Size != w.size || a.Flags != w.flags {
+			t.Errorf("area %d: got %s@%#x %#x flags %#x, want %s@%#x %#x flags %#x",
+				i, a.Name.String(), a.Offset, a.Size, a.Flags, w.name, w.offset, w.size, w.flags)
+		}
+	}
+	if !reflect.DeepEqual(cbfs, []string{"FW_MAIN_A", "COREBOOT"}) {
+		t.Errorf("CBFS sections: got %v, want [FW_MAIN_A COREBOOT]", cbfs)
+	}
+
+	// Decompiling and compiling again yields the same fmap.
+	var b strings.Builder
+	if err := WriteFMD(&b, f, cbfs); err != nil {
+		t.Fatal(err)
+	}
+	g, gcbfs, err := ParseFMD(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, b.String())
+	}
+	if !reflect.DeepEqual(f, g) || !reflect.DeepEqual(cbfs, gcbfs) {
+		t.Errorf("round trip: got %+v %v, want %+v %v from\n%s", g, gcbfs, f, cbfs, b.String())
+	}
+	if !strings.Contains(b.String(), "\t\tRW_SECTION_A@0x0 0x100000 {\n\t\t\tVBLOCK_A@0x0 0x10000\n") {
+		t.Errorf("WriteFMD: unexpected nesting in\n%s", b.String())
+	}
+}
+
+func TestParseFMDErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"FLASH { A 1 }",
+		"FLASH 0x1000",
+		"FLASH 0x1000 { A 0x2000 }",
+		"FLASH 0x1000 { A@0x800 0x1000 }",
+		"FLASH 0x1000 { A 0x800 B@0x400 }",
+		"FLASH 0x1000 { A B C 0x10 }",
+		"FLASH 0x1000 { A(FAST) }",
+		"FLASH 0x1000 { A } B",
+		"FLASH 0x1000 { A",
+		"FLASH 0x1000 { A $ }",
+	} {
+		if _, _, err := ParseFMD(strings.NewReader(s)); err == nil {
+			t.Errorf("ParseFMD(%q): got nil, want error", s)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/linuxboot/fiano/pkg/log"
@@ -18,7 +19,9 @@ const (
 	// Size represents number of bytes in a GUID
 	Size = 16
 	// UExample is a example of a string GUID
-	UExample  = "01234567-89AB-CDEF-0123-456789ABCDEF"
+	UExample = "01234567-89AB-CDEF-0123-456789ABCDEF"
+	// CExample is the example as a C struct initializer.
+	CExample  = "{0x01234567, 0x89ab, 0xcdef, {0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}}"
 	strFormat = "%02X%02X%02X%02X-%02X%02X-%02X%02X-%02X%02X-%02X%02X%02X%02X%02X%02X"
 )
 
@@ -36,8 +39,43 @@ func reverse(b []byte) {
 	}
 }
 
-// Parse parses a guid string.
+// Parse parses a guid string in the format of UExample. The hyphens are
+// optional.
 func Parse(s string) (*GUID, error) {
+	return parse(s)
+}
+
+// ParseLenient parses a guid string like Parse, but also accepts it
+// surrounded by whitespace, in the braces of the registry format, or as the
+// C struct initializer of EDK2 sources, like
+// {0x01234567, 0x89ab, 0xcdef, {0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}}.
+func ParseLenient(s string) (*GUID, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") {
+		return parseC(s)
+	}
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	return parse(s)
+}
+
+// ParseStrict parses a guid string only in the format of UExample.
+func ParseStrict(s string) (*GUID, error) {
+	if len(s) != len(UExample) {
+		return nil, fmt.Errorf("guid string has incorrect length, need string of the format \n%v\n, got \n%v",
+			UExample, s)
+	}
+	for i := range UExample {
+		if (UExample[i] == '-') != (s[i] == '-') {
+			return nil, fmt.Errorf("guid string not correct, need string of the format \n%v\n, got \n%v",
+				UExample, s)
+		}
+	}
+	return parse(s)
+}
+
+func parse(s string) (*GUID, error) {
 	// remove all hyphens to make it easier to parse.
 	stripped := strings.Replace(s, "-", "", -1)
 	decoded, err := hex.DecodeString(stripped)
@@ -62,6 +100,30 @@ func Parse(s string) (*GUID, error) {
 	return &u, nil
 }
 
+// parseC parses the C struct initializer of a GUID. The fields are stored
+// little endian, like the compiler would.
+func parseC(s string) (*GUID, error) {
+	values := strings.Split(strings.NewReplacer("{", "", "}", "").Replace(s), ",")
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("guid initializer has %d values, need %d like \n%v\n, got \n%v",
+			len(values), len(fields), CExample, s)
+	}
+	u := GUID{}
+	i := 0
+	for j, fieldlen := range fields {
+		v, err := strconv.ParseUint(strings.TrimSpace(values[j]), 0, 8*fieldlen)
+		if err != nil {
+			return nil, fmt.Errorf("guid initializer value %d not correct, need initializer like \n%v\n, got \n%v",
+				j, CExample, s)
+		}
+		for k := 0; k < fieldlen; k++ {
+			u[i+k] = byte(v >> (8 * k))
+		}
+		i += fieldlen
+	}
+	return &u, nil
+}
+
 // MustParse parses a guid string or panics.
 func MustParse(s string) *GUID {
 	guid, err := Parse(s)
@@ -87,6 +149,44 @@ func (u GUID) String() string {
 	return fmt.Sprintf(strFormat, b...)
 }
 
+// RegistryString returns the GUID in the braces of the registry format.
+func (u GUID) RegistryString() string {
+	return "{" + u.String() + "}"
+}
+
+// CString returns the GUID as a C struct initializer, as EDK2 sources and
+// DEC files write it.
+func (u GUID) CString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "{0x%08x, 0x%04x, 0x%04x, {", uint32(u[0])|uint32(u[1])<<8|uint32(u[2])<<16|uint32(u[3])<<24,
+		uint16(u[4])|uint16(u[5])<<8, uint16(u[6])|uint16(u[7])<<8)
+	for i, c := range u[8:] {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "0x%02x", c)
+	}
+	b.WriteString("}}")
+	return b.String()
+}
+
+// MarshalText implements encoding.TextMarshaler, so GUIDs can be map keys
+// and values of text formats.
+func (u GUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts all the
+// formats of ParseLenient.
+func (u *GUID) UnmarshalText(b []byte) error {
+	g, err := ParseLenient(string(b))
+	if err != nil {
+		return err
+	}
+	*u = *g
+	return nil
+}
+
 // MarshalJSON implements the marshaller interface.
 // This allows us to actually read and edit the json file
 func (u *GUID) MarshalJSON() ([]byte, error) {
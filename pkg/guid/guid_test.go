@@ -5,6 +5,7 @@
 package guid
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 )
@@ -102,3 +103,90 @@ func TestUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFormats(t *testing.T) {
+	for _, s := range []string{
+		"{" + exampleGUIDString + "}",
+		" " + exampleGUIDString + "\n",
+		CExample,
+		"{ 0x1234567,0x89AB,0xCDEF,{ 0x1,0x23,0x45,0x67,0x89,0xAB,0xCD,0xEF } }",
+	} {
+		u, err := ParseLenient(s)
+		if err != nil {
+			t.Errorf("ParseLenient(%q) returned error %v", s, err)
+		} else if *u != exampleGUID {
+			t.Errorf("ParseLenient(%q) = %v, want %v", s, u, exampleGUID)
+		}
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) was expected to fail", s)
+		}
+	}
+	for _, s := range []string{
+		"{0x01234567, 0x89ab, 0xcdef, {0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd}}",
+		"{0x101234567, 0x89ab, 0xcdef, {0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}}",
+		"{0x01234567, 0x89ab, 0xcdef, {0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xzz}}",
+	} {
+		if _, err := ParseLenient(s); err == nil {
+			t.Errorf("ParseLenient(%q) was expected to fail", s)
+		}
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	u, err := ParseStrict(exampleGUIDString)
+	if err != nil {
+		t.Fatalf("ParseStrict returned error %v", err)
+	}
+	if *u != exampleGUID {
+		t.Errorf("ParseStrict = %v, want %v", u, exampleGUID)
+	}
+	for _, s := range []string{
+		shortGUIDString,
+		"{" + exampleGUIDString + "}",
+		CExample,
+		"0123456789-AB-CDEF-0123-456789ABCDEF",
+	} {
+		if _, err := ParseStrict(s); err == nil {
+			t.Errorf("ParseStrict(%q) was expected to fail", s)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	if s := exampleGUID.CString(); s != CExample {
+		t.Errorf("CString = %v, want %v", s, CExample)
+	}
+	if s := exampleGUID.RegistryString(); s != "{"+exampleGUIDString+"}" {
+		t.Errorf("RegistryString = %v, want {%v}", s, exampleGUIDString)
+	}
+}
+
+func TestText(t *testing.T) {
+	b, err := exampleGUID.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText returned error %v", err)
+	}
+	if string(b) != exampleGUIDString {
+		t.Errorf("MarshalText = %v, want %v", string(b), exampleGUIDString)
+	}
+	var g GUID
+	if err := g.UnmarshalText([]byte(CExample)); err != nil {
+		t.Fatalf("UnmarshalText returned error %v", err)
+	}
+	if g != exampleGUID {
+		t.Errorf("UnmarshalText = %v, want %v", g, exampleGUID)
+	}
+	if err := g.UnmarshalText([]byte(badHex)); err == nil {
+		t.Errorf("UnmarshalText(%q) was expected to fail", badHex)
+	}
+
+	// GUIDs can be map keys of JSON objects through the text marshaling.
+	m := map[GUID]int{exampleGUID: 1}
+	j, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error %v", err)
+	}
+	if want := `{"` + exampleGUIDString + `":1}`; string(j) != want {
+		t.Errorf("json.Marshal = %s, want %s", j, want)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guid
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"hash"
+)
+
+// Name spaces of RFC 4122 for NewV3 and NewV5.
+var (
+	NamespaceDNS  = *MustParse("6BA7B810-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceURL  = *MustParse("6BA7B811-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceOID  = *MustParse("6BA7B812-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceX500 = *MustParse("6BA7B814-9DAD-11D1-80B4-00C04FD430C8")
+)
+
+// NewV3 returns the name-based GUID of version 3, hashed with MD5, of the
+// name in the name space. The same name always gives the same GUID, so
+// tools can give synthesized files stable GUIDs. It is the UUID other
+// implementations of RFC 4122 return, whose string is that of the GUID.
+func NewV3(namespace GUID, name []byte) GUID {
+	return newHashed(md5.New(), 3, namespace, name)
+}
+
+// NewV5 is like NewV3, with version 5, hashed with SHA-1, which RFC 4122
+// prefers.
+func NewV5(namespace GUID, name []byte) GUID {
+	return newHashed(sha1.New(), 5, namespace, name)
+}
+
+// newHashed hashes the name space and the name in the byte order of the
+// string of the GUIDs, as RFC 4122 does.
+func newHashed(h hash.Hash, version byte, namespace GUID, name []byte) GUID {
+	swapFields(&namespace)
+	h.Write(namespace[:])
+	h.Write(name)
+	var u GUID
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | version<<4
+	// The variant of RFC 4122.
+	u[8] = u[8]&0x3f | 0x80
+	swapFields(&u)
+	return u
+}
+
+// swapFields reverses the byte order of the fields of u, converting between
+// the mixed-endian GUID and the byte order of its string.
+func swapFields(u *GUID) {
+	i := 0
+	for _, fieldlen := range fields {
+		reverse(u[i : i+fieldlen])
+		i += fieldlen
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guid
+
+import "testing"
+
+func TestNewNameBased(t *testing.T) {
+	// The UUIDs of Python's uuid module, which implements RFC 4122.
+	for _, tt := range []struct {
+		name string
+		got  GUID
+		want string
+	}{
+		{"v3 dns", NewV3(NamespaceDNS, []byte("python.org")), "6FA459EA-EE8A-3CA4-894E-DB77E160355E"},
+		{"v5 dns", NewV5(NamespaceDNS, []byte("python.org")), "886313E1-3B8A-5372-9B90-0C9AEE199E5D"},
+		{"v5 url", NewV5(NamespaceURL, []byte("https://github.com/linuxboot/fiano")), "7854C1FF-2416-53A4-826D-E3728E93D6B5"},
+	} {
+		if s := tt.got.String(); s != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, s, tt.want)
+		}
+	}
+
+	// Names and name spaces give different GUIDs.
+	a := NewV5(NamespaceDNS, []byte("a"))
+	if a != NewV5(NamespaceDNS, []byte("a")) {
+		t.Errorf("the same name gave different GUIDs")
+	}
+	if a == NewV5(NamespaceDNS, []byte("b")) || a == NewV5(NamespaceURL, []byte("a")) {
+		t.Errorf("different names gave the same GUID %v", a)
+	}
+}
@@ -31,11 +31,11 @@ const (
 )
 
 var hashInfo = []struct {
-	alg  Algorithm
-	hash hash.Hash
+	alg         Algorithm
+	hashFactory func() hash.Hash
 }{
-	{AlgSHA1, crypto.SHA1.New()},
-	{AlgSHA256, crypto.SHA256.New()},
+	{AlgSHA1, crypto.SHA1.New},
+	{AlgSHA256, crypto.SHA256.New},
 }
 
 // IsNull returns true if a is AlgNull or zero (unset).
@@ -48,10 +48,10 @@ func (a Algorithm) IsNull() bool {
 func (a Algorithm) Hash() (hash.Hash, error) {
 	for _, info := range hashInfo {
 		if info.alg == a {
-			if info.hash == nil {
+			if info.hashFactory == nil {
 				return nil, fmt.Errorf("go hash algorithm #%snot available", info.alg.String())
 			}
-			return info.hash, nil
+			return info.hashFactory(), nil
 		}
 	}
 	return nil, fmt.Errorf("hash algorithm not supported: %s", a.String())
@@ -84,6 +84,10 @@ func (m *Manifest) ValidateBPMKey(bpmKS cbnt.KeySignature) error {
 			if _, err := h.Write(bpmKS.Key.Data[4:]); err != nil {
 				return fmt.Errorf("unable to hash: %w", err)
 			}
+		case cbnt.AlgECC:
+			if _, err := h.Write(bpmKS.Key.Data); err != nil {
+				return fmt.Errorf("unable to hash: %w", err)
+			}
 		default:
 			return fmt.Errorf("unsupported key algorithm: %v", bpmKS.Key.KeyAlg)
 		}
@@ -24,7 +24,18 @@ type Signature struct {
 	Version   uint8     `require:"0x10" json:"sigVersion,omitempty"`
 	KeySize   BitSize   `json:"sigKeysize,omitempty"`
 	HashAlg   Algorithm `json:"sigHashAlg"`
-	Data      []byte    `countValue:"KeySize.InBytes()" prettyValue:"dataPrettyValue()" json:"sigData"`
+	Data      []byte    `countValue:"dataSize()" prettyValue:"dataPrettyValue()" json:"sigData"`
+}
+
+// dataSize returns the expected length of Data for specified SigScheme
+// and KeySize: the R and S components of ECDSA and SM2 signatures are each
+// as long as the key.
+func (m Signature) dataSize() int64 {
+	switch m.SigScheme {
+	case AlgECDSA, AlgSM2:
+		return int64(m.KeySize.InBytes()) * 2
+	}
+	return int64(m.KeySize.InBytes())
 }
 
 func (m Signature) dataPrettyValue() interface{} {
@@ -96,11 +107,14 @@ func (m *Signature) SetSignatureByData(sig SignatureDataInterface, hashAlgo Algo
 	case SignatureECDSA:
 		m.SigScheme = AlgECDSA
 		if hashAlgo.IsNull() {
-			m.HashAlg = AlgSHA512
+			m.HashAlg = AlgSHA256
+			if len(m.Data) > 64 {
+				m.HashAlg = AlgSHA384
+			}
 		} else {
 			m.HashAlg = hashAlgo
 		}
-		m.KeySize.SetInBits(uint16(sig.R.BitLen()))
+		m.KeySize.SetInBytes(uint16(len(m.Data) / 2))
 	case SignatureSM2:
 		m.SigScheme = AlgSM2
 		if hashAlgo.IsNull() {
@@ -108,7 +122,7 @@ func (m *Signature) SetSignatureByData(sig SignatureDataInterface, hashAlgo Algo
 		} else {
 			m.HashAlg = hashAlgo
 		}
-		m.KeySize.SetInBits(uint16(sig.R.BitLen()))
+		m.KeySize.SetInBytes(uint16(len(m.Data) / 2))
 	default:
 		return fmt.Errorf("unexpected signature type: %T", sig)
 	}
@@ -137,15 +151,18 @@ func (m *Signature) SetSignatureData(sig SignatureDataInterface) error {
 		default:
 			return fmt.Errorf("internal error")
 		}
-		if r.BitLen() != s.BitLen() {
-			return fmt.Errorf("the length of component R (%d) is not equal to the length of component S (%d)", r.BitLen(), s.BitLen())
+		// The components are as long as the key, R or S may have leading
+		// zeros.
+		size := 32
+		if r.BitLen() > 256 || s.BitLen() > 256 {
+			size = 48
 		}
-		if r.BitLen() != 256 && r.BitLen() != 384 {
-			return fmt.Errorf("component R (or S) size should be 256 or 384 bites (not %d)", r.BitLen())
+		if r.BitLen() > 384 || s.BitLen() > 384 {
+			return fmt.Errorf("component R (or S) size should be at most 384 bits (not %d/%d)", r.BitLen(), s.BitLen())
 		}
-		m.Data = make([]byte, r.BitLen()/8+s.BitLen()/8)
+		m.Data = make([]byte, 2*size)
 		copy(m.Data[:], reverseBytes(r.Bytes()))
-		copy(m.Data[r.BitLen()/8:], reverseBytes(s.Bytes()))
+		copy(m.Data[size:], reverseBytes(s.Bytes()))
 	default:
 		return fmt.Errorf("unexpected signature type: %T", sig)
 	}
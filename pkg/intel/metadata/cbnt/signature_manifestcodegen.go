@@ -91,7 +91,7 @@ func (s *Signature) ReadFrom(r io.Reader) (int64, error) {
 
 	// Data (ManifestFieldType: arrayDynamic)
 	{
-		size := uint16(s.KeySize.InBytes())
+		size := uint16(s.dataSize())
 		s.Data = make([]byte, size)
 		n, err := len(s.Data), binary.Read(r, binary.LittleEndian, s.Data)
 		if err != nil {
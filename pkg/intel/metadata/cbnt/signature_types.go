@@ -32,7 +32,7 @@ func NewSignatureData(
 		// auto-detect the sign algorithm, based on the provided signing key
 		switch k := privKey.(type) {
 		case *rsa.PrivateKey:
-			switch k.Size() {
+			switch k.N.BitLen() {
 			case 2048:
 				signAlgo = AlgRSASSA
 			case 3072:
@@ -81,9 +81,16 @@ func NewSignatureData(
 		if !ok {
 			return nil, fmt.Errorf("expected private ECDSA key (type %T), but received %T", eccPrivateKey, privKey)
 		}
+		// The data is hashed with the algorithm matching the curve, see
+		// SetSignatureByData.
+		h := sha256.New()
+		if eccPrivateKey.Curve.Params().BitSize > 256 {
+			h = sha512.New384()
+		}
+		_, _ = h.Write(signedData)
 		var data SignatureECDSA
 		var err error
-		data.R, data.S, err = ecdsa.Sign(RandReader, eccPrivateKey, signedData)
+		data.R, data.S, err = ecdsa.Sign(RandReader, eccPrivateKey, h.Sum(nil))
 		if err != nil {
 			return nil, fmt.Errorf("unable to sign with ECDSA the data: %w", err)
 		}
@@ -254,7 +261,28 @@ func (s SignatureECDSA) String() string {
 
 // Verify implements SignatureDataInterface.
 func (s SignatureECDSA) Verify(pkIface crypto.PublicKey, hashAlgo Algorithm, signedData []byte) error {
-	return fmt.Errorf("support of ECDSA signatures is not implemented, yet")
+	var pk *ecdsa.PublicKey
+	switch k := pkIface.(type) {
+	case *ecdsa.PublicKey:
+		pk = k
+	case ecdsa.PublicKey:
+		pk = &k
+	default:
+		return fmt.Errorf("expected public key of type %T, but received %T", pk, pkIface)
+	}
+
+	h, err := hashAlgo.Hash()
+	if err != nil {
+		return fmt.Errorf("invalid hash algorithm: %q", err)
+	}
+	if _, err := h.Write(signedData); err != nil {
+		return fmt.Errorf("unable to hash the data: %w", err)
+	}
+
+	if !ecdsa.Verify(pk, h.Sum(nil), s.R, s.S) {
+		return fmt.Errorf("signature does not correspond to the pub key")
+	}
+	return nil
 }
 
 // SignatureSM2 is a structure with components of an SM2 signature.
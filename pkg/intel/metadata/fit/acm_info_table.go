@@ -0,0 +1,173 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// See the section "A.1.2" of the specification
+// "Intel ® Trusted Execution Technology (Intel ® TXT)"
+// https://www.intel.com/content/www/us/en/software-developers/txt-software-development-guide.html
+// and tboot's include/acmod.h.
+
+// ACMInfoTableUUID is the UUID of the information table of AC modules.
+var ACMInfoTableUUID = *guid.MustParse("7FC03AAA-46A7-18DB-AC2E-698F8D417F5A")
+
+const (
+	// ACModuleTypeChipset is the module type of the AC modules.
+	ACModuleTypeChipset = ACModuleType(2)
+	// ACModuleVendorIntel is the vendor of the AC modules.
+	ACModuleVendorIntel = ACModuleVendor(0x8086)
+)
+
+// ChipsetACMType is the type of an AC module: BIOS or SINIT, and whether
+// it only revokes older modules.
+type ChipsetACMType uint8
+
+const (
+	// ChipsetACMTypeBIOS is the type of the BIOS (startup) AC modules.
+	ChipsetACMTypeBIOS = ChipsetACMType(0x00)
+	// ChipsetACMTypeSINIT is the type of the SINIT AC modules.
+	ChipsetACMTypeSINIT = ChipsetACMType(0x01)
+	// ChipsetACMTypeBIOSRevocation is the type of the BIOS revocation AC
+	// modules.
+	ChipsetACMTypeBIOSRevocation = ChipsetACMType(0x08)
+	// ChipsetACMTypeSINITRevocation is the type of the SINIT revocation
+	// AC modules.
+	ChipsetACMTypeSINITRevocation = ChipsetACMType(0x09)
+)
+
+func (t ChipsetACMType) String() string {
+	switch t {
+	case ChipsetACMTypeBIOS:
+		return "BIOS"
+	case ChipsetACMTypeSINIT:
+		return "SINIT"
+	case ChipsetACMTypeBIOSRevocation:
+		return "BIOS revocation"
+	case ChipsetACMTypeSINITRevocation:
+		return "SINIT revocation"
+	}
+	return fmt.Sprintf("unknown (0x%02X)", uint8(t))
+}
+
+// ACMInfoTable is the information table of an AC module, at the start of
+// its user area.
+type ACMInfoTable struct {
+	UUID           guid.GUID
+	ChipsetACMType ChipsetACMType
+	Version        uint8
+	Length         uint16
+	// ChipsetIDList is the offset of the chipset ID list in the module.
+	ChipsetIDList   uint32
+	OSSINITDataVer  uint32
+	MinMLEHeaderVer uint32
+	Capabilities    uint32
+	ACMVersion      uint8
+	ACMRevision     [3]uint8
+	// ProcessorIDList is the offset of the processor ID list in the
+	// module, for tables of version 4 and later.
+	ProcessorIDList uint32
+}
+
+// ACMChipsetID is an entry of the chipset ID list of an AC module.
+type ACMChipsetID struct {
+	Flags      uint32
+	VendorID   uint16
+	DeviceID   uint16
+	RevisionID uint16
+	Reserved   uint16
+	ExtendedID uint32
+}
+
+// ACMProcessorID is an entry of the processor ID list of an AC module.
+type ACMProcessorID struct {
+	FMS          uint32
+	FMSMask      uint32
+	PlatformID   uint64
+	PlatformMask uint64
+}
+
+// ACMInfo is the information table of an AC module with the chipsets and
+// processors it supports.
+type ACMInfo struct {
+	ACMInfoTable
+	ChipsetIDs   []ACMChipsetID
+	ProcessorIDs []ACMProcessorID `json:",omitempty"`
+}
+
+// Revision returns the revision of the module, like "1.4.2".
+func (info *ACMInfo) Revision() string {
+	return fmt.Sprintf("%d.%d.%d", info.ACMRevision[0], info.ACMRevision[1], info.ACMRevision[2])
+}
+
+// acmList returns the number of entries of the list at offset of the
+// module, a count followed by the entries of entrySize bytes, and a reader
+// of the entries.
+func acmList(acm []byte, offset uint32, entrySize int) (uint32, *bytes.Reader, error) {
+	if uint64(offset)+4 > uint64(len(acm)) {
+		return 0, nil, fmt.Errorf("list at 0x%x is outside of the module (0x%x)", offset, len(acm))
+	}
+	count := binary.LittleEndian.Uint32(acm[offset:])
+	if uint64(offset)+4+uint64(count)*uint64(entrySize) > uint64(len(acm)) {
+		return 0, nil, fmt.Errorf("list of %d entries at 0x%x is outside of the module (0x%x)", count, offset, len(acm))
+	}
+	return count, bytes.NewReader(acm[offset+4:]), nil
+}
+
+// ParseACMInfo parses the information table of the AC module acm, with its
+// chipset and processor ID lists.
+func ParseACMInfo(acm []byte) (*ACMInfo, error) {
+	var common EntrySACMDataCommon
+	if err := binary.Read(bytes.NewReader(acm), binary.LittleEndian, &common); err != nil {
+		return nil, fmt.Errorf("unable to parse the AC module headers: %w", err)
+	}
+	if common.ModuleType != ACModuleTypeChipset || common.ModuleVendor != ACModuleVendorIntel {
+		return nil, fmt.Errorf("not an AC module: type 0x%04X, vendor 0x%08X", common.ModuleType, common.ModuleVendor)
+	}
+	if common.Size.Size() > uint64(len(acm)) {
+		return nil, fmt.Errorf("AC module size 0x%x exceeds the buffer (0x%x)", common.Size.Size(), len(acm))
+	}
+	acm = acm[:common.Size.Size()]
+	offset := common.HeaderLen.Size() + common.ScratchSize.Size()
+	if offset >= uint64(len(acm)) {
+		return nil, fmt.Errorf("information table at 0x%x is outside of the module (0x%x)", offset, len(acm))
+	}
+	var info ACMInfo
+	if err := binary.Read(bytes.NewReader(acm[offset:]), binary.LittleEndian, &info.ACMInfoTable); err != nil {
+		return nil, fmt.Errorf("unable to parse the information table: %w", err)
+	}
+	if info.UUID != ACMInfoTableUUID {
+		return nil, fmt.Errorf("information table UUID %v is not %v", info.UUID, ACMInfoTableUUID)
+	}
+	if info.Version < 4 {
+		info.ProcessorIDList = 0
+	}
+
+	count, r, err := acmList(acm, info.ChipsetIDList, binary.Size(ACMChipsetID{}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the chipset ID list: %w", err)
+	}
+	info.ChipsetIDs = make([]ACMChipsetID, count)
+	if err := binary.Read(r, binary.LittleEndian, info.ChipsetIDs); err != nil {
+		return nil, fmt.Errorf("unable to parse the chipset ID list: %w", err)
+	}
+	if info.ProcessorIDList != 0 {
+		count, r, err := acmList(acm, info.ProcessorIDList, binary.Size(ACMProcessorID{}))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the processor ID list: %w", err)
+		}
+		info.ProcessorIDs = make([]ACMProcessorID, count)
+		if err := binary.Read(r, binary.LittleEndian, info.ProcessorIDs); err != nil {
+			return nil, fmt.Errorf("unable to parse the processor ID list: %w", err)
+		}
+	}
+	return &info, nil
+}
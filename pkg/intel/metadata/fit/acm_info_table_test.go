@@ -0,0 +1,67 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testACM returns a SINIT AC module of 0x400 bytes with its information
+// table at 0x200, a chipset ID list at 0x300 and a processor ID list at
+// 0x340.
+func testACM() []byte {
+	b := make([]byte, 0x400)
+	binary.LittleEndian.PutUint16(b[0:], uint16(ACModuleTypeChipset))
+	binary.LittleEndian.PutUint32(b[4:], 0x40)
+	binary.LittleEndian.PutUint32(b[16:], uint32(ACModuleVendorIntel))
+	binary.LittleEndian.PutUint32(b[20:], 0x20230115)
+	binary.LittleEndian.PutUint32(b[24:], 0x100)
+	binary.LittleEndian.PutUint32(b[124:], 0x40)
+
+	info := b[0x200:]
+	copy(info, ACMInfoTableUUID[:])
+	info[16] = uint8(ChipsetACMTypeSINIT)
+	info[17] = 9
+	binary.LittleEndian.PutUint32(info[20:], 0x300)
+	copy(info[36:], []byte{1, 1, 4, 2})
+	binary.LittleEndian.PutUint32(info[40:], 0x340)
+
+	binary.LittleEndian.PutUint32(b[0x300:], 1)
+	binary.LittleEndian.PutUint16(b[0x308:], 0x8086)
+	binary.LittleEndian.PutUint16(b[0x30a:], 0xa0c0)
+	binary.LittleEndian.PutUint32(b[0x340:], 1)
+	binary.LittleEndian.PutUint32(b[0x344:], 0x806c0)
+	binary.LittleEndian.PutUint32(b[0x348:], 0xfff3ff0)
+	return b
+}
+
+func TestParseACMInfo(t *testing.T) {
+	info, err := ParseACMInfo(testACM())
+	require.NoError(t, err)
+	require.Equal(t, ChipsetACMTypeSINIT, info.ChipsetACMType)
+	require.Equal(t, "SINIT", info.ChipsetACMType.String())
+	require.Equal(t, uint8(1), info.ACMVersion)
+	require.Equal(t, "1.4.2", info.Revision())
+	require.Equal(t, []ACMChipsetID{{VendorID: 0x8086, DeviceID: 0xa0c0}}, info.ChipsetIDs)
+	require.Equal(t, []ACMProcessorID{{FMS: 0x806c0, FMSMask: 0xfff3ff0}}, info.ProcessorIDs)
+}
+
+func TestParseACMInfoInvalid(t *testing.T) {
+	b := testACM()
+	b[0x200] ^= 0xff
+	_, err := ParseACMInfo(b)
+	require.Error(t, err)
+
+	b = testACM()
+	binary.LittleEndian.PutUint32(b[0x300:], 0x100)
+	_, err = ParseACMInfo(b)
+	require.Error(t, err)
+
+	_, err = ParseACMInfo(testACM()[:0x200])
+	require.Error(t, err)
+}
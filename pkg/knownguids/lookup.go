@@ -0,0 +1,91 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownguids
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// Entry is a known GUID with its name.
+type Entry struct {
+	GUID guid.GUID
+	Name string
+}
+
+// entries are the GUIDs sorted by name, then GUID.
+var entries = func() []Entry {
+	es := make([]Entry, 0, len(GUIDs))
+	for g, n := range GUIDs {
+		es = append(es, Entry{GUID: g, Name: n})
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].Name != es[j].Name {
+			return es[i].Name < es[j].Name
+		}
+		return bytes.Compare(es[i].GUID[:], es[j].GUID[:]) < 0
+	})
+	return es
+}()
+
+// GUIDToName returns the name of a known GUID.
+func GUIDToName(g guid.GUID) (string, bool) {
+	n, ok := GUIDs[g]
+	return n, ok
+}
+
+// NameToGUIDs returns the GUIDs known by the name, which several modules
+// may share, like the AcpiPlatform drivers of different platforms. Names
+// are compared case-insensitively.
+func NameToGUIDs(name string) []guid.GUID {
+	var gs []guid.GUID
+	for _, e := range entries {
+		if strings.EqualFold(e.Name, name) {
+			gs = append(gs, e.GUID)
+		}
+	}
+	return gs
+}
+
+// NameToGUID returns the GUID known by the name, failing if there is none
+// or several.
+func NameToGUID(name string) (guid.GUID, error) {
+	gs := NameToGUIDs(name)
+	switch len(gs) {
+	case 0:
+		return guid.GUID{}, fmt.Errorf("no known GUID is named %q", name)
+	case 1:
+		return gs[0], nil
+	}
+	return guid.GUID{}, fmt.Errorf("%d known GUIDs are named %q: %v", len(gs), name, gs)
+}
+
+// Search returns the known GUIDs whose name contains s, ignoring case,
+// sorted by name.
+func Search(s string) []Entry {
+	s = strings.ToLower(s)
+	return filter(func(e Entry) bool { return strings.Contains(strings.ToLower(e.Name), s) })
+}
+
+// SearchRegexp returns the known GUIDs whose name matches re, sorted by
+// name.
+func SearchRegexp(re *regexp.Regexp) []Entry {
+	return filter(func(e Entry) bool { return re.MatchString(e.Name) })
+}
+
+func filter(match func(Entry) bool) []Entry {
+	var es []Entry
+	for _, e := range entries {
+		if match(e) {
+			es = append(es, e)
+		}
+	}
+	return es
+}
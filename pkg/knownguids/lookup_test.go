@@ -0,0 +1,65 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownguids
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+var shellGUID = *guid.MustParse("7C04A583-9E3E-4F1C-AD65-E05268D0B4D1")
+
+func TestGUIDToName(t *testing.T) {
+	if n, ok := GUIDToName(shellGUID); !ok || n != "Shell" {
+		t.Errorf("got %q, %v, want Shell", n, ok)
+	}
+	if n, ok := GUIDToName(guid.GUID{}); ok {
+		t.Errorf("got %q for the zero GUID, want none", n)
+	}
+}
+
+func TestNameToGUID(t *testing.T) {
+	for _, name := range []string{"Shell", "shell"} {
+		g, err := NameToGUID(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g != shellGUID {
+			t.Errorf("%s: got %v, want %v", name, g, shellGUID)
+		}
+	}
+	if _, err := NameToGUID("NoSuchModule"); err == nil {
+		t.Errorf("NoSuchModule resolved, want an error")
+	}
+	// Several drivers share the name.
+	if _, err := NameToGUID("AcpiPlatform"); err == nil {
+		t.Errorf("AcpiPlatform resolved, want an error")
+	}
+	if gs := NameToGUIDs("AcpiPlatform"); len(gs) < 2 {
+		t.Errorf("got %v, want several GUIDs for AcpiPlatform", gs)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	es := Search("s3resume")
+	if len(es) == 0 {
+		t.Fatalf("found no S3Resume modules")
+	}
+	for i, e := range es {
+		if GUIDs[e.GUID] != e.Name {
+			t.Errorf("got %v named %q, want %q", e.GUID, e.Name, GUIDs[e.GUID])
+		}
+		if i > 0 && es[i-1].Name > e.Name {
+			t.Errorf("%q comes before %q", es[i-1].Name, e.Name)
+		}
+	}
+
+	es = SearchRegexp(regexp.MustCompile(`^Shell$`))
+	if len(es) != 1 || es[0].GUID != shellGUID {
+		t.Errorf("got %v, want only the Shell", es)
+	}
+}
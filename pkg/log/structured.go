@@ -0,0 +1,180 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Level is the severity of a message.
+type Level int
+
+// Levels of messages, from the least severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+	LevelFatal: "FATAL",
+}
+
+func (l Level) String() string {
+	if n, ok := levelNames[l]; ok {
+		return n
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel parses the name of a level, in any case.
+func ParseLevel(s string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, want debug, info, warn, error or fatal", s)
+}
+
+// Fields are the key value pairs logged with a message.
+type Fields map[string]interface{}
+
+// FieldLogger is a Logger which also logs messages at any level with
+// fields.
+type FieldLogger interface {
+	Logger
+
+	// Log logs msg with the fields at the level.
+	Log(level Level, msg string, fields Fields)
+
+	// With returns a logger adding the fields to every message.
+	With(fields Fields) FieldLogger
+}
+
+// Options are the options of New.
+type Options struct {
+	// Level is the least severe level logged.
+	Level Level
+	// JSON writes every message as a JSON object on one line, with the
+	// members level, msg and the fields, rather than as text.
+	JSON bool
+}
+
+// WriterLogger is the FieldLogger of New.
+type WriterLogger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	opts   Options
+	fields Fields
+}
+
+// New returns a logger writing the messages to w, one per line.
+func New(w io.Writer, opts Options) *WriterLogger {
+	return &WriterLogger{mu: &sync.Mutex{}, w: w, opts: opts}
+}
+
+// Discard is a logger which logs nothing but fatal messages, for tests and
+// quiet programs. It still exits on them.
+var Discard FieldLogger = New(io.Discard, Options{Level: LevelFatal})
+
+// Log implements FieldLogger.
+func (l *WriterLogger) Log(level Level, msg string, fields Fields) {
+	if level < l.opts.Level {
+		return
+	}
+	all := Fields{}
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+
+	var line string
+	if l.opts.JSON {
+		all["level"] = strings.ToLower(level.String())
+		all["msg"] = msg
+		b, err := json.Marshal(all)
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{"level": "error", "msg": fmt.Sprintf("logging %q: %v", msg, err)})
+		}
+		line = string(b)
+	} else {
+		keys := make([]string, 0, len(all))
+		for k := range all {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		fmt.Fprintf(&b, "[fiano][%v] %s", level, msg)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, all[k])
+		}
+		line = b.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, line)
+}
+
+// With implements FieldLogger.
+func (l *WriterLogger) With(fields Fields) FieldLogger {
+	all := Fields{}
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+	return &WriterLogger{mu: l.mu, w: l.w, opts: l.opts, fields: all}
+}
+
+// Warnf implements Logger.
+func (l *WriterLogger) Warnf(format string, args ...interface{}) {
+	l.Log(LevelWarn, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf implements Logger.
+func (l *WriterLogger) Errorf(format string, args ...interface{}) {
+	l.Log(LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+// Fatalf implements Logger.
+func (l *WriterLogger) Fatalf(format string, args ...interface{}) {
+	l.Log(LevelFatal, fmt.Sprintf(format, args...), nil)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context logging to l. Parsing and the visitors
+// running with the context log their diagnostics there rather than to
+// DefaultLogger.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger of ctx, DefaultLogger if it has none.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok && l != nil {
+		return l
+	}
+	return DefaultLogger
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriterLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Options{Level: LevelWarn})
+	l.Log(LevelInfo, "hidden", nil)
+	l.With(Fields{"guid": "D6A2CB7F", "size": 16}).Log(LevelError, "bad file", Fields{"size": 32})
+	l.Warnf("%d volumes", 2)
+	want := "[fiano][ERROR] bad file guid=D6A2CB7F size=32\n[fiano][WARN] 2 volumes\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestWriterLoggerJSON(t *testing.T) {
+	var b bytes.Buffer
+	New(&b, Options{JSON: true}).With(Fields{"guid": "D6A2CB7F"}).Errorf("bad %s", "file")
+	var got map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("%v in %q", err, b.String())
+	}
+	want := map[string]interface{}{"level": "error", "msg": "bad file", "guid": "D6A2CB7F"}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if l := FromContext(context.Background()); l != DefaultLogger {
+		t.Errorf("got %v without a logger, want the DefaultLogger", l)
+	}
+	if l := FromContext(WithLogger(context.Background(), Discard)); l != Discard {
+		t.Errorf("got %v, want Discard", l)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	l, err := ParseLevel("warn")
+	if err != nil || l != LevelWarn {
+		t.Errorf("got %v, %v, want %v", l, err, LevelWarn)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("parsing the loud level succeeded, want an error")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CabFile is a file of a cabinet.
+type CabFile struct {
+	Name string
+	Data []byte
+	// Time of the file, the zero time being 1980-01-01.
+	Time time.Time
+}
+
+const (
+	cabHeaderSize = 36
+	cabFolderSize = 8
+	cabFileSize   = 16
+	cabDataSize   = 8
+	// cabMaxBlock is the largest block of data of a cabinet.
+	cabMaxBlock = 0x8000
+	// cabArchive is the archive attribute of files, _A_ARCH.
+	cabArchive = 0x20
+)
+
+// cabChecksum is the checksum of the blocks of data of cabinets, seeded
+// with seed.
+func cabChecksum(b []byte, seed uint32) uint32 {
+	csum := seed
+	for ; len(b) >= 4; b = b[4:] {
+		csum ^= binary.LittleEndian.Uint32(b)
+	}
+	var ul uint32
+	for _, c := range b {
+		ul = ul<<8 | uint32(c)
+	}
+	return csum ^ ul
+}
+
+// dosDateTime returns the MS-DOS date and time of t.
+func dosDateTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		return 1<<5 | 1, 0
+	}
+	date := uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	tm := uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+	return date, tm
+}
+
+// WriteCab writes the files as an uncompressed Microsoft cabinet, the
+// archive of the updates of LVFS, in a single folder.
+func WriteCab(w io.Writer, files []CabFile) error {
+	if len(files) == 0 || len(files) > 0xffff {
+		return fmt.Errorf("a cabinet holds 1 to 65535 files, got %d", len(files))
+	}
+	var data []byte
+	filesSize := 0
+	for _, f := range files {
+		if uint64(len(f.Data)) > 0xffffffff-uint64(len(data)) {
+			return fmt.Errorf("file %q does not fit in a cabinet", f.Name)
+		}
+		data = append(data, f.Data...)
+		filesSize += cabFileSize + len(f.Name) + 1
+	}
+	blocks := (len(data) + cabMaxBlock - 1) / cabMaxBlock
+	if blocks == 0 {
+		blocks = 1
+	}
+	if blocks > 0xffff {
+		return fmt.Errorf("%d bytes do not fit in a cabinet", len(data))
+	}
+	dataStart := cabHeaderSize + cabFolderSize + filesSize
+	size := dataStart + blocks*cabDataSize + len(data)
+
+	var b bytes.Buffer
+	le := func(v interface{}) {
+		// Writing to a bytes.Buffer cannot fail.
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("MSCF")
+	le([]uint32{0, uint32(size), 0, cabHeaderSize + cabFolderSize, 0})
+	le([]uint8{3, 1})
+	le([]uint16{1, uint16(len(files)), 0, 0, 0})
+	le(uint32(dataStart))
+	le([]uint16{uint16(blocks), 0})
+	var offset uint32
+	for _, f := range files {
+		date, tm := dosDateTime(f.Time)
+		le([]uint32{uint32(len(f.Data)), offset})
+		le([]uint16{0, date, tm, cabArchive})
+		b.WriteString(f.Name)
+		b.WriteByte(0)
+		offset += uint32(len(f.Data))
+	}
+	for i := 0; i < blocks; i++ {
+		end := (i + 1) * cabMaxBlock
+		if end > len(data) {
+			end = len(data)
+		}
+		block := data[i*cabMaxBlock : end]
+		var sizes [4]byte
+		binary.LittleEndian.PutUint16(sizes[0:], uint16(len(block)))
+		binary.LittleEndian.PutUint16(sizes[2:], uint16(len(block)))
+		le(cabChecksum(sizes[:], cabChecksum(block, 0)))
+		b.Write(sizes[:])
+		b.Write(block)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lvfs packages firmware images as updates of the Linux Vendor
+// Firmware Service, a cabinet holding the image and the metainfo.xml which
+// describes it to fwupd.
+package lvfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// Names of the files of an update.
+const (
+	FirmwareName = "firmware.bin"
+	MetainfoName = "firmware.metainfo.xml"
+)
+
+// Metadata describes an update, the fields a vendor fills in for LVFS.
+type Metadata struct {
+	// ID is the reverse DNS name of the component, like
+	// com.example.Laptop.firmware.
+	ID      string
+	Name    string
+	Summary string
+	// Description is a paragraph of text.
+	Description   string
+	DeveloperName string
+	// ProjectLicense defaults to proprietary.
+	ProjectLicense string
+	// MetadataLicense defaults to CC0-1.0.
+	MetadataLicense string
+	// GUIDs are the hardware IDs of the devices the firmware is for, the
+	// ESRT GUID of the system firmware usually.
+	GUIDs   []string
+	Version string
+	// ReleaseDate is of the form 2006-01-02, the day of packaging if empty.
+	ReleaseDate        string
+	ReleaseDescription string
+	// Urgency is one of low, medium, high or critical, if set.
+	Urgency string
+	// VersionFormat is the LVFS::VersionFormat of the version, like
+	// triplet or intel-me, if set.
+	VersionFormat string
+	// Protocol is the LVFS::UpdateProtocol, org.uefi.capsule by default.
+	Protocol string
+}
+
+var idRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)+$`)
+
+// Validate checks the fields LVFS requires and fills in the defaults.
+func (m *Metadata) Validate() error {
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"ID", m.ID}, {"Name", m.Name}, {"Summary", m.Summary},
+		{"DeveloperName", m.DeveloperName}, {"Version", m.Version},
+	} {
+		if f.value == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(m.GUIDs) == 0 {
+		missing = append(missing, "GUIDs")
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("missing metadata: %s", strings.Join(missing, ", "))
+	}
+	if !idRegexp.MatchString(m.ID) {
+		return fmt.Errorf("ID %q is not a reverse DNS name", m.ID)
+	}
+	for _, g := range m.GUIDs {
+		if _, err := guid.Parse(g); err != nil {
+			return err
+		}
+	}
+	switch m.Urgency {
+	case "", "low", "medium", "high", "critical":
+	default:
+		return fmt.Errorf("urgency %q is not one of low, medium, high or critical", m.Urgency)
+	}
+	if m.ReleaseDate == "" {
+		m.ReleaseDate = time.Now().UTC().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", m.ReleaseDate); err != nil {
+		return fmt.Errorf("release date %q is not of the form 2006-01-02", m.ReleaseDate)
+	}
+	if m.ProjectLicense == "" {
+		m.ProjectLicense = "proprietary"
+	}
+	if m.MetadataLicense == "" {
+		m.MetadataLicense = "CC0-1.0"
+	}
+	if m.Protocol == "" {
+		m.Protocol = "org.uefi.capsule"
+	}
+	return nil
+}
+
+type paragraph struct {
+	P string `xml:"p"`
+}
+
+// newParagraph returns the description of text, if any.
+func newParagraph(text string) *paragraph {
+	if text == "" {
+		return nil
+	}
+	return &paragraph{P: text}
+}
+
+type firmwareProvide struct {
+	Type string `xml:"type,attr"`
+	GUID string `xml:",chardata"`
+}
+
+type checksum struct {
+	Type     string `xml:"type,attr"`
+	Filename string `xml:"filename,attr"`
+	Target   string `xml:"target,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type release struct {
+	Version     string     `xml:"version,attr"`
+	Date        string     `xml:"date,attr"`
+	Urgency     string     `xml:"urgency,attr,omitempty"`
+	Checksum    checksum   `xml:"checksum"`
+	Description *paragraph `xml:"description"`
+}
+
+type value struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type component struct {
+	XMLName         xml.Name          `xml:"component"`
+	Type            string            `xml:"type,attr"`
+	ID              string            `xml:"id"`
+	Name            string            `xml:"name"`
+	Summary         string            `xml:"summary"`
+	Description     *paragraph        `xml:"description"`
+	Provides        []firmwareProvide `xml:"provides>firmware"`
+	MetadataLicense string            `xml:"metadata_license"`
+	ProjectLicense  string            `xml:"project_license"`
+	DeveloperName   string            `xml:"developer_name"`
+	Releases        []release         `xml:"releases>release"`
+	Custom          []value           `xml:"custom>value"`
+}
+
+// Metainfo returns the metainfo.xml of the firmware image.
+func Metainfo(m *Metadata, image []byte) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(image)
+	c := component{
+		Type:            "firmware",
+		ID:              m.ID,
+		Name:            m.Name,
+		Summary:         m.Summary,
+		Description:     newParagraph(m.Description),
+		MetadataLicense: m.MetadataLicense,
+		ProjectLicense:  m.ProjectLicense,
+		DeveloperName:   m.DeveloperName,
+		Releases: []release{{
+			Version: m.Version,
+			Date:    m.ReleaseDate,
+			Urgency: m.Urgency,
+			Checksum: checksum{
+				Type:     "sha256",
+				Filename: FirmwareName,
+				Target:   "content",
+				Value:    hex.EncodeToString(sum[:]),
+			},
+			Description: newParagraph(m.ReleaseDescription),
+		}},
+		Custom: []value{{Key: "LVFS::UpdateProtocol", Value: m.Protocol}},
+	}
+	for _, g := range m.GUIDs {
+		// Validate parsed them already.
+		c.Provides = append(c.Provides, firmwareProvide{Type: "flashed", GUID: strings.ToLower(guid.MustParse(g).String())})
+	}
+	if m.VersionFormat != "" {
+		c.Custom = append(c.Custom, value{Key: "LVFS::VersionFormat", Value: m.VersionFormat})
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&c); err != nil {
+		return nil, err
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// WriteCabinet writes the update of the image, a cabinet holding
+// FirmwareName and MetainfoName.
+func WriteCabinet(w io.Writer, m *Metadata, image []byte) error {
+	metainfo, err := Metainfo(m, image)
+	if err != nil {
+		return err
+	}
+	// The date of the release is that of the files, for reproducible
+	// cabinets.
+	date, err := time.Parse("2006-01-02", m.ReleaseDate)
+	if err != nil {
+		return err
+	}
+	return WriteCab(w, []CabFile{
+		{Name: FirmwareName, Data: image, Time: date},
+		{Name: MetainfoName, Data: metainfo, Time: date},
+	})
+}
+
+// WriteDir writes FirmwareName and MetainfoName of the update of the image
+// to dir, the layout of the cabinet, for tools like gcab to archive.
+func WriteDir(dir string, m *Metadata, image []byte) error {
+	metainfo, err := Metainfo(m, image)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, FirmwareName), image, 0666); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MetainfoName), metainfo, 0666)
+}
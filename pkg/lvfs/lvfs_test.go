@@ -0,0 +1,150 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lvfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testMetadata() *Metadata {
+	return &Metadata{
+		ID:            "org.linuxboot.Test.firmware",
+		Name:          "Test",
+		Summary:       "Firmware of the test board",
+		DeveloperName: "LinuxBoot",
+		GUIDs:         []string{"7CB8BDC9-F8EB-4F34-AAEA-3EE4AF6516A1"},
+		Version:       "1.2.3",
+		ReleaseDate:   "2021-06-01",
+		Urgency:       "high",
+		VersionFormat: "triplet",
+	}
+}
+
+func TestMetainfo(t *testing.T) {
+	b, err := Metainfo(testMetadata(), []byte("image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c component
+	if err := xml.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != "firmware" || c.ID != "org.linuxboot.Test.firmware" || c.ProjectLicense != "proprietary" || c.MetadataLicense != "CC0-1.0" {
+		t.Errorf("got component %+v", c)
+	}
+	if len(c.Provides) != 1 || c.Provides[0] != (firmwareProvide{"flashed", "7cb8bdc9-f8eb-4f34-aaea-3ee4af6516a1"}) {
+		t.Errorf("got provides %v, want the lowercase GUID", c.Provides)
+	}
+	// sha256sum of "image".
+	const sum = "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+	if len(c.Releases) != 1 || c.Releases[0].Checksum.Value != sum || c.Releases[0].Urgency != "high" {
+		t.Errorf("got releases %+v", c.Releases)
+	}
+	for _, s := range []string{
+		`<value key="LVFS::UpdateProtocol">org.uefi.capsule</value>`,
+		`<value key="LVFS::VersionFormat">triplet</value>`,
+		`<release version="1.2.3" date="2021-06-01" urgency="high">`,
+	} {
+		if !bytes.Contains(b, []byte(s)) {
+			t.Errorf("metainfo lacks %s:\n%s", s, b)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, change := range map[string]func(m *Metadata){
+		"missing": func(m *Metadata) { m.ID, m.GUIDs = "", nil },
+		"id":      func(m *Metadata) { m.ID = "firmware" },
+		"guid":    func(m *Metadata) { m.GUIDs = []string{"nope"} },
+		"urgency": func(m *Metadata) { m.Urgency = "now" },
+		"date":    func(m *Metadata) { m.ReleaseDate = "June 2021" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := testMetadata()
+			change(m)
+			if err := m.Validate(); err == nil {
+				t.Errorf("validated %+v, want an error", m)
+			}
+		})
+	}
+	m := testMetadata()
+	m.ReleaseDate = ""
+	if err := m.Validate(); err != nil || m.ReleaseDate == "" {
+		t.Errorf("got %v and date %q, want today", err, m.ReleaseDate)
+	}
+}
+
+// readCab returns the files of an uncompressed cabinet of a folder,
+// checking the checksums of its blocks.
+func readCab(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+	le := binary.LittleEndian
+	if string(b[:4]) != "MSCF" || int(le.Uint32(b[8:])) != len(b) {
+		t.Fatalf("bad cabinet header % x", b[:36])
+	}
+	nFiles := int(le.Uint16(b[28:]))
+	dataStart := le.Uint32(b[36:])
+	nBlocks := int(le.Uint16(b[40:]))
+	if typ := le.Uint16(b[42:]); typ != 0 {
+		t.Fatalf("got compression %d, want none", typ)
+	}
+	var data []byte
+	p := b[dataStart:]
+	for i := 0; i < nBlocks; i++ {
+		n := int(le.Uint16(p[4:]))
+		if got := cabChecksum(p[4:8], cabChecksum(p[8:8+n], 0)); got != le.Uint32(p) {
+			t.Errorf("block %d: got checksum %#x, want %#x", i, le.Uint32(p), got)
+		}
+		data = append(data, p[8:8+n]...)
+		p = p[8+n:]
+	}
+	files := map[string][]byte{}
+	p = b[le.Uint32(b[16:]):]
+	for i := 0; i < nFiles; i++ {
+		size, off := le.Uint32(p), le.Uint32(p[4:])
+		name := string(p[16 : 16+bytes.IndexByte(p[16:], 0)])
+		files[name] = data[off : off+size]
+		p = p[16+len(name)+1:]
+	}
+	return files
+}
+
+func TestWriteCabinet(t *testing.T) {
+	// Several blocks, the last partial.
+	image := bytes.Repeat([]byte("0123456789abcdef"), 5000)
+	var b bytes.Buffer
+	if err := WriteCabinet(&b, testMetadata(), image); err != nil {
+		t.Fatal(err)
+	}
+	files := readCab(t, b.Bytes())
+	if !bytes.Equal(files[FirmwareName], image) {
+		t.Errorf("the cabinet holds another image")
+	}
+	if !strings.Contains(string(files[MetainfoName]), "<component type=\"firmware\">") {
+		t.Errorf("got metainfo %q", files[MetainfoName])
+	}
+
+	if err := WriteCab(&b, nil); err == nil {
+		t.Errorf("writing an empty cabinet succeeded, want an error")
+	}
+}
+
+func TestWriteDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "update")
+	if err := WriteDir(dir, testMetadata(), []byte("image")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{FirmwareName, MetainfoName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measuredboot
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	pkgbytes "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+)
+
+// BootGuardPCR0Description describes the single event the Boot Guard ACM
+// extends into PCR0.
+const BootGuardPCR0Description = "Boot Guard Measured S-CRTM"
+
+// BootGuardPCR0Data is what the Boot Guard ACM measures into PCR0 before
+// the IBB runs.
+type BootGuardPCR0Data struct {
+	// ACMPolicyStatus is the value of the ACM policy status register, which
+	// depends on the fuses and the boot and is not part of the image.
+	ACMPolicyStatus uint64
+	ACMSVN          uint16
+	ACMSignature    []byte
+	KMSignature     []byte
+	BPMSignature    []byte
+	IBBDigest       []byte
+}
+
+// Bytes returns the measured data, the concatenation of the fields.
+func (d *BootGuardPCR0Data) Bytes() []byte {
+	b := make([]byte, 10)
+	binary.LittleEndian.PutUint64(b, d.ACMPolicyStatus)
+	binary.LittleEndian.PutUint16(b[8:], d.ACMSVN)
+	b = append(b, d.ACMSignature...)
+	b = append(b, d.KMSignature...)
+	b = append(b, d.BPMSignature...)
+	return append(b, d.IBBDigest...)
+}
+
+// BootGuard returns the PCR0 measurement the Boot Guard ACM makes for the
+// full flash image in image, found through its FIT, with the digests of
+// bank h. The IBB digest is computed over the IBB segments of the boot
+// policy manifest.
+func BootGuard(image []byte, h crypto.Hash, acmPolicyStatus uint64) (*Log, error) {
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return nil, err
+	}
+	hdr := table.First(fit.EntryTypeStartupACModuleEntry)
+	if hdr == nil {
+		return nil, errors.New("no startup ACM in the FIT")
+	}
+	acm, err := hdr.GetEntry(image).(*fit.EntrySACM).ParseData()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the startup ACM: %w", err)
+	}
+	d := &BootGuardPCR0Data{
+		ACMPolicyStatus: acmPolicyStatus,
+		ACMSVN:          uint16(acm.GetTXTSVN()),
+		ACMSignature:    acm.GetRSASig(),
+	}
+
+	bgKM, cbntKM, err := table.ParseKeyManifest(image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the key manifest: %w", err)
+	}
+	bgBPM, cbntBPM, err := table.ParseBootPolicyManifest(image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the boot policy manifest: %w", err)
+	}
+
+	l := &Log{Hash: h}
+	var ibb pkgbytes.Ranges
+	switch {
+	case bgKM != nil && bgBPM != nil && len(bgBPM.SE) > 0:
+		d.KMSignature = bgKM.KeyAndSignature.Signature.Data
+		d.BPMSignature = bgBPM.PMSE.Signature.Data
+		ibb = bgBPM.IBBDataRanges(uint64(len(image)))
+		if bgBPM.SE[0].Flags.Locality3Startup() {
+			l.Locality = 3
+		}
+	case cbntKM != nil && cbntBPM != nil && len(cbntBPM.SE) > 0:
+		d.KMSignature = cbntKM.KeyAndSignature.Signature.Data
+		d.BPMSignature = cbntBPM.PMSE.Signature.Data
+		ibb = cbntBPM.IBBDataRanges(uint64(len(image)))
+		if cbntBPM.SE[0].Flags.Locality3Startup() {
+			l.Locality = 3
+		}
+	default:
+		return nil, errors.New("key and boot policy manifests of different Boot Guard versions")
+	}
+
+	ibbHash := h.New()
+	for _, r := range ibb {
+		if r.End() > uint64(len(image)) {
+			return nil, fmt.Errorf("IBB segment at %#x of %#x bytes out of the image", r.Offset, r.Length)
+		}
+		ibbHash.Write(image[r.Offset:r.End()])
+	}
+	d.IBBDigest = ibbHash.Sum(nil)
+
+	data := d.Bytes()
+	l.Measure(0, BootGuardPCR0Description, data)
+	l.Events[0].Data = data
+	return l, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measuredboot
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/cbfs"
+)
+
+// CorebootSRTMPCR is the default PCR coreboot measures its code into,
+// CONFIG_PCR_SRTM.
+const CorebootSRTMPCR = 2
+
+// CorebootStages are the CBFS files coreboot measures as it loads them,
+// in boot order.
+var CorebootStages = []string{
+	"fallback/verstage",
+	"fallback/romstage",
+	"fallback/postcar",
+	"fallback/ramstage",
+	"fallback/payload",
+}
+
+// Coreboot returns the measurements coreboot with TPM measured boot makes
+// into pcr for the image: the FMAP and the bootblock as CRTM, then each
+// stage of the CBFS in region as stored, compressed or not. Files read at
+// runtime, which go to another PCR in an order depending on the boot, are
+// not included.
+func Coreboot(image []byte, region string, h crypto.Hash, pcr int) (*Log, error) {
+	i, err := cbfs.NewImageRegion(bytes.NewReader(image), region)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{Hash: h}
+
+	if x := i.FMAP.IndexOfArea("FMAP"); x != -1 {
+		a := i.FMAP.Areas[x]
+		if uint64(a.Offset)+uint64(a.Size) > uint64(len(image)) {
+			return nil, fmt.Errorf("FMAP area at %#x of %#x bytes out of the image", a.Offset, a.Size)
+		}
+		l.Measure(pcr, "FMAP: FMAP", image[a.Offset:a.Offset+a.Size])
+	}
+
+	files := map[string]*cbfs.File{}
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		files[f.Name] = f
+	}
+	bootblock, ok := files["bootblock"]
+	if !ok {
+		return nil, fmt.Errorf("no bootblock in the %s CBFS", region)
+	}
+	for _, f := range append([]*cbfs.File{bootblock}, stages(files)...) {
+		// The file readers may transform the data, so hash it as stored.
+		start := uint64(i.Area.Offset) + uint64(f.RecordStart) + uint64(f.SubHeaderOffset)
+		if end := start + uint64(f.Size); end > uint64(len(image)) {
+			return nil, fmt.Errorf("data of %s at %#x of %#x bytes out of the image", f.Name, start, f.Size)
+		}
+		l.Measure(pcr, "CBFS: "+f.Name, image[start:start+uint64(f.Size)])
+	}
+	return l, nil
+}
+
+// stages returns the files of CorebootStages present in files.
+func stages(files map[string]*cbfs.File) []*cbfs.File {
+	var r []*cbfs.File
+	for _, n := range CorebootStages {
+		if f, ok := files[n]; ok {
+			r = append(r, f)
+		}
+	}
+	return r
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package measuredboot precomputes the measurements a platform extends
+// into the TPM when booting an image, so attestation policies can be
+// written before the image is flashed.
+package measuredboot
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+
+	// Register the hashes TPM banks use.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Event is one measurement extended into a PCR.
+type Event struct {
+	PCR         int
+	Description string
+	// Data is what is measured, when it is small enough to be logged.
+	Data   []byte `json:",omitempty"`
+	Digest []byte
+}
+
+// Log is the list of events extended into the PCRs of one bank.
+type Log struct {
+	Hash crypto.Hash
+	// Locality the TPM was started from. For TPM 2.0, PCR0 starts with
+	// this value in its last byte instead of zero.
+	Locality uint8
+	Events   []Event
+}
+
+// Measure appends an event measuring data into pcr.
+func (l *Log) Measure(pcr int, description string, data []byte) {
+	h := l.Hash.New()
+	h.Write(data)
+	l.Events = append(l.Events, Event{PCR: pcr, Description: description, Digest: h.Sum(nil)})
+}
+
+// Extend returns the value of a PCR holding pcr after digest is extended
+// into it.
+func Extend(h crypto.Hash, pcr, digest []byte) []byte {
+	hh := h.New()
+	hh.Write(pcr)
+	hh.Write(digest)
+	return hh.Sum(nil)
+}
+
+// PCR replays the events of the log and returns the final value of pcr.
+func (l *Log) PCR(pcr int) []byte {
+	v := make([]byte, l.Hash.Size())
+	if pcr == 0 {
+		v[len(v)-1] = l.Locality
+	}
+	for _, e := range l.Events {
+		if e.PCR == pcr {
+			v = Extend(l.Hash, v, e.Digest)
+		}
+	}
+	return v
+}
+
+// Print writes the events of the log followed by the final values of the
+// PCRs they extend.
+func (l *Log) Print(w io.Writer) {
+	var pcrs []int
+	seen := map[int]bool{}
+	for _, e := range l.Events {
+		fmt.Fprintf(w, "PCR%d %x %s\n", e.PCR, e.Digest, e.Description)
+		if !seen[e.PCR] {
+			seen[e.PCR] = true
+			pcrs = append(pcrs, e.PCR)
+		}
+	}
+	for _, pcr := range pcrs {
+		fmt.Fprintf(w, "PCR%d = %x (%v)\n", pcr, l.PCR(pcr), l.Hash)
+	}
+}
+
+// ParseHash returns the hash of the TPM bank named s, e.g. "sha256".
+func ParseHash(s string) (crypto.Hash, error) {
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if s == h.String() || s == hashNames[h] {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash %q, want one of sha1, sha256, sha384 or sha512", s)
+}
+
+var hashNames = map[crypto.Hash]string{
+	crypto.SHA1:   "sha1",
+	crypto.SHA256: "sha256",
+	crypto.SHA384: "sha384",
+	crypto.SHA512: "sha512",
+}
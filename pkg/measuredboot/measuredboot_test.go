@@ -0,0 +1,105 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measuredboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogPCR(t *testing.T) {
+	l := &Log{Hash: crypto.SHA256, Locality: 3}
+	l.Measure(0, "a", []byte("a"))
+	l.Measure(1, "b", []byte("b"))
+	l.Measure(0, "c", []byte("c"))
+
+	pcr0 := make([]byte, sha256.Size)
+	pcr0[sha256.Size-1] = 3
+	for _, s := range []string{"a", "c"} {
+		d := sha256.Sum256([]byte(s))
+		pcr0 = Extend(crypto.SHA256, pcr0, d[:])
+	}
+	if got := l.PCR(0); !bytes.Equal(got, pcr0) {
+		t.Errorf("PCR0: got %x, want %x", got, pcr0)
+	}
+	d := sha256.Sum256([]byte("b"))
+	if got, want := l.PCR(1), Extend(crypto.SHA256, make([]byte, sha256.Size), d[:]); !bytes.Equal(got, want) {
+		t.Errorf("PCR1: got %x, want %x", got, want)
+	}
+	if got := l.PCR(7); !bytes.Equal(got, make([]byte, sha256.Size)) {
+		t.Errorf("PCR7: got %x, want zeros", got)
+	}
+
+	var b strings.Builder
+	l.Print(&b)
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 5 {
+		t.Errorf("Print: got %q, want 3 events and 2 PCRs", b.String())
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	for s, want := range map[string]crypto.Hash{"sha1": crypto.SHA1, "SHA-256": crypto.SHA256, "sha384": crypto.SHA384} {
+		if h, err := ParseHash(s); err != nil || h != want {
+			t.Errorf("ParseHash(%q): got %v, %v, want %v", s, h, err, want)
+		}
+	}
+	if _, err := ParseHash("md5"); err == nil {
+		t.Errorf("ParseHash(md5): got nil, want error")
+	}
+}
+
+func TestBootGuardPCR0Data(t *testing.T) {
+	d := &BootGuardPCR0Data{
+		ACMPolicyStatus: 0x0102030405060708,
+		ACMSVN:          0x0a0b,
+		ACMSignature:    []byte{0xac},
+		KMSignature:     []byte{0x4b},
+		BPMSignature:    []byte{0xb0},
+		IBBDigest:       []byte{0x1b, 0x1b},
+	}
+	want := []byte{8, 7, 6, 5, 4, 3, 2, 1, 0x0b, 0x0a, 0xac, 0x4b, 0xb0, 0x1b, 0x1b}
+	if got := d.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("Bytes: got %x, want %x", got, want)
+	}
+}
+
+func TestBootGuardNoFIT(t *testing.T) {
+	if _, err := BootGuard(make([]byte, 0x10000), crypto.SHA256, 0); err == nil {
+		t.Errorf("BootGuard without FIT: got nil, want error")
+	}
+}
+
+func TestCoreboot(t *testing.T) {
+	image, err := os.ReadFile("../cbfs/testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Coreboot(image, "COREBOOT", crypto.SHA256, CorebootSRTMPCR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range l.Events {
+		if e.PCR != CorebootSRTMPCR {
+			t.Errorf("%s measured into PCR%d", e.Description, e.PCR)
+		}
+		got = append(got, e.Description)
+	}
+	want := "FMAP: FMAP,CBFS: bootblock,CBFS: fallback/romstage,CBFS: fallback/ramstage,CBFS: fallback/payload"
+	if strings.Join(got, ",") != want {
+		t.Errorf("events: got %v, want %v", got, want)
+	}
+	if fmap := sha256.Sum256(image[:0x200]); !bytes.Equal(l.Events[0].Digest, fmap[:]) {
+		t.Errorf("FMAP: got %x, want %x", l.Events[0].Digest, fmap)
+	}
+
+	if _, err := Coreboot(image, "FW_MAIN_A", crypto.SHA256, CorebootSRTMPCR); err == nil {
+		t.Errorf("Coreboot of a missing region: got nil, want error")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+var (
+	// AppleROMVersionSignature starts the raw section of Apple images
+	// describing the build of the image.
+	AppleROMVersionSignature = []byte("Apple ROM Version")
+	// BIOSIDSignature starts the Intel BIOS ID structure, which Apple
+	// images hold too.
+	BIOSIDSignature = []byte("$IBIOSI$")
+)
+
+// biosIDLength is the length of the UCS-2 string of the BIOS ID, NUL
+// included.
+const biosIDLength = 34 * 2
+
+// AppleROMVersion is the text of the Apple ROM version section: a line of
+// AppleROMVersionSignature, then lines of "Key: value" like
+//
+//	BIOS ID:      MBP151.88Z.F000.B00.1811070914
+//	Model:        MBP151
+//	EFI Version:  220.230.16.0.0
+type AppleROMVersion struct {
+	Fields map[string]string
+}
+
+// NewAppleROMVersion parses the text of an Apple ROM version section.
+func NewAppleROMVersion(buf []byte) (*AppleROMVersion, error) {
+	if !bytes.HasPrefix(buf, AppleROMVersionSignature) {
+		return nil, errors.New("no Apple ROM version signature")
+	}
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	r := &AppleROMVersion{Fields: map[string]string{}}
+	for _, l := range strings.Split(string(buf), "\n")[1:] {
+		kv := strings.SplitN(l, ":", 2)
+		if k := strings.TrimSpace(kv[0]); len(kv) == 2 && k != "" {
+			r.Fields[k] = strings.TrimSpace(kv[1])
+		}
+	}
+	return r, nil
+}
+
+// Model returns the model of the image, like MBP151.
+func (r *AppleROMVersion) Model() string {
+	return r.Fields["Model"]
+}
+
+// Version returns the EFI version of the image, else its ROM version.
+func (r *AppleROMVersion) Version() string {
+	if v := r.Fields["EFI Version"]; v != "" {
+		return v
+	}
+	return r.Fields["ROM Version"]
+}
+
+// BIOSID is the Intel BIOS ID of an image, like
+// MBP151.88Z.F000.B00.1811070914: the board, the OEM, the major version,
+// the build type and minor version, and the time stamp, dot separated.
+type BIOSID struct {
+	ID           string
+	Board        string
+	OEM          string
+	VersionMajor string
+	BuildType    string
+	VersionMinor string
+	TimeStamp    string
+	// Offset in the buffer it was found in.
+	Offset uint64
+}
+
+// FindBIOSID finds the BIOS ID structure in buf.
+func FindBIOSID(buf []byte) (*BIOSID, error) {
+	o := bytes.Index(buf, BIOSIDSignature)
+	if o < 0 {
+		return nil, errors.New("no BIOS ID")
+	}
+	s := buf[o+len(BIOSIDSignature):]
+	if len(s) > biosIDLength {
+		s = s[:biosIDLength]
+	}
+	for i := 0; i+1 < len(s); i += 2 {
+		if s[i] == 0 && s[i+1] == 0 {
+			s = s[:i]
+			break
+		}
+	}
+	if len(s) < 2 {
+		return nil, errors.New("empty BIOS ID")
+	}
+	id := &BIOSID{ID: unicode.UCS2ToUTF8(s), Offset: uint64(o)}
+	parts := strings.Split(id.ID, ".")
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	id.Board, id.OEM, id.VersionMajor, id.TimeStamp = parts[0], parts[1], parts[2], parts[4]
+	if parts[3] != "" {
+		id.BuildType, id.VersionMinor = parts[3][:1], parts[3][1:]
+	}
+	return id, nil
+}
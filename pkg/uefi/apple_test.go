@@ -0,0 +1,76 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+const sampleAppleROMVersion = "Apple ROM Version\n" +
+	"  BIOS ID:      MBP151.88Z.F000.B00.1811070914\n" +
+	"  Model:        MBP151\n" +
+	"  EFI Version:  220.230.16.0.0\n" +
+	"  Built by:     root@saumon\n" +
+	"  Date:         Wed Nov  7 09:14:51 PST 2018\n\000"
+
+func TestNewAppleROMVersion(t *testing.T) {
+	r, err := NewAppleROMVersion([]byte(sampleAppleROMVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Model() != "MBP151" || r.Version() != "220.230.16.0.0" {
+		t.Errorf("got model %q version %q, want MBP151 220.230.16.0.0", r.Model(), r.Version())
+	}
+	if d := r.Fields["Date"]; d != "Wed Nov  7 09:14:51 PST 2018" {
+		t.Errorf("got date %q, want the value past the first colon", d)
+	}
+
+	r, err = NewAppleROMVersion([]byte("Apple ROM Version\n  ROM Version:  MBP61.0057.B0F\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != "MBP61.0057.B0F" {
+		t.Errorf("got version %q, want the ROM version without an EFI version", r.Version())
+	}
+
+	if _, err := NewAppleROMVersion([]byte("Apple ROM")); err == nil {
+		t.Errorf("got no error without the signature")
+	}
+}
+
+func TestFindBIOSID(t *testing.T) {
+	buf := append(bytes.Repeat([]byte{0xff}, 0x20), BIOSIDSignature...)
+	buf = append(buf, unicode.UTF8ToUCS2("MBP151.88Z.F000.B00.1811070914")...)
+	id, err := FindBIOSID(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BIOSID{
+		ID:           "MBP151.88Z.F000.B00.1811070914",
+		Board:        "MBP151",
+		OEM:          "88Z",
+		VersionMajor: "F000",
+		BuildType:    "B",
+		VersionMinor: "00",
+		TimeStamp:    "1811070914",
+		Offset:       0x20,
+	}
+	if *id != want {
+		t.Errorf("got %+v, want %+v", *id, want)
+	}
+
+	for _, buf := range [][]byte{
+		bytes.Repeat([]byte{0xff}, 0x20),
+		append(append([]byte{}, BIOSIDSignature...), 0, 0),
+		BIOSIDSignature,
+	} {
+		if id, err := FindBIOSID(buf); err == nil {
+			t.Errorf("got BIOS ID %q from %q, want an error", id.ID, buf)
+		}
+	}
+}
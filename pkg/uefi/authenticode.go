@@ -0,0 +1,128 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// Authenticode digests, as described in "Windows Authenticode Portable
+// Executable Signature Format". They are what db and dbx hold for unsigned
+// images.
+
+const (
+	peOffsetOffset    = 0x3c
+	coffHeaderLen     = 20
+	peMagic32         = 0x10b
+	peMagic64         = 0x20b
+	peChecksumOffset  = 64
+	peSectionLen      = 40
+	peSecurityDir     = 4
+	peDataDirEntryLen = 8
+	teHeaderLen       = 40
+)
+
+var (
+	peSignature = []byte("PE\x00\x00")
+	mzSignature = []byte("MZ")
+	teSignature = []byte("VZ")
+)
+
+// AuthenticodeDigest returns the Authenticode digest of the PE32(+) or TE
+// image in buf computed with h.
+//
+// TE images have neither checksum nor certificate table and are not
+// signed, so their digest covers the whole header followed by the sections
+// in file order, which is the image itself.
+func AuthenticodeDigest(buf []byte, h hash.Hash) ([]byte, error) {
+	h.Reset()
+	switch {
+	case len(buf) >= teHeaderLen && buf[0] == teSignature[0] && buf[1] == teSignature[1]:
+		h.Write(buf)
+		return h.Sum(nil), nil
+	case len(buf) > peOffsetOffset+4 && buf[0] == mzSignature[0] && buf[1] == mzSignature[1]:
+		if err := hashPE(buf, h); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+	return nil, errors.New("neither a PE32 nor a TE image")
+}
+
+func hashPE(buf []byte, h hash.Hash) error {
+	u16 := func(o int) int { return int(binary.LittleEndian.Uint16(buf[o:])) }
+	u32 := func(o int) int { return int(binary.LittleEndian.Uint32(buf[o:])) }
+
+	pe := u32(peOffsetOffset)
+	opt := pe + len(peSignature) + coffHeaderLen
+	if pe < 0 || opt+peChecksumOffset+4 > len(buf) || string(buf[pe:pe+4]) != string(peSignature) {
+		return errors.New("no PE signature")
+	}
+	nSections := u16(pe + 4 + 2)
+	optLen := u16(pe + 4 + 16)
+
+	var rvaCountOffset int
+	switch magic := u16(opt); magic {
+	case peMagic32:
+		rvaCountOffset = 92
+	case peMagic64:
+		rvaCountOffset = 108
+	default:
+		return fmt.Errorf("unknown optional header magic %#x", magic)
+	}
+	sizeOfHeaders := u32(opt + 60)
+	sectionTable := opt + optLen
+	if sizeOfHeaders > len(buf) || rvaCountOffset+4 > optLen || sectionTable+nSections*peSectionLen > len(buf) {
+		return errors.New("PE headers out of the image")
+	}
+
+	checksum := opt + peChecksumOffset
+	if sizeOfHeaders < checksum+4 {
+		return fmt.Errorf("size of headers %#x ends before the checksum", sizeOfHeaders)
+	}
+	h.Write(buf[:checksum])
+	// Without a security directory, only the checksum is skipped.
+	certDir := opt + rvaCountOffset + 4 + peSecurityDir*peDataDirEntryLen
+	var certSize int
+	if u32(opt+rvaCountOffset) > peSecurityDir && certDir+peDataDirEntryLen <= sectionTable {
+		if sizeOfHeaders < certDir+peDataDirEntryLen {
+			return fmt.Errorf("size of headers %#x ends before the certificate table entry", sizeOfHeaders)
+		}
+		h.Write(buf[checksum+4 : certDir])
+		h.Write(buf[certDir+peDataDirEntryLen : sizeOfHeaders])
+		certSize = u32(certDir + 4)
+	} else {
+		h.Write(buf[checksum+4 : sizeOfHeaders])
+	}
+
+	type rawData struct{ offset, size int }
+	var sections []rawData
+	for i := 0; i < nSections; i++ {
+		s := sectionTable + i*peSectionLen
+		if r := (rawData{offset: u32(s + 20), size: u32(s + 16)}); r.size != 0 {
+			sections = append(sections, r)
+		}
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+	sumOfBytes := sizeOfHeaders
+	for _, s := range sections {
+		if s.offset < 0 || s.size < 0 || s.offset+s.size > len(buf) {
+			return fmt.Errorf("section data at %#x of %#x bytes out of the image", s.offset, s.size)
+		}
+		h.Write(buf[s.offset : s.offset+s.size])
+		sumOfBytes += s.size
+	}
+
+	// Data past the sections is hashed, except for the certificate table
+	// at the end of the file.
+	if end := len(buf) - certSize; sumOfBytes < end {
+		h.Write(buf[sumOfBytes:end])
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+// testPE returns a PE32+ image with two sections, listed out of file order,
+// and the certificate table appended when cert is not nil.
+func testPE(cert []byte) []byte {
+	const (
+		pe  = 0x40
+		opt = pe + 4 + coffHeaderLen
+	)
+	buf := make([]byte, 0x400)
+	copy(buf, mzSignature)
+	binary.LittleEndian.PutUint32(buf[peOffsetOffset:], pe)
+	copy(buf[pe:], peSignature)
+	binary.LittleEndian.PutUint16(buf[pe+4+2:], 2)
+	binary.LittleEndian.PutUint16(buf[pe+4+16:], 112+16*8)
+	binary.LittleEndian.PutUint16(buf[opt:], peMagic64)
+	binary.LittleEndian.PutUint32(buf[opt+60:], 0x200)
+	binary.LittleEndian.PutUint32(buf[opt+108:], 16)
+	sections := opt + 112 + 16*8
+	binary.LittleEndian.PutUint32(buf[sections+16:], 0x100)
+	binary.LittleEndian.PutUint32(buf[sections+20:], 0x300)
+	binary.LittleEndian.PutUint32(buf[sections+peSectionLen+16:], 0x100)
+	binary.LittleEndian.PutUint32(buf[sections+peSectionLen+20:], 0x200)
+	for i := 0x200; i < len(buf); i++ {
+		buf[i] = byte(i)
+	}
+	if cert != nil {
+		certDir := opt + 112 + peSecurityDir*peDataDirEntryLen
+		binary.LittleEndian.PutUint32(buf[certDir:], uint32(len(buf)))
+		binary.LittleEndian.PutUint32(buf[certDir+4:], uint32(len(cert)))
+		buf = append(buf, cert...)
+	}
+	return buf
+}
+
+func TestAuthenticodeDigest(t *testing.T) {
+	img := testPE(nil)
+	got, err := AuthenticodeDigest(img, sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Headers without checksum and security directory, then the sections
+	// in file order.
+	const opt = 0x40 + 4 + coffHeaderLen
+	certDir := opt + 112 + peSecurityDir*peDataDirEntryLen
+	h := sha256.New()
+	h.Write(img[:opt+peChecksumOffset])
+	h.Write(img[opt+peChecksumOffset+4 : certDir])
+	h.Write(img[certDir+peDataDirEntryLen : 0x400])
+	if want := h.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("AuthenticodeDigest: got %x, want %x", got, want)
+	}
+
+	// Neither the checksum nor the signature are part of the digest.
+	signed := testPE([]byte("signature"))
+	binary.LittleEndian.PutUint32(signed[opt+peChecksumOffset:], 0x1234)
+	if d, err := AuthenticodeDigest(signed, sha256.New()); err != nil || !bytes.Equal(d, got) {
+		t.Errorf("AuthenticodeDigest of signed image: got %x, %v, want %x", d, err, got)
+	}
+
+	// Data past the sections is.
+	if d, _ := AuthenticodeDigest(append(img, 0), sha256.New()); bytes.Equal(d, got) {
+		t.Errorf("AuthenticodeDigest ignores data after the sections")
+	}
+}
+
+func TestAuthenticodeDigestTE(t *testing.T) {
+	te := append([]byte("VZ"), make([]byte, 0x100)...)
+	got, err := AuthenticodeDigest(te, sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(te); !bytes.Equal(got, want[:]) {
+		t.Errorf("AuthenticodeDigest: got %x, want %x", got, want)
+	}
+}
+
+func TestAuthenticodeDigestErrors(t *testing.T) {
+	truncated := testPE(nil)[:0x300]
+	badMagic := testPE(nil)
+	badMagic[0x40+4+coffHeaderLen] = 0
+	// Headers ending before the checksum, or before the certificate table
+	// entry of a signed image.
+	shortHeaders := testPE(nil)
+	binary.LittleEndian.PutUint32(shortHeaders[0x40+4+coffHeaderLen+60:], 0x10)
+	shortSignedHeaders := testPE([]byte("certificate"))
+	binary.LittleEndian.PutUint32(shortSignedHeaders[0x40+4+coffHeaderLen+60:], 0xa0)
+	for name, buf := range map[string][]byte{
+		"not an image":         []byte("banana"),
+		"no PE header":         append([]byte("MZ"), make([]byte, 0x40)...),
+		"bad magic":            badMagic,
+		"truncated":            truncated,
+		"short headers":        shortHeaders,
+		"short signed headers": shortSignedHeaders,
+	} {
+		if _, err := AuthenticodeDigest(buf, sha256.New()); err == nil {
+			t.Errorf("%s: got nil, want error", name)
+		}
+	}
+}
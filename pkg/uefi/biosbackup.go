@@ -0,0 +1,161 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"fmt"
+)
+
+// Dual BIOS boards, like those of Gigabyte, hold a backup of the BIOS in the
+// image: the lower half of a region twice as large as needed, or the
+// padding below the volumes. The copy is the same sequence of volumes as
+// the one at the top of the region, which the CPU boots from.
+
+// BIOSBackup is a backup copy of the volumes at the top of the BIOS region,
+// from its first volume to its last one.
+type BIOSBackup struct {
+	buf []byte
+	// Offset in the BIOS region. The offsets of the elements are in the
+	// backup.
+	Offset uint64
+	// PrimaryOffset is the offset in the BIOS region of the copy booted
+	// from, of the length of the backup.
+	PrimaryOffset uint64
+	Elements      []*TypedFirmware `json:",omitempty"`
+
+	// Metadata for extraction and recovery
+	ExtractPath string
+}
+
+// NewBIOSBackup parses buf as the backup at offset in the BIOS region of
+// the copy at primaryOffset.
+func NewBIOSBackup(buf []byte, offset, primaryOffset uint64) (*BIOSBackup, error) {
+	return newBIOSBackup(context.Background(), buf, offset, primaryOffset)
+}
+
+func newBIOSBackup(ctx context.Context, buf []byte, offset, primaryOffset uint64) (*BIOSBackup, error) {
+	if primaryOffset < offset+uint64(len(buf)) {
+		return nil, fmt.Errorf("BIOS backup at %#x of %#x bytes overlaps its primary copy at %#x", offset, len(buf), primaryOffset)
+	}
+	r, err := newBIOSRegion(ctx, buf, nil, RegionTypeBIOS)
+	if err != nil {
+		return nil, err
+	}
+	br := r.(*BIOSRegion)
+	return &BIOSBackup{buf: br.buf, Offset: offset, PrimaryOffset: primaryOffset, Elements: br.Elements}, nil
+}
+
+// Length returns the length of the backup, and of its primary copy.
+func (b *BIOSBackup) Length() uint64 {
+	return uint64(len(b.buf))
+}
+
+// fileGUIDs returns the GUIDs of the files of fv, in order.
+func fileGUIDs(fv *FirmwareVolume) string {
+	s := make([]byte, 0, len(fv.Files)*16)
+	for _, f := range fv.Files {
+		s = append(s, f.Header.GUID[:]...)
+	}
+	return string(s)
+}
+
+// isBIOSCopy reports whether the volumes backup are a copy of the volumes
+// primary: the same types, names and lengths at the same distances, and
+// the same files in one at least, which the copies of NVRAM volumes lack.
+func isBIOSCopy(backup, primary []*FirmwareVolume) bool {
+	var files bool
+	for i, p := range primary {
+		b := backup[i]
+		if b.FileSystemGUID != p.FileSystemGUID || b.FVName != p.FVName || b.Length != p.Length ||
+			p.FVOffset-b.FVOffset != primary[0].FVOffset-backup[0].FVOffset {
+			return false
+		}
+		if len(p.Files) != 0 && fileGUIDs(b) == fileGUIDs(p) {
+			files = true
+		}
+	}
+	return files
+}
+
+// findBackup moves the largest backup copy of the volumes at the top of
+// the region, if any, to a BIOSBackup element.
+func (br *BIOSRegion) findBackup() {
+	var (
+		fvs     []*FirmwareVolume
+		indexes []int
+	)
+	for i, e := range br.Elements {
+		if fv, ok := e.Value.(*FirmwareVolume); ok {
+			fvs = append(fvs, fv)
+			indexes = append(indexes, i)
+		}
+	}
+	m := len(fvs)
+	for n := m / 2; n >= 1; n-- {
+		primary := fvs[m-n:]
+		for k := m - 2*n; k >= 0; k-- {
+			if !isBIOSCopy(fvs[k:k+n], primary) {
+				continue
+			}
+			first, last := indexes[k], indexes[k+n-1]
+			start, end := fvs[k].FVOffset, fvs[k+n-1].FVOffset+fvs[k+n-1].Length
+			b := &BIOSBackup{
+				buf:           copyBuf(br.buf[start:end]),
+				Offset:        start,
+				PrimaryOffset: primary[0].FVOffset,
+				Elements:      append([]*TypedFirmware{}, br.Elements[first:last+1]...),
+			}
+			for _, e := range b.Elements {
+				switch f := e.Value.(type) {
+				case *FirmwareVolume:
+					f.FVOffset -= start
+				case *BIOSPadding:
+					f.Offset -= start
+				}
+			}
+			br.Elements = append(append(br.Elements[:first:first], MakeTyped(b)), br.Elements[last+1:]...)
+			return
+		}
+	}
+}
+
+// Backup returns the backup copy of the BIOS region, or nil if there is
+// none.
+func (br *BIOSRegion) Backup() *BIOSBackup {
+	for _, e := range br.Elements {
+		if b, ok := e.Value.(*BIOSBackup); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (b *BIOSBackup) Buf() []byte {
+	return b.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (b *BIOSBackup) SetBuf(buf []byte) {
+	b.buf = buf
+}
+
+// Apply calls the visitor on the BIOSBackup.
+func (b *BIOSBackup) Apply(v Visitor) error {
+	return visit(v, b)
+}
+
+// ApplyChildren calls the visitor on each child node of BIOSBackup.
+func (b *BIOSBackup) ApplyChildren(v Visitor) error {
+	for _, e := range b.Elements {
+		if err := e.Value.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
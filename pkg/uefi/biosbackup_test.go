@@ -0,0 +1,98 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestBIOSBackup(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secFV, err := os.ReadFile("../../integration/roms/ovmfSECFV.fv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The SEC volume of the fixture is older than the one of the image,
+	// as backups may be.
+	embedded := append(append(bytes.Repeat([]byte{0xff}, 0x10000), secFV...), image...)
+	for _, test := range []struct {
+		name          string
+		buf           []byte
+		offset        uint64
+		primaryOffset uint64
+		length        uint64
+		volumes       int
+		same          bool
+	}{
+		{"dual", append(append([]byte{}, image...), image...), 0, uint64(len(image)), uint64(len(image)), 3, true},
+		{"in padding", embedded, 0x10000, uint64(len(embedded) - len(secFV)), uint64(len(secFV)), 1, false},
+		{"none", image, 0, 0, 0, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := Parse(test.buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := f.(*BIOSRegion).Backup()
+			if test.volumes == 0 {
+				if b != nil {
+					t.Fatalf("got a backup at %#x, want none", b.Offset)
+				}
+				return
+			}
+			if b == nil {
+				t.Fatal("got no backup")
+			}
+			if b.Offset != test.offset || b.PrimaryOffset != test.primaryOffset || b.Length() != test.length {
+				t.Errorf("got a backup at %#x of %#x bytes of the copy at %#x, want one at %#x of %#x bytes of the copy at %#x",
+					b.Offset, b.Length(), b.PrimaryOffset, test.offset, test.length, test.primaryOffset)
+			}
+			var volumes int
+			var offset uint64
+			for _, e := range b.Elements {
+				if fv, ok := e.Value.(*FirmwareVolume); ok {
+					volumes++
+					if fv.FVOffset != offset {
+						t.Errorf("got a volume at %#x of the backup, want %#x", fv.FVOffset, offset)
+					}
+				}
+				offset += uint64(len(e.Value.Buf()))
+			}
+			if volumes != test.volumes {
+				t.Errorf("got %d volumes in the backup, want %d", volumes, test.volumes)
+			}
+			if same := bytes.Equal(b.Buf(), test.buf[b.PrimaryOffset:b.PrimaryOffset+b.Length()]); same != test.same {
+				t.Errorf("got a backup the same as its primary copy %v, want %v", same, test.same)
+			}
+
+			j, err := MarshalFirmware(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err = UnmarshalFirmware(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := f.(*BIOSRegion).Backup(); b == nil || b.PrimaryOffset != test.primaryOffset {
+				t.Errorf("got backup %+v from JSON, want the one parsed", b)
+			}
+		})
+	}
+}
+
+func TestNewBIOSBackupOverlap(t *testing.T) {
+	secFV, err := os.ReadFile("../../integration/roms/ovmfSECFV.fv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBIOSBackup(secFV, 0x1000, 0x2000); err == nil {
+		t.Errorf("got no error for a backup overlapping its primary copy")
+	}
+}
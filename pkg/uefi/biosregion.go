@@ -5,7 +5,11 @@
 package uefi
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"sync"
 )
 
 // BIOSPadding holds the padding in between firmware volumes
@@ -14,6 +18,8 @@ import (
 type BIOSPadding struct {
 	buf    []byte
 	Offset uint64
+	// EC firmware found in the padding
+	EC *ECFirmware `json:",omitempty"`
 
 	// Metadata
 	ExtractPath string
@@ -23,6 +29,7 @@ type BIOSPadding struct {
 // object.
 func NewBIOSPadding(buf []byte, offset uint64) (*BIOSPadding, error) {
 	bp := &BIOSPadding{buf: buf, Offset: offset}
+	bp.EC = FindECFirmware(buf)
 	return bp, nil
 }
 
@@ -38,12 +45,15 @@ func (bp *BIOSPadding) SetBuf(buf []byte) {
 
 // Apply a visitor to the BIOSPadding.
 func (bp *BIOSPadding) Apply(v Visitor) error {
-	return v.Visit(bp)
+	return visit(v, bp)
 }
 
 // ApplyChildren applies a visitor to all the direct children of the BIOSPadding
 func (bp *BIOSPadding) ApplyChildren(v Visitor) error {
-	return nil
+	if bp.EC == nil {
+		return nil
+	}
+	return bp.EC.Apply(v)
 }
 
 // BIOSRegion represents the Bios Region in the firmware.
@@ -79,19 +89,26 @@ func (br *BIOSRegion) FlashRegion() (fr *FlashRegion) {
 // NewBIOSRegion parses a sequence of bytes and returns a Region
 // object, if a valid one is passed, or an error. It also points to the
 // Region struct uncovered in the ifd.
-func NewBIOSRegion(buf []byte, r *FlashRegion, _ FlashRegionType) (Region, error) {
+func NewBIOSRegion(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error) {
+	return newBIOSRegion(context.Background(), buf, r, rt)
+}
+
+func newBIOSRegion(ctx context.Context, buf []byte, r *FlashRegion, _ FlashRegionType) (Region, error) {
 	br := BIOSRegion{FRegion: r, Length: uint64(len(buf)),
 		RegionType: RegionTypeBIOS}
 	var absOffset uint64
 
 	// Copy the buffer
-	if ReadOnly {
-		br.buf = buf
-	} else {
-		br.buf = make([]byte, len(buf))
-		copy(br.buf, buf)
+	br.buf = copyBuf(buf)
+
+	// Find the volumes from their headers first, so they can be parsed
+	// concurrently.
+	type volume struct {
+		element int
+		data    []byte
+		offset  uint64
 	}
-
+	var volumes []volume
 	for {
 		offset := FindFirmwareVolumeOffset(buf)
 		if offset < 0 {
@@ -115,15 +132,69 @@ func NewBIOSRegion(buf []byte, r *FlashRegion, _ FlashRegionType) (Region, error
 			}
 			br.Elements = append(br.Elements, MakeTyped(bp))
 		}
-		absOffset += uint64(offset)                                  // Find start of volume relative to bios region.
-		fv, err := NewFirmwareVolume(buf[offset:], absOffset, false) // False as top level FVs are not resizable
+		absOffset += uint64(offset) // Find start of volume relative to bios region.
+		volumes = append(volumes, volume{element: len(br.Elements), data: buf[offset:], offset: absOffset})
+		br.Elements = append(br.Elements, nil)
+		if len(buf[offset:]) < FirmwareVolumeMinSize {
+			// Parsing the volume reports the error.
+			break
+		}
+		length := binary.LittleEndian.Uint64(buf[offset+32:])
+		if length == 0 {
+			return nil, fmt.Errorf("firmware volume at %#x has a length of 0", absOffset)
+		}
+		if length > uint64(len(buf[offset:])) {
+			// Parsing the volume reports the error.
+			break
+		}
+		// Set the default erase polarity to the one of the first volume,
+		// before the volumes are parsed concurrently and only read it.
+		fv := FirmwareVolume{}
+		fv.Attributes = binary.LittleEndian.Uint32(buf[offset+44:])
+		setDefaultErasePolarity(fv.GetErasePolarity())
+		absOffset += length
+		buf = buf[uint64(offset)+length:]
+	}
+
+	parse := func(ctx context.Context, v volume) error {
+		fv, err := newFirmwareVolume(ctx, v.data, v.offset, false) // False as top level FVs are not resizable
+		if err != nil {
+			return err
+		}
+		br.Elements[v.element] = MakeTyped(fv)
+		return nil
+	}
+	workers := parseOptionsOf(ctx).workers()
+	if workers <= 1 || len(volumes) <= 1 {
+		for _, v := range volumes {
+			if err := parse(ctx, v); err != nil {
+				return nil, err
+			}
+		}
+		br.findBackup()
+		return &br, nil
+	}
+
+	errs := make([]error, len(volumes))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, v := range volumes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, v volume) {
+			defer wg.Done()
+			errs[i] = parse(forkParse(ctx), v)
+			<-sem
+		}(i, v)
+	}
+	wg.Wait()
+	// Report the error of the first volume, as if parsed in order.
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
-		absOffset += fv.Length
-		buf = buf[uint64(offset)+fv.Length:]
-		br.Elements = append(br.Elements, MakeTyped(fv))
 	}
+	br.findBackup()
 	return &br, nil
 }
 
@@ -141,7 +212,7 @@ func (br *BIOSRegion) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the BIOSRegion.
 func (br *BIOSRegion) Apply(v Visitor) error {
-	return v.Visit(br)
+	return visit(v, br)
 }
 
 // ApplyChildren calls the visitor on each child node of BIOSRegion.
@@ -0,0 +1,54 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestParseWorkers(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, n := range []int{1, 2, 8} {
+		ctx := WithParseOptions(context.Background(), &ParseOptions{Workers: n})
+		f, err := ParseContext(ctx, image)
+		if err != nil {
+			t.Fatalf("%d workers: %v", n, err)
+		}
+		got, err := MarshalFirmware(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == nil {
+			want = got
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%d workers: parsed differently than one after the other", n)
+		}
+	}
+}
+
+func BenchmarkParseWorkers(b *testing.B) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			ctx := WithParseOptions(context.Background(), &ParseOptions{Workers: n})
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseContext(ctx, image); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+)
+
+// Insyde and Phoenix images store some firmware volumes compressed in raw
+// files and raw sections rather than in GUID-defined sections, at times
+// after a vendor header of a few bytes. Without looking into them, parsing
+// stops at an opaque blob holding most of the image.
+
+// CompressedVolumeMaxOffset is how far into a raw file or section the
+// compressed stream is looked for, past the vendor header.
+const CompressedVolumeMaxOffset = 0x100
+
+const (
+	lzmaHeaderLength = 13
+	// lzmaMaxProperties is the largest properties byte, (pb*5+lp)*9+lc.
+	lzmaMaxProperties = 9 * 5 * 5
+	efiHeaderLength   = 8
+	// efiMaxRatio bounds the decoded size of Tiano and EFI streams: a bit
+	// codes a match of up to 256 bytes at best.
+	efiMaxRatio = 8 * 256
+)
+
+// CompressedVolume is a sequence of firmware volumes compressed as a raw
+// LZMA, Tiano or EFI stream in the body of a raw file or section.
+type CompressedVolume struct {
+	buf []byte
+	// Offset in the section, or in the body of the file, past the vendor
+	// header, which stays in the parent.
+	Offset uint64
+	// Length is the room of the stream, to the end of the parent. Streams
+	// encoded again must fit in it.
+	Length uint64
+	// Compression and Variant are the compression of the stream, LZMA,
+	// Tiano or EFI, and its variant to encode alike again.
+	Compression string
+	Variant     string `json:",omitempty"`
+	// OriginalSHA256 is the hash of the volumes decoded from the stream,
+	// which Assemble keeps as is as long as they do not change.
+	OriginalSHA256 string `json:",omitempty"`
+	Volumes        []*FirmwareVolume
+
+	// Metadata for extraction and recovery
+	ExtractPath string
+}
+
+// OriginalSum returns the hash of the compression and of the decoded
+// volumes which OriginalSHA256 holds.
+func (c *CompressedVolume) OriginalSum(decoded []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Compression))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Variant))
+	h.Write([]byte{0})
+	h.Write(decoded)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Compressor returns the compressor encoding the volumes alike again, with
+// the options o.
+func (c *CompressedVolume) Compressor(o compression.Options) (compression.Compressor, error) {
+	switch c.Compression {
+	case "LZMA":
+		return o.CompressorFromGUIDVariant(&compression.LZMAGUID, c.Variant)
+	case "Tiano", "EFI":
+		variant := c.Variant
+		if variant == "" {
+			variant = c.Compression
+		}
+		return compression.ParseEFIVariant(variant)
+	}
+	return nil, fmt.Errorf("unknown compression %q of compressed volume", c.Compression)
+}
+
+// isLZMAHeader checks if buf starts with the header of an LZMA stream
+// decoding to a firmware volume: valid properties, a dictionary size of
+// 2^n or 2^n+2^(n-1) bytes, as LZMA encoders write, and a known size.
+func isLZMAHeader(buf []byte) bool {
+	if len(buf) < lzmaHeaderLength || buf[0] >= lzmaMaxProperties {
+		return false
+	}
+	dictCap := binary.LittleEndian.Uint32(buf[1:])
+	if dictCap < 1<<12 || dictCap > 1<<30 {
+		return false
+	}
+	if hi := uint32(1) << (bits.Len32(dictCap) - 1); dictCap != hi && dictCap != hi|hi>>1 {
+		return false
+	}
+	size := binary.LittleEndian.Uint64(buf[5:])
+	return size >= FirmwareVolumeMinSize && size != ^uint64(0)
+}
+
+// isEFIHeader checks if buf starts with the header of a Tiano or EFI
+// stream decoding to a firmware volume: the sizes of the stream, within
+// buf, and of the decoded data, which a byte of the stream expands to at
+// most efiMaxRatio bytes of.
+func isEFIHeader(buf []byte) bool {
+	if len(buf) < efiHeaderLength {
+		return false
+	}
+	compSize := uint64(binary.LittleEndian.Uint32(buf))
+	origSize := uint64(binary.LittleEndian.Uint32(buf[4:]))
+	return compSize != 0 && compSize <= uint64(len(buf)-efiHeaderLength) &&
+		origSize >= FirmwareVolumeMinSize && origSize <= compSize*efiMaxRatio
+}
+
+// parseVolumes parses data as a sequence of firmware volumes, as long as
+// it is nothing else.
+func parseVolumes(ctx context.Context, data []byte) ([]*FirmwareVolume, error) {
+	var fvs []*FirmwareVolume
+	for o := uint64(0); o < uint64(len(data)); {
+		if uint64(len(data))-o < FirmwareVolumeMinSize || !bytes.Equal(data[o+40:o+44], []byte("_FVH")) {
+			return nil, fmt.Errorf("no firmware volume at %#x", o)
+		}
+		fv, err := newFirmwareVolume(ctx, data[o:], o, true)
+		if err != nil {
+			return nil, err
+		}
+		if fv.Length == 0 {
+			return nil, fmt.Errorf("empty firmware volume at %#x", o)
+		}
+		fvs = append(fvs, fv)
+		o += fv.Length
+	}
+	return fvs, nil
+}
+
+// findCompressedVolume looks for a stream decoding to firmware volumes in
+// the first CompressedVolumeMaxOffset bytes of buf, the body of a raw file
+// or section at offset in the parent. It returns nil if there is none, and
+// an error only if a parse limit is exceeded parsing the volumes.
+func findCompressedVolume(ctx context.Context, buf []byte, offset uint64) (*CompressedVolume, error) {
+	if DisableDecompression {
+		return nil, nil
+	}
+	for o := 0; o <= CompressedVolumeMaxOffset && o+efiHeaderLength < len(buf); o += 4 {
+		var compressors []compression.Compressor
+		if isLZMAHeader(buf[o:]) {
+			compressors = append(compressors, &compression.LZMA{})
+		}
+		if isEFIHeader(buf[o:]) {
+			compressors = append(compressors, &compression.EFI{Tiano: true}, &compression.EFI{})
+		}
+		for _, c := range compressors {
+			// The sizes in the headers of false positives may exceed
+			// the limits, which only bound the streams accepted.
+			data, err := decompress(ctx, c, buf[o:])
+			if err != nil {
+				continue
+			}
+			fvs, err := parseVolumes(ctx, data)
+			if errors.Is(err, ErrParseLimit) {
+				return nil, err
+			}
+			if err != nil {
+				continue
+			}
+			cv := &CompressedVolume{
+				buf:         copyBuf(buf[o:]),
+				Offset:      offset + uint64(o),
+				Length:      uint64(len(buf) - o),
+				Compression: c.Name(),
+				Volumes:     fvs,
+			}
+			if efi, ok := c.(*compression.EFI); ok {
+				cv.Variant = efi.Variant()
+			} else {
+				cv.Variant = compression.DetectVariant(c, buf[o:])
+			}
+			cv.OriginalSHA256 = cv.OriginalSum(data)
+			return cv, nil
+		}
+	}
+	return nil, nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (c *CompressedVolume) Buf() []byte {
+	return c.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (c *CompressedVolume) SetBuf(buf []byte) {
+	c.buf = buf
+}
+
+// Apply calls the visitor on the CompressedVolume.
+func (c *CompressedVolume) Apply(v Visitor) error {
+	return visit(v, c)
+}
+
+// ApplyChildren calls the visitor on each child node of CompressedVolume.
+func (c *CompressedVolume) ApplyChildren(v Visitor) error {
+	for _, fv := range c.Volumes {
+		if err := fv.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// vendorBlob returns sampleFV compressed by c after a vendor header of
+// header bytes, padded with 0xff.
+func vendorBlob(t *testing.T, c compression.Compressor, header int) []byte {
+	t.Helper()
+	stream, err := c.Encode(sampleFV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append(bytes.Repeat([]byte{'$'}, header), stream...)
+	return append(blob, 0xff, 0xff, 0xff, 0xff)
+}
+
+func TestCompressedVolume(t *testing.T) {
+	g := *guid.MustParse("B7E7E4D2-2A8F-4C3B-9E6E-3C5E1A0F1D03")
+	lzma := vendorBlob(t, &compression.LZMA{}, 0x10)
+	corrupt := append([]byte{}, lzma...)
+	copy(corrupt[0x30:], bytes.Repeat([]byte{0x55}, 0x40))
+	notFV, err := (&compression.LZMA{}).Encode(bytes.Repeat([]byte{0xaa}, 0x100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name        string
+		blob        []byte
+		offset      uint64
+		compression string
+	}{
+		{"LZMA", vendorBlob(t, &compression.LZMA{}, 0), 0, "LZMA"},
+		{"LZMA past a vendor header", lzma, 0x10, "LZMA"},
+		{"Tiano", vendorBlob(t, &compression.EFI{Tiano: true}, 8), 8, "Tiano"},
+		{"EFI", vendorBlob(t, &compression.EFI{}, 4), 4, "EFI"},
+		{"corrupt", corrupt, 0, ""},
+		{"not a volume", notFV, 0, ""},
+		{"beyond the vendor header", vendorBlob(t, &compression.LZMA{}, CompressedVolumeMaxOffset+4), 0, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := CreateSection(SectionTypeRaw, test.blob, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.GenSecHeader(); err != nil {
+				t.Fatal(err)
+			}
+			s, err = NewSection(s.Buf(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := newTypedFile(t, FVFileTypeRaw, g, test.blob)
+			for _, cv := range []*CompressedVolume{s.CompressedVolume, f.CompressedVolume} {
+				if test.compression == "" {
+					if cv != nil {
+						t.Errorf("got a %s compressed volume, want none", cv.Compression)
+					}
+					continue
+				}
+				if cv == nil {
+					t.Fatalf("got no compressed volume, want a %s one", test.compression)
+				}
+				if cv.Compression != test.compression || len(cv.Volumes) != 1 {
+					t.Errorf("got %d %s compressed volumes, want 1 %s one", len(cv.Volumes), cv.Compression, test.compression)
+				}
+				if len(cv.Volumes) != 0 && !bytes.Equal(cv.Volumes[0].Buf(), sampleFV) {
+					t.Errorf("got another volume than the one compressed")
+				}
+				if uint64(len(cv.Buf())) != cv.Length || cv.Length != uint64(len(test.blob))-test.offset {
+					t.Errorf("got a stream of %#x bytes with room for %#x, want %#x", len(cv.Buf()), cv.Length, uint64(len(test.blob))-test.offset)
+				}
+			}
+			if test.compression != "" {
+				if want := s.HeaderLen() + test.offset; s.CompressedVolume.Offset != want {
+					t.Errorf("got a stream at %#x of the section, want %#x", s.CompressedVolume.Offset, want)
+				}
+				if f.CompressedVolume.Offset != test.offset {
+					t.Errorf("got a stream at %#x of the file body, want %#x", f.CompressedVolume.Offset, test.offset)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressedVolumeDisabled(t *testing.T) {
+	defer func(d bool) { DisableDecompression = d }(DisableDecompression)
+	DisableDecompression = true
+	f := newTypedFile(t, FVFileTypeRaw, *guid.MustParse("B7E7E4D2-2A8F-4C3B-9E6E-3C5E1A0F1D04"), vendorBlob(t, &compression.LZMA{}, 0))
+	if f.CompressedVolume != nil {
+		t.Errorf("got a compressed volume with decompression disabled")
+	}
+}
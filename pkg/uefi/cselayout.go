@@ -0,0 +1,253 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// CSE layout table parsing, for the IFWI 1.6, 1.7 and 2.0 layouts of the
+// ME region of recent platforms (TGL+). These regions do not start with an
+// $FPT but with a layout table pointing to the data partition, holding the
+// $FPT, and to the boot partitions, each described by a Boot Partition
+// Descriptor Table (BPDT).
+//
+// Layout informations from coreboot's util/cbfstool/ifwitool.c and
+// util/cse_fpt.
+
+const (
+	// CSELayoutROMBypassLength is the size of the ROM bypass vector
+	// starting a CSE layout table.
+	CSELayoutROMBypassLength = 16
+	// CSELayoutTable17HeaderLength is the size of the header following the
+	// ROM bypass vector in a 1.7 layout table.
+	CSELayoutTable17HeaderLength = 8
+	// CSELayoutPartitionCount is the number of partitions of a layout
+	// table: data, BP1 to BP5, temporary pages and flog.
+	CSELayoutPartitionCount = 8
+
+	// BPDTSignature is the signature of a Boot Partition Descriptor Table.
+	BPDTSignature = 0x000055AA
+	// BPDTHeaderLength is the size of a BPDT header.
+	BPDTHeaderLength = 24
+	// BPDTEntryLength is the size of a BPDT entry.
+	BPDTEntryLength = 12
+)
+
+var csePartitionNames = [CSELayoutPartitionCount]string{"Data", "BP1", "BP2", "BP3", "BP4", "BP5", "TempPages", "Flog"}
+
+// CSEPartition is a partition of a CSE layout table.
+type CSEPartition struct {
+	Name   string
+	Offset uint32
+	Size   uint32
+	// BPDT is the descriptor table of a boot partition, if any.
+	BPDT *BPDT `json:",omitempty"`
+}
+
+// CSELayout is a CSE layout table.
+type CSELayout struct {
+	// Holds the raw buffer
+	buf []byte
+
+	// Version is "1.6" or "1.7", IFWI 2.0 images use the 1.7 table.
+	Version    string
+	ROMBypass  [CSELayoutROMBypassLength]byte
+	Size       uint16 `json:",omitempty"`
+	Flags      uint8  `json:",omitempty"`
+	Checksum   uint32 `json:",omitempty"`
+	Partitions []CSEPartition
+}
+
+// BPDTHeader is the header of a Boot Partition Descriptor Table.
+type BPDTHeader struct {
+	Signature       uint32
+	DescriptorCount uint16
+	Version         uint16
+	XorRedundant    uint32
+	IFWIVersion     uint32
+	FitToolVersion  [4]uint16
+}
+
+// BPDTEntry is an entry of a Boot Partition Descriptor Table.
+type BPDTEntry struct {
+	Type  BPDTEntryType
+	Flags uint16
+	// Offset is relative to the start of the BPDT.
+	Offset uint32
+	Size   uint32
+}
+
+// BPDT is a Boot Partition Descriptor Table.
+type BPDT struct {
+	Header  BPDTHeader
+	Entries []BPDTEntry
+}
+
+// BPDTEntryType is the type of a BPDT entry.
+type BPDTEntryType uint16
+
+var bpdtEntryTypeNames = map[BPDTEntryType]string{
+	0:  "SMIP",
+	1:  "RBEP",
+	2:  "FTPR",
+	3:  "UCOD",
+	4:  "IBBP",
+	5:  "S-BPDT",
+	6:  "OBBP",
+	7:  "NFTP",
+	8:  "ISHP",
+	9:  "DLMP",
+	10: "IFP_OVERRIDE",
+	11: "DEBUG_TOKENS",
+	12: "UFS_PHY",
+	13: "UFS_GPP",
+	14: "PMCP",
+	15: "IUNP",
+	16: "NVM_CONFIG",
+	17: "UEP",
+	18: "UFS_RATE_B",
+}
+
+func (t BPDTEntryType) String() string {
+	if s, ok := bpdtEntryTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown (%d)", uint16(t))
+}
+
+// OffsetIsValid returns true if the entry has content in the boot
+// partition.
+func (e BPDTEntry) OffsetIsValid() bool {
+	return e.Size != 0 && e.Offset != 0 && e.Offset != 0xffffffff
+}
+
+// NewBPDT parses the Boot Partition Descriptor Table starting buf.
+func NewBPDT(buf []byte) (*BPDT, error) {
+	var b BPDT
+	r := bytes.NewReader(buf)
+	if err := binary.Read(r, binary.LittleEndian, &b.Header); err != nil {
+		return nil, err
+	}
+	if b.Header.Signature != BPDTSignature {
+		return nil, fmt.Errorf("BPDT signature %#08x not found, got %#08x", BPDTSignature, b.Header.Signature)
+	}
+	l := BPDTHeaderLength + BPDTEntryLength*int(b.Header.DescriptorCount)
+	if len(buf) < l {
+		return nil, fmt.Errorf("boot partition (%#x) too small for %d entries in BPDT (%#x)", len(buf), b.Header.DescriptorCount, l)
+	}
+	b.Entries = make([]BPDTEntry, b.Header.DescriptorCount)
+	if err := binary.Read(r, binary.LittleEndian, b.Entries); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// cseLayoutPartitions reads the partitions of a layout table at buf and
+// checks they fit in the region of length l.
+func cseLayoutPartitions(buf []byte, l int) ([]CSEPartition, error) {
+	var raw [CSELayoutPartitionCount][2]uint32
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &raw); err != nil {
+		return nil, err
+	}
+	ps := make([]CSEPartition, CSELayoutPartitionCount)
+	for i, r := range raw {
+		ps[i] = CSEPartition{Name: csePartitionNames[i], Offset: r[0], Size: r[1]}
+		if r[1] != 0 && uint64(r[0])+uint64(r[1]) > uint64(l) {
+			return nil, fmt.Errorf("CSE layout partition %s [%#x, %#x) exceeds the ME region (%#x)", ps[i].Name, r[0], uint64(r[0])+uint64(r[1]), l)
+		}
+	}
+	if ps[0].Size == 0 {
+		return nil, fmt.Errorf("CSE layout table has no data partition")
+	}
+	return ps, nil
+}
+
+// NewCSELayout tries to create a CSELayout from the start of an ME region.
+// The table is recognized by its data partition starting with an $FPT.
+func NewCSELayout(buf []byte) (*CSELayout, error) {
+	l := &CSELayout{}
+	copy(l.ROMBypass[:], buf)
+	o := CSELayoutROMBypassLength
+	for _, version := range []string{"1.7", "1.6"} {
+		start := o
+		if version == "1.7" {
+			start += CSELayoutTable17HeaderLength
+		}
+		end := start + CSELayoutPartitionCount*8
+		if len(buf) < end {
+			continue
+		}
+		ps, err := cseLayoutPartitions(buf[start:end], len(buf))
+		if err != nil || ps[0].Size < CSELayoutROMBypassLength+uint32(len(MEFTPSignature)) {
+			continue
+		}
+		if _, err := FindMEDescriptor(buf[ps[0].Offset : ps[0].Offset+ps[0].Size]); err != nil {
+			continue
+		}
+		l.Version = version
+		l.Partitions = ps
+		if version == "1.7" {
+			l.Size = binary.LittleEndian.Uint16(buf[o:])
+			l.Flags = buf[o+2]
+			l.Checksum = binary.LittleEndian.Uint32(buf[o+4:])
+		}
+		l.buf = copyBuf(buf[:end])
+		break
+	}
+	if l.Partitions == nil {
+		return nil, fmt.Errorf("CSE layout table not found")
+	}
+	for i := range l.Partitions {
+		p := &l.Partitions[i]
+		if p.Size == 0 || p.Name == "Data" || p.Name == "TempPages" || p.Name == "Flog" {
+			continue
+		}
+		if bpdt, err := NewBPDT(buf[p.Offset : p.Offset+p.Size]); err == nil {
+			p.BPDT = bpdt
+		}
+	}
+	return l, nil
+}
+
+// Data returns the data partition, holding the $FPT.
+func (l *CSELayout) Data() CSEPartition {
+	return l.Partitions[0]
+}
+
+// End returns the end of the last partition of the layout.
+func (l *CSELayout) End() uint64 {
+	var end uint64
+	for _, p := range l.Partitions {
+		if p.Size != 0 && uint64(p.Offset)+uint64(p.Size) > end {
+			end = uint64(p.Offset) + uint64(p.Size)
+		}
+	}
+	return end
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (l *CSELayout) Buf() []byte {
+	return l.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (l *CSELayout) SetBuf(buf []byte) {
+	l.buf = buf
+}
+
+// Apply calls the visitor on the CSELayout.
+func (l *CSELayout) Apply(v Visitor) error {
+	return visit(v, l)
+}
+
+// ApplyChildren calls the visitor on each child node of CSELayout.
+func (l *CSELayout) ApplyChildren(v Visitor) error {
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// cseImage returns an ME region with a layout table of the given version,
+// a data partition at 0x1000 holding an $FPT and a BP1 at 0x2000 holding a
+// BPDT with an FTPR entry.
+func cseImage(version string) []byte {
+	buf := bytes.Repeat([]byte{0xff}, 0x4000)
+	copy(buf, make([]byte, 0x100))
+	o := CSELayoutROMBypassLength
+	if version == "1.7" {
+		binary.LittleEndian.PutUint16(buf[o:], 0x58)
+		binary.LittleEndian.PutUint32(buf[o+4:], 0x12345678)
+		o += CSELayoutTable17HeaderLength
+	}
+	for _, p := range [][2]uint32{{0x1000, 0x1000}, {0x2000, 0x1000}, {0x3000, 0x1000}} {
+		binary.LittleEndian.PutUint32(buf[o:], p[0])
+		binary.LittleEndian.PutUint32(buf[o+4:], p[1])
+		o += 8
+	}
+
+	fpt := buf[0x1000:]
+	copy(fpt, make([]byte, 0x100))
+	copy(fpt[0x10:], MEFTPSignature)
+	binary.LittleEndian.PutUint32(fpt[0x14:], 1)
+	copy(fpt[0x30:], "FTPR")
+	binary.LittleEndian.PutUint32(fpt[0x38:], 0x2000)
+	binary.LittleEndian.PutUint32(fpt[0x3c:], 0x1000)
+
+	bpdt := buf[0x2000:]
+	copy(bpdt, make([]byte, 0x100))
+	binary.LittleEndian.PutUint32(bpdt, BPDTSignature)
+	binary.LittleEndian.PutUint16(bpdt[4:], 2)
+	binary.LittleEndian.PutUint16(bpdt[6:], 2)
+	binary.LittleEndian.PutUint16(bpdt[24:], 2) // FTPR
+	binary.LittleEndian.PutUint32(bpdt[28:], 0x100)
+	binary.LittleEndian.PutUint32(bpdt[32:], 0x800)
+	binary.LittleEndian.PutUint16(bpdt[36:], 3) // UCOD, empty
+	return buf
+}
+
+func TestNewCSELayout(t *testing.T) {
+	for _, version := range []string{"1.6", "1.7"} {
+		t.Run(version, func(t *testing.T) {
+			l, err := NewCSELayout(cseImage(version))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.Version != version {
+				t.Errorf("got version %q, want %q", l.Version, version)
+			}
+			if d := l.Data(); d.Offset != 0x1000 || d.Size != 0x1000 {
+				t.Errorf("got data partition %+v, want [0x1000, 0x2000)", d)
+			}
+			if e := l.End(); e != 0x4000 {
+				t.Errorf("got end %#x, want 0x4000", e)
+			}
+			bp1 := l.Partitions[1]
+			if bp1.Name != "BP1" || bp1.BPDT == nil || len(bp1.BPDT.Entries) != 2 {
+				t.Fatalf("got BP1 %+v, want a BPDT with 2 entries", bp1)
+			}
+			if e := bp1.BPDT.Entries[0]; e.Type.String() != "FTPR" || !e.OffsetIsValid() || e.Size != 0x800 {
+				t.Errorf("got entry %+v, want FTPR at 0x100", e)
+			}
+			if e := bp1.BPDT.Entries[1]; e.Type.String() != "UCOD" || e.OffsetIsValid() {
+				t.Errorf("got entry %+v, want an empty UCOD", e)
+			}
+			if l.Partitions[2].BPDT != nil {
+				t.Errorf("got a BPDT in the erased BP2")
+			}
+		})
+	}
+}
+
+func TestNewCSELayoutNotFound(t *testing.T) {
+	buf := cseImage("1.6")
+	copy(buf[0x1010:], "$XXX")
+	if l, err := NewCSELayout(buf); err == nil {
+		t.Errorf("got layout %+v without $FPT in the data partition, want an error", l)
+	}
+}
+
+func TestNewMERegionCSELayout(t *testing.T) {
+	r, err := NewMERegion(cseImage("1.7"), nil, RegionTypeME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mer := r.(*MERegion)
+	if mer.Layout == nil || mer.FPT == nil {
+		t.Fatalf("got layout %v and $FPT %v, want both", mer.Layout, mer.FPT)
+	}
+	if len(mer.FPT.Entries) != 1 || mer.FPT.Entries[0].Name.String() != "FTPR" {
+		t.Errorf("got $FPT entries %+v, want FTPR", mer.FPT.Entries)
+	}
+	if mer.FreeSpaceOffset != 0x4000 {
+		t.Errorf("got free space offset %#x, want 0x4000", mer.FreeSpaceOffset)
+	}
+}
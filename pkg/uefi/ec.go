@@ -0,0 +1,113 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ECVendor identifies the vendor of an embedded controller firmware.
+type ECVendor string
+
+// Known EC vendors.
+const (
+	ECVendorITE     ECVendor = "ITE"
+	ECVendorNuvoton ECVendor = "Nuvoton"
+)
+
+var (
+	// ITEECSignature is what ITE ECs fetching their firmware from the
+	// shared SPI flash look for at offset 0x40 of the image. It is
+	// followed by chip specific bytes.
+	ITEECSignature = []byte{0xa5, 0xa5, 0xa5, 0xa5, 0xa5, 0xa5, 0xa5}
+	// NuvotonECSignature starts the firmware header of Nuvoton NPCX ECs.
+	NuvotonECSignature = []byte{0x5e, 0x4d, 0x3b, 0x2a}
+)
+
+const (
+	iteSignatureOffset = 0x40
+	// ecScanAlign is the alignment at which EC firmware is searched for in
+	// BIOS padding.
+	ecScanAlign = 0x100
+)
+
+// ECFirmware is the firmware of an embedded controller, found in the EC
+// region or in padding of the BIOS region. It spans from Offset in its
+// parent to the last byte which is not erased.
+type ECFirmware struct {
+	buf    []byte
+	Vendor ECVendor
+	// Offset in the parent.
+	Offset uint64
+
+	// Metadata for extraction and recovery
+	ExtractPath string
+}
+
+// DetectEC returns the vendor of the EC firmware at the start of buf, or
+// an empty string if there is none.
+func DetectEC(buf []byte) ECVendor {
+	switch {
+	case bytes.HasPrefix(buf, NuvotonECSignature):
+		return ECVendorNuvoton
+	case len(buf) > iteSignatureOffset && bytes.HasPrefix(buf[iteSignatureOffset:], ITEECSignature):
+		return ECVendorITE
+	}
+	return ""
+}
+
+// FindECFirmware searches buf for EC firmware at ecScanAlign boundaries
+// and returns the first one, or nil if there is none. The firmware is not
+// copied.
+func FindECFirmware(buf []byte) *ECFirmware {
+	for o := 0; o < len(buf); o += ecScanAlign {
+		vendor := DetectEC(buf[o:])
+		if vendor == "" {
+			continue
+		}
+		end := len(buf)
+		for end > o && buf[end-1] == 0xff {
+			end--
+		}
+		return &ECFirmware{buf: buf[o:end], Vendor: vendor, Offset: uint64(o)}
+	}
+	return nil
+}
+
+// PlaceECFirmware writes the firmware into the buffer of its parent at its
+// offset and erases the rest of the parent, which held the previous
+// firmware.
+func PlaceECFirmware(buf []byte, ec *ECFirmware) error {
+	if end := ec.Offset + uint64(len(ec.buf)); end > uint64(len(buf)) {
+		return fmt.Errorf("%v EC firmware of %#x bytes at %#x does not fit in %#x bytes",
+			ec.Vendor, len(ec.buf), ec.Offset, len(buf))
+	}
+	n := copy(buf[ec.Offset:], ec.buf)
+	eraseFlash(buf[ec.Offset+uint64(n):])
+	return nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (ec *ECFirmware) Buf() []byte {
+	return ec.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (ec *ECFirmware) SetBuf(buf []byte) {
+	ec.buf = buf
+}
+
+// Apply calls the visitor on the ECFirmware.
+func (ec *ECFirmware) Apply(v Visitor) error {
+	return visit(v, ec)
+}
+
+// ApplyChildren calls the visitor on each child node of ECFirmware.
+func (ec *ECFirmware) ApplyChildren(v Visitor) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectEC(t *testing.T) {
+	ite := make([]byte, 0x100)
+	copy(ite[iteSignatureOffset:], ITEECSignature)
+	for _, c := range []struct {
+		buf  []byte
+		want ECVendor
+	}{
+		{ite, ECVendorITE},
+		{append(append([]byte{}, NuvotonECSignature...), 0, 0), ECVendorNuvoton},
+		{ite[:iteSignatureOffset+3], ""},
+		{bytes.Repeat([]byte{0xff}, 0x100), ""},
+	} {
+		if got := DetectEC(c.buf); got != c.want {
+			t.Errorf("DetectEC(% x...): got %q, want %q", c.buf[:4], got, c.want)
+		}
+	}
+}
+
+func TestFindECFirmware(t *testing.T) {
+	buf := bytes.Repeat([]byte{0xff}, 0x1000)
+	copy(buf[0x300:], NuvotonECSignature)
+	copy(buf[0x300+len(NuvotonECSignature):], "firmware")
+	ec := FindECFirmware(buf)
+	if ec == nil {
+		t.Fatal("FindECFirmware: got nil, want Nuvoton firmware")
+	}
+	if ec.Vendor != ECVendorNuvoton || ec.Offset != 0x300 || len(ec.Buf()) != len(NuvotonECSignature)+len("firmware") {
+		t.Errorf("FindECFirmware: got %v firmware of %#x bytes at %#x", ec.Vendor, len(ec.Buf()), ec.Offset)
+	}
+
+	ec.SetBuf(append(append([]byte{}, NuvotonECSignature...), 1))
+	if err := PlaceECFirmware(buf, ec); err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Repeat([]byte{0xff}, 0x1000)
+	copy(want[0x300:], ec.Buf())
+	if !bytes.Equal(buf, want) {
+		t.Errorf("PlaceECFirmware did not replace the old firmware")
+	}
+	ec.SetBuf(make([]byte, 0xd01))
+	if err := PlaceECFirmware(buf, ec); err == nil {
+		t.Errorf("PlaceECFirmware of too large firmware: got nil, want error")
+	}
+
+	if ec := FindECFirmware(buf[:0x300]); ec != nil {
+		t.Errorf("FindECFirmware: got %v firmware, want none", ec.Vendor)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	peDebugDir          = 6
+	peDebugEntryLen     = 28
+	peDebugTypeCodeView = 2
+	teDebugDirOffset    = 32
+)
+
+var peMachines = map[uint16]string{
+	0x014c: "IA32",
+	0x01c0: "ARM",
+	0x01c2: "ARM",
+	0x0ebc: "EBC",
+	0x5032: "RISCV32",
+	0x5064: "RISCV64",
+	0x6264: "LOONGARCH64",
+	0x8664: "X64",
+	0xaa64: "AARCH64",
+}
+
+// ExecutableInfo is the build metadata of a PE32(+) or TE image.
+type ExecutableInfo struct {
+	// Machine is the architecture, like X64, or its number if unknown.
+	Machine string
+	// TimeDateStamp is when the image was linked. TE images drop it.
+	TimeDateStamp uint32 `json:",omitempty"`
+	// PDB is the path of the debug symbols, which edk2 builds record.
+	PDB string `json:",omitempty"`
+}
+
+// ParseExecutableInfo returns the build metadata of the PE32(+) or TE image
+// in buf.
+func ParseExecutableInfo(buf []byte) (*ExecutableInfo, error) {
+	u16 := func(o int) uint16 { return binary.LittleEndian.Uint16(buf[o:]) }
+	u32 := func(o int) int { return int(binary.LittleEndian.Uint32(buf[o:])) }
+	machine := func(m uint16) string {
+		if name, ok := peMachines[m]; ok {
+			return name
+		}
+		return fmt.Sprintf("%#04x", m)
+	}
+
+	switch {
+	case len(buf) >= teHeaderLen && bytes.HasPrefix(buf, teSignature):
+		info := &ExecutableInfo{Machine: machine(u16(2))}
+		// Offsets in TE images are those of the PE image, whose headers
+		// were stripped and replaced with the TE header.
+		delta := teHeaderLen - int(u16(6))
+		dir, size := u32(teDebugDirOffset), u32(teDebugDirOffset+4)
+		if size != 0 {
+			info.PDB = codeViewPath(buf, dir+delta, size, delta)
+		}
+		return info, nil
+
+	case len(buf) > peOffsetOffset+4 && bytes.HasPrefix(buf, mzSignature):
+		pe := u32(peOffsetOffset)
+		opt := pe + len(peSignature) + coffHeaderLen
+		if pe < 0 || opt > len(buf) || !bytes.Equal(buf[pe:pe+4], peSignature) {
+			return nil, errors.New("no PE signature")
+		}
+		info := &ExecutableInfo{Machine: machine(u16(pe + 4)), TimeDateStamp: uint32(u32(pe + 4 + 4))}
+		nSections := int(u16(pe + 4 + 2))
+		optLen := int(u16(pe + 4 + 16))
+		var rvaCountOffset int
+		switch magic := u16(opt); magic {
+		case peMagic32:
+			rvaCountOffset = 92
+		case peMagic64:
+			rvaCountOffset = 108
+		default:
+			return nil, fmt.Errorf("unknown optional header magic %#x", magic)
+		}
+		sectionTable := opt + optLen
+		if sectionTable+nSections*peSectionLen > len(buf) {
+			return nil, errors.New("PE headers out of the image")
+		}
+		debugDir := opt + rvaCountOffset + 4 + peDebugDir*peDataDirEntryLen
+		if u32(opt+rvaCountOffset) <= peDebugDir || debugDir+peDataDirEntryLen > sectionTable {
+			return info, nil
+		}
+		// Find the file offset of the directory from its address.
+		rva, size := u32(debugDir), u32(debugDir+4)
+		for i := 0; i < nSections && size != 0; i++ {
+			s := sectionTable + i*peSectionLen
+			va, vsize, raw := u32(s+12), u32(s+8), u32(s+20)
+			if rva >= va && rva < va+vsize {
+				info.PDB = codeViewPath(buf, rva-va+raw, size, 0)
+				break
+			}
+		}
+		return info, nil
+	}
+	return nil, errors.New("neither a PE32 nor a TE image")
+}
+
+// codeViewPath returns the path of the first CodeView entry of the debug
+// directory at offset dir, whose pointers to data are off by delta.
+func codeViewPath(buf []byte, dir, size, delta int) string {
+	for e := dir; e >= 0 && e+peDebugEntryLen <= len(buf) && e+peDebugEntryLen <= dir+size; e += peDebugEntryLen {
+		if binary.LittleEndian.Uint32(buf[e+12:]) != peDebugTypeCodeView {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint32(buf[e+16:]))
+		p := int(binary.LittleEndian.Uint32(buf[e+24:])) + delta
+		if p < 0 || n < 4 || p+n > len(buf) {
+			return ""
+		}
+		cv := buf[p : p+n]
+		var path []byte
+		switch string(cv[:4]) {
+		case "RSDS": // signature, GUID, age
+			if len(cv) > 24 {
+				path = cv[24:]
+			}
+		case "NB10": // signature, offset, timestamp, age
+			if len(cv) > 16 {
+				path = cv[16:]
+			}
+		case "MTOC": // signature, UUID
+			if len(cv) > 20 {
+				path = cv[20:]
+			}
+		}
+		if i := bytes.IndexByte(path, 0); i >= 0 {
+			path = path[:i]
+		}
+		return string(path)
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// putCodeView writes a debug directory entry at dir pointing to a RSDS
+// CodeView record at cv, which pointer is ptr.
+func putCodeView(buf []byte, dir, cv, ptr int, path string) {
+	rsds := append(append([]byte("RSDS"), make([]byte, 20)...), path+"\x00"...)
+	binary.LittleEndian.PutUint32(buf[dir+12:], peDebugTypeCodeView)
+	binary.LittleEndian.PutUint32(buf[dir+16:], uint32(len(rsds)))
+	binary.LittleEndian.PutUint32(buf[dir+24:], uint32(ptr))
+	copy(buf[cv:], rsds)
+}
+
+func TestParseExecutableInfoPE(t *testing.T) {
+	const (
+		opt      = 0x40 + 4 + coffHeaderLen
+		sections = opt + 112 + 16*8
+	)
+	img := testPE(nil)
+	binary.LittleEndian.PutUint16(img[0x40+4:], 0x8664)
+	binary.LittleEndian.PutUint32(img[0x40+4+4:], 0x5f000000)
+	// The second section, at 0x200 in the file, is mapped at 0x1000 and
+	// holds the debug directory.
+	binary.LittleEndian.PutUint32(img[sections+peSectionLen+8:], 0x100)
+	binary.LittleEndian.PutUint32(img[sections+peSectionLen+12:], 0x1000)
+	debugDir := opt + 112 + peDebugDir*peDataDirEntryLen
+	binary.LittleEndian.PutUint32(img[debugDir:], 0x1000)
+	binary.LittleEndian.PutUint32(img[debugDir+4:], peDebugEntryLen)
+	putCodeView(img, 0x200, 0x220, 0x220, "Build/X64/Shell.dll")
+
+	got, err := ParseExecutableInfo(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ExecutableInfo{Machine: "X64", TimeDateStamp: 0x5f000000, PDB: "Build/X64/Shell.dll"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseExecutableInfoTE(t *testing.T) {
+	// The stripped PE headers were 0x100 bytes, so offsets in the image are
+	// 0x100-teHeaderLen bytes past those in the TE file.
+	const delta = 0x100 - teHeaderLen
+	img := make([]byte, 0x100)
+	copy(img, teSignature)
+	binary.LittleEndian.PutUint16(img[2:], 0xaa64)
+	binary.LittleEndian.PutUint16(img[6:], 0x100)
+	binary.LittleEndian.PutUint32(img[teDebugDirOffset:], 0x40+delta)
+	binary.LittleEndian.PutUint32(img[teDebugDirOffset+4:], peDebugEntryLen)
+	putCodeView(img, 0x40, 0x80, 0x80+delta, "PeiCore.dll")
+
+	got, err := ParseExecutableInfo(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ExecutableInfo{Machine: "AARCH64", PDB: "PeiCore.dll"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseExecutableInfoErrors(t *testing.T) {
+	badMagic := testPE(nil)
+	badMagic[0x40+4+coffHeaderLen] = 0
+	for name, buf := range map[string][]byte{
+		"not an image": []byte("banana"),
+		"no PE header": append([]byte("MZ"), make([]byte, 0x40)...),
+		"bad magic":    badMagic,
+	} {
+		if _, err := ParseExecutableInfo(buf); err == nil {
+			t.Errorf("%s: got nil, want error", name)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package uefi
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -46,7 +47,9 @@ const (
 )
 
 // SupportedFiles is a list of files types which will be parsed. File types not
-// on this list are treated as opaque binary blobs.
+// on this list are treated as opaque binary blobs, but for the OEM and debug
+// types, see RegisterFileParser. It is the default of ParseOptions, which
+// choose the types of a single parse.
 var SupportedFiles = map[FVFileType]bool{
 	// These are the file types that we'll actually try to parse sections for.
 	FVFileTypeRaw:      false,
@@ -54,9 +57,9 @@ var SupportedFiles = map[FVFileType]bool{
 	FVFileTypeSECCore:  true,
 	FVFileTypePEICore:  true,
 	FVFileTypeDXECore:  true,
-	// TODO: Commenting out this line prevents PEI modules from being
-	// decompressed. This solves the problem of PEI being too big when recompressed.
-	//FVFileTypePEIM:               true,
+	// Compressed sections keep their encoding unless they change, so
+	// PEI does not grow when assembled again.
+	FVFileTypePEIM:               true,
 	FVFileTypeDriver:             true,
 	FVFileTypeCombinedPEIMDriver: true,
 	FVFileTypeApplication:        true,
@@ -272,11 +275,16 @@ type File struct {
 	// a File can contain either Sections or an NVarStore but not both
 	Sections  []*Section `json:",omitempty"`
 	NVarStore *NVarStore `json:",omitempty"`
+	// Raw files may hold firmware volumes compressed by a vendor instead.
+	CompressedVolume *CompressedVolume `json:",omitempty"`
 
 	//Metadata for extraction and recovery
 	buf         []byte
 	ExtractPath string
 	DataOffset  uint64
+	// Offset is where the file is in its volume. Assemble moves the
+	// executables which run in place as much as the file moved.
+	Offset uint64
 }
 
 // Buf returns the buffer.
@@ -293,7 +301,7 @@ func (f *File) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the File.
 func (f *File) Apply(v Visitor) error {
-	return v.Visit(f)
+	return visit(v, f)
 }
 
 // ApplyChildren calls the visitor on each child node of File.
@@ -304,6 +312,9 @@ func (f *File) ApplyChildren(v Visitor) error {
 		}
 		return nil
 	}
+	if f.CompressedVolume != nil {
+		return f.CompressedVolume.Apply(v)
+	}
 	for _, s := range f.Sections {
 		if err := s.Apply(v); err != nil {
 			return err
@@ -340,7 +351,8 @@ func (f *File) ChecksumAndAssemble(fileData []byte) error {
 	// To checksum the header we write the temporary header to the file buffer first.
 	fh := &f.Header
 
-	header := new(bytes.Buffer)
+	header := getHeaderBuffer()
+	defer putHeaderBuffer(header)
 	err := binary.Write(header, binary.LittleEndian, fh)
 	if err != nil {
 		return fmt.Errorf("unable to construct binary header of file %v, got %v",
@@ -361,7 +373,7 @@ func (f *File) ChecksumAndAssemble(fileData []byte) error {
 
 	// Write out the updated header to the buffer with the new checksums.
 	// Write the extended header only if the large attribute flag is set.
-	header = new(bytes.Buffer)
+	header.Reset()
 	if fh.Attributes.IsLarge() {
 		err = binary.Write(header, binary.LittleEndian, fh)
 	} else {
@@ -370,14 +382,19 @@ func (f *File) ChecksumAndAssemble(fileData []byte) error {
 	if err != nil {
 		return err
 	}
-	f.buf = header.Bytes()
-
-	f.buf = append(f.buf, fileData...)
+	f.buf = concatBufs(header.Bytes(), fileData)
 	return nil
 }
 
-// CreatePadFile creates an empty pad file in order to align the next file.
+// CreatePadFile creates an empty pad file in order to align the next file,
+// erased with the default erase polarity.
 func CreatePadFile(size uint64) (*File, error) {
+	return CreateErasedPadFile(size, DefaultErasePolarity())
+}
+
+// CreateErasedPadFile creates an empty pad file for a volume of an erase
+// polarity, whose GUID and data are erased like GenFv does.
+func CreateErasedPadFile(size uint64, polarity byte) (*File, error) {
 	if size < FileHeaderMinLength {
 		return nil, fmt.Errorf("size too small! min size required is %#x bytes, requested %#x",
 			FileHeaderMinLength, size)
@@ -387,12 +404,12 @@ func CreatePadFile(size uint64) (*File, error) {
 	fh := &f.Header
 
 	// Create empty guid
-	if Attributes.ErasePolarity == 0xFF || true {
+	if polarity == 0xFF {
 		fh.GUID = *FFGUID
-	} else if Attributes.ErasePolarity == 0 {
+	} else if polarity == 0 {
 		fh.GUID = *ZeroGUID
 	} else {
-		return nil, fmt.Errorf("erase polarity not 0x00 or 0xFF, got %#x", Attributes.ErasePolarity)
+		return nil, fmt.Errorf("erase polarity not 0x00 or 0xFF, got %#x", polarity)
 	}
 
 	// TODO: I see examples of this where the attributes are just 0 and not dependent on the
@@ -412,11 +429,9 @@ func CreatePadFile(size uint64) (*File, error) {
 		fileData = make([]byte, size-FileHeaderExtMinLength)
 	}
 	// Fill with empty bytes
-	for i, dataLen := 0, len(fileData); i < dataLen; i++ {
-		fileData[i] = Attributes.ErasePolarity
-	}
+	Erase(fileData, polarity)
 
-	fh.SetState(FileStateValid)
+	fh.State = FileStateValid ^ FileState(polarity)
 
 	// Everything has been setup. Checksum and create.
 	if err := f.ChecksumAndAssemble(fileData); err != nil {
@@ -429,8 +444,22 @@ func CreatePadFile(size uint64) (*File, error) {
 // object, if a valid one is passed, or an error. If no error is returned and the File
 // pointer is nil, it means we've reached the volume free space at the end of the FV.
 func NewFile(buf []byte) (*File, error) {
+	return newFile(context.Background(), buf)
+}
+
+func newFile(ctx context.Context, buf []byte) (*File, error) {
+	ctx, leave, err := enterNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
 	f := File{}
 	f.DataOffset = FileHeaderMinLength
+	// An erased header starts the free space, whatever the polarity of the
+	// volume.
+	if len(buf) >= FileHeaderMinLength && IsErased(buf[:FileHeaderMinLength], erasePolarityOf(ctx)) {
+		return nil, nil
+	}
 	// Read in standard header.
 	r := bytes.NewReader(buf)
 	if err := binary.Read(r, binary.LittleEndian, &f.Header.FileHeader); err != nil {
@@ -464,43 +493,35 @@ func NewFile(buf []byte) (*File, error) {
 			f.Header.GUID, f.Header.ExtendedSize, buflen)
 	}
 
-	if ReadOnly {
-		f.buf = buf[:f.Header.ExtendedSize]
-	} else {
-		// Copy out the buffer.
-		newBuf := buf[:f.Header.ExtendedSize]
-		f.buf = make([]byte, f.Header.ExtendedSize)
-		copy(f.buf, newBuf)
-	}
+	// Copy out the buffer.
+	f.buf = copyBuf(buf[:f.Header.ExtendedSize])
 
 	// Special case for NVAR Store stored in raw file
 	if f.Header.Type == FVFileTypeRaw && f.Header.GUID == *NVAR {
 		ns, err := NewNVarStore(f.buf[f.DataOffset:])
 		if err != nil {
-			log.Errorf("error parsing NVAR store in file %v: %v", f.Header.GUID, err)
+			log.FromContext(ctx).Errorf("error parsing NVAR store in file %v: %v", f.Header.GUID, err)
 		}
 		// Note that ns is nil if there was an error, so this assign is fine either way.
 		f.NVarStore = ns
 	}
 
 	// Parse sections
-	if !SupportedFiles[f.Header.Type] {
+	if !parseOptionsOf(ctx).parsesSections(f.Header.Type) {
+		switch {
+		case isOEMOrDebug(f.Header.Type):
+			parseOEMOrDebugFile(ctx, &f)
+		case f.Header.Type == FVFileTypeRaw && f.NVarStore == nil && f.DataOffset < f.Header.ExtendedSize:
+			cv, err := findCompressedVolume(ctx, f.buf[f.DataOffset:], 0)
+			if err != nil {
+				return nil, err
+			}
+			f.CompressedVolume = cv
+		}
 		return &f, nil
 	}
-
-	for i, offset := 0, f.DataOffset; offset < f.Header.ExtendedSize; i++ {
-		s, err := NewSection(f.buf[offset:], i)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing sections of file %v: %v", f.Header.GUID, err)
-		}
-		if s.Header.ExtendedSize == 0 {
-			return nil, fmt.Errorf("invalid length of section of file %v", f.Header.GUID)
-		}
-		offset += uint64(s.Header.ExtendedSize)
-		// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
-		// but UEFITool aligns to 4 bytes, and this seems to work on everything I have.
-		offset = Align4(offset)
-		f.Sections = append(f.Sections, s)
+	if err := ParseFileSections(ctx, &f); err != nil {
+		return nil, err
 	}
 	return &f, nil
 }
@@ -0,0 +1,143 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/log"
+)
+
+// FileParser parses the body of a file of an OEM or debug type, the bytes
+// of f.Buf() after DataOffset, into its children, Sections or NVarStore,
+// which it is assembled from. The file stays opaque if it returns an error.
+type FileParser func(ctx context.Context, f *File) error
+
+// fileParsers are the parsers of the files of OEM and debug types, by GUID
+// or by type, the GUIDs first.
+var fileParsers = struct {
+	sync.RWMutex
+	guids map[guid.GUID]FileParser
+	types map[FVFileType]FileParser
+}{
+	guids: map[guid.GUID]FileParser{},
+	types: map[FVFileType]FileParser{},
+}
+
+// isOEMOrDebug reports whether t is in the OEM or debug ranges of file
+// types, which the PI spec leaves to the vendors.
+func isOEMOrDebug(t FVFileType) bool {
+	return FVFileTypeOEMMin <= t && t <= FVFileTypeDebugMax
+}
+
+// RegisterFileParser makes parse the parser of the files of type t, which
+// must be an OEM or debug type, replacing the section heuristic. A nil
+// parse restores it.
+func RegisterFileParser(t FVFileType, parse FileParser) error {
+	if !isOEMOrDebug(t) {
+		return fmt.Errorf("file type %v is not an OEM or debug type", t)
+	}
+	fileParsers.Lock()
+	defer fileParsers.Unlock()
+	if parse == nil {
+		delete(fileParsers.types, t)
+	} else {
+		fileParsers.types[t] = parse
+	}
+	return nil
+}
+
+// RegisterFileGUIDParser makes parse the parser of the files of OEM and
+// debug types named g, before the parser of their type. A nil parse
+// removes it.
+func RegisterFileGUIDParser(g guid.GUID, parse FileParser) {
+	fileParsers.Lock()
+	defer fileParsers.Unlock()
+	if parse == nil {
+		delete(fileParsers.guids, g)
+	} else {
+		fileParsers.guids[g] = parse
+	}
+}
+
+// fileParserOf returns the parser registered for the GUID or the type of
+// the OEM or debug file f, nil if none is.
+func fileParserOf(f *File) FileParser {
+	fileParsers.RLock()
+	defer fileParsers.RUnlock()
+	if parse, ok := fileParsers.guids[f.Header.GUID]; ok {
+		return parse
+	}
+	return fileParsers.types[f.Header.Type]
+}
+
+// ParseFileSections parses the body of f as sections, like the body of the
+// file types of SupportedFiles.
+func ParseFileSections(ctx context.Context, f *File) error {
+	var offsets []uint64
+	for i, offset := 0, f.DataOffset; offset < f.Header.ExtendedSize; i++ {
+		s, err := newSection(ctx, f.buf[offset:], i)
+		if err != nil {
+			return fmt.Errorf("error parsing sections of file %v: %w", f.Header.GUID, err)
+		}
+		if s.Header.ExtendedSize == 0 {
+			return fmt.Errorf("invalid length of section of file %v", f.Header.GUID)
+		}
+		offsets = append(offsets, offset)
+		offset += uint64(s.Header.ExtendedSize)
+		// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
+		// but UEFITool aligns to 4 bytes, and this seems to work on everything I have.
+		offset = Align4(offset)
+		f.Sections = append(f.Sections, s)
+	}
+	detectSectionAlignments(f.Sections, offsets)
+	return nil
+}
+
+// ParseFileSectionsIfValid parses the body of f as sections when it looks
+// like sections: they parse, are of known types and are laid out again as
+// the very same bytes, so that the file does not change when assembled.
+func ParseFileSectionsIfValid(ctx context.Context, f *File) error {
+	if f.DataOffset >= f.Header.ExtendedSize {
+		return fmt.Errorf("no body")
+	}
+	if err := ParseFileSections(ctx, f); err != nil {
+		return err
+	}
+	for _, s := range f.Sections {
+		if _, ok := sectionTypeNames[s.Header.Type]; !ok {
+			return fmt.Errorf("unknown section type %#x", uint8(s.Header.Type))
+		}
+	}
+	_, data, err := LayoutSections(f.Sections, f.DataOffset)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, f.buf[f.DataOffset:]) {
+		return fmt.Errorf("the sections are not laid out as the body")
+	}
+	return nil
+}
+
+// parseOEMOrDebugFile parses the file f of an OEM or debug type with its
+// registered parser, else ParseFileSectionsIfValid, keeping it opaque when
+// the parser fails.
+func parseOEMOrDebugFile(ctx context.Context, f *File) {
+	parse := fileParserOf(f)
+	if parse == nil {
+		if ParseFileSectionsIfValid(ctx, f) != nil {
+			f.Sections = nil
+		}
+		return
+	}
+	if err := parse(ctx, f); err != nil {
+		log.FromContext(ctx).Warnf("file %v of type %v kept opaque: %v", f.Header.GUID, f.Header.Type, err)
+		f.Sections, f.NVarStore = nil, nil
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// rawSections returns RAW sections of the payloads, 4-byte aligned with
+// pad bytes between them.
+func rawSections(t *testing.T, pad byte, payloads ...string) []byte {
+	var body []byte
+	for _, p := range payloads {
+		for len(body)%4 != 0 {
+			body = append(body, pad)
+		}
+		s, err := CreateSection(SectionTypeRaw, []byte(p), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.GenSecHeader(); err != nil {
+			t.Fatal(err)
+		}
+		body = append(body, s.Buf()...)
+	}
+	return body
+}
+
+// newTypedFile parses a file of type typ named g with body.
+func newTypedFile(t *testing.T, typ FVFileType, g guid.GUID, body []byte) *File {
+	buf := make([]byte, FileHeaderMinLength, FileHeaderMinLength+len(body))
+	copy(buf, g[:])
+	buf[18] = byte(typ)
+	buf = append(buf, body...)
+	size := len(buf)
+	buf[20], buf[21], buf[22] = byte(size), byte(size>>8), byte(size>>16)
+	buf[23] = 0xf8
+	f, err := NewFile(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestOEMFileSectionHeuristic(t *testing.T) {
+	g := *guid.MustParse("B7E7E4D2-2A8F-4C3B-9E6E-3C5E1A0F1D01")
+	for _, test := range []struct {
+		name     string
+		typ      FVFileType
+		body     []byte
+		sections int
+	}{
+		{"OEM sections", FVFileTypeOEMMin, rawSections(t, 0, "hello", "world"), 2},
+		{"debug sections", FVFileTypeDebugMax, rawSections(t, 0, "debug"), 1},
+		{"not sections", FVFileTypeOEMMin + 1, []byte("not sections at all"), 0},
+		{"foreign padding", FVFileTypeOEMMax, rawSections(t, 0xff, "hello", "world"), 0},
+		{"no body", FVFileTypeDebugMin, nil, 0},
+		{"not OEM", FVFileTypeRaw, rawSections(t, 0, "raw"), 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTypedFile(t, test.typ, g, test.body)
+			if len(f.Sections) != test.sections {
+				t.Errorf("got %d sections, want %d", len(f.Sections), test.sections)
+			}
+		})
+	}
+}
+
+func TestRegisterFileParser(t *testing.T) {
+	const oemType = FVFileTypeOEMMin + 5
+	g := *guid.MustParse("B7E7E4D2-2A8F-4C3B-9E6E-3C5E1A0F1D02")
+	body := rawSections(t, 0, "hello", "world")
+
+	if err := RegisterFileParser(FVFileTypeDriver, ParseFileSections); err == nil {
+		t.Errorf("registered a parser of drivers, want an error")
+	}
+
+	var parsed []FVFileType
+	if err := RegisterFileParser(oemType, func(ctx context.Context, f *File) error {
+		parsed = append(parsed, f.Header.Type)
+		return ParseFileSections(ctx, f)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterFileParser(oemType, nil)
+	f := newTypedFile(t, oemType, g, body)
+	if len(parsed) != 1 || len(f.Sections) != 2 {
+		t.Errorf("got %d sections parsed by %d parsers, want 2 by the registered parser", len(f.Sections), len(parsed))
+	}
+	if f := newTypedFile(t, oemType+1, g, body); len(parsed) != 1 || len(f.Sections) != 2 {
+		t.Errorf("got the parser of type %v called for type %v", oemType, oemType+1)
+	}
+
+	// The parser of the GUID comes first, and the files it fails to parse
+	// stay opaque.
+	RegisterFileGUIDParser(g, func(ctx context.Context, f *File) error {
+		if err := ParseFileSections(ctx, f); err != nil {
+			return err
+		}
+		return errors.New("not this one")
+	})
+	defer RegisterFileGUIDParser(g, nil)
+	if f := newTypedFile(t, oemType, g, body); len(parsed) != 1 || len(f.Sections) != 0 {
+		t.Errorf("got %d sections, %d calls of the type parser, want an opaque file", len(f.Sections), len(parsed))
+	}
+	RegisterFileGUIDParser(g, nil)
+	if f := newTypedFile(t, oemType, g, body); len(parsed) != 2 || len(f.Sections) != 2 {
+		t.Errorf("got %d sections, %d calls of the type parser, want the type parser back", len(f.Sections), len(parsed))
+	}
+}
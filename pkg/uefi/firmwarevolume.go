@@ -6,6 +6,7 @@ package uefi
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -92,7 +93,10 @@ type FirmwareVolume struct {
 	// We don't really have to care about blocks because we just read everything in.
 	Blocks []Block
 	FirmwareVolumeExtHeader
-	Files []*File `json:",omitempty"`
+	ExtEntries []FirmwareVolumeExtEntry `json:",omitempty"`
+	Files      []*File                  `json:",omitempty"`
+	// VariableStores are the stores of NVRAM volumes.
+	VariableStores []*VariableStore `json:",omitempty"`
 
 	// Variables not in the binary for us to keep track of stuff/print
 	DataOffset  uint64
@@ -118,7 +122,7 @@ func (fv *FirmwareVolume) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the FirmwareVolume.
 func (fv *FirmwareVolume) Apply(v Visitor) error {
-	return v.Visit(fv)
+	return visit(v, fv)
 }
 
 // ApplyChildren calls the visitor on each child node of FirmwareVolume.
@@ -128,6 +132,11 @@ func (fv *FirmwareVolume) ApplyChildren(v Visitor) error {
 			return err
 		}
 	}
+	for _, s := range fv.VariableStores {
+		if err := s.Apply(v); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -139,6 +148,17 @@ func (fv *FirmwareVolume) GetErasePolarity() uint8 {
 	return 0
 }
 
+type erasePolarityKey struct{}
+
+// erasePolarityOf returns the erase polarity of the volume being parsed, or
+// the default one outside of volumes.
+func erasePolarityOf(ctx context.Context) byte {
+	if ep, ok := ctx.Value(erasePolarityKey{}).(byte); ok {
+		return ep
+	}
+	return DefaultErasePolarity()
+}
+
 // String creates a string representation for the firmware volume.
 func (fv FirmwareVolume) String() string {
 	if fv.ExtHeaderOffset != 0 {
@@ -158,8 +178,9 @@ func (fv *FirmwareVolume) InsertFile(alignedOffset uint64, fBuf []byte) error {
 	}
 
 	// add padding for alignment
+	ep := fv.GetErasePolarity()
 	for i, num := uint64(0), alignedOffset-bufLen; i < num; i++ {
-		fv.buf = append(fv.buf, Attributes.ErasePolarity)
+		fv.buf = append(fv.buf, ep)
 	}
 
 	// Check size
@@ -194,6 +215,15 @@ func FindFirmwareVolumeOffset(data []byte) int64 {
 // NewFirmwareVolume parses a sequence of bytes and returns a FirmwareVolume
 // object, if a valid one is passed, or an error
 func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareVolume, error) {
+	return newFirmwareVolume(context.Background(), data, fvOffset, resizable)
+}
+
+func newFirmwareVolume(ctx context.Context, data []byte, fvOffset uint64, resizable bool) (*FirmwareVolume, error) {
+	ctx, leave, err := enterNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
 	fv := FirmwareVolume{Resizable: resizable}
 
 	if len(data) < FirmwareVolumeMinSize {
@@ -221,10 +251,11 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 	}
 	fv.Blocks = blocks
 
-	// Set the erase polarity
-	if err := SetErasePolarity(fv.GetErasePolarity()); err != nil {
-		return nil, err
-	}
+	// The files of the volume are erased with its polarity, which is the
+	// default of the image if the first.
+	ep := fv.GetErasePolarity()
+	setDefaultErasePolarity(ep)
+	ctx = context.WithValue(ctx, erasePolarityKey{}, ep)
 
 	// Boundary checks (to return an error instead of panicking)
 	if fv.Length > uint64(len(data)) {
@@ -243,6 +274,12 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 		if err := binary.Read(r, binary.LittleEndian, &fv.FirmwareVolumeExtHeader); err != nil {
 			return nil, fmt.Errorf("unable to parse FV extended header, got: %v", err)
 		}
+		entries := uint64(fv.ExtHeaderOffset) + FirmwareVolumeExtHeaderMinSize
+		if end := uint64(fv.ExtHeaderOffset) + uint64(fv.ExtHeaderSize); end > entries && end <= fv.Length {
+			if fv.ExtEntries, err = parseFVExtEntries(data[entries:end]); err != nil {
+				log.FromContext(ctx).Warnf("FV %v: %v", fv.FVName, err)
+			}
+		}
 		// TODO: will the ext header ever end before the regular header? I don't believe so. Add a check?
 		fv.DataOffset = uint64(fv.ExtHeaderOffset) + uint64(fv.ExtHeaderSize)
 	}
@@ -253,36 +290,35 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 	fv.FVType = FVGUIDs[fv.FileSystemGUID]
 	fv.FVOffset = fvOffset
 
-	if ReadOnly {
-		fv.buf = data[:fv.Length]
-	} else {
-		// copy out the buffer.
-		newBuf := data[:fv.Length]
-		fv.buf = make([]byte, fv.Length)
-		copy(fv.buf, newBuf)
-	}
+	// copy out the buffer.
+	fv.buf = copyBuf(data[:fv.Length])
 
 	// Parse the files.
 	// TODO: handle fv data alignment.
 	// Start from the end of the fv header.
 	// Test if the fv type is supported.
 	if _, ok := supportedFVs[fv.FileSystemGUID]; !ok {
-		log.Warnf("unsupported fv type %v,%v not parsing it", fv.FileSystemGUID.String(), fv.FVType)
+		log.FromContext(ctx).Warnf("unsupported fv type %v,%v not parsing it", fv.FileSystemGUID.String(), fv.FVType)
+		// The variables of NVRAM volumes are parsed all the same.
+		if fv.FileSystemGUID == *EVSA && fv.DataOffset < fv.Length {
+			fv.VariableStores = findVariableStores(ctx, fv.buf[fv.DataOffset:])
+		}
 		return &fv, nil
 	}
 	lh := fv.Length - FileHeaderMinLength
 	var prevLen uint64
 	for offset := fv.DataOffset; offset < lh; offset += prevLen {
 		offset = Align8(offset)
-		file, err := NewFile(data[offset:])
+		file, err := newFile(ctx, data[offset:])
 		if err != nil {
-			return nil, fmt.Errorf("unable to construct firmware file at offset %#x into FV: %v", offset, err)
+			return nil, fmt.Errorf("unable to construct firmware file at offset %#x into FV: %w", offset, err)
 		}
 		if file == nil {
 			// We've reached free space. Terminate
 			fv.FreeSpace = fv.Length - offset
 			break
 		}
+		file.Offset = offset
 		fv.Files = append(fv.Files, file)
 		prevLen = file.Header.ExtendedSize
 		if prevLen == 0 {
@@ -5,6 +5,7 @@
 package uefi
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"testing"
@@ -47,3 +48,74 @@ func TestNewFirmwareVolume(t *testing.T) {
 		})
 	}
 }
+
+// erasedFV returns sampleFV with its first file only, erased with ep.
+func erasedFV(t *testing.T, ep byte) []byte {
+	t.Helper()
+	fv, err := NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(sampleFV))
+	Erase(buf, ep)
+	copy(buf, sampleFV[:fv.DataOffset])
+	copy(buf[fv.DataOffset:], fv.Files[0].Buf())
+	attr := binary.LittleEndian.Uint32(buf[44:]) &^ 0x800
+	if ep == 0xFF {
+		attr |= 0x800
+	}
+	binary.LittleEndian.PutUint32(buf[44:], attr)
+	return buf
+}
+
+func TestMixedErasePolarity(t *testing.T) {
+	defer func(a ROMAttributes) { Attributes = a }(Attributes)
+	Attributes.ErasePolarity = poisonedPolarity
+
+	// The first volume sets the default polarity, the other keeps its own.
+	for _, ep := range []byte{0xFF, 0x00} {
+		fv, err := NewFirmwareVolume(erasedFV(t, ep), 0, false)
+		if err != nil {
+			t.Fatalf("polarity %#x: %v", ep, err)
+		}
+		if got := fv.GetErasePolarity(); got != ep {
+			t.Errorf("got polarity %#x, want %#x", got, ep)
+		}
+		if len(fv.Files) != 1 || fv.FreeSpace == 0 {
+			t.Errorf("polarity %#x: got %d files and %#x bytes of free space, want 1 file and free space",
+				ep, len(fv.Files), fv.FreeSpace)
+		}
+	}
+	if Attributes.ErasePolarity != 0xFF {
+		t.Errorf("got default polarity %#x, want the one of the first volume, 0xFF", Attributes.ErasePolarity)
+	}
+
+	pad, err := CreateErasedPadFile(0x20, 0x00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pad.Header.GUID != *ZeroGUID || !IsErased(pad.Buf()[FileHeaderMinLength:], 0x00) {
+		t.Errorf("pad file %v is not erased to 0x00", pad.Header.GUID)
+	}
+}
+
+func TestErasePolarityPerImage(t *testing.T) {
+	defer func(a ROMAttributes) { Attributes = a }(Attributes)
+
+	// Each image sets the default polarity, and its pad files, anew.
+	for _, ep := range []byte{0x00, 0xFF, 0x00} {
+		if _, err := Parse(erasedFV(t, ep)); err != nil {
+			t.Fatalf("polarity %#x: %v", ep, err)
+		}
+		if got := DefaultErasePolarity(); got != ep {
+			t.Errorf("got default polarity %#x, want the one of the last image, %#x", got, ep)
+		}
+		pad, err := CreatePadFile(0x20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsErased(pad.Buf()[FileHeaderMinLength:], ep) {
+			t.Errorf("pad file is not erased to %#x", ep)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package uefi
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"sort"
@@ -70,7 +71,7 @@ func (fd *FlashDescriptor) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the FlashDescriptor.
 func (fd *FlashDescriptor) Apply(v Visitor) error {
-	return v.Visit(fd)
+	return visit(v, fd)
 }
 
 // ApplyChildren calls the visitor on each child node of FlashDescriptor.
@@ -145,7 +146,7 @@ func (f *FlashImage) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the FlashImage.
 func (f *FlashImage) Apply(v Visitor) error {
-	return v.Visit(f)
+	return visit(v, f)
 }
 
 // ApplyChildren calls the visitor on each child node of FlashImage.
@@ -229,6 +230,10 @@ func (f *FlashImage) fillRegionGaps() error {
 // and an error if any. This only works with images that operate in Descriptor
 // mode.
 func NewFlashImage(buf []byte) (*FlashImage, error) {
+	return newFlashImage(context.Background(), buf)
+}
+
+func newFlashImage(ctx context.Context, buf []byte) (*FlashImage, error) {
 	if len(buf) < FlashDescriptorLength {
 		return nil, fmt.Errorf("flash Descriptor Map size too small: expected %v bytes, got %v",
 			FlashDescriptorLength,
@@ -238,10 +243,8 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 	f := FlashImage{FlashSize: uint64(len(buf))}
 
 	// Copy out buffers
-	f.buf = make([]byte, len(buf))
-	copy(f.buf, buf)
-	f.IFD.buf = make([]byte, FlashDescriptorLength)
-	copy(f.IFD.buf, buf[:FlashDescriptorLength])
+	f.buf = copyBuf(buf)
+	f.IFD.buf = copyBuf(buf[:FlashDescriptorLength])
 
 	if err := f.IFD.ParseFlashDescriptor(); err != nil {
 		return nil, err
@@ -278,7 +281,17 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 			continue
 		}
 		if c, ok := regionConstructors[FlashRegionType(i)]; ok {
-			r, err := c(buf[fr.BaseOffset():fr.EndOffset()], &frs[i], FlashRegionType(i))
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			var r Region
+			var err error
+			if FlashRegionType(i) == RegionTypeBIOS {
+				// Only the BIOS region takes long to parse.
+				r, err = newBIOSRegion(ctx, buf[fr.BaseOffset():fr.EndOffset()], &frs[i], FlashRegionType(i))
+			} else {
+				r, err = c(buf[fr.BaseOffset():fr.EndOffset()], &frs[i], FlashRegionType(i))
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -0,0 +1,239 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// FlashComponentSectionSize is the size in bytes of the FlashComponentSection
+	FlashComponentSectionSize = 12
+
+	// flumap1Offset is the offset of the FLUMAP1 register in the descriptor,
+	// which locates the VSCC table.
+	flumap1Offset = 0xefc
+
+	// ChipNotPresent is the density code of a component which is not there.
+	ChipNotPresent = 0xf
+)
+
+// FlashComponentSection holds the parameters of the SPI flash chips: their
+// densities and frequencies, the opcodes the controller refuses to send and
+// the boundary between the lower and upper flash partition.
+type FlashComponentSection struct {
+	Params              FlashParams
+	InvalidInstructions [4]uint8
+	PartitionBoundary   uint32
+}
+
+// NewFlashComponentSection parses a sequence of bytes and returns a
+// FlashComponentSection object, if a valid one is passed, or an error
+func NewFlashComponentSection(buf []byte) (*FlashComponentSection, error) {
+	if len(buf) < FlashComponentSectionSize {
+		return nil, fmt.Errorf("flash Component Section size too small: expected %v bytes, got %v",
+			FlashComponentSectionSize,
+			len(buf),
+		)
+	}
+	var c FlashComponentSection
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// LegacyLayout reports whether the parameters use the layout of descriptors
+// older than Skylake, with 3-bit density fields. Like ifdtool, this is
+// guessed from the read clock frequency, which is 20MHz only on those.
+func (p *FlashParams) LegacyLayout() bool {
+	return p.ReadClockFrequency() == Freq20MHz
+}
+
+// ChipDensity returns the density code of chip 0 or 1.
+func (p *FlashParams) ChipDensity(chip int) uint {
+	if p.LegacyLayout() {
+		return uint(p[0]>>(3*chip)) & 0x07
+	}
+	if chip == 0 {
+		return p.FirstChipDensity()
+	}
+	return p.SecondChipDensity()
+}
+
+// ChipSize returns the size in bytes of chip 0 or 1, or 0 if it is not
+// present.
+func (p *FlashParams) ChipSize(chip int) uint64 {
+	d := p.ChipDensity(chip)
+	if d == ChipNotPresent || d > 7 {
+		return 0
+	}
+	return 512 * 1024 << d
+}
+
+// SetChipSize sets the density of chip 0 or 1 to size, which must be a
+// power of two between 512KiB and 64MiB, or 16MiB for legacy descriptors.
+// A size of 0 marks the chip as not present.
+func (p *FlashParams) SetChipSize(chip int, size uint64) error {
+	if chip != 0 && chip != 1 {
+		return fmt.Errorf("no flash chip %d", chip)
+	}
+	max := uint(7)
+	if p.LegacyLayout() {
+		max = 5
+	}
+	d := uint(ChipNotPresent)
+	if size != 0 {
+		for d = 0; d <= max && 512*1024<<d != size; d++ {
+		}
+		if d > max {
+			return fmt.Errorf("no density code for a chip of %#x bytes", size)
+		}
+	} else if p.LegacyLayout() {
+		return fmt.Errorf("legacy descriptors cannot mark a chip as not present")
+	}
+	if p.LegacyLayout() {
+		p[0] = p[0]&^(0x07<<(3*chip)) | byte(d)<<(3*chip)
+	} else {
+		p[0] = p[0]&^(0x0f<<(4*chip)) | byte(d)<<(4*chip)
+	}
+	return nil
+}
+
+// VSCCEntry is an entry of the VSCC table, which tells the flash controller
+// how to erase and write a SPI chip, identified by its JEDEC ID.
+type VSCCEntry struct {
+	JID  uint32
+	VSCC uint32
+}
+
+// VendorID returns the JEDEC vendor ID of the chip.
+func (e *VSCCEntry) VendorID() uint8 {
+	return uint8(e.JID)
+}
+
+// DeviceID returns the JEDEC device ID of the chip.
+func (e *VSCCEntry) DeviceID() uint16 {
+	return uint16(e.JID >> 8)
+}
+
+// Upper returns the parameters for the upper flash partition.
+func (e *VSCCEntry) Upper() VSCC {
+	return VSCC(e.VSCC)
+}
+
+// Lower returns the parameters for the lower flash partition.
+func (e *VSCCEntry) Lower() VSCC {
+	return VSCC(e.VSCC >> 16)
+}
+
+func (e *VSCCEntry) String() string {
+	return fmt.Sprintf("VSCCEntry{Vendor=%#02x, Device=%#04x, Lower=%v, Upper=%v}",
+		e.VendorID(), e.DeviceID(), e.Lower(), e.Upper())
+}
+
+// VSCC holds the erase and write parameters of a flash partition.
+type VSCC uint16
+
+// BlockEraseSize returns the size in bytes erased by the erase opcode.
+func (v VSCC) BlockEraseSize() uint32 {
+	return []uint32{256, 4 * 1024, 8 * 1024, 64 * 1024}[v&0x3]
+}
+
+// WriteGranularity returns the number of bytes written at once.
+func (v VSCC) WriteGranularity() uint32 {
+	if v&(1<<2) != 0 {
+		return 64
+	}
+	return 1
+}
+
+// WriteStatusRequired reports whether the status register has to be
+// written before writes.
+func (v VSCC) WriteStatusRequired() bool {
+	return v&(1<<3) != 0
+}
+
+// WriteEnableOnWriteStatus reports whether the status register is written
+// with opcode 0x06 (write enable) rather than 0x50.
+func (v VSCC) WriteEnableOnWriteStatus() bool {
+	return v&(1<<4) != 0
+}
+
+// EraseOpcode returns the opcode used to erase a block.
+func (v VSCC) EraseOpcode() uint8 {
+	return uint8(v >> 8)
+}
+
+func (v VSCC) String() string {
+	return fmt.Sprintf("{Erase=%#02x/%#x, WriteGranularity=%d, WriteStatusRequired=%v, WriteEnableOnWriteStatus=%v}",
+		v.EraseOpcode(), v.BlockEraseSize(), v.WriteGranularity(),
+		v.WriteStatusRequired(), v.WriteEnableOnWriteStatus())
+}
+
+// NumberOfComponents returns the number of flash chips of the descriptor.
+func (fd *FlashDescriptor) NumberOfComponents() int {
+	return int(fd.DescriptorMap.NumberOfFlashChips&0x3) + 1
+}
+
+func (fd *FlashDescriptor) componentStart() (uint, error) {
+	start := uint(fd.DescriptorMap.ComponentBase) * 0x10
+	if start+FlashComponentSectionSize > uint(len(fd.buf)) {
+		return 0, fmt.Errorf("flash component section at %#x out of the descriptor", start)
+	}
+	return start, nil
+}
+
+// Component parses the flash component section of the descriptor.
+func (fd *FlashDescriptor) Component() (*FlashComponentSection, error) {
+	start, err := fd.componentStart()
+	if err != nil {
+		return nil, err
+	}
+	return NewFlashComponentSection(fd.buf[start:])
+}
+
+// SetChipSize sets the density of chip 0 or 1 in the buffer of the
+// descriptor, e.g. when moving an image to a larger chip. Assemble keeps
+// the change.
+func (fd *FlashDescriptor) SetChipSize(chip int, size uint64) error {
+	if chip >= fd.NumberOfComponents() {
+		return fmt.Errorf("descriptor only has %d flash chips", fd.NumberOfComponents())
+	}
+	start, err := fd.componentStart()
+	if err != nil {
+		return err
+	}
+	var p FlashParams
+	copy(p[:], fd.buf[start:])
+	if err := p.SetChipSize(chip, size); err != nil {
+		return err
+	}
+	copy(fd.buf[start:], p[:])
+	return nil
+}
+
+// VSCCTable parses the VSCC table, which FLUMAP1 locates.
+func (fd *FlashDescriptor) VSCCTable() ([]VSCCEntry, error) {
+	if len(fd.buf) < flumap1Offset+4 {
+		return nil, fmt.Errorf("descriptor too small for FLUMAP1")
+	}
+	flumap1 := binary.LittleEndian.Uint32(fd.buf[flumap1Offset:])
+	base := uint(flumap1&0xff) << 4
+	n := uint(flumap1>>8&0xff) / 2
+	if base == 0 || n == 0 {
+		return nil, nil
+	}
+	if base+n*8 > uint(len(fd.buf)) {
+		return nil, fmt.Errorf("VSCC table at %#x with %d entries out of the descriptor", base, n)
+	}
+	entries := make([]VSCCEntry, n)
+	if err := binary.Read(bytes.NewReader(fd.buf[base:base+n*8]), binary.LittleEndian, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// withComponents adds a component section for one 8MiB chip at 17MHz and
+// a VSCC table with a W25Q64 to an image from ifdTestImage.
+func withComponents(buf []byte) []byte {
+	copy(buf[0x30:], []byte{0xf4, 0x00, 0x0c, 0x00, 0x20, 0x60, 0x00, 0x00})
+	binary.LittleEndian.PutUint32(buf[flumap1Offset:], 0x02df)
+	binary.LittleEndian.PutUint32(buf[0xdf0:], 0x1740ef)
+	binary.LittleEndian.PutUint32(buf[0xdf4:], 0x20052015)
+	return buf
+}
+
+func TestFlashComponent(t *testing.T) {
+	f, err := NewFlashImage(withComponents(ifdTestImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := &f.IFD
+	c, err := fd.Component()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fd.NumberOfComponents(); n != 1 {
+		t.Errorf("NumberOfComponents: got %d, want 1", n)
+	}
+	if c.Params.LegacyLayout() || c.Params.ReadClockFrequency() != Freq17MHz {
+		t.Errorf("read clock: got %v, want 17MHz", c.Params.ReadClockFrequency())
+	}
+	if s := c.Params.ChipSize(0); s != 8<<20 {
+		t.Errorf("chip 0: got %#x bytes, want 8MiB", s)
+	}
+	if s := c.Params.ChipSize(1); s != 0 {
+		t.Errorf("chip 1: got %#x bytes, want not present", s)
+	}
+	if c.InvalidInstructions != [4]uint8{0x20, 0x60, 0, 0} {
+		t.Errorf("invalid instructions: got % x", c.InvalidInstructions)
+	}
+
+	vscc, err := fd.VSCCTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vscc) != 1 {
+		t.Fatalf("VSCC table: got %d entries, want 1", len(vscc))
+	}
+	e := vscc[0]
+	if e.VendorID() != 0xef || e.DeviceID() != 0x1740 {
+		t.Errorf("JEDEC ID: got %#x %#x, want 0xef 0x1740", e.VendorID(), e.DeviceID())
+	}
+	if l := e.Lower(); l.EraseOpcode() != 0x20 || l.BlockEraseSize() != 0x1000 || l.WriteGranularity() != 64 || l.WriteStatusRequired() {
+		t.Errorf("lower VSCC: got %v", l)
+	}
+	if u := e.Upper(); !u.WriteEnableOnWriteStatus() {
+		t.Errorf("upper VSCC: got %v, want write enable on write status", u)
+	}
+
+	if err := fd.SetChipSize(0, 32<<20); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = fd.Component(); c.Params.ChipSize(0) != 32<<20 || c.Params.ChipDensity(1) != ChipNotPresent {
+		t.Errorf("SetChipSize: got %#x bytes, density of chip 1 %#x", c.Params.ChipSize(0), c.Params.ChipDensity(1))
+	}
+	for _, s := range []struct {
+		chip int
+		size uint64
+	}{{0, 3 << 20}, {0, 128 << 20}, {1, 8 << 20}} {
+		if err := fd.SetChipSize(s.chip, s.size); err == nil {
+			t.Errorf("SetChipSize(%d, %#x): got nil, want error", s.chip, s.size)
+		}
+	}
+}
+
+func TestLegacyFlashParams(t *testing.T) {
+	p := FlashParams{0x1d}
+	if !p.LegacyLayout() || p.ChipSize(0) != 16<<20 || p.ChipSize(1) != 4<<20 {
+		t.Errorf("legacy densities: got %#x and %#x, want 16MiB and 4MiB", p.ChipSize(0), p.ChipSize(1))
+	}
+	if err := p.SetChipSize(1, 1<<20); err != nil || p[0] != 0x0d {
+		t.Errorf("SetChipSize: got %#x, %v, want 0x0d", p[0], err)
+	}
+	if err := p.SetChipSize(0, 32<<20); err == nil {
+		t.Errorf("32MiB legacy chip: got nil, want error")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// StrapPlatform is the chipset generation the soft straps of a descriptor
+// are laid out for.
+type StrapPlatform string
+
+// Strap platforms, guessed from the descriptor as ifdtool does.
+const (
+	StrapPlatformICH8        StrapPlatform = "ICH8"
+	StrapPlatformICH9        StrapPlatform = "ICH9"
+	StrapPlatformICH10       StrapPlatform = "ICH10"
+	StrapPlatformIbexPeak    StrapPlatform = "5 series (Ibex Peak)"
+	StrapPlatformBayTrail    StrapPlatform = "Bay Trail"
+	StrapPlatformCougarPoint StrapPlatform = "6/7 series (Cougar/Panther Point)"
+	StrapPlatformLynxPoint   StrapPlatform = "8 series (Lynx Point)"
+	StrapPlatformWildcat     StrapPlatform = "9 series (Wildcat Point)"
+	StrapPlatformIFD2        StrapPlatform = "100 series and later (IFD v2)"
+	StrapPlatformUnknown     StrapPlatform = "unknown"
+)
+
+// Strap sections: the straps of the PCH, or ICH, and of the processor, or
+// MCH.
+const (
+	StrapSectionPCH = "PCH"
+	StrapSectionCPU = "CPU"
+)
+
+// SoftStrap is a 32-bit soft strap of the descriptor, with the fields
+// known for its platform.
+type SoftStrap struct {
+	Section string
+	Index   int
+	// Name is the name of the strap in the datasheets, like PCHSTRP10.
+	Name   string
+	Value  uint32
+	Fields []StrapField `json:",omitempty"`
+}
+
+// StrapField is a field of a soft strap.
+type StrapField struct {
+	Name  string
+	Bit   uint
+	Width uint
+	Value uint32
+	// Meaning is what the value of the field means, if known.
+	Meaning string `json:",omitempty"`
+}
+
+// strapField describes a field of the soft straps of a platform. Only the
+// fields public documentation and ifdtool describe are known.
+type strapField struct {
+	section  string
+	index    int
+	name     string
+	bit      uint
+	width    uint
+	meanings map[uint32]string
+}
+
+var (
+	meDisableMeanings = map[uint32]string{0: "ME enabled", 1: "ME disabled"}
+
+	strapFields = map[StrapPlatform][]strapField{
+		StrapPlatformICH8:        ichStrapFields,
+		StrapPlatformICH9:        ichStrapFields,
+		StrapPlatformICH10:       ichStrapFields,
+		StrapPlatformIbexPeak:    pchStrapFields,
+		StrapPlatformCougarPoint: pchStrapFields,
+		StrapPlatformLynxPoint:   pchStrapFields,
+		StrapPlatformWildcat:     pchStrapFields,
+		StrapPlatformIFD2: {
+			{StrapSectionPCH, 0, "HAP", 16, 1, map[uint32]string{0: "ME enabled", 1: "ME disabled after bring-up (High Assurance Platform)"}},
+		},
+	}
+	ichStrapFields = []strapField{
+		{StrapSectionPCH, 0, "ICH_MeDisable", 0, 1, meDisableMeanings},
+		{StrapSectionCPU, 0, "MCH_MeDisable", 0, 1, meDisableMeanings},
+		{StrapSectionCPU, 0, "MCH_AltMeDisable", 7, 1, meDisableMeanings},
+	}
+	pchStrapFields = []strapField{
+		{StrapSectionPCH, 10, "AltMeDisable", 7, 1, meDisableMeanings},
+	}
+)
+
+// legacyLayout reports whether the descriptor is laid out as before
+// Skylake, as its component section tells.
+func (fd *FlashDescriptor) legacyLayout() bool {
+	c, err := fd.Component()
+	return err == nil && c.Params.LegacyLayout()
+}
+
+// StrapPlatform guesses the platform the soft straps are laid out for from
+// the numbers of straps and the bases of the ICC table and processor
+// straps, as ifdtool does. Platforms with the same layout cannot be told
+// apart.
+func (fd *FlashDescriptor) StrapPlatform() StrapPlatform {
+	d := fd.DescriptorMap
+	if d == nil {
+		return StrapPlatformUnknown
+	}
+	if !fd.legacyLayout() {
+		return StrapPlatformIFD2
+	}
+	isl, msl := d.NumberOfPchStraps, d.NumberOfProcStraps
+	switch {
+	case d.IccTableBase == 0:
+		switch {
+		case msl == 0 && isl <= 2:
+			return StrapPlatformICH8
+		case isl <= 2:
+			return StrapPlatformICH9
+		case isl <= 10:
+			return StrapPlatformICH10
+		}
+		return StrapPlatformIbexPeak
+	case d.IccTableBase < 0x31 && d.ProcStrapsBase < 0x30:
+		switch {
+		case msl == 0 && isl <= 17:
+			return StrapPlatformBayTrail
+		case msl <= 1 && isl <= 18:
+			return StrapPlatformCougarPoint
+		case msl <= 1 && isl <= 21:
+			return StrapPlatformLynxPoint
+		}
+		return StrapPlatformWildcat
+	}
+	return StrapPlatformUnknown
+}
+
+// strapName returns the name of strap i of section on platform p.
+func strapName(p StrapPlatform, section string, i int) string {
+	ich := p == StrapPlatformICH8 || p == StrapPlatformICH9 || p == StrapPlatformICH10
+	switch {
+	case section == StrapSectionPCH && ich:
+		return fmt.Sprintf("ICHSTRP%d", i)
+	case section == StrapSectionPCH:
+		return fmt.Sprintf("PCHSTRP%d", i)
+	case ich:
+		return fmt.Sprintf("MCHSTRP%d", i)
+	}
+	return fmt.Sprintf("PROCSTRP%d", i)
+}
+
+// readStraps reads n straps of section at base, in units of 16 bytes.
+func (fd *FlashDescriptor) readStraps(p StrapPlatform, section string, base, n uint8) ([]SoftStrap, error) {
+	start := uint(base) * 0x10
+	if start+uint(n)*4 > uint(len(fd.buf)) {
+		return nil, fmt.Errorf("%d %s straps at %#x out of the descriptor", n, section, start)
+	}
+	straps := make([]SoftStrap, n)
+	for i := range straps {
+		straps[i] = SoftStrap{
+			Section: section,
+			Index:   i,
+			Name:    strapName(p, section, i),
+			Value:   binary.LittleEndian.Uint32(fd.buf[start+uint(i)*4:]),
+		}
+	}
+	return straps, nil
+}
+
+// SoftStraps decodes the PCH and processor soft straps of the descriptor,
+// with the fields known for the platform StrapPlatform guesses.
+func (fd *FlashDescriptor) SoftStraps() ([]SoftStrap, error) {
+	d := fd.DescriptorMap
+	if d == nil {
+		return nil, fmt.Errorf("descriptor not parsed")
+	}
+	p := fd.StrapPlatform()
+	straps, err := fd.readStraps(p, StrapSectionPCH, d.PchStrapsBase, d.NumberOfPchStraps)
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := fd.readStraps(p, StrapSectionCPU, d.ProcStrapsBase, d.NumberOfProcStraps)
+	if err != nil {
+		return nil, err
+	}
+	straps = append(straps, cpu...)
+	for _, f := range strapFields[p] {
+		for i := range straps {
+			s := &straps[i]
+			if s.Section != f.section || s.Index != f.index {
+				continue
+			}
+			v := s.Value >> f.bit & (1<<f.width - 1)
+			s.Fields = append(s.Fields, StrapField{Name: f.name, Bit: f.bit, Width: f.width, Value: v, Meaning: f.meanings[v]})
+		}
+	}
+	return straps, nil
+}
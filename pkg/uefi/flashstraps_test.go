@@ -0,0 +1,105 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// withStraps sets the strap and ICC table fields of the descriptor map of
+// an image from ifdTestImage, with PCH straps at 0x100 and processor ones
+// at 0x200, and returns it.
+func withStraps(buf []byte, pch, proc, icc uint8, values map[uint]uint32) []byte {
+	copy(buf[26:], []byte{0x10, pch, 0x20, proc, icc})
+	for o, v := range values {
+		binary.LittleEndian.PutUint32(buf[o:], v)
+	}
+	return buf
+}
+
+func TestSoftStraps(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		buf      []byte
+		platform StrapPlatform
+		straps   int
+		fields   map[string]StrapField
+	}{
+		{
+			name:     "ICH9",
+			buf:      withStraps(ifdTestImage(), 1, 1, 0, map[uint]uint32{0x100: 1, 0x200: 0x80}),
+			platform: StrapPlatformICH9,
+			straps:   2,
+			fields: map[string]StrapField{
+				"ICHSTRP0": {Name: "ICH_MeDisable", Value: 1, Width: 1, Meaning: "ME disabled"},
+				"MCHSTRP0": {Name: "MCH_AltMeDisable", Bit: 7, Width: 1, Value: 1, Meaning: "ME disabled"},
+			},
+		},
+		{
+			name:     "Cougar Point",
+			buf:      withStraps(ifdTestImage(), 18, 1, 0x22, map[uint]uint32{0x128: 0x80}),
+			platform: StrapPlatformCougarPoint,
+			straps:   19,
+			fields: map[string]StrapField{
+				"PCHSTRP10": {Name: "AltMeDisable", Bit: 7, Width: 1, Value: 1, Meaning: "ME disabled"},
+			},
+		},
+		{
+			name:     "IFD v2",
+			buf:      withStraps(withComponents(ifdTestImage()), 0x40, 0, 0, map[uint]uint32{0x100: 0x10000}),
+			platform: StrapPlatformIFD2,
+			straps:   0x40,
+			fields: map[string]StrapField{
+				"PCHSTRP0": {Name: "HAP", Bit: 16, Width: 1, Value: 1, Meaning: "ME disabled after bring-up (High Assurance Platform)"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := NewFlashImage(test.buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p := f.IFD.StrapPlatform(); p != test.platform {
+				t.Errorf("got platform %q, want %q", p, test.platform)
+			}
+			straps, err := f.IFD.SoftStraps()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(straps) != test.straps {
+				t.Errorf("got %d straps, want %d", len(straps), test.straps)
+			}
+			for name, want := range test.fields {
+				var found bool
+				for _, s := range straps {
+					for _, f := range s.Fields {
+						if s.Name == name && f.Name == want.Name {
+							found = true
+							if f != want {
+								t.Errorf("got %s field %+v, want %+v", name, f, want)
+							}
+						}
+					}
+				}
+				if !found {
+					t.Errorf("got no %s field %s", name, want.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestSoftStrapsOutOfDescriptor(t *testing.T) {
+	buf := ifdTestImage()
+	copy(buf[26:], []byte{0xff, 0x40})
+	f, err := NewFlashImage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.IFD.SoftStraps(); err == nil {
+		t.Errorf("got no error for straps out of the descriptor")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// FVExtEntryType is the type of an entry of the extended header of a
+// firmware volume.
+type FVExtEntryType uint16
+
+// Entry types of the extended header, from the UEFI PI Spec volume 3,
+// 3.2.1.1.
+const (
+	FVExtEntryTypeOEM      FVExtEntryType = 0x01
+	FVExtEntryTypeGUID     FVExtEntryType = 0x02
+	FVExtEntryTypeUsedSize FVExtEntryType = 0x03
+)
+
+const fvExtEntryHeaderLen = 4
+
+// FirmwareVolumeExtEntry is an entry of the extended header of a firmware
+// volume. Entries of known types have their fields decoded, others keep
+// their data.
+type FirmwareVolumeExtEntry struct {
+	Type FVExtEntryType
+
+	// For FVExtEntryTypeOEM, the OEM file types the volume holds.
+	TypeMask uint32      `json:",omitempty"`
+	Types    []guid.GUID `json:",omitempty"`
+
+	// For FVExtEntryTypeGUID, the format of Data.
+	FormatType *guid.GUID `json:",omitempty"`
+
+	// For FVExtEntryTypeUsedSize, the bytes of the volume in use.
+	UsedSize uint32 `json:",omitempty"`
+
+	Data []byte `json:",omitempty"`
+}
+
+// parseFVExtEntries parses the entries of an extended header following its
+// fixed fields.
+func parseFVExtEntries(buf []byte) ([]FirmwareVolumeExtEntry, error) {
+	var entries []FirmwareVolumeExtEntry
+	for len(buf) > 0 {
+		if len(buf) < fvExtEntryHeaderLen {
+			return nil, fmt.Errorf("%d bytes left for a volume extended header entry", len(buf))
+		}
+		size := int(binary.LittleEndian.Uint16(buf))
+		if size < fvExtEntryHeaderLen || size > len(buf) {
+			return nil, fmt.Errorf("volume extended header entry of %d bytes in %d bytes", size, len(buf))
+		}
+		e := FirmwareVolumeExtEntry{Type: FVExtEntryType(binary.LittleEndian.Uint16(buf[2:]))}
+		data := buf[fvExtEntryHeaderLen:size]
+		switch {
+		case e.Type == FVExtEntryTypeOEM && len(data) >= 4 && (len(data)-4)%len(guid.GUID{}) == 0:
+			e.TypeMask = binary.LittleEndian.Uint32(data)
+			for d := data[4:]; len(d) > 0; d = d[len(guid.GUID{}):] {
+				var g guid.GUID
+				copy(g[:], d)
+				e.Types = append(e.Types, g)
+			}
+		case e.Type == FVExtEntryTypeGUID && len(data) >= len(guid.GUID{}):
+			e.FormatType = &guid.GUID{}
+			copy(e.FormatType[:], data)
+			e.Data = append([]byte{}, data[len(guid.GUID{}):]...)
+		case e.Type == FVExtEntryTypeUsedSize && len(data) == 4:
+			e.UsedSize = binary.LittleEndian.Uint32(data)
+		default:
+			e.Data = append([]byte{}, data...)
+		}
+		entries = append(entries, e)
+		buf = buf[size:]
+	}
+	return entries, nil
+}
+
+// buf returns the entry with its header.
+func (e *FirmwareVolumeExtEntry) buf() ([]byte, error) {
+	var data []byte
+	switch {
+	case e.Type == FVExtEntryTypeOEM && e.Data == nil:
+		data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, e.TypeMask)
+		for _, g := range e.Types {
+			data = append(data, g[:]...)
+		}
+	case e.Type == FVExtEntryTypeGUID && e.FormatType != nil:
+		data = append(append([]byte{}, e.FormatType[:]...), e.Data...)
+	case e.Type == FVExtEntryTypeUsedSize && e.Data == nil:
+		data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, e.UsedSize)
+	default:
+		data = e.Data
+	}
+	size := fvExtEntryHeaderLen + len(data)
+	if size > 0xFFFF {
+		return nil, fmt.Errorf("volume extended header entry of type %#x is too large: %d bytes", e.Type, size)
+	}
+	b := make([]byte, fvExtEntryHeaderLen, size)
+	binary.LittleEndian.PutUint16(b, uint16(size))
+	binary.LittleEndian.PutUint16(b[2:], uint16(e.Type))
+	return append(b, data...), nil
+}
+
+// ExtHeaderBuf returns the extended header of the volume generated from its
+// name and entries, and sets ExtHeaderSize to its size.
+func (fv *FirmwareVolume) ExtHeaderBuf() ([]byte, error) {
+	var entries []byte
+	for i := range fv.ExtEntries {
+		e, err := fv.ExtEntries[i].buf()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	fv.ExtHeaderSize = uint32(FirmwareVolumeExtHeaderMinSize + len(entries))
+	b := &bytes.Buffer{}
+	if err := binary.Write(b, binary.LittleEndian, &fv.FirmwareVolumeExtHeader); err != nil {
+		return nil, err
+	}
+	b.Write(entries)
+	return b.Bytes(), nil
+}
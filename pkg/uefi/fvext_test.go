@@ -0,0 +1,41 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+func TestFVExtEntries(t *testing.T) {
+	fv := &FirmwareVolume{}
+	fv.FVName = *guid.MustParse("763BED0D-DE9F-48F5-81F1-3E90E1B1A015")
+	fv.ExtEntries = []FirmwareVolumeExtEntry{
+		{Type: FVExtEntryTypeOEM, TypeMask: 0x3, Types: []guid.GUID{*FFS2, *FFS3}},
+		{Type: FVExtEntryTypeGUID, FormatType: FFS1, Data: []byte{1, 2, 3}},
+		{Type: FVExtEntryTypeUsedSize, UsedSize: 0x1000},
+		{Type: 0x42, Data: []byte{4, 5}},
+	}
+	buf, err := fv.ExtHeaderBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := FirmwareVolumeExtHeaderMinSize + 40 + 23 + 8 + 6; len(buf) != want || fv.ExtHeaderSize != uint32(want) {
+		t.Fatalf("got %d bytes, size %d, want %d", len(buf), fv.ExtHeaderSize, want)
+	}
+	got, err := parseFVExtEntries(buf[FirmwareVolumeExtHeaderMinSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fv.ExtEntries) {
+		t.Errorf("got %+v, want %+v", got, fv.ExtEntries)
+	}
+
+	if _, err := parseFVExtEntries([]byte{0x10, 0, 1, 0}); err == nil {
+		t.Errorf("parsing an entry past the header succeeded, want an error")
+	}
+}
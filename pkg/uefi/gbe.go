@@ -0,0 +1,114 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// GbE NVM layout, as described in the datasheets of the Intel integrated
+// LAN controllers (82566, 82567, 82579, I217, I219).
+const (
+	// GbEBankSize is the size of a copy of the GbE NVM. The region usually
+	// holds two of them, the controller uses the one with a valid signature.
+	GbEBankSize = 0x1000
+	// GbEChecksumSum is what the first 64 words of a bank add up to.
+	GbEChecksumSum = 0xbaba
+
+	gbeChecksumWord  = 0x3f
+	gbeSignatureWord = 0x13
+)
+
+var errNoValidGbEBank = errors.New("no GbE NVM bank with a valid signature")
+
+// GbE gives access to the NVM copies of a GbE region. It works on the
+// buffer of the region, so changes apply to it directly.
+type GbE struct {
+	buf []byte
+}
+
+// NewGbE returns a GbE for the buffer of a GbE region.
+func NewGbE(buf []byte) (*GbE, error) {
+	if len(buf) < GbEBankSize || len(buf)%GbEBankSize != 0 {
+		return nil, fmt.Errorf("GbE region size %#x is not a multiple of %#x", len(buf), GbEBankSize)
+	}
+	return &GbE{buf: buf}, nil
+}
+
+// Banks returns the number of NVM copies in the region.
+func (g *GbE) Banks() int {
+	return len(g.buf) / GbEBankSize
+}
+
+func (g *GbE) word(bank, i int) uint16 {
+	return binary.LittleEndian.Uint16(g.buf[bank*GbEBankSize+2*i:])
+}
+
+// SignatureValid reports whether the signature bits 15:14 of word 0x13 of
+// the bank are 10b, which makes the controller use it.
+func (g *GbE) SignatureValid(bank int) bool {
+	return g.word(bank, gbeSignatureWord)>>14 == 2
+}
+
+// ValidBank returns the first bank with a valid signature.
+func (g *GbE) ValidBank() (int, error) {
+	for b := 0; b < g.Banks(); b++ {
+		if g.SignatureValid(b) {
+			return b, nil
+		}
+	}
+	return -1, errNoValidGbEBank
+}
+
+// Checksum returns the checksum word which makes the bank valid.
+func (g *GbE) Checksum(bank int) uint16 {
+	var sum uint16
+	for i := 0; i < gbeChecksumWord; i++ {
+		sum += g.word(bank, i)
+	}
+	return GbEChecksumSum - sum
+}
+
+// ChecksumValid reports whether the checksum word of the bank is correct.
+func (g *GbE) ChecksumValid(bank int) bool {
+	return g.word(bank, gbeChecksumWord) == g.Checksum(bank)
+}
+
+// MAC returns the MAC address stored in the bank.
+func (g *GbE) MAC(bank int) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, g.buf[bank*GbEBankSize:])
+	return mac
+}
+
+// SetMAC sets the MAC address of every bank with a valid signature and
+// fixes their checksums.
+func (g *GbE) SetMAC(mac net.HardwareAddr) error {
+	if len(mac) != 6 {
+		return fmt.Errorf("MAC address %v is not 6 bytes long", mac)
+	}
+	if _, err := g.ValidBank(); err != nil {
+		return err
+	}
+	for b := 0; b < g.Banks(); b++ {
+		if g.SignatureValid(b) {
+			copy(g.buf[b*GbEBankSize:], mac)
+		}
+	}
+	g.FixChecksums()
+	return nil
+}
+
+// FixChecksums sets the checksum word of every bank with a valid signature.
+func (g *GbE) FixChecksums() {
+	for b := 0; b < g.Banks(); b++ {
+		if g.SignatureValid(b) {
+			binary.LittleEndian.PutUint16(g.buf[b*GbEBankSize+2*gbeChecksumWord:], g.Checksum(b))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// gbeTestRegion returns a GbE region of two banks, of which only the first
+// has a valid signature and checksum.
+func gbeTestRegion() []byte {
+	buf := bytes.Repeat([]byte{0xff}, 2*GbEBankSize)
+	copy(buf, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	binary.LittleEndian.PutUint16(buf[2*gbeSignatureWord:], 0x8000)
+	g := &GbE{buf: buf}
+	binary.LittleEndian.PutUint16(buf[2*gbeChecksumWord:], g.Checksum(0))
+	return buf
+}
+
+func TestGbE(t *testing.T) {
+	buf := gbeTestRegion()
+	g, err := NewGbE(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Banks() != 2 {
+		t.Errorf("Banks: got %d, want 2", g.Banks())
+	}
+	if b, err := g.ValidBank(); err != nil || b != 0 {
+		t.Errorf("ValidBank: got %d, %v, want 0", b, err)
+	}
+	if !g.ChecksumValid(0) || g.SignatureValid(1) {
+		t.Errorf("bank 0 should be valid and bank 1 not")
+	}
+	if mac := g.MAC(0).String(); mac != "00:11:22:33:44:55" {
+		t.Errorf("MAC: got %s, want 00:11:22:33:44:55", mac)
+	}
+
+	mac, _ := net.ParseMAC("02:aa:bb:cc:dd:ee")
+	if err := g.SetMAC(mac); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(g.MAC(0), mac) || !g.ChecksumValid(0) {
+		t.Errorf("SetMAC: got %v, checksum valid %v", g.MAC(0), g.ChecksumValid(0))
+	}
+	if !bytes.Equal(buf[GbEBankSize:], bytes.Repeat([]byte{0xff}, GbEBankSize)) {
+		t.Errorf("SetMAC modified the invalid bank")
+	}
+	var sum uint16
+	for i := 0; i <= gbeChecksumWord; i++ {
+		sum += binary.LittleEndian.Uint16(buf[2*i:])
+	}
+	if sum != GbEChecksumSum {
+		t.Errorf("checksum: words add up to %#x, want %#x", sum, GbEChecksumSum)
+	}
+
+	if err := g.SetMAC(mac[:4]); err == nil {
+		t.Errorf("SetMAC of 4 bytes: got nil, want error")
+	}
+	if _, err := NewGbE(buf[:0x1800]); err == nil {
+		t.Errorf("NewGbE of 0x1800 bytes: got nil, want error")
+	}
+	g, _ = NewGbE(bytes.Repeat([]byte{0xff}, GbEBankSize))
+	if err := g.SetMAC(mac); err != errNoValidGbEBank {
+		t.Errorf("SetMAC without valid bank: got %v, want %v", err, errNoValidGbEBank)
+	}
+}
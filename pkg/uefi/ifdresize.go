@@ -0,0 +1,86 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// unusedRegion is the FLREG value ifdtool writes for regions which are not
+// used.
+var unusedRegion = FlashRegion{Base: 0x7fff, Limit: 0}
+
+// ParseFlashRegionType returns the region type named s, ignoring case.
+func ParseFlashRegionType(s string) (FlashRegionType, error) {
+	for rt, n := range flashRegionTypeNames {
+		if strings.EqualFold(n, s) {
+			return rt, nil
+		}
+	}
+	return RegionTypeUnknown, fmt.Errorf("unknown flash region %q", s)
+}
+
+// ResizeRegion returns a copy of the buffer of the image in which the
+// region of type rt spans size bytes from offset base. Base and size must
+// be multiples of RegionBlockSize, and the region must not overlap the
+// descriptor or any other region. A size of 0 removes the region from the
+// descriptor. If move is set, the contents of the region are moved to the
+// new location, truncated or padded with 0xff as needed, and the space it
+// no longer covers is erased. Otherwise only the descriptor changes.
+func (f *FlashImage) ResizeRegion(rt FlashRegionType, base, size uint32, move bool) ([]byte, error) {
+	if rt < RegionTypeBIOS || int(rt) >= len(f.IFD.Region.FlashRegions) {
+		return nil, fmt.Errorf("no FLREG entry for region %v", rt)
+	}
+	if nr := int(f.IFD.DescriptorMap.NumberOfRegions); nr != 0 && int(rt) >= nr {
+		return nil, fmt.Errorf("descriptor only has %d regions, cannot set %v", nr, rt)
+	}
+	if base%RegionBlockSize != 0 || size%RegionBlockSize != 0 {
+		return nil, fmt.Errorf("base %#x and size %#x must be multiples of %#x", base, size, RegionBlockSize)
+	}
+	flashSize := uint64(len(f.buf))
+	nr := unusedRegion
+	if size != 0 {
+		if base < FlashDescriptorLength || uint64(base)+uint64(size) > flashSize {
+			return nil, fmt.Errorf("region [%#x, %#x) is not between the descriptor and the end of the flash at %#x",
+				base, uint64(base)+uint64(size), flashSize)
+		}
+		nr = FlashRegion{Base: uint16(base / RegionBlockSize), Limit: uint16((base+size)/RegionBlockSize - 1)}
+		regions := f.IFD.validRegions(flashSize)
+		for _, ot := range regionTypes(regions) {
+			or := regions[ot]
+			if ot != rt && nr.BaseOffset() < or.EndOffset() && or.BaseOffset() < nr.EndOffset() {
+				return nil, fmt.Errorf("%v region %v overlaps %v region %v", rt, &nr, ot, &or)
+			}
+		}
+	}
+
+	buf := make([]byte, len(f.buf))
+	copy(buf, f.buf)
+	o := f.IFD.RegionStart + 4 + 4*uint(rt)
+	binary.LittleEndian.PutUint16(buf[o:], nr.Base)
+	binary.LittleEndian.PutUint16(buf[o+2:], nr.Limit)
+
+	old := f.IFD.Region.FlashRegions[rt]
+	if !move || !old.Valid() || uint64(old.EndOffset()) > flashSize {
+		return buf, nil
+	}
+	contents := f.buf[old.BaseOffset():old.EndOffset()]
+	eraseFlash(buf[old.BaseOffset():old.EndOffset()])
+	if size != 0 {
+		n := copy(buf[nr.BaseOffset():nr.EndOffset()], contents)
+		eraseFlash(buf[nr.BaseOffset()+uint32(n) : nr.EndOffset()])
+	}
+	return buf, nil
+}
+
+// eraseFlash sets buf to the erased state of SPI flash, independently of
+// the erase polarity of firmware volumes.
+func eraseFlash(buf []byte) {
+	for i := range buf {
+		buf[i] = 0xff
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResizeRegion(t *testing.T) {
+	buf := ifdTestImage()
+	f, err := NewFlashImage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shrink ME, keeping its contents in place.
+	b, err := f.ResizeRegion(RegionTypeME, 0x3000, 0x2000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewFlashImage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.IFD.Region.FlashRegions[RegionTypeME]; got != (FlashRegion{Base: 3, Limit: 4}) {
+		t.Errorf("ME region: got %v, want [0x3, 0x4)", &got)
+	}
+	if !bytes.Equal(b[FlashDescriptorLength:], buf[FlashDescriptorLength:]) {
+		t.Errorf("resize without move changed region contents")
+	}
+
+	// Grow BIOS into the space freed.
+	b, err = n.ResizeRegion(RegionTypeBIOS, 0x5000, 0xb000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err = NewFlashImage(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.IFD.Region.FlashRegions[RegionTypeBIOS]; got != (FlashRegion{Base: 5, Limit: 15}) {
+		t.Errorf("BIOS region: got %v, want [0x5, 0xf)", &got)
+	}
+	if !bytes.Equal(b[0x3000:0x5000], buf[0x3000:0x5000]) || !IsErased(b[0x5000:], 0xff) {
+		t.Errorf("move did not relocate the BIOS region")
+	}
+
+	if _, err := f.ResizeRegion(RegionTypeGBE, 0x8000, 0x2000, true); err == nil {
+		t.Errorf("overlapping GbE and BIOS: got nil, want error")
+	}
+	b, err = f.ResizeRegion(RegionTypeEC, 0x1000, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[FlashDescriptorLength:], buf[FlashDescriptorLength:]) {
+		t.Errorf("removing an unused region changed region contents")
+	}
+	// Remove GbE and check its old location is erased.
+	b, err = f.ResizeRegion(RegionTypeGBE, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err = NewFlashImage(b); err != nil {
+		t.Fatal(err)
+	}
+	if n.IFD.Region.FlashRegions[RegionTypeGBE].Valid() || !IsErased(b[0x1000:0x3000], 0xff) {
+		t.Errorf("GbE region was not removed")
+	}
+
+	for _, c := range []struct {
+		rt         FlashRegionType
+		base, size uint32
+	}{
+		{RegionTypeME, 0x3000, 0x1800},
+		{RegionTypeME, 0, 0x2000},
+		{RegionTypeME, 0xf000, 0x2000},
+		{RegionTypeME, 0x2000, 0x2000},
+		{RegionTypeUnknown, 0x3000, 0x1000},
+	} {
+		if _, err := f.ResizeRegion(c.rt, c.base, c.size, false); err == nil {
+			t.Errorf("ResizeRegion(%v, %#x, %#x): got nil, want error", c.rt, c.base, c.size)
+		}
+	}
+}
+
+func TestParseFlashRegionType(t *testing.T) {
+	if rt, err := ParseFlashRegionType("gbe"); err != nil || rt != RegionTypeGBE {
+		t.Errorf("ParseFlashRegionType(gbe): got %v, %v, want GbE", rt, err)
+	}
+	if _, err := ParseFlashRegionType("foo"); err == nil {
+		t.Errorf("ParseFlashRegionType(foo): got nil, want error")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DescriptorFileName is the name ifdtool -x gives the file of the
+// descriptor.
+const DescriptorFileName = "flashregion_0_flashdescriptor.bin"
+
+// regionFileNames are the names ifdtool -x gives the region files. The
+// numbers are those of the FLREG registers, which is the region type + 1.
+var regionFileNames = map[FlashRegionType]string{
+	RegionTypeBIOS:      "flashregion_1_bios.bin",
+	RegionTypeME:        "flashregion_2_intel_me.bin",
+	RegionTypeGBE:       "flashregion_3_gbe.bin",
+	RegionTypePD:        "flashregion_4_platform_data.bin",
+	RegionTypeDevExp1:   "flashregion_5_device_exp.bin",
+	RegionTypeBIOS2:     "flashregion_6_bios2.bin",
+	RegionTypeMicrocode: "flashregion_7_reserved.bin",
+	RegionTypeEC:        "flashregion_8_ec.bin",
+	RegionTypeDevExp2:   "flashregion_9_device_exp.bin",
+	RegionTypeIE:        "flashregion_10_ie.bin",
+	RegionTypeTGBE1:     "flashregion_11_10gbe0.bin",
+	RegionTypeTGBE2:     "flashregion_12_10gbe1.bin",
+	RegionTypeReserved1: "flashregion_13_reserved.bin",
+	RegionTypeReserved2: "flashregion_14_reserved.bin",
+	RegionTypePTT:       "flashregion_15_ptt.bin",
+}
+
+// RegionFileName returns the name ifdtool -x gives the file of the region
+// of type rt.
+func RegionFileName(rt FlashRegionType) string {
+	return regionFileNames[rt]
+}
+
+// validRegions returns the regions of the descriptor which are within the
+// flash, keyed by type.
+func (fd *FlashDescriptor) validRegions(size uint64) map[FlashRegionType]FlashRegion {
+	regions := map[FlashRegionType]FlashRegion{}
+	nr := int(fd.DescriptorMap.NumberOfRegions)
+	for i, fr := range fd.Region.FlashRegions {
+		if nr != 0 && i >= nr {
+			break
+		}
+		if fr.Valid() && uint64(fr.EndOffset()) <= size {
+			regions[FlashRegionType(i)] = fr
+		}
+	}
+	return regions
+}
+
+// regionTypes returns the types of regions in ascending order, so they are
+// visited alike on every run.
+func regionTypes(regions map[FlashRegionType]FlashRegion) []FlashRegionType {
+	types := make([]FlashRegionType, 0, len(regions))
+	for rt := range regions {
+		types = append(types, rt)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// SplitRegions returns the descriptor and every valid region of the image,
+// keyed by the names ifdtool -x gives their files. The contents are taken
+// from the buffer of the image, so Assemble has to be applied first if the
+// image was modified.
+func (f *FlashImage) SplitRegions() map[string][]byte {
+	files := map[string][]byte{DescriptorFileName: f.buf[:FlashDescriptorLength]}
+	for rt, fr := range f.IFD.validRegions(uint64(len(f.buf))) {
+		files[RegionFileName(rt)] = f.buf[fr.BaseOffset():fr.EndOffset()]
+	}
+	return files
+}
+
+// MergeRegions returns a copy of the buffer of the image in which the
+// descriptor and regions are replaced by files, which are keyed like the
+// result of SplitRegions. Regions without a file are kept. If files holds
+// a descriptor, the regions are placed as it describes, and it has to
+// describe a flash of the same size. Every file must have exactly the size
+// of its region.
+func (f *FlashImage) MergeRegions(files map[string][]byte) ([]byte, error) {
+	buf := make([]byte, len(f.buf))
+	copy(buf, f.buf)
+
+	ifd := &f.IFD
+	if d, ok := files[DescriptorFileName]; ok {
+		if len(d) != FlashDescriptorLength {
+			return nil, fmt.Errorf("%s: size %#x, want %#x", DescriptorFileName, len(d), FlashDescriptorLength)
+		}
+		ifd = &FlashDescriptor{buf: d}
+		if err := ifd.ParseFlashDescriptor(); err != nil {
+			return nil, fmt.Errorf("%s: %v", DescriptorFileName, err)
+		}
+		copy(buf, d)
+	}
+	regions := ifd.validRegions(uint64(len(buf)))
+	names := map[string]FlashRegionType{}
+	for rt := range regions {
+		names[RegionFileName(rt)] = rt
+	}
+	// Copy in the order of the names, so files of overlapping regions
+	// merge alike on every run.
+	fileNames := make([]string, 0, len(files))
+	for n := range files {
+		fileNames = append(fileNames, n)
+	}
+	sort.Strings(fileNames)
+	for _, n := range fileNames {
+		if n == DescriptorFileName {
+			continue
+		}
+		d := files[n]
+		rt, ok := names[n]
+		if !ok {
+			return nil, fmt.Errorf("%s: no such region in the descriptor", n)
+		}
+		fr := regions[rt]
+		if size := fr.EndOffset() - fr.BaseOffset(); uint32(len(d)) != size {
+			return nil, fmt.Errorf("%s: size %#x, but the %v region %v has %#x bytes", n, len(d), rt, &fr, size)
+		}
+		copy(buf[fr.BaseOffset():], d)
+	}
+	return buf, nil
+}
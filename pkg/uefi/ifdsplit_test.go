@@ -0,0 +1,82 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// ifdTestImage returns a 64KiB PCH flash image with a descriptor, a GbE
+// region at 0x1000, an ME region at 0x3000 and a BIOS region at 0x8000.
+// Each region is filled with its type number.
+func ifdTestImage() []byte {
+	buf := bytes.Repeat([]byte{0xff}, 0x10000)
+	copy(buf, make([]byte, FlashDescriptorLength))
+	copy(buf[16:], FlashSignature)
+	// FLMAP0: RegionBase 0x40, FLMAP1: MasterBase 0x80
+	copy(buf[20:], []byte{0x03, 0x00, 0x04, 0x00, 0x08, 0x03})
+	regions := []FlashRegion{
+		RegionTypeBIOS: {Base: 8, Limit: 15},
+		RegionTypeME:   {Base: 3, Limit: 7},
+		RegionTypeGBE:  {Base: 1, Limit: 2},
+	}
+	for i, r := range regions {
+		binary.LittleEndian.PutUint16(buf[0x44+4*i:], r.Base)
+		binary.LittleEndian.PutUint16(buf[0x46+4*i:], r.Limit)
+		copy(buf[r.BaseOffset():r.EndOffset()], bytes.Repeat([]byte{byte(i)}, int(r.EndOffset()-r.BaseOffset())))
+	}
+	// Leave the BIOS region empty.
+	copy(buf[0x8000:], bytes.Repeat([]byte{0xff}, 0x8000))
+	return buf
+}
+
+func TestSplitMergeRegions(t *testing.T) {
+	buf := ifdTestImage()
+	f, err := NewFlashImage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := f.SplitRegions()
+	want := map[string][2]int{
+		DescriptorFileName:           {0, 0x1000},
+		"flashregion_1_bios.bin":     {0x8000, 0x10000},
+		"flashregion_2_intel_me.bin": {0x3000, 0x8000},
+		"flashregion_3_gbe.bin":      {0x1000, 0x3000},
+	}
+	if len(files) != len(want) {
+		t.Errorf("SplitRegions: got %d files, want %d", len(files), len(want))
+	}
+	for n, w := range want {
+		if !bytes.Equal(files[n], buf[w[0]:w[1]]) {
+			t.Errorf("SplitRegions: %s does not hold [%#x, %#x)", n, w[0], w[1])
+		}
+	}
+
+	gbe := bytes.Repeat([]byte{0x42}, 0x2000)
+	merged, err := f.MergeRegions(map[string][]byte{"flashregion_3_gbe.bin": gbe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(merged[0x1000:0x3000], gbe) || !bytes.Equal(merged[0x3000:], buf[0x3000:]) || !bytes.Equal(merged[:0x1000], buf[:0x1000]) {
+		t.Errorf("MergeRegions did not replace exactly the GbE region")
+	}
+	if bytes.Equal(f.Buf(), merged) {
+		t.Errorf("MergeRegions modified the image")
+	}
+
+	for _, files := range []map[string][]byte{
+		{"flashregion_3_gbe.bin": gbe[1:]},
+		{"flashregion_8_ec.bin": gbe},
+		{"foo.bin": gbe},
+		{DescriptorFileName: make([]byte, FlashDescriptorLength)},
+		{DescriptorFileName: buf[:0x800]},
+	} {
+		if _, err := f.MergeRegions(files); err == nil {
+			t.Errorf("MergeRegions(%d files): got nil, want error", len(files))
+		}
+	}
+}
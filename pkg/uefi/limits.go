@@ -0,0 +1,125 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+)
+
+// DefaultMaxDepth is the deepest nesting of volumes, files and sections
+// parsed by default. Real images nest about ten levels deep.
+const DefaultMaxDepth = 64
+
+// ParseLimits bound what parsing an image takes, so that malformed or
+// hostile images, like untrusted uploads, fail with an error wrapping
+// ErrParseLimit rather than exhausting the memory or the stack. Zero values
+// mean no limit.
+type ParseLimits struct {
+	// MaxDepth is the deepest nesting of volumes, files and sections.
+	MaxDepth int
+	// MaxDecompressedSize is the total size of the data decompressed while
+	// parsing an image.
+	MaxDecompressedSize uint64
+	// MaxNodeSize is the largest size a single section decompresses to.
+	MaxNodeSize uint64
+	// MaxNodes is the number of regions, volumes, files and sections of
+	// an image.
+	MaxNodes uint64
+}
+
+// defaultParseLimits are the limits of parses without ParseOptions.Limits.
+// Only the depth is limited.
+var defaultParseLimits = ParseLimits{MaxDepth: DefaultMaxDepth}
+
+// ErrParseLimit is the error wrapped by the errors of parsing beyond the
+// ParseLimits.
+var ErrParseLimit = errors.New("parse limit exceeded")
+
+// parseState is what a parse has taken so far.
+type parseState struct {
+	depth int
+	// decompressed and nodes are shared by the states of concurrent parses
+	// of the volumes of an image.
+	decompressed *uint64
+	nodes        *uint64
+}
+
+type parseStateKey struct{}
+
+// parseStateOf returns the state of the parse, adding it to the context if
+// the parse starts.
+func parseStateOf(ctx context.Context) (context.Context, *parseState) {
+	if st, ok := ctx.Value(parseStateKey{}).(*parseState); ok {
+		return ctx, st
+	}
+	st := &parseState{decompressed: new(uint64), nodes: new(uint64)}
+	return context.WithValue(ctx, parseStateKey{}, st), st
+}
+
+// forkParse returns the context of a parse running concurrently with the
+// others of the image, which has its own depth.
+func forkParse(ctx context.Context) context.Context {
+	ctx, st := parseStateOf(ctx)
+	return context.WithValue(ctx, parseStateKey{}, &parseState{depth: st.depth, decompressed: st.decompressed, nodes: st.nodes})
+}
+
+// enterNode checks that the parse may go one level deeper and take one more
+// node, and that ctx is not done. It returns the context of the parse, holding its state, and
+// the function to call when leaving the level.
+func enterNode(ctx context.Context) (context.Context, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	ctx, st := parseStateOf(ctx)
+	limits := parseOptionsOf(ctx).limits()
+	if limits.MaxDepth > 0 && st.depth >= limits.MaxDepth {
+		return nil, nil, fmt.Errorf("%w: nested deeper than %d levels", ErrParseLimit, limits.MaxDepth)
+	}
+	if n := atomic.AddUint64(st.nodes, 1); limits.MaxNodes > 0 && n > limits.MaxNodes {
+		return nil, nil, fmt.Errorf("%w: more than %d nodes", ErrParseLimit, limits.MaxNodes)
+	}
+	st.depth++
+	return ctx, func() { st.depth-- }, nil
+}
+
+// decompress decodes the data of a section with c, within the ParseLimits
+// of ctx.
+func decompress(ctx context.Context, c compression.Compressor, data []byte) ([]byte, error) {
+	_, st := parseStateOf(ctx)
+	limits := parseOptionsOf(ctx).limits()
+	max, total := limits.MaxNodeSize, false
+	if limits.MaxDecompressedSize > 0 {
+		var left uint64
+		if d := atomic.LoadUint64(st.decompressed); d < limits.MaxDecompressedSize {
+			left = limits.MaxDecompressedSize - d
+		}
+		if left == 0 {
+			return nil, fmt.Errorf("%w: decompressed more than %d bytes in all", ErrParseLimit, limits.MaxDecompressedSize)
+		}
+		if max == 0 || left < max {
+			max, total = left, true
+		}
+	}
+	b, err := compression.DecodeLimit(c, data, max)
+	if errors.Is(err, compression.ErrSizeLimit) {
+		if total {
+			return nil, fmt.Errorf("%w: decompressed more than %d bytes in all", ErrParseLimit, limits.MaxDecompressedSize)
+		}
+		return nil, fmt.Errorf("%w: section decompresses to more than %d bytes", ErrParseLimit, limits.MaxNodeSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Concurrent parses may each take what is left, so check the total.
+	if d := atomic.AddUint64(st.decompressed, uint64(len(b))); limits.MaxDecompressedSize > 0 && d > limits.MaxDecompressedSize {
+		return nil, fmt.Errorf("%w: decompressed more than %d bytes in all", ErrParseLimit, limits.MaxDecompressedSize)
+	}
+	return b, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestParseLimits(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		limits *ParseLimits
+		err    bool
+	}{
+		{"defaults", nil, false},
+		{"none", &ParseLimits{}, false},
+		{"large enough", &ParseLimits{MaxDepth: 16, MaxDecompressedSize: 16 << 20, MaxNodeSize: 16 << 20, MaxNodes: 1 << 16}, false},
+		// The DXE volume is compressed in a GUID-defined section of a
+		// file of a volume, and holds volumes itself.
+		{"depth", &ParseLimits{MaxDepth: 4}, true},
+		{"node size", &ParseLimits{MaxNodeSize: 1 << 20}, true},
+		{"decompressed size", &ParseLimits{MaxDecompressedSize: 1 << 20}, true},
+		{"nodes", &ParseLimits{MaxNodes: 64}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithParseOptions(context.Background(), &ParseOptions{Limits: tt.limits})
+			_, err := ParseContext(ctx, image)
+			if tt.err && !errors.Is(err, ErrParseLimit) {
+				t.Errorf("got %v, want %v", err, ErrParseLimit)
+			}
+			if !tt.err && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SchemaVersion is the version of the JSON MarshalFirmware writes. It
+// changes whenever older versions of fiano cannot read the JSON, or read
+// it wrongly, and a migration from the previous version is then added to
+// manifestMigrations.
+//
+// Versions:
+//
+//	1: JSON without SchemaVersion.
+//	2: ExtractPath is slash separated on every system.
+const SchemaVersion = 2
+
+// manifestMigrations migrate the FirmwareElement of JSON of the version of
+// their index to the next version, in place. It is decoded as generic JSON,
+// made of map[string]interface{}, []interface{} and values, numbers being
+// json.Number.
+var manifestMigrations = map[int]func(interface{}) error{
+	1: migrateSlashExtractPath,
+}
+
+// migrateSlashExtractPath replaces the backslashes of paths extracted on
+// Windows.
+func migrateSlashExtractPath(e interface{}) error {
+	walkJSONObjects(e, func(o map[string]interface{}) {
+		if p, ok := o["ExtractPath"].(string); ok {
+			o["ExtractPath"] = strings.ReplaceAll(p, `\`, "/")
+		}
+	})
+	return nil
+}
+
+// walkJSONObjects calls f on every object of generic JSON.
+func walkJSONObjects(e interface{}, f func(map[string]interface{})) {
+	switch e := e.(type) {
+	case map[string]interface{}:
+		f(e)
+		for _, v := range e {
+			walkJSONObjects(v, f)
+		}
+	case []interface{}:
+		for _, v := range e {
+			walkJSONObjects(v, f)
+		}
+	}
+}
+
+// MigrateManifest returns JSON written by MarshalFirmware of any version
+// migrated to SchemaVersion. JSON of a newer version is an error, since it
+// may hold what this version of fiano does not know about.
+func MigrateManifest(b []byte) ([]byte, error) {
+	var m marshalFirmware
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if m.SchemaVersion == 0 {
+		m.SchemaVersion = 1
+	}
+	if m.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("manifest schema version %d is newer than the supported version %d, update fiano",
+			m.SchemaVersion, SchemaVersion)
+	}
+	if m.SchemaVersion == SchemaVersion {
+		return b, nil
+	}
+
+	// Numbers are kept as json.Number, since 64-bit offsets and sizes do
+	// not fit the float64 of generic JSON.
+	d := json.NewDecoder(bytes.NewReader(m.FirmwareElement))
+	d.UseNumber()
+	var e interface{}
+	if err := d.Decode(&e); err != nil {
+		return nil, err
+	}
+	for ; m.SchemaVersion < SchemaVersion; m.SchemaVersion++ {
+		if err := manifestMigrations[m.SchemaVersion](e); err != nil {
+			return nil, fmt.Errorf("migrating manifest from schema version %d: %v", m.SchemaVersion, err)
+		}
+	}
+	fe, err := json.MarshalIndent(e, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	m.FirmwareElement = fe
+	return json.MarshalIndent(m, "", "    ")
+}
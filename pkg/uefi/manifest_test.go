@@ -0,0 +1,82 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMarshalFirmwareSchemaVersion(t *testing.T) {
+	j, err := MarshalFirmware(&Section{Name: "CHARLIE", ExtractPath: "a/b.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m marshalFirmware
+	if err := json.Unmarshal(j, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.SchemaVersion != SchemaVersion {
+		t.Errorf("got schema version %d, want %d", m.SchemaVersion, SchemaVersion)
+	}
+	f, err := UnmarshalFirmware(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := f.(*Section); s.Name != "CHARLIE" || s.ExtractPath != "a/b.bin" {
+		t.Errorf("got %q %q, want CHARLIE a/b.bin", s.Name, s.ExtractPath)
+	}
+}
+
+func TestUnmarshalFirmwareMigrates(t *testing.T) {
+	// A manifest of schema version 1, without version, extracted on Windows.
+	v1 := `{"FType": "*uefi.Section", "FirmwareElement": {"Name": "DELTA", "ExtractPath": "dir\\0\\sec.bin"}}`
+	f, err := UnmarshalFirmware([]byte(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := f.(*Section)
+	if !ok {
+		t.Fatalf("got %T; expected *uefi.Section", f)
+	}
+	if s.Name != "DELTA" || s.ExtractPath != "dir/0/sec.bin" {
+		t.Errorf("got %q %q, want DELTA dir/0/sec.bin", s.Name, s.ExtractPath)
+	}
+
+	m, err := MigrateManifest([]byte(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mf marshalFirmware
+	if err := json.Unmarshal(m, &mf); err != nil {
+		t.Fatal(err)
+	}
+	if mf.SchemaVersion != SchemaVersion {
+		t.Errorf("got schema version %d after migration, want %d", mf.SchemaVersion, SchemaVersion)
+	}
+}
+
+func TestMigrateManifestLargeNumbers(t *testing.T) {
+	v1 := `{"FType": "*uefi.FirmwareVolume", "FirmwareElement": {"FVOffset": 18446744073709551600, "ExtractPath": "fv\\0"}}`
+	f, err := UnmarshalFirmware([]byte(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv, ok := f.(*FirmwareVolume)
+	if !ok {
+		t.Fatalf("got %T; expected *uefi.FirmwareVolume", f)
+	}
+	if fv.FVOffset != 0xFFFFFFFFFFFFFFF0 || fv.ExtractPath != "fv/0" {
+		t.Errorf("got offset %#x and path %q, want 0xfffffffffffffff0 and fv/0", fv.FVOffset, fv.ExtractPath)
+	}
+}
+
+func TestUnmarshalFirmwareNewerVersion(t *testing.T) {
+	j := fmt.Sprintf(`{"SchemaVersion": %d, "FType": "*uefi.Section", "FirmwareElement": {}}`, SchemaVersion+1)
+	if _, err := UnmarshalFirmware([]byte(j)); err == nil {
+		t.Errorf("unmarshalling a newer schema version succeeded, want an error")
+	}
+}
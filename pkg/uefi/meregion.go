@@ -120,7 +120,7 @@ func (fp *MEFPT) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the MEFPT.
 func (fp *MEFPT) Apply(v Visitor) error {
-	return v.Visit(fp)
+	return visit(v, fp)
 }
 
 // ApplyChildren calls the visitor on each child node of MEFPT.
@@ -147,8 +147,7 @@ func NewMEFPT(buf []byte) (*MEFPT, error) {
 		return nil, fmt.Errorf("ME section (%#x) too small for %d entries in ME Flash Partition Table (%#x)", len(buf), fp.PartitionCount, l)
 	}
 
-	fp.buf = make([]byte, l)
-	copy(fp.buf, buf[:l])
+	fp.buf = copyBuf(buf[:l])
 	if err := fp.parsePartitions(); err != nil {
 		return nil, err
 	}
@@ -163,7 +162,10 @@ func (fp *MEFPT) parsePartitions() error {
 
 // MERegion implements Region for a raw chunk of bytes in the firmware image.
 type MERegion struct {
-	FPT *MEFPT
+	// Layout is the CSE layout table of IFWI 1.6+ regions, their $FPT is
+	// in the data partition.
+	Layout *CSELayout `json:",omitempty"`
+	FPT    *MEFPT
 	// holds the raw data
 	buf []byte
 	// Metadata for extraction and recovery
@@ -189,14 +191,28 @@ func (rr *MERegion) FlashRegion() (fr *FlashRegion) {
 // NewMERegion creates a new region.
 func NewMERegion(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error) {
 	rr := &MERegion{FRegion: r, RegionType: rt}
-	rr.buf = make([]byte, len(buf))
-	copy(rr.buf, buf)
+	rr.buf = copyBuf(buf)
 	fp, err := NewMEFPT(buf)
 	if err != nil {
-		log.Errorf("error parsing ME Flash Partition Table: %v", err)
-		return rr, nil
+		layout, lerr := NewCSELayout(buf)
+		if lerr != nil {
+			log.Errorf("error parsing ME Flash Partition Table: %v", err)
+			return rr, nil
+		}
+		rr.Layout = layout
+		d := layout.Data()
+		if fp, err = NewMEFPT(buf[d.Offset : d.Offset+d.Size]); err != nil {
+			log.Errorf("error parsing ME Flash Partition Table of the CSE data partition: %v", err)
+			rr.FreeSpaceOffset = layout.End()
+			return rr, nil
+		}
 	}
 	rr.FPT = fp
+	if rr.Layout != nil {
+		// The layout table covers the whole CSE, boot partitions included.
+		rr.FreeSpaceOffset = rr.Layout.End()
+		return rr, nil
+	}
 	// Compute FreeSpaceOffset
 	for _, p := range fp.Entries {
 		if p.OffsetIsValid() {
@@ -229,11 +245,16 @@ func (rr *MERegion) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the MERegion.
 func (rr *MERegion) Apply(v Visitor) error {
-	return v.Visit(rr)
+	return visit(v, rr)
 }
 
 // ApplyChildren calls the visitor on each child node of MERegion.
 func (rr *MERegion) ApplyChildren(v Visitor) error {
+	if rr.Layout != nil {
+		if err := rr.Layout.Apply(v); err != nil {
+			return err
+		}
+	}
 	if rr.FPT == nil {
 		return nil
 	}
@@ -0,0 +1,138 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ME firmware recognition: client CSME images have a recovery (FTPR)
+// partition, server (SPS) images have operational (OPR1, OPR2) partitions
+// next to it. The code partitions hold a manifest with their version.
+//
+// Manifest informations from me_cleaner.
+
+// MEManifestSignature is the tag of the manifest of an ME code partition,
+// ie "$MN2".
+var MEManifestSignature = []byte{0x24, 0x4d, 0x4e, 0x32}
+
+const (
+	// MEManifestTagOffset is the offset of the tag in the manifest header.
+	MEManifestTagOffset = 0x1c
+	// MEManifestVendorIntel is the vendor ID of the manifests.
+	MEManifestVendorIntel = 0x8086
+
+	// MEFirmwareCSME is the kind of an ME region holding client firmware.
+	MEFirmwareCSME = "CSME"
+	// MEFirmwareSPS is the kind of an ME region holding server platform
+	// services firmware.
+	MEFirmwareSPS = "SPS"
+)
+
+// MEVersion is the version of the manifest of an ME code partition.
+type MEVersion struct {
+	Major  uint16
+	Minor  uint16
+	Hotfix uint16
+	Build  uint16
+}
+
+func (v MEVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v.Major, v.Minor, v.Hotfix, v.Build)
+}
+
+// FindMEVersion returns the version of the first manifest of buf.
+func FindMEVersion(buf []byte) (MEVersion, bool) {
+	for o := 0; ; {
+		i := bytes.Index(buf[o:], MEManifestSignature)
+		if i < 0 {
+			return MEVersion{}, false
+		}
+		i += o
+		o = i + len(MEManifestSignature)
+		start := i - MEManifestTagOffset
+		if start < 0 || len(buf) < i+16 {
+			continue
+		}
+		// The vendor is at 0x10 in the manifest header.
+		if binary.LittleEndian.Uint32(buf[start+0x10:]) != MEManifestVendorIntel {
+			continue
+		}
+		return MEVersion{
+			Major:  binary.LittleEndian.Uint16(buf[i+8:]),
+			Minor:  binary.LittleEndian.Uint16(buf[i+10:]),
+			Hotfix: binary.LittleEndian.Uint16(buf[i+12:]),
+			Build:  binary.LittleEndian.Uint16(buf[i+14:]),
+		}, true
+	}
+}
+
+// MEPartitionRole returns "operational" for the operational partitions of
+// SPS firmware, "recovery" for the recovery partition and "" for the others.
+func MEPartitionRole(name string) string {
+	switch name {
+	case "OPR1", "OPR2":
+		return "operational"
+	case "FTPR":
+		return "recovery"
+	}
+	return ""
+}
+
+// Kind returns MEFirmwareSPS if the $FPT of the region has operational
+// partitions, MEFirmwareCSME otherwise, or "" without $FPT.
+func (rr *MERegion) Kind() string {
+	if rr.FPT == nil {
+		return ""
+	}
+	for _, p := range rr.FPT.Entries {
+		if MEPartitionRole(p.Name.String()) == "operational" {
+			return MEFirmwareSPS
+		}
+	}
+	return MEFirmwareCSME
+}
+
+// Partition returns the content of the partition of the $FPT named name.
+func (rr *MERegion) Partition(name string) ([]byte, error) {
+	if rr.FPT == nil {
+		return nil, fmt.Errorf("no ME Flash Partition Table")
+	}
+	for _, p := range rr.FPT.Entries {
+		if p.Name.String() != name {
+			continue
+		}
+		if !p.OffsetIsValid() {
+			return nil, fmt.Errorf("ME partition %s has no content", name)
+		}
+		end := uint64(p.Offset) + uint64(p.Length)
+		if end > uint64(len(rr.buf)) {
+			return nil, fmt.Errorf("ME partition %s [%#x, %#x) exceeds the ME region (%#x)", name, p.Offset, end, len(rr.buf))
+		}
+		return rr.buf[p.Offset:end], nil
+	}
+	return nil, fmt.Errorf("no ME partition %s", name)
+}
+
+// Version returns the version of the firmware of the region: that of the
+// first operational partition for SPS, of the recovery partition for CSME.
+func (rr *MERegion) Version() (MEVersion, bool) {
+	names := []string{"FTPR"}
+	if rr.Kind() == MEFirmwareSPS {
+		names = []string{"OPR1", "OPR2", "FTPR"}
+	}
+	for _, name := range names {
+		b, err := rr.Partition(name)
+		if err != nil {
+			continue
+		}
+		if v, ok := FindMEVersion(b); ok {
+			return v, true
+		}
+	}
+	return MEVersion{}, false
+}
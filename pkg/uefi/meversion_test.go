@@ -0,0 +1,83 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// meManifest returns a partition holding a manifest of version v at 0x40.
+func meManifest(v MEVersion) []byte {
+	b := make([]byte, 0x100)
+	binary.LittleEndian.PutUint32(b[0x40+0x10:], MEManifestVendorIntel)
+	copy(b[0x40+MEManifestTagOffset:], MEManifestSignature)
+	for i, n := range []uint16{v.Major, v.Minor, v.Hotfix, v.Build} {
+		binary.LittleEndian.PutUint16(b[0x40+MEManifestTagOffset+8+2*i:], n)
+	}
+	return b
+}
+
+// meImage returns an ME region with an $FPT listing the partitions names,
+// each holding a manifest of version v with its index as build number.
+func meImage(v MEVersion, names ...string) []byte {
+	buf := bytes.Repeat([]byte{0xff}, 0x1000*(len(names)+1))
+	copy(buf, make([]byte, 0x100))
+	copy(buf[0x10:], MEFTPSignature)
+	binary.LittleEndian.PutUint32(buf[0x14:], uint32(len(names)))
+	for i, name := range names {
+		e := buf[0x30+MEPartitionTableEntryLength*i:]
+		copy(e, name)
+		binary.LittleEndian.PutUint32(e[8:], uint32(0x1000*(i+1)))
+		binary.LittleEndian.PutUint32(e[12:], 0x1000)
+		v.Build = uint16(i)
+		copy(buf[0x1000*(i+1):], meManifest(v))
+	}
+	return buf
+}
+
+func TestFindMEVersion(t *testing.T) {
+	want := MEVersion{4, 1, 4, 339}
+	b := meManifest(want)
+	if v, ok := FindMEVersion(b); !ok || v != want {
+		t.Errorf("got version %v, %v, want %v", v, ok, want)
+	}
+	if s := want.String(); s != "4.1.4.339" {
+		t.Errorf("got %q, want 4.1.4.339", s)
+	}
+	binary.LittleEndian.PutUint32(b[0x50:], 0x1022)
+	if v, ok := FindMEVersion(b); ok {
+		t.Errorf("got version %v of a manifest of another vendor", v)
+	}
+}
+
+func TestMERegionKind(t *testing.T) {
+	for _, test := range []struct {
+		names   []string
+		kind    string
+		version MEVersion
+	}{
+		{[]string{"FTPR", "NFTP"}, MEFirmwareCSME, MEVersion{15, 0, 30, 0}},
+		{[]string{"FTPR", "OPR1", "OPR2"}, MEFirmwareSPS, MEVersion{4, 1, 4, 1}},
+	} {
+		t.Run(test.kind, func(t *testing.T) {
+			r, err := NewMERegion(meImage(test.version, test.names...), nil, RegionTypeME)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mer := r.(*MERegion)
+			if k := mer.Kind(); k != test.kind {
+				t.Errorf("got kind %q, want %q", k, test.kind)
+			}
+			if v, ok := mer.Version(); !ok || v != test.version {
+				t.Errorf("got version %v, %v, want %v", v, ok, test.version)
+			}
+			if _, err := mer.Partition("XXXX"); err == nil {
+				t.Errorf("got partition XXXX, want an error")
+			}
+		})
+	}
+}
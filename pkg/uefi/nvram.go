@@ -141,7 +141,7 @@ func (v *NVar) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the NVar.
 func (v *NVar) Apply(vr Visitor) error {
-	return vr.Visit(v)
+	return visit(vr, v)
 }
 
 // ApplyChildren calls the visitor on each child node of NVar.
@@ -180,7 +180,7 @@ func (s *NVarStore) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the NVarStore.
 func (s *NVarStore) Apply(v Visitor) error {
-	return v.Visit(s)
+	return visit(v, s)
 }
 
 // ApplyChildren calls the visitor on each child node of NVarStore.
@@ -448,9 +448,7 @@ func newNVar(buf []byte, offset uint64, s *NVarStore) (*NVar, error) {
 	}
 
 	// Copy out the buffer.
-	newBuf := buf[:v.Header.Size]
-	v.buf = make([]byte, v.Header.Size)
-	copy(v.buf, newBuf)
+	v.buf = copyBuf(buf[:v.Header.Size])
 
 	// Entry is marked as invalid
 	if !v.Header.Attributes.IsValid() {
@@ -569,8 +567,7 @@ func NewNVarStore(buf []byte) (*NVarStore, error) {
 	s := NVarStore{}
 
 	// Copy out the buffer.
-	s.buf = make([]byte, len(buf))
-	copy(s.buf, buf)
+	s.buf = copyBuf(buf)
 
 	s.Length = uint64(len(buf))
 	s.GUIDStoreOffset = s.Length
@@ -0,0 +1,340 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+)
+
+// PCI option ROM parsing, as described in the PCI Firmware Specification
+// 3.0 and the UEFI Specification, section 14.4.2 "PCI Option ROMs".
+
+var (
+	// OptionROMSignature starts every image of a PCI option ROM.
+	OptionROMSignature = []byte{0x55, 0xaa}
+	// PCIRSignature starts the PCI data structure of an image.
+	PCIRSignature = []byte("PCIR")
+)
+
+const (
+	// OptionROMBlockSize is the unit of the image lengths.
+	OptionROMBlockSize = 512
+	// EFIROMSignature identifies images holding an EFI driver.
+	EFIROMSignature = 0x0ef1
+
+	// EFIROMCompressed is the compression type of EFI images whose driver
+	// is compressed with EFI 1.1 compression.
+	EFIROMCompressed = 1
+
+	optionROMHeaderLength = 0x1a
+	initSizeOffset        = 0x02
+	pcirOffsetOffset      = 0x18
+	pcirImageLengthOffset = 0x10
+	pcirIndicatorOffset   = 0x15
+	pcirLastImage         = 0x80
+)
+
+// OptionROMCodeType is the type of code in an option ROM image.
+type OptionROMCodeType uint8
+
+// Code types of the PCI data structure.
+const (
+	CodeTypeX86            OptionROMCodeType = 0
+	CodeTypeOpenFirmware   OptionROMCodeType = 1
+	CodeTypeHPPARISC       OptionROMCodeType = 2
+	CodeTypeEFI            OptionROMCodeType = 3
+	CodeTypeNotImplemented OptionROMCodeType = 0xff
+)
+
+var codeTypeNames = map[OptionROMCodeType]string{
+	CodeTypeX86:            "Legacy",
+	CodeTypeOpenFirmware:   "OpenFirmware",
+	CodeTypeHPPARISC:       "HP PA RISC",
+	CodeTypeEFI:            "EFI",
+	CodeTypeNotImplemented: "Not implemented",
+}
+
+func (t OptionROMCodeType) String() string {
+	if s, ok := codeTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown (%#x)", uint8(t))
+}
+
+// ParseOptionROMCodeType returns the code type named s, ignoring case.
+func ParseOptionROMCodeType(s string) (OptionROMCodeType, error) {
+	for t, n := range codeTypeNames {
+		if strings.EqualFold(n, s) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown option ROM code type %q", s)
+}
+
+// PCIRHeader is the PCI data structure of an option ROM image.
+type PCIRHeader struct {
+	Signature             [4]uint8
+	VendorID              uint16
+	DeviceID              uint16
+	DeviceListOffset      uint16
+	Length                uint16
+	Revision              uint8
+	ClassCode             [3]uint8
+	ImageLength           uint16
+	CodeRevision          uint16
+	CodeType              OptionROMCodeType
+	Indicator             uint8
+	MaxRuntimeImageLength uint16
+	ConfigUtilityOffset   uint16
+	DMTFCLPOffset         uint16
+}
+
+// EFIROMHeader is the header of option ROM images holding an EFI driver.
+type EFIROMHeader struct {
+	Signature            uint16
+	InitializationSize   uint16
+	EFISignature         uint32
+	EFISubsystem         uint16
+	EFIMachineType       uint16
+	CompressionType      uint16
+	Reserved             [8]uint8
+	EFIImageHeaderOffset uint16
+	PCIROffset           uint16
+}
+
+// OptionROMImage is one image of a PCI option ROM.
+type OptionROMImage struct {
+	buf []byte
+	// Offset in the option ROM.
+	Offset uint64
+	PCIR   PCIRHeader
+	// For images of CodeTypeEFI.
+	EFI *EFIROMHeader `json:",omitempty"`
+
+	// Metadata for extraction and recovery
+	ExtractPath string
+}
+
+// OptionROM is a PCI option ROM, made of one or more images of different
+// code types, like a legacy and an EFI one.
+type OptionROM struct {
+	buf    []byte
+	Images []*OptionROMImage
+	// Offset in the parent.
+	Offset uint64
+	// Length is the length of the ROM in the parent when it was parsed. The
+	// data of the parent after it, like padding, stays in the parent.
+	Length uint64
+
+	// Metadata for extraction and recovery
+	ExtractPath string
+}
+
+// IsOptionROM returns true if buf starts with the signature of an option
+// ROM.
+func IsOptionROM(buf []byte) bool {
+	return bytes.HasPrefix(buf, OptionROMSignature)
+}
+
+// NewOptionROMImage parses the image at the start of buf. The image is not
+// copied.
+func NewOptionROMImage(buf []byte, offset uint64) (*OptionROMImage, error) {
+	if !IsOptionROM(buf) || len(buf) < optionROMHeaderLength {
+		return nil, errors.New("no option ROM signature")
+	}
+	img := &OptionROMImage{Offset: offset}
+	po := int(binary.LittleEndian.Uint16(buf[pcirOffsetOffset:]))
+	if po+binary.Size(img.PCIR) > len(buf) {
+		return nil, fmt.Errorf("PCI data structure at %#x out of the image", po)
+	}
+	if err := binary.Read(bytes.NewReader(buf[po:]), binary.LittleEndian, &img.PCIR); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(img.PCIR.Signature[:], PCIRSignature) {
+		return nil, fmt.Errorf("no PCIR signature at %#x", po)
+	}
+	l := int(img.PCIR.ImageLength) * OptionROMBlockSize
+	if l == 0 || l > len(buf) {
+		return nil, fmt.Errorf("image length %#x out of the buffer of %#x bytes", l, len(buf))
+	}
+	img.buf = buf[:l]
+	if img.PCIR.CodeType == CodeTypeEFI {
+		efi := &EFIROMHeader{}
+		if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, efi); err != nil {
+			return nil, err
+		}
+		if efi.EFISignature != EFIROMSignature {
+			return nil, fmt.Errorf("EFI image without EFI signature, got %#x", efi.EFISignature)
+		}
+		img.EFI = efi
+	}
+	return img, nil
+}
+
+// NewOptionROM parses the images of the option ROM at the start of buf.
+// The buffer is copied.
+func NewOptionROM(buf []byte, offset uint64) (*OptionROM, error) {
+	r := &OptionROM{Offset: offset}
+	var o uint64
+	for {
+		img, err := NewOptionROMImage(buf[o:], o)
+		if err != nil {
+			return nil, fmt.Errorf("option ROM image at %#x: %v", o, err)
+		}
+		r.Images = append(r.Images, img)
+		o += uint64(len(img.buf))
+		if img.Last() || o >= uint64(len(buf)) || !IsOptionROM(buf[o:]) {
+			break
+		}
+	}
+	r.buf = copyBuf(buf[:o])
+	r.Length = o
+	for _, img := range r.Images {
+		img.buf = r.buf[img.Offset : img.Offset+uint64(len(img.buf))]
+	}
+	return r, nil
+}
+
+// Last returns true if the image is marked as the last one of the ROM.
+func (img *OptionROMImage) Last() bool {
+	return img.PCIR.Indicator&pcirLastImage != 0
+}
+
+// SetLast marks the image as the last one of the ROM or not.
+func (img *OptionROMImage) SetLast(last bool) {
+	img.PCIR.Indicator &^= pcirLastImage
+	if last {
+		img.PCIR.Indicator |= pcirLastImage
+	}
+	po := binary.LittleEndian.Uint16(img.buf[pcirOffsetOffset:])
+	img.buf[int(po)+pcirIndicatorOffset] = img.PCIR.Indicator
+}
+
+// Compressed returns true if the image holds an EFI driver compressed with
+// EFI 1.1 compression.
+func (img *OptionROMImage) Compressed() bool {
+	return img.EFI != nil && img.EFI.CompressionType == EFIROMCompressed
+}
+
+// Driver returns the EFI driver of the image, decompressed if needed.
+func (img *OptionROMImage) Driver() ([]byte, error) {
+	if img.EFI == nil {
+		return nil, fmt.Errorf("%v image holds no EFI driver", img.PCIR.CodeType)
+	}
+	end := int(img.EFI.InitializationSize) * OptionROMBlockSize
+	if end > len(img.buf) {
+		end = len(img.buf)
+	}
+	start := int(img.EFI.EFIImageHeaderOffset)
+	if start >= end {
+		return nil, fmt.Errorf("EFI driver at %#x out of the image of %#x bytes", start, end)
+	}
+	if !img.Compressed() {
+		return img.buf[start:end], nil
+	}
+	return (&compression.EFI{}).Decode(img.buf[start:end])
+}
+
+// SetDriver replaces the EFI driver of the image, compressing it if the
+// image was compressed, and resizes the image.
+func (img *OptionROMImage) SetDriver(driver []byte) error {
+	if img.EFI == nil {
+		return fmt.Errorf("%v image holds no EFI driver", img.PCIR.CodeType)
+	}
+	start := int(img.EFI.EFIImageHeaderOffset)
+	po := int(img.EFI.PCIROffset)
+	if po+binary.Size(img.PCIR) > start {
+		return fmt.Errorf("PCI data structure at %#x overlaps the EFI driver at %#x", po, start)
+	}
+	if img.Compressed() {
+		var err error
+		if driver, err = (&compression.EFI{}).Encode(driver); err != nil {
+			return err
+		}
+	}
+	l := Align(uint64(start+len(driver)), OptionROMBlockSize)
+	if l/OptionROMBlockSize > 0xffff {
+		return fmt.Errorf("EFI driver of %#x bytes too large for an option ROM image", len(driver))
+	}
+	buf := make([]byte, l)
+	copy(buf, img.buf[:start])
+	copy(buf[start:], driver)
+	img.EFI.InitializationSize = uint16(l / OptionROMBlockSize)
+	img.PCIR.ImageLength = img.EFI.InitializationSize
+	binary.LittleEndian.PutUint16(buf[initSizeOffset:], img.EFI.InitializationSize)
+	binary.LittleEndian.PutUint16(buf[po+pcirImageLengthOffset:], img.PCIR.ImageLength)
+	img.buf = buf
+	return nil
+}
+
+// RemoveImages removes the images of code type t. The indicator of the
+// last image is updated by Assemble. It returns the number of images
+// removed.
+func (r *OptionROM) RemoveImages(t OptionROMCodeType) int {
+	var images []*OptionROMImage
+	for _, img := range r.Images {
+		if img.PCIR.CodeType != t {
+			images = append(images, img)
+		}
+	}
+	n := len(r.Images) - len(images)
+	r.Images = images
+	return n
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (img *OptionROMImage) Buf() []byte {
+	return img.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (img *OptionROMImage) SetBuf(buf []byte) {
+	img.buf = buf
+}
+
+// Apply calls the visitor on the OptionROMImage.
+func (img *OptionROMImage) Apply(v Visitor) error {
+	return visit(v, img)
+}
+
+// ApplyChildren calls the visitor on each child node of OptionROMImage.
+func (img *OptionROMImage) ApplyChildren(v Visitor) error {
+	return nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (r *OptionROM) Buf() []byte {
+	return r.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (r *OptionROM) SetBuf(buf []byte) {
+	r.buf = buf
+}
+
+// Apply calls the visitor on the OptionROM.
+func (r *OptionROM) Apply(v Visitor) error {
+	return visit(v, r)
+}
+
+// ApplyChildren calls the visitor on each child node of OptionROM.
+func (r *OptionROM) ApplyChildren(v Visitor) error {
+	for _, img := range r.Images {
+		if err := img.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// optionROMImage returns an image of one block for device 8086:1234.
+func optionROMImage(t OptionROMCodeType, last bool) []byte {
+	buf := make([]byte, OptionROMBlockSize)
+	copy(buf, OptionROMSignature)
+	buf[2] = 1
+	if t == CodeTypeEFI {
+		binary.LittleEndian.PutUint32(buf[4:], EFIROMSignature)
+		binary.LittleEndian.PutUint16(buf[8:], 0xb)
+		binary.LittleEndian.PutUint16(buf[10:], 0x8664)
+	}
+	binary.LittleEndian.PutUint16(buf[pcirOffsetOffset:], 0x1c)
+	p := PCIRHeader{VendorID: 0x8086, DeviceID: 0x1234, Length: 0x18, Revision: 3, ImageLength: 1, CodeType: t}
+	copy(p.Signature[:], PCIRSignature)
+	if last {
+		p.Indicator = pcirLastImage
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &p)
+	copy(buf[0x1c:], b.Bytes())
+	return buf
+}
+
+func TestOptionROMSection(t *testing.T) {
+	rom := append(optionROMImage(CodeTypeX86, false), optionROMImage(CodeTypeEFI, true)...)
+	s, err := CreateSection(SectionTypeRaw, rom, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewSection(s.Buf(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.OptionROM
+	if r == nil {
+		t.Fatal("raw section: no option ROM")
+	}
+	if r.Offset != s.HeaderLen() || !bytes.Equal(r.Buf(), rom) {
+		t.Errorf("option ROM at %#x of %#x bytes, want at %#x of %#x bytes", r.Offset, len(r.Buf()), s.HeaderLen(), len(rom))
+	}
+	if len(r.Images) != 2 {
+		t.Fatalf("got %d images, want 2", len(r.Images))
+	}
+	if img := r.Images[0]; img.PCIR.CodeType != CodeTypeX86 || img.Last() || img.EFI != nil {
+		t.Errorf("image 0: got %v, last %v", img.PCIR.CodeType, img.Last())
+	}
+	img := r.Images[1]
+	if img.PCIR.CodeType != CodeTypeEFI || !img.Last() || img.Offset != OptionROMBlockSize || img.EFI == nil || img.EFI.EFIMachineType != 0x8664 {
+		t.Errorf("image 1: got %v at %#x, last %v, EFI header %+v", img.PCIR.CodeType, img.Offset, img.Last(), img.EFI)
+	}
+
+	if n := r.RemoveImages(CodeTypeX86); n != 1 || len(r.Images) != 1 {
+		t.Errorf("RemoveImages: removed %d, %d left, want 1 and 1", n, len(r.Images))
+	}
+	img.SetLast(false)
+	if r, err := NewOptionROM(img.Buf(), 0); err != nil || r.Images[0].Last() {
+		t.Errorf("SetLast(false): got %v", err)
+	}
+}
+
+func TestOptionROMErrors(t *testing.T) {
+	img := optionROMImage(CodeTypeEFI, true)
+	for _, b := range [][]byte{
+		img[:0x10],
+		img[:0x100],
+		append([]byte{0x55, 0xaa, 1, 0}, make([]byte, 0x1fc)...),
+		append(append([]byte{}, img[:4]...), append([]byte{0, 0}, img[6:]...)...),
+	} {
+		if _, err := NewOptionROM(b, 0); err == nil {
+			t.Errorf("NewOptionROM(% x...): got nil, want error", b[:8])
+		}
+	}
+	if _, err := ParseOptionROMCodeType("legacy"); err != nil {
+		t.Errorf("ParseOptionROMCodeType(legacy): got %v", err)
+	}
+}
+
+func TestOptionROMDriver(t *testing.T) {
+	buf := optionROMImage(CodeTypeEFI, true)
+	binary.LittleEndian.PutUint16(buf[0x0c:], EFIROMCompressed)
+	binary.LittleEndian.PutUint16(buf[0x16:], 0x38)
+	img, err := NewOptionROMImage(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !img.Compressed() {
+		t.Fatalf("image not compressed")
+	}
+	driver := bytes.Repeat([]byte("MZ driver code "), 0x200)
+	if err := img.SetDriver(driver); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(img.Buf()); l%OptionROMBlockSize != 0 || l != int(img.PCIR.ImageLength)*OptionROMBlockSize || l >= len(driver) {
+		t.Errorf("SetDriver: image of %#x bytes, image length %#x blocks", l, img.PCIR.ImageLength)
+	}
+	img, err = NewOptionROMImage(img.Buf(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := img.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, driver) || img.EFI.InitializationSize != img.PCIR.ImageLength {
+		t.Errorf("Driver: got %#x bytes, want the %#x bytes set", len(got), len(driver))
+	}
+
+	legacy, _ := NewOptionROMImage(optionROMImage(CodeTypeX86, true), 0)
+	if _, err := legacy.Driver(); err == nil {
+		t.Errorf("Driver of a legacy image: got nil, want error")
+	}
+}
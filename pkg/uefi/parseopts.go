@@ -0,0 +1,110 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// ParseOptions are the options of one parse, set on its context with
+// WithParseOptions, so that tools sharing a process may parse differently.
+type ParseOptions struct {
+	// SupportedFiles are the file types whose sections are parsed, the
+	// others are opaque binary blobs. Nil means the package-level
+	// SupportedFiles.
+	SupportedFiles map[FVFileType]bool
+	// Limits bound what the parse takes. Nil means only the depth is
+	// limited, to DefaultMaxDepth.
+	Limits *ParseLimits
+	// Workers is the number of firmware volumes of a BIOS region parsed
+	// concurrently, GOMAXPROCS if 0. They are parsed one after the other
+	// if it is 1 or less.
+	Workers int
+	// Externals are the External compressors decoding the sections of
+	// their GUID.
+	Externals map[guid.GUID]*compression.External
+}
+
+type parseOptionsKey struct{}
+
+// WithParseOptions returns a context parsing with opts.
+func WithParseOptions(ctx context.Context, opts *ParseOptions) context.Context {
+	return context.WithValue(ctx, parseOptionsKey{}, opts)
+}
+
+// ParseOptionsOf returns the options of the parses of ctx, the defaults if
+// it has none.
+func ParseOptionsOf(ctx context.Context) *ParseOptions {
+	return parseOptionsOf(ctx)
+}
+
+// parseOptionsOf returns the options of the parse, the defaults if ctx has
+// none.
+func parseOptionsOf(ctx context.Context) *ParseOptions {
+	if opts, ok := ctx.Value(parseOptionsKey{}).(*ParseOptions); ok && opts != nil {
+		return opts
+	}
+	return &ParseOptions{}
+}
+
+// parsesSections reports whether the sections of files of type t are parsed.
+func (o *ParseOptions) parsesSections(t FVFileType) bool {
+	if o.SupportedFiles == nil {
+		return SupportedFiles[t]
+	}
+	return o.SupportedFiles[t]
+}
+
+// limits returns the limits of the parse.
+func (o *ParseOptions) limits() *ParseLimits {
+	if o.Limits == nil {
+		return &defaultParseLimits
+	}
+	return o.Limits
+}
+
+// workers returns the number of volumes parsed concurrently.
+func (o *ParseOptions) workers() int {
+	if o.Workers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Workers
+}
+
+// DefaultSupportedFiles returns a copy of the package-level SupportedFiles,
+// to change for ParseOptions.
+func DefaultSupportedFiles() map[FVFileType]bool {
+	types := make(map[FVFileType]bool, len(SupportedFiles))
+	for t, ok := range SupportedFiles {
+		if ok {
+			types[t] = true
+		}
+	}
+	return types
+}
+
+// ParseFileTypes parses a comma separated list of file types, like
+// "PEIM,DRIVER", with or without the EFI_FV_FILETYPE_ prefix and in any case.
+func ParseFileTypes(s string) ([]FVFileType, error) {
+	var types []FVFileType
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		t, ok := NamesToFileType[strings.TrimPrefix(strings.ToUpper(name), "EFI_FV_FILETYPE_")]
+		if !ok {
+			return nil, fmt.Errorf("unknown file type %q", name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// parsedFiles counts the files of each type whose sections were parsed.
+type parsedFiles map[FVFileType]int
+
+func (p parsedFiles) Run(f Firmware) error { return f.Apply(p) }
+
+func (p parsedFiles) Visit(f Firmware) error {
+	if file, ok := f.(*File); ok && len(file.Sections) > 0 {
+		p[file.Header.Type]++
+	}
+	return f.ApplyChildren(p)
+}
+
+func TestParseOptions(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(opts *ParseOptions) parsedFiles {
+		t.Helper()
+		ctx := context.Background()
+		if opts != nil {
+			ctx = WithParseOptions(ctx, opts)
+		}
+		f, err := ParseContext(ctx, image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := parsedFiles{}
+		if err := p.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	defaults := parse(nil)
+	if defaults[FVFileTypePEIM] == 0 || defaults[FVFileTypeDriver] == 0 {
+		t.Fatalf("got %v parsed by default, want PEIMs and drivers", defaults)
+	}
+
+	opaque := DefaultSupportedFiles()
+	delete(opaque, FVFileTypePEIM)
+	got := parse(&ParseOptions{SupportedFiles: opaque})
+	if got[FVFileTypePEIM] != 0 || got[FVFileTypeDriver] != defaults[FVFileTypeDriver] {
+		t.Errorf("got %v parsed with opaque PEIMs, want the drivers of %v", got, defaults)
+	}
+
+	// Other parses keep the defaults.
+	if got := parse(&ParseOptions{}); !reflect.DeepEqual(got, defaults) {
+		t.Errorf("got %v parsed with empty options, want %v", got, defaults)
+	}
+}
+
+func TestParseFileTypes(t *testing.T) {
+	got, err := ParseFileTypes("PEIM, efi_fv_filetype_driver,,dxe_core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FVFileType{FVFileTypePEIM, FVFileTypeDriver, FVFileTypeDXECore}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := ParseFileTypes("PEIM,BOGUS"); err == nil {
+		t.Error("parsing an unknown type succeeded, want an error")
+	}
+}
@@ -14,6 +14,8 @@ type RawRegion struct {
 	FRegion *FlashRegion
 	// Region Type as per the IFD
 	RegionType FlashRegionType
+	// EC firmware found in the EC region
+	EC *ECFirmware `json:",omitempty"`
 }
 
 // SetFlashRegion sets the flash region.
@@ -29,8 +31,10 @@ func (rr *RawRegion) FlashRegion() (fr *FlashRegion) {
 // NewRawRegion creates a new region.
 func NewRawRegion(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error) {
 	rr := &RawRegion{FRegion: r, RegionType: rt}
-	rr.buf = make([]byte, len(buf))
-	copy(rr.buf, buf)
+	rr.buf = copyBuf(buf)
+	if rt == RegionTypeEC {
+		rr.EC = FindECFirmware(rr.buf)
+	}
 	return rr, nil
 }
 
@@ -53,10 +57,13 @@ func (rr *RawRegion) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the RawRegion.
 func (rr *RawRegion) Apply(v Visitor) error {
-	return v.Visit(rr)
+	return visit(v, rr)
 }
 
 // ApplyChildren calls the visitor on each child node of RawRegion.
 func (rr *RawRegion) ApplyChildren(v Visitor) error {
-	return nil
+	if rr.EC == nil {
+		return nil
+	}
+	return rr.EC.Apply(v)
 }
@@ -0,0 +1,216 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Base relocation types of the PE/COFF specification.
+const (
+	relBasedAbsolute = 0
+	relBasedHigh     = 1
+	relBasedLow      = 2
+	relBasedHighLow  = 3
+	relBasedDir64    = 10
+)
+
+// XIPTypes are the types of files whose executables run in place from
+// flash, which GenFv rebases to their address.
+var XIPTypes = map[FVFileType]bool{
+	FVFileTypeSECCore:            true,
+	FVFileTypePEICore:            true,
+	FVFileTypePEIM:               true,
+	FVFileTypeCombinedPEIMDriver: true,
+}
+
+// peImage locates the fields of a PE32(+) or TE image which rebasing
+// changes.
+type peImage struct {
+	buf []byte
+	// imageBase is the offset of the image base field, which is 32 bits
+	// wide in PE32 images.
+	imageBase int
+	is32      bool
+	// delta turns offsets in the PE image into offsets in buf, and is not
+	// zero for TE images, whose PE headers were stripped.
+	delta int
+	// relocs is the base relocation directory.
+	relocRVA, relocSize uint32
+	sectionTable        int
+	nSections           int
+}
+
+func newPEImage(buf []byte) (*peImage, error) {
+	u16 := func(o int) int { return int(binary.LittleEndian.Uint16(buf[o:])) }
+	u32 := func(o int) uint32 { return binary.LittleEndian.Uint32(buf[o:]) }
+
+	switch {
+	case len(buf) >= teHeaderLen && bytes.HasPrefix(buf, teSignature):
+		img := &peImage{
+			buf:          buf,
+			imageBase:    16,
+			delta:        teHeaderLen - u16(6),
+			relocRVA:     u32(24),
+			relocSize:    u32(28),
+			sectionTable: teHeaderLen,
+			nSections:    int(buf[4]),
+		}
+		if img.sectionTable+img.nSections*peSectionLen > len(buf) {
+			return nil, errors.New("TE section table out of the image")
+		}
+		return img, nil
+
+	case len(buf) > peOffsetOffset+4 && bytes.HasPrefix(buf, mzSignature):
+		pe := int(u32(peOffsetOffset))
+		opt := pe + len(peSignature) + coffHeaderLen
+		if pe < 0 || opt+peOptHeader32Len > len(buf) || !bytes.Equal(buf[pe:pe+4], peSignature) {
+			return nil, errors.New("no PE signature")
+		}
+		img := &peImage{
+			buf:          buf,
+			sectionTable: opt + u16(pe+4+16),
+			nSections:    u16(pe + 4 + 2),
+		}
+		var rvaCountOffset int
+		switch magic := u16(opt); magic {
+		case peMagic32:
+			rvaCountOffset, img.imageBase, img.is32 = 92, opt+28, true
+		case peMagic64:
+			rvaCountOffset, img.imageBase = 108, opt+24
+		default:
+			return nil, fmt.Errorf("unknown optional header magic %#x", magic)
+		}
+		if img.sectionTable+img.nSections*peSectionLen > len(buf) {
+			return nil, errors.New("PE headers out of the image")
+		}
+		entry := opt + rvaCountOffset + 4 + peBaseRelocDir*peDataDirEntryLen
+		if int(u32(opt+rvaCountOffset)) > peBaseRelocDir && entry+peDataDirEntryLen <= img.sectionTable {
+			img.relocRVA, img.relocSize = u32(entry), u32(entry+4)
+		}
+		return img, nil
+	}
+	return nil, errors.New("neither a PE32 nor a TE image")
+}
+
+// offset returns the offset in the image of n bytes at the address rva.
+func (img *peImage) offset(rva uint32, n int) (int, error) {
+	o := int(rva)
+	for i := 0; i < img.nSections; i++ {
+		s := img.buf[img.sectionTable+i*peSectionLen:]
+		va, vsize := binary.LittleEndian.Uint32(s[12:]), binary.LittleEndian.Uint32(s[8:])
+		rawSize, raw := binary.LittleEndian.Uint32(s[16:]), binary.LittleEndian.Uint32(s[20:])
+		if rva >= va && rva-va < rawSize && rva-va < vsize {
+			o = int(rva - va + raw)
+			break
+		}
+	}
+	o += img.delta
+	if o < 0 || o+n > len(img.buf) {
+		return 0, fmt.Errorf("address %#x is not in the image", rva)
+	}
+	return o, nil
+}
+
+func (img *peImage) base() uint64 {
+	if img.is32 {
+		return uint64(binary.LittleEndian.Uint32(img.buf[img.imageBase:]))
+	}
+	return binary.LittleEndian.Uint64(img.buf[img.imageBase:])
+}
+
+// XIPAddress returns the address in flash the PE32(+) or TE image in buf
+// runs from, according to its image base.
+func XIPAddress(buf []byte) (uint64, error) {
+	img, err := newPEImage(buf)
+	if err != nil {
+		return 0, err
+	}
+	return img.base() - uint64(img.delta), nil
+}
+
+// RebaseXIP relocates the PE32(+) or TE image in buf, in place, to run from
+// addr, the address of buf in flash, and reports if it changed. Images
+// without base relocations cannot move.
+func RebaseXIP(buf []byte, addr uint64) (bool, error) {
+	img, err := newPEImage(buf)
+	if err != nil {
+		return false, err
+	}
+	// The image base of TE images is the address of the PE image they were.
+	newBase := addr + uint64(img.delta)
+	delta := newBase - img.base()
+	if delta == 0 {
+		return false, nil
+	}
+	if img.relocSize == 0 {
+		return false, fmt.Errorf("image at %#x has no base relocations to move it to %#x", img.base(), newBase)
+	}
+	if img.is32 && newBase > 0xFFFFFFFF {
+		return false, fmt.Errorf("PE32 image cannot run from %#x", newBase)
+	}
+
+	dir, err := img.offset(img.relocRVA, int(img.relocSize))
+	if err != nil {
+		return false, err
+	}
+	relocs := buf[dir : dir+int(img.relocSize)]
+	// Check the relocations before applying any.
+	for _, apply := range []bool{false, true} {
+		for b := 0; b+8 <= len(relocs); {
+			page := binary.LittleEndian.Uint32(relocs[b:])
+			size := int(binary.LittleEndian.Uint32(relocs[b+4:]))
+			if size < 8 || b+size > len(relocs) {
+				return false, fmt.Errorf("base relocation block at %#x has a bad size %#x", page, size)
+			}
+			for e := b + 8; e+2 <= b+size; e += 2 {
+				entry := binary.LittleEndian.Uint16(relocs[e:])
+				rva := page + uint32(entry&0xFFF)
+				typ := entry >> 12
+				width := 0
+				switch typ {
+				case relBasedAbsolute:
+					continue
+				case relBasedHigh, relBasedLow:
+					width = 2
+				case relBasedHighLow:
+					width = 4
+				case relBasedDir64:
+					width = 8
+				default:
+					return false, fmt.Errorf("unsupported base relocation type %d at %#x", typ, rva)
+				}
+				o, err := img.offset(rva, width)
+				if err != nil {
+					return false, err
+				}
+				if !apply {
+					continue
+				}
+				switch p := buf[o:]; typ {
+				case relBasedHigh:
+					binary.LittleEndian.PutUint16(p, binary.LittleEndian.Uint16(p)+uint16(uint32(delta)>>16))
+				case relBasedLow:
+					binary.LittleEndian.PutUint16(p, binary.LittleEndian.Uint16(p)+uint16(delta))
+				case relBasedHighLow:
+					binary.LittleEndian.PutUint32(p, binary.LittleEndian.Uint32(p)+uint32(delta))
+				case relBasedDir64:
+					binary.LittleEndian.PutUint64(p, binary.LittleEndian.Uint64(p)+delta)
+				}
+			}
+			b += size
+		}
+	}
+
+	if img.is32 {
+		binary.LittleEndian.PutUint32(buf[img.imageBase:], uint32(newBase))
+	} else {
+		binary.LittleEndian.PutUint64(buf[img.imageBase:], newBase)
+	}
+	return true, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testRelocPE returns a PE32+ image based at 0x100000 whose first section
+// holds a 64-bit and a 32-bit pointer to 0x100300, and their relocations.
+func testRelocPE() []byte {
+	const (
+		opt      = 0x40 + 4 + coffHeaderLen
+		sections = opt + 112 + 16*8
+	)
+	img := testPE(nil)
+	binary.LittleEndian.PutUint64(img[opt+24:], 0x100000)
+	// The first section is mapped where it is in the file.
+	binary.LittleEndian.PutUint32(img[sections+8:], 0x100)
+	binary.LittleEndian.PutUint32(img[sections+12:], 0x300)
+	binary.LittleEndian.PutUint64(img[0x300:], 0x100300)
+	binary.LittleEndian.PutUint32(img[0x308:], 0x100300)
+	relocs := opt + 112 + peBaseRelocDir*peDataDirEntryLen
+	binary.LittleEndian.PutUint32(img[relocs:], 0x310)
+	binary.LittleEndian.PutUint32(img[relocs+4:], 12)
+	binary.LittleEndian.PutUint32(img[0x310:], 0x300)
+	binary.LittleEndian.PutUint32(img[0x314:], 12)
+	binary.LittleEndian.PutUint16(img[0x318:], relBasedDir64<<12|0x0)
+	binary.LittleEndian.PutUint16(img[0x31a:], relBasedHighLow<<12|0x8)
+	return img
+}
+
+func TestRebaseXIP(t *testing.T) {
+	img := testRelocPE()
+	if changed, err := RebaseXIP(img, 0x100000); err != nil || changed {
+		t.Errorf("rebasing to the image base changed the image: %v, %v", changed, err)
+	}
+	if changed, err := RebaseXIP(img, 0x200000); err != nil || !changed {
+		t.Fatalf("rebasing gave %v, %v, want a changed image", changed, err)
+	}
+	if got := binary.LittleEndian.Uint64(img[0x300:]); got != 0x200300 {
+		t.Errorf("got 64-bit pointer %#x, want 0x200300", got)
+	}
+	if got := binary.LittleEndian.Uint32(img[0x308:]); got != 0x200300 {
+		t.Errorf("got 32-bit pointer %#x, want 0x200300", got)
+	}
+
+	// TE images are based where their PE headers would be.
+	pe := testRelocPE()
+	te, err := PEToTE(pe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The TE image runs from where the sections of the PE image would.
+	shift := len(pe) - len(te)
+	if changed, err := RebaseXIP(te, 0x200000+uint64(shift)); err != nil || !changed {
+		t.Fatalf("rebasing gave %v, %v, want a changed image", changed, err)
+	}
+	if got := binary.LittleEndian.Uint64(te[0x300-shift:]); got != 0x200300 {
+		t.Errorf("got 64-bit pointer %#x in the TE image, want 0x200300", got)
+	}
+	if got := binary.LittleEndian.Uint64(te[16:]); got != 0x200000 {
+		t.Errorf("got TE image base %#x, want 0x200000", got)
+	}
+
+	if _, err := RebaseXIP(testPE(nil), 0x200000); err == nil {
+		t.Errorf("rebasing an image without relocations succeeded, want an error")
+	}
+}
@@ -6,7 +6,10 @@ package uefi
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -112,6 +115,30 @@ type SectionGUIDDefined struct {
 
 	// Metadata
 	Compression string
+	// Variant selects the encoding variant of the compressor, for the EFI
+	// and Tiano compressors, as named by compression.ParseEFIVariant, and
+	// the LZMA ones, as named by compression.ParseLZMAVariant. Empty is the
+	// default of the GUID. Parsing sets the variant the section was encoded
+	// with, if it tells, so assembling encodes it alike.
+	Variant string `json:",omitempty"`
+	// OriginalSHA256 is the hash of the GUID, the attributes, the variant
+	// and the data of the section when it was parsed, as OriginalSum
+	// computes it. While they are unchanged, assembling keeps the encoded
+	// bytes of the section rather than encoding them again, which may
+	// make them larger. Empty to always encode them again.
+	OriginalSHA256 string `json:",omitempty"`
+}
+
+// OriginalSum returns the hash of the section header fields and the
+// decoded data which OriginalSHA256 holds.
+func (s *SectionGUIDDefined) OriginalSum(decoded []byte) string {
+	h := sha256.New()
+	h.Write(s.GUID[:])
+	binary.Write(h, binary.LittleEndian, s.Attributes)
+	h.Write([]byte(s.Variant))
+	h.Write([]byte{0})
+	h.Write(decoded)
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // GetBinHeaderLen returns the length of the binary typ specific header
@@ -211,6 +238,22 @@ type Section struct {
 
 	// Encapsulated firmware
 	Encapsulated []*TypedFirmware `json:",omitempty"`
+
+	// For EFI_SECTION_RAW and EFI_SECTION_FREEFORM_SUBTYPE_GUID holding a
+	// PCI option ROM
+	OptionROM *OptionROM `json:",omitempty"`
+
+	// For EFI_SECTION_RAW and EFI_SECTION_FREEFORM_SUBTYPE_GUID holding
+	// firmware volumes compressed by a vendor
+	CompressedVolume *CompressedVolume `json:",omitempty"`
+
+	// For EFI_SECTION_RAW holding the Apple ROM version
+	AppleROMVersion *AppleROMVersion `json:",omitempty"`
+
+	// Alignment is the alignment of the section within its file or
+	// encapsulation section, DefaultSectionAlignment if zero. Newer PI
+	// specifications let sections like PE32 ones require 8 bytes or more.
+	Alignment uint64 `json:",omitempty"`
 }
 
 // String returns the String value of the section if it makes sense,
@@ -245,11 +288,30 @@ func (s *Section) SetBuf(buf []byte) {
 
 // Apply calls the visitor on the Section.
 func (s *Section) Apply(v Visitor) error {
-	return v.Visit(s)
+	return visit(v, s)
+}
+
+// HeaderLen returns the length of the common section header depending on
+// the section size.
+func (s *Section) HeaderLen() uint64 {
+	if s.Header.Size == [3]uint8{0xFF, 0xFF, 0xFF} {
+		return uint64(unsafe.Sizeof(SectionExtHeader{}))
+	}
+	return uint64(unsafe.Sizeof(SectionHeader{}))
 }
 
 // ApplyChildren calls the visitor on each child node of Section.
 func (s *Section) ApplyChildren(v Visitor) error {
+	if s.OptionROM != nil {
+		if err := s.OptionROM.Apply(v); err != nil {
+			return err
+		}
+	}
+	if s.CompressedVolume != nil {
+		if err := s.CompressedVolume.Apply(v); err != nil {
+			return err
+		}
+	}
 	for _, f := range s.Encapsulated {
 		if err := f.Value.Apply(v); err != nil {
 			return err
@@ -286,6 +348,8 @@ func CreateSection(t SectionType, buf []byte, encap []Firmware, g *guid.GUID) (*
 			guidDefHeader.Compression = "LZMA"
 		case compression.LZMAX86GUID:
 			guidDefHeader.Compression = "LZMAX86"
+		case compression.TianoGUID:
+			guidDefHeader.Compression = "Tiano"
 		default:
 			guidDefHeader.Compression = "UNKNOWN"
 		}
@@ -313,22 +377,10 @@ func (s *Section) GenSecHeader() error {
 		s.Header.ExtendedSize += 4
 	}
 
-	// Set the correct data offset for GUID Defined headers.
-	// This is terrible
-	if s.Header.Type == SectionTypeGUIDDefined {
-		gd := s.TypeSpecific.Header.(*SectionGUIDDefined)
-		gd.DataOffset = uint16(headerLen)
-		// append type specific header in front of data
-		tsh := new(bytes.Buffer)
-		if err = binary.Write(tsh, binary.LittleEndian, &gd.SectionGUIDDefinedHeader); err != nil {
-			return err
-		}
-		s.buf = append(tsh.Bytes(), s.buf...)
-	}
-
-	// Append common header
+	// Common header
 	s.Header.Size = Write3Size(uint64(s.Header.ExtendedSize))
-	h := new(bytes.Buffer)
+	h := getHeaderBuffer()
+	defer putHeaderBuffer(h)
 	if s.Header.ExtendedSize >= 0xFFFFFF {
 		err = binary.Write(h, binary.LittleEndian, &s.Header)
 	} else {
@@ -337,13 +389,33 @@ func (s *Section) GenSecHeader() error {
 	if err != nil {
 		return err
 	}
-	s.buf = append(h.Bytes(), s.buf...)
+
+	// Set the correct data offset for GUID Defined headers.
+	// This is terrible
+	if s.Header.Type == SectionTypeGUIDDefined {
+		gd := s.TypeSpecific.Header.(*SectionGUIDDefined)
+		gd.DataOffset = uint16(headerLen)
+		// type specific header between the common header and the data
+		if err = binary.Write(h, binary.LittleEndian, &gd.SectionGUIDDefinedHeader); err != nil {
+			return err
+		}
+	}
+	s.buf = concatBufs(h.Bytes(), s.buf)
 	return nil
 }
 
 // NewSection parses a sequence of bytes and returns a Section
 // object, if a valid one is passed, or an error.
 func NewSection(buf []byte, fileOrder int) (*Section, error) {
+	return newSection(context.Background(), buf, fileOrder)
+}
+
+func newSection(ctx context.Context, buf []byte, fileOrder int) (*Section, error) {
+	ctx, leave, err := enterNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
 	s := Section{FileOrder: fileOrder}
 	// Read in standard header.
 	r := bytes.NewReader(buf)
@@ -386,14 +458,8 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 			s.Header.ExtendedSize, buflen)
 	}
 
-	if ReadOnly {
-		s.buf = buf[:s.Header.ExtendedSize]
-	} else {
-		// Copy out the buffer.
-		newBuf := buf[:s.Header.ExtendedSize]
-		s.buf = make([]byte, s.Header.ExtendedSize)
-		copy(s.buf, newBuf)
-	}
+	// Copy out the buffer.
+	s.buf = copyBuf(buf[:s.Header.ExtendedSize])
 
 	// Section type specific data
 	switch s.Header.Type {
@@ -407,31 +473,53 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 		// Determine how to interpret the section based on the GUID.
 		var encapBuf []byte
 		if typeSpec.Attributes&uint16(GUIDEDSectionProcessingRequired) != 0 && !DisableDecompression {
-			if compressor := compression.CompressorFromGUID(&typeSpec.GUID); compressor != nil {
+			copts := compression.Options{Externals: parseOptionsOf(ctx).Externals, Context: ctx}
+			if compressor := copts.CompressorFromGUID(&typeSpec.GUID); compressor != nil {
 				typeSpec.Compression = compressor.Name()
 				var err error
-				encapBuf, err = compressor.Decode(buf[typeSpec.DataOffset:])
+				encapBuf, err = decompress(ctx, compressor, buf[typeSpec.DataOffset:])
+				if efi, ok := compressor.(*compression.EFI); ok && efi.Tiano && err != nil && !errors.Is(err, ErrParseLimit) {
+					// Some Tiano sections hold EFI 1.1 compressed data.
+					if b, efiErr := decompress(ctx, &compression.EFI{}, buf[typeSpec.DataOffset:]); efiErr == nil {
+						encapBuf, err = b, nil
+						typeSpec.Compression, typeSpec.Variant = "EFI", "EFI"
+					}
+				}
+				if errors.Is(err, ErrParseLimit) {
+					return nil, err
+				}
 				if err != nil {
-					log.Errorf("%v", err)
+					log.FromContext(ctx).Errorf("%v", err)
 					typeSpec.Compression = "UNKNOWN"
 					encapBuf = []byte{}
+				} else {
+					if typeSpec.Variant == "" {
+						// Encode like the vendor again.
+						typeSpec.Variant = compression.DetectVariant(compressor, buf[typeSpec.DataOffset:])
+					}
+					typeSpec.OriginalSHA256 = typeSpec.OriginalSum(encapBuf)
 				}
 			} else {
 				typeSpec.Compression = "UNKNOWN"
 			}
 		}
 
+		var encapSections []*Section
+		var offsets []uint64
 		for i, offset := 0, uint64(0); offset < uint64(len(encapBuf)); i++ {
-			encapS, err := NewSection(encapBuf[offset:], i)
+			encapS, err := newSection(ctx, encapBuf[offset:], i)
 			if err != nil {
-				return nil, fmt.Errorf("error parsing encapsulated section #%d at offset %d: %v",
+				return nil, fmt.Errorf("error parsing encapsulated section #%d at offset %d: %w",
 					i, offset, err)
 			}
+			encapSections = append(encapSections, encapS)
+			offsets = append(offsets, offset)
 			// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
 			// but UEFITool aligns to 4 bytes, and this seems to work on everything I have.
 			offset = Align4(offset + uint64(encapS.Header.ExtendedSize))
 			s.Encapsulated = append(s.Encapsulated, MakeTyped(encapS))
 		}
+		detectSectionAlignments(encapSections, offsets)
 
 	case SectionTypeUserInterface:
 		s.Name = unicode.UCS2ToUTF8(s.buf[headerSize:])
@@ -441,7 +529,7 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 		s.Version = unicode.UCS2ToUTF8(s.buf[headerSize+2:])
 
 	case SectionTypeFirmwareVolumeImage:
-		fv, err := NewFirmwareVolume(s.buf[headerSize:], 0, true)
+		fv, err := newFirmwareVolume(ctx, s.buf[headerSize:], 0, true)
 		if err != nil {
 			return nil, err
 		}
@@ -450,7 +538,34 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 	case SectionTypeDXEDepEx, SectionTypePEIDepEx, SectionMMDepEx:
 		var err error
 		if s.DepEx, err = parseDepEx(s.buf[headerSize:]); err != nil {
-			log.Warnf("%v", err)
+			log.FromContext(ctx).Warnf("%v", err)
+		}
+
+	case SectionTypeRaw, SectionTypeFreeformSubtypeGUID:
+		o := uint64(headerSize)
+		if s.Header.Type == SectionTypeFreeformSubtypeGUID {
+			o += uint64(binary.Size(guid.GUID{}))
+		}
+		if o < uint64(len(s.buf)) && IsOptionROM(s.buf[o:]) {
+			r, err := NewOptionROM(s.buf[o:], o)
+			if err != nil {
+				log.FromContext(ctx).Warnf("%v", err)
+			} else {
+				s.OptionROM = r
+			}
+		}
+		if o < uint64(len(s.buf)) {
+			if r, err := NewAppleROMVersion(s.buf[o:]); err == nil {
+				s.AppleROMVersion = r
+				break
+			}
+		}
+		if s.OptionROM == nil && o < uint64(len(s.buf)) {
+			cv, err := findCompressedVolume(ctx, s.buf[o:], o)
+			if err != nil {
+				return nil, err
+			}
+			s.CompressedVolume = cv
 		}
 	}
 
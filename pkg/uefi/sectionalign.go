@@ -0,0 +1,112 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+)
+
+// DefaultSectionAlignment is the alignment of sections in a file or in an
+// encapsulation section. UEFITool and EDK2 align to 4 bytes.
+const DefaultSectionAlignment = 4
+
+// maxSectionAlignment is the largest alignment detected on parse, the one
+// of file data in the PI specification before 1.6.
+const maxSectionAlignment = 64 << 10
+
+// SectionAlignment returns the alignment of the section within its file or
+// encapsulation section.
+func (s *Section) SectionAlignment() (uint64, error) {
+	a := s.Alignment
+	if a == 0 {
+		return DefaultSectionAlignment, nil
+	}
+	if a < DefaultSectionAlignment || a&(a-1) != 0 {
+		return 0, fmt.Errorf("section alignment %#x is not a power of two of at least %d", a, DefaultSectionAlignment)
+	}
+	return a, nil
+}
+
+// isAlignmentPad checks if the section is the zero-filled raw section EDK2
+// puts before sections aligned to more than 4 bytes.
+func (s *Section) isAlignmentPad() bool {
+	if s.Header.Type != SectionTypeRaw || s.OptionROM != nil {
+		return false
+	}
+	for _, b := range s.buf[s.HeaderLen():] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// detectSectionAlignments sets the Alignment of the sections following an
+// alignment pad, given the offsets of the sections, to the smallest one the
+// pad explains.
+func detectSectionAlignments(sections []*Section, offsets []uint64) {
+	for i := 1; i < len(sections); i++ {
+		// EDK2 pads end where the aligned section starts.
+		pad := sections[i-1]
+		if !pad.isAlignmentPad() || offsets[i-1]+uint64(pad.Header.ExtendedSize) != offsets[i] {
+			continue
+		}
+		for a := uint64(2 * DefaultSectionAlignment); a <= maxSectionAlignment; a <<= 1 {
+			if Align(offsets[i-1], a) == offsets[i] {
+				sections[i].Alignment = a
+				break
+			}
+		}
+	}
+}
+
+// LayoutSections returns the data of a file or an encapsulation section
+// holding the sections, which start at offset base of the data their
+// alignment is relative to. Sections are aligned to 4 bytes with zeros, and
+// to more with alignment pads, which replace the ones in sections that no
+// longer align the next section. The sections returned have the pads of the
+// data.
+func LayoutSections(sections []*Section, base uint64) ([]*Section, []byte, error) {
+	var data []byte
+	var laid []*Section
+	for i, s := range sections {
+		align, err := s.SectionAlignment()
+		if err != nil {
+			return nil, nil, err
+		}
+		// Why is it 00s? I don't know. Everything else has been extended with FFs
+		// but somehow in between sections alignment is done with 0s. What the heck.
+		for count := Align4(uint64(len(data))) - uint64(len(data)); count > 0; count-- {
+			data = append(data, 0x00)
+		}
+		offset := base + uint64(len(data))
+		if i+1 < len(sections) && s.isAlignmentPad() {
+			next, err := sections[i+1].SectionAlignment()
+			if err != nil {
+				return nil, nil, err
+			}
+			if next > DefaultSectionAlignment && Align4(offset+uint64(len(s.Buf())))%next != 0 {
+				continue
+			}
+		}
+		if gap := Align(offset, align) - offset; gap > 0 {
+			if gap >= 0xFFFFFF {
+				return nil, nil, fmt.Errorf("no room for an alignment pad of %#x bytes", gap)
+			}
+			pad, err := CreateSection(SectionTypeRaw, make([]byte, gap-SectionMinLength), nil, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := pad.GenSecHeader(); err != nil {
+				return nil, nil, err
+			}
+			data = append(data, pad.Buf()...)
+			laid = append(laid, pad)
+		}
+		data = append(data, s.Buf()...)
+		laid = append(laid, s)
+	}
+	return laid, data, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+func makeSection(t *testing.T, typ SectionType, data []byte) *Section {
+	t.Helper()
+	s, err := CreateSection(typ, data, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// parseSections parses the sections of data starting at offset base, like
+// the sections of a file.
+func parseSections(t *testing.T, data []byte, base uint64) []*Section {
+	t.Helper()
+	var sections []*Section
+	var offsets []uint64
+	for offset := uint64(0); offset < uint64(len(data)); {
+		s, err := NewSection(data[offset:], len(sections))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sections = append(sections, s)
+		offsets = append(offsets, base+offset)
+		offset = Align4(offset + uint64(s.Header.ExtendedSize))
+	}
+	detectSectionAlignments(sections, offsets)
+	return sections
+}
+
+func TestLayoutSections(t *testing.T) {
+	ui := makeSection(t, SectionTypeUserInterface, unicode.UTF8ToUCS2("abc"))
+	pe := makeSection(t, SectionTypePE32, []byte("MZ"))
+	pe.Alignment = 32
+
+	sections, data, err := LayoutSections([]*Section{ui, pe}, FileHeaderMinLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The UI section ends at 0x24, the pad takes up to 0x40.
+	if len(sections) != 3 || !sections[1].isAlignmentPad() {
+		t.Fatalf("got %d sections, want the UI one, a pad and the PE32 one", len(sections))
+	}
+	if !bytes.HasSuffix(data, pe.Buf()) || (FileHeaderMinLength+uint64(len(data)-len(pe.Buf())))%32 != 0 {
+		t.Errorf("the PE32 section is not aligned to 32 bytes in %x", data)
+	}
+
+	parsed := parseSections(t, data, FileHeaderMinLength)
+	if len(parsed) != 3 || parsed[2].Alignment != 32 {
+		t.Fatalf("got %d sections, the last aligned to %d, want 3, aligned to 32", len(parsed), parsed[len(parsed)-1].Alignment)
+	}
+
+	// Moving the section replaces its pad.
+	ui = makeSection(t, SectionTypeUserInterface, unicode.UTF8ToUCS2("abcdefg"))
+	sections, again, err := LayoutSections([]*Section{ui, parsed[1], parsed[2]}, FileHeaderMinLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 3 || (FileHeaderMinLength+uint64(len(again)-len(pe.Buf())))%32 != 0 {
+		t.Errorf("got %d sections in %x, want 3, the PE32 one aligned to 32 bytes", len(sections), again)
+	}
+
+	pe.Alignment = 12
+	if _, _, err := LayoutSections([]*Section{pe}, 0); err == nil {
+		t.Errorf("laying out a section aligned to 12 bytes succeeded, want an error")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Conversion between PE32(+) and Terse Executable images, as described in
+// the PI specification, volume 1, "TE Image". A TE image is a PE image whose
+// headers up to the section table, StrippedSize bytes, were replaced with the
+// TE header. Offsets in the TE header and the section table are still those
+// of the PE image.
+
+const (
+	peBaseRelocDir       = 5
+	peMinDataDirs        = peDebugDir + 1
+	peMaxDataDirs        = 16
+	dosHeaderLen         = 0x40
+	peOptHeader32Len     = 96
+	peOptHeader64Len     = 112
+	peImageExecutable    = 0x0002
+	peRelocsStripped     = 0x0001
+	peLargeAddressAware  = 0x0020
+	pe32BitMachine       = 0x0100
+	peSectionCode        = 0x00000020
+	peSectionInitialized = 0x00000040
+	peSectionUninit      = 0x00000080
+)
+
+// peMachines64 are the machines whose images have a PE32+ optional header.
+var peMachines64 = map[uint16]bool{
+	0x5064: true, // RISCV64
+	0x6264: true, // LOONGARCH64
+	0x8664: true, // X64
+	0xaa64: true, // AARCH64
+}
+
+// teHeader is EFI_TE_IMAGE_HEADER.
+type teHeader struct {
+	Signature           [2]byte
+	Machine             uint16
+	NumberOfSections    uint8
+	Subsystem           uint8
+	StrippedSize        uint16
+	AddressOfEntryPoint uint32
+	BaseOfCode          uint32
+	ImageBase           uint64
+	// The base relocation and debug directories.
+	DataDirectory [2][2]uint32
+}
+
+// PEToTE converts the PE32(+) image in buf to a TE image, the way GenFw
+// does. The TE image drops the DOS stub, the time stamp and the data
+// directories other than the base relocation and debug ones.
+func PEToTE(buf []byte) ([]byte, error) {
+	u16 := func(o int) uint16 { return binary.LittleEndian.Uint16(buf[o:]) }
+	u32 := func(o int) uint32 { return binary.LittleEndian.Uint32(buf[o:]) }
+
+	if len(buf) <= peOffsetOffset+4 || !bytes.HasPrefix(buf, mzSignature) {
+		return nil, errors.New("not a PE32 image")
+	}
+	pe := int(u32(peOffsetOffset))
+	opt := pe + len(peSignature) + coffHeaderLen
+	if pe < 0 || opt+peOptHeader32Len > len(buf) || !bytes.Equal(buf[pe:pe+4], peSignature) {
+		return nil, errors.New("no PE signature")
+	}
+	nSections := int(u16(pe + 4 + 2))
+	sectionTable := opt + int(u16(pe+4+16))
+	if sectionTable+nSections*peSectionLen > len(buf) {
+		return nil, errors.New("PE headers out of the image")
+	}
+	if nSections > 0xFF || sectionTable > 0xFFFF {
+		return nil, fmt.Errorf("%d sections after %#x bytes of headers do not fit in a TE header", nSections, sectionTable)
+	}
+
+	h := teHeader{
+		Machine:             u16(pe + 4),
+		NumberOfSections:    uint8(nSections),
+		Subsystem:           uint8(u16(opt + 68)),
+		StrippedSize:        uint16(sectionTable),
+		AddressOfEntryPoint: u32(opt + 16),
+		BaseOfCode:          u32(opt + 20),
+	}
+	copy(h.Signature[:], teSignature)
+	var rvaCountOffset int
+	switch magic := u16(opt); magic {
+	case peMagic32:
+		rvaCountOffset = 92
+		h.ImageBase = uint64(u32(opt + 28))
+	case peMagic64:
+		rvaCountOffset = 108
+		h.ImageBase = binary.LittleEndian.Uint64(buf[opt+24:])
+	default:
+		return nil, fmt.Errorf("unknown optional header magic %#x", magic)
+	}
+	dirs := opt + rvaCountOffset + 4
+	for i, d := range []int{peBaseRelocDir, peDebugDir} {
+		entry := dirs + d*peDataDirEntryLen
+		if int(u32(opt+rvaCountOffset)) > d && entry+peDataDirEntryLen <= sectionTable {
+			h.DataDirectory[i] = [2]uint32{u32(entry), u32(entry + 4)}
+		}
+	}
+
+	te := &bytes.Buffer{}
+	if err := binary.Write(te, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	te.Write(buf[sectionTable:])
+	return te.Bytes(), nil
+}
+
+// TEToPE converts the TE image in buf back to a PE32(+) image, so PE tools
+// can read it. The headers are rebuilt in the StrippedSize bytes the TE
+// header replaced, which keeps the offsets of the image, so PEToTE gives back
+// the TE image. The fields TE drops are zero, and the alignments are the
+// largest the sections allow.
+func TEToPE(buf []byte) ([]byte, error) {
+	var h teHeader
+	if len(buf) < teHeaderLen || !bytes.HasPrefix(buf, teSignature) {
+		return nil, errors.New("not a TE image")
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	nSections := int(h.NumberOfSections)
+	if teHeaderLen+nSections*peSectionLen > len(buf) {
+		return nil, errors.New("TE section table out of the image")
+	}
+
+	is64 := peMachines64[h.Machine]
+	optLen, rvaCountOffset := peOptHeader32Len, 92
+	if is64 {
+		optLen, rvaCountOffset = peOptHeader64Len, 108
+	}
+	// Use all the data directories if they fit.
+	stripped := int(h.StrippedSize)
+	nDirs := peMaxDataDirs
+	if room := (stripped - dosHeaderLen - len(peSignature) - coffHeaderLen - optLen) / peDataDirEntryLen; room < nDirs {
+		nDirs = room
+	}
+	if nDirs < peMinDataDirs {
+		return nil, fmt.Errorf("stripped size %#x has no room for the PE headers", stripped)
+	}
+	optLen += nDirs * peDataDirEntryLen
+	pe := stripped - optLen - coffHeaderLen - len(peSignature)
+
+	// Sizes and alignments come from the section table.
+	var sizeOfCode, sizeOfData, sizeOfBSS, sizeOfImage uint32
+	sectionAlign, fileAlign := uint32(0x1000), uint32(0x1000)
+	sizeOfHeaders := uint32(stripped + nSections*peSectionLen)
+	alignOf := func(align, v uint32) uint32 {
+		for align > 1 && v%align != 0 {
+			align >>= 1
+		}
+		return align
+	}
+	for i := 0; i < nSections; i++ {
+		s := buf[teHeaderLen+i*peSectionLen:]
+		vsize, va := binary.LittleEndian.Uint32(s[8:]), binary.LittleEndian.Uint32(s[12:])
+		rawSize, raw := binary.LittleEndian.Uint32(s[16:]), binary.LittleEndian.Uint32(s[20:])
+		characteristics := binary.LittleEndian.Uint32(s[36:])
+		switch {
+		case characteristics&peSectionCode != 0:
+			sizeOfCode += rawSize
+		case characteristics&peSectionInitialized != 0:
+			sizeOfData += rawSize
+		case characteristics&peSectionUninit != 0:
+			sizeOfBSS += vsize
+		}
+		sectionAlign = alignOf(sectionAlign, va)
+		if rawSize != 0 {
+			fileAlign = alignOf(fileAlign, raw)
+			if raw < sizeOfHeaders {
+				sizeOfHeaders = raw
+			}
+		}
+		if end := va + vsize; end > sizeOfImage {
+			sizeOfImage = end
+		}
+	}
+	sizeOfImage = uint32(Align(uint64(sizeOfImage), uint64(sectionAlign)))
+
+	out := make([]byte, stripped, stripped+len(buf)-teHeaderLen)
+	put16 := func(o int, v uint16) { binary.LittleEndian.PutUint16(out[o:], v) }
+	put32 := func(o int, v uint32) { binary.LittleEndian.PutUint32(out[o:], v) }
+	copy(out, mzSignature)
+	put32(peOffsetOffset, uint32(pe))
+	copy(out[pe:], peSignature)
+
+	coff := pe + len(peSignature)
+	characteristics := uint16(peImageExecutable)
+	if h.DataDirectory[0][1] == 0 {
+		characteristics |= peRelocsStripped
+	}
+	if is64 {
+		characteristics |= peLargeAddressAware
+	} else {
+		characteristics |= pe32BitMachine
+	}
+	put16(coff, h.Machine)
+	put16(coff+2, uint16(nSections))
+	put16(coff+16, uint16(optLen))
+	put16(coff+18, characteristics)
+
+	opt := coff + coffHeaderLen
+	if is64 {
+		put16(opt, peMagic64)
+		binary.LittleEndian.PutUint64(out[opt+24:], h.ImageBase)
+	} else {
+		put16(opt, peMagic32)
+		put32(opt+28, uint32(h.ImageBase))
+	}
+	put32(opt+4, sizeOfCode)
+	put32(opt+8, sizeOfData)
+	put32(opt+12, sizeOfBSS)
+	put32(opt+16, h.AddressOfEntryPoint)
+	put32(opt+20, h.BaseOfCode)
+	put32(opt+32, sectionAlign)
+	put32(opt+36, fileAlign)
+	put32(opt+56, sizeOfImage)
+	put32(opt+60, sizeOfHeaders)
+	put16(opt+68, uint16(h.Subsystem))
+	put32(opt+rvaCountOffset, uint32(nDirs))
+	dirs := opt + rvaCountOffset + 4
+	for i, d := range []int{peBaseRelocDir, peDebugDir} {
+		put32(dirs+d*peDataDirEntryLen, h.DataDirectory[i][0])
+		put32(dirs+d*peDataDirEntryLen+4, h.DataDirectory[i][1])
+	}
+
+	return append(out, buf[teHeaderLen:]...), nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPEToTE(t *testing.T) {
+	const (
+		opt      = 0x40 + 4 + coffHeaderLen
+		dirs     = opt + 112
+		sections = dirs + 16*8
+	)
+	img := testPE(nil)
+	binary.LittleEndian.PutUint16(img[0x40+4:], 0x8664)
+	binary.LittleEndian.PutUint32(img[opt+16:], 0x1234)
+	binary.LittleEndian.PutUint64(img[opt+24:], 0xFFF00000)
+	binary.LittleEndian.PutUint32(img[dirs+peBaseRelocDir*peDataDirEntryLen:], 0x300)
+	binary.LittleEndian.PutUint32(img[dirs+peBaseRelocDir*peDataDirEntryLen+4:], 0x10)
+
+	te, err := PEToTE(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h teHeader
+	if err := binary.Read(bytes.NewReader(te), binary.LittleEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Machine != 0x8664 || h.NumberOfSections != 2 || h.StrippedSize != sections ||
+		h.AddressOfEntryPoint != 0x1234 || h.ImageBase != 0xFFF00000 || h.DataDirectory[0] != [2]uint32{0x300, 0x10} {
+		t.Errorf("got TE header %+v", h)
+	}
+	if !bytes.Equal(te[teHeaderLen:], img[sections:]) {
+		t.Errorf("the section table and data were changed")
+	}
+
+	pe, err := TEToPE(te)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pe) != len(img) || !bytes.Equal(pe[sections:], img[sections:]) {
+		t.Errorf("the PE image has %#x bytes, want the sections at the same offsets in %#x bytes", len(pe), len(img))
+	}
+	if info, err := ParseExecutableInfo(pe); err != nil || info.Machine != "X64" {
+		t.Errorf("got %+v, %v parsing the PE image, want an X64 one", info, err)
+	}
+	again, err := PEToTE(pe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, te) {
+		t.Errorf("converting back gave a different TE image")
+	}
+}
+
+func TestTEToPEErrors(t *testing.T) {
+	te := make([]byte, 0x100)
+	copy(te, teSignature)
+	// Too small for the headers of a PE32 image.
+	binary.LittleEndian.PutUint16(te[6:], 0x80)
+	for name, buf := range map[string][]byte{
+		"not an image":     []byte("banana"),
+		"stripped too few": te,
+	} {
+		if _, err := TEToPE(buf); err == nil {
+			t.Errorf("%s: converting succeeded, want an error", name)
+		}
+	}
+	if _, err := PEToTE(te); err == nil {
+		t.Errorf("converting a TE image as a PE one succeeded, want an error")
+	}
+}
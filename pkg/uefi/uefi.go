@@ -8,10 +8,12 @@ package uefi
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sync"
 )
 
 var (
@@ -48,6 +50,10 @@ var Attributes = ROMAttributes{ErasePolarity: poisonedPolarity}
 // See also: https://github.com/linuxboot/fiano/issues/329
 var SuppressErasePolarityError = false
 
+// forcedErasePolarity is the Erase Polarity SetErasePolarity set, which
+// parsing keeps instead of the one of the first volume of the image.
+var forcedErasePolarity = poisonedPolarity
+
 // SetErasePolarity sets the Erase Polarity for the flash image.
 // It checks to see if there are conflicting Erase Polarities.
 func SetErasePolarity(ep byte) error {
@@ -66,9 +72,34 @@ func SetErasePolarity(ep byte) error {
 		return nil
 	}
 	Attributes.ErasePolarity = ep
+	forcedErasePolarity = ep
 	return nil
 }
 
+// resetErasePolarity unsets the Erase Polarity of the previous image, so
+// that the next one parsed sets its own, unless SetErasePolarity set it.
+func resetErasePolarity() {
+	Attributes.ErasePolarity = forcedErasePolarity
+}
+
+// setDefaultErasePolarity sets the Erase Polarity for the flash image if it
+// was not set, as parsing does for the first volume. Volumes of another
+// polarity keep theirs.
+func setDefaultErasePolarity(ep byte) {
+	if Attributes.ErasePolarity == poisonedPolarity {
+		Attributes.ErasePolarity = ep
+	}
+}
+
+// DefaultErasePolarity returns the Erase Polarity for the flash image, or
+// 0xFF if it was not set.
+func DefaultErasePolarity() byte {
+	if Attributes.ErasePolarity == poisonedPolarity {
+		return 0xFF
+	}
+	return Attributes.ErasePolarity
+}
+
 // Firmware is an interface to describe generic firmware types. When the
 // firmware is parsed, all the Firmware objects are laid out in a tree (similar
 // to an AST). This interface represents one node in said tree. The
@@ -122,11 +153,13 @@ func (f *TypedFirmware) UnmarshalJSON(b []byte) error {
 
 // This should never be exposed, it is only used for marshalling different types to json.
 type marshalFirmware struct {
+	SchemaVersion   int `json:",omitempty"`
 	FType           string
 	FirmwareElement json.RawMessage
 }
 
 var firmwareTypes = map[string]func() Firmware{
+	"*uefi.BIOSBackup":      func() Firmware { return &BIOSBackup{} },
 	"*uefi.BIOSRegion":      func() Firmware { return &BIOSRegion{} },
 	"*uefi.BIOSPadding":     func() Firmware { return &BIOSPadding{} },
 	"*uefi.File":            func() Firmware { return &File{} },
@@ -145,12 +178,17 @@ func MarshalFirmware(f Firmware) ([]byte, error) {
 		return nil, err
 	}
 
-	m := marshalFirmware{FType: reflect.TypeOf(f).String(), FirmwareElement: json.RawMessage(b)}
+	m := marshalFirmware{SchemaVersion: SchemaVersion, FType: reflect.TypeOf(f).String(), FirmwareElement: json.RawMessage(b)}
 	return json.MarshalIndent(m, "", "    ")
 }
 
 // UnmarshalFirmware unmarshals the firmware element from JSON, using the type information at the top.
+// JSON of older schema versions is migrated first.
 func UnmarshalFirmware(b []byte) (Firmware, error) {
+	b, err := MigrateManifest(b)
+	if err != nil {
+		return nil, err
+	}
 	var m marshalFirmware
 	if err := json.Unmarshal(b, &m); err != nil {
 		return nil, err
@@ -160,7 +198,7 @@ func UnmarshalFirmware(b []byte) (Firmware, error) {
 		return nil, fmt.Errorf("unknown Firmware type '%s', unable to unmarshal", m.FType)
 	}
 	f := factory()
-	err := json.Unmarshal(m.FirmwareElement, &f)
+	err = json.Unmarshal(m.FirmwareElement, &f)
 	return f, err
 }
 
@@ -168,22 +206,59 @@ func UnmarshalFirmware(b []byte) (Firmware, error) {
 // implement any parser itself, but it calls known parsers that implement the
 // Firmware interface.
 func Parse(buf []byte) (Firmware, error) {
-	if _, err := FindSignature(buf); err == nil {
+	return ParseContext(context.Background(), buf)
+}
+
+// ParseContext is like Parse, but stops with the error of ctx once it is
+// done. It is checked before parsing each region, volume, file and section.
+// The default erase polarity becomes the one of the image.
+func ParseContext(ctx context.Context, buf []byte) (Firmware, error) {
+	var f Firmware
+	var err error
+	resetErasePolarity()
+	if _, err = FindSignature(buf); err == nil {
 		// Intel rom.
-		return NewFlashImage(buf)
+		f, err = newFlashImage(ctx, buf)
+	} else {
+		// Non intel image such as edk2's OVMF
+		// We don't know how to parse this header, so treat it as a large BIOSRegion
+		f, err = newBIOSRegion(ctx, buf, nil, RegionTypeBIOS)
+	}
+	if err != nil {
+		// The parsers wrap the error of ctx in their own.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
 	}
-	// Non intel image such as edk2's OVMF
-	// We don't know how to parse this header, so treat it as a large BIOSRegion
-	return NewBIOSRegion(buf, nil, RegionTypeBIOS)
+	return f, nil
 }
 
 // Checksum8 does a 8 bit checksum of the slice passed in.
 func Checksum8(buf []byte) uint8 {
-	var sum uint8
+	// Sum 8 bytes at a time, in four 16 bit lanes of the even bytes and
+	// four of the odd ones. A lane takes 128 words before it can overflow.
+	const mask = 0x00ff00ff00ff00ff
+	var sum uint64
+	for len(buf) >= 8 {
+		n := len(buf) / 8
+		if n > 128 {
+			n = 128
+		}
+		var lanes uint64
+		for i := 0; i < n; i++ {
+			w := binary.LittleEndian.Uint64(buf[8*i:])
+			lanes += w&mask + (w>>8)&mask
+		}
+		for ; lanes != 0; lanes >>= 16 {
+			sum += lanes & 0xffff
+		}
+		buf = buf[8*n:]
+	}
 	for _, val := range buf {
-		sum += val
+		sum += uint64(val)
 	}
-	return sum
+	return uint8(sum)
 }
 
 // Checksum16 does a 16 bit checksum of the byte slice passed in.
@@ -226,18 +301,53 @@ func Align(val uint64, base uint64) uint64 {
 
 // Align4 aligns an address to 4 bytes
 func Align4(val uint64) uint64 {
-	return Align(val, 4)
+	return (val + 3) &^ 3
 }
 
 // Align8 aligns an address to 8 bytes
 func Align8(val uint64) uint64 {
-	return Align(val, 8)
+	return (val + 7) &^ 7
+}
+
+// copyBuf returns a copy of buf for the node to own, or buf itself if
+// ReadOnly is set.
+func copyBuf(buf []byte) []byte {
+	if ReadOnly {
+		return buf
+	}
+	c := make([]byte, len(buf))
+	copy(c, buf)
+	return c
+}
+
+// headerPool holds the scratch buffers headers are serialized into before
+// they are put in front of the data of a node.
+var headerPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getHeaderBuffer() *bytes.Buffer {
+	b := headerPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putHeaderBuffer(b *bytes.Buffer) {
+	headerPool.Put(b)
+}
+
+// concatBufs returns header followed by data, in a buffer allocated once.
+func concatBufs(header, data []byte) []byte {
+	buf := make([]byte, len(header)+len(data))
+	copy(buf, header)
+	copy(buf[len(header):], data)
+	return buf
 }
 
-// Erase sets the buffer to be ErasePolarity
+// Erase sets the buffer to be polarity
 func Erase(buf []byte, polarity byte) {
 	for j, blen := 0, len(buf); j < blen; j++ {
-		buf[j] = Attributes.ErasePolarity
+		buf[j] = polarity
 	}
 }
 
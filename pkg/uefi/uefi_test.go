@@ -5,9 +5,16 @@
 package uefi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/linuxboot/fiano/pkg/log"
 )
 
 func TestSetErasePolarity(t *testing.T) {
@@ -129,6 +136,52 @@ func TestChecksum8(t *testing.T) {
 	}
 }
 
+func TestChecksum8Lengths(t *testing.T) {
+	buf := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(buf)
+	for i := range buf[:2048] {
+		buf[i] = 0xFF
+	}
+	for _, l := range []int{1, 7, 8, 9, 1023, 1024, 1025, 2048, 4095, 4096} {
+		for _, b := range [][]byte{buf[:l], buf[len(buf)-l:]} {
+			var want uint8
+			for _, c := range b {
+				want += c
+			}
+			if got := Checksum8(b); got != want {
+				t.Errorf("Checksum8 of %d bytes: got %#x, want %#x", l, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkChecksum8(b *testing.B) {
+	buf := make([]byte, 1<<20)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		Checksum8(buf)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func(ro bool) { ReadOnly = ro }(ReadOnly)
+	for _, ro := range []bool{false, true} {
+		b.Run(fmt.Sprintf("ReadOnly=%v", ro), func(b *testing.B) {
+			ReadOnly = ro
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Parse(image); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestChecksum16(t *testing.T) {
 	var tests = []struct {
 		name string
@@ -228,3 +281,38 @@ func TestAlign8(t *testing.T) {
 		}
 	}
 }
+
+func TestParseContext(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ParseContext(ctx, image); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := ParseContext(context.Background(), image); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseContextLogger(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	ctx := log.WithLogger(context.Background(), log.New(&b, log.Options{JSON: true}))
+	if _, err := ParseContext(ctx, image); err != nil {
+		t.Fatal(err)
+	}
+	// OVMF has an NVRAM volume, which is not parsed.
+	var m struct{ Level, Msg string }
+	if err := json.Unmarshal(bytes.SplitN(b.Bytes(), []byte("\n"), 2)[0], &m); err != nil {
+		t.Fatalf("%v in %q", err, b.String())
+	}
+	if m.Level != "warn" || !strings.Contains(m.Msg, "unsupported fv type") {
+		t.Errorf("got %+v, want the warning of the NVRAM volume", m)
+	}
+}
@@ -4,6 +4,8 @@
 
 package uefi
 
+import "context"
+
 // Visitor represents an operation which can be applied to the Firmware.
 // Typically, the Visit function contains a type switch for the different
 // firmware types and a default case. For example:
@@ -34,3 +36,68 @@ type Visitor interface {
 	// over the children.
 	Visit(Firmware) error
 }
+
+// ContextVisitor is a Visitor which can be cancelled. Once its context is
+// done, applying it returns the error of the context instead of visiting,
+// which ends the walk of the tree.
+type ContextVisitor interface {
+	Visitor
+
+	// Context returns the context of the visitor.
+	Context() context.Context
+}
+
+// VisitHooks are called around the visits of the ContextVisitors whose
+// context has them, so metrics, auditing or policies may be added to every
+// visitor without changing it.
+type VisitHooks struct {
+	// PreVisit is called before v visits f. Its error is returned instead
+	// of visiting.
+	PreVisit func(v Visitor, f Firmware) error
+	// PostVisit is called once v visited f, and its children if it
+	// recursed over them, with the error of the visit, and returns the
+	// error of the visit.
+	PostVisit func(v Visitor, f Firmware, err error) error
+}
+
+type visitHooksKey struct{}
+
+// WithVisitHooks returns a context calling h around the visits, after the
+// PreVisit and before the PostVisit of the hooks ctx already has.
+func WithVisitHooks(ctx context.Context, h *VisitHooks) context.Context {
+	hooks := append([]*VisitHooks{}, visitHooksOf(ctx)...)
+	return context.WithValue(ctx, visitHooksKey{}, append(hooks, h))
+}
+
+func visitHooksOf(ctx context.Context) []*VisitHooks {
+	hooks, _ := ctx.Value(visitHooksKey{}).([]*VisitHooks)
+	return hooks
+}
+
+// visit applies the visitor to the Firmware, unless it is a ContextVisitor
+// whose context is done, calling the VisitHooks of its context around.
+func visit(v Visitor, f Firmware) error {
+	cv, ok := v.(ContextVisitor)
+	if !ok {
+		return v.Visit(f)
+	}
+	ctx := cv.Context()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	hooks := visitHooksOf(ctx)
+	for _, h := range hooks {
+		if h.PreVisit != nil {
+			if err := h.PreVisit(v, f); err != nil {
+				return err
+			}
+		}
+	}
+	err := v.Visit(f)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].PostVisit != nil {
+			err = hooks[i].PostVisit(v, f, err)
+		}
+	}
+	return err
+}
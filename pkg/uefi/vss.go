@@ -0,0 +1,353 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+// Variable stores of the NVRAM volumes, as laid out by EDK2, whose VSS
+// stores Apple extends with a CRC32 of the data of the variables, and by
+// Apple in its Fsys and Gaid stores. They are parsed to be reported;
+// Assemble keeps the bytes of their volume.
+
+// Variable store types.
+const (
+	VariableStoreVSS     = "VSS"
+	VariableStoreAuthVSS = "Auth VSS"
+	VariableStoreFsys    = "Fsys"
+	VariableStoreGaid    = "Gaid"
+)
+
+// Variable formats.
+const (
+	VariableFormatStandard = "Standard"
+	VariableFormatAuth     = "Auth"
+	VariableFormatApple    = "Apple"
+	VariableFormatFsys     = "Fsys"
+)
+
+// GUIDs of the EDK2 variable stores.
+var (
+	VariableStoreGUID     = guid.MustParse("DDCF3616-3275-4164-98B6-FE85707FFE7D")
+	AuthVariableStoreGUID = guid.MustParse("AAF32C78-947B-439A-A180-2E144EC37792")
+)
+
+const (
+	vssStoreHeaderLength     = 16
+	vssGUIDStoreHeaderLength = 28
+	vssStoreFormatted        = 0x5a
+	vssStartID               = 0x55aa
+	appleStoreHeaderLength   = 11
+
+	// VariableAdded is the state of the variables in use.
+	VariableAdded = 0x3f
+	// VariableInDeletedTransition is cleared in the state of the variables
+	// being replaced, which are in use until the new one is added.
+	VariableInDeletedTransition = 0xfe
+
+	// VariableAuthenticatedWriteAccess and
+	// VariableTimeBasedAuthenticatedWriteAccess are the attributes of the
+	// variables with an authenticated header.
+	VariableAuthenticatedWriteAccess          = 0x10
+	VariableTimeBasedAuthenticatedWriteAccess = 0x20
+	// VariableAppleDataChecksum is the attribute of the variables of Apple
+	// VSS stores with a CRC32 of their data in the header.
+	VariableAppleDataChecksum = 0x80000000
+)
+
+// vssVariableHeaderLength are the lengths of the headers of the variable
+// formats of VSS stores. They all start with the StartID, State and
+// Attributes of the variable.
+var vssVariableHeaderLength = map[string]uint64{
+	VariableFormatStandard: 32,
+	VariableFormatApple:    36,
+	VariableFormatAuth:     60,
+}
+
+// VariableStore is a store of variables in an NVRAM volume: an EDK2 VSS
+// store, whose signature is $VSS or $SVS on Apple images or a GUID, or an
+// Apple Fsys or Gaid store.
+type VariableStore struct {
+	buf []byte
+	// Type is one of the VariableStore types.
+	Type string
+	// Offset in the data of the volume.
+	Offset    uint64
+	Variables []*Variable `json:",omitempty"`
+
+	// CRC32 ends the Apple stores.
+	CRC32 uint32 `json:",omitempty"`
+}
+
+// Variable is a variable of a VariableStore.
+type Variable struct {
+	buf []byte
+	// Format is one of the Variable formats.
+	Format string
+	// GUID is the vendor GUID of the variables of VSS stores.
+	GUID       *guid.GUID `json:",omitempty"`
+	Name       string
+	State      uint8  `json:",omitempty"`
+	Attributes uint32 `json:",omitempty"`
+	// DataCRC32 is the CRC32 of the data of Apple variables.
+	DataCRC32 uint32 `json:",omitempty"`
+
+	// Offset in the store.
+	Offset     uint64
+	DataOffset uint64
+}
+
+// Data returns the data of the variable.
+func (v *Variable) Data() []byte {
+	return v.buf[v.DataOffset:]
+}
+
+// IsValid reports whether the variable is in use: added and not deleted,
+// or being replaced. The variables of Apple Fsys and Gaid stores have no
+// state.
+func (v *Variable) IsValid() bool {
+	if v.Format == VariableFormatFsys {
+		return true
+	}
+	return v.State == VariableAdded || v.State == VariableAdded&VariableInDeletedTransition
+}
+
+// CRC32Valid reports whether the CRC32 of an Apple variable matches its
+// data. It is true for the other formats.
+func (v *Variable) CRC32Valid() bool {
+	return v.Format != VariableFormatApple || crc32.ChecksumIEEE(v.Data()) == v.DataCRC32
+}
+
+// String returns the format and the name of the variable, and whether it
+// is deleted.
+func (v *Variable) String() string {
+	if !v.IsValid() {
+		return fmt.Sprintf("[%s] %s (deleted)", v.Format, v.Name)
+	}
+	return fmt.Sprintf("[%s] %s", v.Format, v.Name)
+}
+
+// CRC32Valid reports whether the CRC32 of an Apple store matches its
+// content. It is true for VSS stores.
+func (s *VariableStore) CRC32Valid() bool {
+	if s.Type != VariableStoreFsys && s.Type != VariableStoreGaid {
+		return true
+	}
+	return crc32.ChecksumIEEE(s.buf[:len(s.buf)-4]) == s.CRC32
+}
+
+// vssStoreAt returns the type, header length and size of the VSS store at
+// the start of buf, if there is one.
+func vssStoreAt(buf []byte) (string, uint64, uint64, bool) {
+	t, hl := "", uint64(0)
+	switch {
+	case len(buf) < vssStoreHeaderLength:
+		return "", 0, 0, false
+	case bytes.HasPrefix(buf, []byte("$VSS")), bytes.HasPrefix(buf, []byte("$SVS")):
+		t, hl = VariableStoreVSS, vssStoreHeaderLength
+	case len(buf) < vssGUIDStoreHeaderLength:
+		return "", 0, 0, false
+	case bytes.HasPrefix(buf, VariableStoreGUID[:]):
+		t, hl = VariableStoreVSS, vssGUIDStoreHeaderLength
+	case bytes.HasPrefix(buf, AuthVariableStoreGUID[:]):
+		t, hl = VariableStoreAuthVSS, vssGUIDStoreHeaderLength
+	default:
+		return "", 0, 0, false
+	}
+	size := uint64(binary.LittleEndian.Uint32(buf[hl-12:]))
+	if buf[hl-8] != vssStoreFormatted || size < hl || size > uint64(len(buf)) {
+		return "", 0, 0, false
+	}
+	return t, hl, size, true
+}
+
+// newVSSStore parses the variables of the VSS store of type t at the start
+// of buf, whose header is hl bytes long.
+func newVSSStore(ctx context.Context, buf []byte, t string, hl uint64, offset uint64) *VariableStore {
+	s := &VariableStore{buf: copyBuf(buf), Type: t, Offset: offset}
+	for o := hl; o+vssVariableHeaderLength[VariableFormatStandard] <= uint64(len(s.buf)); {
+		h := s.buf[o:]
+		if binary.LittleEndian.Uint16(h) != vssStartID {
+			break
+		}
+		v := &Variable{Format: VariableFormatStandard, State: h[2], Attributes: binary.LittleEndian.Uint32(h[4:]), Offset: o}
+		switch {
+		case t == VariableStoreAuthVSS, v.Attributes&(VariableAuthenticatedWriteAccess|VariableTimeBasedAuthenticatedWriteAccess) != 0:
+			v.Format = VariableFormatAuth
+		case v.Attributes&VariableAppleDataChecksum != 0:
+			v.Format = VariableFormatApple
+		}
+		vhl := vssVariableHeaderLength[v.Format]
+		if o+vhl > uint64(len(s.buf)) {
+			log.FromContext(ctx).Warnf("%s variable header at %#x out of the store", v.Format, o)
+			break
+		}
+		// The sizes and the GUID end the header, but for the CRC32 of
+		// Apple variables.
+		sizes := vhl - 24
+		if v.Format == VariableFormatApple {
+			sizes -= 4
+			v.DataCRC32 = binary.LittleEndian.Uint32(h[vhl-4:])
+		}
+		nameSize := uint64(binary.LittleEndian.Uint32(h[sizes:]))
+		dataSize := uint64(binary.LittleEndian.Uint32(h[sizes+4:]))
+		var g guid.GUID
+		copy(g[:], h[sizes+8:])
+		v.GUID = &g
+		end := o + vhl + nameSize + dataSize
+		if end > uint64(len(s.buf)) || end < o {
+			log.FromContext(ctx).Warnf("%s variable at %#x of %#x bytes of name and %#x of data out of the store", v.Format, o, nameSize, dataSize)
+			break
+		}
+		v.buf = s.buf[o:end]
+		if nameSize >= 2 {
+			v.Name = unicode.UCS2ToUTF8(v.buf[vhl : vhl+nameSize])
+		}
+		v.DataOffset = vhl + nameSize
+		s.Variables = append(s.Variables, v)
+		o = Align4(end)
+	}
+	return s
+}
+
+// appleStoreAt returns the type and size of the Apple Fsys or Gaid store
+// at the start of buf, if there is one.
+func appleStoreAt(buf []byte) (string, uint64, bool) {
+	if len(buf) < appleStoreHeaderLength {
+		return "", 0, false
+	}
+	var t string
+	switch string(buf[:4]) {
+	case "Fsys":
+		t = VariableStoreFsys
+	case "Gaid":
+		t = VariableStoreGaid
+	default:
+		return "", 0, false
+	}
+	size := uint64(binary.LittleEndian.Uint16(buf[9:]))
+	if size < appleStoreHeaderLength+4 || size > uint64(len(buf)) {
+		return "", 0, false
+	}
+	return t, size, true
+}
+
+// newAppleStore parses the variables of the Apple store of type t at the
+// start of buf: a byte of name size, the name, two bytes of data size and
+// the data, up to the variable named EOF. A CRC32 of the rest ends the
+// store.
+func newAppleStore(ctx context.Context, buf []byte, t string, offset uint64) *VariableStore {
+	s := &VariableStore{buf: copyBuf(buf), Type: t, Offset: offset}
+	s.CRC32 = binary.LittleEndian.Uint32(s.buf[len(s.buf)-4:])
+	end := uint64(len(s.buf) - 4)
+	for o := uint64(appleStoreHeaderLength); o < end; {
+		nameSize := uint64(s.buf[o])
+		if o+1+nameSize > end {
+			log.FromContext(ctx).Warnf("%s variable at %#x out of the store", t, o)
+			break
+		}
+		name := string(s.buf[o+1 : o+1+nameSize])
+		if name == "EOF" {
+			break
+		}
+		if o+3+nameSize > end {
+			log.FromContext(ctx).Warnf("%s variable %q out of the store", t, name)
+			break
+		}
+		dataSize := uint64(binary.LittleEndian.Uint16(s.buf[o+1+nameSize:]))
+		vEnd := o + 3 + nameSize + dataSize
+		if vEnd > end {
+			log.FromContext(ctx).Warnf("%s variable %q of %#x bytes out of the store", t, name, dataSize)
+			break
+		}
+		s.Variables = append(s.Variables, &Variable{
+			buf:        s.buf[o:vEnd],
+			Format:     VariableFormatFsys,
+			Name:       name,
+			Offset:     o,
+			DataOffset: 3 + nameSize,
+		})
+		o = vEnd
+	}
+	return s
+}
+
+// findVariableStores finds the variable stores of the data of an NVRAM
+// volume, on 4-byte boundaries.
+func findVariableStores(ctx context.Context, data []byte) []*VariableStore {
+	var stores []*VariableStore
+	for o := uint64(0); o+appleStoreHeaderLength <= uint64(len(data)); {
+		if t, hl, size, ok := vssStoreAt(data[o:]); ok {
+			stores = append(stores, newVSSStore(ctx, data[o:o+size], t, hl, o))
+			o = Align4(o + size)
+			continue
+		}
+		if t, size, ok := appleStoreAt(data[o:]); ok {
+			stores = append(stores, newAppleStore(ctx, data[o:o+size], t, o))
+			o = Align4(o + size)
+			continue
+		}
+		o += 4
+	}
+	return stores
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (s *VariableStore) Buf() []byte {
+	return s.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (s *VariableStore) SetBuf(buf []byte) {
+	s.buf = buf
+}
+
+// Apply calls the visitor on the VariableStore.
+func (s *VariableStore) Apply(v Visitor) error {
+	return visit(v, s)
+}
+
+// ApplyChildren calls the visitor on each child node of VariableStore.
+func (s *VariableStore) ApplyChildren(v Visitor) error {
+	for _, vr := range s.Variables {
+		if err := vr.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (v *Variable) Buf() []byte {
+	return v.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (v *Variable) SetBuf(buf []byte) {
+	v.buf = buf
+}
+
+// Apply calls the visitor on the Variable.
+func (v *Variable) Apply(vr Visitor) error {
+	return visit(vr, v)
+}
+
+// ApplyChildren calls the visitor on each child node of Variable.
+func (v *Variable) ApplyChildren(vr Visitor) error {
+	return nil
+}
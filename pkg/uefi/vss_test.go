@@ -0,0 +1,129 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+// vssVariable returns a variable of a VSS store in format.
+func vssVariable(format string, state uint8, attrs uint32, name string, data []byte) []byte {
+	vhl := vssVariableHeaderLength[format]
+	n := unicode.UTF8ToUCS2(name)
+	h := make([]byte, vhl)
+	binary.LittleEndian.PutUint16(h, vssStartID)
+	h[2] = state
+	binary.LittleEndian.PutUint32(h[4:], attrs)
+	sizes := vhl - 24
+	if format == VariableFormatApple {
+		sizes -= 4
+		binary.LittleEndian.PutUint32(h[vhl-4:], crc32.ChecksumIEEE(data))
+	}
+	binary.LittleEndian.PutUint32(h[sizes:], uint32(len(n)))
+	binary.LittleEndian.PutUint32(h[sizes+4:], uint32(len(data)))
+	copy(h[sizes+8:], guid.MustParse("7C436110-AB2A-4BBB-A880-FE41995C9F82")[:])
+	v := append(append(h, n...), data...)
+	return append(v, make([]byte, Align4(uint64(len(v)))-uint64(len(v)))...)
+}
+
+// vssStore returns a $VSS store of size bytes holding vars.
+func vssStore(size int, vars ...[]byte) []byte {
+	s := append([]byte("$VSS"), make([]byte, vssStoreHeaderLength-4)...)
+	binary.LittleEndian.PutUint32(s[4:], uint32(size))
+	s[8] = vssStoreFormatted
+	for _, v := range vars {
+		s = append(s, v...)
+	}
+	return append(s, bytes.Repeat([]byte{0xff}, size-len(s))...)
+}
+
+// appleStore returns an Fsys store of variables named and valued alike.
+func appleStore(vars ...string) []byte {
+	s := append([]byte("Fsys"), make([]byte, appleStoreHeaderLength-4)...)
+	for _, v := range append(vars, "EOF") {
+		s = append(append(s, byte(len(v))), v...)
+		if v != "EOF" {
+			s = append(append(s, byte(len(v)), 0), v...)
+		}
+	}
+	binary.LittleEndian.PutUint16(s[9:], uint16(len(s)+4))
+	sum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sum, crc32.ChecksumIEEE(s))
+	return append(s, sum...)
+}
+
+func TestFindVariableStores(t *testing.T) {
+	vss := vssStore(0x200,
+		vssVariable(VariableFormatStandard, VariableAdded, 7, "Lang", []byte("eng")),
+		vssVariable(VariableFormatApple, VariableAdded, 7|VariableAppleDataChecksum, "boot-args", []byte("-v")),
+		vssVariable(VariableFormatAuth, VariableAdded&VariableInDeletedTransition, 7|VariableTimeBasedAuthenticatedWriteAccess, "db", []byte{1, 2}),
+		vssVariable(VariableFormatStandard, 0x3c, 7, "Old", []byte{3}),
+	)
+	fsys := appleStore("SystemSerialNumber", "ssn")
+	data := append(append(append(bytes.Repeat([]byte{0xff}, 0x10), vss...), fsys...), bytes.Repeat([]byte{0xff}, 0x40)...)
+
+	stores := findVariableStores(context.Background(), data)
+	if len(stores) != 2 {
+		t.Fatalf("got %d stores, want 2", len(stores))
+	}
+	s := stores[0]
+	if s.Type != VariableStoreVSS || s.Offset != 0x10 || len(s.Buf()) != 0x200 {
+		t.Errorf("got a %s store at %#x of %#x bytes, want a VSS one at 0x10 of 0x200", s.Type, s.Offset, len(s.Buf()))
+	}
+	want := []struct {
+		format string
+		name   string
+		data   string
+		valid  bool
+	}{
+		{VariableFormatStandard, "Lang", "eng", true},
+		{VariableFormatApple, "boot-args", "-v", true},
+		{VariableFormatAuth, "db", "\x01\x02", true},
+		{VariableFormatStandard, "Old", "\x03", false},
+	}
+	if len(s.Variables) != len(want) {
+		t.Fatalf("got %d variables, want %d", len(s.Variables), len(want))
+	}
+	for i, w := range want {
+		v := s.Variables[i]
+		if v.Format != w.format || v.Name != w.name || string(v.Data()) != w.data || v.IsValid() != w.valid || !v.CRC32Valid() {
+			t.Errorf("got variable %d %v with data %q, want %s %q with data %q, valid %v", i, v, v.Data(), w.format, w.name, w.data, w.valid)
+		}
+	}
+
+	s = stores[1]
+	if s.Type != VariableStoreFsys || s.Offset != uint64(0x10+len(vss)) || !s.CRC32Valid() {
+		t.Errorf("got a %s store at %#x, CRC32 valid %v, want an Fsys one at %#x with a valid CRC32", s.Type, s.Offset, s.CRC32Valid(), 0x10+len(vss))
+	}
+	if len(s.Variables) != 2 || s.Variables[0].Name != "SystemSerialNumber" || string(s.Variables[1].Data()) != "ssn" {
+		t.Errorf("got variables %v, want SystemSerialNumber and ssn", s.Variables)
+	}
+}
+
+func TestVariableCRC32(t *testing.T) {
+	v := vssVariable(VariableFormatApple, VariableAdded, VariableAppleDataChecksum, "boot-args", []byte("-v"))
+	v[len(v)-4] ^= 1
+	s := findVariableStores(context.Background(), vssStore(0x100, v))
+	if len(s) != 1 || len(s[0].Variables) != 1 {
+		t.Fatalf("got %d stores, want 1 of 1 variable", len(s))
+	}
+	if s[0].Variables[0].CRC32Valid() {
+		t.Errorf("got a valid CRC32 for corrupt data")
+	}
+
+	fsys := appleStore("a")
+	fsys[appleStoreHeaderLength+1] = 'b'
+	s = findVariableStores(context.Background(), fsys)
+	if len(s) != 1 || s[0].CRC32Valid() {
+		t.Errorf("got a valid CRC32 for a corrupt Fsys store")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptFlag introduces a script file among the operations.
+const ScriptFlag = "-script"
+
+// A script is a file of operations, in YAML or JSON:
+//
+//	# Replace the shell with Linux.
+//	vars:
+//	  shell: 7C04A583-9E3E-4F1C-AD65-E05268D0B4D1
+//	  out: winterfell2.rom
+//	ops:
+//	  - remove ${shell}
+//	  - [replace_pe32, Shell, linux.efi]
+//	  - save $out
+//
+// Each operation is either a string of arguments separated by spaces or a
+// list of arguments. Arguments may use the variables, as $name or ${name}.
+// Paths are relative to the working directory, like on the command line.
+type script struct {
+	Vars map[string]string `yaml:"vars"`
+	Ops  []yaml.Node       `yaml:"ops"`
+}
+
+// ExpandScripts returns the operations with each ScriptFlag and the script
+// file following it replaced by the operations of the script.
+func ExpandScripts(ops []string) ([]string, error) {
+	var r []string
+	for i := 0; i < len(ops); i++ {
+		if ops[i] != ScriptFlag {
+			r = append(r, ops[i])
+			continue
+		}
+		if i+1 == len(ops) {
+			return nil, fmt.Errorf("%s needs a file", ScriptFlag)
+		}
+		i++
+		s, err := LoadScript(ops[i])
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, s...)
+	}
+	return r, nil
+}
+
+// LoadScript returns the operations of the script file at path, with the
+// variables expanded.
+func LoadScript(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s script
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var ops []string
+	for _, n := range s.Ops {
+		var args []string
+		switch n.Kind {
+		case yaml.ScalarNode:
+			args = strings.Fields(n.Value)
+		case yaml.SequenceNode:
+			if err := n.Decode(&args); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n.Line, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: an operation is a string or a list of arguments", path, n.Line)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s:%d: empty operation", path, n.Line)
+		}
+		for _, a := range args {
+			var undefined []string
+			a = os.Expand(a, func(name string) string {
+				v, ok := s.Vars[name]
+				if !ok {
+					undefined = append(undefined, name)
+				}
+				return v
+			})
+			if len(undefined) != 0 {
+				return nil, fmt.Errorf("%s:%d: undefined variable %q", path, n.Line, undefined[0])
+			}
+			ops = append(ops, a)
+		}
+	}
+	return ops, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExpandScripts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "script-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	yamlScript := writeScript(t, dir, "ops.yaml", `# Replace the shell.
+vars:
+  shell: 7C04A583-9E3E-4F1C-AD65-E05268D0B4D1
+  out: new.rom
+ops:
+  - remove ${shell}   # by GUID
+  - [replace_pe32, Shell, "linux efi.efi"]
+  - save $out
+`)
+	jsonScript := writeScript(t, dir, "ops.json", `{"vars": {"n": "DxeCore"}, "ops": [["find", "$n"]]}`)
+
+	got, err := ExpandScripts([]string{"table", ScriptFlag, yamlScript, ScriptFlag, jsonScript, "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"table",
+		"remove", "7C04A583-9E3E-4F1C-AD65-E05268D0B4D1",
+		"replace_pe32", "Shell", "linux efi.efi",
+		"save", "new.rom",
+		"find", "DxeCore",
+		"json",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandScriptsErrors(t *testing.T) {
+	dir, err := os.MkdirTemp("", "script-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"undefined.yaml": "ops:\n  - remove $missing\n",
+		"empty.yaml":     "ops:\n  - []\n",
+		"mapping.yaml":   "ops:\n  - {remove: Shell}\n",
+		"syntax.yaml":    "ops: [remove\n",
+	} {
+		p := writeScript(t, dir, name, content)
+		if _, err := ExpandScripts([]string{ScriptFlag, p}); err == nil {
+			t.Errorf("%s: expanding succeeded, want an error", name)
+		}
+	}
+	if _, err := ExpandScripts([]string{"table", ScriptFlag}); err == nil {
+		t.Errorf("expanding %s without a file succeeded, want an error", ScriptFlag)
+	}
+}
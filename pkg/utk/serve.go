@@ -0,0 +1,355 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/linuxboot/fiano/pkg/firmware"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// Defaults of the limits of a Server.
+const (
+	DefaultMaxImageSize = 256 << 20
+	DefaultMaxImages    = 16
+	DefaultMaxTotalSize = 1 << 30
+)
+
+// DefaultServerParseLimits bound the parses of a Server, for the limits its
+// context leaves at 0.
+var DefaultServerParseLimits = uefi.ParseLimits{
+	MaxDepth:            uefi.DefaultMaxDepth,
+	MaxDecompressedSize: 1 << 30,
+	MaxNodeSize:         256 << 20,
+	MaxNodes:            1 << 16,
+}
+
+// maxOpsSize is the largest request of operations.
+const maxOpsSize = 1 << 20
+
+// serverOps are the operations the server applies, which edit the tree in
+// memory with their arguments only, and neither read nor write files on the
+// server nor run commands.
+var serverOps = map[string]bool{
+	"apcb_set":        true,
+	"invalidate_nvar": true,
+	"move_region":     true,
+	"remove":          true,
+	"remove_by_size":  true,
+	"remove_pad":      true,
+	"resize_region":   true,
+	"set_chip_size":   true,
+	"set_fv_name":     true,
+	"set_mac":         true,
+	"set_ui":          true,
+	"set_version":     true,
+	"strip_oprom":     true,
+	"sync_backup":     true,
+	"tighten_me":      true,
+}
+
+// Server serves the operations of utk over HTTP, on images kept in memory
+// between requests. Requests and responses are JSON, except for images:
+//
+//	POST   /images                  upload an image, returns {"id": ID}
+//	GET    /images                  list the IDs of the images
+//	GET    /images/ID               the JSON tree of the image
+//	DELETE /images/ID               forget the image
+//	GET    /images/ID/find?q=QUERY  the files matching, like find
+//	POST   /images/ID/ops           apply {"ops": [...]}, operations and
+//	                                their arguments like those of utk
+//	GET    /images/ID/validate      {"errors": [...]}
+//	GET    /images/ID/body          the assembled image
+//
+// Errors are {"error": MESSAGE} with a 4xx or 5xx status. Uploads beyond
+// MaxImages or MaxTotalSize are refused with 429 until images are deleted,
+// and images are parsed within DefaultServerParseLimits. Only the
+// operations editing the tree in memory, like remove or set_ui, are
+// applied; the others are refused, since they would read or write files on
+// the server, run commands, or write reports the endpoints return instead.
+type Server struct {
+	// MaxImageSize is the largest image uploaded, DefaultMaxImageSize if 0.
+	MaxImageSize int64
+	// MaxImages is the number of images kept, DefaultMaxImages if 0.
+	MaxImages int
+	// MaxTotalSize is the total size of the images kept, as uploaded,
+	// DefaultMaxTotalSize if 0.
+	MaxTotalSize int64
+
+	ctx    context.Context
+	mu     sync.Mutex
+	images map[string]*serverImage
+	// size is the total size of images.
+	size int64
+}
+
+type serverImage struct {
+	mu   sync.Mutex
+	im   *firmware.Image
+	size int64
+}
+
+// NewServer returns a Server parsing and running the operations with the
+// options of ctx, within DefaultServerParseLimits for the limits it leaves
+// at 0.
+func NewServer(ctx context.Context) *Server {
+	opts := *uefi.ParseOptionsOf(ctx)
+	limits := DefaultServerParseLimits
+	if l := opts.Limits; l != nil {
+		if l.MaxDepth > 0 {
+			limits.MaxDepth = l.MaxDepth
+		}
+		if l.MaxDecompressedSize > 0 {
+			limits.MaxDecompressedSize = l.MaxDecompressedSize
+		}
+		if l.MaxNodeSize > 0 {
+			limits.MaxNodeSize = l.MaxNodeSize
+		}
+		if l.MaxNodes > 0 {
+			limits.MaxNodes = l.MaxNodes
+		}
+	}
+	opts.Limits = &limits
+	return &Server{ctx: uefi.WithParseOptions(ctx, &opts), images: map[string]*serverImage{}}
+}
+
+// Serve serves the operations on addr, like localhost:8080, until ctx is
+// done.
+func Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: NewServer(ctx)}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		srv.Close()
+		return ctx.Err()
+	}
+}
+
+// httpError is an error with the HTTP status of the response.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+func statusError(status int, format string, a ...interface{}) error {
+	return &httpError{status: status, err: fmt.Errorf(format, a...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.serve(w, r); err != nil {
+		status := http.StatusUnprocessableEntity
+		var he *httpError
+		if errors.As(err, &he) {
+			status = he.status
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "images" || len(parts) > 3 {
+		return statusError(http.StatusNotFound, "no such endpoint %q", r.URL.Path)
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			return s.list(w)
+		case http.MethodPost:
+			return s.upload(w, r)
+		}
+		return statusError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+
+	if len(parts) == 2 && r.Method == http.MethodDelete {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.images[parts[1]]; !ok {
+			return statusError(http.StatusNotFound, "no image %q", parts[1])
+		}
+		s.size -= s.images[parts[1]].size
+		delete(s.images, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	s.mu.Lock()
+	si, ok := s.images[parts[1]]
+	s.mu.Unlock()
+	if !ok {
+		return statusError(http.StatusNotFound, "no image %q", parts[1])
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	endpoint := ""
+	if len(parts) == 3 {
+		endpoint = parts[2]
+	}
+	method := map[string]string{
+		"":         http.MethodGet,
+		"find":     http.MethodGet,
+		"ops":      http.MethodPost,
+		"validate": http.MethodGet,
+		"body":     http.MethodGet,
+	}
+	want, ok := method[endpoint]
+	if !ok {
+		return statusError(http.StatusNotFound, "no such endpoint %q", r.URL.Path)
+	}
+	if r.Method != want {
+		return statusError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+
+	switch endpoint {
+	case "":
+		w.Header().Set("Content-Type", "application/json")
+		return si.im.Root.Apply(&visitors.JSON{W: w})
+	case "find":
+		matches, err := si.im.Find(r.URL.Query().Get("q"))
+		if err != nil {
+			return err
+		}
+		if matches == nil {
+			matches = []uefi.Firmware{}
+		}
+		return writeJSON(w, http.StatusOK, matches)
+	case "ops":
+		return s.ops(w, r, si.im)
+	case "validate":
+		errs, err := si.im.Validate()
+		if err != nil {
+			return err
+		}
+		msgs := []string{}
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		return writeJSON(w, http.StatusOK, map[string][]string{"errors": msgs})
+	default: // body
+		buf, err := si.im.Bytes()
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, err = w.Write(buf)
+		return err
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter) error {
+	s.mu.Lock()
+	ids := []string{}
+	for id := range s.images {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	return writeJSON(w, http.StatusOK, map[string][]string{"ids": ids})
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) error {
+	max := s.MaxImageSize
+	if max == 0 {
+		max = DefaultMaxImageSize
+	}
+	if err := s.room(0); err != nil {
+		return err
+	}
+	im, err := firmware.ReadContext(s.ctx, http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return statusError(http.StatusInternalServerError, "%v", err)
+	}
+	id := hex.EncodeToString(b)
+	size := int64(len(im.Root.Buf()))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Other uploads may have taken the room while this one was parsed.
+	if err := s.roomLocked(size); err != nil {
+		return err
+	}
+	s.images[id] = &serverImage{im: im, size: size}
+	s.size += size
+	return writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+}
+
+// room returns an error with status 429 unless an image of size bytes may
+// be kept.
+func (s *Server) room(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.roomLocked(size)
+}
+
+// roomLocked is room with s.mu held.
+func (s *Server) roomLocked(size int64) error {
+	maxImages, maxSize := s.MaxImages, s.MaxTotalSize
+	if maxImages == 0 {
+		maxImages = DefaultMaxImages
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxTotalSize
+	}
+	if len(s.images) >= maxImages {
+		return statusError(http.StatusTooManyRequests, "%d images kept already, delete some first", len(s.images))
+	}
+	if s.size+size > maxSize {
+		return statusError(http.StatusTooManyRequests, "the images kept take %d of %d bytes, delete some first", s.size, maxSize)
+	}
+	return nil
+}
+
+func (s *Server) ops(w http.ResponseWriter, r *http.Request, im *firmware.Image) error {
+	var req struct{ Ops []string }
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOpsSize)).Decode(&req); err != nil {
+		return statusError(http.StatusBadRequest, "decoding the operations: %v", err)
+	}
+	names, err := visitors.CLINames(req.Ops)
+	if err != nil {
+		return statusError(http.StatusBadRequest, "%v", err)
+	}
+	for _, n := range names {
+		if !serverOps[n] {
+			return statusError(http.StatusForbidden, "operation %s is not applied by the server", n)
+		}
+	}
+	v, err := visitors.ParseCLI(req.Ops)
+	if err != nil {
+		return statusError(http.StatusBadRequest, "%v", err)
+	}
+	if err := visitors.ExecuteCLIContext(im.Context(), im.Root, v); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, map[string]int{"applied": len(v)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
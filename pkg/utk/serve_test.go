@@ -0,0 +1,137 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func request(t *testing.T, srv *httptest.Server, method, path, body string, wantStatus int) []byte {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: got status %d, want %d: %s", method, path, resp.StatusCode, wantStatus, b)
+	}
+	return b
+}
+
+func TestServer(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewServer(context.Background()))
+	defer srv.Close()
+
+	var created struct{ ID string }
+	if err := json.Unmarshal(request(t, srv, "POST", "/images", string(image), http.StatusCreated), &created); err != nil {
+		t.Fatal(err)
+	}
+	img := "/images/" + created.ID
+
+	var matches []map[string]interface{}
+	if err := json.Unmarshal(request(t, srv, "GET", img+"/find?q=Shell", "", http.StatusOK), &matches); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("found %d Shell files, want 1", len(matches))
+	}
+
+	request(t, srv, "POST", img+"/ops", `{"ops": ["remove", "Shell"]}`, http.StatusOK)
+	request(t, srv, "POST", img+"/ops", `{"ops": ["save", "/tmp/x.rom"]}`, http.StatusForbidden)
+	request(t, srv, "POST", img+"/ops", `{"ops": ["dump_pe", "DxeCore", "/tmp/x.efi"]}`, http.StatusForbidden)
+	request(t, srv, "POST", img+"/ops", `{"ops": ["table"]}`, http.StatusForbidden)
+	request(t, srv, "POST", img+"/ops", `{"ops": ["no_such_op"]}`, http.StatusBadRequest)
+	request(t, srv, "POST", img+"/ops", `{"ops": ["remove", "`+strings.Repeat("x", maxOpsSize)+`"]}`, http.StatusBadRequest)
+	if b := request(t, srv, "GET", img+"/find?q=Shell", "", http.StatusOK); strings.TrimSpace(string(b)) != "[]" {
+		t.Errorf("found %s after removing Shell, want none", b)
+	}
+	if b := request(t, srv, "GET", img+"/validate", "", http.StatusOK); !bytes.Contains(b, []byte(`"errors":[]`)) {
+		t.Errorf("got %s, want no validation errors", b)
+	}
+
+	body := request(t, srv, "GET", img+"/body", "", http.StatusOK)
+	if len(body) != len(image) {
+		t.Errorf("got an image of %d bytes, want %d", len(body), len(image))
+	}
+	if _, err := uefi.Parse(body); err != nil {
+		t.Errorf("parsing the assembled image: %v", err)
+	}
+	request(t, srv, "GET", img, "", http.StatusOK)
+
+	request(t, srv, "DELETE", img, "", http.StatusNoContent)
+	request(t, srv, "GET", img+"/body", "", http.StatusNotFound)
+	request(t, srv, "GET", "/nothing", "", http.StatusNotFound)
+}
+
+func TestServerMaxImageSize(t *testing.T) {
+	s := NewServer(context.Background())
+	s.MaxImageSize = 16
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	request(t, srv, "POST", "/images", strings.Repeat("\xff", 17), http.StatusUnprocessableEntity)
+	if b := request(t, srv, "GET", "/images", "", http.StatusOK); !bytes.Contains(b, []byte(`"ids":[]`)) {
+		t.Errorf("got %s, want no images", b)
+	}
+}
+
+func TestServerMaxImages(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Server{
+		{MaxImages: 1},
+		{MaxTotalSize: int64(len(image)) + 1},
+	} {
+		s.ctx, s.images = context.Background(), map[string]*serverImage{}
+		srv := httptest.NewServer(s)
+		var created struct{ ID string }
+		if err := json.Unmarshal(request(t, srv, "POST", "/images", string(image), http.StatusCreated), &created); err != nil {
+			t.Fatal(err)
+		}
+		request(t, srv, "POST", "/images", string(image), http.StatusTooManyRequests)
+		request(t, srv, "DELETE", "/images/"+created.ID, "", http.StatusNoContent)
+		request(t, srv, "POST", "/images", string(image), http.StatusCreated)
+		srv.Close()
+	}
+}
+
+func TestServerParseLimits(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := uefi.ParseOptionsOf(NewServer(context.Background()).ctx).Limits; l == nil || *l != DefaultServerParseLimits {
+		t.Errorf("got parse limits %+v, want %+v", l, DefaultServerParseLimits)
+	}
+	ctx := uefi.WithParseOptions(context.Background(), &uefi.ParseOptions{Limits: &uefi.ParseLimits{MaxNodes: 64}})
+	srv := httptest.NewServer(NewServer(ctx))
+	defer srv.Close()
+	if b := request(t, srv, "POST", "/images", string(image), http.StatusUnprocessableEntity); !bytes.Contains(b, []byte(uefi.ErrParseLimit.Error())) {
+		t.Errorf("got %s, want the upload beyond the parse limits refused", b)
+	}
+}
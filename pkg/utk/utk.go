@@ -6,54 +6,101 @@
 package utk
 
 import (
+	"context"
 	"errors"
-	"os"
+	"io"
 
+	"github.com/linuxboot/fiano/pkg/firmware"
 	"github.com/linuxboot/fiano/pkg/uefi"
 	"github.com/linuxboot/fiano/pkg/visitors"
 )
 
 // Run runs the utk command with the given arguments.
 func Run(args ...string) error {
+	return RunContext(context.Background(), args...)
+}
+
+// RunContext is like Run, but stops parsing or the operations with the error
+// of ctx once it is done.
+func RunContext(ctx context.Context, args ...string) error {
 	if len(args) == 0 {
 		return errors.New("at least one argument is required")
 	}
 
-	v, err := visitors.ParseCLI(args[1:])
+	ops, err := ExpandScripts(args[1:])
 	if err != nil {
 		return err
 	}
-
-	// Load and parse the image.
-	path := args[0]
-	f, err := os.Stat(path)
+	v, err := visitors.ParseCLI(ops)
 	if err != nil {
 		return err
 	}
-	var parsedRoot uefi.Firmware
-	if m := f.Mode(); m.IsDir() {
-		// Call ParseDir
-		pd := visitors.ParseDir{BasePath: path}
-		if parsedRoot, err = pd.Parse(); err != nil {
-			return err
-		}
-		// Assemble the tree from the bottom up
-		a := visitors.Assemble{}
-		if err = a.Run(parsedRoot); err != nil {
-			return err
-		}
-	} else {
-		// Regular file
-		image, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		parsedRoot, err = uefi.Parse(image)
-		if err != nil {
-			return err
-		}
+
+	parsedRoot, err := loadContext(ctx, args[0])
+	if err != nil {
+		return err
 	}
 
 	// Execute the instructions from the command line.
-	return visitors.ExecuteCLI(parsedRoot, v)
+	return visitors.ExecuteCLIContext(ctx, parsedRoot, v)
+}
+
+// DryRun applies the operations of the utk command with the given arguments
+// without writing any file, and writes the plan of what they change to w.
+func DryRun(w io.Writer, args ...string) error {
+	return DryRunContext(context.Background(), w, args...)
+}
+
+// DryRunContext is like DryRun, but parses the image with ctx.
+func DryRunContext(ctx context.Context, w io.Writer, args ...string) error {
+	if len(args) == 0 {
+		return errors.New("at least one argument is required")
+	}
+
+	ops, err := ExpandScripts(args[1:])
+	if err != nil {
+		return err
+	}
+	parsedRoot, err := loadContext(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	plan, err := visitors.DryRunCLIContext(ctx, parsedRoot, ops)
+	if err != nil {
+		return err
+	}
+	return plan.Write(w)
+}
+
+// Interactive loads the image and runs a shell on it, reading commands from
+// in and writing to out.
+func Interactive(path string, in io.Reader, out io.Writer) error {
+	return InteractiveContext(context.Background(), path, in, out)
+}
+
+// InteractiveContext is like Interactive, but parses the image with ctx.
+func InteractiveContext(ctx context.Context, path string, in io.Reader, out io.Writer) error {
+	parsedRoot, err := loadContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	sh := &visitors.Shell{
+		Root:    parsedRoot,
+		In:      in,
+		Out:     out,
+		Prompt:  "utk> ",
+		Context: ctx,
+	}
+	return sh.Run()
+}
+
+// loadContext loads and parses the image, or the directory or archive it was
+// extracted to.
+func loadContext(ctx context.Context, path string) (uefi.Firmware, error) {
+	im, err := firmware.OpenContext(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return im.Root, nil
 }
@@ -0,0 +1,89 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// GBBSignature starts the Google Binary Block.
+const GBBSignature = "$GBB"
+
+// GBBHeader is the header of the Google Binary Block (struct
+// vb2_gbb_header). All offsets are relative to the start of the GBB.
+type GBBHeader struct {
+	Signature         [4]byte
+	MajorVersion      uint16
+	MinorVersion      uint16
+	HeaderSize        uint32
+	Flags             uint32
+	HWIDOffset        uint32
+	HWIDSize          uint32
+	RootKeyOffset     uint32
+	RootKeySize       uint32
+	BmpFVOffset       uint32
+	BmpFVSize         uint32
+	RecoveryKeyOffset uint32
+	RecoveryKeySize   uint32
+	HWIDDigest        [32]byte
+	Pad               [48]byte
+}
+
+// GBB is the parsed Google Binary Block, which holds the keys the RW
+// firmware slots are verified with.
+type GBB struct {
+	GBBHeader
+	HWID        string
+	RootKey     *PackedKey
+	RecoveryKey *PackedKey
+}
+
+// ParseGBB parses the GBB at the start of b.
+func ParseGBB(b []byte) (*GBB, error) {
+	var g GBB
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &g.GBBHeader); err != nil {
+		return nil, fmt.Errorf("GBB header: %v", err)
+	}
+	if string(g.Signature[:]) != GBBSignature {
+		return nil, fmt.Errorf("GBB signature %q, want %q", g.Signature[:], GBBSignature)
+	}
+	if g.MajorVersion != 1 {
+		return nil, fmt.Errorf("unsupported GBB version %d.%d", g.MajorVersion, g.MinorVersion)
+	}
+	field := func(name string, off, size uint32) ([]byte, error) {
+		if uint64(off)+uint64(size) > uint64(len(b)) {
+			return nil, fmt.Errorf("GBB %s [%#x, %#x) out of bounds", name, off, uint64(off)+uint64(size))
+		}
+		return b[off : off+size], nil
+	}
+	hwid, err := field("HWID", g.HWIDOffset, g.HWIDSize)
+	if err != nil {
+		return nil, err
+	}
+	g.HWID = string(hwid)
+	if i := bytes.IndexByte(hwid, 0); i != -1 {
+		g.HWID = string(hwid[:i])
+	}
+	rk, err := field("root key", g.RootKeyOffset, g.RootKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if g.RootKey, err = ParsePackedKey(rk); err != nil {
+		return nil, fmt.Errorf("GBB root key: %v", err)
+	}
+	if g.RecoveryKeySize == 0 {
+		return &g, nil
+	}
+	rk, err = field("recovery key", g.RecoveryKeyOffset, g.RecoveryKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if g.RecoveryKey, err = ParsePackedKey(rk); err != nil {
+		return nil, fmt.Errorf("GBB recovery key: %v", err)
+	}
+	return &g, nil
+}
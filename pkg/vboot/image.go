@@ -0,0 +1,138 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vboot parses and signs the verified boot structures of coreboot
+// images for Chrome OS: the GBB, and the keyblocks and firmware preambles
+// in VBLOCK_A and VBLOCK_B, which verify the RW firmware in FW_MAIN_A and
+// FW_MAIN_B.
+package vboot
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+)
+
+// Slots are the names of the RW firmware slots.
+var Slots = []string{"A", "B"}
+
+// Image is a vboot firmware image.
+type Image struct {
+	FMAP *fmap.FMap
+	GBB  *GBB
+	Data []byte
+}
+
+// NewImage parses the FMAP and the GBB of data.
+func NewImage(data []byte) (*Image, error) {
+	f, _, err := fmap.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	i := &Image{FMAP: f, Data: data}
+	b, err := i.area("GBB")
+	if err != nil {
+		return nil, err
+	}
+	if i.GBB, err = ParseGBB(b); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// area returns the contents of the FMAP area called name.
+func (i *Image) area(name string) ([]byte, error) {
+	x := i.FMAP.IndexOfArea(name)
+	if x == -1 {
+		return nil, fmt.Errorf("no %s in fmap", name)
+	}
+	a := i.FMAP.Areas[x]
+	if uint64(a.Offset)+uint64(a.Size) > uint64(len(i.Data)) {
+		return nil, fmt.Errorf("%s [%#x, %#x) exceeds the image size %#x", name, a.Offset, uint64(a.Offset)+uint64(a.Size), len(i.Data))
+	}
+	return i.Data[a.Offset : a.Offset+a.Size], nil
+}
+
+// VBlock returns the keyblock and the firmware preamble of slot.
+func (i *Image) VBlock(slot string) (*Keyblock, *FirmwarePreamble, error) {
+	b, err := i.area("VBLOCK_" + slot)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := ParseKeyblock(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("VBLOCK_%s: %v", slot, err)
+	}
+	p, err := ParseFirmwarePreamble(b[k.KeyblockSize:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("VBLOCK_%s: %v", slot, err)
+	}
+	return k, p, nil
+}
+
+// Verify checks the keyblock of slot against the GBB root key, and the
+// preamble and the firmware body against the data key.
+func (i *Image) Verify(slot string) error {
+	k, p, err := i.VBlock(slot)
+	if err != nil {
+		return err
+	}
+	if err := k.Verify(i.GBB.RootKey); err != nil {
+		return fmt.Errorf("VBLOCK_%s: %v", slot, err)
+	}
+	body, err := i.area("FW_MAIN_" + slot)
+	if err != nil {
+		return err
+	}
+	if err := p.Verify(k.DataKey, body); err != nil {
+		return fmt.Errorf("VBLOCK_%s: %v", slot, err)
+	}
+	return nil
+}
+
+// Resign signs the whole FW_MAIN area of slot, e.g. after modifying its
+// CBFS, and the preamble with dataKey. If keyblock is not nil, it replaces
+// the keyblock of the slot; it must hold the public part of dataKey.
+// Firmware version, kernel subkey and flags of the preamble are kept.
+func (i *Image) Resign(slot string, dataKey *rsa.PrivateKey, keyblock []byte) error {
+	k, p, err := i.VBlock(slot)
+	if err != nil {
+		return err
+	}
+	if keyblock != nil {
+		if k, err = ParseKeyblock(keyblock); err != nil {
+			return err
+		}
+	}
+	pub, err := k.DataKey.PublicKey()
+	if err != nil {
+		return err
+	}
+	if pub.N.Cmp(dataKey.N) != 0 {
+		return fmt.Errorf("VBLOCK_%s: data key does not match the keyblock", slot)
+	}
+	body, err := i.area("FW_MAIN_" + slot)
+	if err != nil {
+		return err
+	}
+	p, err = NewFirmwarePreamble(p.FirmwareVersion, p.KernelSubkey, body, p.Flags, dataKey, k.DataKey.Algorithm)
+	if err != nil {
+		return err
+	}
+	vb, err := i.area("VBLOCK_" + slot)
+	if err != nil {
+		return err
+	}
+	if len(k.Raw)+len(p.Raw) > len(vb) {
+		return fmt.Errorf("VBLOCK_%s: %#x bytes of keyblock and preamble exceed %#x bytes", slot, len(k.Raw)+len(p.Raw), len(vb))
+	}
+	n := copy(vb, k.Raw)
+	n += copy(vb[n:], p.Raw)
+	for x := range vb[n:] {
+		vb[n+x] = 0xff
+	}
+	return nil
+}
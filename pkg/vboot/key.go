@@ -0,0 +1,223 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Algorithm is the vboot crypto algorithm, combining the RSA key size and
+// the hash used for signatures.
+type Algorithm uint64
+
+// These are the algorithms supported by vboot firmware verification.
+const (
+	RSA1024SHA1 Algorithm = iota
+	RSA1024SHA256
+	RSA1024SHA512
+	RSA2048SHA1
+	RSA2048SHA256
+	RSA2048SHA512
+	RSA4096SHA1
+	RSA4096SHA256
+	RSA4096SHA512
+	RSA8192SHA1
+	RSA8192SHA256
+	RSA8192SHA512
+)
+
+// KeyBits returns the RSA modulus size of the algorithm.
+func (a Algorithm) KeyBits() int {
+	if a > RSA8192SHA512 {
+		return 0
+	}
+	return 1024 << (a / 3)
+}
+
+// Hash returns the hash used for signatures with the algorithm.
+func (a Algorithm) Hash() crypto.Hash {
+	if a > RSA8192SHA512 {
+		return 0
+	}
+	return [...]crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512}[a%3]
+}
+
+func (a Algorithm) String() string {
+	if a > RSA8192SHA512 {
+		return fmt.Sprintf("unknown algorithm %d", uint64(a))
+	}
+	return fmt.Sprintf("RSA%d/%v", a.KeyBits(), a.Hash())
+}
+
+// AlgorithmFor returns the algorithm using h with a key of the size of k.
+func AlgorithmFor(k *rsa.PublicKey, h crypto.Hash) (Algorithm, error) {
+	for a := RSA1024SHA1; a <= RSA8192SHA512; a++ {
+		if a.KeyBits() == k.N.BitLen() && a.Hash() == h {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("no vboot algorithm for RSA%d/%v", k.N.BitLen(), h)
+}
+
+// PackedKeyHeader is the header of a public key (struct vb2_packed_key).
+// The key data is at KeyOffset, relative to the start of the header.
+type PackedKeyHeader struct {
+	KeyOffset  uint64
+	KeySize    uint64
+	Algorithm  Algorithm
+	KeyVersion uint64
+}
+
+// PackedKey is a public key as stored in the GBB, keyblocks and preambles.
+type PackedKey struct {
+	PackedKeyHeader
+	Key []byte
+}
+
+var packedKeyHeaderLen = binary.Size(PackedKeyHeader{})
+
+// ParsePackedKey parses the packed key at the start of b.
+func ParsePackedKey(b []byte) (*PackedKey, error) {
+	var k PackedKey
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &k.PackedKeyHeader); err != nil {
+		return nil, fmt.Errorf("packed key header: %v", err)
+	}
+	if k.KeyOffset+k.KeySize > uint64(len(b)) || k.KeyOffset+k.KeySize < k.KeyOffset {
+		return nil, fmt.Errorf("packed key data [%#x, %#x) out of bounds", k.KeyOffset, k.KeyOffset+k.KeySize)
+	}
+	k.Key = b[k.KeyOffset : k.KeyOffset+k.KeySize]
+	return &k, nil
+}
+
+// NewPackedKey packs pub for use with algorithm a.
+func NewPackedKey(pub *rsa.PublicKey, a Algorithm, version uint64) (*PackedKey, error) {
+	if pub.N.BitLen() != a.KeyBits() {
+		return nil, fmt.Errorf("%d bit key can not be used with %v", pub.N.BitLen(), a)
+	}
+	if pub.E != 65537 {
+		return nil, fmt.Errorf("unsupported public exponent %d", pub.E)
+	}
+	words := pub.N.BitLen() / 32
+	// vboot uses Montgomery multiplication, so the key carries
+	// -1/n mod 2^32 and R^2 mod n, with R = 2^(32*words).
+	b32 := new(big.Int).Lsh(big.NewInt(1), 32)
+	n0inv := new(big.Int).ModInverse(new(big.Int).Mod(pub.N, b32), b32)
+	n0inv.Sub(b32, n0inv)
+	rr := new(big.Int).Lsh(big.NewInt(1), uint(2*32*words))
+	rr.Mod(rr, pub.N)
+
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(words))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(n0inv.Uint64()))
+	buf.Write(leWords(pub.N, words))
+	buf.Write(leWords(rr, words))
+	k := &PackedKey{
+		PackedKeyHeader: PackedKeyHeader{
+			KeyOffset:  uint64(packedKeyHeaderLen),
+			KeySize:    uint64(buf.Len()),
+			Algorithm:  a,
+			KeyVersion: version,
+		},
+		Key: buf.Bytes(),
+	}
+	return k, nil
+}
+
+// leWords returns n as words little-endian 32 bit words, least
+// significant word first.
+func leWords(n *big.Int, words int) []byte {
+	be := n.FillBytes(make([]byte, 4*words))
+	le := make([]byte, len(be))
+	for i := range be {
+		le[i] = be[len(be)-1-i]
+	}
+	return le
+}
+
+// PublicKey returns the RSA public key.
+func (k *PackedKey) PublicKey() (*rsa.PublicKey, error) {
+	if len(k.Key) < 8 {
+		return nil, fmt.Errorf("packed key too short: %d bytes", len(k.Key))
+	}
+	words := int(binary.LittleEndian.Uint32(k.Key))
+	if len(k.Key) < 8+2*4*words {
+		return nil, fmt.Errorf("packed key too short for %d words: %d bytes", words, len(k.Key))
+	}
+	if words*32 != k.Algorithm.KeyBits() {
+		return nil, fmt.Errorf("%d bit key does not match %v", words*32, k.Algorithm)
+	}
+	le := k.Key[8 : 8+4*words]
+	be := make([]byte, len(le))
+	for i := range le {
+		be[i] = le[len(le)-1-i]
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(be), E: 65537}, nil
+}
+
+// Bytes returns the header, with KeyOffset pointing right behind it, and
+// the key data.
+func (k *PackedKey) Bytes() []byte {
+	var buf bytes.Buffer
+	h := k.PackedKeyHeader
+	h.KeyOffset, h.KeySize = uint64(packedKeyHeaderLen), uint64(len(k.Key))
+	_ = binary.Write(&buf, binary.LittleEndian, h)
+	buf.Write(k.Key)
+	return buf.Bytes()
+}
+
+// Verify checks the PKCS#1 v1.5 signature sig over data.
+func (k *PackedKey) Verify(data, sig []byte) error {
+	pub, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	h := k.Algorithm.Hash()
+	d := h.New()
+	d.Write(data)
+	return rsa.VerifyPKCS1v15(pub, h, d.Sum(nil), sig)
+}
+
+// sign returns the PKCS#1 v1.5 signature over data.
+func sign(priv *rsa.PrivateKey, a Algorithm, data []byte) ([]byte, error) {
+	if priv.N.BitLen() != a.KeyBits() {
+		return nil, fmt.Errorf("%d bit key can not be used with %v", priv.N.BitLen(), a)
+	}
+	h := a.Hash()
+	d := h.New()
+	d.Write(data)
+	return rsa.SignPKCS1v15(rand.Reader, priv, h, d.Sum(nil))
+}
+
+// ParsePrivateKey parses an RSA private key in PEM (PKCS#1 or PKCS#8) or
+// vboot's .vbprivk format, which is the algorithm followed by the PKCS#1
+// DER encoding.
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	if p, _ := pem.Decode(b); p != nil {
+		if k, err := x509.ParsePKCS1PrivateKey(p.Bytes); err == nil {
+			return k, nil
+		}
+		k, err := x509.ParsePKCS8PrivateKey(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if rk, ok := k.(*rsa.PrivateKey); ok {
+			return rk, nil
+		}
+		return nil, errors.New("not an RSA private key")
+	}
+	if len(b) < 8 {
+		return nil, errors.New("private key too short")
+	}
+	return x509.ParsePKCS1PrivateKey(b[8:])
+}
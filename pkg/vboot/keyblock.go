@@ -0,0 +1,179 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+)
+
+// KeyblockMagic starts every keyblock.
+const KeyblockMagic = "CHROMEOS"
+
+// KeyblockHeader is the header of a keyblock (struct vb2_keyblock). The
+// keyblock holds the data key, which signs the firmware preamble, and is
+// signed by the root key from the GBB.
+type KeyblockHeader struct {
+	Magic              [8]byte
+	HeaderVersionMajor uint32
+	HeaderVersionMinor uint32
+	KeyblockSize       uint32
+	Reserved0          uint32
+	KeyblockSignature  Signature
+	KeyblockHash       Signature
+	KeyblockFlags      uint32
+	Reserved1          uint32
+	DataKey            PackedKeyHeader
+}
+
+// Offsets of the signatures and the data key within the keyblock header.
+const (
+	keyblockSignatureOffset = 24
+	keyblockHashOffset      = 48
+	keyblockDataKeyOffset   = 80
+)
+
+// Keyblock flags.
+const (
+	KeyblockFlagDeveloper0 = 1 << iota
+	KeyblockFlagDeveloper1
+	KeyblockFlagRecovery0
+	KeyblockFlagRecovery1
+)
+
+// Keyblock is a parsed keyblock. Raw holds its KeyblockSize bytes.
+type Keyblock struct {
+	KeyblockHeader
+	DataKey *PackedKey
+	Raw     []byte
+}
+
+var keyblockHeaderLen = binary.Size(KeyblockHeader{})
+
+// ParseKeyblock parses the keyblock at the start of b.
+func ParseKeyblock(b []byte) (*Keyblock, error) {
+	var k Keyblock
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &k.KeyblockHeader); err != nil {
+		return nil, fmt.Errorf("keyblock header: %v", err)
+	}
+	if string(k.Magic[:]) != KeyblockMagic {
+		return nil, fmt.Errorf("keyblock magic %q, want %q", k.Magic[:], KeyblockMagic)
+	}
+	if k.HeaderVersionMajor != 2 {
+		return nil, fmt.Errorf("unsupported keyblock version %d.%d", k.HeaderVersionMajor, k.HeaderVersionMinor)
+	}
+	if uint64(k.KeyblockSize) > uint64(len(b)) || int(k.KeyblockSize) < keyblockHeaderLen {
+		return nil, fmt.Errorf("keyblock size %#x out of bounds", k.KeyblockSize)
+	}
+	k.Raw = b[:k.KeyblockSize]
+	dk, err := ParsePackedKey(k.Raw[keyblockDataKeyOffset:])
+	if err != nil {
+		return nil, fmt.Errorf("keyblock data key: %v", err)
+	}
+	k.DataKey = dk
+	return &k, nil
+}
+
+// NewKeyblock creates a keyblock holding dataKey, signed by signer with
+// algorithm a.
+func NewKeyblock(dataKey *PackedKey, flags uint32, signer *rsa.PrivateKey, a Algorithm) (*Keyblock, error) {
+	signedSize := keyblockHeaderLen + len(dataKey.Key)
+	sigSize := a.KeyBits() / 8
+	h := KeyblockHeader{
+		HeaderVersionMajor: 2,
+		HeaderVersionMinor: 1,
+		KeyblockSize:       uint32(signedSize + sha512.Size + sigSize),
+		KeyblockSignature: Signature{
+			SigOffset: uint64(signedSize + sha512.Size - keyblockSignatureOffset),
+			SigSize:   uint64(sigSize),
+			DataSize:  uint64(signedSize),
+		},
+		KeyblockHash: Signature{
+			SigOffset: uint64(signedSize - keyblockHashOffset),
+			SigSize:   sha512.Size,
+			DataSize:  uint64(signedSize),
+		},
+		KeyblockFlags: flags,
+		DataKey:       dataKey.PackedKeyHeader,
+	}
+	copy(h.Magic[:], KeyblockMagic)
+	h.DataKey.KeyOffset = uint64(keyblockHeaderLen - keyblockDataKeyOffset)
+	h.DataKey.KeySize = uint64(len(dataKey.Key))
+
+	raw := make([]byte, h.KeyblockSize)
+	put(raw, 0, h)
+	copy(raw[keyblockHeaderLen:], dataKey.Key)
+	k, err := ParseKeyblock(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Sign(signer, a); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Sign recomputes the hash and signature of the keyblock in place. The
+// signature size must not change.
+func (k *Keyblock) Sign(signer *rsa.PrivateKey, a Algorithm) error {
+	signed, err := k.KeyblockSignature.signed(k.Raw)
+	if err != nil {
+		return err
+	}
+	sig, err := k.KeyblockSignature.data(k.Raw, keyblockSignatureOffset)
+	if err != nil {
+		return err
+	}
+	if len(sig) != a.KeyBits()/8 {
+		return fmt.Errorf("keyblock has room for a %d byte signature, %v needs %d", len(sig), a, a.KeyBits()/8)
+	}
+	hash, err := k.KeyblockHash.data(k.Raw, keyblockHashOffset)
+	if err != nil {
+		return err
+	}
+	if len(hash) != sha512.Size {
+		return fmt.Errorf("keyblock hash is %d bytes, want %d", len(hash), sha512.Size)
+	}
+	d := sha512.Sum512(signed)
+	copy(hash, d[:])
+	s, err := sign(signer, a, signed)
+	if err != nil {
+		return err
+	}
+	copy(sig, s)
+	return nil
+}
+
+// Verify checks the keyblock hash and its signature with key.
+func (k *Keyblock) Verify(key *PackedKey) error {
+	if k.KeyblockSignature.DataSize < keyblockDataKeyOffset+k.DataKey.KeyOffset+k.DataKey.KeySize {
+		return fmt.Errorf("keyblock signature does not cover the data key")
+	}
+	signed, err := k.KeyblockHash.signed(k.Raw)
+	if err != nil {
+		return err
+	}
+	hash, err := k.KeyblockHash.data(k.Raw, keyblockHashOffset)
+	if err != nil {
+		return err
+	}
+	if d := sha512.Sum512(signed); !bytes.Equal(d[:], hash) {
+		return fmt.Errorf("keyblock hash mismatch")
+	}
+	if signed, err = k.KeyblockSignature.signed(k.Raw); err != nil {
+		return err
+	}
+	sig, err := k.KeyblockSignature.data(k.Raw, keyblockSignatureOffset)
+	if err != nil {
+		return err
+	}
+	if err := key.Verify(signed, sig); err != nil {
+		return fmt.Errorf("keyblock signature: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+)
+
+// FirmwarePreambleHeader is the header of a firmware preamble (struct
+// vb2_fw_preamble). The preamble follows the keyblock in VBLOCK_A/B, is
+// signed by the data key and holds the signature of the firmware body.
+type FirmwarePreambleHeader struct {
+	PreambleSize       uint32
+	Reserved0          uint32
+	PreambleSignature  Signature
+	HeaderVersionMajor uint32
+	HeaderVersionMinor uint32
+	FirmwareVersion    uint32
+	Reserved1          uint32
+	KernelSubkey       PackedKeyHeader
+	BodySignature      Signature
+	Flags              uint32
+}
+
+// Offsets of the signatures and the kernel subkey within the preamble
+// header.
+const (
+	preambleSignatureOffset     = 8
+	preambleKernelSubkeyOffset  = 48
+	preambleBodySignatureOffset = 80
+)
+
+// FirmwarePreamble is a parsed firmware preamble. Raw holds its
+// PreambleSize bytes.
+type FirmwarePreamble struct {
+	FirmwarePreambleHeader
+	KernelSubkey *PackedKey
+	Raw          []byte
+}
+
+var preambleHeaderLen = binary.Size(FirmwarePreambleHeader{})
+
+// ParseFirmwarePreamble parses the firmware preamble at the start of b.
+func ParseFirmwarePreamble(b []byte) (*FirmwarePreamble, error) {
+	var p FirmwarePreamble
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &p.FirmwarePreambleHeader); err != nil {
+		return nil, fmt.Errorf("firmware preamble header: %v", err)
+	}
+	if p.HeaderVersionMajor != 2 {
+		return nil, fmt.Errorf("unsupported firmware preamble version %d.%d", p.HeaderVersionMajor, p.HeaderVersionMinor)
+	}
+	if uint64(p.PreambleSize) > uint64(len(b)) || int(p.PreambleSize) < preambleHeaderLen {
+		return nil, fmt.Errorf("firmware preamble size %#x out of bounds", p.PreambleSize)
+	}
+	p.Raw = b[:p.PreambleSize]
+	k, err := ParsePackedKey(p.Raw[preambleKernelSubkeyOffset:])
+	if err != nil {
+		return nil, fmt.Errorf("firmware preamble kernel subkey: %v", err)
+	}
+	p.KernelSubkey = k
+	return &p, nil
+}
+
+// NewFirmwarePreamble creates a preamble for body, which is signed along
+// with the preamble by dataKey with algorithm a.
+func NewFirmwarePreamble(version uint32, kernelSubkey *PackedKey, body []byte, flags uint32, dataKey *rsa.PrivateKey, a Algorithm) (*FirmwarePreamble, error) {
+	bodySig, err := sign(dataKey, a, body)
+	if err != nil {
+		return nil, err
+	}
+	signedSize := preambleHeaderLen + len(kernelSubkey.Key) + len(bodySig)
+	sigSize := a.KeyBits() / 8
+	h := FirmwarePreambleHeader{
+		PreambleSize: uint32(signedSize + sigSize),
+		PreambleSignature: Signature{
+			SigOffset: uint64(signedSize - preambleSignatureOffset),
+			SigSize:   uint64(sigSize),
+			DataSize:  uint64(signedSize),
+		},
+		HeaderVersionMajor: 2,
+		HeaderVersionMinor: 1,
+		FirmwareVersion:    version,
+		KernelSubkey:       kernelSubkey.PackedKeyHeader,
+		BodySignature: Signature{
+			SigOffset: uint64(preambleHeaderLen + len(kernelSubkey.Key) - preambleBodySignatureOffset),
+			SigSize:   uint64(len(bodySig)),
+			DataSize:  uint64(len(body)),
+		},
+		Flags: flags,
+	}
+	h.KernelSubkey.KeyOffset = uint64(preambleHeaderLen - preambleKernelSubkeyOffset)
+	h.KernelSubkey.KeySize = uint64(len(kernelSubkey.Key))
+
+	raw := make([]byte, h.PreambleSize)
+	put(raw, 0, h)
+	copy(raw[preambleHeaderLen:], kernelSubkey.Key)
+	copy(raw[preambleHeaderLen+len(kernelSubkey.Key):], bodySig)
+	p, err := ParseFirmwarePreamble(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.sign(dataKey, a); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Sign re-signs body and the preamble in place with dataKey. The size of
+// the signatures must not change.
+func (p *FirmwarePreamble) Sign(body []byte, dataKey *rsa.PrivateKey, a Algorithm) error {
+	p.BodySignature.DataSize = uint64(len(body))
+	put(p.Raw, preambleBodySignatureOffset, p.BodySignature)
+	sig, err := p.BodySignature.data(p.Raw, preambleBodySignatureOffset)
+	if err != nil {
+		return err
+	}
+	if len(sig) != a.KeyBits()/8 {
+		return fmt.Errorf("preamble has room for a %d byte body signature, %v needs %d", len(sig), a, a.KeyBits()/8)
+	}
+	s, err := sign(dataKey, a, body)
+	if err != nil {
+		return err
+	}
+	copy(sig, s)
+	return p.sign(dataKey, a)
+}
+
+// sign signs the preamble itself.
+func (p *FirmwarePreamble) sign(dataKey *rsa.PrivateKey, a Algorithm) error {
+	signed, err := p.PreambleSignature.signed(p.Raw)
+	if err != nil {
+		return err
+	}
+	sig, err := p.PreambleSignature.data(p.Raw, preambleSignatureOffset)
+	if err != nil {
+		return err
+	}
+	if len(sig) != a.KeyBits()/8 {
+		return fmt.Errorf("preamble has room for a %d byte signature, %v needs %d", len(sig), a, a.KeyBits()/8)
+	}
+	s, err := sign(dataKey, a, signed)
+	if err != nil {
+		return err
+	}
+	copy(sig, s)
+	return nil
+}
+
+// Verify checks the preamble signature and the signature of body with the
+// data key.
+func (p *FirmwarePreamble) Verify(dataKey *PackedKey, body []byte) error {
+	if p.PreambleSignature.DataSize < uint64(preambleHeaderLen) {
+		return fmt.Errorf("preamble signature does not cover the header")
+	}
+	signed, err := p.PreambleSignature.signed(p.Raw)
+	if err != nil {
+		return err
+	}
+	sig, err := p.PreambleSignature.data(p.Raw, preambleSignatureOffset)
+	if err != nil {
+		return err
+	}
+	if err := dataKey.Verify(signed, sig); err != nil {
+		return fmt.Errorf("preamble signature: %v", err)
+	}
+	if signed, err = p.BodySignature.signed(body); err != nil {
+		return fmt.Errorf("firmware body: %v", err)
+	}
+	if sig, err = p.BodySignature.data(p.Raw, preambleBodySignatureOffset); err != nil {
+		return err
+	}
+	if err := dataKey.Verify(signed, sig); err != nil {
+		return fmt.Errorf("firmware body signature: %v", err)
+	}
+	return nil
+}
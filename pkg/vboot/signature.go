@@ -0,0 +1,48 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Signature describes a signature or digest (struct vb2_signature). The
+// signature data is at SigOffset, relative to the start of the
+// Signature, and covers the first DataSize bytes of the signed data.
+type Signature struct {
+	SigOffset uint64
+	SigSize   uint64
+	DataSize  uint64
+}
+
+var signatureLen = binary.Size(Signature{})
+
+// data returns the signature data, given the container b holding the
+// Signature at offset off.
+func (s *Signature) data(b []byte, off int) ([]byte, error) {
+	start := uint64(off) + s.SigOffset
+	end := start + s.SigSize
+	if end > uint64(len(b)) || end < start {
+		return nil, fmt.Errorf("signature data [%#x, %#x) out of bounds", start, end)
+	}
+	return b[start:end], nil
+}
+
+// signed returns the signed data, i.e. the first DataSize bytes of b.
+func (s *Signature) signed(b []byte) ([]byte, error) {
+	if s.DataSize > uint64(len(b)) {
+		return nil, fmt.Errorf("signed data size %#x exceeds %#x bytes", s.DataSize, len(b))
+	}
+	return b[:s.DataSize], nil
+}
+
+// put writes v little-endian into b at off.
+func put(b []byte, off int, v interface{}) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, v)
+	copy(b[off:], buf.Bytes())
+}
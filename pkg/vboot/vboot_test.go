@@ -0,0 +1,193 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+)
+
+// testImage builds an image with a GBB and slot A, signed with fresh keys.
+func testImage(t *testing.T) (*Image, *rsa.PrivateKey) {
+	t.Helper()
+	root, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootKey, err := NewPackedKey(&root.PublicKey, RSA2048SHA256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := NewPackedKey(&data.PublicKey, RSA2048SHA256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rom := bytes.Repeat([]byte{0xff}, 0x10000)
+	areas := []struct {
+		name      string
+		off, size uint32
+	}{
+		{"FMAP", 0, 0x400},
+		{"GBB", 0x400, 0x1000},
+		{"VBLOCK_A", 0x1400, 0x2000},
+		{"FW_MAIN_A", 0x3400, 0x4000},
+	}
+	f := fmap.FMap{Header: fmap.Header{VerMajor: 1, VerMinor: 1, Size: uint32(len(rom)), NAreas: uint16(len(areas))}}
+	copy(f.Signature[:], fmap.Signature)
+	for _, a := range areas {
+		fa := fmap.Area{Offset: a.off, Size: a.size}
+		copy(fa.Name.Value[:], a.name)
+		f.Areas = append(f.Areas, fa)
+	}
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, f.Header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&b, binary.LittleEndian, f.Areas); err != nil {
+		t.Fatal(err)
+	}
+	copy(rom, b.Bytes())
+
+	gbb := rom[0x400:0x1400]
+	rk := rootKey.Bytes()
+	h := GBBHeader{
+		MajorVersion:  1,
+		MinorVersion:  2,
+		HeaderSize:    128,
+		HWIDOffset:    0x80,
+		HWIDSize:      0x40,
+		RootKeyOffset: 0x100,
+		RootKeySize:   uint32(len(rk)),
+	}
+	copy(h.Signature[:], GBBSignature)
+	put(gbb, 0, h)
+	copy(gbb[0x80:0xc0], append([]byte("FIANO TEST"), make([]byte, 0x36)...))
+	copy(gbb[0x100:], rk)
+
+	body := rom[0x3400:0x7400]
+	copy(body, "LARCHIVE")
+	kb, err := NewKeyblock(dataKey, KeyblockFlagDeveloper0|KeyblockFlagRecovery0, root, RSA2048SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewFirmwarePreamble(1, dataKey, body, 0, data, RSA2048SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(rom[0x1400:], kb.Raw)
+	copy(rom[0x1400+len(kb.Raw):], p.Raw)
+
+	i, err := NewImage(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i, data
+}
+
+func TestVerify(t *testing.T) {
+	i, _ := testImage(t)
+	if i.GBB.HWID != "FIANO TEST" {
+		t.Errorf("HWID: got %q, want %q", i.GBB.HWID, "FIANO TEST")
+	}
+	if i.GBB.RecoveryKey != nil {
+		t.Errorf("got recovery key, want none")
+	}
+	if err := i.Verify("A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Verify("B"); err == nil {
+		t.Errorf("Verify of missing slot: got nil, want error")
+	}
+	k, p, err := i.VBlock("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.DataKey.Algorithm != RSA2048SHA256 || p.FirmwareVersion != 1 {
+		t.Errorf("got %v, firmware version %d; want %v, version 1", k.DataKey.Algorithm, p.FirmwareVersion, RSA2048SHA256)
+	}
+
+	// Modify the body.
+	i.Data[0x3500] ^= 0xff
+	if err := i.Verify("A"); err == nil {
+		t.Errorf("Verify of modified body: got nil, want error")
+	}
+	// Modify the keyblock.
+	i, _ = testImage(t)
+	i.Data[0x1400+keyblockHeaderLen] ^= 0xff
+	if err := i.Verify("A"); err == nil {
+		t.Errorf("Verify of modified keyblock: got nil, want error")
+	}
+}
+
+func TestResign(t *testing.T) {
+	i, data := testImage(t)
+	i.Data[0x3500] ^= 0xff
+	if err := i.Verify("A"); err == nil {
+		t.Fatalf("Verify of modified body: got nil, want error")
+	}
+	// Round trip the key through PEM, as it would be passed by users.
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(data)})
+	key, err := ParsePrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Resign("A", key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Verify("A"); err != nil {
+		t.Fatalf("Verify after Resign: %v", err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Resign("A", other, nil); err == nil {
+		t.Errorf("Resign with a foreign data key: got nil, want error")
+	}
+}
+
+func TestPackedKey(t *testing.T) {
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPackedKey(&k.PublicKey, RSA2048SHA256, 1); err == nil {
+		t.Errorf("NewPackedKey with wrong key size: got nil, want error")
+	}
+	p, err := NewPackedKey(&k.PublicKey, RSA1024SHA1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = ParsePackedKey(p.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := p.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(&k.PublicKey) {
+		t.Errorf("public key changed by packing")
+	}
+	// n0inv * n == -1 mod 2^32
+	n0inv := binary.LittleEndian.Uint32(p.Key[4:])
+	n0 := binary.LittleEndian.Uint32(p.Key[8:])
+	if n0inv*n0 != 0xffffffff {
+		t.Errorf("n0inv %#x is not -1/n mod 2^32", n0inv)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// amdBIOSEntry is an entry of a BIOS directory.
+type amdBIOSEntry struct {
+	offset uint64
+	size   uint32
+}
+
+// amdBIOSEntries returns the entries of type t of the BIOS directories of
+// the AMD image buf.
+func amdBIOSEntries(buf []byte, t manifest.BIOSDirectoryTableEntryType) []amdBIOSEntry {
+	img := manifest.FirmwareImage(buf)
+	var tables []*manifest.BIOSDirectoryTable
+	if amd, err := manifest.NewAMDFirmware(img); err == nil {
+		psp := amd.PSPFirmware()
+		tables = append(tables, psp.BIOSDirectoryLevel1, psp.BIOSDirectoryLevel2)
+	} else if table, _, err := manifest.FindBIOSDirectoryTable(buf); err == nil {
+		// Images without an embedded firmware structure, like BIOS
+		// region dumps.
+		tables = append(tables, table)
+	}
+	var entries []amdBIOSEntry
+	for _, table := range tables {
+		if table == nil {
+			continue
+		}
+		for _, e := range table.Entries {
+			if e.Type != t {
+				continue
+			}
+			off := e.SourceAddress
+			if off >= uint64(len(buf)) {
+				off = img.PhysAddrToOffset(off)
+			}
+			if off > uint64(len(buf)) || uint64(e.Size) > uint64(len(buf))-off {
+				continue
+			}
+			entries = append(entries, amdBIOSEntry{offset: off, size: e.Size})
+		}
+	}
+	return entries
+}
+
+// writeAMDBIOSEntry writes b over the entry e of the image f, in the BIOS
+// padding holding it, and erases the rest of the entry.
+func writeAMDBIOSEntry(f uefi.Firmware, e amdBIOSEntry, b []byte) error {
+	if uint64(len(b)) > uint64(e.size) {
+		return fmt.Errorf("%#x bytes do not fit in the BIOS directory entry of %#x bytes at %#x", len(b), e.size, e.offset)
+	}
+	find := &Find{
+		Predicate: func(f uefi.Firmware) bool {
+			bp, ok := f.(*uefi.BIOSPadding)
+			return ok && bp.Offset <= e.offset && e.offset+uint64(e.size) <= bp.Offset+uint64(len(bp.Buf()))
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+	if len(find.Matches) == 0 {
+		return fmt.Errorf("BIOS directory entry at %#x is outside of the BIOS padding", e.offset)
+	}
+	bp := find.Matches[0].(*uefi.BIOSPadding)
+	entry := bp.Buf()[e.offset-bp.Offset : e.offset-bp.Offset+uint64(e.size)]
+	copy(entry, b)
+	for i := len(b); i < len(entry); i++ {
+		entry[i] = 0xff
+	}
+	return nil
+}
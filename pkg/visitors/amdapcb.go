@@ -0,0 +1,192 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/amd/apcb"
+	"github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoAPCB = errors.New("no APCB entry found in the BIOS directories")
+
+// AMDAPCBToken is a token of an APCB.
+type AMDAPCBToken struct {
+	apcb.Token
+	Name string `json:",omitempty"`
+}
+
+// AMDAPCBEntry is an APCB of the BIOS directories.
+type AMDAPCBEntry struct {
+	// Offset and Size are those of the entry.
+	Offset uint64
+	Size   uint32
+	// Backup is true for the backup copy of the APCB.
+	Backup        bool
+	ChecksumValid bool
+	Groups        []apcb.Group
+	Tokens        []AMDAPCBToken
+	// Error tells why the entry holds no valid APCB.
+	Error string `json:",omitempty"`
+}
+
+// AMDAPCB lists the groups and tokens of the APCBs (AMD PSP Customization
+// Blocks) of the BIOS directories of the image, and sets the value of the
+// token TokenID to Value if Value is set.
+type AMDAPCB struct {
+	Reporter
+
+	TokenID apcb.TokenID
+	// Value is parsed according to the type of the token: true or false,
+	// or an unsigned integer of 8, 16 or 32 bits.
+	Value string
+	// Optionally write the APCBs, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	APCBs []AMDAPCBEntry
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *AMDAPCB) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v.APCBs)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	for _, a := range v.APCBs {
+		name := "APCB"
+		if a.Backup {
+			name = "Backup APCB"
+		}
+		if a.Error != "" {
+			fmt.Fprintf(v.W, "%s at %#x: %s\n", name, a.Offset, a.Error)
+			continue
+		}
+		fmt.Fprintf(v.W, "%s at %#x, %#x bytes, checksum valid %v\n", name, a.Offset, a.Size, a.ChecksumValid)
+		w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Group\tID\tTypes\tSize")
+		for _, g := range a.Groups {
+			fmt.Fprintf(w, "%s\t%#04x\t%d\t%#x\n", g.Signature, g.ID, len(g.Types), g.Size)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Token\tName\tPriority\tBoard\tValue")
+		for _, t := range a.Tokens {
+			fmt.Fprintf(w, "%08X\t%s\t%v\t%04X\t%v\n", uint32(t.ID), t.Name, t.PriorityMask, t.BoardMask, t.Value)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseAPCBValue parses s as a value of the type of old.
+func parseAPCBValue(s string, old interface{}) (interface{}, error) {
+	switch old.(type) {
+	case bool:
+		return strconv.ParseBool(s)
+	case uint8:
+		n, err := strconv.ParseUint(s, 0, 8)
+		return uint8(n), err
+	case uint16:
+		n, err := strconv.ParseUint(s, 0, 16)
+		return uint16(n), err
+	case uint32:
+		n, err := strconv.ParseUint(s, 0, 32)
+		return uint32(n), err
+	}
+	return nil, fmt.Errorf("unknown token type %T", old)
+}
+
+// Visit lists the APCBs of the image f and sets the token.
+func (v *AMDAPCB) Visit(f uefi.Firmware) error {
+	buf := f.Buf()
+	v.APCBs = []AMDAPCBEntry{}
+	set := false
+	for _, typ := range []manifest.BIOSDirectoryTableEntryType{manifest.APCBDataEntry, manifest.APCBDataBackupEntry} {
+		for _, e := range amdBIOSEntries(buf, typ) {
+			a := AMDAPCBEntry{Offset: e.offset, Size: e.size, Backup: typ == manifest.APCBDataBackupEntry}
+			b := append([]byte{}, buf[e.offset:e.offset+uint64(e.size)]...)
+			tokens, err := apcb.ParseAPCBBinaryTokens(b)
+			if err != nil {
+				a.Error = err.Error()
+				v.APCBs = append(v.APCBs, a)
+				continue
+			}
+			if v.Value != "" {
+				changed := false
+				for _, t := range tokens {
+					if t.ID != v.TokenID {
+						continue
+					}
+					value, err := parseAPCBValue(v.Value, t.Value)
+					if err != nil {
+						return fmt.Errorf("invalid value for token %08X: %v", uint32(t.ID), err)
+					}
+					if err := apcb.UpsertToken(t.ID, t.PriorityMask, t.BoardMask, value, b); err != nil {
+						return err
+					}
+					changed = true
+				}
+				if changed {
+					if err := writeAMDBIOSEntry(f, e, b); err != nil {
+						return err
+					}
+					if tokens, err = apcb.ParseAPCBBinaryTokens(b); err != nil {
+						return err
+					}
+					set = true
+				}
+			}
+			if a.Groups, err = apcb.ParseAPCBBinaryGroups(b); err != nil {
+				a.Error = err.Error()
+				v.APCBs = append(v.APCBs, a)
+				continue
+			}
+			a.ChecksumValid, _ = apcb.ChecksumValid(b)
+			for _, t := range tokens {
+				a.Tokens = append(a.Tokens, AMDAPCBToken{Token: t, Name: apcb.GetTokenIDString(t.ID)})
+			}
+			v.APCBs = append(v.APCBs, a)
+		}
+	}
+	if len(v.APCBs) == 0 {
+		return errNoAPCB
+	}
+	if v.Value != "" && !set {
+		return fmt.Errorf("no token %08X in the APCBs", uint32(v.TokenID))
+	}
+	return nil
+}
+
+func init() {
+	RegisterCLI("apcb", "print the groups and tokens of the APCBs of the BIOS directories of an AMD image", 0, func(args []string) (uefi.Visitor, error) {
+		return &AMDAPCB{W: os.Stdout}, nil
+	})
+	RegisterCLI("apcb_set", "apcb_set TOKEN VALUE\n set the APCB token named TOKEN or of hexadecimal ID TOKEN to VALUE, at every priority and board mask it has, and fix the checksums", 2, func(args []string) (uefi.Visitor, error) {
+		id, err := apcb.ParseTokenID(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &AMDAPCB{TokenID: id, Value: args[1]}, nil
+	})
+}
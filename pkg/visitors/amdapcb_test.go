@@ -0,0 +1,105 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/amd/apcb"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/ulikunitz/xz"
+)
+
+// apcbImage returns an image with a BIOS directory pointing to the APCB of
+// pkg/amd/apcb at 0x2000.
+func apcbImage(t *testing.T) []byte {
+	compressed, err := os.ReadFile("../amd/apcb/testdata/apcb_binary.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Repeat([]byte{0xff}, 0x10000)
+	dir := buf[0x1000:]
+	binary.LittleEndian.PutUint32(dir, 0x44484224) // $BHD
+	binary.LittleEndian.PutUint32(dir[4:], 0)
+	binary.LittleEndian.PutUint32(dir[8:], 1)
+	binary.LittleEndian.PutUint32(dir[12:], 0)
+	copy(dir[16:], []byte{0x60, 0, 0, 0})
+	binary.LittleEndian.PutUint32(dir[20:], uint32(len(data)))
+	binary.LittleEndian.PutUint64(dir[24:], 0x2000)
+	binary.LittleEndian.PutUint64(dir[32:], ^uint64(0))
+	copy(buf[0x2000:], data)
+	return buf
+}
+
+func TestAMDAPCB(t *testing.T) {
+	f, err := uefi.Parse(apcbImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &AMDAPCB{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.APCBs) != 1 {
+		t.Fatalf("got %d APCBs, want 1", len(v.APCBs))
+	}
+	a := v.APCBs[0]
+	if !a.ChecksumValid || len(a.Groups) != 2 || len(a.Tokens) != 40 {
+		t.Errorf("got APCB with checksum valid %v, %d groups and %d tokens, want true, 2 and 40", a.ChecksumValid, len(a.Groups), len(a.Tokens))
+	}
+	for _, s := range []string{"APCB at 0x2000, 0x1000 bytes, checksum valid true", "TOKN   0x3000", "3E7D5274"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestAMDAPCBSet(t *testing.T) {
+	f, err := uefi.Parse(apcbImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &AMDAPCB{TokenID: 0x3E7D5274, Value: "4000"}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Read the padding back, f.Buf is a copy of the image.
+	b := f.(*uefi.BIOSRegion).Elements[0].Value.Buf()[0x2000:0x3000]
+	tokens, err := apcb.ParseAPCBBinaryTokens(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range tokens {
+		if tok.ID == 0x3E7D5274 && tok.Value != uint32(4000) {
+			t.Errorf("got token value %v, want 4000", tok.Value)
+		}
+	}
+	if valid, err := apcb.ChecksumValid(b); err != nil || !valid {
+		t.Errorf("got checksum valid %v, %v, want true", valid, err)
+	}
+
+	for _, v := range []*AMDAPCB{
+		{TokenID: 0x3E7D5274, Value: "0x100000000"},
+		{TokenID: 0xFFFFAAAA, Value: "1"},
+	} {
+		if err := v.Run(f); err == nil {
+			t.Errorf("set token %08X to %s, want an error", uint32(v.TokenID), v.Value)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/amd/microcode"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoAMDUcode = errors.New("no microcode entry found in the BIOS directories")
+
+// AMDUcodePatch is an AMD microcode patch of a microcode entry of the BIOS
+// directories.
+type AMDUcodePatch struct {
+	// Offset and Size are those of the entry.
+	Offset uint64
+	Size   uint32
+	// Container is true if the entry is a container rather than a patch.
+	Container      bool
+	ProcessorRevID uint16
+	PatchID        uint32
+	Date           string
+	// CPUIDs are those the equivalence table of the container maps to
+	// the processor revision.
+	CPUIDs []uint32 `json:",omitempty"`
+	// Error tells why the entry holds no valid patch.
+	Error string `json:",omitempty"`
+}
+
+// AMDUcode lists the AMD microcode patches of the BIOS directories of the
+// image, and replaces the patch for the processor revision of Replace by
+// it if set.
+type AMDUcode struct {
+	Reporter
+
+	// Replace is a patch to write over the patches of the same processor
+	// revision, either alone in their entry or in a container.
+	Replace []byte `json:"-"`
+	// Optionally write the patches, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Patches []AMDUcodePatch
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *AMDUcode) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v.Patches)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Offset\tSize\tContainer\tRevision\tPatch\tDate\tCPUIDs")
+	for _, p := range v.Patches {
+		if p.Error != "" {
+			fmt.Fprintf(w, "%#x\t%#x\t%v\t%s\n", p.Offset, p.Size, p.Container, p.Error)
+			continue
+		}
+		cpuids := ""
+		for i, c := range p.CPUIDs {
+			if i > 0 {
+				cpuids += ","
+			}
+			cpuids += fmt.Sprintf("%08X", c)
+		}
+		fmt.Fprintf(w, "%#x\t%#x\t%v\t%04X\t%08X\t%s\t%s\n",
+			p.Offset, p.Size, p.Container, p.ProcessorRevID, p.PatchID, p.Date, cpuids)
+	}
+	return w.Flush()
+}
+
+// Visit lists the patches of the image f and replaces them.
+func (v *AMDUcode) Visit(f uefi.Firmware) error {
+	var replace *microcode.Patch
+	if v.Replace != nil {
+		var err error
+		if replace, err = microcode.ParsePatch(v.Replace); err != nil {
+			return err
+		}
+	}
+	buf := f.Buf()
+	entries := amdBIOSEntries(buf, manifest.MicrocodePatchEntry)
+	if len(entries) == 0 {
+		return errNoAMDUcode
+	}
+	v.Patches = []AMDUcodePatch{}
+	replaced := false
+	for _, e := range entries {
+		b := buf[e.offset : e.offset+uint64(e.size)]
+		if len(b) < 4 || binary.LittleEndian.Uint32(b) != microcode.ContainerMagic {
+			p, err := microcode.ParsePatch(b)
+			if err != nil {
+				v.Patches = append(v.Patches, AMDUcodePatch{Offset: e.offset, Size: e.size, Error: err.Error()})
+				continue
+			}
+			v.Patches = append(v.Patches, AMDUcodePatch{Offset: e.offset, Size: e.size,
+				ProcessorRevID: p.ProcessorRevID, PatchID: p.PatchID, Date: p.Date()})
+			if replace == nil || replace.ProcessorRevID != p.ProcessorRevID {
+				continue
+			}
+			if err := writeAMDBIOSEntry(f, e, replace.Buf); err != nil {
+				return err
+			}
+			replaced = true
+			continue
+		}
+		c, err := microcode.ParseContainer(b)
+		if err != nil {
+			v.Patches = append(v.Patches, AMDUcodePatch{Offset: e.offset, Size: e.size, Container: true, Error: err.Error()})
+			continue
+		}
+		for _, p := range c.Patches {
+			ap := AMDUcodePatch{Offset: e.offset, Size: e.size, Container: true,
+				ProcessorRevID: p.ProcessorRevID, PatchID: p.PatchID, Date: p.Date()}
+			for _, eq := range c.EquivalenceTable {
+				if eq.EquivCPU == p.ProcessorRevID {
+					ap.CPUIDs = append(ap.CPUIDs, eq.InstalledCPU)
+				}
+			}
+			v.Patches = append(v.Patches, ap)
+		}
+		if replace == nil || c.Patch(replace.ProcessorRevID) == nil {
+			continue
+		}
+		if _, err := c.Replace(replace); err != nil {
+			return err
+		}
+		if err := writeAMDBIOSEntry(f, e, c.Bytes()); err != nil {
+			return err
+		}
+		replaced = true
+	}
+	if replace != nil && !replaced {
+		return fmt.Errorf("no patch for processor revision %#04x in the image", replace.ProcessorRevID)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCLI("amd_ucode", "print the processor revision, ID and date of the AMD microcode patches of the BIOS directories", 0, func(args []string) (uefi.Visitor, error) {
+		return &AMDUcode{W: os.Stdout}, nil
+	})
+	RegisterCLI("amd_ucode_replace", "amd_ucode_replace FILE\n replace the AMD microcode patches of the processor revision of the patch FILE, alone or in containers", 1, func(args []string) (uefi.Visitor, error) {
+		patch, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &AMDUcode{Replace: patch}, nil
+	})
+}
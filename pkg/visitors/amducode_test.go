@@ -0,0 +1,108 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/amd/microcode"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// amdPatch returns a patch of 0x80 bytes for the processor revision.
+func amdPatch(rev uint16, id uint32) []byte {
+	b := make([]byte, 0x80)
+	binary.LittleEndian.PutUint32(b, 0x20211021)
+	binary.LittleEndian.PutUint32(b[4:], id)
+	binary.LittleEndian.PutUint16(b[24:], rev)
+	return b
+}
+
+// amdUcodeImage returns an image with a BIOS directory pointing to a
+// patch for A011 at 0x2000 and to a container at 0x3000.
+func amdUcodeImage(t *testing.T) []byte {
+	buf := bytes.Repeat([]byte{0xff}, 0x10000)
+	dir := buf[0x1000:]
+	binary.LittleEndian.PutUint32(dir, 0x44484224) // $BHD
+	binary.LittleEndian.PutUint32(dir[4:], 0)
+	binary.LittleEndian.PutUint32(dir[8:], 2)
+	binary.LittleEndian.PutUint32(dir[12:], 0)
+	for i, e := range []struct{ size, src uint64 }{{0x100, 0x2000}, {0x200, 0x3000}} {
+		entry := dir[16+24*i:]
+		copy(entry, []byte{0x66, 0, 0, 0})
+		binary.LittleEndian.PutUint32(entry[4:], uint32(e.size))
+		binary.LittleEndian.PutUint64(entry[8:], e.src)
+		binary.LittleEndian.PutUint64(entry[16:], ^uint64(0))
+	}
+	copy(buf[0x2000:], amdPatch(0xa011, 0x0a0011d1))
+	c := &microcode.Container{EquivalenceTable: []microcode.EquivalenceEntry{
+		{InstalledCPU: 0x00a00f11, EquivCPU: 0xa011},
+		{InstalledCPU: 0x00a00f12, EquivCPU: 0xa012},
+	}}
+	for _, b := range [][]byte{amdPatch(0xa011, 0x0a0011d1), amdPatch(0xa012, 0x0a001238)} {
+		p, err := microcode.ParsePatch(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Patches = append(c.Patches, p)
+	}
+	copy(buf[0x3000:], c.Bytes())
+	return buf
+}
+
+func TestAMDUcode(t *testing.T) {
+	f, err := uefi.Parse(amdUcodeImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &AMDUcode{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	want := []AMDUcodePatch{
+		{Offset: 0x2000, Size: 0x100, ProcessorRevID: 0xa011, PatchID: 0x0a0011d1, Date: "2021-10-21"},
+		{Offset: 0x3000, Size: 0x200, Container: true, ProcessorRevID: 0xa011, PatchID: 0x0a0011d1, Date: "2021-10-21", CPUIDs: []uint32{0x00a00f11}},
+		{Offset: 0x3000, Size: 0x200, Container: true, ProcessorRevID: 0xa012, PatchID: 0x0a001238, Date: "2021-10-21", CPUIDs: []uint32{0x00a00f12}},
+	}
+	if !reflect.DeepEqual(v.Patches, want) {
+		t.Errorf("got patches %+v, want %+v", v.Patches, want)
+	}
+	if !strings.Contains(out.String(), "0x3000  0x200  true       A012      0A001238  2021-10-21  00A00F12") {
+		t.Errorf("the table lacks the container patch for A012:\n%s", out.String())
+	}
+}
+
+func TestAMDUcodeReplace(t *testing.T) {
+	f, err := uefi.Parse(amdUcodeImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &AMDUcode{Replace: amdPatch(0xa011, 0x0a0011d3)}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Read the padding back, f.Buf is a copy of the image.
+	buf := f.(*uefi.BIOSRegion).Elements[0].Value.Buf()
+	if id := binary.LittleEndian.Uint32(buf[0x2004:]); id != 0x0a0011d3 {
+		t.Errorf("got patch %#x at 0x2000, want 0xa0011d3", id)
+	}
+	c, err := microcode.ParseContainer(buf[0x3000:0x3200])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Patch(0xa011).PatchID != 0x0a0011d3 || c.Patch(0xa012).PatchID != 0x0a001238 {
+		t.Errorf("got container patches %v and %v, want only A011 replaced", c.Patches[0], c.Patches[1])
+	}
+
+	v = &AMDUcode{Replace: amdPatch(0x8012, 1)}
+	if err := v.Run(f); err == nil {
+		t.Errorf("replaced the patch of a revision the image lacks")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// AMDVersions reports the AGESA version of an AMD image and the versions
+// of the PSP bootloaders, SMU firmware and AGESA bootloaders (ABLs) of its
+// PSP directories.
+type AMDVersions struct {
+	Reporter
+
+	// Optionally write the versions, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	AGESA    string `json:",omitempty"`
+	Binaries []manifest.BinaryVersion
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *AMDVersions) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	if v.AGESA != "" {
+		fmt.Fprintf(v.W, "AGESA: %s\n", v.AGESA)
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Level\tType\tName\tOffset\tSize\tVersion")
+	for _, b := range v.Binaries {
+		fmt.Fprintf(w, "%d\t%#04x\t%s\t%#x\t%#x\t%s\n", b.Level, b.Type, b.Name, b.Offset, b.Size, b.Version)
+	}
+	return w.Flush()
+}
+
+// Visit reads the versions of the image f.
+func (v *AMDVersions) Visit(f uefi.Firmware) error {
+	buf := f.Buf()
+	amd, err := manifest.NewAMDFirmware(manifest.FirmwareImage(buf))
+	if err != nil {
+		return err
+	}
+	v.AGESA, _ = manifest.FindAGESAVersion(buf)
+	v.Binaries = amd.BinaryVersions()
+	if v.Binaries == nil {
+		v.Binaries = []manifest.BinaryVersion{}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCLI("amd_versions", "print the AGESA version and the versions of the PSP bootloaders, SMU firmware and ABLs of an AMD image", 0, func(args []string) (uefi.Visitor, error) {
+		return &AMDVersions{W: os.Stdout}, nil
+	})
+}
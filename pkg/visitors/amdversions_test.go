@@ -0,0 +1,62 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestAMDVersions(t *testing.T) {
+	// A 512K image with its embedded firmware structure at 0xfffa0000
+	// and a PSP directory pointing to an SMU firmware.
+	buf := bytes.Repeat([]byte{0xff}, 0x80000)
+	copy(buf[0x20000:0x20040], make([]byte, 0x40))
+	binary.LittleEndian.PutUint32(buf[0x20000:], manifest.EmbeddedFirmwareStructureSignature)
+	binary.LittleEndian.PutUint32(buf[0x20014:], 0x21000)
+	dir := buf[0x21000:]
+	copy(dir, make([]byte, 0x20))
+	binary.LittleEndian.PutUint32(dir, manifest.PSPDirectoryTableCookie)
+	binary.LittleEndian.PutUint32(dir[8:], 1)
+	dir[16] = byte(manifest.SMUFirmwareEntry)
+	binary.LittleEndian.PutUint32(dir[20:], 0x100)
+	binary.LittleEndian.PutUint64(dir[24:], 0x22000)
+	copy(buf[0x22000:0x22100], make([]byte, 0x100))
+	copy(buf[0x22060:], []byte{0x00, 0x41, 0x2e, 0x00})
+	copy(buf[0x30000:], "AGESA!V9\x00CezannePI-FP6 1.0.0.8\x00")
+
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &AMDVersions{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.AGESA != "CezannePI-FP6 1.0.0.8" {
+		t.Errorf("got AGESA version %q, want CezannePI-FP6 1.0.0.8", v.AGESA)
+	}
+	if len(v.Binaries) != 1 || v.Binaries[0].Version != "46.65.0" {
+		t.Errorf("got binaries %+v, want the SMU firmware 46.65.0", v.Binaries)
+	}
+	for _, s := range []string{"AGESA: CezannePI-FP6 1.0.0.8\n", "SMU firmware  0x22000  0x100  46.65.0"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestAMDVersionsNotAMD(t *testing.T) {
+	v := &AMDVersions{}
+	if err := v.Run(parseImage(t)); err == nil {
+		t.Errorf("got versions %+v from OVMF, want an error", v.Binaries)
+	}
+}
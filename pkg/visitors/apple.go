@@ -0,0 +1,146 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoApple = errors.New("no Apple ROM version section nor BIOS ID found")
+
+// AppleInfoStore is a variable store of the NVRAM volumes of an image.
+type AppleInfoStore struct {
+	Type string
+	// Offset in the image.
+	Offset    uint64
+	Size      uint64
+	Variables int
+	Deleted   int
+	// BadCRC32 lists the Apple variables whose data does not match their
+	// CRC32, and the store itself, as "store", if its CRC32 does not match.
+	BadCRC32 []string `json:",omitempty"`
+}
+
+// AppleInfo reports the model and the version of an Apple image, from its
+// ROM version section, else its BIOS ID, and its NVRAM stores.
+type AppleInfo struct {
+	Reporter
+
+	// Optionally write the report, as text unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Model      string            `json:",omitempty"`
+	Version    string            `json:",omitempty"`
+	BIOSID     *uefi.BIOSID      `json:",omitempty"`
+	ROMVersion map[string]string `json:",omitempty"`
+	Stores     []AppleInfoStore
+
+	offsets map[uefi.Firmware]uint64
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *AppleInfo) Run(f uefi.Firmware) error {
+	var err error
+	if v.offsets, err = nodeOffsets(f); err != nil {
+		return err
+	}
+	v.Model, v.Version, v.BIOSID, v.ROMVersion, v.Stores = "", "", nil, nil, []AppleInfoStore{}
+	if id, err := uefi.FindBIOSID(f.Buf()); err == nil {
+		v.BIOSID = id
+	}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.ROMVersion == nil && v.BIOSID == nil {
+		return errNoApple
+	}
+	if v.ROMVersion == nil {
+		v.Model, v.Version = v.BIOSID.Board, v.BIOSID.ID
+	}
+
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Model:\t%s\n", v.Model)
+	fmt.Fprintf(w, "Version:\t%s\n", v.Version)
+	if v.BIOSID != nil {
+		fmt.Fprintf(w, "BIOS ID:\t%s\n", v.BIOSID.ID)
+	}
+	keys := make([]string, 0, len(v.ROMVersion))
+	for k := range v.ROMVersion {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s:\t%s\n", k, v.ROMVersion[k])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(v.Stores) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nStore\tOffset\tSize\tVariables\tDeleted\tBad CRC32")
+	for _, s := range v.Stores {
+		fmt.Fprintf(w, "%s\t%#x\t%#x\t%d\t%d\t%v\n", s.Type, s.Offset, s.Size, s.Variables, s.Deleted, s.BadCRC32)
+	}
+	return w.Flush()
+}
+
+// Visit applies the AppleInfo visitor to any Firmware type.
+func (v *AppleInfo) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.Section:
+		if f.AppleROMVersion != nil && v.ROMVersion == nil {
+			v.ROMVersion = f.AppleROMVersion.Fields
+			v.Model, v.Version = f.AppleROMVersion.Model(), f.AppleROMVersion.Version()
+		}
+		// The BIOS ID may be in a compressed section.
+		if v.BIOSID == nil && len(f.Encapsulated) == 0 {
+			if id, err := uefi.FindBIOSID(f.Buf()); err == nil {
+				v.BIOSID = id
+			}
+		}
+	case *uefi.VariableStore:
+		s := AppleInfoStore{Type: f.Type, Offset: v.offsets[f], Size: uint64(len(f.Buf())), Variables: len(f.Variables)}
+		for _, vr := range f.Variables {
+			if !vr.IsValid() {
+				s.Deleted++
+			}
+			if !vr.CRC32Valid() {
+				s.BadCRC32 = append(s.BadCRC32, vr.Name)
+			}
+		}
+		if !f.CRC32Valid() {
+			s.BadCRC32 = append(s.BadCRC32, "store")
+		}
+		v.Stores = append(v.Stores, s)
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("apple_info", "print the model and version of an Apple image, from its ROM version section or BIOS ID, and its NVRAM stores", 0, func(args []string) (uefi.Visitor, error) {
+		return &AppleInfo{W: os.Stdout}, nil
+	})
+}
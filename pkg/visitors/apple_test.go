@@ -0,0 +1,72 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+func TestAppleInfo(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The variable store of the NVRAM volume is erased there.
+	copy(image[0x1000:], append(append([]byte{}, uefi.BIOSIDSignature...), unicode.UTF8ToUCS2("MBP151.88Z.F000.B00.1811070914")...))
+	f, err := uefi.Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &AppleInfo{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.Model != "MBP151" || v.Version != "MBP151.88Z.F000.B00.1811070914" {
+		t.Errorf("got model %q version %q, want those of the BIOS ID", v.Model, v.Version)
+	}
+	want := AppleInfoStore{Type: uefi.VariableStoreAuthVSS, Offset: 0x48, Size: 0x3ffb8}
+	if len(v.Stores) != 1 || v.Stores[0].Type != want.Type || v.Stores[0].Offset != want.Offset || v.Stores[0].Size != want.Size {
+		t.Errorf("got stores %+v, want %+v", v.Stores, want)
+	}
+	for _, s := range []string{"Model:    MBP151\n", "Auth VSS  0x48"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestAppleInfoROMVersion(t *testing.T) {
+	s, err := uefi.CreateSection(uefi.SectionTypeRaw, []byte("Apple ROM Version\n  Model:        MBP151\n  EFI Version:  220.230.16.0.0\n"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = uefi.NewSection(s.Buf(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &AppleInfo{}
+	if err := v.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if v.Model != "MBP151" || v.Version != "220.230.16.0.0" || v.BIOSID != nil {
+		t.Errorf("got model %q version %q BIOS ID %v, want MBP151 220.230.16.0.0 and no BIOS ID", v.Model, v.Version, v.BIOSID)
+	}
+}
+
+func TestAppleInfoNoApple(t *testing.T) {
+	if err := (&AppleInfo{}).Run(parseImage(t)); err != errNoApple {
+		t.Errorf("got %v, want %v", err, errNoApple)
+	}
+}
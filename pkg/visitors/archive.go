@@ -0,0 +1,149 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Formats of the archives Extract writes and ParseDir reads instead of
+// directories, chosen by the extension of their path.
+const (
+	archiveNone = ""
+	archiveTar  = ".tar"
+	archiveZip  = ".zip"
+)
+
+// archiveFormat returns the format of the archive at p, if p names one.
+func archiveFormat(p string) string {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case archiveTar:
+		return archiveTar
+	case archiveZip:
+		return archiveZip
+	}
+	return archiveNone
+}
+
+// IsArchive returns true if Extract and ParseDir use an archive at p
+// rather than a directory.
+func IsArchive(p string) bool {
+	return archiveFormat(p) != archiveNone
+}
+
+// writeArchive writes the files of fsys to the archive at p. Names in the
+// archive are slash separated, whatever the system, and the entries are
+// sorted, so identical trees give identical archives.
+func writeArchive(fsys fs.FS, p string) error {
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			names = append(names, name)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	switch archiveFormat(p) {
+	case archiveTar:
+		w := tar.NewWriter(&buf)
+		for _, n := range names {
+			data, err := fs.ReadFile(fsys, n)
+			if err != nil {
+				return err
+			}
+			h := &tar.Header{Name: n, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+			if err := w.WriteHeader(h); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	case archiveZip:
+		w := zip.NewWriter(&buf)
+		for _, n := range names {
+			data, err := fs.ReadFile(fsys, n)
+			if err != nil {
+				return err
+			}
+			fw, err := w.CreateHeader(&zip.FileHeader{Name: n, Method: zip.Deflate})
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(data); err != nil {
+				return err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s: not a .tar or .zip archive", p)
+	}
+	return os.WriteFile(p, buf.Bytes(), 0666)
+}
+
+// readArchive returns the file system of the archive at p.
+func readArchive(p string) (fs.FS, error) {
+	switch archiveFormat(p) {
+	case archiveTar:
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files := MemFS{}
+		r := tar.NewReader(f)
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				return files, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			if err := files.WriteFile(path.Clean(h.Name), data, 0644); err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+		}
+	case archiveZip:
+		// The archive is read into memory, so there is nothing to close.
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("%s: not a .tar or .zip archive", p)
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractArchive(t *testing.T) {
+	want, _ := extractAndAssemble(t, false)
+
+	tmpDir, err := os.MkdirTemp("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"ovmf.tar", "ovmf.zip", "OVMF.ZIP"} {
+		t.Run(name, func(t *testing.T) {
+			var archives [][]byte
+			for i := 0; i < 2; i++ {
+				p := filepath.Join(tmpDir, fmt.Sprint(i, name))
+				var fIndex uint64
+				if err := (&Extract{BasePath: p, DirPath: ".", Index: &fIndex}).Run(parseImage(t)); err != nil {
+					t.Fatal(err)
+				}
+				a, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				archives = append(archives, a)
+
+				f, err := (&ParseDir{BasePath: p}).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := (&Assemble{Options: &AssembleOptions{Deterministic: true}}).Run(f); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(f.Buf(), want) {
+					t.Errorf("the archive assembles to a different image than the directory")
+				}
+			}
+			if !bytes.Equal(archives[0], archives[1]) {
+				t.Errorf("extracting the same image twice gave different archives")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveExists(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	p := filepath.Join(tmpDir, "exists.tar")
+	if err := os.WriteFile(p, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var fIndex uint64
+	if err := (&Extract{BasePath: p, DirPath: ".", Index: &fIndex}).Run(parseImage(t)); err == nil {
+		t.Errorf("extracting over an existing archive succeeded, want an error")
+	}
+}
+
+func TestParseDirArchiveErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := writeArchive(DirFS(tmpDir), filepath.Join(tmpDir, "empty.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ParseDir{BasePath: filepath.Join(tmpDir, "empty.zip")}).Parse(); err == nil {
+		t.Errorf("parsing an archive without summary.json succeeded, want an error")
+	}
+	if _, err := (&ParseDir{BasePath: filepath.Join(tmpDir, "missing.tar")}).Parse(); err == nil {
+		t.Errorf("parsing a missing archive succeeded, want an error")
+	}
+}
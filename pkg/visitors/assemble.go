@@ -19,13 +19,106 @@ import (
 
 // Assemble reconstitutes the firmware tree assuming that the leaf node buffers are accurate
 type Assemble struct {
-	// This is set when a file or section >=16MiB is encountered during assembly.
-	// This tells the enclosing FV to use the FFSV3 GUID instead of the FFSV2 GUID,
-	// and the enclosing FV resets it.
-	// TODO: figure out if, in the case where the FVs are triply nested, must the FVs further up
-	// also use the FFSV3 GUID? In that case we should fix this since only the innermost
-	// enclosing FV changes to FFSV3
-	useFFS3 bool
+	Cancelable
+
+	// Options of the assembly, nil for those of the context.
+	Options *AssembleOptions
+
+	// xipVolumes are the volumes whose executables run in place, with how
+	// much their address moved.
+	xipVolumes map[*uefi.FirmwareVolume]uint64
+}
+
+// assembleEncapsulated returns the data of the firmware a section
+// encapsulates, laying out sections like a file does.
+func assembleEncapsulated(s *uefi.Section) ([]byte, error) {
+	var sections []*uefi.Section
+	for _, es := range s.Encapsulated {
+		if es, ok := es.Value.(*uefi.Section); ok {
+			sections = append(sections, es)
+		}
+	}
+	if len(sections) == len(s.Encapsulated) {
+		sections, secData, err := uefi.LayoutSections(sections, 0)
+		if err != nil {
+			return nil, err
+		}
+		s.Encapsulated = s.Encapsulated[:0]
+		for _, es := range sections {
+			s.Encapsulated = append(s.Encapsulated, uefi.MakeTyped(es))
+		}
+		return secData, nil
+	}
+
+	secData := []byte{}
+	for _, es := range s.Encapsulated {
+		// Align to 4 bytes and extend with 00s
+		for count := uefi.Align4(uint64(len(secData))) - uint64(len(secData)); count > 0; count-- {
+			secData = append(secData, 0x00)
+		}
+		secData = append(secData, es.Value.Buf()...)
+	}
+	return secData, nil
+}
+
+// assembleExtHeader regenerates the extended header of a volume if its name
+// or entries changed. GenFv puts it in a pad file right after the header,
+// which is regenerated too. The data of volumes without files is kept, so
+// their extended header keeps its size.
+func assembleExtHeader(f *uefi.FirmwareVolume) error {
+	if f.ExtHeaderOffset == 0 {
+		return nil
+	}
+	ext, err := f.ExtHeaderBuf()
+	if err != nil {
+		return err
+	}
+	fBuf := f.Buf()
+	offset := uint64(f.ExtHeaderOffset)
+	if offset+uint64(len(ext)) <= uint64(len(fBuf)) && bytes.Equal(fBuf[offset:offset+uint64(len(ext))], ext) {
+		return nil
+	}
+
+	var header []byte
+	if inPad := uint64(f.HeaderLen) + uefi.FileHeaderMinLength; offset == inPad {
+		pad, err := uefi.CreateErasedPadFile(inPad-uint64(f.HeaderLen)+uint64(len(ext)), f.GetErasePolarity())
+		if err != nil {
+			return err
+		}
+		if err := pad.ChecksumAndAssemble(ext); err != nil {
+			return err
+		}
+		header = append(append([]byte{}, fBuf[:f.HeaderLen]...), pad.Buf()...)
+	} else if offset >= uint64(f.HeaderLen) && offset <= uint64(len(fBuf)) {
+		header = append(append([]byte{}, fBuf[:offset]...), ext...)
+	} else {
+		return fmt.Errorf("extended header offset %#x is out of the volume header", offset)
+	}
+	dataOffset := uefi.Align8(uint64(len(header)))
+	erased := make([]byte, dataOffset-uint64(len(header)))
+	uefi.Erase(erased, f.GetErasePolarity())
+	header = append(header, erased...)
+
+	// The header points to the extended header, which needs revision 2.
+	binary.LittleEndian.PutUint16(header[52:], f.ExtHeaderOffset)
+	header[55] = f.Revision
+	binary.LittleEndian.PutUint16(header[50:], 0)
+	sum, err := uefi.Checksum16(header[:f.HeaderLen])
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(header[50:], 0-sum)
+
+	if len(f.Files) == 0 {
+		if dataOffset != f.DataOffset || dataOffset > uint64(len(fBuf)) {
+			return fmt.Errorf("cannot resize the extended header of volume %v without files", f.FVName)
+		}
+		copy(fBuf, header)
+		return nil
+	}
+	f.SetBuf(header)
+	f.DataOffset = dataOffset
+	return nil
 }
 
 // Run just applies the visitor.
@@ -33,31 +126,42 @@ func (v *Assemble) Run(f uefi.Firmware) error {
 	return f.Apply(v)
 }
 
+// options returns the options of the assembly.
+func (v *Assemble) options() *AssembleOptions {
+	if v.Options != nil {
+		return v.Options
+	}
+	return AssembleOptionsOf(v.Context())
+}
+
 // Visit applies the Assemble visitor to any Firmware type.
 func (v *Assemble) Visit(f uefi.Firmware) error {
 	var err error
 
-	// Get the damn Erase Polarity
-	if f, ok := f.(*uefi.FirmwareVolume); ok {
-		// Set Erase Polarity
-		if err = uefi.SetErasePolarity(f.GetErasePolarity()); err != nil {
-			return err
-		}
-	}
-
 	// We first assemble the children.
 	// Sounds horrible but has to be done =(
-	if err = f.ApplyChildren(v); err != nil {
+	if r, ok := f.(*uefi.BIOSRegion); ok && v.options().Rebase {
+		err = v.applyXIPChildren(r)
+	} else {
+		err = f.ApplyChildren(v)
+	}
+	if err != nil {
 		return err
 	}
 
 	switch f := f.(type) {
 
 	case *uefi.FirmwareVolume:
+		if err = assembleExtHeader(f); err != nil {
+			return err
+		}
 		if len(f.Files) == 0 {
 			// No children, buffer should already contain data.
 			return nil
 		}
+		if f.Files, err = padFiles(f, v.options().PadPolicy); err != nil {
+			return err
+		}
 		// We assume the buffer already contains the header. We repopulate the header from the buffer
 		// Construct the full buffer.
 		// The FV header is the only thing we've read in so far.
@@ -77,6 +181,20 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			f.SetBuf(fBuf)
 		}
 
+		// Files of 16MiB or more have the extended header of FFSv3. Large
+		// sections make large files, so checking the files is enough.
+		var large *uefi.File
+		for _, file := range f.Files {
+			if file.Header.Attributes.IsLarge() {
+				large = file
+				break
+			}
+		}
+		if large != nil && f.FileSystemGUID == *uefi.FFS2 && v.options().StrictFFS2 {
+			return fmt.Errorf("file %v of %#x bytes needs FFSv3, but the volume is FFSv2",
+				large.Header.GUID, large.Header.ExtendedSize)
+		}
+
 		for _, file := range f.Files {
 			fileBuf := file.Buf()
 			fileLen := uint64(len(fileBuf))
@@ -101,7 +219,7 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 				}
 				if newOffset != alignedOffset && false {
 					// Add a pad file starting from alignedOffset to newOffset
-					pfile, err := uefi.CreatePadFile(newOffset - alignedOffset)
+					pfile, err := uefi.CreateErasedPadFile(newOffset-alignedOffset, f.GetErasePolarity())
 					if err != nil {
 						return err
 					}
@@ -111,6 +229,14 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 				}
 				alignedOffset = newOffset
 			}
+			// Executables which run in place follow their file.
+			if fvMove, ok := v.xipVolumes[f]; ok && rebaseFile(file, alignedOffset, fvMove) {
+				if err = v.Visit(file); err != nil {
+					return err
+				}
+				fileBuf = file.Buf()
+			}
+			file.Offset = alignedOffset
 			if err = f.InsertFile(alignedOffset, fileBuf); err != nil {
 				return fmt.Errorf("file %s: %v", file.Header.GUID, err)
 			}
@@ -139,7 +265,7 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			// If the buffer is not long enough, pad ErasePolarity
 			extLen := f.Length - newFVLen
 			emptyBuf := make([]byte, extLen)
-			uefi.Erase(emptyBuf, uefi.Attributes.ErasePolarity)
+			uefi.Erase(emptyBuf, f.GetErasePolarity())
 			f.SetBuf(append(f.Buf(), emptyBuf...))
 		}
 
@@ -153,13 +279,12 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		// Write the correct GUID to the correct spot
 		// Refer to EFI_FIRMWARE_FILE_SYSTEM3_GUID in section 3.2.2, volume 3 in
 		// the UEFI PI Specification version 1.6
-		if v.useFFS3 && f.FileSystemGUID == *uefi.FFS2 {
-			// There is a large file or section, we need to swap to FFSV3
+		if large != nil && f.FileSystemGUID == *uefi.FFS2 {
+			// There is a large file, we need to swap to FFSV3
 			f.FileSystemGUID = *uefi.FFS3
 			// Write it out
 			copy(fBuf[16:32], f.FileSystemGUID[:])
 		}
-		v.useFFS3 = false
 
 		// Write the block map count
 		binary.LittleEndian.PutUint32(fBuf[56:], f.Blocks[0].Count)
@@ -178,6 +303,12 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		f.SetBuf(fBuf)
 
 	case *uefi.File:
+		if cv := f.CompressedVolume; cv != nil && len(cv.Buf()) != 0 {
+			// Keep the vendor header between the file header and the
+			// stream.
+			fileData := append([]byte{}, f.Buf()[f.DataOffset:f.DataOffset+cv.Offset]...)
+			return f.ChecksumAndAssemble(append(fileData, cv.Buf()...))
+		}
 		if len(f.Sections) == 0 && f.NVarStore == nil {
 			// No children, buffer should already contain data.
 			// we don't support this file type, just return the raw buffer.
@@ -204,27 +335,23 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			fileData = f.NVarStore.Buf()
 			dLen = f.NVarStore.Length
 		} else {
-			for _, s := range f.Sections {
-				// Align to 4 bytes and extend with 00s
-				// Why is it 00s? I don't know. Everything else has been extended with FFs
-				// but somehow in between sections alignment is done with 0s. What the heck.
-				for count := uefi.Align4(dLen) - dLen; count > 0; count-- {
-					fileData = append(fileData, 0x00)
+			// Sections are aligned relative to the start of the file,
+			// whose header is longer for large files.
+			sections, data, err := uefi.LayoutSections(f.Sections, uefi.FileHeaderMinLength)
+			if err != nil {
+				return err
+			}
+			if uefi.FileHeaderMinLength+uint64(len(data)) > 0xFFFFFF {
+				if sections, data, err = uefi.LayoutSections(f.Sections, uefi.FileHeaderExtMinLength); err != nil {
+					return err
 				}
-				dLen = uefi.Align4(dLen)
-
-				// Append the section
-				sData := s.Buf()
-				dLen += uint64(len(sData))
-				fileData = append(fileData, sData...)
 			}
+			f.Sections = sections
+			fileData = data
+			dLen = uint64(len(data))
 		}
 
 		f.SetSize(uefi.FileHeaderMinLength+dLen, true)
-		// We need to use FFSV3
-		if f.Header.ExtendedSize > 0xFFFFFF {
-			v.useFFS3 = true
-		}
 
 		// TODO: Not setting to valid used to cause some failures on some bioses, verify that it no longer fails.
 		// There are some bioses that don't set the valid bits correctly,
@@ -243,6 +370,33 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			switch f.Header.Type {
 			default:
 				return nil
+			case uefi.SectionTypeRaw, uefi.SectionTypeFreeformSubtypeGUID:
+				var child uefi.Firmware
+				var offset, end uint64
+				buf := f.Buf()
+				switch {
+				case f.OptionROM != nil:
+					child, offset = f.OptionROM, f.OptionROM.Offset
+					end = offset + f.OptionROM.Length
+					if f.OptionROM.Length == 0 || end > uint64(len(buf)) {
+						end = uint64(len(buf))
+					}
+					if bytes.Equal(buf[offset:end], child.Buf()) {
+						// Unchanged, keep the data after the ROM as well.
+						return nil
+					}
+				case f.CompressedVolume != nil && len(f.CompressedVolume.Buf()) != 0:
+					child, offset = f.CompressedVolume, f.CompressedVolume.Offset
+					end = uint64(len(buf))
+				default:
+					return nil
+				}
+				// Keep the GUID of freeform sections, or the vendor header,
+				// which is between the header and the child, and the data
+				// after the child, like padding.
+				newBuf := append([]byte{}, buf[f.HeaderLen():offset]...)
+				newBuf = append(newBuf, child.Buf()...)
+				f.SetBuf(append(newBuf, buf[end:]...))
 			case uefi.SectionTypeUserInterface:
 				f.SetBuf(unicode.UTF8ToUCS2(f.Name))
 			case uefi.SectionTypeVersion:
@@ -283,37 +437,38 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			}
 
 			// We've got the data in the section buffer, now regenerate the header.
-			err = f.GenSecHeader()
-			if f.Header.ExtendedSize > 0xFFFFFF {
-				v.useFFS3 = true
-			}
-			return err
+			return f.GenSecHeader()
 		}
 
 		// Construct the section data
-		secData := []byte{}
-		dLen := uint64(0)
-		for _, es := range f.Encapsulated {
-			// Align to 4 bytes and extend with 00s
-			for count := uefi.Align4(dLen) - dLen; count > 0; count-- {
-				secData = append(secData, 0x00)
-			}
-			dLen = uefi.Align4(dLen)
-
-			esData := es.Value.Buf()
-			dLen += uint64(len(esData))
-			secData = append(secData, esData...)
+		secData, err := assembleEncapsulated(f)
+		if err != nil {
+			return err
 		}
 
 		// Special processing for some section types
 		switch f.Header.Type {
 		case uefi.SectionTypeGUIDDefined:
 			ts := f.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+			if ts.OriginalSHA256 != "" && len(f.Buf()) > int(ts.DataOffset) && ts.OriginalSum(secData) == ts.OriginalSHA256 {
+				// Unchanged, keep the original encoding, which may be
+				// smaller than encoding again.
+				return nil
+			}
 			if ts.Attributes&uint16(uefi.GUIDEDSectionProcessingRequired) != 0 {
-				compressor := compression.CompressorFromGUID(&ts.GUID)
+				copts := v.options().compressionOptions(v.Context())
+				compressor, err := copts.CompressorFromGUIDVariant(&ts.GUID, ts.Variant)
+				if err != nil {
+					return err
+				}
 				if compressor == nil {
 					return fmt.Errorf("unknown guid defined from section %v, should not have encapsulated sections", f)
 				}
+				// An LZMA variant may add or drop the x86 filter.
+				if g, ok := compression.GUIDFromCompressor(compressor); ok {
+					ts.GUID = g
+				}
+				ts.Compression = compressor.Name()
 				if fBuf, err := compressor.Encode(secData); err == nil {
 					f.SetBuf(fBuf)
 				} else {
@@ -325,10 +480,44 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		}
 
 		// Fix up the header
-		err = f.GenSecHeader()
-		if f.Header.ExtendedSize > 0xFFFFFF {
-			v.useFFS3 = true
+		return f.GenSecHeader()
+
+	case *uefi.OptionROM:
+		romData := []byte{}
+		for i, img := range f.Images {
+			img.SetLast(i == len(f.Images)-1)
+			img.Offset = uint64(len(romData))
+			romData = append(romData, img.Buf()...)
 		}
+		f.SetBuf(romData)
+
+	case *uefi.CompressedVolume:
+		data := []byte{}
+		for _, fv := range f.Volumes {
+			data = append(data, fv.Buf()...)
+		}
+		if f.OriginalSHA256 != "" && f.OriginalSum(data) == f.OriginalSHA256 {
+			// Unchanged, keep the original stream, or the parent if the
+			// stream was not read back.
+			return nil
+		}
+		compressor, err := f.Compressor(v.options().compressionOptions(v.Context()))
+		if err != nil {
+			return err
+		}
+		stream, err := compressor.Encode(data)
+		if err != nil {
+			return err
+		}
+		if uint64(len(stream)) > f.Length {
+			return fmt.Errorf("volumes compressed to %#x bytes, more than the %#x bytes of the original stream", len(stream), f.Length)
+		}
+		// The room of the stream is the rest of the parent, whose size the
+		// vendor header may hold, so pad it as erased flash.
+		fBuf := bytes.Repeat([]byte{0xff}, int(f.Length))
+		copy(fBuf, stream)
+		f.SetBuf(fBuf)
+		f.OriginalSHA256 = f.OriginalSum(data)
 
 	case *uefi.NVarStore:
 		nvData := []byte{}
@@ -404,16 +593,34 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 
 		return nil
 
+	case *uefi.BIOSPadding:
+		if f.EC != nil {
+			return uefi.PlaceECFirmware(f.Buf(), f.EC)
+		}
+		return nil
+
+	case *uefi.RawRegion:
+		if f.EC != nil {
+			return uefi.PlaceECFirmware(f.Buf(), f.EC)
+		}
+		return nil
+
+	case *uefi.BIOSBackup:
+		// Put the elements together, as for the region.
+		var fBuf []byte
+		for _, e := range f.Elements {
+			fBuf = append(fBuf, e.Value.Buf()...)
+		}
+		f.SetBuf(fBuf)
+		return nil
+
 	case *uefi.BIOSRegion:
 		fBuf := make([]byte, f.Length)
 		firstFV, err := f.FirstFV()
 		if err != nil {
 			return err
 		}
-		if err = uefi.SetErasePolarity(firstFV.GetErasePolarity()); err != nil {
-			return err
-		}
-		uefi.Erase(fBuf, uefi.Attributes.ErasePolarity)
+		uefi.Erase(fBuf, firstFV.GetErasePolarity())
 		// Put the elements together
 		offset := uint64(0)
 		for _, e := range f.Elements {
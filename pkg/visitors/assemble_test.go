@@ -5,9 +5,15 @@
 package visitors
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"math/rand"
+	"os"
 	"testing"
 
+	"github.com/linuxboot/fiano/pkg/compression"
 	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
@@ -46,3 +52,287 @@ func TestBadDepex(t *testing.T) {
 		})
 	}
 }
+
+// deterministicSHA256 is the hash of the OVMF image recompressed by a
+// deterministic assembly. It only changes with the internal compressors.
+const deterministicSHA256 = "2c67e77feb5d369b1141b8474b6801da1dad4162c3c060bcde9c7b536f238625"
+
+func TestAssembleDeterministic(t *testing.T) {
+
+	tmpDir, err := os.MkdirTemp("", "deterministic-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	var fIndex uint64
+	if err := (&Extract{BasePath: tmpDir, DirPath: ".", Index: &fIndex}).Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := (&ParseDir{BasePath: tmpDir}).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Forget the original encodings, so every section is compressed again.
+	forget := &Find{Predicate: func(f uefi.Firmware) bool {
+		if s, ok := f.(*uefi.Section); ok && s.TypeSpecific != nil {
+			if ts, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+				ts.OriginalSHA256 = ""
+			}
+		}
+		return false
+	}}
+	if err := forget.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Neither an External compressor nor the system xz may be used.
+	opts := &AssembleOptions{
+		Deterministic: true,
+		Externals:     map[guid.GUID]*compression.External{compression.LZMAGUID: {Encoder: []string{"false"}}},
+	}
+	if err := (&Assemble{Options: opts}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%x", sha256.Sum256(f.Buf())); got != deterministicSHA256 {
+		t.Errorf("got image with SHA256 %s, want %s", got, deterministicSHA256)
+	}
+}
+
+func TestAssembleCompressionVariant(t *testing.T) {
+	data := bytes.Repeat([]byte("fiano"), 1000)
+	for _, tt := range []struct {
+		guid        guid.GUID
+		variant     string
+		compression string
+		// parsed is the variant parsing detects.
+		parsed string
+	}{
+		{compression.TianoGUID, "", "Tiano", ""},
+		{compression.TianoGUID, "Tiano,block=16,nosingle", "Tiano", ""},
+		// Parsing falls back to EFI 1.1.
+		{compression.TianoGUID, "EFI", "EFI", "EFI"},
+		{compression.LZMAGUID, "", "LZMA", ""},
+		{compression.LZMAGUID, "LZMA,dict=64KiB,lc=0", "LZMA", "LZMA,dict=64KiB,lc=0,lp=0,pb=2"},
+		// The x86 filter changes the GUID.
+		{compression.LZMAGUID, "LZMAX86,dict=1MiB", "LZMAX86", "LZMAX86,dict=1MiB"},
+	} {
+		raw, err := uefi.CreateSection(uefi.SectionTypeRaw, data, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := uefi.CreateSection(uefi.SectionTypeGUIDDefined, nil, []uefi.Firmware{raw}, &tt.guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.TypeSpecific.Header.(*uefi.SectionGUIDDefined).Variant = tt.variant
+		if err := (&Assemble{Options: &AssembleOptions{Deterministic: true}}).Run(s); err != nil {
+			t.Fatalf("%q: %v", tt.variant, err)
+		}
+
+		parsed, err := uefi.NewSection(s.Buf(), 0)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.variant, err)
+		}
+		ts := parsed.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+		if ts.Compression != tt.compression {
+			t.Errorf("%q: parsed as %s, want %s", tt.variant, ts.Compression, tt.compression)
+		}
+		if len(parsed.Encapsulated) != 1 || !bytes.HasSuffix(parsed.Encapsulated[0].Value.Buf(), data) {
+			t.Errorf("%q: the raw section did not decode", tt.variant)
+		}
+		if ts.Variant != tt.parsed {
+			t.Errorf("%q: parsed variant %q, want %q", tt.variant, ts.Variant, tt.parsed)
+		}
+
+		// Assembling the parsed section encodes it alike.
+		assembled := append([]byte{}, s.Buf()...)
+		parsed.Encapsulated[0].Value.(*uefi.Section).SetBuf(append([]byte{}, parsed.Encapsulated[0].Value.Buf()...))
+		if err := (&Assemble{}).Run(parsed); err != nil {
+			t.Fatalf("%q: %v", tt.variant, err)
+		}
+		if tt.parsed != "" && !bytes.Equal(parsed.Buf(), assembled) {
+			t.Errorf("%q: assembled the parsed section differently", tt.variant)
+		}
+	}
+}
+
+func TestAssembleKeepsEncoding(t *testing.T) {
+	f := parseImage(t)
+	files := find(t, f, guid.MustParse("9E21FD93-9C72-4C15-8C4B-E77F1DB2D792"))
+	if len(files) != 1 {
+		t.Fatalf("got %d files holding the DXE volume, want 1", len(files))
+	}
+	s := files[0].(*uefi.File).Sections[0]
+	if s.Header.Type != uefi.SectionTypeGUIDDefined {
+		t.Fatalf("got %v section, want the compressed one", s.Header.Type)
+	}
+	orig := append([]byte{}, s.Buf()...)
+
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf(), orig) {
+		t.Errorf("the unchanged compressed section was encoded again")
+	}
+
+	remove := &Remove{Predicate: FindFileGUIDPredicate(*dxeCoreGUID)}
+	if err := remove.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(s.Buf(), orig) {
+		t.Errorf("the changed compressed section kept its encoding")
+	}
+}
+
+func BenchmarkAssemble(b *testing.B) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := uefi.Parse(image)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := (&Assemble{}).Run(f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAssembleLargeFile(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			f := parseImage(t)
+			files := find(t, f, guid.MustParse("9E21FD93-9C72-4C15-8C4B-E77F1DB2D792"))
+			if len(files) != 1 {
+				t.Fatalf("got %d files holding the DXE volume, want 1", len(files))
+			}
+			file := files[0].(*uefi.File)
+			find := &Find{Predicate: func(f uefi.Firmware) bool {
+				fv, ok := f.(*uefi.FirmwareVolume)
+				if !ok {
+					return false
+				}
+				for _, ff := range fv.Files {
+					if ff == file {
+						return true
+					}
+				}
+				return false
+			}}
+			if err := find.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if len(find.Matches) != 1 {
+				t.Fatalf("got %d volumes holding the file, want 1", len(find.Matches))
+			}
+			fv := find.Matches[0].(*uefi.FirmwareVolume)
+			if fv.FileSystemGUID != *uefi.FFS2 {
+				t.Fatalf("got %v volume, want FFSv2", fv.FileSystemGUID)
+			}
+			fv.Resizable = true
+
+			s, err := uefi.CreateSection(uefi.SectionTypeRaw, make([]byte, 17<<20), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Sections = []*uefi.Section{s}
+
+			err = (&Assemble{Options: &AssembleOptions{StrictFFS2: strict}}).Run(fv)
+			if strict {
+				if err == nil {
+					t.Errorf("assembled a large file into an FFSv2 volume, want an error")
+				}
+				a := &Assemble{}
+				a.SetContext(WithAssembleOptions(context.Background(), &AssembleOptions{StrictFFS2: true}))
+				if err := a.Run(fv); err == nil {
+					t.Errorf("assembled a large file into an FFSv2 volume with the options of the context, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !file.Header.Attributes.IsLarge() {
+				t.Errorf("the file of %#x bytes is not large", file.Header.ExtendedSize)
+			}
+			if fv.FileSystemGUID != *uefi.FFS3 {
+				t.Errorf("got %v volume, want FFSv3", fv.FileSystemGUID)
+			}
+		})
+	}
+}
+
+// compressedVolumeSection returns a raw section holding the volume of
+// ovmfSECFV.fv compressed with LZMA after a vendor header, with room bytes
+// to spare.
+func compressedVolumeSection(t *testing.T, room int) *uefi.Section {
+	fv, err := os.ReadFile("../../integration/roms/ovmfSECFV.fv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := (&compression.LZMA{}).Encode(fv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := append([]byte("$INSYDE$"), stream...)
+	s, err := uefi.CreateSection(uefi.SectionTypeRaw, append(body, bytes.Repeat([]byte{0xff}, room)...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = uefi.NewSection(s.Buf(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if s.CompressedVolume == nil {
+		t.Fatal("got no compressed volume")
+	}
+	return s
+}
+
+func TestAssembleCompressedVolume(t *testing.T) {
+	s := compressedVolumeSection(t, 0x100)
+	orig := append([]byte{}, s.Buf()...)
+	if err := (&Assemble{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf(), orig) {
+		t.Errorf("the unchanged compressed volume was encoded again")
+	}
+
+	files := s.CompressedVolume.Volumes[0].Files
+	remove := &Remove{Predicate: FindFileGUIDPredicate(files[len(files)-1].Header.GUID)}
+	if err := remove.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Buf()) != len(orig) || bytes.Equal(s.Buf(), orig) || !bytes.Equal(s.Buf()[:s.CompressedVolume.Offset], orig[:s.CompressedVolume.Offset]) {
+		t.Errorf("got a section of %#x bytes, want the vendor header and a new stream in %#x bytes", len(s.Buf()), len(orig))
+	}
+	parsed, err := uefi.NewSection(s.Buf(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cv := parsed.CompressedVolume; cv == nil || len(cv.Volumes) != 1 || len(cv.Volumes[0].Files) != len(files)-1 {
+		t.Errorf("got %+v, want the volume without the removed file", cv)
+	}
+
+	// Data compressing worse than the volume does not fit.
+	s = compressedVolumeSection(t, 0)
+	fv := s.CompressedVolume.Volumes[0]
+	random := make([]byte, len(fv.Buf()))
+	rand.New(rand.NewSource(1)).Read(random)
+	fv.Files = nil
+	fv.SetBuf(random)
+	if err := (&Assemble{}).Run(s.CompressedVolume); err == nil {
+		t.Errorf("assembled volumes which do not fit, want an error")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// AssembleOptions are the options of one assembly. They are set on the
+// Assemble visitor, or on the context of the visitors assembling with
+// WithAssembleOptions, so that tools sharing a process may assemble
+// differently.
+type AssembleOptions struct {
+	// StrictFFS2 makes Assemble fail on FFSv2 volumes holding files of
+	// 16MiB or more, which need the extended header of FFSv3, rather than
+	// changing them to FFSv3, for firmware which only parses FFSv2.
+	StrictFFS2 bool
+	// PadPolicy is how the pad files of volumes are treated, PadPreserve,
+	// PadErase or PadMinimize. Empty means PadPreserve.
+	PadPolicy string
+	// Rebase relocates the SEC and PEI executables which run in place from
+	// the volumes of the BIOS region, if their file or volume moved, rather
+	// than keeping their image base.
+	Rebase bool
+	// Deterministic encodes the compressed sections with the compressors
+	// of compression.Options which are Deterministic, so identical trees
+	// assemble to identical images on every system.
+	Deterministic bool
+	// Externals are the External compressors encoding the sections of
+	// their GUID, unless Deterministic.
+	Externals map[guid.GUID]*compression.External
+}
+
+// compressionOptions returns the options of the compressors of the
+// assembly, whose commands run until ctx is done.
+func (o *AssembleOptions) compressionOptions(ctx context.Context) compression.Options {
+	return compression.Options{Deterministic: o.Deterministic, Externals: o.Externals, Context: ctx}
+}
+
+type assembleOptionsKey struct{}
+
+// WithAssembleOptions returns a context assembling with opts.
+func WithAssembleOptions(ctx context.Context, opts *AssembleOptions) context.Context {
+	return context.WithValue(ctx, assembleOptionsKey{}, opts)
+}
+
+// AssembleOptionsOf returns the options of the assemblies of ctx, the
+// defaults if it has none.
+func AssembleOptionsOf(ctx context.Context) *AssembleOptions {
+	if opts, ok := ctx.Value(assembleOptionsKey{}).(*AssembleOptions); ok && opts != nil {
+		return opts
+	}
+	return &AssembleOptions{}
+}
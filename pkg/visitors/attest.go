@@ -0,0 +1,265 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// AttestNode is the hash of a node of an AttestManifest.
+type AttestNode struct {
+	// Path of the node from the root, named like in DiffEntry.
+	Path   string
+	Type   string
+	SHA256 string
+}
+
+// AttestManifest holds the hashes of every node of a golden image, the
+// regions, volumes, files and sections, so an image read back from a
+// machine can be checked against it with Verify.
+type AttestManifest struct {
+	Nodes []AttestNode
+	// Signature is the Ed25519 signature of the JSON of the manifest
+	// without it, if signed.
+	Signature []byte `json:",omitempty"`
+}
+
+// signedBytes returns the bytes the signature of m signs.
+func (m *AttestManifest) signedBytes() ([]byte, error) {
+	return json.Marshal(&AttestManifest{Nodes: m.Nodes})
+}
+
+// Errors of Verify.
+var (
+	ErrDrift        = errors.New("the image drifted from its manifest")
+	ErrBadSignature = errors.New("invalid manifest signature")
+)
+
+// attestNodes returns the hashes of f and its descendants.
+func attestNodes(f uefi.Firmware) []AttestNode {
+	var nodes []AttestNode
+	var walk func(path string, f uefi.Firmware)
+	walk = func(path string, f uefi.Firmware) {
+		sum := sha256.Sum256(f.Buf())
+		nodes = append(nodes, AttestNode{
+			Path:   path,
+			Type:   strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		keys, children := diffChildren(f)
+		for _, k := range keys {
+			walk(path+"/"+k, children[k])
+		}
+	}
+	walk(diffLabel(f), f)
+	return nodes
+}
+
+// Attest writes the AttestManifest of the image to Path, signed with Key
+// if set.
+type Attest struct {
+	Path string
+	Key  ed25519.PrivateKey
+
+	// Output
+	Manifest *AttestManifest
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Attest) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit writes the manifest of f.
+func (v *Attest) Visit(f uefi.Firmware) error {
+	v.Manifest = &AttestManifest{Nodes: attestNodes(f)}
+	if v.Key != nil {
+		b, err := v.Manifest.signedBytes()
+		if err != nil {
+			return err
+		}
+		v.Manifest.Signature = ed25519.Sign(v.Key, b)
+	}
+	b, err := json.MarshalIndent(v.Manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(v.Path, append(b, '\n'), 0666)
+}
+
+// Outputs implements Outputter.
+func (v *Attest) Outputs() []string { return []string{v.Path} }
+
+// Verify checks the image against the AttestManifest at Path, and reports
+// the nodes which changed, were removed or were added since, like Diff
+// does, the old hashes being those of the manifest. Run fails with
+// ErrDrift if any did.
+type Verify struct {
+	Reporter
+
+	// Input
+	Path string
+	// PublicKey checks the signature of the manifest, if set, which must
+	// then be signed.
+	PublicKey ed25519.PublicKey
+	// Optionally write the entries, as text unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Entries []DiffEntry
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Verify) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W != nil {
+		if v.Format == "" {
+			for _, e := range v.Entries {
+				fmt.Fprintf(v.W, "%-8s %s\n", e.Kind, e.Path)
+			}
+		} else {
+			b, err := marshalReport(v.Format, v.Entries)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(v.W, string(b))
+		}
+	}
+	if len(v.Entries) != 0 {
+		return fmt.Errorf("%w: %d nodes differ", ErrDrift, len(v.Entries))
+	}
+	return nil
+}
+
+// Visit compares f with the manifest.
+func (v *Verify) Visit(f uefi.Firmware) error {
+	b, err := os.ReadFile(v.Path)
+	if err != nil {
+		return err
+	}
+	var m AttestManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("reading manifest %s: %w", v.Path, err)
+	}
+	if v.PublicKey != nil {
+		signed, err := m.signedBytes()
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(v.PublicKey, signed, m.Signature) {
+			return fmt.Errorf("%w: %s", ErrBadSignature, v.Path)
+		}
+	}
+
+	want := map[string]AttestNode{}
+	for _, n := range m.Nodes {
+		want[n.Path] = n
+	}
+	v.Entries = []DiffEntry{}
+	got := attestNodes(f)
+	seen := map[string]bool{}
+	for _, n := range got {
+		seen[n.Path] = true
+		w, ok := want[n.Path]
+		switch {
+		case !ok:
+			v.Entries = append(v.Entries, DiffEntry{Kind: DiffAdded, Path: n.Path, NewSHA256: n.SHA256})
+		case w.SHA256 != n.SHA256:
+			v.Entries = append(v.Entries, DiffEntry{Kind: DiffChanged, Path: n.Path, OldSHA256: w.SHA256, NewSHA256: n.SHA256})
+		}
+	}
+	for _, n := range m.Nodes {
+		if !seen[n.Path] {
+			v.Entries = append(v.Entries, DiffEntry{Kind: DiffRemoved, Path: n.Path, OldSHA256: n.SHA256})
+		}
+	}
+	return nil
+}
+
+// readPEM returns the DER of the PEM block of the type in the file.
+func readPEM(path, typ string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != typ {
+		return nil, fmt.Errorf("%s: no PEM block of type %q", path, typ)
+	}
+	return block.Bytes, nil
+}
+
+// ReadEd25519PrivateKey reads a PKCS #8 Ed25519 private key from a PEM
+// file, like those of openssl genpkey -algorithm ed25519.
+func ReadEd25519PrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: a %T, want an Ed25519 key", path, k)
+	}
+	return key, nil
+}
+
+// ReadEd25519PublicKey reads a PKIX Ed25519 public key from a PEM file,
+// like those of openssl pkey -pubout.
+func ReadEd25519PublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	k, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: a %T, want an Ed25519 key", path, k)
+	}
+	return key, nil
+}
+
+func init() {
+	RegisterCLI("attest", "attest FILE\n write the SHA256 of every node to the manifest FILE", 1, func(args []string) (uefi.Visitor, error) {
+		return &Attest{Path: args[0]}, nil
+	})
+	RegisterCLI("attest_signed", "attest_signed FILE KEY\n like attest, signing the manifest with the Ed25519 private key in the PEM file KEY", 2, func(args []string) (uefi.Visitor, error) {
+		key, err := ReadEd25519PrivateKey(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Attest{Path: args[0], Key: key}, nil
+	})
+	RegisterCLI("verify", "verify FILE\n list the nodes which differ from the manifest FILE of attest, and fail if any", 1, func(args []string) (uefi.Visitor, error) {
+		return &Verify{Path: args[0], W: os.Stdout}, nil
+	})
+	RegisterCLI("verify_signed", "verify_signed FILE KEY\n like verify, checking the signature of the manifest with the Ed25519 public key in the PEM file KEY first", 2, func(args []string) (uefi.Visitor, error) {
+		key, err := ReadEd25519PublicKey(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Verify{Path: args[0], PublicKey: key, W: os.Stdout}, nil
+	})
+}
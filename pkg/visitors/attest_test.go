@@ -0,0 +1,125 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	f := parseImage(t)
+	attest := &Attest{Path: path}
+	if err := attest.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if n := attest.Manifest.Nodes; len(n) < 100 || n[0].Type != "BIOSRegion" {
+		t.Fatalf("got %d nodes starting with %v, want every node of OVMF from the region", len(n), n[0])
+	}
+
+	var out bytes.Buffer
+	verify := &Verify{Path: path, W: &out}
+	if err := verify.Run(parseImage(t)); err != nil {
+		t.Fatalf("verifying the same image: %v\n%s", err, out.String())
+	}
+
+	remove := &Remove{Predicate: FindFileGUIDPredicate(*testGUID)}
+	if err := remove.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	verify = &Verify{Path: path, W: &out}
+	if err := verify.Run(f); !errors.Is(err, ErrDrift) {
+		t.Fatalf("got %v, want ErrDrift", err)
+	}
+	var removed, changed int
+	for _, e := range verify.Entries {
+		switch e.Kind {
+		case DiffRemoved:
+			removed++
+			if !strings.Contains(e.Path, "File("+testGUID.String()) {
+				t.Errorf("removed %q, want the removed file or its sections", e.Path)
+			}
+		case DiffChanged:
+			changed++
+		}
+	}
+	if removed == 0 || changed == 0 {
+		t.Errorf("got %d removed and %d changed nodes, want the file and its ancestors:\n%s", removed, changed, out.String())
+	}
+}
+
+func writeKeys(t *testing.T, dir string) (string, string) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPath, pubPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "pub.pem")
+	for _, k := range []struct {
+		path, typ string
+		der       []byte
+	}{{privPath, "PRIVATE KEY", privDER}, {pubPath, "PUBLIC KEY", pubDER}} {
+		if err := os.WriteFile(k.path, pem.EncodeToMemory(&pem.Block{Type: k.typ, Bytes: k.der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return privPath, pubPath
+}
+
+func TestAttestSigned(t *testing.T) {
+	dir := t.TempDir()
+	priv, pub := writeKeys(t, dir)
+	path := filepath.Join(dir, "manifest.json")
+	f := parseImage(t)
+	v, err := ParseCLI([]string{"attest_signed", path, priv, "verify_signed", path, pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v[1].(*Verify).W = nil
+	if err := ExecuteCLI(f, v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing a hash breaks the signature.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := v[0].(*Attest).Manifest.Nodes[1].SHA256
+	b = bytes.Replace(b, []byte(sum), []byte(strings.Repeat("0", len(sum))), 1)
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	verify := &Verify{Path: path}
+	if err := verify.Run(f); !errors.Is(err, ErrDrift) || len(verify.Entries) != 1 {
+		t.Errorf("verifying without a key returned %v, %v, want the changed node only", err, verify.Entries)
+	}
+	if err := ExecuteCLI(f, v[1:]); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("got %v, want ErrBadSignature", err)
+	}
+
+	if _, err := ParseCLI([]string{"attest_signed", path, pub}); err == nil {
+		t.Errorf("signing with a public key succeeded, want an error")
+	}
+}
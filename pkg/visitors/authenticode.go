@@ -0,0 +1,117 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// AuthenticodeEntry is the Authenticode digests of one PE32 or TE section.
+type AuthenticodeEntry struct {
+	GUID   guid.GUID
+	Name   string `json:",omitempty"`
+	Type   string
+	SHA1   string
+	SHA256 string
+}
+
+// Authenticode computes the Authenticode digests of every PE32 and TE
+// section, to be checked against dbx and lists of known bad hashes.
+type Authenticode struct {
+	Cancelable
+	Reporter
+
+	// Optionally write the manifest as JSON.
+	W io.Writer `json:"-"`
+
+	// Output
+	Manifest []AuthenticodeEntry
+
+	file *uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Authenticode) Run(f uefi.Firmware) error {
+	v.Manifest = []AuthenticodeEntry{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		b, err := marshalReport(v.Format, v.Manifest)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	return nil
+}
+
+// Visit applies the Authenticode visitor to any Firmware type.
+func (v *Authenticode) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		// Sections of nested volumes belong to their own files.
+		parent := v.file
+		v.file = f
+		err := f.ApplyChildren(v)
+		v.file = parent
+		return err
+
+	case *uefi.Section:
+		if f.Header.Type != uefi.SectionTypePE32 && f.Header.Type != uefi.SectionTypeTE {
+			return f.ApplyChildren(v)
+		}
+		img := f.Buf()[f.HeaderLen():]
+		e := AuthenticodeEntry{Type: f.Type}
+		if v.file != nil {
+			e.GUID = v.file.Header.GUID
+			e.Name = fileName(v.file)
+		}
+		sha1Sum, err := uefi.AuthenticodeDigest(img, sha1.New())
+		if err != nil {
+			log.FromContext(v.Context()).Warnf("Authenticode digest of %v section in %v: %v", e.Type, e.GUID, err)
+			return nil
+		}
+		sha256Sum, err := uefi.AuthenticodeDigest(img, sha256.New())
+		if err != nil {
+			return err
+		}
+		e.SHA1 = hex.EncodeToString(sha1Sum)
+		e.SHA256 = hex.EncodeToString(sha256Sum)
+		v.Manifest = append(v.Manifest, e)
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+// fileName returns the name in the user interface section of the file, if
+// any.
+func fileName(f *uefi.File) string {
+	for _, s := range f.Sections {
+		if s.Header.Type == uefi.SectionTypeUserInterface {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+func init() {
+	RegisterCLI("authenticode", "print the Authenticode digests of PE32 and TE sections as JSON", 0, func(args []string) (uefi.Visitor, error) {
+		return &Authenticode{
+			W: os.Stdout,
+		}, nil
+	})
+}
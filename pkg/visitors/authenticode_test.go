@@ -0,0 +1,44 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestAuthenticode(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	a := &Authenticode{W: &b}
+	if err := a.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Manifest) == 0 {
+		t.Fatal("no PE32 or TE section found")
+	}
+	var dxeCore *AuthenticodeEntry
+	for i, e := range a.Manifest {
+		if len(e.SHA1) != 40 || len(e.SHA256) != 64 {
+			t.Errorf("%v: bad digests %q, %q", e.GUID, e.SHA1, e.SHA256)
+		}
+		if e.GUID == *dxeCoreGUID {
+			dxeCore = &a.Manifest[i]
+		}
+	}
+	if dxeCore == nil || dxeCore.Name != "DxeCore" || dxeCore.Type != "EFI_SECTION_PE32" {
+		t.Errorf("DxeCore: got %+v", dxeCore)
+	}
+
+	var manifest []AuthenticodeEntry
+	if err := json.Unmarshal(b.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != len(a.Manifest) {
+		t.Errorf("JSON manifest: got %d entries, want %d", len(manifest), len(a.Manifest))
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Body writes the body of a node, its bytes without its header, so a
+// single binary like a PE32 can be piped into other tools:
+//
+//	utk image.rom body '//File[name=Shell]/Section[type=PE32]' - | objdump ...
+type Body struct {
+	// Input
+	// Query selects the node, which may be of any type. If it is nil,
+	// Predicate selects a file, like for Find.
+	Query     *Query
+	Predicate FindPredicate
+	// Decompress writes the sections encapsulation sections hold, decoded,
+	// rather than their encoded body. It has no effect on other nodes.
+	Decompress bool
+
+	// Output
+	// The body is written to this writer.
+	W io.Writer
+	// Or to the file at this path, if W is nil.
+	Path string
+
+	data []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Body) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return os.WriteFile(v.Path, v.data, 0666)
+	}
+	_, err := v.W.Write(v.data)
+	return err
+}
+
+// Visit selects the node and takes its body.
+func (v *Body) Visit(f uefi.Firmware) error {
+	var matches []uefi.Firmware
+	if v.Query != nil {
+		matches = v.Query.Select(f)
+	} else {
+		find := Find{Predicate: v.Predicate}
+		if err := find.Run(f); err != nil {
+			return err
+		}
+		matches = find.Matches
+	}
+	// There must only be one match.
+	if len(matches) > 1 {
+		return fmt.Errorf("more than one match, only one match allowed! got %d", len(matches))
+	} else if len(matches) == 0 {
+		return errors.New("no matches found")
+	}
+	v.data = v.body(matches[0])
+	return nil
+}
+
+func (v *Body) body(f uefi.Firmware) []byte {
+	switch f := f.(type) {
+	case *uefi.File:
+		return f.Buf()[f.DataOffset:]
+	case *uefi.Section:
+		if v.Decompress && len(f.Encapsulated) != 0 {
+			// Like Assemble, before encoding.
+			var data []byte
+			for _, es := range f.Encapsulated {
+				for len(data)%4 != 0 {
+					data = append(data, 0)
+				}
+				data = append(data, es.Value.Buf()...)
+			}
+			return data
+		}
+		if f.Header.Type == uefi.SectionTypeGUIDDefined && f.TypeSpecific != nil {
+			if h, ok := f.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+				return f.Buf()[h.DataOffset:]
+			}
+		}
+		return f.Buf()[f.HeaderLen():]
+	}
+	return f.Buf()
+}
+
+func newBodyCLI(args []string, decompress bool) (uefi.Visitor, error) {
+	v := &Body{Decompress: decompress, Path: args[1]}
+	if args[1] == "-" {
+		v.W = os.Stdout
+	}
+	if strings.HasPrefix(args[0], "/") {
+		q, err := ParseQuery(args[0])
+		if err != nil {
+			return nil, err
+		}
+		v.Query = q
+		return v, nil
+	}
+	pred, err := FindFilePredicate(args[0])
+	if err != nil {
+		return nil, err
+	}
+	v.Predicate = pred
+	return v, nil
+}
+
+func init() {
+	RegisterCLI("body", "write the body of the file matching a regexp, or of the node matching a query, to a file or - for stdout", 2, func(args []string) (uefi.Visitor, error) {
+		return newBodyCLI(args, false)
+	})
+	RegisterCLI("body_decompressed", "like body, decoding the sections compressed sections hold", 2, func(args []string) (uefi.Visitor, error) {
+		return newBodyCLI(args, true)
+	})
+}
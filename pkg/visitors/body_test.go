@@ -0,0 +1,79 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func runBody(t *testing.T, f uefi.Firmware, pattern string, decompress bool) []byte {
+	v, err := newBodyCLI([]string{pattern, "-"}, decompress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	v.(*Body).W = &b
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestBody(t *testing.T) {
+	f := parseImage(t)
+
+	pe := runBody(t, f, "//File[name=Shell]/Section[type=PE32]", false)
+	if !bytes.HasPrefix(pe, []byte("MZ")) {
+		t.Errorf("the body of the PE32 section does not start with MZ")
+	}
+
+	file := find(t, f, dxeCoreGUID)[0].(*uefi.File)
+	if body := runBody(t, f, dxeCoreGUID.String(), false); !bytes.Equal(body, file.Buf()[uefi.FileHeaderMinLength:]) {
+		t.Errorf("the body of the file is not its sections")
+	}
+
+	q, err := ParseQuery("/BIOS/FV/File/Section[type=GUID_DEFINED]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := q.Select(f)[0].(*uefi.Section)
+	encoded := runBody(t, f, "/BIOS/FV/File/Section[type=GUID_DEFINED]", false)
+	decoded := runBody(t, f, "/BIOS/FV/File/Section[type=GUID_DEFINED]", true)
+	if len(decoded) <= len(encoded) {
+		t.Errorf("got %d decompressed bytes from %d, want more", len(decoded), len(encoded))
+	}
+	first := compressed.Encapsulated[0].Value.Buf()
+	if !bytes.HasPrefix(decoded, first) {
+		t.Errorf("the decompressed body does not start with the first encapsulated section")
+	}
+}
+
+func TestBodyErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "body-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	f := parseImage(t)
+	for _, pattern := range []string{"NoSuchFile", "//File"} {
+		out := filepath.Join(tmpDir, "out.bin")
+		v, err := newBodyCLI([]string{pattern, out}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Run(f); err == nil {
+			t.Errorf("%s: got no error, want one for none or many matches", pattern)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("%s: the output file was written despite the error", pattern)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	pkgbytes "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg/bgbootpolicy"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg/bgkey"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt/cbntbootpolicy"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt/cbntkey"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit/consts"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ErrBootGuard is the error of BootGuardAudit for images failing some of
+// its checks.
+var ErrBootGuard = errors.New("Boot Guard provisioning issues")
+
+// Boot Guard profiles, from the strictest.
+const (
+	BootGuardProfileVerifiedMeasured = "verified and measured"
+	BootGuardProfileVerified         = "verified"
+	BootGuardProfileMeasured         = "measured"
+	BootGuardProfileNone             = "none"
+)
+
+// BootGuardCheck is a check of the Boot Guard provisioning of the image.
+type BootGuardCheck struct {
+	Check  string
+	OK     bool
+	Detail string `json:",omitempty"`
+}
+
+// BootGuardFPF is what the field programmable fuses of the platform must
+// hold for the image to boot with its Boot Guard profile.
+type BootGuardFPF struct {
+	// KMPubKeyHash is the digest of the public key signing the key
+	// manifest, as hex.
+	KMPubKeyHash    string `json:",omitempty"`
+	KMPubKeyHashAlg string `json:",omitempty"`
+	KMID            uint8
+	Profile         string
+}
+
+// BootGuardIBBSegment is a segment of the initial boot block.
+type BootGuardIBBSegment struct {
+	Offset uint64
+	Size   uint64
+	// Hashed is false for the segments left out of the IBB digest.
+	Hashed bool
+}
+
+// BootGuardAudit combines the FIT, the key and boot policy manifests, the
+// startup ACM and the flash descriptor of the image to report the Boot
+// Guard profile it boots with and the fuses it expects. It flags key
+// hashes, signatures and digests which do not match and the parts of the
+// boot which the IBB does not protect. It must be applied to the root,
+// which is mapped below 4GiB.
+type BootGuardAudit struct {
+	Reporter
+
+	// Optionally write the report, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	// Version is "1.0" for Boot Guard, "2.0" for CBnT, empty without
+	// manifests.
+	Version string `json:",omitempty"`
+	// Profile is the strictest profile the image boots with.
+	Profile    string
+	FPF        *BootGuardFPF `json:",omitempty"`
+	ACM        *TXTACM       `json:",omitempty"`
+	KMSVN      uint8
+	BPMSVN     uint8
+	ACMSVNAuth uint8
+	IBB        []BootGuardIBBSegment
+	Checks     []BootGuardCheck
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *BootGuardAudit) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W != nil {
+		if err := v.write(); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	for _, c := range v.Checks {
+		if !c.OK {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%w: %d checks failed", ErrBootGuard, failed)
+	}
+	return nil
+}
+
+// write writes the report to W.
+func (v *BootGuardAudit) write() error {
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	if v.Version == "" {
+		_, err := fmt.Fprintf(v.W, "Boot Guard profile: %s, no key and boot policy manifests\n", v.Profile)
+		return err
+	}
+	fmt.Fprintf(v.W, "Boot Guard %s profile: %s\n", v.Version, v.Profile)
+	if v.FPF != nil {
+		fmt.Fprintf(v.W, "FPF: KM public key %s hash %s, KM ID %#x\n", v.FPF.KMPubKeyHashAlg, v.FPF.KMPubKeyHash, v.FPF.KMID)
+	}
+	fmt.Fprintf(v.W, "KM SVN %d, BPM SVN %d, ACM SVN auth %d\n", v.KMSVN, v.BPMSVN, v.ACMSVNAuth)
+	for _, s := range v.IBB {
+		fmt.Fprintf(v.W, "IBB segment [%#x, %#x) hashed %v\n", s.Offset, s.Offset+s.Size, s.Hashed)
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Check\tResult\tDetail")
+	for _, c := range v.Checks {
+		result := "ok"
+		if !c.OK {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Check, result, c.Detail)
+	}
+	return w.Flush()
+}
+
+// check records the result of a check, failed if any of problems is set.
+func (v *BootGuardAudit) check(name string, problems ...string) {
+	var details []string
+	for _, p := range problems {
+		if p != "" {
+			details = append(details, p)
+		}
+	}
+	v.Checks = append(v.Checks, BootGuardCheck{Check: name, OK: len(details) == 0, Detail: strings.Join(details, "; ")})
+}
+
+// errString returns the message of err, "" for nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// bootGuardManifests is what the audit reads of the key and boot policy
+// manifests, of either version.
+type bootGuardManifests struct {
+	version                         string
+	kmID, kmSVN, bpmSVN, acmSVNAuth uint8
+	kmPubKeyHash                    []byte
+	kmPubKeyHashAlg                 string
+	kmSignature, bpmKey, bpmSig     error
+	segments                        []BootGuardIBBSegment
+	entryPoint                      uint32
+	authorityMeasure                bool
+	// ibbDigest checks the digest of the IBB, once its segments are known
+	// to be in the image.
+	ibbDigest func() error
+}
+
+// kmPubKeyHash returns the digest of the RSA public key data, the modulus
+// followed by the exponent, the fuses hold.
+func kmPubKeyHash(h hash.Hash, data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("no public key in the key manifest")
+	}
+	h.Write(data[4:])
+	h.Write(data[:4])
+	return h.Sum(nil), nil
+}
+
+// bgManifests returns what the audit reads of Boot Guard 1.0 manifests,
+// km and bpm being their bytes.
+func bgManifests(f uefi.Firmware, kmm *bgkey.Manifest, km []byte, bpmm *bgbootpolicy.Manifest, bpm []byte) (*bootGuardManifests, error) {
+	if len(bpmm.SE) == 0 {
+		return nil, fmt.Errorf("no IBB segments element in the boot policy manifest")
+	}
+	if kmm.KeyAndSignatureOffset() > uint64(len(km)) || bpmm.PMSEOffset()+bpmm.PMSE.KeySignatureOffset() > uint64(len(bpm)) {
+		return nil, fmt.Errorf("signature offsets out of the manifests")
+	}
+	m := &bootGuardManifests{
+		version:         "1.0",
+		kmID:            kmm.KMID,
+		kmSVN:           kmm.KMSVN.SVN(),
+		bpmSVN:          bpmm.BPMSVN.SVN(),
+		acmSVNAuth:      bpmm.ACMSVNAuth.SVN(),
+		kmPubKeyHashAlg: bg.AlgSHA256.String(),
+		bpmKey:          kmm.ValidateBPMKey(bpmm.PMSE.KeySignature),
+		entryPoint:      bpmm.SE[0].IBBEntryPoint,
+		ibbDigest:       func() error { return bpmm.ValidateIBB(f) },
+	}
+	if kmm.KeyAndSignature.Key.KeyAlg != bg.AlgRSA {
+		m.kmSignature = fmt.Errorf("unsupported key algorithm: %v", kmm.KeyAndSignature.Key.KeyAlg)
+	} else {
+		m.kmPubKeyHash, m.kmSignature = kmPubKeyHash(sha256.New(), kmm.KeyAndSignature.Key.Data)
+	}
+	if m.kmSignature == nil {
+		m.kmSignature = kmm.KeyAndSignature.Verify(km[:kmm.KeyAndSignatureOffset()])
+	}
+	m.bpmSig = bpmm.PMSE.KeySignature.Verify(bpm[:bpmm.PMSEOffset()+bpmm.PMSE.KeySignatureOffset()])
+	m.authorityMeasure = bpmm.SE[0].Flags.AuthorityMeasure()
+	for _, s := range bpmm.SE[0].IBBSegments {
+		m.segments = append(m.segments, BootGuardIBBSegment{Offset: uint64(s.Base), Size: uint64(s.Size), Hashed: s.Flags&1 == 0})
+	}
+	return m, nil
+}
+
+// cbntManifests returns what the audit reads of CBnT manifests, km and bpm
+// being their bytes.
+func cbntManifests(f uefi.Firmware, kmm *cbntkey.Manifest, km []byte, bpmm *cbntbootpolicy.Manifest, bpm []byte) (*bootGuardManifests, error) {
+	if len(bpmm.SE) == 0 {
+		return nil, fmt.Errorf("no IBB segments element in the boot policy manifest")
+	}
+	if int(kmm.KeyManifestSignatureOffset) > len(km) || int(bpmm.KeySignatureOffset) > len(bpm) {
+		return nil, fmt.Errorf("signature offsets out of the manifests")
+	}
+	alg := kmm.PubKeyHashAlg
+	if alg.IsNull() {
+		alg = cbnt.AlgSHA256
+	}
+	m := &bootGuardManifests{
+		version:         "2.0",
+		kmID:            kmm.KMID,
+		kmSVN:           kmm.KMSVN.SVN(),
+		bpmSVN:          bpmm.BPMSVN.SVN(),
+		acmSVNAuth:      bpmm.ACMSVNAuth.SVN(),
+		kmPubKeyHashAlg: alg.String(),
+		bpmKey:          kmm.ValidateBPMKey(bpmm.PMSE.KeySignature),
+		entryPoint:      bpmm.SE[0].IBBEntryPoint,
+		ibbDigest:       func() error { return bpmm.ValidateIBB(f) },
+	}
+	h, err := alg.Hash()
+	switch {
+	case err != nil:
+		m.kmSignature = fmt.Errorf("invalid KM public key hash algorithm %v: %w", alg, err)
+	case kmm.KeyAndSignature.Key.KeyAlg == cbnt.AlgRSA:
+		m.kmPubKeyHash, m.kmSignature = kmPubKeyHash(h, kmm.KeyAndSignature.Key.Data)
+	case kmm.KeyAndSignature.Key.KeyAlg == cbnt.AlgECC:
+		// The coordinates of the point.
+		h.Write(kmm.KeyAndSignature.Key.Data)
+		m.kmPubKeyHash = h.Sum(nil)
+	default:
+		m.kmSignature = fmt.Errorf("unsupported key algorithm: %v", kmm.KeyAndSignature.Key.KeyAlg)
+	}
+	if m.kmSignature == nil {
+		m.kmSignature = kmm.KeyAndSignature.Verify(km[:kmm.KeyManifestSignatureOffset])
+	}
+	m.bpmSig = bpmm.PMSE.KeySignature.Verify(bpm[:bpmm.KeySignatureOffset])
+	m.authorityMeasure = bpmm.SE[0].Flags.AuthorityMeasure()
+	for _, s := range bpmm.SE[0].IBBSegments {
+		m.segments = append(m.segments, BootGuardIBBSegment{Offset: uint64(s.Base), Size: uint64(s.Size), Hashed: s.Flags&1 == 0})
+	}
+	return m, nil
+}
+
+// manifestBytes returns the bytes of the first FIT entry of type t and its
+// offset in the image.
+func manifestBytes(table fit.Table, buf []byte, t fit.EntryType) ([]byte, uint64, error) {
+	hdr := table.First(t)
+	if hdr == nil {
+		return nil, 0, fit.ErrNotFound{}
+	}
+	addr := hdr.Address.Pointer()
+	if addr < consts.BasePhysAddr-uint64(len(buf)) || addr >= consts.BasePhysAddr {
+		return nil, 0, fmt.Errorf("%v at %#x is outside of the image", t, addr)
+	}
+	offset := fit.CalculateOffsetFromPhysAddr(addr, uint64(len(buf)))
+	size := uint64(hdr.Size.Uint32())
+	if size == 0 || offset+size > uint64(len(buf)) {
+		return nil, 0, fmt.Errorf("%v at %#x of %#x bytes does not fit in the image", t, addr, size)
+	}
+	return buf[offset : offset+size], offset, nil
+}
+
+// biosRegion returns the range of the BIOS region of the descriptor of f,
+// the whole image without descriptor.
+func biosRegion(f uefi.Firmware) pkgbytes.Range {
+	r := pkgbytes.Range{Length: uint64(len(f.Buf()))}
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return r
+	}
+	for _, t := range fi.Regions {
+		if br, ok := t.Value.(*uefi.BIOSRegion); ok && br.FRegion != nil {
+			r.Offset = uint64(br.FRegion.BaseOffset())
+			r.Length = uint64(br.FRegion.EndOffset()) - r.Offset
+		}
+	}
+	return r
+}
+
+// rangeIn returns true if r is entirely in the ranges of rs, which are
+// sorted and merged.
+func rangeIn(rs pkgbytes.Ranges, r pkgbytes.Range) bool {
+	for _, c := range rs {
+		if c.Offset <= r.Offset && r.End() <= c.End() {
+			return true
+		}
+	}
+	return false
+}
+
+// Visit audits the Boot Guard provisioning of the image f.
+func (v *BootGuardAudit) Visit(f uefi.Firmware) error {
+	buf := f.Buf()
+	size := uint64(len(buf))
+	*v = BootGuardAudit{Reporter: v.Reporter, W: v.W, Profile: BootGuardProfileNone, IBB: []BootGuardIBBSegment{}, Checks: []BootGuardCheck{}}
+
+	var table fit.Table
+	if size >= consts.FITPointerOffset {
+		table, _ = fit.GetTable(buf)
+	}
+	if table.First(fit.EntryTypeKeyManifestRecord) == nil && table.First(fit.EntryTypeBootPolicyManifest) == nil {
+		return nil
+	}
+	bios := biosRegion(f)
+	var outside []string
+	inBIOS := func(name string, offset, length uint64) {
+		if r := (pkgbytes.Range{Offset: offset, Length: length}); !rangeIn(pkgbytes.Ranges{bios}, r) {
+			outside = append(outside, fmt.Sprintf("%s [%#x, %#x) is outside of the BIOS region [%#x, %#x)", name, r.Offset, r.End(), bios.Offset, bios.End()))
+		}
+	}
+
+	if hdr := table.First(fit.EntryTypeStartupACModuleEntry); hdr == nil {
+		v.check("startup ACM", "no startup ACM in the FIT")
+	} else if addr := hdr.Address.Pointer(); addr < consts.BasePhysAddr-size || addr >= consts.BasePhysAddr {
+		v.check("startup ACM", fmt.Sprintf("startup ACM at %#x is outside of the image", addr))
+	} else {
+		offset := fit.CalculateOffsetFromPhysAddr(addr, size)
+		acm := parseTXTACM(buf[offset:])
+		acm.Offset, acm.FIT = offset, true
+		v.ACM = &acm
+		switch {
+		case acm.Error != "":
+			v.check("startup ACM", acm.Error)
+		case acm.Type != fit.ChipsetACMTypeBIOS.String():
+			v.check("startup ACM", fmt.Sprintf("the ACM is a %s ACM, not a BIOS ACM", acm.Type))
+		default:
+			v.check("startup ACM")
+			inBIOS("startup ACM", acm.Offset, acm.Size)
+		}
+	}
+
+	km, kmOffset, err := manifestBytes(table, buf, fit.EntryTypeKeyManifestRecord)
+	if err != nil {
+		v.check("key manifest", fmt.Sprintf("key manifest: %v", err))
+		return nil
+	}
+	bpm, bpmOffset, err := manifestBytes(table, buf, fit.EntryTypeBootPolicyManifest)
+	if err != nil {
+		v.check("boot policy manifest", fmt.Sprintf("boot policy manifest: %v", err))
+		return nil
+	}
+	inBIOS("key manifest", kmOffset, uint64(len(km)))
+	inBIOS("boot policy manifest", bpmOffset, uint64(len(bpm)))
+	bgKM, cbntKM, err := table.ParseKeyManifest(buf)
+	if err != nil {
+		v.check("key manifest", fmt.Sprintf("unable to parse the key manifest: %v", err))
+		return nil
+	}
+	bgBPM, cbntBPM, err := table.ParseBootPolicyManifest(buf)
+	if err != nil {
+		v.check("boot policy manifest", fmt.Sprintf("unable to parse the boot policy manifest: %v", err))
+		return nil
+	}
+	var m *bootGuardManifests
+	switch {
+	case bgKM != nil && bgBPM != nil:
+		m, err = bgManifests(f, bgKM, km, bgBPM, bpm)
+	case cbntKM != nil && cbntBPM != nil:
+		m, err = cbntManifests(f, cbntKM, km, cbntBPM, bpm)
+	default:
+		err = errors.New("key and boot policy manifests of different Boot Guard versions")
+	}
+	if err != nil {
+		v.check("boot policy manifest", err.Error())
+		return nil
+	}
+
+	v.Version = m.version
+	v.KMSVN, v.BPMSVN, v.ACMSVNAuth = m.kmSVN, m.bpmSVN, m.acmSVNAuth
+	if v.ACM != nil && v.ACM.Error == "" {
+		if v.ACM.TXTSVN < uint16(m.acmSVNAuth) {
+			v.check("ACM SVN", fmt.Sprintf("ACM SVN %d is below the SVN %d the boot policy manifest authorizes", v.ACM.TXTSVN, m.acmSVNAuth))
+		} else {
+			v.check("ACM SVN")
+		}
+	}
+	v.check("KM signature", errString(m.kmSignature))
+	v.check("BPM key hash", errString(m.bpmKey))
+	v.check("BPM signature", errString(m.bpmSig))
+
+	// The IBB must hold the code the CPU starts and be in the BIOS region,
+	// which the descriptor keeps the other masters from writing.
+	var hashed pkgbytes.Ranges
+	var problems []string
+	for _, s := range m.segments {
+		if uint64(s.Offset) < consts.BasePhysAddr-size || uint64(s.Offset)+s.Size > consts.BasePhysAddr {
+			problems = append(problems, fmt.Sprintf("segment at %#x of %#x bytes is outside of the image", s.Offset, s.Size))
+			continue
+		}
+		s.Offset = fit.CalculateOffsetFromPhysAddr(s.Offset, size)
+		v.IBB = append(v.IBB, s)
+		if !s.Hashed {
+			problems = append(problems, fmt.Sprintf("segment [%#x, %#x) is not hashed", s.Offset, s.Offset+s.Size))
+			continue
+		}
+		inBIOS("IBB segment", s.Offset, s.Size)
+		hashed = append(hashed, pkgbytes.Range{Offset: s.Offset, Length: s.Size})
+	}
+	var ibbDigest error
+	switch {
+	case len(v.IBB) != len(m.segments):
+		ibbDigest = errors.New("the IBB is not in the image")
+	case len(hashed) == 0:
+		ibbDigest = errors.New("no hashed IBB segment")
+	default:
+		ibbDigest = m.ibbDigest()
+	}
+	v.check("IBB digest", errString(ibbDigest))
+	v.check("BIOS region", outside...)
+
+	hashed.SortAndMerge()
+	for _, p := range []struct {
+		name   string
+		offset uint64
+		length uint64
+	}{
+		{"reset vector", size - 0x10, 0x10},
+		{"FIT pointer", size - consts.FITPointerOffset, 8},
+		{"IBB entry point", fit.CalculateOffsetFromPhysAddr(uint64(m.entryPoint), size), 1},
+	} {
+		if !rangeIn(hashed, pkgbytes.Range{Offset: p.offset, Length: p.length}) {
+			problems = append(problems, fmt.Sprintf("the %s at %#x is not in the IBB", p.name, p.offset))
+		}
+	}
+	offsets, err := nodeOffsets(f)
+	if err != nil {
+		return err
+	}
+	var partial []string
+	for n, o := range offsets {
+		fv, ok := n.(*uefi.FirmwareVolume)
+		if !ok || o+uint64(len(fv.Buf())) > size || !bytes.Equal(fv.Buf(), buf[o:o+uint64(len(fv.Buf()))]) {
+			continue
+		}
+		r := pkgbytes.Range{Offset: o, Length: uint64(len(fv.Buf()))}
+		if rangeIn(hashed, r) {
+			continue
+		}
+		for _, h := range hashed {
+			if h.Intersect(r) {
+				partial = append(partial, fmt.Sprintf("volume %v [%#x, %#x) is only partly in the IBB", fv.FVName, r.Offset, r.End()))
+				break
+			}
+		}
+	}
+	sort.Strings(partial)
+	v.check("IBB coverage", append(problems, partial...)...)
+
+	v.Profile = BootGuardProfileMeasured
+	if m.kmSignature == nil && m.bpmKey == nil && m.bpmSig == nil && ibbDigest == nil {
+		v.Profile = BootGuardProfileVerified
+		if m.authorityMeasure {
+			v.Profile = BootGuardProfileVerifiedMeasured
+		}
+	}
+	v.FPF = &BootGuardFPF{KMID: m.kmID, KMPubKeyHashAlg: m.kmPubKeyHashAlg, Profile: v.Profile}
+	if m.kmPubKeyHash != nil {
+		v.FPF.KMPubKeyHash = fmt.Sprintf("%x", m.kmPubKeyHash)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCLI("bootguard_audit", "report the Boot Guard profile of the image and the fuses it expects, and flag mismatched key hashes, signatures and digests and unprotected IBB ranges", 0, func(args []string) (uefi.Visitor, error) {
+		return &BootGuardAudit{W: os.Stdout}, nil
+	})
+}
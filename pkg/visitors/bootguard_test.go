@@ -0,0 +1,284 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg/bgbootpolicy"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg/bgkey"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// bootGuardKeys are the keys signing the manifests of bootGuardImage.
+type bootGuardKeys struct {
+	km, bpm, other *rsa.PrivateKey
+}
+
+func newBootGuardKeys(t *testing.T) *bootGuardKeys {
+	var keys [3]*rsa.PrivateKey
+	for i := range keys {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+	}
+	return &bootGuardKeys{km: keys[0], bpm: keys[1], other: keys[2]}
+}
+
+// bootGuardImage returns ifdImage with the BIOS ACM of txtImage at 0x3c000
+// and Boot Guard 1.0 manifests: a key manifest at 0x3d000 trusting bpmKey
+// and a boot policy manifest at 0x3d800 signed by keys.bpm, whose IBB is
+// segments, all in the FIT at 0x3f000.
+func bootGuardImage(t *testing.T, keys *bootGuardKeys, bpmKey *rsa.PrivateKey, segments []bgbootpolicy.IBBSegment) []byte {
+	buf := ifdImage(t)
+	copy(buf[0x3c000:], testACM(0x400, fit.ChipsetACMTypeBIOS, 1))
+
+	bpm := bgbootpolicy.NewManifest()
+	bpm.ACMSVNAuth = 2
+	se := bgbootpolicy.NewSE()
+	se.Flags = 0x04
+	se.IBBEntryPoint = 0xFFFFFFF0
+	// The post IBB hash is read with the size of its digest.
+	se.PostIBBHash.HashBuffer = make([]byte, 2+sha256.Size)
+	se.Digest = bg.HashStructure{HashAlg: bg.AlgSHA256, HashBuffer: make([]byte, sha256.Size)}
+	se.IBBSegments = segments
+	bpm.SE = []bgbootpolicy.SE{*se}
+	if err := bpm.PMSE.KeySignature.SetSignature(bg.AlgRSASSA, keys.bpm, nil); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if _, err := bpm.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	bpmSize := b.Len()
+
+	// The FIT is in the IBB: hash it once complete.
+	km := bgkey.NewManifest()
+	if err := km.SetSignature(bg.AlgRSASSA, keys.km, nil); err != nil {
+		t.Fatal(err)
+	}
+	km.KMID = 1
+	km.BPKey = bg.HashStructure{HashAlg: bg.AlgSHA256, HashBuffer: make([]byte, sha256.Size)}
+	b.Reset()
+	if _, err := km.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	writeBootGuardFIT(buf, b.Len(), bpmSize)
+
+	h := sha256.New()
+	for _, r := range bpm.IBBDataRanges(uint64(len(buf))) {
+		h.Write(buf[r.Offset:r.End()])
+	}
+	bpm.SE[0].Digest.HashBuffer = h.Sum(nil)
+	b.Reset()
+	if _, err := bpm.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := bpm.PMSE.KeySignature.SetSignature(bg.AlgRSASSA, keys.bpm, b.Bytes()[:bpm.PMSEOffset()+bpm.PMSE.KeySignatureOffset()]); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if _, err := bpm.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[0x3d800:], b.Bytes())
+
+	var bpmPub bg.Key
+	if err := bpmPub.SetPubKey(bpmKey.Public()); err != nil {
+		t.Fatal(err)
+	}
+	bpmKeyHash := sha256.Sum256(bpmPub.Data[4:])
+	km.BPKey.HashBuffer = bpmKeyHash[:]
+	b.Reset()
+	if _, err := km.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.SetSignature(bg.AlgRSASSA, keys.km, b.Bytes()[:km.KeyAndSignatureOffset()]); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if _, err := km.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[0x3d000:], b.Bytes())
+	return buf
+}
+
+// writeBootGuardFIT writes the FIT of bootGuardImage at 0x3f000 of buf, for
+// the BIOS ACM at 0x3c000, a key manifest at 0x3d000 and a boot policy
+// manifest at 0x3d800.
+func writeBootGuardFIT(buf []byte, kmSize, bpmSize int) {
+	const base = 0xFFFC0000
+	table := buf[0x3f000:]
+	copy(table, make([]byte, 0x40))
+	copy(table, "_FIT_   ")
+	table[8] = 4
+	for i, e := range []struct {
+		offset uint64
+		size   int
+		t      fit.EntryType
+	}{
+		{0x3c000, 0, fit.EntryTypeStartupACModuleEntry},
+		{0x3d000, kmSize, fit.EntryTypeKeyManifestRecord},
+		{0x3d800, bpmSize, fit.EntryTypeBootPolicyManifest},
+	} {
+		h := table[0x10*(i+1):]
+		binary.LittleEndian.PutUint64(h, base+e.offset)
+		binary.LittleEndian.PutUint32(h[8:], uint32(e.size))
+		h[0xd] = 0x01
+		h[0xe] = byte(e.t)
+	}
+	binary.LittleEndian.PutUint64(buf[0x3ffc0:], base+0x3f000)
+}
+
+// ibbTop is the IBB of the last 8K of the image, with its reset vector and
+// FIT.
+var ibbTop = []bgbootpolicy.IBBSegment{{Base: 0xFFFFE000, Size: 0x2000}}
+
+func auditBootGuard(t *testing.T, buf []byte) (*BootGuardAudit, string, error) {
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &BootGuardAudit{W: &out}
+	err = v.Run(f)
+	return v, out.String(), err
+}
+
+// failedChecks returns the failed checks of v by name.
+func failedChecks(v *BootGuardAudit) map[string]string {
+	failed := map[string]string{}
+	for _, c := range v.Checks {
+		if !c.OK {
+			failed[c.Check] = c.Detail
+		}
+	}
+	return failed
+}
+
+func TestBootGuardAudit(t *testing.T) {
+	keys := newBootGuardKeys(t)
+	v, out, err := auditBootGuard(t, bootGuardImage(t, keys, keys.bpm, ibbTop))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if v.Version != "1.0" || v.Profile != BootGuardProfileVerifiedMeasured {
+		t.Errorf("got Boot Guard %q profile %q, want 1.0 %q", v.Version, v.Profile, BootGuardProfileVerifiedMeasured)
+	}
+	var kmPub bg.Key
+	if err := kmPub.SetPubKey(keys.km.Public()); err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(append(kmPub.Data[4:], kmPub.Data[:4]...))
+	if v.FPF == nil || v.FPF.KMPubKeyHash != fmt.Sprintf("%x", want) || v.FPF.KMID != 1 {
+		t.Errorf("got FPF %+v, want the KM public key hash %x and KM ID 1", v.FPF, want)
+	}
+	if v.ACM == nil || v.ACM.Offset != 0x3c000 || v.ACMSVNAuth != 2 {
+		t.Errorf("got ACM %+v and ACM SVN auth %d, want the ACM at 0x3c000 and 2", v.ACM, v.ACMSVNAuth)
+	}
+	if len(v.IBB) != 1 || v.IBB[0] != (BootGuardIBBSegment{Offset: 0x3e000, Size: 0x2000, Hashed: true}) {
+		t.Errorf("got IBB %+v, want [0x3e000, 0x40000)", v.IBB)
+	}
+	for _, s := range []string{
+		"Boot Guard 1.0 profile: verified and measured\n",
+		"KM signature   ok",
+		"IBB coverage   ok",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output lacks %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestBootGuardAuditIssues(t *testing.T) {
+	keys := newBootGuardKeys(t)
+	for _, test := range []struct {
+		name     string
+		buf      func() []byte
+		profile  string
+		failed   []string
+		contains string
+	}{{
+		name: "no Boot Guard",
+		buf:  func() []byte { return ifdImage(t) },
+		// No check fails without manifests.
+		profile: BootGuardProfileNone,
+	}, {
+		name:     "BPM key",
+		buf:      func() []byte { return bootGuardImage(t, keys, keys.other, ibbTop) },
+		profile:  BootGuardProfileMeasured,
+		failed:   []string{"BPM key hash"},
+		contains: "BPM key hash does not match",
+	}, {
+		name: "IBB changed",
+		buf: func() []byte {
+			buf := bootGuardImage(t, keys, keys.bpm, ibbTop)
+			buf[0x3e000] ^= 0xff
+			return buf
+		},
+		profile:  BootGuardProfileMeasured,
+		failed:   []string{"IBB digest"},
+		contains: "hash mismatch",
+	}, {
+		name: "IBB coverage",
+		buf: func() []byte {
+			return bootGuardImage(t, keys, keys.bpm, []bgbootpolicy.IBBSegment{
+				{Base: 0xFFFFB000, Size: 0x1000},
+				{Base: 0xFFFFE000, Size: 0x1000},
+				{Flags: 1, Base: 0xFFFFF000, Size: 0x1000},
+			})
+		},
+		profile: BootGuardProfileVerifiedMeasured,
+		failed:  []string{"IBB coverage"},
+		contains: "segment [0x3f000, 0x40000) is not hashed; " +
+			"the reset vector at 0x3fff0 is not in the IBB; " +
+			"the FIT pointer at 0x3ffc0 is not in the IBB; " +
+			"the IBB entry point at 0x3fff0 is not in the IBB; " +
+			"volume 763BED0D-DE9F-48F5-81F1-3E90E1B1A015 [0x8000, 0x3c000) is only partly in the IBB",
+	}, {
+		name: "outside of the BIOS region",
+		buf: func() []byte {
+			return bootGuardImage(t, keys, keys.bpm, []bgbootpolicy.IBBSegment{
+				{Base: 0xFFFC1000, Size: 0x1000},
+				{Base: 0xFFFFE000, Size: 0x2000},
+			})
+		},
+		profile:  BootGuardProfileVerifiedMeasured,
+		failed:   []string{"BIOS region"},
+		contains: "IBB segment [0x1000, 0x2000) is outside of the BIOS region [0x8000, 0x40000)",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			v, out, err := auditBootGuard(t, test.buf())
+			if len(test.failed) == 0 && err != nil || len(test.failed) != 0 && !errors.Is(err, ErrBootGuard) {
+				t.Errorf("got error %v, want failed checks %v", err, test.failed)
+			}
+			if v.Profile != test.profile {
+				t.Errorf("got profile %q, want %q", v.Profile, test.profile)
+			}
+			failed := failedChecks(v)
+			if len(failed) != len(test.failed) {
+				t.Errorf("got failed checks %v, want %v", failed, test.failed)
+			}
+			for _, c := range test.failed {
+				if d, ok := failed[c]; !ok || !strings.Contains(d, test.contains) {
+					t.Errorf("check %q: got %q, want it to fail with %q\n%s", c, d, test.contains, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+
+	pkgbytes "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg/bgbootpolicy"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/bg/bgkey"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt/cbntbootpolicy"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt/cbntkey"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit/consts"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// BootGuardSign re-signs the key and boot policy manifests of an image whose
+// IBB changed, for platforms with unfused Boot Guard or fused with keys of
+// the user. It updates the IBB digests of the boot policy manifest and signs
+// it with BPMKey. The key manifest is updated to trust BPMKey and signed
+// with KMKey, which is only needed when it does not trust BPMKey yet. Boot
+// Guard 1.0 manifests are signed with RSA keys, CBnT manifests with RSA or
+// ECDSA P-256 keys. It must be applied to the root, which is mapped below
+// 4GiB.
+type BootGuardSign struct {
+	BPMKey crypto.Signer
+	KMKey  crypto.Signer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *BootGuardSign) Run(f uefi.Firmware) error {
+	return v.Visit(f)
+}
+
+// bootGuardSigner signs the manifests of either Boot Guard version.
+type bootGuardSigner interface {
+	// setKeys sets the public keys of the manifests, and so their sizes.
+	// kmKey is nil to keep the signature of the key manifest.
+	setKeys(kmKey, bpmKey crypto.Signer) error
+	// sign updates the IBB digests with the image buf and signs the
+	// manifests.
+	sign(buf []byte, kmKey, bpmKey crypto.Signer) error
+	// manifests returns the bytes of the key and boot policy manifests.
+	manifests() (km, bpm []byte, err error)
+}
+
+// hashIBB returns the digest of the ranges of the image buf.
+func hashIBB(h hash.Hash, buf []byte, ranges pkgbytes.Ranges) ([]byte, error) {
+	for _, r := range ranges {
+		if r.End() > uint64(len(buf)) {
+			return nil, fmt.Errorf("IBB segment [%#x, %#x) is outside of the image (%#x)", r.Offset, r.End(), len(buf))
+		}
+		h.Write(buf[r.Offset:r.End()])
+	}
+	return h.Sum(nil), nil
+}
+
+// untrustedBPMKey is the error of a key manifest which does not trust the
+// BPM key and which is not re-signed.
+func untrustedBPMKey(err error) error {
+	return fmt.Errorf("the key manifest does not trust the BPM key, it must be re-signed with the KM key: %w", err)
+}
+
+type bgSigner struct {
+	km  *bgkey.Manifest
+	bpm *bgbootpolicy.Manifest
+}
+
+func (s *bgSigner) setKeys(kmKey, bpmKey crypto.Signer) error {
+	if len(s.bpm.SE) == 0 {
+		return fmt.Errorf("no IBB segments element in the boot policy manifest")
+	}
+	if err := s.bpm.PMSE.KeySignature.SetSignature(bg.AlgRSASSA, bpmKey, nil); err != nil {
+		return fmt.Errorf("unable to sign the boot policy manifest: %w", err)
+	}
+	if kmKey == nil {
+		return nil
+	}
+	if err := s.km.SetSignature(bg.AlgRSASSA, kmKey, nil); err != nil {
+		return fmt.Errorf("unable to sign the key manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *bgSigner) sign(buf []byte, kmKey, bpmKey crypto.Signer) error {
+	se := &s.bpm.SE[0]
+	h, err := se.Digest.HashAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("invalid IBB hash algorithm %v: %w", se.Digest.HashAlg, err)
+	}
+	if se.Digest.HashBuffer, err = hashIBB(h, buf, s.bpm.IBBDataRanges(uint64(len(buf)))); err != nil {
+		return err
+	}
+	_, bpm, err := s.manifests()
+	if err != nil {
+		return err
+	}
+	if err := s.bpm.PMSE.KeySignature.SetSignature(bg.AlgRSASSA, bpmKey, bpm[:s.bpm.PMSEOffset()+s.bpm.PMSE.KeySignatureOffset()]); err != nil {
+		return fmt.Errorf("unable to sign the boot policy manifest: %w", err)
+	}
+
+	if kmKey == nil {
+		if err := s.km.ValidateBPMKey(s.bpm.PMSE.KeySignature); err != nil {
+			return untrustedBPMKey(err)
+		}
+		return nil
+	}
+	h, err = s.km.BPKey.HashAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("invalid BPM key hash algorithm %v: %w", s.km.BPKey.HashAlg, err)
+	}
+	h.Write(s.bpm.PMSE.KeySignature.Key.Data[4:])
+	s.km.BPKey.HashBuffer = h.Sum(nil)
+	km, _, err := s.manifests()
+	if err != nil {
+		return err
+	}
+	if err := s.km.SetSignature(bg.AlgRSASSA, kmKey, km[:s.km.KeyAndSignatureOffset()]); err != nil {
+		return fmt.Errorf("unable to sign the key manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *bgSigner) manifests() ([]byte, []byte, error) {
+	var km, bpm bytes.Buffer
+	if _, err := s.km.WriteTo(&km); err != nil {
+		return nil, nil, fmt.Errorf("unable to write the key manifest: %w", err)
+	}
+	if _, err := s.bpm.WriteTo(&bpm); err != nil {
+		return nil, nil, fmt.Errorf("unable to write the boot policy manifest: %w", err)
+	}
+	return km.Bytes(), bpm.Bytes(), nil
+}
+
+type cbntSigner struct {
+	km  *cbntkey.Manifest
+	bpm *cbntbootpolicy.Manifest
+}
+
+// The signature algorithms are those of the keys: RSASSA for 2048-bit RSA
+// keys, RSAPSS for 3072-bit ones and ECDSA.
+func (s *cbntSigner) setKeys(kmKey, bpmKey crypto.Signer) error {
+	if len(s.bpm.SE) == 0 {
+		return fmt.Errorf("no IBB segments element in the boot policy manifest")
+	}
+	if err := s.bpm.PMSE.KeySignature.SetSignature(0, 0, bpmKey, nil); err != nil {
+		return fmt.Errorf("unable to sign the boot policy manifest: %w", err)
+	}
+	if kmKey == nil {
+		return nil
+	}
+	if err := s.km.SetSignature(0, 0, kmKey, nil); err != nil {
+		return fmt.Errorf("unable to sign the key manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *cbntSigner) sign(buf []byte, kmKey, bpmKey crypto.Signer) error {
+	digests := s.bpm.SE[0].DigestList.List
+	if len(digests) == 0 {
+		return fmt.Errorf("no IBB hashes")
+	}
+	for i := range digests {
+		h, err := digests[i].HashAlg.Hash()
+		if err != nil {
+			return fmt.Errorf("invalid IBB hash algorithm %v: %w", digests[i].HashAlg, err)
+		}
+		if digests[i].HashBuffer, err = hashIBB(h, buf, s.bpm.IBBDataRanges(uint64(len(buf)))); err != nil {
+			return err
+		}
+	}
+	_, bpm, err := s.manifests()
+	if err != nil {
+		return err
+	}
+	if err := s.bpm.PMSE.KeySignature.SetSignature(0, 0, bpmKey, bpm[:s.bpm.KeySignatureOffset]); err != nil {
+		return fmt.Errorf("unable to sign the boot policy manifest: %w", err)
+	}
+
+	if kmKey == nil {
+		if err := s.km.ValidateBPMKey(s.bpm.PMSE.KeySignature); err != nil {
+			return untrustedBPMKey(err)
+		}
+		return nil
+	}
+	key := s.bpm.PMSE.KeySignature.Key
+	if key.KeyAlg == cbnt.AlgRSA {
+		key.Data = key.Data[4:]
+	}
+	trusted := false
+	for i := range s.km.Hash {
+		d := &s.km.Hash[i].Digest
+		if !s.km.Hash[i].Usage.IsSet(cbntkey.UsageBPMSigningPKD) {
+			continue
+		}
+		h, err := d.HashAlg.Hash()
+		if err != nil {
+			return fmt.Errorf("invalid BPM key hash algorithm %v: %w", d.HashAlg, err)
+		}
+		h.Write(key.Data)
+		d.HashBuffer = h.Sum(nil)
+		trusted = true
+	}
+	if !trusted {
+		return fmt.Errorf("no hash of the BPM key in the key manifest")
+	}
+	km, _, err := s.manifests()
+	if err != nil {
+		return err
+	}
+	if err := s.km.SetSignature(0, 0, kmKey, km[:s.km.KeyManifestSignatureOffset]); err != nil {
+		return fmt.Errorf("unable to sign the key manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *cbntSigner) manifests() ([]byte, []byte, error) {
+	var km, bpm bytes.Buffer
+	if _, err := s.km.WriteTo(&km); err != nil {
+		return nil, nil, fmt.Errorf("unable to write the key manifest: %w", err)
+	}
+	if _, err := s.bpm.WriteTo(&bpm); err != nil {
+		return nil, nil, fmt.Errorf("unable to write the boot policy manifest: %w", err)
+	}
+	return km.Bytes(), bpm.Bytes(), nil
+}
+
+// resizeManifest sets the size of the first FIT entry of type t to size,
+// which may take the erased space following the manifest, and returns its
+// offset in the image, its former size and whether the entry changed.
+func resizeManifest(table fit.Table, buf []byte, t fit.EntryType, size int) (uint64, uint64, bool, error) {
+	b, offset, err := manifestBytes(table, buf, t)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("%v: %w", t, err)
+	}
+	old := uint64(len(b))
+	if uint64(size) == old {
+		return offset, old, false, nil
+	}
+	room := old
+	for offset+room < uint64(len(buf)) && room < uint64(size) && buf[offset+room] == 0xff {
+		room++
+	}
+	if uint64(size) > room {
+		return 0, 0, false, fmt.Errorf("the %v (%#x bytes) does not fit in the %#x bytes at %#x", t, size, room, offset)
+	}
+	hdr := table.First(t)
+	hdr.Size.SetUint32(uint32(size))
+	if hdr.IsChecksumValid() {
+		hdr.Checksum = hdr.CalculateChecksum()
+	}
+	return offset, old, true, nil
+}
+
+// Visit re-signs the Boot Guard manifests of the image f.
+func (v *BootGuardSign) Visit(f uefi.Firmware) error {
+	if v.BPMKey == nil {
+		return errors.New("no BPM key to sign the boot policy manifest with")
+	}
+	// The IBB digests are those of the image as it boots, with the changes
+	// of the previous visitors.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	buf := f.Buf()
+	if uint64(len(buf)) < consts.FITPointerOffset {
+		return fmt.Errorf("the image (%#x bytes) is too small for a FIT", len(buf))
+	}
+	table, err := fit.GetTable(buf)
+	if err != nil {
+		return fmt.Errorf("unable to get the FIT: %w", err)
+	}
+	bgKM, cbntKM, err := table.ParseKeyManifest(buf)
+	if err != nil {
+		return fmt.Errorf("unable to parse the key manifest: %w", err)
+	}
+	bgBPM, cbntBPM, err := table.ParseBootPolicyManifest(buf)
+	if err != nil {
+		return fmt.Errorf("unable to parse the boot policy manifest: %w", err)
+	}
+	var s bootGuardSigner
+	switch {
+	case bgKM != nil && bgBPM != nil:
+		s = &bgSigner{km: bgKM, bpm: bgBPM}
+	case cbntKM != nil && cbntBPM != nil:
+		s = &cbntSigner{km: cbntKM, bpm: cbntBPM}
+	default:
+		return errors.New("key and boot policy manifests of different Boot Guard versions")
+	}
+	if err := s.setKeys(v.KMKey, v.BPMKey); err != nil {
+		return err
+	}
+
+	// The manifests change size with the keys, so do their FIT entries,
+	// which may be in the IBB: hash the image with the new FIT, and only
+	// write it once the manifests are signed.
+	km, bpm, err := s.manifests()
+	if err != nil {
+		return err
+	}
+	kmOffset, kmSize, kmResized, err := resizeManifest(table, buf, fit.EntryTypeKeyManifestRecord, len(km))
+	if err != nil {
+		return err
+	}
+	bpmOffset, bpmSize, bpmResized, err := resizeManifest(table, buf, fit.EntryTypeBootPolicyManifest, len(bpm))
+	if err != nil {
+		return err
+	}
+	var fitOffset uint64
+	var fitBytes []byte
+	if kmResized || bpmResized {
+		start, _, err := fit.GetHeadersTableRangeFrom(bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		if _, err := table.WriteTo(&b); err != nil {
+			return err
+		}
+		fitOffset, fitBytes = start, b.Bytes()
+		if fitOffset+uint64(len(fitBytes)) > uint64(len(buf)) {
+			return fmt.Errorf("the FIT at %#x is outside of the image", fitOffset)
+		}
+		buf = append([]byte{}, buf...)
+		copy(buf[fitOffset:], fitBytes)
+	}
+
+	if err := s.sign(buf, v.KMKey, v.BPMKey); err != nil {
+		return err
+	}
+	if km, bpm, err = s.manifests(); err != nil {
+		return err
+	}
+	if fitBytes != nil {
+		if err := writeImageRange(f, fitOffset, fitBytes); err != nil {
+			return err
+		}
+	}
+	for _, m := range []struct {
+		offset, size uint64
+		b            []byte
+	}{
+		{kmOffset, kmSize, km},
+		{bpmOffset, bpmSize, bpm},
+	} {
+		// Erase what a manifest which shrunk leaves behind.
+		b := m.b
+		if uint64(len(b)) < m.size {
+			b = append(b, bytes.Repeat([]byte{0xff}, int(m.size)-len(b))...)
+		}
+		if err := writeImageRange(f, m.offset, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSigner reads a PKCS #1 RSA, SEC 1 EC or PKCS #8 private key from a
+// PEM file, like those of openssl genrsa, openssl ecparam -genkey and
+// openssl genpkey.
+func readSigner(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM private key", path)
+		}
+		var k interface{}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			k, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			k, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			// Like the EC PARAMETERS of openssl ecparam.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		signer, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s: a %T, want a signing key", path, k)
+		}
+		return signer, nil
+	}
+}
+
+func init() {
+	RegisterCLI("bootguard_sign", "bootguard_sign BPM_KEY\n update the IBB digests of the Boot Guard boot policy manifest and sign it with the PEM private key BPM_KEY, which the key manifest must trust", 1, func(args []string) (uefi.Visitor, error) {
+		bpmKey, err := readSigner(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &BootGuardSign{BPMKey: bpmKey}, nil
+	})
+	RegisterCLI("bootguard_sign_km", "bootguard_sign_km KM_KEY BPM_KEY\n update the IBB digests of the Boot Guard boot policy manifest, sign it with the PEM private key BPM_KEY and sign the key manifest trusting it with the PEM private key KM_KEY", 2, func(args []string) (uefi.Visitor, error) {
+		kmKey, err := readSigner(args[0])
+		if err != nil {
+			return nil, err
+		}
+		bpmKey, err := readSigner(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &BootGuardSign{KMKey: kmKey, BPMKey: bpmKey}, nil
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt/cbntbootpolicy"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt/cbntkey"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// signBootGuard re-signs the manifests of buf and returns the assembled
+// image.
+func signBootGuard(t *testing.T, buf []byte, v *BootGuardSign) ([]byte, error) {
+	f, err := uefi.Parse(append([]byte{}, buf...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Run(f); err != nil {
+		return nil, err
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	return f.Buf(), nil
+}
+
+// checkBootGuard fails the test if the audit of buf fails.
+func checkBootGuard(t *testing.T, buf []byte, version string) *BootGuardAudit {
+	v, out, err := auditBootGuard(t, buf)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if v.Version != version || v.Profile != BootGuardProfileVerifiedMeasured {
+		t.Errorf("got Boot Guard %q profile %q, want %s %q", v.Version, v.Profile, version, BootGuardProfileVerifiedMeasured)
+	}
+	return v
+}
+
+func TestBootGuardSign(t *testing.T) {
+	keys := newBootGuardKeys(t)
+	buf := bootGuardImage(t, keys, keys.bpm, ibbTop)
+	buf[0x3e000] ^= 0xff
+	if _, _, err := auditBootGuard(t, buf); err == nil {
+		t.Fatal("the IBB changed, want the audit to fail")
+	}
+
+	signed, err := signBootGuard(t, buf, &BootGuardSign{BPMKey: keys.bpm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBootGuard(t, signed, "1.0")
+
+	if _, err := signBootGuard(t, buf, &BootGuardSign{BPMKey: keys.other}); err == nil {
+		t.Errorf("signed with a BPM key the key manifest does not trust, want an error")
+	}
+	signed, err = signBootGuard(t, buf, &BootGuardSign{KMKey: keys.km, BPMKey: keys.other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBootGuard(t, signed, "1.0")
+
+	// A larger key grows the boot policy manifest and its FIT entry, which
+	// is in the IBB.
+	bpmKey, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err = signBootGuard(t, buf, &BootGuardSign{KMKey: keys.km, BPMKey: bpmKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBootGuard(t, signed, "1.0")
+	var sizes [2]uint32
+	for i, b := range [][]byte{buf, signed} {
+		table, err := fit.GetTable(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[i] = table.First(fit.EntryTypeBootPolicyManifest).Size.Uint32()
+	}
+	if sizes[1] != sizes[0]+0x100 {
+		t.Errorf("got a boot policy manifest of %#x bytes, want %#x", sizes[1], sizes[0]+0x100)
+	}
+}
+
+// cbntImage returns the image of bootGuardImage with CBnT manifests whose
+// IBB is ibbTop, to sign with kmKey and bpmKey.
+func cbntImage(t *testing.T, kmKey, bpmKey crypto.Signer) []byte {
+	buf := ifdImage(t)
+	copy(buf[0x3c000:], testACM(0x400, fit.ChipsetACMTypeBIOS, 1))
+
+	km := cbntkey.NewManifest()
+	km.KMID = 1
+	km.Hash = []cbntkey.Hash{{
+		Usage:  cbntkey.UsageBPMSigningPKD,
+		Digest: cbnt.HashStructure{HashAlg: cbnt.AlgSHA256, HashBuffer: make([]byte, sha256.Size)},
+	}}
+	if err := km.SetSignature(0, 0, kmKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	bpm := cbntbootpolicy.NewManifest()
+	se := cbntbootpolicy.NewSE()
+	se.Flags = 0x04
+	se.IBBEntryPoint = 0xFFFFFFF0
+	se.DigestList.List = []cbnt.HashStructure{{HashAlg: cbnt.AlgSHA256, HashBuffer: make([]byte, sha256.Size)}}
+	for _, s := range ibbTop {
+		se.IBBSegments = append(se.IBBSegments, cbntbootpolicy.IBBSegment{Base: s.Base, Size: s.Size})
+	}
+	bpm.SE = []cbntbootpolicy.SE{*se}
+	if err := bpm.PMSE.KeySignature.SetSignature(0, 0, bpmKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var kmb, bpmb bytes.Buffer
+	if _, err := km.WriteTo(&kmb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bpm.WriteTo(&bpmb); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[0x3d000:], kmb.Bytes())
+	copy(buf[0x3d800:], bpmb.Bytes())
+	writeBootGuardFIT(buf, kmb.Len(), bpmb.Len())
+	return buf
+}
+
+func TestBootGuardSignCBnT(t *testing.T) {
+	keys := newBootGuardKeys(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa3072, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name          string
+		kmKey, bpmKey crypto.Signer
+	}{
+		{"RSA", keys.km, keys.bpm},
+		{"ECDSA BPM key", keys.km, ecKey},
+		{"RSAPSS", rsa3072, rsa3072},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf := cbntImage(t, keys.km, keys.bpm)
+			if _, err := signBootGuard(t, buf, &BootGuardSign{BPMKey: test.bpmKey}); err == nil {
+				t.Errorf("signed without the KM key, want an error")
+			}
+			signed, err := signBootGuard(t, buf, &BootGuardSign{KMKey: test.kmKey, BPMKey: test.bpmKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBootGuard(t, signed, "2.0")
+
+			signed[0x3e000] ^= 0xff
+			if signed, err = signBootGuard(t, signed, &BootGuardSign{BPMKey: test.bpmKey}); err != nil {
+				t.Fatal(err)
+			}
+			checkBootGuard(t, signed, "2.0")
+		})
+	}
+}
+
+func TestReadSigner(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		blocks []*pem.Block
+		key    crypto.Signer
+	}{
+		{"rsa.pem", []*pem.Block{{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}}, rsaKey},
+		{"ec.pem", []*pem.Block{{Type: "EC PARAMETERS", Bytes: []byte{0x06}}, {Type: "EC PRIVATE KEY", Bytes: ecDER}}, ecKey},
+		{"pkcs8.pem", []*pem.Block{{Type: "PRIVATE KEY", Bytes: pkcs8}}, ecKey},
+		{"public.pem", []*pem.Block{{Type: "PUBLIC KEY", Bytes: []byte{0x30}}}, nil},
+	} {
+		var b []byte
+		for _, block := range test.blocks {
+			b = append(b, pem.EncodeToMemory(block)...)
+		}
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+		k, err := readSigner(path)
+		if test.key == nil {
+			if err == nil {
+				t.Errorf("%s: got a %T, want an error", test.name, k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if pub, ok := k.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(test.key.Public()) {
+			t.Errorf("%s: got another key", test.name)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit/consts"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// fitFlashEntries are the types of FIT entries whose address is in flash.
+// The others, like the TPM and TXT policy records, may hold I/O indexes.
+var fitFlashEntries = map[fit.EntryType]bool{
+	fit.EntryTypeMicrocodeUpdateEntry:        true,
+	fit.EntryTypeStartupACModuleEntry:        true,
+	fit.EntryTypeDiagnosticACModuleEntry:     true,
+	fit.EntryTypeBIOSStartupModuleEntry:      true,
+	fit.EntryTypeBIOSPolicyRecord:            true,
+	fit.EntryTypeKeyManifestRecord:           true,
+	fit.EntryTypeBootPolicyManifest:          true,
+	fit.EntryTypeCSESecureBoot:               true,
+	fit.EntryTypeFeaturePolicyDeliveryRecord: true,
+	fit.EntryTypeJMPDebugPolicy:              true,
+}
+
+// fmapRegionAreas are the names coreboot gives the fmap areas of the
+// regions of the flash descriptor.
+var fmapRegionAreas = map[string]uefi.FlashRegionType{
+	"SI_BIOS": uefi.RegionTypeBIOS,
+	"SI_ME":   uefi.RegionTypeME,
+	"SI_GBE":  uefi.RegionTypeGBE,
+	"SI_PDR":  uefi.RegionTypePD,
+	"SI_EC":   uefi.RegionTypeEC,
+}
+
+// extent is a range of the image some structure covers.
+type extent struct {
+	name       string
+	start, end uint64
+}
+
+func (e extent) String() string {
+	return fmt.Sprintf("%s [%#x, %#x)", e.name, e.start, e.end)
+}
+
+func (e extent) overlaps(o extent) bool {
+	return e.start < o.end && o.start < e.end
+}
+
+func (e extent) contains(o extent) bool {
+	return e.start <= o.start && o.end <= e.end
+}
+
+// CheckLayout cross-checks the regions of the flash descriptor, the areas
+// of the fmap, the firmware volumes and the entries of the FIT against each
+// other and the size of the image. It reports structures which leave the
+// image, overlap or point nowhere, as manual edits of an image leave them.
+// The image is checked as it was parsed or last assembled.
+type CheckLayout struct {
+	Reporter
+
+	// W is where problems are written, os.Stdout if nil.
+	W io.Writer
+
+	// Output
+	Problems []string
+
+	size    uint64
+	regions map[uefi.FlashRegionType]extent
+	volumes []extent
+}
+
+func (v *CheckLayout) report(format string, a ...interface{}) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, a...))
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *CheckLayout) Run(f uefi.Firmware) error {
+	buf := f.Buf()
+	v.size = uint64(len(buf))
+	v.Problems = nil
+	v.regions = map[uefi.FlashRegionType]extent{}
+	v.volumes = nil
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	v.checkFMAP(buf)
+	v.checkFIT(buf)
+
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	if v.Format != FormatDefault {
+		report := struct{ Problems []string }{Problems: append([]string{}, v.Problems...)}
+		b, err := marshalReport(v.Format, report)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(b))
+	} else {
+		for _, p := range v.Problems {
+			fmt.Fprintln(w, p)
+		}
+	}
+	if len(v.Problems) != 0 {
+		return fmt.Errorf("%d layout problems", len(v.Problems))
+	}
+	return nil
+}
+
+// Visit applies the CheckLayout visitor to any Firmware type.
+func (v *CheckLayout) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FlashImage:
+		v.checkRegions(f)
+		return f.ApplyChildren(v)
+
+	case *uefi.BIOSRegion:
+		region := extent{name: "BIOS region", end: uint64(len(f.Buf()))}
+		if r := f.FlashRegion(); r != nil {
+			region.start, region.end = uint64(r.BaseOffset()), uint64(r.EndOffset())
+		}
+		for _, e := range f.Elements {
+			fv, ok := e.Value.(*uefi.FirmwareVolume)
+			if !ok {
+				continue
+			}
+			start := region.start + fv.FVOffset
+			vol := extent{name: "volume " + fv.String(), start: start, end: start + fv.Length}
+			if !region.contains(vol) {
+				v.report("%v leaves the %v", vol, region)
+			}
+			for _, o := range v.volumes {
+				if vol.overlaps(o) {
+					v.report("%v overlaps %v", vol, o)
+				}
+			}
+			v.volumes = append(v.volumes, vol)
+		}
+	}
+	// Nested volumes are checked with the sections holding them.
+	return nil
+}
+
+// checkRegions checks the regions of the flash descriptor against the
+// image and each other.
+func (v *CheckLayout) checkRegions(f *uefi.FlashImage) {
+	if f.IFD.Region == nil {
+		return
+	}
+	exts := []extent{{name: "descriptor", end: uefi.RegionBlockSize}}
+	for i, r := range f.IFD.Region.FlashRegions {
+		if !r.Valid() {
+			continue
+		}
+		t := uefi.FlashRegionType(i)
+		e := extent{name: t.String() + " region", start: uint64(r.BaseOffset()), end: uint64(r.EndOffset())}
+		if e.end > v.size {
+			v.report("%v ends past the image of %#x bytes", e, v.size)
+		}
+		for _, o := range exts {
+			if e.overlaps(o) {
+				v.report("%v overlaps %v", e, o)
+			}
+		}
+		exts = append(exts, e)
+		v.regions[t] = e
+	}
+}
+
+// checkFMAP checks the areas of the fmap against the image, each other,
+// the regions and the volumes. Areas nest, but must not partially overlap.
+func (v *CheckLayout) checkFMAP(buf []byte) {
+	var fm *fmap.FMap
+	for _, c := range fmap.Scan(buf) {
+		if c.FMap == nil {
+			// The signature alone, e.g. in the code reading the fmap.
+			continue
+		}
+		if c.Err != nil {
+			v.report("fmap at %#x: %v", c.Start, c.Err)
+		}
+		if fm != nil {
+			v.report("another fmap at %#x", c.Start)
+			continue
+		}
+		fm = c.FMap
+	}
+	if fm == nil {
+		return
+	}
+
+	var areas []extent
+	for _, a := range fm.Areas {
+		e := extent{name: fmt.Sprintf("fmap area %q", a.Name.String()), start: uint64(a.Offset), end: a.End()}
+		for _, o := range areas {
+			if e.overlaps(o) && !e.contains(o) && !o.contains(e) {
+				v.report("%v partially overlaps %v", e, o)
+			}
+		}
+		areas = append(areas, e)
+		if t, ok := fmapRegionAreas[a.Name.String()]; ok {
+			if r, ok := v.regions[t]; ok && (r.start != e.start || r.end != e.end) {
+				v.report("%v does not match the %v", e, r)
+			}
+		}
+		for _, vol := range v.volumes {
+			if e.overlaps(vol) && !e.contains(vol) && !vol.contains(e) {
+				v.report("%v partially overlaps %v", vol, e)
+			}
+		}
+	}
+}
+
+// checkFIT checks that the FIT pointer points to a FIT, and that its
+// entries point into the image and the BIOS region.
+func (v *CheckLayout) checkFIT(buf []byte) {
+	if v.size < consts.FITPointerOffset {
+		return
+	}
+	ptr := binary.LittleEndian.Uint64(buf[v.size-consts.FITPointerOffset:])
+	if ptr == 0 || ptr>>32 != 0 {
+		// Images without a FIT hold code or erased flash there.
+		return
+	}
+	if ptr < consts.BasePhysAddr-v.size {
+		v.report("FIT pointer %#x is outside the image", ptr)
+		return
+	}
+	table, err := fit.GetTable(buf)
+	if err != nil {
+		v.report("FIT pointer %#x: %v", ptr, err)
+		return
+	}
+
+	bios, hasBIOS := v.regions[uefi.RegionTypeBIOS]
+	for i, hdr := range table {
+		t := hdr.Type()
+		if !fitFlashEntries[t] {
+			continue
+		}
+		addr := hdr.Address.Pointer()
+		e := extent{name: fmt.Sprintf("FIT entry %d (%v)", i, t)}
+		if addr < consts.BasePhysAddr-v.size || addr >= consts.BasePhysAddr {
+			v.report("%s points to %#x, outside the image", e.name, addr)
+			continue
+		}
+		e.start = fit.CalculateOffsetFromPhysAddr(addr, v.size)
+		e.end = e.start + 1
+		if t == fit.EntryTypeKeyManifestRecord || t == fit.EntryTypeBootPolicyManifest {
+			// The size of manifests is in bytes rather than in 16 bytes.
+			e.end = e.start + uint64(hdr.Size.Uint32())
+			if e.end > v.size {
+				v.report("%v ends past the image of %#x bytes", e, v.size)
+				continue
+			}
+		}
+		if hasBIOS && !bios.contains(e) {
+			v.report("%v is outside the %v", e, bios)
+		}
+	}
+}
+
+func init() {
+	RegisterCLI("check_layout", "check that the regions, fmap areas, volumes and FIT entries fit together", 0, func(args []string) (uefi.Visitor, error) {
+		return &CheckLayout{}, nil
+	})
+}
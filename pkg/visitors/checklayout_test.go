@@ -0,0 +1,95 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func checkLayout(t *testing.T, f uefi.Firmware) []string {
+	t.Helper()
+	v := &CheckLayout{W: io.Discard}
+	err := v.Run(f)
+	if (err != nil) != (len(v.Problems) != 0) {
+		t.Errorf("got error %v for problems %q", err, v.Problems)
+	}
+	return v.Problems
+}
+
+func TestCheckLayout(t *testing.T) {
+	if p := checkLayout(t, parseImage(t)); len(p) != 0 {
+		t.Errorf("got problems %q in the OVMF image, want none", p)
+	}
+
+	buf := ifdImage(t)
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := checkLayout(t, f); len(p) != 0 {
+		t.Errorf("got problems %q in the descriptor image, want none", p)
+	}
+
+	// Add an fmap and a FIT, in the erased flash after the volume.
+	const base = 0xFFFC0000
+	var m bytes.Buffer
+	hdr := fmap.Header{VerMajor: 1, Size: 0x40000, NAreas: 2}
+	copy(hdr.Signature[:], fmap.Signature)
+	areas := []fmap.Area{{Offset: 0x8000, Size: 0x30000}, {Offset: 0x3b000, Size: 0x2000}}
+	copy(areas[0].Name.Value[:], "SI_BIOS")
+	copy(areas[1].Name.Value[:], "RO")
+	for _, d := range []interface{}{&hdr, areas} {
+		if err := binary.Write(&m, binary.LittleEndian, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copy(buf[0x3e000:], m.Bytes())
+	table := buf[0x3d000:]
+	copy(table, make([]byte, 0x30))
+	copy(table, "_FIT_   ")
+	table[8] = 3
+	// A microcode update in the GbE region and a key manifest past the end.
+	binary.LittleEndian.PutUint64(table[0x10:], base+0x2000)
+	table[0x1e] = 0x01
+	binary.LittleEndian.PutUint64(table[0x20:], base+0x3fff0)
+	table[0x28] = 0x80
+	table[0x2e] = 0x0b
+	binary.LittleEndian.PutUint64(buf[0x3ffc0:], base+0x3d000)
+	if f, err = uefi.Parse(buf); err != nil {
+		t.Fatal(err)
+	}
+	p := checkLayout(t, f)
+	for _, want := range []string{
+		`fmap area "SI_BIOS" [0x8000, 0x38000) does not match the BIOS region [0x8000, 0x40000)`,
+		`volume 763BED0D-DE9F-48F5-81F1-3E90E1B1A015 [0x8000, 0x3c000) partially overlaps fmap area "RO"`,
+		`FIT entry 1 (MicrocodeUpdateEntry) [0x2000, 0x2001) is outside the BIOS region`,
+		`FIT entry 2 (KeyManifestRecord) [0x3fff0, 0x40070) ends past the image`,
+	} {
+		found := false
+		for _, s := range p {
+			found = found || strings.HasPrefix(s, want)
+		}
+		if !found {
+			t.Errorf("problem %q not in %q", want, p)
+		}
+	}
+	if len(p) != 4 {
+		t.Errorf("got %d problems, want 4: %q", len(p), p)
+	}
+
+	// Move the GbE region into the BIOS region.
+	f.(*uefi.FlashImage).IFD.Region.FlashRegions[uefi.RegionTypeGBE] = uefi.FlashRegion{Base: 1, Limit: 8}
+	p = checkLayout(t, f)
+	if len(p) != 5 || p[0] != "GbE region [0x1000, 0x9000) overlaps BIOS region [0x8000, 0x40000)" {
+		t.Errorf("got problems %q, want the overlap of the regions first", p)
+	}
+}
@@ -65,6 +65,24 @@ func ParseCLI(args []string) ([]uefi.Visitor, error) {
 	return visitors, nil
 }
 
+// CLINames returns the names of the operations of the CLI argument list,
+// without their arguments.
+func CLINames(args []string) ([]string, error) {
+	var names []string
+	for len(args) > 0 {
+		o, ok := visitorRegistry[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("could not find command '%s'\n%s", args[0], helpMessage)
+		}
+		if o.numArgs > len(args)-1 {
+			return nil, fmt.Errorf("too few arguments for command '%s', got %d, expected %d", args[0], len(args)-1, o.numArgs)
+		}
+		names = append(names, args[0])
+		args = args[o.numArgs+1:]
+	}
+	return names, nil
+}
+
 // ExecuteCLI applies each Visitor over the firmware in sequence.
 func ExecuteCLI(f uefi.Firmware, v []uefi.Visitor) error {
 	for i := range v {
@@ -0,0 +1,65 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Cancelable makes the visitors which embed it uefi.ContextVisitors, so
+// their walk of the tree stops once the context set with SetContext is done.
+// Visitors which take long, like Assemble recompressing volumes or scans,
+// embed it.
+type Cancelable struct {
+	ctx context.Context
+}
+
+// Context implements uefi.ContextVisitor. It is context.Background() until
+// set.
+func (c *Cancelable) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// SetContext sets the context of the visitor.
+func (c *Cancelable) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// ExecuteCLIContext is like ExecuteCLI, but stops with the error of ctx once
+// it is done, between visitors and while Cancelable visitors run. Reports
+// are written in the output format of ctx, and the visitors run through its
+// Middleware.
+func ExecuteCLIContext(ctx context.Context, f uefi.Firmware, v []uefi.Visitor) error {
+	run := runFunc(ctx)
+	for i := range v {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		prepare(ctx, v[i])
+		if err := run(v[i], f); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare sets the context and the default output format of ctx on v, if
+// it has them.
+func prepare(ctx context.Context, v uefi.Visitor) {
+	if c, ok := v.(interface{ SetContext(context.Context) }); ok {
+		c.SetContext(ctx)
+	}
+	if r, ok := v.(interface{ defaultFormat(string) }); ok {
+		r.defaultFormat(OutputFormatOf(ctx))
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// cancelAfter cancels its context after visiting n nodes.
+type cancelAfter struct {
+	Cancelable
+
+	n       int
+	visited int
+	cancel  context.CancelFunc
+}
+
+func (v *cancelAfter) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+func (v *cancelAfter) Visit(f uefi.Firmware) error {
+	v.visited++
+	if v.visited == v.n {
+		v.cancel()
+	}
+	return f.ApplyChildren(v)
+}
+
+func TestExecuteCLIContext(t *testing.T) {
+	f := parseImage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	v := &cancelAfter{n: 3, cancel: cancel}
+	count := &Count{}
+	if err := ExecuteCLIContext(ctx, f, []uefi.Visitor{v, count}); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if v.visited != 3 {
+		t.Errorf("visited %d nodes, want to stop after 3", v.visited)
+	}
+	if count.FirmwareTypeCount != nil {
+		t.Error("ran the visitor after the cancellation")
+	}
+}
@@ -5,7 +5,6 @@
 package visitors
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -16,6 +15,9 @@ import (
 
 // Count counts the number of each firmware type.
 type Count struct {
+	Cancelable
+	Reporter
+
 	// Optionally write result as JSON.
 	W io.Writer `json:"-"`
 
@@ -36,7 +38,7 @@ func (v *Count) Run(f uefi.Firmware) error {
 	}
 
 	if v.W != nil {
-		b, err := json.MarshalIndent(v, "", "\t")
+		b, err := marshalReport(v.Format, v)
 		if err != nil {
 			return err
 		}
@@ -191,7 +191,7 @@ func createEmptyFirmwareVolume(fvOffset, size uint64, name *guid.GUID) (*uefi.Fi
 		// At [1] `GenerateFvImage` gives the extended header as an argument to `AddPadFile` implemented at [2].
 		// [1]: https://github.com/tianocore/edk2/blob/master/BaseTools/Source/C/GenFv/GenFvInternalLib.c#L2772
 		// [2]: https://github.com/tianocore/edk2/blob/master/BaseTools/Source/C/GenFv/GenFvInternalLib.c#L563
-		extHeaderFile, err := uefi.CreatePadFile(uint64(uefi.FileHeaderMinLength + fv.ExtHeaderSize))
+		extHeaderFile, err := uefi.CreateErasedPadFile(uint64(uefi.FileHeaderMinLength+fv.ExtHeaderSize), fv.GetErasePolarity())
 		if err != nil {
 			return nil, fmt.Errorf("building ExtHeader %v", err)
 		}
@@ -209,7 +209,7 @@ func createEmptyFirmwareVolume(fvOffset, size uint64, name *guid.GUID) (*uefi.Fi
 	// Add empty space
 	extLen := fv.Length - fv.DataOffset
 	emptyBuf := make([]byte, extLen)
-	uefi.Erase(emptyBuf, uefi.Attributes.ErasePolarity)
+	uefi.Erase(emptyBuf, fv.GetErasePolarity())
 
 	// Store the buffer in
 	fv.SetBuf(append(fv.Buf(), emptyBuf...))
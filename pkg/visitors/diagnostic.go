@@ -0,0 +1,98 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Diagnostic is a finding about a node, like a failed validation check. It
+// is written as one JSON object per line, so CI jobs can gate on its code
+// rather than on the text of the message.
+type Diagnostic struct {
+	// Level is "error" or "warn".
+	Level string `json:"level"`
+	// Code identifies the check, like validate.fv or validate.file.
+	Code string `json:"code"`
+	// Path of the node, like the paths of the shell.
+	Path string `json:"path,omitempty"`
+	// Offset of the node in the image, or in the decompressed data of the
+	// section holding it, if known.
+	Offset *uint64 `json:"offset,omitempty"`
+	Msg    string  `json:"msg"`
+
+	node uefi.Firmware
+}
+
+// Error implements error with the message.
+func (d *Diagnostic) Error() string {
+	return d.Msg
+}
+
+// WriteDiagnostic writes d as a line of JSON.
+func WriteDiagnostic(w io.Writer, d *Diagnostic) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+type diagnosticsKey struct{}
+
+// WithDiagnostics returns a context whose visitors write their findings as
+// Diagnostics to w, rather than as text.
+func WithDiagnostics(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, diagnosticsKey{}, w)
+}
+
+// DiagnosticsOf returns the writer of the Diagnostics of ctx, nil if they
+// are written as text.
+func DiagnosticsOf(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(diagnosticsKey{}).(io.Writer)
+	return w
+}
+
+// diagnosticCode returns the code of the checks of the node, by type.
+func diagnosticCode(check string, f uefi.Firmware) string {
+	switch f.(type) {
+	case *uefi.FlashImage:
+		return check + ".image"
+	case *uefi.FlashDescriptor:
+		return check + ".ifd"
+	case *uefi.BIOSRegion:
+		return check + ".bios"
+	case *uefi.MERegion, *uefi.RawRegion:
+		return check + ".region"
+	case *uefi.FirmwareVolume:
+		return check + ".fv"
+	case *uefi.File:
+		return check + ".file"
+	case *uefi.Section:
+		return check + ".section"
+	}
+	return check + ".node"
+}
+
+// nodeOffsets returns the offsets of the nodes of f as table writes them.
+func nodeOffsets(f uefi.Firmware) (map[uefi.Firmware]uint64, error) {
+	offsets := map[uefi.Firmware]uint64{}
+	t := &Table{
+		W:        tabwriter.NewWriter(io.Discard, 0, 0, 0, ' ', 0),
+		printRow: func(v *Table, node, name, typez interface{}, offset, length uint64) {},
+		offsets:  offsets,
+	}
+	if err := t.Run(f); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
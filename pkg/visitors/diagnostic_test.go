@@ -0,0 +1,67 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestValidateDiagnostics(t *testing.T) {
+	f := parseImage(t)
+	find := &Find{Predicate: FindFileTypePredicate(uefi.FVFileTypePEIM)}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	file := find.Matches[0].(*uefi.File)
+	// Break the checksum of the header.
+	file.Buf()[16] ^= 0xff
+
+	var b bytes.Buffer
+	ctx := WithDiagnostics(context.Background(), &b)
+	if err := ExecuteCLIContext(ctx, f, []uefi.Visitor{&Validate{}}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("got %v, want %v", err, ErrValidation)
+	}
+	offsets, err := nodeOffsets(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var d Diagnostic
+		if err := json.Unmarshal([]byte(line), &d); err != nil {
+			t.Fatalf("%v in %q", err, line)
+		}
+		if d.Code != "validate.file" || !strings.Contains(d.Msg, "header checksum failure") {
+			continue
+		}
+		found = true
+		if d.Level != "error" || !strings.HasSuffix(d.Path, "/File("+file.Header.GUID.String()+")") {
+			t.Errorf("got %+v, want an error about the file", d)
+		}
+		if d.Offset == nil || *d.Offset != offsets[file] || *d.Offset == 0 {
+			t.Errorf("got offset %v, want %#x", d.Offset, offsets[file])
+		}
+	}
+	if !found {
+		t.Errorf("got diagnostics %s, want the header checksum of the file", b.String())
+	}
+}
+
+func TestDiagnosticsContext(t *testing.T) {
+	if w := DiagnosticsOf(context.Background()); w != nil {
+		t.Errorf("got %v without diagnostics, want nil", w)
+	}
+	var b bytes.Buffer
+	if w := DiagnosticsOf(WithDiagnostics(context.Background(), &b)); w != &b {
+		t.Errorf("got %v, want the writer", w)
+	}
+}
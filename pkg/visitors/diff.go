@@ -0,0 +1,263 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Kinds of DiffEntry.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+	DiffMoved   = "moved"
+)
+
+// DiffEntry is a node which differs between two images.
+type DiffEntry struct {
+	Kind string
+	// Path of the node from the root, in the new image for added nodes and
+	// in the old image otherwise.
+	Path      string
+	OldSize   int    `json:",omitempty"`
+	NewSize   int    `json:",omitempty"`
+	OldSHA256 string `json:",omitempty"`
+	NewSHA256 string `json:",omitempty"`
+}
+
+// Diff compares the image it is applied to, the old image, with a new
+// image, region by region, volume by volume, file by file and section by
+// section.
+//
+// Nodes are told apart from their siblings by their type, their GUID if
+// they have one and their rank among siblings looking the same. Changed
+// nodes are descended into, so changes are reported for the node and all
+// its ancestors. Nodes whose content is the same but which are out of
+// order relatively to their common siblings are moved.
+type Diff struct {
+	Reporter
+
+	// Input
+	// New is the image to compare to. If nil, it is parsed from NewPath.
+	New     uefi.Firmware
+	NewPath string
+	// Hash sets the SHA256 of the nodes in the entries.
+	Hash bool
+	// Optionally write the entries, as JSON if JSON is set.
+	W    io.Writer `json:"-"`
+	JSON bool      `json:"-"`
+
+	// Output
+	Entries []DiffEntry
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Diff) Run(f uefi.Firmware) error {
+	if v.New == nil {
+		var err error
+		if v.New, err = parseImageFile(v.NewPath); err != nil {
+			return err
+		}
+	}
+	v.Entries = []DiffEntry{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W == nil {
+		return nil
+	}
+	if !v.JSON {
+		for _, e := range v.Entries {
+			fmt.Fprintf(v.W, "%-8s %s\n", e.Kind, e.Path)
+		}
+		return nil
+	}
+	b, err := marshalReport(v.Format, v.Entries)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// Visit applies the Diff visitor to any Firmware type.
+func (v *Diff) Visit(f uefi.Firmware) error {
+	v.compare(nil, diffLabel(f), f, v.New)
+	return nil
+}
+
+func (v *Diff) entry(kind string, path []string, old, new uefi.Firmware) DiffEntry {
+	e := DiffEntry{Kind: kind, Path: strings.Join(path, "/")}
+	if old != nil {
+		e.OldSize = len(old.Buf())
+	}
+	if new != nil {
+		e.NewSize = len(new.Buf())
+	}
+	if v.Hash {
+		if old != nil {
+			s := sha256.Sum256(old.Buf())
+			e.OldSHA256 = hex.EncodeToString(s[:])
+		}
+		if new != nil {
+			s := sha256.Sum256(new.Buf())
+			e.NewSHA256 = hex.EncodeToString(s[:])
+		}
+	}
+	return e
+}
+
+// compare reports the differences between old and new, which are at the
+// same place in both images, under the key.
+func (v *Diff) compare(path []string, key string, old, new uefi.Firmware) {
+	if label := diffLabel(new); label != diffLabel(old) {
+		v.Entries = append(v.Entries,
+			v.entry(DiffRemoved, append(path, key), old, nil),
+			v.entry(DiffAdded, append(path, label), nil, new))
+		return
+	}
+	path = append(path, key)
+	if bytes.Equal(old.Buf(), new.Buf()) {
+		return
+	}
+	v.Entries = append(v.Entries, v.entry(DiffChanged, path, old, new))
+
+	oldKeys, oldNodes := diffChildren(old)
+	newKeys, newNodes := diffChildren(new)
+	var common []string
+	for _, k := range oldKeys {
+		if _, ok := newNodes[k]; ok {
+			common = append(common, k)
+		} else {
+			v.Entries = append(v.Entries, v.entry(DiffRemoved, append(path, k), oldNodes[k], nil))
+		}
+	}
+	var newCommon []string
+	for _, k := range newKeys {
+		if _, ok := oldNodes[k]; ok {
+			newCommon = append(newCommon, k)
+		} else {
+			v.Entries = append(v.Entries, v.entry(DiffAdded, append(path, k), nil, newNodes[k]))
+		}
+	}
+	inOrder := lcs(common, newCommon)
+	for _, k := range common {
+		o, n := oldNodes[k], newNodes[k]
+		if !inOrder[k] && bytes.Equal(o.Buf(), n.Buf()) {
+			v.Entries = append(v.Entries, v.entry(DiffMoved, append(path, k), o, n))
+			continue
+		}
+		// Copy the path, so entries of siblings do not share it.
+		v.compare(append([]string{}, path...), k, o, n)
+	}
+}
+
+// diffLabel returns what identifies a node among its siblings. Unlike
+// nodeLabel, it has neither the offset of volumes, which changes whenever
+// something before them does, nor the name and version of sections, so
+// changing them changes the section rather than replacing it.
+func diffLabel(f uefi.Firmware) string {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		return fmt.Sprintf("FirmwareVolume(%v)", f.FVName)
+	case *uefi.Section:
+		return fmt.Sprintf("Section(%s)", f.Type)
+	}
+	return nodeLabel(f)
+}
+
+// diffChildren returns the keys of the children of f in order and the
+// children by key. Children with the same label are numbered.
+func diffChildren(f uefi.Firmware) ([]string, map[string]uefi.Firmware) {
+	var keys []string
+	nodes := map[string]uefi.Firmware{}
+	seen := map[string]int{}
+	for _, c := range children(f) {
+		k := diffLabel(c)
+		if n := seen[k]; n != 0 {
+			seen[k]++
+			k = fmt.Sprintf("%s#%d", k, n)
+		} else {
+			seen[k] = 1
+		}
+		keys = append(keys, k)
+		nodes[k] = c
+	}
+	return keys, nodes
+}
+
+// parseImageFile reads and parses the image at path.
+func parseImageFile(path string) (uefi.Firmware, error) {
+	if path == "" {
+		return nil, errors.New("no image path")
+	}
+	image, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return uefi.Parse(image)
+}
+
+// lcs returns the set of keys of a longest common subsequence of a and b.
+func lcs(a, b []string) map[string]bool {
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				l[i][j] = l[i+1][j+1] + 1
+			case l[i+1][j] >= l[i][j+1]:
+				l[i][j] = l[i+1][j]
+			default:
+				l[i][j] = l[i][j+1]
+			}
+		}
+	}
+	r := map[string]bool{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			r[a[i]] = true
+			i++
+			j++
+		case l[i+1][j] >= l[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return r
+}
+
+func init() {
+	RegisterCLI("diff", "compare with another image and list added, removed, changed and moved nodes", 1, func(args []string) (uefi.Visitor, error) {
+		return &Diff{
+			NewPath: args[0],
+			W:       os.Stdout,
+		}, nil
+	})
+	RegisterCLI("diff_json", "compare with another image and print the differences and their SHA256 as JSON", 1, func(args []string) (uefi.Visitor, error) {
+		return &Diff{
+			NewPath: args[0],
+			Hash:    true,
+			W:       os.Stdout,
+			JSON:    true,
+		}, nil
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func diffKinds(entries []DiffEntry, suffix string) []string {
+	var kinds []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Path, suffix) {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	return kinds
+}
+
+func TestDiffSame(t *testing.T) {
+	v := &Diff{New: parseImage(t)}
+	if err := v.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Entries) != 0 {
+		t.Errorf("got %+v, want no differences", v.Entries)
+	}
+}
+
+func TestDiffRemoved(t *testing.T) {
+	old, new := parseImage(t), parseImage(t)
+	if err := (&Remove{Predicate: FindFileGUIDPredicate(*testGUID)}).Run(new); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(new); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &Diff{New: new, Hash: true, W: &b, JSON: true}
+	if err := v.Run(old); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := diffKinds(v.Entries, "/File("+testGUID.String()+")"); !reflect.DeepEqual(kinds, []string{DiffRemoved}) {
+		t.Errorf("got %v for the removed file, want [removed]", kinds)
+	}
+	root := v.Entries[0]
+	if root.Kind != DiffChanged || root.Path != "BIOSRegion" || root.OldSHA256 == "" || root.OldSHA256 == root.NewSHA256 {
+		t.Errorf("got %+v as first entry, want the changed root with hashes", root)
+	}
+	for _, e := range v.Entries {
+		if e.Kind == DiffAdded || e.Kind == DiffMoved {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+
+	var entries []DiffEntry
+	if err := json.Unmarshal(b.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, v.Entries) {
+		t.Errorf("JSON output does not match the entries")
+	}
+
+	// The other way around, the file is added.
+	v = &Diff{New: old}
+	if err := v.Run(new); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := diffKinds(v.Entries, "/File("+testGUID.String()+")"); !reflect.DeepEqual(kinds, []string{DiffAdded}) {
+		t.Errorf("got %v for the added file, want [added]", kinds)
+	}
+}
+
+func TestDiffMoved(t *testing.T) {
+	old, new := parseImage(t), parseImage(t)
+	q, err := ParseQuery("//FV[guid=" + dxeFVGUID(t, new) + "]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv := q.Select(new)[0].(*uefi.FirmwareVolume)
+	var i int
+	for i = range fv.Files {
+		if fv.Files[i].Header.GUID == *dxeCoreGUID {
+			break
+		}
+	}
+	fv.Files[i], fv.Files[i+1] = fv.Files[i+1], fv.Files[i]
+	moved := fv.Files[i].Header.GUID
+	if err := (&Assemble{}).Run(new); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &Diff{New: new, W: &b}
+	if err := v.Run(old); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range v.Entries {
+		if e.Kind == DiffMoved {
+			got = append(got, e.Path)
+		}
+	}
+	if len(got) != 1 || (!strings.HasSuffix(got[0], dxeCoreGUID.String()+")") && !strings.HasSuffix(got[0], moved.String()+")")) {
+		t.Errorf("got moved %v, want DxeCore or its neighbour", got)
+	}
+	if !strings.Contains(b.String(), "moved ") {
+		t.Errorf("got output %q, want the moved file", b.String())
+	}
+}
+
+// dxeFVGUID returns the GUID of the volume holding DxeCore.
+func dxeFVGUID(t *testing.T, f uefi.Firmware) string {
+	q, err := ParseQuery("//FV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range q.Select(f) {
+		for _, file := range n.(*uefi.FirmwareVolume).Files {
+			if file.Header.GUID == *dxeCoreGUID {
+				return n.(*uefi.FirmwareVolume).FVName.String()
+			}
+		}
+	}
+	t.Fatal("no volume holds DxeCore")
+	return ""
+}
+
+func TestLCS(t *testing.T) {
+	got := lcs([]string{"a", "b", "c", "d"}, []string{"a", "c", "b", "d"})
+	if len(got) != 3 || !got["a"] || !got["d"] {
+		t.Errorf("got %v, want a, d and one of b and c", got)
+	}
+}
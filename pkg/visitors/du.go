@@ -0,0 +1,149 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// DuEntry is the size of a node and of its children.
+type DuEntry struct {
+	Node string
+	Name string `json:",omitempty"`
+	// Size is the size of the node in its parent, compressed if the
+	// parent is.
+	Size int
+	// UncompressedSize is the size of the node with all the sections it
+	// holds decompressed.
+	UncompressedSize int
+	// Percent is the part of the uncompressed size of the parent.
+	Percent  float64
+	Children []*DuEntry `json:",omitempty"`
+}
+
+// Du reports the size of every node, like du does for files, with the
+// children of each node sorted by decreasing size.
+type Du struct {
+	Reporter
+
+	// Depth limits the levels of nodes reported, the node the visitor is
+	// applied to being the first. Depth <= 0 means all.
+	Depth int
+	// Optionally write the report, as a tree of text or in the output
+	// format.
+	W io.Writer `json:"-"`
+
+	// Output
+	Root *DuEntry
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Du) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != FormatDefault {
+		b, err := marshalReport(v.Format, v.Root)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Size\tUncompressed\tParent\t\n")
+	var print func(e *DuEntry, depth int)
+	print = func(e *DuEntry, depth int) {
+		name := e.Node
+		if e.Name != "" {
+			name += " " + e.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%5.1f%%\t  %s%s\n", humanize.IBytes(uint64(e.Size)),
+			humanize.IBytes(uint64(e.UncompressedSize)), e.Percent, indent(2*depth), name)
+		for _, c := range e.Children {
+			print(c, depth+1)
+		}
+	}
+	print(v.Root, 0)
+	return w.Flush()
+}
+
+// Visit applies the Du visitor to any Firmware type.
+func (v *Du) Visit(f uefi.Firmware) error {
+	v.Root = v.entry(f, 1)
+	v.Root.Percent = 100
+	return nil
+}
+
+// entry returns the entry of f, at the depth.
+func (v *Du) entry(f uefi.Firmware, depth int) *DuEntry {
+	e := &DuEntry{Node: nodeLabel(f), Size: len(f.Buf())}
+	if file, ok := f.(*uefi.File); ok {
+		e.Name = fileName(file)
+	}
+
+	var cs []*DuEntry
+	for _, c := range children(f) {
+		cs = append(cs, v.entry(c, depth+1))
+	}
+	if s, ok := f.(*uefi.Section); ok && duCompressed(s) {
+		// The children are the decompressed data, aligned like Assemble.
+		e.UncompressedSize = int(s.TypeSpecific.Header.(*uefi.SectionGUIDDefined).DataOffset)
+		var data int
+		for _, c := range cs {
+			data = int(uefi.Align4(uint64(data))) + c.UncompressedSize
+		}
+		e.UncompressedSize += data
+	} else {
+		e.UncompressedSize = e.Size
+		for _, c := range cs {
+			e.UncompressedSize += c.UncompressedSize - c.Size
+		}
+	}
+
+	for _, c := range cs {
+		if e.UncompressedSize != 0 {
+			c.Percent = 100 * float64(c.UncompressedSize) / float64(e.UncompressedSize)
+		}
+	}
+	if v.Depth <= 0 || depth < v.Depth {
+		sort.SliceStable(cs, func(i, j int) bool { return cs[i].Size > cs[j].Size })
+		e.Children = cs
+	}
+	return e
+}
+
+// duCompressed returns true if the section holds its children compressed.
+func duCompressed(s *uefi.Section) bool {
+	if s.Header.Type != uefi.SectionTypeGUIDDefined || s.TypeSpecific == nil || len(s.Encapsulated) == 0 {
+		return false
+	}
+	h, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+	return ok && h.Attributes&uint16(uefi.GUIDEDSectionProcessingRequired) != 0
+}
+
+func init() {
+	RegisterCLI("du", "print the size, uncompressed size and part of the parent of every node, largest first", 0, func(args []string) (uefi.Visitor, error) {
+		return &Du{W: os.Stdout}, nil
+	})
+	RegisterCLI("du_depth", "like du, for the nodes up to a depth", 1, func(args []string) (uefi.Visitor, error) {
+		d, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("du_depth: bad depth %q: %v", args[0], err)
+		}
+		return &Du{W: os.Stdout, Depth: d}, nil
+	})
+}
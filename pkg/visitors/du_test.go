@@ -0,0 +1,61 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDu(t *testing.T) {
+	f := parseImage(t)
+	du := &Du{}
+	if err := du.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if du.Root.Size != len(f.Buf()) || du.Root.Percent != 100 {
+		t.Errorf("root: got size %d, percent %v, want %d, 100", du.Root.Size, du.Root.Percent, len(f.Buf()))
+	}
+	// The DXE volume is compressed, so the image is larger decompressed.
+	if du.Root.UncompressedSize <= du.Root.Size {
+		t.Errorf("uncompressed size %d not larger than size %d", du.Root.UncompressedSize, du.Root.Size)
+	}
+
+	var check func(e *DuEntry)
+	check = func(e *DuEntry) {
+		var percent float64
+		for i, c := range e.Children {
+			if i > 0 && c.Size > e.Children[i-1].Size {
+				t.Errorf("%s: children not sorted by size", e.Node)
+			}
+			percent += c.Percent
+			check(c)
+		}
+		// Headers and padding take the rest.
+		if percent > 100.001 {
+			t.Errorf("%s: children take %v%% of it", e.Node, percent)
+		}
+	}
+	check(du.Root)
+}
+
+func TestDuDepth(t *testing.T) {
+	f := parseImage(t)
+	var b bytes.Buffer
+	du := &Du{Depth: 2, W: &b}
+	if err := du.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range du.Root.Children {
+		if len(c.Children) != 0 {
+			t.Errorf("%s: got children beyond depth 2", c.Node)
+		}
+	}
+	// A header and a line per node.
+	if got, want := strings.Count(b.String(), "\n"), 2+len(du.Root.Children); got != want {
+		t.Errorf("got %d lines, want %d:\n%s", got, want, b.String())
+	}
+}
@@ -21,10 +21,21 @@ type Dump struct {
 	// Output
 	// The file is written to this writer.
 	W io.Writer
+	// Or to the file at this path, if W is nil.
+	Path string
 }
 
 // Run just calls the visitor
 func (v *Dump) Run(f uefi.Firmware) error {
+	if v.W == nil {
+		file, err := os.OpenFile(v.Path, os.O_RDWR|os.O_CREATE, 0755)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		v.W = file
+		defer func() { v.W = nil }()
+	}
 	return f.Apply(v)
 }
 
@@ -58,15 +69,9 @@ func init() {
 			return nil, err
 		}
 
-		file, err := os.OpenFile(args[1], os.O_RDWR|os.O_CREATE, 0755)
-		if err != nil {
-			return nil, err
-		}
-
-		// Find all the matching files and replace their inner PE32s.
 		return &Dump{
 			Predicate: pred,
-			W:         file,
+			Path:      args[1],
 		}, nil
 	})
 }
@@ -0,0 +1,82 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// DumpPE writes the executable of a file to a PE32 image, converting TE
+// sections, like the ones of SEC and PEI modules, so PE tools can read them.
+// ReplacePE32 converts the image back to TE when the file has a TE section.
+type DumpPE struct {
+	// Input
+	Predicate FindPredicate
+	Path      string
+
+	// Output
+	Section *uefi.Section
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *DumpPE) Run(f uefi.Firmware) error {
+	match, err := FindExactlyOne(f, v.Predicate)
+	if err != nil {
+		return err
+	}
+
+	v.Section = nil
+	if err := match.Apply(v); err != nil {
+		return err
+	}
+	if v.Section == nil {
+		return errors.New("no PE32 or TE section in the file")
+	}
+	img := v.Section.Buf()[v.Section.HeaderLen():]
+	if v.Section.Header.Type == uefi.SectionTypeTE {
+		pe, err := uefi.TEToPE(img)
+		if err != nil {
+			return err
+		}
+		img = pe
+	}
+	return os.WriteFile(v.Path, img, 0666)
+}
+
+// Visit applies the DumpPE visitor to any Firmware type.
+func (v *DumpPE) Visit(f uefi.Firmware) error {
+	if v.Section != nil {
+		return nil
+	}
+	switch f := f.(type) {
+	case *uefi.File:
+		return f.ApplyChildren(v)
+
+	case *uefi.Section:
+		if f.Header.Type == uefi.SectionTypePE32 || f.Header.Type == uefi.SectionTypeTE {
+			v.Section = f
+			return nil
+		}
+		return f.ApplyChildren(v)
+	}
+	// Must be applied to a File to have any effect.
+	return nil
+}
+
+func init() {
+	RegisterCLI("dump_pe", "dump the pe32 or te section of a file as a pe32 image", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFilePredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &DumpPE{
+			Predicate: pred,
+			Path:      args[1],
+		}, nil
+	})
+}
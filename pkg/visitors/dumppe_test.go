@@ -0,0 +1,79 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// testPE returns an X64 PE32+ image without sections.
+func testPE() []byte {
+	const (
+		pe  = 0x40
+		opt = pe + 4 + 20
+	)
+	buf := make([]byte, 0x200)
+	copy(buf, "MZ")
+	binary.LittleEndian.PutUint32(buf[0x3c:], pe)
+	copy(buf[pe:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(buf[pe+4:], 0x8664)
+	binary.LittleEndian.PutUint16(buf[pe+4+16:], 112+16*8)
+	binary.LittleEndian.PutUint16(buf[opt:], 0x20b)
+	binary.LittleEndian.PutUint32(buf[opt+108:], 16)
+	for i := opt + 112 + 16*8; i < len(buf); i++ {
+		buf[i] = byte(i)
+	}
+	return buf
+}
+
+func TestDumpAndReplaceTE(t *testing.T) {
+	f := parseImage(t)
+	pe := testPE()
+	te, err := uefi.PEToTE(pe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn the PE32 section of the file into a TE one.
+	file := find(t, f, testGUID)[0].(*uefi.File)
+	s := file.Sections[0]
+	if s.Header.Type != uefi.SectionTypePE32 {
+		t.Fatalf("got a %v section, want a PE32 one", s.Header.Type)
+	}
+	s.Header.Type = uefi.SectionTypeTE
+	s.SetBuf(te)
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "image.efi")
+	if err := (&DumpPE{Predicate: FindFileGUIDPredicate(*testGUID), Path: path}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	dumped, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(dumped, []byte("MZ")) || !bytes.Equal(dumped[len(dumped)-len(te)+40:], te[40:]) {
+		t.Errorf("the dumped image is not the PE32 image of the TE section")
+	}
+
+	// A PE32 image replaces the TE section as TE.
+	pe[len(pe)-1] = 0x42
+	if err := (&ReplacePE32{Predicate: FindFileGUIDPredicate(*testGUID), NewPE32: pe}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	s = file.Sections[0]
+	got := s.Buf()[s.HeaderLen():]
+	if s.Header.Type != uefi.SectionTypeTE || !bytes.HasPrefix(got, []byte("VZ")) || got[len(got)-1] != 0x42 {
+		t.Errorf("got a %v section, want the new image converted to TE", s.Header.Type)
+	}
+}
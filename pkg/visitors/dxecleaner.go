@@ -184,7 +184,7 @@ func init() {
 				defer os.RemoveAll(tmpDir)
 				tmpFile := filepath.Join(tmpDir, "bios.bin")
 
-				if err := (&Save{tmpFile}).Run(f); err != nil {
+				if err := (&Save{DirPath: tmpFile}).Run(f); err != nil {
 					return true, err
 				}
 				cmd := exec.CommandContext(ctx, args[0], tmpFile)
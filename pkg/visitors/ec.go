@@ -0,0 +1,64 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoEC = errors.New("no EC firmware found")
+
+// ReplaceEC replaces the first EC firmware found, in the EC region or in
+// BIOS padding, by NewEC. Assemble writes it into the image.
+type ReplaceEC struct {
+	// Input
+	NewEC []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceEC) Run(f uefi.Firmware) error {
+	find := &Find{
+		Predicate: func(f uefi.Firmware) bool {
+			_, ok := f.(*uefi.ECFirmware)
+			return ok
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+	if len(find.Matches) == 0 {
+		return errNoEC
+	}
+	return find.Matches[0].Apply(v)
+}
+
+// Visit applies the ReplaceEC visitor to any Firmware type.
+func (v *ReplaceEC) Visit(f uefi.Firmware) error {
+	ec, ok := f.(*uefi.ECFirmware)
+	if !ok {
+		return errNoEC
+	}
+	if vendor := uefi.DetectEC(v.NewEC); vendor != ec.Vendor {
+		return fmt.Errorf("new EC firmware is not %v firmware", ec.Vendor)
+	}
+	ec.SetBuf(v.NewEC)
+	return nil
+}
+
+func init() {
+	RegisterCLI("replace_ec", "replace the EC firmware", 1, func(args []string) (uefi.Visitor, error) {
+		newEC, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceEC{
+			NewEC: newEC,
+		}, nil
+	})
+}
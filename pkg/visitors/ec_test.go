@@ -0,0 +1,63 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestReplaceEC(t *testing.T) {
+	buf := ifdImage(t)
+	// EC region at 0x3000 holding Nuvoton firmware.
+	binary.LittleEndian.PutUint16(buf[0x44+4*int(uefi.RegionTypeEC):], 3)
+	binary.LittleEndian.PutUint16(buf[0x46+4*int(uefi.RegionTypeEC):], 4)
+	copy(buf[0x3000:], uefi.NuvotonECSignature)
+	copy(buf[0x3000+len(uefi.NuvotonECSignature):], "old firmware")
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	if err := (&Table{W: w, printRow: printRowStd}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	if !strings.Contains(b.String(), "  EC  ") || !strings.Contains(b.String(), " Nuvoton ") {
+		t.Errorf("Table: no Nuvoton EC firmware in\n%s", b.String())
+	}
+
+	newEC := append(append([]byte{}, uefi.NuvotonECSignature...), "new"...)
+	if err := (&ReplaceEC{NewEC: newEC}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{}, buf...)
+	copy(want[0x3000:0x5000], bytes.Repeat([]byte{0xff}, 0x2000))
+	copy(want[0x3000:], newEC)
+	if !bytes.Equal(f.Buf(), want) {
+		t.Errorf("replace_ec: image differs from the expected one")
+	}
+
+	if err := (&ReplaceEC{NewEC: []byte("not EC firmware")}).Run(f); err == nil {
+		t.Errorf("replace_ec with unknown firmware: got nil, want error")
+	}
+	f, err = uefi.Parse(ifdImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&ReplaceEC{NewEC: newEC}).Run(f); err != errNoEC {
+		t.Errorf("replace_ec without EC: got %v, want %v", err, errNoEC)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// HighEntropy is the entropy, in bits per byte, above which data is most
+// likely compressed or encrypted.
+const HighEntropy = 7.2
+
+// EntropyNode is the entropy of the buffer of a node.
+type EntropyNode struct {
+	Depth   int
+	Type    string
+	Name    string `json:",omitempty"`
+	Size    int
+	Entropy float64
+}
+
+// EntropyWindow is the entropy and compression ratio of a window of the
+// image.
+type EntropyWindow struct {
+	Offset  uint64
+	Entropy float64
+	// Ratio is the size of the window compressed with DEFLATE over its
+	// size. Data which is already compressed or encrypted is close to 1.
+	Ratio float64
+	High  bool
+}
+
+// Entropy computes the Shannon entropy of every node and of every window
+// of WindowSize bytes of the image, to find compressed or encrypted blobs
+// the parser does not know.
+type Entropy struct {
+	Cancelable
+	Reporter
+
+	// Input
+	WindowSize uint64
+	// Optionally write the report, as JSON or, if CSV is set, as CSV.
+	W   io.Writer `json:"-"`
+	CSV bool      `json:"-"`
+
+	// Output
+	Nodes   []EntropyNode
+	Windows []EntropyWindow
+
+	depth int
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Entropy) Run(f uefi.Firmware) error {
+	if v.WindowSize == 0 {
+		return errors.New("window size must not be 0")
+	}
+	v.Nodes = []EntropyNode{}
+	v.Windows = []EntropyWindow{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	buf := f.Buf()
+	for o := uint64(0); o < uint64(len(buf)); o += v.WindowSize {
+		end := o + v.WindowSize
+		if end > uint64(len(buf)) {
+			end = uint64(len(buf))
+		}
+		w := EntropyWindow{
+			Offset:  o,
+			Entropy: shannon(buf[o:end]),
+			Ratio:   deflateRatio(buf[o:end]),
+		}
+		w.High = w.Entropy >= HighEntropy
+		v.Windows = append(v.Windows, w)
+	}
+
+	if v.W == nil {
+		return nil
+	}
+	if v.CSV {
+		return v.writeCSV()
+	}
+	b, err := marshalReport(v.Format, v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// Visit applies the Entropy visitor to any Firmware type.
+func (v *Entropy) Visit(f uefi.Firmware) error {
+	n := EntropyNode{
+		Depth:   v.depth,
+		Type:    strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."),
+		Size:    len(f.Buf()),
+		Entropy: shannon(f.Buf()),
+	}
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		n.Name = f.FVName.String()
+	case *uefi.File:
+		n.Name = f.Header.GUID.String()
+	case *uefi.Section:
+		n.Name = f.String()
+	case *uefi.NVar:
+		n.Name = f.Name
+	}
+	v.Nodes = append(v.Nodes, n)
+
+	v.depth++
+	err := f.ApplyChildren(v)
+	v.depth--
+	return err
+}
+
+// writeCSV writes the nodes then the windows, each with their header.
+func (v *Entropy) writeCSV() error {
+	w := csv.NewWriter(v.W)
+	f := func(x float64) string { return strconv.FormatFloat(x, 'f', 3, 64) }
+	w.Write([]string{"depth", "type", "name", "size", "entropy"})
+	for _, n := range v.Nodes {
+		w.Write([]string{strconv.Itoa(n.Depth), n.Type, n.Name, strconv.Itoa(n.Size), f(n.Entropy)})
+	}
+	w.Write([]string{"offset", "entropy", "ratio", "high"})
+	for _, x := range v.Windows {
+		w.Write([]string{fmt.Sprintf("%#x", x.Offset), f(x.Entropy), f(x.Ratio), strconv.FormatBool(x.High)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// shannon returns the Shannon entropy of buf in bits per byte.
+func shannon(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range buf {
+		counts[b]++
+	}
+	var e float64
+	for _, c := range counts {
+		if c != 0 {
+			p := float64(c) / float64(len(buf))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// deflateRatio returns the size of buf compressed with DEFLATE over the
+// size of buf.
+func deflateRatio(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.BestSpeed)
+	if err != nil {
+		return 0
+	}
+	w.Write(buf)
+	w.Close()
+	return float64(b.Len()) / float64(len(buf))
+}
+
+func parseEntropy(args []string, csv bool) (uefi.Visitor, error) {
+	size, err := strconv.ParseUint(args[0], 0, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Entropy{
+		WindowSize: size,
+		W:          os.Stdout,
+		CSV:        csv,
+	}, nil
+}
+
+func init() {
+	RegisterCLI("entropy", "print the entropy of every node and window of the given size as JSON", 1, func(args []string) (uefi.Visitor, error) {
+		return parseEntropy(args, false)
+	})
+	RegisterCLI("entropy_csv", "print the entropy of every node and window of the given size as CSV", 1, func(args []string) (uefi.Visitor, error) {
+		return parseEntropy(args, true)
+	})
+}
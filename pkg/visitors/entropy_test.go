@@ -0,0 +1,82 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestShannon(t *testing.T) {
+	random := make([]byte, 0x10000)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, tt := range []struct {
+		name string
+		buf  []byte
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"zeros", make([]byte, 100), 0},
+		{"two symbols", []byte("abab"), 1},
+		{"all bytes", func() []byte {
+			b := make([]byte, 256)
+			for i := range b {
+				b[i] = byte(i)
+			}
+			return b
+		}(), 8},
+	} {
+		if got := shannon(tt.buf); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if e, r := shannon(random), deflateRatio(random); e < HighEntropy || r < 0.99 {
+		t.Errorf("random: got entropy %v and ratio %v, want high", e, r)
+	}
+	if r := deflateRatio(make([]byte, 0x10000)); r > 0.01 {
+		t.Errorf("zeros: got ratio %v, want low", r)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	v := &Entropy{WindowSize: 0x10000, W: &b, CSV: true}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if want := (len(f.Buf()) + 0xffff) / 0x10000; len(v.Windows) != want {
+		t.Errorf("got %d windows, want %d", len(v.Windows), want)
+	}
+	if len(v.Nodes) == 0 || v.Nodes[0].Depth != 0 || v.Nodes[0].Size != len(f.Buf()) {
+		t.Errorf("got nodes %+v, want the root first", v.Nodes)
+	}
+	// OVMF compresses its DXE volume.
+	high := false
+	for _, w := range v.Windows {
+		high = high || w.High
+	}
+	if !high {
+		t.Errorf("no window of high entropy")
+	}
+
+	r := csv.NewReader(&b)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(v.Nodes) + len(v.Windows) + 2; len(records) != want {
+		t.Errorf("CSV: got %d records, want %d", len(records), want)
+	}
+
+	if err := (&Entropy{}).Run(f); err == nil {
+		t.Errorf("window size 0: got nil, want error")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ExecutableEntry describes one PE32 or TE section.
+type ExecutableEntry struct {
+	GUID     guid.GUID
+	Name     string `json:",omitempty"`
+	FileType string
+	Type     string
+	// SHA256 is the digest of the image, the body of the section.
+	SHA256 string
+	// Version and BuildNumber are those of the version section of the
+	// file, if any.
+	Version     string `json:",omitempty"`
+	BuildNumber uint16 `json:",omitempty"`
+	uefi.ExecutableInfo
+}
+
+// Executables lists every executable of the image with its hash and build
+// metadata, as an allowlist for measured boot or endpoint monitoring.
+type Executables struct {
+	Cancelable
+	Reporter
+
+	// Optionally write the manifest as JSON.
+	W io.Writer `json:"-"`
+
+	// Output
+	Manifest []ExecutableEntry
+
+	file *uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Executables) Run(f uefi.Firmware) error {
+	v.Manifest = []ExecutableEntry{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		b, err := marshalReport(v.Format, v.Manifest)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	return nil
+}
+
+// Visit applies the Executables visitor to any Firmware type.
+func (v *Executables) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		// Sections of nested volumes belong to their own files.
+		parent := v.file
+		v.file = f
+		err := f.ApplyChildren(v)
+		v.file = parent
+		return err
+
+	case *uefi.Section:
+		if f.Header.Type != uefi.SectionTypePE32 && f.Header.Type != uefi.SectionTypeTE {
+			return f.ApplyChildren(v)
+		}
+		img := f.Buf()[f.HeaderLen():]
+		sum := sha256.Sum256(img)
+		e := ExecutableEntry{Type: f.Type, SHA256: hex.EncodeToString(sum[:])}
+		if v.file != nil {
+			e.GUID = v.file.Header.GUID
+			e.Name = fileName(v.file)
+			e.FileType = v.file.Type
+			for _, s := range v.file.Sections {
+				if s.Header.Type == uefi.SectionTypeVersion {
+					e.Version = s.Version
+					e.BuildNumber = s.BuildNumber
+				}
+			}
+		}
+		info, err := uefi.ParseExecutableInfo(img)
+		if err != nil {
+			log.FromContext(v.Context()).Warnf("build metadata of %v section in %v: %v", e.Type, e.GUID, err)
+		} else {
+			e.ExecutableInfo = *info
+		}
+		v.Manifest = append(v.Manifest, e)
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("executables", "print the GUID, name, type, SHA-256 and build metadata of every PE32 and TE section as JSON", 0, func(args []string) (uefi.Visitor, error) {
+		return &Executables{
+			W: os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecutables(t *testing.T) {
+	f := parseImage(t)
+	v := &Executables{}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	a := &Authenticode{}
+	if err := a.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Manifest) != len(a.Manifest) {
+		t.Errorf("got %d executables, want as many as Authenticode digests, %d", len(v.Manifest), len(a.Manifest))
+	}
+	for _, e := range v.Manifest {
+		if e.Name != "DxeCore" {
+			continue
+		}
+		if e.GUID != *dxeCoreGUID || e.FileType != "EFI_FV_FILETYPE_DXE_CORE" || len(e.SHA256) != 64 {
+			t.Errorf("got %+v", e)
+		}
+		if e.Machine != "X64" || !strings.HasSuffix(e.PDB, "/DxeCore.dll") || e.Version != "1.0" {
+			t.Errorf("got build metadata %+v", e)
+		}
+		return
+	}
+	t.Error("DxeCore not found")
+}
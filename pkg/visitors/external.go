@@ -0,0 +1,150 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ExternalDirEnv is the environment variable holding the directory the
+// tree is extracted to for the command of External.
+const ExternalDirEnv = "FIANO_TREE_DIR"
+
+// External runs a command as an operation, so operations may be written
+// out of tree, in any language. The command reads the JSON of the tree,
+// with the bodies of the nodes, on its standard input. The tree is also
+// extracted to the directory of ExternalDirEnv, like with extract; if the
+// command changes the directory, the tree is read back from it and
+// assembled, replacing the tree the visitor runs on.
+type External struct {
+	Cancelable
+
+	// Command is the command line, the command followed by its arguments.
+	Command []string
+	// Stdout and Stderr of the command, os.Stdout and os.Stderr if nil.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *External) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit runs the command on f.
+func (v *External) Visit(f uefi.Firmware) error {
+	if len(v.Command) == 0 {
+		return fmt.Errorf("external: empty command line")
+	}
+	dir, err := os.MkdirTemp("", "fiano-tree")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var index uint64
+	if err := (&Extract{BasePath: dir, DirPath: ".", Index: &index}).Run(f); err != nil {
+		return err
+	}
+	before, err := hashDir(dir)
+	if err != nil {
+		return err
+	}
+	var tree bytes.Buffer
+	if err := (&JSON{W: &tree, Bodies: true}).Run(f); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(v.Context(), v.Command[0], v.Command[1:]...)
+	cmd.Env = append(os.Environ(), ExternalDirEnv+"="+dir)
+	cmd.Stdin = &tree
+	cmd.Stdout, cmd.Stderr = v.Stdout, v.Stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", v.Command[0], err)
+	}
+
+	after, err := hashDir(dir)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	root, err := (&ParseDir{BasePath: dir}).Parse()
+	if err != nil {
+		return fmt.Errorf("%s: reading the changed tree: %w", v.Command[0], err)
+	}
+	a := &Assemble{}
+	a.SetContext(v.Context())
+	if err := a.Run(root); err != nil {
+		return fmt.Errorf("%s: assembling the changed tree: %w", v.Command[0], err)
+	}
+	dst, src := reflect.ValueOf(f), reflect.ValueOf(root)
+	if dst.Kind() != reflect.Ptr || dst.Type() != src.Type() {
+		return fmt.Errorf("%s: the changed tree is a %T, want a %T", v.Command[0], root, f)
+	}
+	dst.Elem().Set(src.Elem())
+	return nil
+}
+
+// hashDir returns the SHA256 of the names and contents of the files of the
+// directory.
+func hashDir(dir string) ([]byte, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(buf)
+		fmt.Fprintf(h, "%s\x00%x\n", filepath.ToSlash(rel), sum)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// RegisterExternal registers the operation name, which runs the command
+// line with External, appending the numArgs arguments of the operation.
+// Unlike RegisterCLI, it fails rather than panics if the name is taken.
+func RegisterExternal(name string, numArgs int, command []string) error {
+	if _, ok := visitorRegistry[name]; ok {
+		return fmt.Errorf("operation %q is already registered", name)
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("operation %q: empty command line", name)
+	}
+	help := fmt.Sprintf("run the external operation %q", command[0])
+	RegisterCLI(name, help, numArgs, func(args []string) (uefi.Visitor, error) {
+		cmdline := append(append([]string{}, command...), args...)
+		return &External{Command: cmdline}, nil
+	})
+	return nil
+}
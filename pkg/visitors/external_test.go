@@ -0,0 +1,83 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestExternalReadsTree(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	f := parseImage(t)
+	bios := f.(*uefi.BIOSRegion)
+	elem := bios.Elements[0]
+	var out bytes.Buffer
+	v := &External{
+		Command: []string{"sh", "-c", `test -f "$` + ExternalDirEnv + `/summary.json" && cat`},
+		Stdout:  &out,
+	}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var tree struct{ Body string }
+	if err := json.Unmarshal(out.Bytes(), &tree); err != nil || tree.Body == "" {
+		t.Errorf("got %v from the command, want the JSON tree with bodies: %v", tree, err)
+	}
+	if bios.Elements[0] != elem {
+		t.Errorf("the tree was replaced, want it kept since the directory did not change")
+	}
+}
+
+func TestExternalChangesTree(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	f := parseImage(t)
+	bios := f.(*uefi.BIOSRegion)
+	elem := bios.Elements[0]
+	v := &External{Command: []string{"sh", "-c", `touch "$` + ExternalDirEnv + `/note"`}}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if bios.Elements[0] == elem {
+		t.Errorf("the tree was kept, want it read back from the changed directory")
+	}
+	if got := find(t, f, testGUID); len(got) != 1 {
+		t.Errorf("found %d files in the tree read back, want 1", len(got))
+	}
+
+	v = &External{Command: []string{"sh", "-c", `echo x > "$` + ExternalDirEnv + `/summary.json"`}}
+	if err := v.Run(f); err == nil || !strings.Contains(err.Error(), "reading the changed tree") {
+		t.Errorf("got %v, want an error reading the broken tree", err)
+	}
+	if err := (&External{Command: []string{"false"}}).Run(f); err == nil {
+		t.Errorf("running a failing command succeeded, want an error")
+	}
+}
+
+func TestRegisterExternal(t *testing.T) {
+	if err := RegisterExternal("json", 0, []string{"true"}); err == nil {
+		t.Errorf("registering json again succeeded, want an error")
+	}
+	if err := RegisterExternal("test_external", 1, []string{"echo", "-n"}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(visitorRegistry, "test_external")
+	v, err := ParseCLI([]string{"test_external", "arg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v[0].(*External).Command; strings.Join(got, " ") != "echo -n arg" {
+		t.Errorf("got command %q, want the arguments appended", got)
+	}
+}
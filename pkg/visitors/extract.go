@@ -5,11 +5,15 @@
 package visitors
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
@@ -19,11 +23,33 @@ var (
 	remove = flag.Bool("remove", false, "remove existing directory before extracting")
 )
 
+// BlobsDir is the directory of the blobs of content addressed extractions.
+const BlobsDir = "blobs"
+
 // Extract extracts any Firmware node to DirPath
 type Extract struct {
 	BasePath string
 	DirPath  string
 	Index    *uint64
+	// ContentAddressed stores the binaries in BlobsDir, named by their
+	// SHA256, instead of in a directory per node. Identical binaries are
+	// stored once, and the ExtractPath of nodes in summary.json holds the
+	// hash of their binary. ParseDir reads both layouts.
+	ContentAddressed bool
+	// FS is written to instead of BasePath, if set. DirPath is then
+	// relative to the root of FS.
+	FS WriteFS
+
+	// used are the paths written, in lower case.
+	used map[string]bool
+}
+
+// fsys returns the file system to extract to.
+func (v *Extract) fsys() WriteFS {
+	if v.FS != nil {
+		return v.FS
+	}
+	return DirFS(v.BasePath)
 }
 
 // extractBinary simply dumps the binary to a specified directory and filename.
@@ -31,51 +57,127 @@ type Extract struct {
 // It returns the filepath of the binary, and an error if it exists.
 // This is meant as a helper function for other Extract functions.
 func (v *Extract) extractBinary(buf []byte, filename string) (string, error) {
+	if v.ContentAddressed {
+		return v.extractBlob(buf)
+	}
+
 	// Create the directory if it doesn't exist
-	dirPath := filepath.Join(v.BasePath, v.DirPath)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
+	dirPath := path.Clean(v.DirPath)
+	if err := v.fsys().MkdirAll(dirPath, 0755); err != nil {
 		return "", err
 	}
 
 	// Dump the binary.
-	fp := filepath.Join(dirPath, filename)
-	if err := os.WriteFile(fp, buf, 0666); err != nil {
+	fp := v.unique(path.Join(dirPath, safeName(filename)))
+	if err := v.fsys().WriteFile(fp, buf, 0666); err != nil {
 		// Make sure we return "" since we don't want an invalid path to be serialized out.
 		return "", err
 	}
 	// Return only the relative path from the root of the tree
-	return filepath.Join(v.DirPath, filename), nil
+	return fp, nil
+}
+
+// windowsReserved are the names of devices, which Windows does not allow as
+// file names, even with an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// safeName returns name with what Windows does not allow in file names,
+// like control characters, <>:"/\|?*, trailing dots and spaces, and device
+// names, replaced, so extractions work on every system. Nodes keep their
+// original names in summary.json.
+func safeName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c < 0x20 || strings.IndexByte(`<>:"/\|?*`, c) >= 0 {
+			b[i] = '_'
+		}
+	}
+	for i := len(b) - 1; i >= 0 && (b[i] == '.' || b[i] == ' '); i-- {
+		b[i] = '_'
+	}
+	name = string(b)
+	if base := strings.SplitN(name, ".", 2)[0]; windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = "_" + name
+	}
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// unique returns fp, or fp with a number added before its extension if a
+// path differing only in case was already written, since the file systems
+// of Windows and macOS ignore case.
+func (v *Extract) unique(fp string) string {
+	if v.used == nil {
+		return fp
+	}
+	ext := path.Ext(fp)
+	base := strings.TrimSuffix(fp, ext)
+	for n := 1; v.used[strings.ToLower(fp)]; n++ {
+		fp = fmt.Sprintf("%s~%d%s", base, n, ext)
+	}
+	v.used[strings.ToLower(fp)] = true
+	return fp
+}
+
+// extractBlob stores the binary in BlobsDir, unless it already is there,
+// and returns its path relative to the root of the tree.
+func (v *Extract) extractBlob(buf []byte) (string, error) {
+	sum := sha256.Sum256(buf)
+	h := hex.EncodeToString(sum[:])
+	rp := path.Join(BlobsDir, h[:2], h)
+	if _, err := fs.Stat(v.fsys(), rp); err == nil {
+		return rp, nil
+	}
+	if err := v.fsys().MkdirAll(path.Dir(rp), 0755); err != nil {
+		return "", err
+	}
+	if err := v.fsys().WriteFile(rp, buf, 0666); err != nil {
+		return "", err
+	}
+	return rp, nil
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Extract) Run(f uefi.Firmware) error {
-	// Optionally remove directory if it already exists.
-	if *remove {
-		if err := os.RemoveAll(v.BasePath); err != nil {
-			return err
-		}
+	if v.FS == nil && IsArchive(v.BasePath) {
+		return v.runArchive(f)
 	}
 
-	if !*force {
-		// Check that directory does not exist or is empty.
-		files, err := os.ReadDir(v.BasePath)
-		if err == nil {
-			if len(files) != 0 {
-				return errors.New("existing directory not empty, use --force to override")
+	if v.FS == nil {
+		// Optionally remove directory if it already exists.
+		if *remove {
+			if err := os.RemoveAll(v.BasePath); err != nil {
+				return err
 			}
-		} else if !os.IsNotExist(err) {
-			// Error was not EEXIST, we do not know what went wrong.
+		}
+		// Create the directory if it does not exist.
+		if err := os.MkdirAll(v.BasePath, 0755); err != nil {
 			return err
 		}
 	}
 
-	// Create the directory if it does not exist.
-	if err := os.MkdirAll(v.BasePath, 0755); err != nil {
-		return err
+	if !*force {
+		// Check that directory is empty.
+		files, err := fs.ReadDir(v.fsys(), ".")
+		if err != nil {
+			return err
+		}
+		if len(files) != 0 {
+			return errors.New("existing directory not empty, use --force to override")
+		}
 	}
 
-	// Reset the index
+	// Reset the index and the paths written.
 	*v.Index = 0
+	v.used = map[string]bool{}
 	if err := f.Apply(v); err != nil {
 		return err
 	}
@@ -85,11 +187,29 @@ func (v *Extract) Run(f uefi.Firmware) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(v.BasePath, "summary.json"), json, 0666)
+	return v.fsys().WriteFile("summary.json", json, 0666)
+}
+
+// runArchive extracts in memory, then archives the files at BasePath.
+func (v *Extract) runArchive(f uefi.Firmware) error {
+	if _, err := os.Stat(v.BasePath); err == nil && !*force && !*remove {
+		return errors.New("existing archive, use --force to override")
+	}
+	mem := MemFS{}
+	v2 := *v
+	v2.FS = mem
+	if err := v2.Run(f); err != nil {
+		return err
+	}
+	return writeArchive(mem, v.BasePath)
 }
 
 // Visit applies the Extract visitor to any Firmware type.
 func (v *Extract) Visit(f uefi.Firmware) error {
+	// The paths written are shared by all clones.
+	if v.used == nil {
+		v.used = map[string]bool{}
+	}
 	// The visitor must be cloned before modification; otherwise, the
 	// sibling's values are modified.
 	v2 := *v
@@ -98,7 +218,7 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 	switch f := f.(type) {
 
 	case *uefi.FirmwareVolume:
-		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("%#x", f.FVOffset))
+		v2.DirPath = path.Join(v.DirPath, fmt.Sprintf("%#x", f.FVOffset))
 		if len(f.Files) == 0 {
 			f.ExtractPath, err = v2.extractBinary(f.Buf(), "fv.bin")
 		} else {
@@ -107,9 +227,9 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 
 	case *uefi.File:
 		// For files we use the GUID as the folder name.
-		v2.DirPath = filepath.Join(v.DirPath, f.Header.GUID.String())
+		v2.DirPath = path.Join(v.DirPath, f.Header.GUID.String())
 		// Crappy hack to make unique ids unique
-		v2.DirPath = filepath.Join(v2.DirPath, fmt.Sprint(*v.Index))
+		v2.DirPath = path.Join(v2.DirPath, fmt.Sprint(*v.Index))
 		*v.Index++
 		if len(f.Sections) == 0 && f.NVarStore == nil {
 			f.ExtractPath, err = v2.extractBinary(f.Buf(), fmt.Sprintf("%v.ffs", f.Header.GUID))
@@ -117,14 +237,17 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 
 	case *uefi.Section:
 		// For sections we use the file order as the folder name.
-		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprint(f.FileOrder))
-		if len(f.Encapsulated) == 0 {
+		v2.DirPath = path.Join(v.DirPath, fmt.Sprint(f.FileOrder))
+		if len(f.Encapsulated) == 0 || keepsEncoding(f) {
 			f.ExtractPath, err = v2.extractBinary(f.Buf(), fmt.Sprintf("%v.sec", f.FileOrder))
 		}
 
+	case *uefi.OptionROMImage:
+		f.ExtractPath, err = v2.extractBinary(f.Buf(), fmt.Sprintf("%#x-%v.rom", f.Offset, f.PCIR.CodeType))
+
 	case *uefi.NVar:
 		// For NVar we use the GUID as the folder name the Name as file name and add the offset to links to make them unique
-		v2.DirPath = filepath.Join(v.DirPath, f.GUID.String())
+		v2.DirPath = path.Join(v.DirPath, f.GUID.String())
 		if f.IsValid() {
 			if f.NVarStore == nil {
 				if f.Type == uefi.LinkNVarEntry {
@@ -138,26 +261,36 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 		}
 
 	case *uefi.FlashDescriptor:
-		v2.DirPath = filepath.Join(v.DirPath, "ifd")
+		v2.DirPath = path.Join(v.DirPath, "ifd")
 		f.ExtractPath, err = v2.extractBinary(f.Buf(), "flashdescriptor.bin")
 
 	case *uefi.BIOSRegion:
-		v2.DirPath = filepath.Join(v.DirPath, "bios")
+		v2.DirPath = path.Join(v.DirPath, "bios")
 		if len(f.Elements) == 0 {
 			f.ExtractPath, err = v2.extractBinary(f.Buf(), "biosregion.bin")
 		}
 
 	case *uefi.MERegion:
-		v2.DirPath = filepath.Join(v.DirPath, "me")
+		v2.DirPath = path.Join(v.DirPath, "me")
 		f.ExtractPath, err = v2.extractBinary(f.Buf(), "meregion.bin")
 
 	case *uefi.RawRegion:
-		v2.DirPath = filepath.Join(v.DirPath, f.Type().String())
+		v2.DirPath = path.Join(v.DirPath, safeName(f.Type().String()))
 		f.ExtractPath, err = v2.extractBinary(f.Buf(), fmt.Sprintf("%#x.bin", f.FlashRegion().BaseOffset()))
 
 	case *uefi.BIOSPadding:
-		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("biospad_%#x", f.Offset))
+		v2.DirPath = path.Join(v.DirPath, fmt.Sprintf("biospad_%#x", f.Offset))
 		f.ExtractPath, err = v2.extractBinary(f.Buf(), "pad.bin")
+
+	case *uefi.BIOSBackup:
+		v2.DirPath = path.Join(v.DirPath, fmt.Sprintf("biosbackup_%#x", f.Offset))
+		if len(f.Elements) == 0 {
+			f.ExtractPath, err = v2.extractBinary(f.Buf(), "backup.bin")
+		}
+
+	case *uefi.ECFirmware:
+		v2.DirPath = path.Join(v.DirPath, "ec")
+		f.ExtractPath, err = v2.extractBinary(f.Buf(), fmt.Sprintf("%#x.bin", f.Offset))
 	}
 	if err != nil {
 		return err
@@ -175,4 +308,22 @@ func init() {
 			Index:    &fileIndex,
 		}, nil
 	})
+	RegisterCLI("extract_cas", "extract dir\n extract the files to directory `dir`, storing each distinct binary once, named by its SHA256", 1, func(args []string) (uefi.Visitor, error) {
+		return &Extract{
+			BasePath:         args[0],
+			DirPath:          ".",
+			Index:            &fileIndex,
+			ContentAddressed: true,
+		}, nil
+	})
+}
+
+// keepsEncoding returns true for the compressed sections which keep their
+// encoded bytes while unchanged, so they are extracted too.
+func keepsEncoding(s *uefi.Section) bool {
+	if s.TypeSpecific == nil {
+		return false
+	}
+	h, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+	return ok && h.OriginalSHA256 != ""
 }
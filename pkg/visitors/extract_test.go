@@ -5,7 +5,11 @@
 package visitors
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/linuxboot/fiano/pkg/log"
@@ -221,3 +225,124 @@ func TestExtractAssembleSection(t *testing.T) {
 		})
 	}
 }
+
+// extractAndAssemble extracts the image, parses the directory and
+// assembles it, and returns the image and the extracted files.
+func extractAndAssemble(t *testing.T, contentAddressed bool) ([]byte, []string) {
+	tmpDir, err := os.MkdirTemp("", "extract-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var fIndex uint64
+	v := &Extract{BasePath: tmpDir, DirPath: ".", Index: &fIndex, ContentAddressed: contentAddressed}
+	if err := v.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	err = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() != "summary.json" {
+			files = append(files, strings.TrimPrefix(path, tmpDir+string(filepath.Separator)))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := (&ParseDir{BasePath: tmpDir}).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{Options: &AssembleOptions{Deterministic: true}}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	return f.Buf(), files
+}
+
+func TestExtractContentAddressed(t *testing.T) {
+	want, files := extractAndAssemble(t, false)
+	got, blobs := extractAndAssemble(t, true)
+	if !bytes.Equal(got, want) {
+		t.Errorf("the content addressed extraction assembles to a different image")
+	}
+	if len(blobs) == 0 || len(blobs) >= len(files) {
+		t.Errorf("got %d blobs for %d files, want identical files stored once", len(blobs), len(files))
+	}
+	for _, b := range blobs {
+		if filepath.Dir(filepath.Dir(b)) != BlobsDir || len(filepath.Base(b)) != 64 {
+			t.Errorf("blob %q is not named by its SHA256 in %s", b, BlobsDir)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	for _, test := range []struct {
+		name, want string
+	}{
+		{"Setup.bin", "Setup.bin"},
+		{`a<b>c:d"e/f\g|h?i*j.bin`, "a_b_c_d_e_f_g_h_i_j.bin"},
+		{"tab\there.bin", "tab_here.bin"},
+		{"dots..", "dots__"},
+		{"space ", "space_"},
+		{"CON.bin", "_CON.bin"},
+		{"com1", "_com1"},
+		{"Lpt9 .bin", "_Lpt9 .bin"},
+		{"CONSOLE.bin", "CONSOLE.bin"},
+		{"", "_"},
+	} {
+		if got := safeName(test.name); got != test.want {
+			t.Errorf("safeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExtractCaseCollisions(t *testing.T) {
+	mem := MemFS{}
+	v := &Extract{DirPath: "nvars", FS: mem, used: map[string]bool{}}
+	var got []string
+	for _, n := range []string{"Setup.bin", "SETUP.bin", "setup.bin", "Setup:1.bin", "Setup_1.bin"} {
+		p, err := v.extractBinary([]byte(n), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p)
+	}
+	want := []string{"nvars/Setup.bin", "nvars/SETUP~1.bin", "nvars/setup~2.bin", "nvars/Setup_1.bin", "nvars/Setup_1~1.bin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got paths %q, want %q", got, want)
+	}
+	for _, p := range got {
+		if _, ok := mem[p]; !ok {
+			t.Errorf("%s was not written", p)
+		}
+	}
+}
+
+func TestExtractNVarRoundTrip(t *testing.T) {
+	f, err := (&ParseDir{BasePath: "../../integration/roms/nvartest/"}).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{}, f.Buf()...)
+
+	mem := MemFS{}
+	var fIndex uint64
+	if err := (&Extract{DirPath: ".", Index: &fIndex, FS: mem}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	g, err := (&ParseDir{FS: mem}).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(g); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(g.Buf(), want) {
+		t.Errorf("the extracted NVRAM assembles to a different volume")
+	}
+}
@@ -5,7 +5,6 @@
 package visitors
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -22,6 +21,9 @@ type FindPredicate = func(f uefi.Firmware) bool
 
 // Find a firmware file given its name or GUID.
 type Find struct {
+	Cancelable
+	Reporter
+
 	// Input
 	// Only when this functions returns true will the file appear in the
 	// `Matches` slice.
@@ -43,7 +45,7 @@ func (v *Find) Run(f uefi.Firmware) error {
 		return err
 	}
 	if v.W != nil {
-		b, err := json.MarshalIndent(v.Matches, "", "\t")
+		b, err := marshalReport(v.Format, v.Matches)
 		if err != nil {
 			log.Fatalf("%v", err)
 		}
@@ -116,7 +118,11 @@ func FindFileTypePredicate(t uefi.FVFileType) FindPredicate {
 }
 
 // FindFilePredicate is a generic predicate for searching files and UI sections only.
+// If r starts with "/", it is a Query instead.
 func FindFilePredicate(r string) (func(f uefi.Firmware) bool, error) {
+	if len(r) > 0 && r[0] == '/' {
+		return FindQueryPredicate(r)
+	}
 	ciRE, err := regexp.Compile("^(?i)(" + r + ")$")
 	if err != nil {
 		return nil, err
@@ -152,6 +158,19 @@ func FindFilePredicateSize(r string, size uint64) (func(f uefi.Firmware) bool, e
 	}, nil
 }
 
+// FindFVPredicate is a generic predicate for searching FVs by their name GUID,
+// or by their file system GUID if they have no name.
+func FindFVPredicate(r string) (FindPredicate, error) {
+	ciRE, err := regexp.Compile("^(?i)(" + r + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return func(f uefi.Firmware) bool {
+		fv, ok := f.(*uefi.FirmwareVolume)
+		return ok && ciRE.MatchString(fv.String())
+	}, nil
+}
+
 // FindFileFVPredicate is a generic predicate for searching FVs, files and UI sections.
 func FindFileFVPredicate(r string) (func(f uefi.Firmware) bool, error) {
 	ciRE, err := regexp.Compile("^(?i)" + r + "$")
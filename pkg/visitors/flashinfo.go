@@ -0,0 +1,108 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// FlashInfo prints the flash chips described by the descriptor: their
+// sizes, frequencies, forbidden opcodes and VSCC table.
+type FlashInfo struct {
+	W io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *FlashInfo) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the FlashInfo visitor to any Firmware type.
+func (v *FlashInfo) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return errNoFlashImage
+	}
+	fd := &fi.IFD
+	c, err := fd.Component()
+	if err != nil {
+		return err
+	}
+	p := &c.Params
+	n := fd.NumberOfComponents()
+	fmt.Fprintf(v.W, "Flash chips: %d\n", n)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(v.W, "Chip %d: density %#x, %d bytes\n", i, p.ChipDensity(i), p.ChipSize(i))
+	}
+	freq := func(f uefi.FlashFrequency) string {
+		if s, ok := uefi.FlashFrequencyStringMap[f]; ok {
+			return s
+		}
+		return fmt.Sprintf("unknown (%d)", f)
+	}
+	fmt.Fprintf(v.W, "Read clock: %s\n", freq(p.ReadClockFrequency()))
+	fmt.Fprintf(v.W, "Fast read: %v, %s\n", p.FastReadEnabled() != 0, freq(p.FastReadFrequency()))
+	fmt.Fprintf(v.W, "Write and erase clock: %s\n", freq(p.FlashWriteFrequency()))
+	fmt.Fprintf(v.W, "Read ID and status clock: %s\n", freq(p.FlashReadStatusFrequency()))
+	fmt.Fprintf(v.W, "Dual output fast read: %v\n", p.DualOutputFastReadSupported() != 0)
+	fmt.Fprintf(v.W, "Invalid instructions: % x\n", c.InvalidInstructions)
+	fmt.Fprintf(v.W, "Partition boundary: %#x\n", c.PartitionBoundary)
+
+	vscc, err := fd.VSCCTable()
+	if err != nil {
+		return err
+	}
+	for _, e := range vscc {
+		fmt.Fprintf(v.W, "VSCC: %v\n", &e)
+	}
+	return nil
+}
+
+// SetChipSize sets the density of a flash chip in the descriptor, e.g.
+// when moving an image to a larger chip.
+type SetChipSize struct {
+	// Input
+	Chip int
+	Size uint64
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetChipSize) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the SetChipSize visitor to any Firmware type.
+func (v *SetChipSize) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return errNoFlashImage
+	}
+	return fi.IFD.SetChipSize(v.Chip, v.Size)
+}
+
+func init() {
+	RegisterCLI("flash_info", "print the flash chip parameters and VSCC table of the descriptor", 0, func(args []string) (uefi.Visitor, error) {
+		return &FlashInfo{W: os.Stdout}, nil
+	})
+	RegisterCLI("set_chip_size", "set the size in bytes of flash chip 0 or 1 in the descriptor, 0 if not present", 2, func(args []string) (uefi.Visitor, error) {
+		chip, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &SetChipSize{
+			Chip: chip,
+			Size: size,
+		}, nil
+	})
+}
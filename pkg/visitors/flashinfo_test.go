@@ -0,0 +1,41 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestFlashInfo(t *testing.T) {
+	buf := ifdImage(t)
+	// One 8MiB chip at 17MHz.
+	copy(buf[0x30:], []byte{0xf4, 0x00, 0x0c, 0x00})
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&SetChipSize{Chip: 0, Size: 16 << 20}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if f.Buf()[0x30] != 0xf5 {
+		t.Errorf("SetChipSize: got FLCOMP density %#x, want 0xf5", f.Buf()[0x30])
+	}
+
+	var b strings.Builder
+	if err := (&FlashInfo{W: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Flash chips: 1\n", "Chip 0: density 0x5, 16777216 bytes\n", "Read clock: 17MHz\n"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("FlashInfo: %q not in\n%s", s, b.String())
+		}
+	}
+}
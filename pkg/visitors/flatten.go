@@ -5,7 +5,6 @@
 package visitors
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -17,6 +16,8 @@ import (
 // Each node contains the index of its parent (the root node's parent is
 // itself). This format is suitable for insertion into a database.
 type Flatten struct {
+	Reporter
+
 	// Optionally write result as JSON.
 	W io.Writer
 
@@ -60,7 +61,7 @@ func (v *Flatten) Run(f uefi.Firmware) error {
 
 	// Optionally print as JSON
 	if v.W != nil {
-		b, err := json.MarshalIndent(v.List, "", "\t")
+		b, err := marshalReport(v.Format, v.List)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,237 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats of the reports of the visitors.
+const (
+	// FormatDefault is the usual output of each visitor: JSON for most,
+	// text for table and validate.
+	FormatDefault = ""
+	FormatJSON    = "json"
+	FormatYAML    = "yaml"
+	FormatTOML    = "toml"
+)
+
+// CheckFormat returns an error if format is not one of the output formats.
+func CheckFormat(format string) error {
+	switch format {
+	case FormatDefault, FormatJSON, FormatYAML, FormatTOML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, want json, yaml or toml", format)
+}
+
+// Reporter is embedded by the visitors writing reports, for the format of
+// their reports. Extracted manifests are JSON whatever the format, since
+// ParseDir reads them back.
+type Reporter struct {
+	// Format is one of the output formats. ExecuteCLIContext sets the
+	// format of its context on visitors which have FormatDefault.
+	Format string `json:"-"`
+}
+
+// defaultFormat sets the format of the reports if none is set.
+func (r *Reporter) defaultFormat(format string) {
+	if r.Format == FormatDefault {
+		r.Format = format
+	}
+}
+
+type outputFormatKey struct{}
+
+// WithOutputFormat returns a context whose visitors write their reports in
+// format.
+func WithOutputFormat(ctx context.Context, format string) context.Context {
+	return context.WithValue(ctx, outputFormatKey{}, format)
+}
+
+// OutputFormatOf returns the output format of the visitors of ctx,
+// FormatDefault if it has none.
+func OutputFormatOf(ctx context.Context) string {
+	format, _ := ctx.Value(outputFormatKey{}).(string)
+	return format
+}
+
+// marshalReport marshals v in format. v is converted through JSON, so its
+// JSON tags and MarshalJSON methods apply in every format.
+func marshalReport(format string, v interface{}) ([]byte, error) {
+	if err := CheckFormat(format); err != nil {
+		return nil, err
+	}
+	if format == FormatDefault || format == FormatJSON {
+		return json.MarshalIndent(v, "", "\t")
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatYAML {
+		return marshalYAML(b)
+	}
+	return marshalTOML(b)
+}
+
+// orderedObject is a JSON object which keeps the order of its members, so
+// fields come out in the order of the structs.
+type orderedObject []orderedMember
+
+type orderedMember struct {
+	Key   string
+	Value interface{}
+}
+
+// decodeOrdered decodes JSON into orderedObject, []interface{}, string,
+// json.Number, bool and nil.
+func decodeOrdered(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return decodeOrderedValue(d)
+}
+
+func decodeOrderedValue(d *json.Decoder) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		o := orderedObject{}
+		for d.More() {
+			k, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeOrderedValue(d)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, orderedMember{k.(string), v})
+		}
+		_, err := d.Token()
+		return o, err
+	case json.Delim('['):
+		a := []interface{}{}
+		for d.More() {
+			v, err := decodeOrderedValue(d)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err := d.Token()
+		return a, err
+	}
+	return t, nil
+}
+
+// marshalYAML converts JSON to YAML. JSON is YAML in flow style, so it is
+// parsed as YAML, which keeps the order of the members and the digits of
+// the numbers, and written back in block style.
+func marshalYAML(b []byte) ([]byte, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	blockStyle(&n)
+	var buf bytes.Buffer
+	e := yaml.NewEncoder(&buf)
+	e.SetIndent(2)
+	if err := e.Encode(&n); err != nil {
+		return nil, err
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// blockStyle clears the flow style and quotes of n and its children, so
+// the encoder only quotes the strings which need it.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// marshalTOML converts JSON to TOML. TOML documents are tables, so other
+// values become the Items of one, and TOML has no null, so null members
+// and elements are left out.
+func marshalTOML(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	v, err := tomlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		o = map[string]interface{}{"Items": v}
+	}
+	var buf bytes.Buffer
+	e := toml.NewEncoder(&buf)
+	e.Indent = ""
+	if err := e.Encode(o); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// tomlValue converts the numbers of decoded JSON to the integers and floats
+// of TOML, and drops nulls.
+func tomlValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if _, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			// TOML integers are 64-bit signed, keep the digits.
+			return string(v), nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+				continue
+			}
+			e, err := tomlValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+	case []interface{}:
+		a := v[:0]
+		for _, e := range v {
+			if e == nil {
+				continue
+			}
+			e, err := tomlValue(e)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, e)
+		}
+		return a, nil
+	}
+	return v, nil
+}
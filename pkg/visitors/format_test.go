@@ -0,0 +1,136 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"gopkg.in/yaml.v3"
+)
+
+type formatInner struct {
+	GUID string
+	Size uint64
+}
+
+type formatReport struct {
+	Name    string
+	Empty   bool
+	Note    string
+	Missing *formatInner
+	Tags    []string
+	None    []string
+	Inner   formatInner
+	Files   []formatInner
+}
+
+var sampleReport = formatReport{
+	Name:  "DxeCore",
+	Note:  "yes: \"quoted\"\n",
+	Tags:  []string{"a", "true", "0x10", "- b", "#c"},
+	None:  []string{},
+	Inner: formatInner{GUID: "D6A2CB7F-6A18-4E2F-B43B-9920A733700A", Size: 16},
+	Files: []formatInner{{GUID: "1", Size: 1}, {GUID: "2", Size: 0xFFFFFFFFFFFFFFF0}},
+}
+
+func TestMarshalReportYAML(t *testing.T) {
+	b, err := marshalReport(FormatYAML, sampleReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// JSON is YAML, so both documents decode to the same values.
+	j, err := json.Marshal(sampleReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatalf("%v in:\n%s", err, b)
+	}
+	if err := yaml.Unmarshal(j, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v from:\n%s", got, want, b)
+	}
+	// Members come in the order of the fields, in block style.
+	if !strings.HasPrefix(string(b), "Name: DxeCore\nEmpty: false\n") || strings.Contains(string(b), "{") {
+		t.Errorf("got:\n%s\nwant the fields in order, in block style", b)
+	}
+}
+
+func TestMarshalReportTOML(t *testing.T) {
+	b, err := marshalReport(FormatTOML, sampleReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if _, err := toml.Decode(string(b), &got); err != nil {
+		t.Fatalf("%v in:\n%s", err, b)
+	}
+	want := map[string]interface{}{
+		"Name":  "DxeCore",
+		"Empty": false,
+		"Note":  "yes: \"quoted\"\n",
+		"Tags":  []interface{}{"a", "true", "0x10", "- b", "#c"},
+		"None":  []interface{}{},
+		"Inner": map[string]interface{}{"GUID": "D6A2CB7F-6A18-4E2F-B43B-9920A733700A", "Size": int64(16)},
+		// TOML integers are signed, so larger ones are strings.
+		"Files": []map[string]interface{}{
+			{"GUID": "1", "Size": int64(1)},
+			{"GUID": "2", "Size": "18446744073709551600"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v from:\n%s", got, want, b)
+	}
+}
+
+func TestMarshalReportTOMLArray(t *testing.T) {
+	b, err := marshalReport(FormatTOML, []interface{}{"a", nil, 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Items []interface{} }
+	if _, err := toml.Decode(string(b), &got); err != nil {
+		t.Fatalf("%v in:\n%s", err, b)
+	}
+	if want := []interface{}{"a", 1.5}; !reflect.DeepEqual(got.Items, want) {
+		t.Errorf("got %v, want %v", got.Items, want)
+	}
+}
+
+func TestMarshalReportUnknown(t *testing.T) {
+	if err := CheckFormat("xml"); err == nil {
+		t.Errorf("checking the xml output format succeeded, want an error")
+	}
+	if _, err := marshalReport("xml", sampleReport); err == nil {
+		t.Errorf("marshalling as xml succeeded, want an error")
+	}
+}
+
+func TestOutputFormatContext(t *testing.T) {
+	ctx := WithOutputFormat(context.Background(), FormatYAML)
+	var b, c bytes.Buffer
+	// The format of the visitor is kept.
+	v := []*Validate{{W: &b}, {W: &c, Reporter: Reporter{Format: FormatJSON}}}
+	if err := ExecuteCLIContext(ctx, parseImage(t), []uefi.Visitor{v[0], v[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(b.String()); got != "Errors: []" {
+		t.Errorf("got %q, want an empty list of errors in YAML", got)
+	}
+	var report struct{ Errors []string }
+	if err := json.Unmarshal(c.Bytes(), &report); err != nil {
+		t.Errorf("got %q, want JSON: %v", c.String(), err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing/fstest"
+)
+
+// WriteFS is a file system Extract can write to. Names are slash separated
+// and relative to the root of the file system, like for fs.FS.
+type WriteFS interface {
+	fs.FS
+	// MkdirAll creates a directory and its parents.
+	MkdirAll(name string, perm fs.FileMode) error
+	// WriteFile creates or truncates a file and writes data to it.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// DirFS is the WriteFS of the directory tree rooted at the directory.
+type DirFS string
+
+func (d DirFS) join(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+// Open implements fs.FS.
+func (d DirFS) Open(name string) (fs.File, error) {
+	return os.DirFS(string(d)).Open(name)
+}
+
+// MkdirAll implements WriteFS.
+func (d DirFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := d.join(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// WriteFile implements WriteFS.
+func (d DirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := d.join(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, perm)
+}
+
+// MemFS is a WriteFS in memory.
+type MemFS fstest.MapFS
+
+// Open implements fs.FS.
+func (m MemFS) Open(name string) (fs.File, error) {
+	return fstest.MapFS(m).Open(name)
+}
+
+// MkdirAll implements WriteFS.
+func (m MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	for ; name != "."; name = path.Dir(name) {
+		if f, ok := m[name]; ok {
+			if !f.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+			}
+			continue
+		}
+		m[name] = &fstest.MapFile{Mode: fs.ModeDir | perm}
+	}
+	return nil
+}
+
+// WriteFile implements WriteFS.
+func (m MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	if f, ok := m[name]; ok && f.Mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	m[name] = &fstest.MapFile{Data: append([]byte{}, data...), Mode: perm}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExtractMemFS(t *testing.T) {
+	want, files := extractAndAssemble(t, false)
+
+	mem := MemFS{}
+	var fIndex uint64
+	if err := (&Extract{DirPath: ".", Index: &fIndex, FS: mem}).Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, f := range mem {
+		if f.Mode.IsRegular() {
+			n++
+		}
+	}
+	// The files and summary.json.
+	if n != len(files)+1 {
+		t.Errorf("got %d files in memory, want %d", n, len(files)+1)
+	}
+
+	f, err := (&ParseDir{FS: mem}).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{Options: &AssembleOptions{Deterministic: true}}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), want) {
+		t.Errorf("the extraction in memory assembles to a different image")
+	}
+}
+
+func TestWriteFS(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "fs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, fsys := range []WriteFS{MemFS{}, DirFS(tmpDir)} {
+		if err := fsys.MkdirAll("a/b", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.WriteFile("a/b/c.bin", []byte("c"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.MkdirAll("d", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(fsys, "a/b/c.bin", "d"); err != nil {
+			t.Errorf("%T: %v", fsys, err)
+		}
+		if err := fsys.WriteFile("../escape.bin", nil, 0644); err == nil {
+			t.Errorf("%T: writing out of the file system succeeded", fsys)
+		}
+		if err := fsys.WriteFile("a/b", nil, 0644); err == nil {
+			t.Errorf("%T: writing over a directory succeeded", fsys)
+		}
+		if b, err := fs.ReadFile(fsys, "a/b/c.bin"); err != nil || string(b) != "c" {
+			t.Errorf("%T: got %q, %v, want c", fsys, b, err)
+		}
+	}
+}
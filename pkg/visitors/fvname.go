@@ -0,0 +1,71 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// SetFVName sets the name GUID of the firmware volumes matching Predicate,
+// which firmware finds volumes by. Volumes without an extended header get
+// one, in a pad file after their header like GenFv puts it. Assemble
+// regenerates the extended header and the checksums.
+type SetFVName struct {
+	// Input
+	Predicate FindPredicate
+	Name      guid.GUID
+
+	// Output
+	Matches []*uefi.FirmwareVolume
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetFVName) Run(f uefi.Firmware) error {
+	v.Matches = nil
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if len(v.Matches) == 0 {
+		return errors.New("no matches found")
+	}
+	return nil
+}
+
+// Visit applies the SetFVName visitor to any Firmware type.
+func (v *SetFVName) Visit(f uefi.Firmware) error {
+	fv, ok := f.(*uefi.FirmwareVolume)
+	if !ok || !v.Predicate(fv) {
+		return f.ApplyChildren(v)
+	}
+	if fv.ExtHeaderOffset == 0 {
+		fv.ExtHeaderOffset = fv.HeaderLen + uefi.FileHeaderMinLength
+		if fv.Revision < 2 {
+			fv.Revision = 2
+		}
+	}
+	fv.FVName = v.Name
+	v.Matches = append(v.Matches, fv)
+	return fv.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("set_fv_name", "set the name GUID of the volumes matching a GUID", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFVPredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		name, err := guid.Parse(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &SetFVName{
+			Predicate: pred,
+			Name:      *name,
+		}, nil
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func findFV(t *testing.T, f uefi.Firmware, name string) *uefi.FirmwareVolume {
+	t.Helper()
+	pred, err := FindFVPredicate(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := &Find{Predicate: pred}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 1 {
+		t.Fatalf("got %d volumes named %s, want 1", len(find.Matches), name)
+	}
+	return find.Matches[0].(*uefi.FirmwareVolume)
+}
+
+func TestSetFVName(t *testing.T) {
+	const (
+		dxeFV  = "48DB5E17-707C-472D-91CD-1613E7EF51B0"
+		newFV  = "B0B0B0B0-0000-4000-8000-000000000001"
+		nvram  = "FFF12B8D-7696-4C8B-A985-2747075B4F50"
+		dxeVol = "9E21FD93-9C72-4C15-8C4B-E77F1DB2D792"
+	)
+	f := parseImage(t)
+	pred, err := FindFVPredicate(dxeFV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&SetFVName{Predicate: pred, Name: *guid.MustParse(newFV)}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Growing the extended header moves the files.
+	entries := []uefi.FirmwareVolumeExtEntry{{Type: uefi.FVExtEntryTypeUsedSize, UsedSize: 0x130000}}
+	findFV(t, f, newFV).ExtEntries = entries
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv := findFV(t, parsed, newFV)
+	if !reflect.DeepEqual(fv.ExtEntries, entries) {
+		t.Errorf("got entries %+v, want %+v", fv.ExtEntries, entries)
+	}
+	if len(fv.Files) != 1 || fv.Files[0].Header.GUID != *guid.MustParse(dxeVol) {
+		t.Errorf("the files of the volume were lost")
+	}
+
+	// Volumes without files keep their data, so they cannot get an
+	// extended header.
+	pred, err = FindFVPredicate(nvram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&SetFVName{Predicate: pred, Name: *guid.MustParse(newFV)}).Run(parsed); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(parsed); err == nil {
+		t.Errorf("adding an extended header to a volume without files succeeded, want an error")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoGbE = errors.New("no GbE region found")
+
+// findGbE returns the NVM of the GbE region of the firmware.
+func findGbE(f uefi.Firmware) (*uefi.GbE, error) {
+	find := &Find{
+		Predicate: func(f uefi.Firmware) bool {
+			r, ok := f.(*uefi.RawRegion)
+			return ok && r.Type() == uefi.RegionTypeGBE
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return nil, err
+	}
+	if len(find.Matches) == 0 {
+		return nil, errNoGbE
+	}
+	return uefi.NewGbE(find.Matches[0].Buf())
+}
+
+// GbEInfo prints the MAC address, signature and checksum of each NVM bank
+// of the GbE region.
+type GbEInfo struct {
+	W io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *GbEInfo) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the GbEInfo visitor to any Firmware type.
+func (v *GbEInfo) Visit(f uefi.Firmware) error {
+	g, err := findGbE(f)
+	if err != nil {
+		return err
+	}
+	for b := 0; b < g.Banks(); b++ {
+		fmt.Fprintf(v.W, "Bank %d: MAC %v, signature valid %v, checksum valid %v\n",
+			b, g.MAC(b), g.SignatureValid(b), g.ChecksumValid(b))
+	}
+	return nil
+}
+
+// SetMAC sets the MAC address in the GbE region and fixes its checksums.
+type SetMAC struct {
+	// Input
+	MAC net.HardwareAddr
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetMAC) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the SetMAC visitor to any Firmware type.
+func (v *SetMAC) Visit(f uefi.Firmware) error {
+	g, err := findGbE(f)
+	if err != nil {
+		return err
+	}
+	return g.SetMAC(v.MAC)
+}
+
+func init() {
+	RegisterCLI("gbe_info", "print the MAC address and checksums of the GbE region", 0, func(args []string) (uefi.Visitor, error) {
+		return &GbEInfo{W: os.Stdout}, nil
+	})
+	RegisterCLI("set_mac", "set the MAC address in the GbE region and fix its checksums", 1, func(args []string) (uefi.Visitor, error) {
+		mac, err := net.ParseMAC(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &SetMAC{
+			MAC: mac,
+		}, nil
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSetMAC(t *testing.T) {
+	buf := ifdImage(t)
+	// Valid signature in the first bank of the GbE region.
+	copy(buf[0x1000+2*0x13:], []byte{0x00, 0x80})
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("02:aa:bb:cc:dd:ee")
+	if err := (&SetMAC{MAC: mac}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := (&GbEInfo{W: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	want := "Bank 0: MAC 02:aa:bb:cc:dd:ee, signature valid true, checksum valid true\n" +
+		"Bank 1: MAC ff:ff:ff:ff:ff:ff, signature valid false, checksum valid false\n"
+	if b.String() != want {
+		t.Errorf("GbEInfo: got\n%s, want\n%s", b.String(), want)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(f.Buf()[0x1000:]), string(mac)) {
+		t.Errorf("MAC not in the assembled image")
+	}
+
+	f, err = uefi.Parse(buf[0x8000:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&SetMAC{MAC: mac}).Run(f); err != errNoGbE {
+		t.Errorf("SetMAC without GbE region: got %v, want %v", err, errNoGbE)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// GrepMatch is a match of Grep in a node.
+type GrepMatch struct {
+	// Path of the node from the root.
+	Path string
+	// Offset of the match in the buffer of the node.
+	Offset uint64
+	Match  []byte
+}
+
+// Grep searches the leaves of the firmware tree, which hold the
+// decompressed content of sections, for byte patterns or a regular
+// expression.
+type Grep struct {
+	Cancelable
+
+	// Input
+	Patterns [][]byte
+	Regexp   *regexp.Regexp
+	// Optionally print the matches.
+	W io.Writer
+
+	// Output
+	Matches []GrepMatch
+
+	path []string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Grep) Run(f uefi.Firmware) error {
+	v.Matches = nil
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W != nil {
+		for _, m := range v.Matches {
+			fmt.Fprintf(v.W, "%s: %#x: %q\n", m.Path, m.Offset, m.Match)
+		}
+	}
+	return nil
+}
+
+// Visit applies the Grep visitor to any Firmware type.
+func (v *Grep) Visit(f uefi.Firmware) error {
+	v.path = append(v.path, nodeLabel(f))
+	defer func() { v.path = v.path[:len(v.path)-1] }()
+
+	if !hasChildren(f) {
+		v.search(f.Buf())
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *Grep) search(buf []byte) {
+	path := strings.Join(v.path, "/")
+	for _, p := range v.Patterns {
+		if len(p) == 0 {
+			continue
+		}
+		for o := 0; ; o++ {
+			i := bytes.Index(buf[o:], p)
+			if i < 0 {
+				break
+			}
+			o += i
+			v.Matches = append(v.Matches, GrepMatch{Path: path, Offset: uint64(o), Match: p})
+		}
+	}
+	if v.Regexp != nil {
+		for _, loc := range v.Regexp.FindAllIndex(buf, -1) {
+			v.Matches = append(v.Matches, GrepMatch{Path: path, Offset: uint64(loc[0]), Match: buf[loc[0]:loc[1]]})
+		}
+	}
+}
+
+// nodeLabel returns the type of the node and what identifies it among its
+// siblings.
+func nodeLabel(f uefi.Firmware) string {
+	t := strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi.")
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		return fmt.Sprintf("%s(%v@%#x)", t, f.FVName, f.FVOffset)
+	case *uefi.File:
+		return fmt.Sprintf("%s(%v)", t, f.Header.GUID)
+	case *uefi.Section:
+		if s := f.String(); s != "" {
+			return fmt.Sprintf("%s(%s)", t, s)
+		}
+		return fmt.Sprintf("%s(%s)", t, f.Type)
+	case *uefi.NVar:
+		return fmt.Sprintf("%s(%s)", t, f.Name)
+	case *uefi.RawRegion:
+		return fmt.Sprintf("%s(%v)", t, f.Type())
+	}
+	return t
+}
+
+// childCounter counts the children a node applies it to.
+type childCounter struct {
+	n int
+}
+
+func (c *childCounter) Run(f uefi.Firmware) error {
+	return f.ApplyChildren(c)
+}
+
+func (c *childCounter) Visit(f uefi.Firmware) error {
+	c.n++
+	return nil
+}
+
+func hasChildren(f uefi.Firmware) bool {
+	c := &childCounter{}
+	// Counting cannot fail.
+	_ = c.Run(f)
+	return c.n != 0
+}
+
+func init() {
+	RegisterCLI("grep", "search decompressed content for an ASCII and UCS-2 string", 1, func(args []string) (uefi.Visitor, error) {
+		return &Grep{
+			Patterns: [][]byte{[]byte(args[0]), ucs2(args[0])},
+			W:        os.Stdout,
+		}, nil
+	})
+	RegisterCLI("grep_hex", "search decompressed content for hex encoded bytes", 1, func(args []string) (uefi.Visitor, error) {
+		p, err := hex.DecodeString(strings.ReplaceAll(args[0], " ", ""))
+		if err != nil {
+			return nil, err
+		}
+		return &Grep{
+			Patterns: [][]byte{p},
+			W:        os.Stdout,
+		}, nil
+	})
+	RegisterCLI("grep_regexp", "search decompressed content for a regular expression", 1, func(args []string) (uefi.Visitor, error) {
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Grep{
+			Regexp: re,
+			W:      os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGrep(t *testing.T) {
+	f := parseImage(t)
+
+	// The DXE volume of OVMF is compressed.
+	v := &Grep{Patterns: [][]byte{ucs2("DxeCore")}}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Matches) != 1 {
+		t.Fatalf("got %d matches, want 1: %+v", len(v.Matches), v.Matches)
+	}
+	m := v.Matches[0]
+	if !strings.HasPrefix(m.Path, "BIOSRegion/FirmwareVolume(") ||
+		!strings.HasSuffix(m.Path, "/File("+dxeCoreGUID.String()+")/Section(DxeCore)") || m.Offset != 4 {
+		t.Errorf("got match %+v, want the UI section of DxeCore", m)
+	}
+
+	var b bytes.Buffer
+	v = &Grep{Regexp: regexp.MustCompile(`PciHostBridge[A-Za-z]*\.dll`), W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Matches) == 0 || string(v.Matches[0].Match) != "PciHostBridgeDxe.dll" ||
+		!strings.HasSuffix(v.Matches[0].Path, "/Section(EFI_SECTION_PE32)") {
+		t.Errorf("got matches %+v, want PciHostBridgeDxe.dll in a PE32 section", v.Matches)
+	}
+	if !strings.Contains(b.String(), `"PciHostBridgeDxe.dll"`) {
+		t.Errorf("got output %q, want the match", b.String())
+	}
+
+	v = &Grep{Patterns: [][]byte{[]byte("no such string in OVMF")}}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Matches) != 0 {
+		t.Errorf("got %d matches, want none", len(v.Matches))
+	}
+}
+
+func TestGrepOverlapping(t *testing.T) {
+	v := &Grep{Patterns: [][]byte{[]byte("aa")}, path: []string{"root"}}
+	v.search([]byte("aaaxaa"))
+	var offsets []uint64
+	for _, m := range v.Matches {
+		offsets = append(offsets, m.Offset)
+	}
+	if len(offsets) != 3 || offsets[0] != 0 || offsets[1] != 1 || offsets[2] != 4 {
+		t.Errorf("got offsets %v, want [0 1 4]", offsets)
+	}
+}
@@ -50,18 +50,28 @@ const (
 	// TODO: Add InsertIn
 
 	// InsertTypeInsert is generalization of all InsertTypeInsert* above. Arguments:
-	// * The first argument specifies the type of what to insert (possible values: "file" or "pad_file")
+	// * The first argument specifies the type of what to insert (possible values: "file", "pad_file",
+	//   "fv" or "compressed_fv")
 	// * The second argument specifies the content of what to insert:
 	//     - If the first argument is "file" then a path to the file content is expected.
 	//     - If the first argument is "pad_file" then the size is expected.
-	// * The third argument specifies the preposition of where to insert to (possible values: "front", "end", "after", "before").
+	//     - If the first argument is "fv" or "compressed_fv" then a path to a firmware volume is
+	//       expected, which is wrapped in a new FIRMWARE_VOLUME_IMAGE file, LZMA compressed for
+	//       "compressed_fv".
+	// * The third argument specifies the preposition of where to insert to (possible values: "front", "end", "after", "before", "at").
 	// * The forth argument specifies the preposition object of where to insert to. It could be FV_or_File GUID_or_name.
+	// * The fifth argument is the size of the file the forth argument names, or "" for any size. For "at", it is
+	//   the index of the inserted file among the files of the volume instead.
 	//   For example combination "end 5C60F367-A505-419A-859E-2A4FF6CA6FE5" means to insert to the end of volume
 	//   "5C60F367-A505-419A-859E-2A4FF6CA6FE5".
 	//
 	// A complete example: "pad_file 256 after FC510EE7-FFDC-11D4-BD41-0080C73C8881" means to insert a pad file
 	// of size 256 bytes after file with GUID "FC510EE7-FFDC-11D4-BD41-0080C73C8881".
 	InsertTypeInsert
+
+	// InsertTypeAt inserts a file at an index of the firmware volume, which
+	// is specified like for InsertTypeFront. Index 0 is the front.
+	InsertTypeAt
 )
 
 var insertTypeNames = map[InsertType]string{
@@ -74,6 +84,9 @@ var insertTypeNames = map[InsertType]string{
 	InsertTypeAfter:  "insert_after",
 	InsertTypeBefore: "insert_before",
 	InsertTypeDXE:    "insert_dxe",
+
+	// Only through InsertTypeInsert:
+	InsertTypeAt: "insert at",
 }
 
 // String creates a string representation for the insert type.
@@ -91,6 +104,8 @@ const (
 	InsertWhatTypeUndefined = InsertWhatType(iota)
 	InsertWhatTypeFile
 	InsertWhatTypePadFile
+	InsertWhatTypeFV
+	InsertWhatTypeCompressedFV
 
 	EndOfInsertWhatType
 )
@@ -104,6 +119,10 @@ func (t InsertWhatType) String() string {
 		return "file"
 	case InsertWhatTypePadFile:
 		return "pad_file"
+	case InsertWhatTypeFV:
+		return "fv"
+	case InsertWhatTypeCompressedFV:
+		return "compressed_fv"
 	}
 	return fmt.Sprintf("unknown_%d", t)
 }
@@ -130,6 +149,7 @@ const (
 	InsertWherePrepositionEnd
 	InsertWherePrepositionAfter
 	InsertWherePrepositionBefore
+	InsertWherePrepositionAt
 
 	EndOfInsertWherePreposition
 )
@@ -147,6 +167,8 @@ func (p InsertWherePreposition) String() string {
 		return "after"
 	case InsertWherePrepositionBefore:
 		return "before"
+	case InsertWherePrepositionAt:
+		return "at"
 	}
 	return fmt.Sprintf("unknown_%d", p)
 }
@@ -172,6 +194,9 @@ type Insert struct {
 	Predicate func(f uefi.Firmware) bool
 	NewFile   *uefi.File
 	InsertType
+	// Index is the index of NewFile among the files of the volume for
+	// InsertTypeAt.
+	Index int
 
 	// Matched File
 	Matches []uefi.Firmware
@@ -200,6 +225,8 @@ func (v *Insert) Run(f uefi.Firmware) error {
 			fvMatch.Files = append([]*uefi.File{v.NewFile}, fvMatch.Files...)
 		case InsertTypeEnd:
 			fvMatch.Files = append(fvMatch.Files, v.NewFile)
+		case InsertTypeAt:
+			return v.insertAt(fvMatch)
 		default:
 			return fmt.Errorf("matched FV but insert operation was %s, which only matches Files",
 				v.InsertType.String())
@@ -212,7 +239,10 @@ func (v *Insert) Run(f uefi.Firmware) error {
 			return fmt.Errorf("match was not a file or a firmware volume: got %T, unable to insert", m)
 		}
 	}
-	v.Matches = find.Matches[:1]
+	if numMatch := len(find.Matches); numMatch > 1 {
+		return fmt.Errorf("more than one match, only one match allowed! got %v", find.Matches)
+	}
+	v.Matches = find.Matches
 	// Matches are files, apply visitor.
 	return f.Apply(v)
 }
@@ -238,6 +268,8 @@ func (v *Insert) Visit(f uefi.Firmware) error {
 						f.Files = append(f.Files[:i], append([]*uefi.File{v.NewFile}, f.Files[i:]...)...)
 					case InsertTypeReplaceFFS:
 						f.Files = append(f.Files[:i], append([]*uefi.File{v.NewFile}, f.Files[i+1:]...)...)
+					case InsertTypeAt:
+						return v.insertAt(f)
 					}
 					return nil
 				}
@@ -248,6 +280,16 @@ func (v *Insert) Visit(f uefi.Firmware) error {
 	return f.ApplyChildren(v)
 }
 
+// insertAt inserts NewFile at Index of the files of the volume, so the
+// files before it are dispatched first.
+func (v *Insert) insertAt(fv *uefi.FirmwareVolume) error {
+	if v.Index < 0 || v.Index > len(fv.Files) {
+		return fmt.Errorf("index %d out of the %d files of volume %v", v.Index, len(fv.Files), fv)
+	}
+	fv.Files = append(fv.Files[:v.Index], append([]*uefi.File{v.NewFile}, fv.Files[v.Index:]...)...)
+	return nil
+}
+
 func parseFile(filePath string) (*uefi.File, error) {
 	fileBytes, err := os.ReadFile(filePath)
 	if err != nil {
@@ -327,6 +369,15 @@ func genInsertFileCLI() func(args []string) (uefi.Visitor, error) {
 			if err != nil {
 				return nil, fmt.Errorf("unable to create a pad file of size %d: %w", padSize, err)
 			}
+		case InsertWhatTypeFV, InsertWhatTypeCompressedFV:
+			fvBytes, err := os.ReadFile(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("unable to read firmware volume '%s': %w", args[1], err)
+			}
+			file, err = CreateVolumeImageFile(fvBytes, whatType == InsertWhatTypeCompressedFV)
+			if err != nil {
+				return nil, fmt.Errorf("unable to wrap firmware volume '%s': %w", args[1], err)
+			}
 		default:
 			return nil, fmt.Errorf("what-type '%s' is not supported, yet", whatType)
 		}
@@ -338,7 +389,14 @@ func genInsertFileCLI() func(args []string) (uefi.Visitor, error) {
 
 		var pred FindPredicate
 		var err error
-		if args[4] == "" {
+		var index int
+		if wherePreposition == InsertWherePrepositionAt {
+			index, err = strconv.Atoi(args[4])
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse file index '%s': %w", args[4], err)
+			}
+			pred, err = FindFileFVPredicate(args[3])
+		} else if args[4] == "" {
 			pred, err = FindFileFVPredicate(args[3])
 		} else {
 			var size uint64
@@ -363,6 +421,8 @@ func genInsertFileCLI() func(args []string) (uefi.Visitor, error) {
 			insertType = InsertTypeAfter
 		case InsertWherePrepositionBefore:
 			insertType = InsertTypeBefore
+		case InsertWherePrepositionAt:
+			insertType = InsertTypeAt
 		default:
 			return nil, fmt.Errorf("where-preposition '%s' is not supported, yet", wherePreposition)
 		}
@@ -373,6 +433,7 @@ func genInsertFileCLI() func(args []string) (uefi.Visitor, error) {
 			NewFile:   file,
 			// TODO: use InsertWherePreposition to define the location, instead of InsertType
 			InsertType: insertType,
+			Index:      index,
 		}, nil
 	}
 }
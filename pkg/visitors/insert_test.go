@@ -6,6 +6,8 @@ package visitors
 
 import (
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -15,6 +17,8 @@ import (
 
 const (
 	insertTestFile = "../../integration/roms/testfile.ffs"
+	// insertTestFV is the PEI volume nested in the OVMF image.
+	insertTestFV = "6938079B-B503-4E3D-9D24-B28337A25806"
 )
 
 func testRunObsoleteInsert(t *testing.T, f uefi.Firmware, insertType InsertType, testGUID guid.GUID) (*Insert, error) {
@@ -89,11 +93,24 @@ func testInsertCLI(t *testing.T, whatType InsertWhatType, wherePreposition Inser
 		args = append(args, insertTestFile)
 	case InsertWhatTypePadFile:
 		args = append(args, "256")
+	case InsertWhatTypeFV, InsertWhatTypeCompressedFV:
+		path := filepath.Join(t.TempDir(), "pei.fv")
+		pred, err := FindFVPredicate(insertTestFV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&DumpFV{Predicate: pred, Path: path}).Run(f); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, path)
 	default:
 		t.Fatalf("unknown what-type '%s'", whatType)
 	}
 
-	args = append(args, wherePreposition.String(), testGUID.String())
+	args = append(args, wherePreposition.String(), testGUID.String(), "")
+	if wherePreposition == InsertWherePrepositionAt {
+		args[len(args)-1] = "1"
+	}
 
 	visitor, err := genInsertFileCLI()(args)
 	if err != nil {
@@ -137,6 +154,18 @@ func testInsertCLI(t *testing.T, whatType InsertWhatType, wherePreposition Inser
 		if len(find.Matches) != 1 {
 			t.Errorf("incorrect number of matches after insertion! expected 1, got %v", len(find.Matches))
 		}
+	case InsertWhatTypeFV, InsertWhatTypeCompressedFV:
+		pred, err := FindFVPredicate(insertTestFV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		find := &Find{Predicate: pred}
+		if err = find.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		if len(find.Matches) != 2 {
+			t.Errorf("incorrect number of matches after insertion! expected 2, got %v", len(find.Matches))
+		}
 	default:
 		t.Fatalf("unknown what-type '%s'", whatType)
 	}
@@ -206,3 +235,53 @@ func TestNoFindInsert(t *testing.T) {
 		})
 	}
 }
+
+func TestInsertAt(t *testing.T) {
+	f := parseImage(t)
+	find := &Find{Predicate: func(f uefi.Firmware) bool {
+		fv, ok := f.(*uefi.FirmwareVolume)
+		if !ok {
+			return false
+		}
+		for _, file := range fv.Files {
+			if file.Header.GUID == *testGUID {
+				return true
+			}
+		}
+		return false
+	}}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 1 {
+		t.Fatalf("got %d volumes holding the test file, want 1", len(find.Matches))
+	}
+	fv := find.Matches[0].(*uefi.FirmwareVolume)
+	n := len(fv.Files)
+
+	// Pad files, since inserting the test file makes its GUID ambiguous.
+	for _, index := range []string{"2", "0", strconv.Itoa(n + 2)} {
+		visitor, err := genInsertFileCLI()([]string{"pad_file", "256", "at", testGUID.String(), index})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := visitor.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		i, _ := strconv.Atoi(index)
+		if fv.Files[i] != visitor.(*Insert).NewFile {
+			t.Errorf("the file inserted at %d is not there", i)
+		}
+	}
+	if len(fv.Files) != n+3 {
+		t.Errorf("got %d files, want %d", len(fv.Files), n+3)
+	}
+
+	visitor, err := genInsertFileCLI()([]string{"pad_file", "256", "at", testGUID.String(), strconv.Itoa(n + 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := visitor.Run(f); err == nil {
+		t.Errorf("inserting past the end of the volume succeeded, want an error")
+	}
+}
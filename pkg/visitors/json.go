@@ -5,18 +5,39 @@
 package visitors
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
 // JSON prints any Firmware node as JSON.
 type JSON struct {
+	Reporter
+
 	// JSON is written to this writer.
 	W io.Writer
+
+	// Bodies includes the bytes of each node, base64 encoded, as Body.
+	Bodies bool
+	// Omit leaves out the nodes of these types, with their children. Types
+	// are named as in queries, like fv, file, section or nvar.
+	Omit []string
+	// Depth limits the levels of nodes written, the node the visitor is
+	// applied to being the first. Depth <= 0 means all.
+	Depth int
+	// SortKeys writes the members of every object sorted by key, rather
+	// than in the order of the fields of the structs, so the output stays
+	// comparable between versions of fiano.
+	SortKeys bool
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
@@ -26,7 +47,18 @@ func (v *JSON) Run(f uefi.Firmware) error {
 
 // Visit applies the JSON visitor to any Firmware type.
 func (v *JSON) Visit(f uefi.Firmware) error {
-	b, err := json.MarshalIndent(f, "", "\t")
+	var n interface{} = f
+	if v.Bodies || len(v.Omit) != 0 || v.Depth > 0 || v.SortKeys {
+		o, err := v.node(f, 1)
+		if err != nil {
+			return err
+		}
+		if v.SortKeys {
+			sortKeys(o)
+		}
+		n = o
+	}
+	b, err := marshalReport(v.Format, n)
 	if err != nil {
 		return err
 	}
@@ -34,10 +66,208 @@ func (v *JSON) Visit(f uefi.Firmware) error {
 	return nil
 }
 
+// omitted returns true if f is not written at the depth.
+func (v *JSON) omitted(f uefi.Firmware, depth int) bool {
+	if v.Depth > 0 && depth > v.Depth {
+		return true
+	}
+	typ := strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."))
+	for _, o := range v.Omit {
+		o = strings.ToLower(o)
+		if t, ok := queryTypes[o]; ok {
+			o = t
+		}
+		if o == typ {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	firmwareType      = reflect.TypeOf((*uefi.Firmware)(nil)).Elem()
+	typedFirmwareType = reflect.TypeOf((*uefi.TypedFirmware)(nil))
+)
+
+// isChildType returns true if fields of type t hold child nodes.
+func isChildType(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t == typedFirmwareType || t.Implements(firmwareType) || reflect.PtrTo(t).Implements(firmwareType)
+}
+
+// node returns the JSON of f as an orderedObject. Each node is marshalled
+// without its children, which are added back as nodes in turn, so they can
+// be filtered and given bodies.
+func (v *JSON) node(f uefi.Firmware, depth int) (orderedObject, error) {
+	rv := reflect.ValueOf(f)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot write %T as JSON", f)
+	}
+	st := rv.Elem().Type()
+	shallow := reflect.New(st)
+	shallow.Elem().Set(rv.Elem())
+	var fields []int
+	for i := 0; i < st.NumField(); i++ {
+		if sf := st.Field(i); sf.PkgPath == "" && sf.Tag.Get("json") != "-" && isChildType(sf.Type) {
+			fields = append(fields, i)
+			shallow.Elem().Field(i).Set(reflect.Zero(sf.Type))
+		}
+	}
+	b, err := json.Marshal(shallow.Interface())
+	if err != nil {
+		return nil, err
+	}
+	d, err := decodeOrdered(b)
+	if err != nil {
+		return nil, err
+	}
+	o := d.(orderedObject)
+
+	for _, i := range fields {
+		sf := st.Field(i)
+		tag := strings.Split(sf.Tag.Get("json"), ",")
+		key := sf.Name
+		if tag[0] != "" {
+			key = tag[0]
+		}
+		c, err := v.children(rv.Elem().Field(i), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := c.([]interface{}); c == nil || ok && len(a) == 0 && len(tag) > 1 && tag[1] == "omitempty" {
+			// Like encoding/json, which left the member out or null.
+			continue
+		}
+		o = o.set(key, c)
+	}
+	if v.Bodies {
+		o = o.set("Body", base64.StdEncoding.EncodeToString(f.Buf()))
+	}
+	return o, nil
+}
+
+// children returns the JSON of the child nodes held by the field, or nil
+// if there are none to write.
+func (v *JSON) children(field reflect.Value, depth int) (interface{}, error) {
+	if field.Kind() == reflect.Slice {
+		if field.IsNil() {
+			return nil, nil
+		}
+		a := []interface{}{}
+		for i := 0; i < field.Len(); i++ {
+			c, err := v.children(field.Index(i), depth)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				a = append(a, c)
+			}
+		}
+		return a, nil
+	}
+	if field.Kind() == reflect.Ptr && field.IsNil() {
+		return nil, nil
+	}
+	if t, ok := field.Interface().(*uefi.TypedFirmware); ok {
+		if t.Value == nil || v.omitted(t.Value, depth) {
+			return nil, nil
+		}
+		n, err := v.node(t.Value, depth)
+		if err != nil {
+			return nil, err
+		}
+		return orderedObject{{"Type", t.Type}, {"Value", n}}, nil
+	}
+	if field.Kind() != reflect.Ptr {
+		field = field.Addr()
+	}
+	f := field.Interface().(uefi.Firmware)
+	if v.omitted(f, depth) {
+		return nil, nil
+	}
+	return v.node(f, depth)
+}
+
+// set sets the member with the key, or appends it.
+func (o orderedObject) set(key string, value interface{}) orderedObject {
+	for i := range o {
+		if o[i].Key == key {
+			o[i].Value = value
+			return o
+		}
+	}
+	return append(o, orderedMember{key, value})
+}
+
+// sortKeys sorts the members of the objects of v by key.
+func sortKeys(v interface{}) {
+	switch v := v.(type) {
+	case orderedObject:
+		sort.SliceStable(v, func(i, j int) bool { return v[i].Key < v[j].Key })
+		for _, m := range v {
+			sortKeys(m.Value)
+		}
+	case []interface{}:
+		for _, e := range v {
+			sortKeys(e)
+		}
+	}
+}
+
+// MarshalJSON marshals the members in order.
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Quote(m.Key))
+		b.WriteByte(':')
+		mb, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(mb)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// parseJSONOptions parses the comma separated options of json_opts.
+func parseJSONOptions(opts string) (*JSON, error) {
+	v := &JSON{W: os.Stdout}
+	for _, opt := range strings.Split(opts, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		switch {
+		case opt == "bodies":
+			v.Bodies = true
+		case opt == "sorted":
+			v.SortKeys = true
+		case kv[0] == "omit" && len(kv) == 2:
+			v.Omit = append(v.Omit, kv[1])
+		case kv[0] == "depth" && len(kv) == 2:
+			d, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("json_opts: bad depth %q: %v", kv[1], err)
+			}
+			v.Depth = d
+		default:
+			return nil, fmt.Errorf("json_opts: unknown option %q, want bodies, sorted, omit=TYPE or depth=N", opt)
+		}
+	}
+	return v, nil
+}
+
 func init() {
 	RegisterCLI("json", "produce JSON for the full firmware volume", 0, func(args []string) (uefi.Visitor, error) {
 		return &JSON{
 			W: os.Stdout,
 		}, nil
 	})
+	RegisterCLI("json_opts", "produce JSON with comma separated options: bodies, sorted, omit=TYPE (repeatable), depth=N", 1, func(args []string) (uefi.Visitor, error) {
+		return parseJSONOptions(args[0])
+	})
 }
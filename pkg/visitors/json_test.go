@@ -6,7 +6,10 @@ package visitors
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -29,3 +32,76 @@ func TestJSON(t *testing.T) {
 		t.Errorf("invalid json: %q", out.String())
 	}
 }
+
+func runJSON(t *testing.T, v *JSON) (interface{}, string) {
+	out := &bytes.Buffer{}
+	v.W = out
+	if err := parseImage(t).Apply(v); err != nil {
+		t.Fatal(err)
+	}
+	var dec interface{}
+	if err := json.Unmarshal(out.Bytes(), &dec); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	return dec, out.String()
+}
+
+func TestJSONNodesMatchPlain(t *testing.T) {
+	plain, _ := runJSON(t, &JSON{})
+	nodes, _ := runJSON(t, &JSON{Depth: 100})
+	if !reflect.DeepEqual(plain, nodes) {
+		t.Errorf("writing node by node gives different JSON than marshalling the tree")
+	}
+}
+
+func TestJSONSortKeys(t *testing.T) {
+	plain, _ := runJSON(t, &JSON{})
+	sorted, out := runJSON(t, &JSON{SortKeys: true})
+	if !reflect.DeepEqual(plain, sorted) {
+		t.Errorf("sorting keys changes the JSON")
+	}
+	if strings.Index(out, `"Elements"`) > strings.Index(out, `"ExtractPath"`) {
+		t.Errorf("keys are not sorted")
+	}
+}
+
+func TestJSONBodies(t *testing.T) {
+	dec, _ := runJSON(t, &JSON{Bodies: true, Depth: 1})
+	root := dec.(map[string]interface{})
+	body, err := base64.StdEncoding.DecodeString(root["Body"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, parseImage(t).Buf()) {
+		t.Errorf("the body is not the bytes of the image")
+	}
+	if e, ok := root["Elements"]; ok {
+		t.Errorf("got elements %v below depth 1, want none", e)
+	}
+}
+
+func TestJSONOmit(t *testing.T) {
+	_, out := runJSON(t, &JSON{Omit: []string{"Section"}})
+	if strings.Contains(out, "EFI_SECTION_") {
+		t.Errorf("omitted sections were written")
+	}
+	if !strings.Contains(out, "EFI_FV_FILETYPE_") {
+		t.Errorf("files were not written")
+	}
+}
+
+func TestParseJSONOptions(t *testing.T) {
+	v, err := parseJSONOptions("bodies,sorted,omit=fv,omit=nvar,depth=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &JSON{W: v.W, Bodies: true, SortKeys: true, Omit: []string{"fv", "nvar"}, Depth: 2}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+	for _, bad := range []string{"", "depth=x", "omit", "pretty"} {
+		if _, err := parseJSONOptions(bad); err == nil {
+			t.Errorf("parsing %q succeeded, want an error", bad)
+		}
+	}
+}
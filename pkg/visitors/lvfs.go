@@ -0,0 +1,78 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/lvfs"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// LVFS assembles the image and packages it as an update of LVFS described
+// by Metadata: a cabinet if OutPath ends in .cab, else a directory holding
+// the files of the cabinet.
+type LVFS struct {
+	Cancelable
+
+	Metadata *lvfs.Metadata
+	OutPath  string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *LVFS) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit assembles f and writes its update.
+func (v *LVFS) Visit(f uefi.Firmware) error {
+	if err := f.Apply(&Assemble{Cancelable: v.Cancelable}); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(v.OutPath, ".cab") {
+		return lvfs.WriteDir(v.OutPath, v.Metadata, f.Buf())
+	}
+	out, err := os.Create(v.OutPath)
+	if err != nil {
+		return err
+	}
+	if err := lvfs.WriteCabinet(out, v.Metadata, f.Buf()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Outputs implements Outputter.
+func (v *LVFS) Outputs() []string { return []string{v.OutPath} }
+
+// ReadLVFSMetadata reads the lvfs.Metadata of an update from a JSON file.
+func ReadLVFSMetadata(path string) (*lvfs.Metadata, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m lvfs.Metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("reading metadata %s: %w", path, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func init() {
+	RegisterCLI("lvfs", "lvfs METADATA OUT\n package the image as an update of LVFS described by the JSON file METADATA, a cabinet if OUT ends in .cab, else a directory", 2, func(args []string) (uefi.Visitor, error) {
+		m, err := ReadLVFSMetadata(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &LVFS{Metadata: m, OutPath: args[1]}, nil
+	})
+}
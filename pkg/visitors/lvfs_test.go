@@ -0,0 +1,59 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/lvfs"
+)
+
+func TestLVFS(t *testing.T) {
+	dir := t.TempDir()
+	metadata := filepath.Join(dir, "metadata.json")
+	if err := os.WriteFile(metadata, []byte(`{
+	"ID": "org.linuxboot.OVMF.firmware",
+	"Name": "OVMF",
+	"Summary": "Firmware of QEMU",
+	"DeveloperName": "LinuxBoot",
+	"GUIDs": ["7CB8BDC9-F8EB-4F34-AAEA-3EE4AF6516A1"],
+	"Version": "1.0"
+}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "update")
+	v, err := ParseCLI([]string{"lvfs", metadata, out, "lvfs", metadata, out + ".cab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := parseImage(t)
+	if err := ExecuteCLI(f, v); err != nil {
+		t.Fatal(err)
+	}
+	image, err := os.ReadFile(filepath.Join(out, lvfs.FirmwareName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, f.Buf()) {
+		t.Errorf("the update holds another image than the assembled one")
+	}
+	cab, err := os.ReadFile(out + ".cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(cab, []byte("MSCF")) {
+		t.Errorf("%s.cab is not a cabinet", out)
+	}
+
+	if err := os.WriteFile(metadata, []byte(`{"ID": "org.linuxboot.OVMF"}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseCLI([]string{"lvfs", metadata, out}); err == nil {
+		t.Errorf("incomplete metadata was accepted, want an error")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoME = errors.New("no ME region with an ME Flash Partition Table found")
+
+// MEInfoPartition is a partition of the ME Flash Partition Table.
+type MEInfoPartition struct {
+	Name string
+	// Role is "operational" or "recovery" for the code partitions.
+	Role   string `json:",omitempty"`
+	Offset uint64
+	Size   uint32
+	// Version is that of the manifest of a code partition.
+	Version string `json:",omitempty"`
+}
+
+// MEInfo reports whether the ME region holds client (CSME) or server (SPS)
+// firmware, its version and the versions of its partitions.
+type MEInfo struct {
+	Reporter
+
+	// Optionally write the report, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Kind       string
+	Version    string `json:",omitempty"`
+	Partitions []MEInfoPartition
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *MEInfo) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	fmt.Fprintf(v.W, "%s firmware, version %s\n", v.Kind, v.Version)
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Name\tRole\tOffset\tSize\tVersion")
+	for _, p := range v.Partitions {
+		fmt.Fprintf(w, "%s\t%s\t%#x\t%#x\t%s\n", p.Name, p.Role, p.Offset, p.Size, p.Version)
+	}
+	return w.Flush()
+}
+
+// Visit reports the ME region of f.
+func (v *MEInfo) Visit(f uefi.Firmware) error {
+	find := &Find{
+		Predicate: func(f uefi.Firmware) bool {
+			r, ok := f.(*uefi.MERegion)
+			return ok && r.FPT != nil
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+	if len(find.Matches) == 0 {
+		return errNoME
+	}
+	mer := find.Matches[0].(*uefi.MERegion)
+	var base uint64
+	if mer.FRegion != nil {
+		base = uint64(mer.FRegion.BaseOffset())
+	}
+	v.Kind = mer.Kind()
+	v.Version = ""
+	if version, ok := mer.Version(); ok {
+		v.Version = version.String()
+	}
+	v.Partitions = []MEInfoPartition{}
+	for _, e := range mer.FPT.Entries {
+		p := MEInfoPartition{Name: e.Name.String(), Role: uefi.MEPartitionRole(e.Name.String()), Size: e.Length}
+		if e.OffsetIsValid() {
+			p.Offset = base + uint64(e.Offset)
+		}
+		if b, err := mer.Partition(p.Name); err == nil && e.Type() == "Code" {
+			if version, ok := uefi.FindMEVersion(b); ok {
+				p.Version = version.String()
+			}
+		}
+		v.Partitions = append(v.Partitions, p)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCLI("me_info", "print whether the ME region holds client (CSME) or server (SPS) firmware, its version and its partitions", 0, func(args []string) (uefi.Visitor, error) {
+		return &MEInfo{W: os.Stdout}, nil
+	})
+}
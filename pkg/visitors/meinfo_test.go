@@ -0,0 +1,73 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// meImage returns ifdImage with an ME region at 0x3000 whose $FPT lists an
+// FTPR and an OPR1 partition.
+func meImage(t *testing.T) []byte {
+	buf := ifdImage(t)
+	binary.LittleEndian.PutUint16(buf[0x44+4*int(uefi.RegionTypeME):], 3)
+	binary.LittleEndian.PutUint16(buf[0x46+4*int(uefi.RegionTypeME):], 7)
+	me := buf[0x3000:0x8000]
+	copy(me, make([]byte, 0x100))
+	copy(me, uefi.MEFTPSignature)
+	binary.LittleEndian.PutUint32(me[4:], 2)
+	for i, p := range []struct {
+		name    string
+		version []uint16
+	}{{"FTPR", []uint16{4, 1, 4, 100}}, {"OPR1", []uint16{4, 1, 4, 339}}} {
+		e := me[0x20+uefi.MEPartitionTableEntryLength*i:]
+		copy(e, p.name)
+		binary.LittleEndian.PutUint32(e[8:], uint32(0x1000*(i+1)))
+		binary.LittleEndian.PutUint32(e[12:], 0x1000)
+		m := me[0x1000*(i+1):]
+		copy(m, make([]byte, 0x100))
+		binary.LittleEndian.PutUint32(m[0x10:], uefi.MEManifestVendorIntel)
+		copy(m[uefi.MEManifestTagOffset:], uefi.MEManifestSignature)
+		for j, n := range p.version {
+			binary.LittleEndian.PutUint16(m[uefi.MEManifestTagOffset+8+2*j:], n)
+		}
+	}
+	return buf
+}
+
+func TestMEInfo(t *testing.T) {
+	f, err := uefi.Parse(meImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &MEInfo{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.Kind != uefi.MEFirmwareSPS || v.Version != "4.1.4.339" {
+		t.Errorf("got %s firmware %s, want SPS firmware 4.1.4.339", v.Kind, v.Version)
+	}
+	for _, s := range []string{"SPS firmware, version 4.1.4.339\n", "FTPR  recovery     0x4000  0x1000  4.1.4.100", "OPR1  operational  0x5000  0x1000  4.1.4.339"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestMEInfoNoME(t *testing.T) {
+	f, err := uefi.Parse(ifdImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&MEInfo{}).Run(f); err != errNoME {
+		t.Errorf("got %v, want %v", err, errNoME)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Merge3Conflict is a node both the new image and the image merged into
+// changed, which Merge3 left as it was in the image merged into.
+type Merge3Conflict struct {
+	Path   string
+	Reason string
+}
+
+// Merge3 applies the changes between a base image and a new image onto the
+// image it is applied to, for example the changes of a vendor update onto
+// an image which was modified since the base image.
+//
+// Nodes are matched like in Diff. A node only the new image changed is
+// taken from the new image, added or removed. A node both images changed
+// is merged child by child, so changes to different files of the same
+// compressed volume merge, and is a conflict if it has no children to
+// merge. Conflicting nodes are left as they are. The image is assembled
+// again when done.
+type Merge3 struct {
+	Cancelable
+
+	// Input
+	// Base and New are parsed from BasePath and NewPath if nil.
+	Base     uefi.Firmware
+	BasePath string
+	New      uefi.Firmware
+	NewPath  string
+	// If Strict is set, Run fails on conflicts, after merging the rest.
+	Strict bool
+	// logs are written to this writer.
+	W io.Writer
+
+	// Output
+	// Applied are the changes made to the image, with the path in the
+	// image for removed nodes and in the new image otherwise.
+	Applied   []DiffEntry
+	Conflicts []Merge3Conflict
+}
+
+func (v *Merge3) printf(format string, a ...interface{}) {
+	if v.W != nil {
+		fmt.Fprintf(v.W, format, a...)
+	}
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Merge3) Run(f uefi.Firmware) error {
+	var err error
+	if v.Base == nil {
+		if v.Base, err = parseImageFile(v.BasePath); err != nil {
+			return err
+		}
+	}
+	if v.New == nil {
+		if v.New, err = parseImageFile(v.NewPath); err != nil {
+			return err
+		}
+	}
+	v.Applied = nil
+	v.Conflicts = nil
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	for _, e := range v.Applied {
+		v.printf("%-8s %s\n", e.Kind, e.Path)
+	}
+	for _, c := range v.Conflicts {
+		v.printf("conflict %s: %s\n", c.Path, c.Reason)
+	}
+	if len(v.Applied) != 0 {
+		if err := (&Assemble{Cancelable: v.Cancelable, Options: AssembleOptionsOf(v.Context())}).Run(f); err != nil {
+			return err
+		}
+	}
+	if v.Strict && len(v.Conflicts) != 0 {
+		return fmt.Errorf("%d merge conflicts", len(v.Conflicts))
+	}
+	return nil
+}
+
+// Visit applies the Merge3 visitor to any Firmware type.
+func (v *Merge3) Visit(f uefi.Firmware) error {
+	label := diffLabel(f)
+	if diffLabel(v.Base) != label || diffLabel(v.New) != label {
+		v.conflict([]string{label}, "the images are of different types")
+		return nil
+	}
+	// The root cannot be replaced, so it is always merged child by child.
+	v.mergeChildren([]string{label}, v.Base, v.New, f)
+	return nil
+}
+
+func (v *Merge3) conflict(path []string, reason string) {
+	v.Conflicts = append(v.Conflicts, Merge3Conflict{Path: strings.Join(path, "/"), Reason: reason})
+}
+
+func (v *Merge3) apply(kind string, path []string, old, new uefi.Firmware) {
+	v.Applied = append(v.Applied, (&Diff{}).entry(kind, path, old, new))
+}
+
+// merge returns the node to use in place of ours, which is at the same
+// place as base and new.
+func (v *Merge3) merge(path []string, base, new, ours uefi.Firmware) uefi.Firmware {
+	switch {
+	case bytes.Equal(base.Buf(), new.Buf()), bytes.Equal(ours.Buf(), new.Buf()):
+		return ours
+	case bytes.Equal(base.Buf(), ours.Buf()):
+		v.apply(DiffChanged, path, ours, new)
+		return new
+	}
+	if len(children(base)) == 0 || len(children(new)) == 0 || len(children(ours)) == 0 {
+		v.conflict(path, "changed in both images")
+		return ours
+	}
+	v.mergeChildren(path, base, new, ours)
+	return ours
+}
+
+// mergeChildren merges the children of base, new and ours into ours.
+func (v *Merge3) mergeChildren(path []string, base, new, ours uefi.Firmware) {
+	_, baseNodes := diffChildren(base)
+	newKeys, newNodes := diffChildren(new)
+	oursKeys, oursNodes := diffChildren(ours)
+	child := func(k string) []string {
+		return append(append([]string{}, path...), k)
+	}
+
+	var keys []string
+	nodes := map[string]uefi.Firmware{}
+	modified := false
+	for _, k := range oursKeys {
+		b, inBase := baseNodes[k]
+		n, inNew := newNodes[k]
+		o := oursNodes[k]
+		switch {
+		case inBase && inNew:
+			if m := v.merge(child(k), b, n, o); m != o {
+				o, modified = m, true
+			}
+		case inBase && bytes.Equal(b.Buf(), o.Buf()):
+			v.apply(DiffRemoved, child(k), o, nil)
+			modified = true
+			continue
+		case inBase:
+			v.conflict(child(k), "removed in the new image and changed in the image")
+		case inNew && !bytes.Equal(n.Buf(), o.Buf()):
+			v.conflict(child(k), "added differently in both images")
+		}
+		keys = append(keys, k)
+		nodes[k] = o
+	}
+
+	// Insert what the new image added after the node preceding it there.
+	for i, k := range newKeys {
+		if _, ok := baseNodes[k]; ok {
+			if _, ok := oursNodes[k]; !ok && !bytes.Equal(baseNodes[k].Buf(), newNodes[k].Buf()) {
+				v.conflict(child(k), "changed in the new image and removed in the image")
+			}
+			continue
+		}
+		if _, ok := oursNodes[k]; ok {
+			continue
+		}
+		at := 0
+		for j := i - 1; j >= 0; j-- {
+			if p := indexOf(keys, newKeys[j]); p >= 0 {
+				at = p + 1
+				break
+			}
+		}
+		keys = append(keys[:at], append([]string{k}, keys[at:]...)...)
+		nodes[k] = newNodes[k]
+		v.apply(DiffAdded, child(k), nil, newNodes[k])
+		modified = true
+	}
+	if !modified {
+		return
+	}
+
+	merged := make([]uefi.Firmware, len(keys))
+	for i, k := range keys {
+		merged[i] = nodes[k]
+	}
+	if err := setChildren(ours, merged); err != nil {
+		v.conflict(path, err.Error())
+	}
+}
+
+func indexOf(keys []string, k string) int {
+	for i, x := range keys {
+		if x == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// setChildren replaces the children of f.
+func setChildren(f uefi.Firmware, c []uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		files := make([]*uefi.File, len(c))
+		for i := range c {
+			file, ok := c[i].(*uefi.File)
+			if !ok {
+				return fmt.Errorf("cannot put %T in a firmware volume", c[i])
+			}
+			files[i] = file
+		}
+		f.Files = files
+		return nil
+	case *uefi.File:
+		if f.NVarStore != nil {
+			break
+		}
+		sections := make([]*uefi.Section, len(c))
+		for i := range c {
+			s, ok := c[i].(*uefi.Section)
+			if !ok {
+				return fmt.Errorf("cannot put %T in a file", c[i])
+			}
+			sections[i] = s
+		}
+		f.Sections = sections
+		return nil
+	case *uefi.Section:
+		f.Encapsulated = typed(c)
+		return nil
+	case *uefi.BIOSRegion:
+		f.Elements = typed(c)
+		return nil
+	case *uefi.FlashImage:
+		if len(c) == 0 {
+			break
+		}
+		ifd, ok := c[0].(*uefi.FlashDescriptor)
+		if !ok {
+			break
+		}
+		f.IFD = *ifd
+		f.Regions = typed(c[1:])
+		return nil
+	}
+	return fmt.Errorf("cannot change the children of %T", f)
+}
+
+func typed(c []uefi.Firmware) []*uefi.TypedFirmware {
+	t := make([]*uefi.TypedFirmware, len(c))
+	for i := range c {
+		t[i] = uefi.MakeTyped(c[i])
+	}
+	return t
+}
+
+func init() {
+	RegisterCLI("merge3", "apply the changes from the BASE image to the NEW image, failing on conflicts", 2, func(args []string) (uefi.Visitor, error) {
+		return &Merge3{
+			BasePath: args[0],
+			NewPath:  args[1],
+			Strict:   true,
+			W:        os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var pciHostBridgeGUID = guid.MustParse("128FB770-5E79-4176-9E51-9BB268A17DD1")
+
+func removeAndAssemble(t *testing.T, f uefi.Firmware, g *guid.GUID) {
+	if err := (&Remove{Predicate: FindFileGUIDPredicate(*g)}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func renameAndAssemble(t *testing.T, f uefi.Firmware, g *guid.GUID, name string) {
+	file := find(t, f, g)[0].(*uefi.File)
+	for _, s := range file.Sections {
+		if s.Header.Type == uefi.SectionTypeUserInterface {
+			s.Name = name
+		}
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base, new, ours := parseImage(t), parseImage(t), parseImage(t)
+	// The vendor removes a file of the top level volume and renames
+	// DxeCore in the compressed volume, where the image removes another
+	// file.
+	removeAndAssemble(t, new, testGUID)
+	renameAndAssemble(t, new, dxeCoreGUID, "VendorCore")
+	removeAndAssemble(t, ours, pciHostBridgeGUID)
+
+	v := &Merge3{Base: base, New: new, Strict: true}
+	if err := v.Run(ours); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Conflicts) != 0 {
+		t.Errorf("got conflicts %+v, want none", v.Conflicts)
+	}
+	if len(find(t, ours, testGUID)) != 0 || len(find(t, ours, pciHostBridgeGUID)) != 0 {
+		t.Errorf("removed files are still in the merged image")
+	}
+	if name := fileName(find(t, ours, dxeCoreGUID)[0].(*uefi.File)); name != "VendorCore" {
+		t.Errorf("got DxeCore named %q, want VendorCore", name)
+	}
+
+	// The merged image is the new image without the file the image removed.
+	d := &Diff{New: ours}
+	if err := d.Run(new); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range d.Entries {
+		if e.Kind != DiffChanged && !strings.HasSuffix(e.Path, "/File("+pciHostBridgeGUID.String()+")") {
+			t.Errorf("unexpected difference with the new image %+v", e)
+		}
+	}
+}
+
+func TestMerge3Conflicts(t *testing.T) {
+	base, new, ours := parseImage(t), parseImage(t), parseImage(t)
+	renameAndAssemble(t, new, dxeCoreGUID, "VendorCore")
+	renameAndAssemble(t, ours, dxeCoreGUID, "UserCore")
+	// The vendor changes a file the image removed.
+	renameAndAssemble(t, new, pciHostBridgeGUID, "VendorBridge")
+	removeAndAssemble(t, ours, pciHostBridgeGUID)
+
+	v := &Merge3{Base: base, New: new, Strict: true}
+	if err := v.Run(ours); err == nil {
+		t.Fatal("merge succeeded, want conflicts")
+	}
+	if len(v.Conflicts) != 2 ||
+		!strings.HasSuffix(v.Conflicts[0].Path, "/File("+dxeCoreGUID.String()+")/Section(EFI_SECTION_USER_INTERFACE)") ||
+		!strings.HasSuffix(v.Conflicts[1].Path, "/File("+pciHostBridgeGUID.String()+")") {
+		t.Errorf("got conflicts %+v, want DxeCore and PciHostBridge", v.Conflicts)
+	}
+	if name := fileName(find(t, ours, dxeCoreGUID)[0].(*uefi.File)); name != "UserCore" {
+		t.Errorf("got DxeCore named %q, want UserCore", name)
+	}
+}
+
+func TestMerge3Context(t *testing.T) {
+	base, new, ours := parseImage(t), parseImage(t), parseImage(t)
+	renameAndAssemble(t, new, dxeCoreGUID, "VendorCore")
+
+	// The compressed volume of DxeCore is encoded again with the failing
+	// compressor of the context.
+	opts := &AssembleOptions{
+		Externals: map[guid.GUID]*compression.External{compression.LZMAGUID: {Encoder: []string{"false"}}},
+	}
+	v := &Merge3{Base: base, New: new}
+	v.SetContext(WithAssembleOptions(context.Background(), opts))
+	if err := v.Run(ours); err == nil {
+		t.Errorf("merged without the compressor of the context, want an error")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// RunFunc runs a visitor on the firmware, like its Run method.
+type RunFunc func(v uefi.Visitor, f uefi.Firmware) error
+
+// Middleware wraps the runs of the visitors, which it may measure, audit or
+// refuse, calling next to run them. uefi.WithVisitHooks does the same for
+// every node the visitors visit.
+type Middleware func(next RunFunc) RunFunc
+
+type middlewareKey struct{}
+
+// WithMiddleware returns a context whose visitors ExecuteCLIContext and
+// DryRunCLIContext run through mw, inside the middleware ctx already has.
+// The first of mw is the outermost.
+func WithMiddleware(ctx context.Context, mw ...Middleware) context.Context {
+	all := append(append([]Middleware{}, MiddlewareOf(ctx)...), mw...)
+	return context.WithValue(ctx, middlewareKey{}, all)
+}
+
+// MiddlewareOf returns the middleware of ctx, outermost first.
+func MiddlewareOf(ctx context.Context) []Middleware {
+	mw, _ := ctx.Value(middlewareKey{}).([]Middleware)
+	return mw
+}
+
+// runFunc returns the RunFunc running the visitors through the middleware
+// of ctx.
+func runFunc(ctx context.Context) RunFunc {
+	run := func(v uefi.Visitor, f uefi.Firmware) error {
+		return v.Run(f)
+	}
+	mw := MiddlewareOf(ctx)
+	for i := len(mw) - 1; i >= 0; i-- {
+		run = mw[i](run)
+	}
+	return run
+}
+
+// AuditRecord is the line Audit writes for each run.
+type AuditRecord struct {
+	// Visitor is the name of the type of the visitor, like Remove.
+	Visitor string  `json:"visitor"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Audit returns a Middleware writing an AuditRecord of every run to w, as
+// one JSON object per line.
+func Audit(w io.Writer) Middleware {
+	return func(next RunFunc) RunFunc {
+		return func(v uefi.Visitor, f uefi.Firmware) error {
+			start := time.Now()
+			err := next(v, f)
+			rec := AuditRecord{
+				Visitor: visitorName(v),
+				Seconds: time.Since(start).Seconds(),
+			}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			b, jerr := json.Marshal(rec)
+			if jerr != nil {
+				return jerr
+			}
+			fmt.Fprintln(w, string(b))
+			return err
+		}
+	}
+}
+
+// visitorName returns the name of the type of v, without its package.
+func visitorName(v uefi.Visitor) string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
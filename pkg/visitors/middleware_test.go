@@ -0,0 +1,104 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next RunFunc) RunFunc {
+			return func(v uefi.Visitor, f uefi.Firmware) error {
+				calls = append(calls, name+" "+visitorName(v))
+				return next(v, f)
+			}
+		}
+	}
+	ctx := WithMiddleware(context.Background(), trace("outer"))
+	ctx = WithMiddleware(ctx, trace("inner"))
+	if err := ExecuteCLIContext(ctx, parseImage(t), []uefi.Visitor{&Count{}, &Validate{}}); err != nil {
+		t.Fatal(err)
+	}
+	want := "outer Count,inner Count,outer Validate,inner Validate"
+	if got := strings.Join(calls, ","); got != want {
+		t.Errorf("got calls %q, want %q", got, want)
+	}
+}
+
+func TestMiddlewareRefuses(t *testing.T) {
+	errRefused := errors.New("refused")
+	refuse := func(next RunFunc) RunFunc {
+		return func(v uefi.Visitor, f uefi.Firmware) error {
+			if _, ok := v.(*Remove); ok {
+				return errRefused
+			}
+			return next(v, f)
+		}
+	}
+	var audit bytes.Buffer
+	ctx := WithMiddleware(context.Background(), Audit(&audit), refuse)
+	f := parseImage(t)
+	remove := &Remove{Predicate: FindFileGUIDPredicate(*testGUID)}
+	if err := ExecuteCLIContext(ctx, f, []uefi.Visitor{remove}); !errors.Is(err, errRefused) {
+		t.Fatalf("got %v, want the error of the middleware", err)
+	}
+	if got := find(t, f, testGUID); len(got) != 1 {
+		t.Errorf("found %d files after the refused removal, want 1", len(got))
+	}
+	var rec AuditRecord
+	if err := json.Unmarshal(audit.Bytes(), &rec); err != nil {
+		t.Fatalf("got %q, want a JSON line: %v", audit.String(), err)
+	}
+	if rec.Visitor != "Remove" || rec.Error != "refused" {
+		t.Errorf("got %+v, want the refused Remove", rec)
+	}
+}
+
+func TestVisitHooks(t *testing.T) {
+	var pre, post int
+	ctx := uefi.WithVisitHooks(context.Background(), &uefi.VisitHooks{
+		PreVisit: func(v uefi.Visitor, f uefi.Firmware) error {
+			pre++
+			return nil
+		},
+		PostVisit: func(v uefi.Visitor, f uefi.Firmware, err error) error {
+			post++
+			return err
+		},
+	})
+	count := &Count{}
+	if err := ExecuteCLIContext(ctx, parseImage(t), []uefi.Visitor{count}); err != nil {
+		t.Fatal(err)
+	}
+	nodes := 0
+	for _, n := range count.FirmwareTypeCount {
+		nodes += n
+	}
+	if pre != nodes || post != nodes {
+		t.Errorf("got %d pre and %d post visits, want one of each for the %d nodes", pre, post, nodes)
+	}
+
+	errStop := errors.New("stop")
+	ctx = uefi.WithVisitHooks(ctx, &uefi.VisitHooks{
+		PreVisit: func(v uefi.Visitor, f uefi.Firmware) error {
+			if _, ok := f.(*uefi.File); ok {
+				return errStop
+			}
+			return nil
+		},
+	})
+	if err := ExecuteCLIContext(ctx, parseImage(t), []uefi.Visitor{&Count{}}); !errors.Is(err, errStop) {
+		t.Errorf("got %v, want the error of the hook", err)
+	}
+}
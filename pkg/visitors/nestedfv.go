@@ -0,0 +1,98 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// DumpFV writes a firmware volume to a standalone .fv file. The volume is
+// either matched itself, or is the first one nested in a matched file, like
+// the compressed DXE volume EDK2 puts in a FIRMWARE_VOLUME_IMAGE file. The
+// volume is assembled first, so the file has the edits made to it.
+type DumpFV struct {
+	Cancelable
+
+	// Input
+	Predicate FindPredicate
+	Path      string
+
+	// Output
+	Volume *uefi.FirmwareVolume
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *DumpFV) Run(f uefi.Firmware) error {
+	match, err := FindExactlyOne(f, v.Predicate)
+	if err != nil {
+		return err
+	}
+
+	v.Volume = nil
+	if err := match.Apply(v); err != nil {
+		return err
+	}
+	if v.Volume == nil {
+		return fmt.Errorf("no firmware volume in %v", match)
+	}
+	if err := (&Assemble{Cancelable: v.Cancelable}).Run(v.Volume); err != nil {
+		return err
+	}
+	return os.WriteFile(v.Path, v.Volume.Buf(), 0666)
+}
+
+// Visit applies the DumpFV visitor to any Firmware type.
+func (v *DumpFV) Visit(f uefi.Firmware) error {
+	if v.Volume != nil {
+		return nil
+	}
+	if fv, ok := f.(*uefi.FirmwareVolume); ok {
+		v.Volume = fv
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+// CreateVolumeImageFile wraps the firmware volume in buf, like one DumpFV
+// writes, in a new FIRMWARE_VOLUME_IMAGE file to insert into a volume. With
+// compress, the volume image section is in an LZMA compressed section, which
+// is how EDK2 nests the DXE volume. The file GUID is derived from its
+// contents.
+func CreateVolumeImageFile(buf []byte, compress bool) (*uefi.File, error) {
+	fv, err := uefi.NewFirmwareVolume(buf, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if fv.Length != uint64(len(buf)) {
+		return nil, fmt.Errorf("firmware volume is %#x bytes, but has a length of %#x", len(buf), fv.Length)
+	}
+	s, err := uefi.CreateSection(uefi.SectionTypeFirmwareVolumeImage, []byte{}, []uefi.Firmware{fv}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if compress {
+		if s, err = uefi.CreateSection(uefi.SectionTypeGUIDDefined, []byte{}, []uefi.Firmware{s}, &compression.LZMAGUID); err != nil {
+			return nil, err
+		}
+	}
+	return createVolumeImageFile(s)
+}
+
+func init() {
+	RegisterCLI("dump_fv", "dump a firmware volume, or the first one nested in a file, to a .fv file", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFileFVPredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &DumpFV{
+			Predicate: pred,
+			Path:      args[1],
+		}, nil
+	})
+}
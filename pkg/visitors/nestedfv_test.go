@@ -0,0 +1,96 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestDumpAndWrapFV(t *testing.T) {
+	const (
+		volumeFile = "9E21FD93-9C72-4C15-8C4B-E77F1DB2D792"
+		peiFV      = "6938079B-B503-4E3D-9D24-B28337A25806"
+		dxeFV      = "7CB8BDC9-F8EB-4F34-AAEA-3EE4AF6516A1"
+	)
+	dir := t.TempDir()
+	dump := func(f uefi.Firmware, name, path string) []byte {
+		t.Helper()
+		pred, err := FindFileFVPredicate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&DumpFV{Predicate: pred, Path: path}).Run(f); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+
+	f := parseImage(t)
+	// The file holds the PEI volume first.
+	pei := dump(f, volumeFile, filepath.Join(dir, "file.fv"))
+	if got := dump(f, peiFV, filepath.Join(dir, "pei.fv")); !bytes.Equal(got, pei) {
+		t.Errorf("dumping the file did not dump its first volume")
+	}
+	dxe := dump(f, dxeFV, filepath.Join(dir, "dxe.fv"))
+
+	// Replace the file with one for each volume, one of them compressed.
+	pred, err := FindFileFVPredicate(volumeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dxeFile, err := CreateVolumeImageFile(dxe, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Insert{Predicate: pred, NewFile: dxeFile, InsertType: InsertTypeReplaceFFS}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	peiFile, err := CreateVolumeImageFile(pei, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred = FindFileGUIDPredicate(dxeFile.Header.GUID)
+	if err := (&Insert{Predicate: pred, NewFile: peiFile, InsertType: InsertTypeBefore}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dump(parsed, peiFV, filepath.Join(dir, "pei2.fv")); !bytes.Equal(got, pei) {
+		t.Errorf("the wrapped PEI volume changed")
+	}
+	if got := dump(parsed, dxeFV, filepath.Join(dir, "dxe2.fv")); !bytes.Equal(got, dxe) {
+		t.Errorf("the compressed DXE volume changed")
+	}
+	if len(find(t, parsed, guid.MustParse(volumeFile))) != 0 {
+		t.Errorf("the replaced file is still in the image")
+	}
+
+	if _, err := CreateVolumeImageFile(pei[:len(pei)-8], false); err == nil {
+		t.Errorf("wrapping a truncated volume succeeded, want an error")
+	}
+	pred, err = FindFileFVPredicate(testGUID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&DumpFV{Predicate: pred, Path: filepath.Join(dir, "sec.fv")}).Run(f); err == nil {
+		t.Errorf("dumping a file without a volume succeeded, want an error")
+	}
+}
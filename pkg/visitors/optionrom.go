@@ -0,0 +1,168 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoOptionROM = errors.New("no option ROM found")
+
+// findEFIOptionROMImage returns the first EFI option ROM image for the PCI
+// device vendor:device.
+func findEFIOptionROMImage(f uefi.Firmware, vendor, device uint16) (*uefi.OptionROMImage, error) {
+	find := &Find{
+		Predicate: func(f uefi.Firmware) bool {
+			img, ok := f.(*uefi.OptionROMImage)
+			return ok && img.EFI != nil && img.PCIR.VendorID == vendor && img.PCIR.DeviceID == device
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return nil, err
+	}
+	if len(find.Matches) == 0 {
+		return nil, fmt.Errorf("no EFI option ROM image for %04x:%04x", vendor, device)
+	}
+	return find.Matches[0].(*uefi.OptionROMImage), nil
+}
+
+// OptionROMDriver writes the EFI driver of an option ROM image to a file,
+// decompressed if needed.
+type OptionROMDriver struct {
+	// Input
+	VendorID uint16
+	DeviceID uint16
+	OutPath  string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *OptionROMDriver) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the OptionROMDriver visitor to any Firmware type.
+func (v *OptionROMDriver) Visit(f uefi.Firmware) error {
+	img, err := findEFIOptionROMImage(f, v.VendorID, v.DeviceID)
+	if err != nil {
+		return err
+	}
+	d, err := img.Driver()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(v.OutPath, d, 0666)
+}
+
+// ReplaceOptionROMDriver replaces the EFI driver of an option ROM image,
+// compressing it if the image was compressed.
+type ReplaceOptionROMDriver struct {
+	// Input
+	VendorID  uint16
+	DeviceID  uint16
+	NewDriver []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceOptionROMDriver) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ReplaceOptionROMDriver visitor to any Firmware type.
+func (v *ReplaceOptionROMDriver) Visit(f uefi.Firmware) error {
+	img, err := findEFIOptionROMImage(f, v.VendorID, v.DeviceID)
+	if err != nil {
+		return err
+	}
+	return img.SetDriver(v.NewDriver)
+}
+
+func parsePCIID(s string) (uint16, uint16, error) {
+	var vendor, device uint16
+	if _, err := fmt.Sscanf(s, "%x:%x", &vendor, &device); err != nil {
+		return 0, 0, fmt.Errorf("PCI ID %q is not VENDOR:DEVICE in hex: %v", s, err)
+	}
+	return vendor, device, nil
+}
+
+// StripOptionROM removes the images of a code type, e.g. the legacy ones,
+// from all PCI option ROMs.
+type StripOptionROM struct {
+	// Input
+	CodeType uefi.OptionROMCodeType
+
+	// Output
+	Removed int
+
+	found bool
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *StripOptionROM) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if !v.found {
+		return errNoOptionROM
+	}
+	return nil
+}
+
+// Visit applies the StripOptionROM visitor to any Firmware type.
+func (v *StripOptionROM) Visit(f uefi.Firmware) error {
+	r, ok := f.(*uefi.OptionROM)
+	if !ok {
+		return f.ApplyChildren(v)
+	}
+	v.found = true
+	for _, img := range r.Images {
+		if img.PCIR.CodeType != v.CodeType {
+			v.Removed += r.RemoveImages(v.CodeType)
+			return nil
+		}
+	}
+	return fmt.Errorf("option ROM only holds %v images, not removing them all", v.CodeType)
+}
+
+func init() {
+	RegisterCLI("dump_oprom_driver", "write the EFI driver of the option ROM for VENDOR:DEVICE to a file", 2, func(args []string) (uefi.Visitor, error) {
+		vendor, device, err := parsePCIID(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &OptionROMDriver{
+			VendorID: vendor,
+			DeviceID: device,
+			OutPath:  args[1],
+		}, nil
+	})
+	RegisterCLI("replace_oprom_driver", "replace the EFI driver of the option ROM for VENDOR:DEVICE", 2, func(args []string) (uefi.Visitor, error) {
+		vendor, device, err := parsePCIID(args[0])
+		if err != nil {
+			return nil, err
+		}
+		newDriver, err := os.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceOptionROMDriver{
+			VendorID:  vendor,
+			DeviceID:  device,
+			NewDriver: newDriver,
+		}, nil
+	})
+	RegisterCLI("strip_oprom", "remove images of a code type (legacy, efi) from PCI option ROMs", 1, func(args []string) (uefi.Visitor, error) {
+		t, err := uefi.ParseOptionROMCodeType(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &StripOptionROM{
+			CodeType: t,
+		}, nil
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// optionROM returns a legacy and an EFI image of one block each.
+func optionROM() []byte {
+	var rom []byte
+	for i, t := range []uefi.OptionROMCodeType{uefi.CodeTypeX86, uefi.CodeTypeEFI} {
+		img := make([]byte, uefi.OptionROMBlockSize)
+		copy(img, uefi.OptionROMSignature)
+		binary.LittleEndian.PutUint32(img[4:], uefi.EFIROMSignature)
+		binary.LittleEndian.PutUint16(img[0x16:], 0x38)
+		binary.LittleEndian.PutUint16(img[0x18:], 0x1c)
+		p := uefi.PCIRHeader{VendorID: 0x8086, DeviceID: 0x1234, ImageLength: 1, CodeType: t}
+		copy(p.Signature[:], uefi.PCIRSignature)
+		if i == 1 {
+			p.Indicator = 0x80
+		}
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, &p)
+		copy(img[0x1c:], b.Bytes())
+		rom = append(rom, img...)
+	}
+	return rom
+}
+
+func TestStripOptionROM(t *testing.T) {
+	rom := optionROM()
+	g := guid.MustParse("12345678-9abc-def0-1234-56789abcdef0")
+	s, err := uefi.CreateSection(uefi.SectionTypeFreeformSubtypeGUID, append(append([]byte{}, g[:]...), rom...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = uefi.NewSection(s.Buf(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &StripOptionROM{CodeType: uefi.CodeTypeX86}
+	if err := v.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if v.Removed != 1 {
+		t.Errorf("removed %d images, want 1", v.Removed)
+	}
+	if err := s.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = uefi.NewSection(s.Buf(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf()[s.HeaderLen():s.HeaderLen()+16], g[:]) {
+		t.Errorf("freeform section lost its GUID")
+	}
+	if s.OptionROM == nil || len(s.OptionROM.Images) != 1 || !bytes.Equal(s.OptionROM.Buf(), rom[uefi.OptionROMBlockSize:]) {
+		t.Fatalf("strip_oprom: option ROM does not hold just the EFI image")
+	}
+
+	if err := (&StripOptionROM{CodeType: uefi.CodeTypeEFI}).Run(s); err == nil {
+		t.Errorf("removing the last image: got nil, want error")
+	}
+	raw, _ := uefi.CreateSection(uefi.SectionTypeRaw, []byte("raw"), nil, nil)
+	if err := (&StripOptionROM{}).Run(raw); err != errNoOptionROM {
+		t.Errorf("section without option ROM: got %v, want %v", err, errNoOptionROM)
+	}
+}
+
+func TestAssembleOptionROMTrailingData(t *testing.T) {
+	rom := optionROM()
+	pad := bytes.Repeat([]byte{0xff}, 0x204)
+	s, err := uefi.CreateSection(uefi.SectionTypeRaw, append(append([]byte{}, rom...), pad...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	orig := append([]byte{}, s.Buf()...)
+	if s, err = uefi.NewSection(orig, 0); err != nil {
+		t.Fatal(err)
+	}
+	if s.OptionROM == nil {
+		t.Fatal("no option ROM parsed")
+	}
+
+	if err := s.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf(), orig) {
+		t.Errorf("unchanged section: got %#x bytes, want the %#x bytes parsed", len(s.Buf()), len(orig))
+	}
+
+	if err := (&StripOptionROM{CodeType: uefi.CodeTypeX86}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{}, rom[uefi.OptionROMBlockSize:]...), pad...)
+	if got := s.Buf()[s.HeaderLen():]; !bytes.Equal(got, want) {
+		t.Errorf("stripped section: got %#x bytes, want the EFI image and the padding, %#x bytes", len(got), len(want))
+	}
+}
+
+func TestReplaceOptionROMDriver(t *testing.T) {
+	s, err := uefi.CreateSection(uefi.SectionTypeRaw, optionROM(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = uefi.NewSection(s.Buf(), 0); err != nil {
+		t.Fatal(err)
+	}
+	driver := bytes.Repeat([]byte{0x4d, 0x5a}, 0x180)
+	if err := (&ReplaceOptionROMDriver{VendorID: 0x8086, DeviceID: 0x1234, NewDriver: driver}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = uefi.NewSection(s.Buf(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.OptionROM.Images); n != 2 || len(s.OptionROM.Images[1].Buf()) != 2*uefi.OptionROMBlockSize {
+		t.Fatalf("replace_oprom_driver: got %d images, want the EFI one grown to 2 blocks", n)
+	}
+
+	out := filepath.Join(t.TempDir(), "driver.efi")
+	if err := (&OptionROMDriver{VendorID: 0x8086, DeviceID: 0x1234, OutPath: out}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, driver) {
+		t.Errorf("dump_oprom_driver: got %#x bytes, not the driver", len(got))
+	}
+	if err := (&OptionROMDriver{VendorID: 0x8086, DeviceID: 0x4321, OutPath: out}).Run(s); err == nil {
+		t.Errorf("dump_oprom_driver of unknown device: got nil, want error")
+	}
+	if _, _, err := parsePCIID("8086"); err == nil {
+		t.Errorf("parsePCIID(8086): got nil, want error")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Policies of the pad files of volumes on assembly.
+const (
+	// PadPreserve keeps pad files byte for byte, for vendor tools which
+	// checksum the padding.
+	PadPreserve = "preserve"
+	// PadErase regenerates pad files erased with the polarity of the
+	// volume, so the padding does not depend on what the vendor left there.
+	PadErase = "erase"
+	// PadMinimize merges consecutive pad files into one erased pad file, and
+	// drops the pad files before the free space.
+	PadMinimize = "minimize"
+)
+
+// CheckPadPolicy returns an error if policy is not one of the pad file
+// policies of AssembleOptions.
+func CheckPadPolicy(policy string) error {
+	switch policy {
+	case "", PadPreserve, PadErase, PadMinimize:
+		return nil
+	}
+	return fmt.Errorf("unknown pad file policy %q, want preserve, erase or minimize", policy)
+}
+
+// isPadFile checks if a file is a pad file Assemble may change, not one
+// aligned itself.
+func isPadFile(f *uefi.File) bool {
+	return f.Header.Type == uefi.FVFileTypePad && f.Header.Attributes.GetAlignment() == 1
+}
+
+// padFiles returns the files of the volume with their pad files changed
+// according to the pad policy. Files other than pad files keep their
+// offsets.
+func padFiles(fv *uefi.FirmwareVolume, policy string) ([]*uefi.File, error) {
+	if err := CheckPadPolicy(policy); err != nil {
+		return nil, err
+	}
+	if policy == "" || policy == PadPreserve {
+		return fv.Files, nil
+	}
+	ep := fv.GetErasePolarity()
+	var files []*uefi.File
+	for i := 0; i < len(fv.Files); i++ {
+		f := fv.Files[i]
+		if !isPadFile(f) {
+			files = append(files, f)
+			continue
+		}
+		size := f.Header.ExtendedSize
+		if policy == PadMinimize {
+			// Files are 8-byte aligned, pad files included.
+			j := i + 1
+			for ; j < len(fv.Files) && isPadFile(fv.Files[j]); j++ {
+				merged := uefi.Align8(size) + fv.Files[j].Header.ExtendedSize
+				if merged > 0xFFFFFF {
+					break
+				}
+				size = merged
+			}
+			if j == len(fv.Files) {
+				// Only the free space follows.
+				break
+			}
+			i = j - 1
+		}
+		pad, err := uefi.CreateErasedPadFile(size, ep)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pad)
+	}
+	return files, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestPadFiles(t *testing.T) {
+	file := func(typ uefi.FVFileType, size uint64, fill byte) *uefi.File {
+		f, err := uefi.CreateErasedPadFile(size, 0xFF)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Header.Type = typ
+		for i := uefi.FileHeaderMinLength; i < len(f.Buf()); i++ {
+			f.Buf()[i] = fill
+		}
+		return f
+	}
+	raw := file(uefi.FVFileTypeRaw, 0x40, 0x12)
+	fv := &uefi.FirmwareVolume{}
+	fv.Attributes = 0x800 // Erased to 0xFF.
+	fv.Files = []*uefi.File{
+		file(uefi.FVFileTypePad, 0x1C, 0x34),
+		file(uefi.FVFileTypePad, 0x30, 0x56),
+		raw,
+		file(uefi.FVFileTypePad, 0x40, 0x78),
+	}
+
+	for _, tt := range []struct {
+		policy string
+		sizes  []uint64
+		raw    int
+	}{
+		{PadPreserve, []uint64{0x1C, 0x30, 0x40, 0x40}, 2},
+		{PadErase, []uint64{0x1C, 0x30, 0x40, 0x40}, 2},
+		// The second pad file starts 0x20 bytes after the first, since
+		// files are 8-byte aligned. The last one is dropped.
+		{PadMinimize, []uint64{0x50, 0x40}, 1},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			files, err := padFiles(fv, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.sizes) {
+				t.Fatalf("got %d files, want %d", len(files), len(tt.sizes))
+			}
+			for i, f := range files {
+				if f.Header.ExtendedSize != tt.sizes[i] {
+					t.Errorf("file %d: got %#x bytes, want %#x", i, f.Header.ExtendedSize, tt.sizes[i])
+				}
+				erased := uefi.IsErased(f.Buf()[uefi.FileHeaderMinLength:], 0xFF)
+				if f.Header.Type == uefi.FVFileTypePad && erased == (tt.policy == PadPreserve) {
+					t.Errorf("file %d: erased is %v under the %s policy", i, erased, tt.policy)
+				}
+			}
+			if files[tt.raw] != raw {
+				t.Errorf("the raw file was changed")
+			}
+		})
+	}
+
+	if _, err := padFiles(fv, "shrink"); err == nil {
+		t.Errorf("padding with an unknown policy succeeded, want an error")
+	}
+	if err := (&Assemble{Options: &AssembleOptions{PadPolicy: "shrink"}}).Run(fv); err == nil {
+		t.Errorf("assembling with an unknown policy succeeded, want an error")
+	}
+}
@@ -6,15 +6,28 @@ package visitors
 
 import (
 	"errors"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
-// ParseDir creates the firmware tree and reads the binaries from the provided directory
+// ParseDir creates the firmware tree and reads the binaries from the provided directory,
+// or from the provided .tar or .zip archive.
 type ParseDir struct {
 	BasePath string
+	// FS is read from instead of BasePath, if set.
+	FS fs.FS
+}
+
+// fsys returns the file system to read from.
+func (v *ParseDir) fsys() fs.FS {
+	if v.FS != nil {
+		return v.FS
+	}
+	return os.DirFS(v.BasePath)
 }
 
 // Run is not actually implemented cause we can't fit the interface
@@ -24,8 +37,16 @@ func (v *ParseDir) Run(f uefi.Firmware) error {
 
 // Parse parses a directory and creates the tree.
 func (v *ParseDir) Parse() (uefi.Firmware, error) {
+	if v.FS == nil && IsArchive(v.BasePath) {
+		fsys, err := readArchive(v.BasePath)
+		if err != nil {
+			return nil, err
+		}
+		v.FS = fsys
+	}
+
 	// Read in the json and construct the tree.
-	jsonbuf, err := os.ReadFile(filepath.Join(v.BasePath, "summary.json"))
+	jsonbuf, err := v.readBuf("summary.json")
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +63,9 @@ func (v *ParseDir) Parse() (uefi.Firmware, error) {
 
 func (v *ParseDir) readBuf(ExtractPath string) ([]byte, error) {
 	if ExtractPath != "" {
-		return os.ReadFile(filepath.Join(v.BasePath, ExtractPath))
+		// Trees extracted on Windows before ExtractPath was slash
+		// separated have backslashes.
+		return fs.ReadFile(v.fsys(), path.Clean(filepath.ToSlash(ExtractPath)))
 	}
 	return nil, nil
 }
@@ -62,6 +85,9 @@ func (v *ParseDir) Visit(f uefi.Firmware) error {
 	case *uefi.Section:
 		fBuf, err = v.readBuf(f.ExtractPath)
 
+	case *uefi.OptionROMImage:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
 	case *uefi.NVar:
 		if f.IsValid() {
 			var fValBuf []byte
@@ -85,6 +111,12 @@ func (v *ParseDir) Visit(f uefi.Firmware) error {
 
 	case *uefi.BIOSPadding:
 		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.BIOSBackup:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.ECFirmware:
+		fBuf, err = v.readBuf(f.ExtractPath)
 	}
 
 	if err != nil {
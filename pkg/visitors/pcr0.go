@@ -0,0 +1,63 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/measuredboot"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// PCR0 precomputes the Boot Guard measurement into PCR0 of the image.
+// It must be applied to the root, which is mapped below 4GiB.
+type PCR0 struct {
+	// Input
+	Hash            crypto.Hash
+	ACMPolicyStatus uint64
+	W               io.Writer
+
+	// Output
+	Log *measuredboot.Log
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *PCR0) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the PCR0 visitor to any Firmware type.
+func (v *PCR0) Visit(f uefi.Firmware) error {
+	l, err := measuredboot.BootGuard(f.Buf(), v.Hash, v.ACMPolicyStatus)
+	if err != nil {
+		return err
+	}
+	v.Log = l
+	if v.W != nil {
+		l.Print(v.W)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCLI("pcr0", "precompute the Boot Guard PCR0 given the hash of the bank and the ACM policy status", 2, func(args []string) (uefi.Visitor, error) {
+		h, err := measuredboot.ParseHash(args[0])
+		if err != nil {
+			return nil, err
+		}
+		status, err := strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &PCR0{
+			Hash:            h,
+			ACMPolicyStatus: status,
+			W:               os.Stdout,
+		}, nil
+	})
+}
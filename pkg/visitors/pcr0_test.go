@@ -0,0 +1,23 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto"
+	"testing"
+)
+
+func TestPCR0NoBootGuard(t *testing.T) {
+	f := parseImage(t)
+
+	// OVMF has no FIT, hence no Boot Guard.
+	v := &PCR0{Hash: crypto.SHA256}
+	if err := v.Run(f); err == nil {
+		t.Errorf("PCR0 of OVMF: got nil, want error")
+	}
+	if v.Log != nil {
+		t.Errorf("PCR0 of OVMF: got a log")
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+// PersistenceAgent describes a well known agent which firmware installs
+// into the operating system. Only GUIDs identify its files and NVRAM
+// variables for removal; strings and variable names are too common for
+// that, so their matches are only reported as hints.
+type PersistenceAgent struct {
+	Name        string
+	Description string
+	// GUIDs are the file GUIDs of its drivers.
+	GUIDs []string `json:",omitempty"`
+	// VariableGUIDs are the vendor GUIDs of its NVRAM variables.
+	VariableGUIDs []string `json:",omitempty"`
+	// Strings found, in ASCII or UCS-2, in the images of its files. Data
+	// sections are not searched, so ACPI tables are not mistaken for the
+	// drivers installing them.
+	Strings []string `json:",omitempty"`
+	// Variables matches the names of its NVRAM variables.
+	Variables *regexp.Regexp `json:"-"`
+}
+
+// PersistenceAgents are the agents DetectPersistence looks for. They come
+// without GUIDs, which differ between vendors; LoadPersistenceAgents reads
+// those of the images at hand.
+var PersistenceAgents = []PersistenceAgent{
+	{
+		Name:        "Computrace",
+		Description: "Absolute Computrace/LoJack, which drops rpcnetp.exe into Windows",
+		Strings:     []string{"rpcnetp.exe", "namequery.com", "Computrace"},
+		Variables:   regexp.MustCompile(`(?i)computrace|lojack`),
+	},
+	{
+		Name:        "WPBT",
+		Description: "driver installing a Windows Platform Binary Table, which Windows runs at boot",
+		Strings:     []string{"WPBT"},
+		Variables:   regexp.MustCompile(`(?i)wpbt`),
+	},
+}
+
+// LoadPersistenceAgents reads a JSON list of agents, identified by the
+// GUIDs of their files and NVRAM variables.
+func LoadPersistenceAgents(r io.Reader) ([]PersistenceAgent, error) {
+	var agents []PersistenceAgent
+	if err := json.NewDecoder(r).Decode(&agents); err != nil {
+		return nil, err
+	}
+	for i := range agents {
+		if err := agents[i].parseGUIDs(map[guid.GUID]string{}, map[guid.GUID]string{}); err != nil {
+			return nil, err
+		}
+	}
+	return agents, nil
+}
+
+// parseGUIDs adds the file and variable GUIDs of a to the maps from GUID to
+// agent name.
+func (a *PersistenceAgent) parseGUIDs(files, vars map[guid.GUID]string) error {
+	for _, l := range []struct {
+		strs []string
+		m    map[guid.GUID]string
+	}{{a.GUIDs, files}, {a.VariableGUIDs, vars}} {
+		for _, s := range l.strs {
+			g, err := guid.Parse(s)
+			if err != nil {
+				return fmt.Errorf("%s: %v", a.Name, err)
+			}
+			if _, ok := l.m[*g]; !ok {
+				l.m[*g] = a.Name
+			}
+		}
+	}
+	return nil
+}
+
+// PersistenceHit is a file or an NVRAM variable of a persistence agent.
+type PersistenceHit struct {
+	Agent string
+	// Either File or Variable is set.
+	File     *guid.GUID `json:",omitempty"`
+	Name     string     `json:",omitempty"`
+	Variable string     `json:",omitempty"`
+	// Hint is set if only a string or the variable name matched. Hints
+	// are never removed.
+	Hint bool `json:",omitempty"`
+}
+
+// DetectPersistence finds the files and NVRAM variables of persistence
+// agents and, if Remove is set, removes the files and invalidates the
+// variables matched by GUID.
+type DetectPersistence struct {
+	// Input
+	Agents []PersistenceAgent
+	Remove bool
+
+	// Output
+	Hits []PersistenceHit
+	// logs are written to this writer.
+	W io.Writer
+
+	file    *uefi.File
+	name    string
+	files   map[*uefi.File]bool
+	nvars   map[*uefi.NVar]bool
+	matched map[*uefi.File]string
+	// fileGUIDs and varGUIDs map GUIDs to agent names.
+	fileGUIDs map[guid.GUID]string
+	varGUIDs  map[guid.GUID]string
+}
+
+func (v *DetectPersistence) printf(format string, a ...interface{}) {
+	if v.W != nil {
+		fmt.Fprintf(v.W, format, a...)
+	}
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *DetectPersistence) Run(f uefi.Firmware) error {
+	if v.Agents == nil {
+		v.Agents = PersistenceAgents
+	}
+	v.fileGUIDs = map[guid.GUID]string{}
+	v.varGUIDs = map[guid.GUID]string{}
+	for i := range v.Agents {
+		if err := v.Agents[i].parseGUIDs(v.fileGUIDs, v.varGUIDs); err != nil {
+			return err
+		}
+	}
+	v.files = map[*uefi.File]bool{}
+	v.nvars = map[*uefi.NVar]bool{}
+	v.matched = map[*uefi.File]string{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	for _, h := range v.Hits {
+		hint := ""
+		if h.Hint {
+			hint = " (hint, not removed)"
+		}
+		if h.File != nil {
+			v.printf("%s: file %v %s%s\n", h.Agent, h.File, h.Name, hint)
+		} else {
+			v.printf("%s: variable %s%s\n", h.Agent, h.Variable, hint)
+		}
+	}
+	if !v.Remove {
+		return nil
+	}
+
+	remove := &Remove{
+		Predicate: func(f uefi.Firmware) bool {
+			file, ok := f.(*uefi.File)
+			return ok && v.files[file]
+		},
+		W: v.W,
+	}
+	if err := remove.Run(f); err != nil {
+		return err
+	}
+	invalidate := &NVarInvalidate{
+		Predicate: func(f uefi.Firmware) bool {
+			nvar, ok := f.(*uefi.NVar)
+			return ok && v.nvars[nvar]
+		},
+		W: v.W,
+	}
+	return invalidate.Run(f)
+}
+
+// Visit applies the DetectPersistence visitor to any Firmware type.
+func (v *DetectPersistence) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		parent, parentName := v.file, v.name
+		v.file, v.name = f, ""
+		if err := f.ApplyChildren(v); err != nil {
+			return err
+		}
+		g := f.Header.GUID
+		if agent, ok := v.fileGUIDs[g]; ok {
+			v.Hits = append(v.Hits, PersistenceHit{Agent: agent, File: &g, Name: v.name})
+			v.files[f] = true
+		} else if agent, ok := v.matched[f]; ok {
+			v.Hits = append(v.Hits, PersistenceHit{Agent: agent, File: &g, Name: v.name, Hint: true})
+		}
+		v.file, v.name = parent, parentName
+		return nil
+
+	case *uefi.Section:
+		if v.file == nil {
+			return f.ApplyChildren(v)
+		}
+		if f.Header.Type == uefi.SectionTypeUserInterface {
+			v.name = f.Name
+		}
+		if _, ok := v.matched[v.file]; !ok && isImageSection(f.Header.Type) {
+			if agent := v.matchStrings(f.Buf()); agent != "" {
+				v.matched[v.file] = agent
+			}
+		}
+
+	case *uefi.NVar:
+		if !f.IsValid() {
+			break
+		}
+		if agent, ok := v.varGUIDs[f.GUID]; ok {
+			v.Hits = append(v.Hits, PersistenceHit{Agent: agent, Variable: f.Name})
+			v.nvars[f] = true
+			break
+		}
+		for _, a := range v.Agents {
+			if a.Variables != nil && a.Variables.MatchString(f.Name) {
+				v.Hits = append(v.Hits, PersistenceHit{Agent: a.Name, Variable: f.Name, Hint: true})
+				break
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func isImageSection(t uefi.SectionType) bool {
+	return t == uefi.SectionTypePE32 || t == uefi.SectionTypeTE || t == uefi.SectionTypePIC
+}
+
+// matchStrings returns the name of the first agent with a string in buf.
+func (v *DetectPersistence) matchStrings(buf []byte) string {
+	for _, a := range v.Agents {
+		for _, s := range a.Strings {
+			if bytes.Contains(buf, []byte(s)) || bytes.Contains(buf, ucs2(s)) {
+				return a.Name
+			}
+		}
+	}
+	return ""
+}
+
+// ucs2 returns s in UCS-2 without terminator.
+func ucs2(s string) []byte {
+	return bytes.TrimSuffix(unicode.UTF8ToUCS2(s), []byte{0, 0})
+}
+
+func genPersistenceCLI(remove bool) func(args []string) (uefi.Visitor, error) {
+	return func(args []string) (uefi.Visitor, error) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		agents, err := LoadPersistenceAgents(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", args[0], err)
+		}
+		return &DetectPersistence{
+			Agents: append(append([]PersistenceAgent{}, PersistenceAgents...), agents...),
+			Remove: remove,
+			W:      os.Stdout,
+		}, nil
+	}
+}
+
+func init() {
+	RegisterCLI("detect_persistence", "detect Computrace and WPBT persistence agents and their NVRAM variables", 0, func(args []string) (uefi.Visitor, error) {
+		return &DetectPersistence{
+			W: os.Stdout,
+		}, nil
+	})
+	RegisterCLI("detect_persistence_agents", "detect persistence agents of a JSON agent list and the built-in ones", 1, genPersistenceCLI(false))
+	RegisterCLI("remove_persistence", "remove the files and invalidate the NVRAM variables of persistence agents by the GUIDs of a JSON agent list", 1, genPersistenceCLI(true))
+}
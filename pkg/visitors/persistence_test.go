@@ -0,0 +1,145 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestDetectPersistence(t *testing.T) {
+	f := parseImage(t)
+
+	// Turn DxeCore into Computrace.
+	dxeCore := find(t, f, dxeCoreGUID)[0].(*uefi.File)
+	for _, s := range dxeCore.Sections {
+		if s.Header.Type == uefi.SectionTypePE32 {
+			buf := append([]byte{}, s.Buf()...)
+			copy(buf[len(buf)-0x40:], ucs2("RPCNETP.EXE rpcnetp.exe"))
+			s.SetBuf(buf)
+		}
+	}
+
+	v := &DetectPersistence{Remove: true}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Hits) != 1 || v.Hits[0].Agent != "Computrace" || *v.Hits[0].File != *dxeCoreGUID || v.Hits[0].Name != "DxeCore" || !v.Hits[0].Hint {
+		t.Fatalf("DetectPersistence: got %+v, want DxeCore as Computrace hint", v.Hits)
+	}
+	if len(find(t, f, dxeCoreGUID)) != 1 {
+		t.Fatalf("DetectPersistence removed DxeCore matched by strings only")
+	}
+
+	v = &DetectPersistence{
+		Agents: []PersistenceAgent{{Name: "Computrace", GUIDs: []string{dxeCoreGUID.String()}}},
+		Remove: true,
+	}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Hits) != 1 || v.Hits[0].Hint {
+		t.Fatalf("DetectPersistence: got %+v, want DxeCore as Computrace", v.Hits)
+	}
+	if len(find(t, f, dxeCoreGUID)) != 0 {
+		t.Errorf("DetectPersistence did not remove DxeCore")
+	}
+}
+
+func TestDetectPersistenceWPBTString(t *testing.T) {
+	f := parseImage(t)
+
+	// An unrelated driver merely mentioning the table is no WPBT agent.
+	dxeCore := find(t, f, dxeCoreGUID)[0].(*uefi.File)
+	for _, s := range dxeCore.Sections {
+		if s.Header.Type == uefi.SectionTypePE32 {
+			buf := append([]byte{}, s.Buf()...)
+			copy(buf[len(buf)-0x10:], "WPBT")
+			s.SetBuf(buf)
+		}
+	}
+
+	v := &DetectPersistence{Remove: true}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Hits) != 1 || v.Hits[0].Agent != "WPBT" || !v.Hits[0].Hint {
+		t.Errorf("DetectPersistence: got %+v, want DxeCore as WPBT hint", v.Hits)
+	}
+	if len(find(t, f, dxeCoreGUID)) != 1 {
+		t.Errorf("DetectPersistence removed DxeCore containing WPBT")
+	}
+}
+
+func TestLoadPersistenceAgents(t *testing.T) {
+	agents, err := LoadPersistenceAgents(strings.NewReader(`[{"Name": "Test", "GUIDs": ["` + dxeCoreGUID.String() + `"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].Name != "Test" || len(agents[0].GUIDs) != 1 {
+		t.Errorf("LoadPersistenceAgents: got %+v", agents)
+	}
+	if _, err := LoadPersistenceAgents(strings.NewReader(`[{"Name": "Test", "GUIDs": ["nope"]}]`)); err == nil {
+		t.Errorf("LoadPersistenceAgents accepted an invalid GUID")
+	}
+}
+
+func TestDetectPersistenceNVar(t *testing.T) {
+	pd := ParseDir{BasePath: "../../integration/roms/nvartest/"}
+	f, err := pd.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &DetectPersistence{
+		Agents: []PersistenceAgent{{
+			Name:          "Test",
+			VariableGUIDs: []string{"7E577E57-0123-4567-89AB-CDEF00000000"},
+			Variables:     regexp.MustCompile("^Test1$"),
+		}},
+		Remove: true,
+	}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Test0 has several entries, Test1 only matches by name.
+	if len(v.Hits) == 0 {
+		t.Fatalf("DetectPersistence: got no hits, want variable Test0")
+	}
+	for _, h := range v.Hits {
+		if h.Variable != "Test0" && !(h.Variable == "Test1" && h.Hint) {
+			t.Errorf("DetectPersistence: got %+v, want variable Test0 or hint Test1", h)
+		}
+	}
+	find := &Find{Predicate: func(f uefi.Firmware) bool {
+		nvar, ok := f.(*uefi.NVar)
+		return ok && nvar.Name == "Test0"
+	}}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range find.Matches {
+		if m.(*uefi.NVar).IsValid() {
+			t.Errorf("Test0 still valid")
+		}
+	}
+	find.Predicate = func(f uefi.Firmware) bool {
+		nvar, ok := f.(*uefi.NVar)
+		return ok && nvar.Name == "Test1" && nvar.IsValid()
+	}
+	find.Matches = nil
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) == 0 {
+		t.Errorf("Test1 was invalidated by its name")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// An Outputter is a visitor which writes files, like save or extract. A dry
+// run does not apply it, and reports the files instead.
+type Outputter interface {
+	Outputs() []string
+}
+
+// Outputs implements Outputter.
+func (v *Save) Outputs() []string { return []string{v.DirPath} }
+
+// Outputs implements Outputter.
+func (v *Extract) Outputs() []string { return []string{v.BasePath} }
+
+// Outputs implements Outputter.
+func (v *Split) Outputs() []string { return []string{v.DirPath} }
+
+// Outputs implements Outputter.
+func (v *DumpPE) Outputs() []string { return []string{v.Path} }
+
+// Outputs implements Outputter.
+func (v *DumpFV) Outputs() []string { return []string{v.Path} }
+
+// Outputs implements Outputter.
+func (v *OptionROMDriver) Outputs() []string { return []string{v.OutPath} }
+
+// Outputs implements Outputter. A Dump writing to W writes no file.
+func (v *Dump) Outputs() []string {
+	if v.W != nil {
+		return nil
+	}
+	return []string{v.Path}
+}
+
+// Outputs implements Outputter. A Body writing to W writes no file.
+func (v *Body) Outputs() []string {
+	if v.W != nil {
+		return nil
+	}
+	return []string{v.Path}
+}
+
+// PlanFreeSpace is the free space of a volume before and after a step.
+type PlanFreeSpace struct {
+	Path   string
+	Before uint64
+	After  uint64
+}
+
+// PlanStep is what an operation of a dry run changes.
+type PlanStep struct {
+	// Op is the operation, as on the command line.
+	Op string
+	// Writes are the files the operation would write.
+	Writes []string `json:",omitempty"`
+	// Changes are the nodes the operation changes, as Diff reports them.
+	Changes   []DiffEntry     `json:",omitempty"`
+	FreeSpace []PlanFreeSpace `json:",omitempty"`
+}
+
+// Plan is the result of a dry run.
+type Plan struct {
+	Reporter
+
+	Steps []PlanStep
+}
+
+// DryRunCLI applies the operations of the command line to the image like
+// ExecuteCLI, except for Outputters, and returns what each of them changes.
+// The image is assembled after each operation, as save would, and the
+// changes are those of the assembled images.
+func DryRunCLI(f uefi.Firmware, args []string) (*Plan, error) {
+	return DryRunCLIContext(context.Background(), f, args)
+}
+
+// DryRunCLIContext is like DryRunCLI, but assembles with the options of ctx,
+// and runs the visitors through its Middleware.
+func DryRunCLIContext(ctx context.Context, f uefi.Firmware, args []string) (*Plan, error) {
+	v, err := ParseCLI(args)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := func() (uefi.Firmware, error) {
+		if err := (&Assemble{Options: AssembleOptionsOf(ctx)}).Run(f); err != nil {
+			return nil, err
+		}
+		return uefi.ParseContext(ctx, append([]byte{}, f.Buf()...))
+	}
+	before, err := snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	run := runFunc(ctx)
+	plan := &Plan{Reporter: Reporter{Format: OutputFormatOf(ctx)}, Steps: []PlanStep{}}
+	for i := range v {
+		n := visitorRegistry[args[0]].numArgs + 1
+		step := PlanStep{Op: strings.Join(args[:n], " ")}
+		args = args[n:]
+
+		if o, ok := v[i].(Outputter); ok {
+			step.Writes = o.Outputs()
+			plan.Steps = append(plan.Steps, step)
+			continue
+		}
+		if err := run(v[i], f); err != nil {
+			return nil, fmt.Errorf("%s: %v", step.Op, err)
+		}
+		after, err := snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", step.Op, err)
+		}
+		d := &Diff{New: after}
+		if err := d.Run(before); err != nil {
+			return nil, err
+		}
+		step.Changes = d.Entries
+		step.FreeSpace = freeSpaceChanges(volumeFreeSpace(before), volumeFreeSpace(after))
+		plan.Steps = append(plan.Steps, step)
+		before = after
+	}
+	return plan, nil
+}
+
+// volumeFreeSpace returns the free space of the volumes of the tree by their
+// path, named like in DiffEntry.
+func volumeFreeSpace(f uefi.Firmware) map[string]uint64 {
+	m := map[string]uint64{}
+	var walk func(path string, f uefi.Firmware)
+	walk = func(path string, f uefi.Firmware) {
+		if fv, ok := f.(*uefi.FirmwareVolume); ok {
+			m[path] = fv.FreeSpace
+		}
+		keys, nodes := diffChildren(f)
+		for _, k := range keys {
+			walk(path+"/"+k, nodes[k])
+		}
+	}
+	walk(diffLabel(f), f)
+	return m
+}
+
+// freeSpaceChanges returns the volumes whose free space differs, sorted by
+// path. Volumes missing before or after have no free space there.
+func freeSpaceChanges(before, after map[string]uint64) []PlanFreeSpace {
+	var r []PlanFreeSpace
+	for p, b := range before {
+		if a, ok := after[p]; !ok || a != b {
+			r = append(r, PlanFreeSpace{Path: p, Before: b, After: a})
+		}
+	}
+	for p, a := range after {
+		if _, ok := before[p]; !ok {
+			r = append(r, PlanFreeSpace{Path: p, After: a})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Path < r[j].Path })
+	return r
+}
+
+// Write writes the plan to w, in the output format, or as text by default.
+func (p *Plan) Write(w io.Writer) error {
+	if p.Format != FormatDefault {
+		b, err := marshalReport(p.Format, p)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+	for _, s := range p.Steps {
+		s.write(w)
+	}
+	return nil
+}
+
+// write writes the step as text.
+func (s *PlanStep) write(w io.Writer) {
+	fmt.Fprintln(w, s.Op)
+	for _, o := range s.Writes {
+		fmt.Fprintf(w, "  %-8s %s\n", "writes", o)
+	}
+	for _, e := range s.Changes {
+		switch e.Kind {
+		case DiffAdded:
+			fmt.Fprintf(w, "  %-8s %s (%d bytes)\n", e.Kind, e.Path, e.NewSize)
+		case DiffRemoved:
+			fmt.Fprintf(w, "  %-8s %s (%d bytes)\n", e.Kind, e.Path, e.OldSize)
+		default:
+			fmt.Fprintf(w, "  %-8s %s (%d -> %d bytes)\n", e.Kind, e.Path, e.OldSize, e.NewSize)
+		}
+	}
+	for _, fs := range s.FreeSpace {
+		fmt.Fprintf(w, "  %-8s %s (%d -> %d bytes)\n", "free", fs.Path, fs.Before, fs.After)
+	}
+	if len(s.Writes)+len(s.Changes) == 0 {
+		fmt.Fprintln(w, "  no changes")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunCLI(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "out.rom")
+	dump := filepath.Join(tmpDir, "dump.bin")
+
+	f := parseImage(t)
+	plan, err := DryRunCLI(f, []string{"remove", "Shell", "dump", "DxeCore", dump, "save", out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{out, dump} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("the dry run wrote %s", p)
+		}
+	}
+	if len(plan.Steps) != 3 {
+		t.Fatalf("got %d steps, want 3", len(plan.Steps))
+	}
+	if s := plan.Steps[2]; s.Op != "save "+out || len(s.Writes) != 1 || s.Writes[0] != out {
+		t.Errorf("got step %+v, want one writing %s", s, out)
+	}
+
+	remove := plan.Steps[0]
+	var removed int
+	for _, e := range remove.Changes {
+		if e.Kind == DiffRemoved {
+			removed++
+			// The shell is in the second volume image of the section.
+			if !strings.Contains(e.Path, "Section(EFI_SECTION_FIRMWARE_VOLUME_IMAGE)#1/") {
+				t.Errorf("got path %s, want it to tell the volume images apart", e.Path)
+			}
+		}
+	}
+	if removed != 1 {
+		t.Errorf("got %d removed nodes, want 1: %+v", removed, remove.Changes)
+	}
+	if len(remove.FreeSpace) == 0 {
+		t.Errorf("removing a file freed no space")
+	}
+	for _, fs := range remove.FreeSpace {
+		if fs.After <= fs.Before {
+			t.Errorf("free space of %s went from %d to %d bytes, want more", fs.Path, fs.Before, fs.After)
+		}
+	}
+	if s := plan.Steps[1]; len(s.Changes) != 0 || len(s.Writes) != 1 {
+		t.Errorf("got step %+v, want a dump changing nothing", s)
+	}
+
+	var b bytes.Buffer
+	if err := plan.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "  writes   "+out) {
+		t.Errorf("the text plan does not list the saved file:\n%s", b.String())
+	}
+}
+
+func TestDryRunOutputters(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, args := range [][]string{
+		{"dump_pe", "DxeCore", filepath.Join(tmpDir, "DxeCore.efi")},
+		{"dump_fv", "9E21FD93-9C72-4C15-8C4B-E77F1DB2D792", filepath.Join(tmpDir, "dxe.fv")},
+	} {
+		plan, err := DryRunCLI(parseImage(t), args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(args[2]); !os.IsNotExist(err) {
+			t.Errorf("the dry run of %s wrote %s", args[0], args[2])
+		}
+		if len(plan.Steps) != 1 || len(plan.Steps[0].Writes) != 1 || plan.Steps[0].Writes[0] != args[2] {
+			t.Errorf("got plan %+v, want %s writing %s", plan.Steps, args[0], args[2])
+		}
+	}
+}
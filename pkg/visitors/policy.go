@@ -0,0 +1,162 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ErrProtected is the error of the runs which change a node a Policy
+// protects.
+var ErrProtected = errors.New("protected node changed")
+
+// PolicyRule protects the nodes its predicate matches.
+type PolicyRule struct {
+	// Spec is what the rule was declared as, like ME.
+	Spec      string
+	Predicate FindPredicate
+}
+
+// Policy declares the nodes of the image which no visitor may change, like
+// the descriptor and the ME region, so that operations going wrong fail
+// instead of damaging them.
+type Policy []PolicyRule
+
+// ParsePolicy returns the Policy of the comma separated specs of s. A spec
+// is ifd or descriptor for the flash descriptor, the name of a region, like
+// ME or GbE, or else a file, as in find.
+func ParsePolicy(s string) (Policy, error) {
+	var p Policy
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r, err := policyRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse protected node %q: %w", spec, err)
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+// policyRule returns the rule of one spec of ParsePolicy.
+func policyRule(spec string) (PolicyRule, error) {
+	if strings.EqualFold(spec, "ifd") || strings.EqualFold(spec, "descriptor") {
+		return PolicyRule{Spec: spec, Predicate: func(f uefi.Firmware) bool {
+			_, ok := f.(*uefi.FlashDescriptor)
+			return ok
+		}}, nil
+	}
+	if rt, err := uefi.ParseFlashRegionType(spec); err == nil {
+		return PolicyRule{Spec: spec, Predicate: func(f uefi.Firmware) bool {
+			r, ok := f.(uefi.Region)
+			return ok && r.Type() == rt
+		}}, nil
+	}
+	pred, err := FindFilePredicate(spec)
+	if err != nil {
+		return PolicyRule{}, err
+	}
+	return PolicyRule{Spec: spec, Predicate: pred}, nil
+}
+
+// protectedNode is the hash of a node a rule matches, of its tree and its
+// bytes.
+type protectedNode struct {
+	label string
+	sum   [sha256.Size]byte
+}
+
+// snapshot returns the nodes of f each rule of p matches.
+func (p Policy) snapshot(f uefi.Firmware) ([][]protectedNode, error) {
+	nodes := make([][]protectedNode, len(p))
+	for i, r := range p {
+		find := &Find{Predicate: r.Predicate}
+		if err := find.Run(f); err != nil {
+			return nil, err
+		}
+		for _, m := range find.Matches {
+			j, err := fingerprint(m)
+			if err != nil {
+				return nil, err
+			}
+			nodes[i] = append(nodes[i], protectedNode{
+				label: nodeLabel(m),
+				sum:   sha256.Sum256(append(j, m.Buf()...)),
+			})
+		}
+	}
+	return nodes, nil
+}
+
+// fingerprint returns the tree of f as JSON, without the ExtractPath of its
+// nodes, which operations reading the image, like extract, set.
+func fingerprint(f uefi.Firmware) ([]byte, error) {
+	j, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(j, &tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(withoutExtractPaths(tree))
+}
+
+// withoutExtractPaths removes the ExtractPath fields of the JSON tree t.
+func withoutExtractPaths(t interface{}) interface{} {
+	switch t := t.(type) {
+	case map[string]interface{}:
+		delete(t, "ExtractPath")
+		for _, v := range t {
+			withoutExtractPaths(v)
+		}
+	case []interface{}:
+		for _, v := range t {
+			withoutExtractPaths(v)
+		}
+	}
+	return t
+}
+
+// Protect returns a Middleware failing the runs which change, remove or add
+// a node of p with ErrProtected, naming the visitor and the node. The tree
+// is left as the visitor changed it, so the image must not be saved.
+func Protect(p Policy) Middleware {
+	return func(next RunFunc) RunFunc {
+		return func(v uefi.Visitor, f uefi.Firmware) error {
+			before, err := p.snapshot(f)
+			if err != nil {
+				return err
+			}
+			runErr := next(v, f)
+			after, err := p.snapshot(f)
+			if err != nil {
+				return err
+			}
+			for i, r := range p {
+				if len(before[i]) != len(after[i]) {
+					return fmt.Errorf("%w: %s changed the number of nodes protected by %q from %d to %d",
+						ErrProtected, visitorName(v), r.Spec, len(before[i]), len(after[i]))
+				}
+				for j, n := range before[i] {
+					if n.sum != after[i][j].sum {
+						return fmt.Errorf("%w: %s changed %s, protected by %q",
+							ErrProtected, visitorName(v), n.label, r.Spec)
+					}
+				}
+			}
+			return runErr
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestProtect(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		protect   string
+		protected bool
+	}{
+		{"descriptor", "gbe,IFD", true},
+		{"other region", "GbE,BIOS", false},
+		{"none", "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf := ifdImage(t)
+			copy(buf[0x30:], []byte{0xf4, 0x00, 0x0c, 0x00})
+			f, err := uefi.Parse(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := ParsePolicy(test.protect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := WithMiddleware(context.Background(), Protect(p))
+			err = ExecuteCLIContext(ctx, f, []uefi.Visitor{&Count{}, &SetChipSize{Chip: 0, Size: 16 << 20}})
+			if protected := errors.Is(err, ErrProtected); protected != test.protected || !protected && err != nil {
+				t.Fatalf("got %v, want protected %v", err, test.protected)
+			}
+			if test.protected && !strings.Contains(err.Error(), "SetChipSize changed FlashDescriptor") {
+				t.Errorf("got %q, want the visitor and the node named", err)
+			}
+		})
+	}
+}
+
+func TestProtectFile(t *testing.T) {
+	p, err := ParsePolicy(testGUID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithMiddleware(context.Background(), Protect(p))
+	remove := &Remove{Predicate: FindFileGUIDPredicate(*testGUID)}
+	if err := ExecuteCLIContext(ctx, parseImage(t), []uefi.Visitor{remove}); !errors.Is(err, ErrProtected) {
+		t.Errorf("got %v, want %v", err, ErrProtected)
+	}
+}
+
+func TestProtectExtract(t *testing.T) {
+	p, err := ParsePolicy(testGUID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithMiddleware(context.Background(), Protect(p))
+	var fIndex uint64
+	extract := &Extract{BasePath: t.TempDir(), DirPath: ".", Index: &fIndex}
+	if err := ExecuteCLIContext(ctx, parseImage(t), []uefi.Visitor{extract}); err != nil {
+		t.Errorf("extracting a protected file: got %v, want nil", err)
+	}
+}
+
+func TestProtectShell(t *testing.T) {
+	p, err := ParsePolicy(testGUID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := parseImage(t)
+	var b bytes.Buffer
+	sh := &Shell{
+		Root:    f,
+		In:      strings.NewReader("remove " + testGUID.String()),
+		Out:     &b,
+		Context: WithMiddleware(context.Background(), Protect(p)),
+	}
+	if err := sh.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), ErrProtected.Error()) {
+		t.Errorf("got %q, want the removal of the protected file refused", b.String())
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	if _, err := ParsePolicy("ME,("); err == nil {
+		t.Errorf("got no error for an invalid spec")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// A Query selects nodes by their position in the tree and their
+// attributes, with a syntax close to XPath:
+//
+//	//FV[guid=8C8CE578-8A3D-4F1C-9935-896185C32DD3]/File[type=DRIVER][name~="Usb"]
+//
+// Each step is introduced by "/", for a child of the node selected by the
+// previous step or of the root, or "//", for any descendant. It names the
+// type of the node, like FV, File, Section, NVar or Region, or "*" for any
+// type, followed by filters on the attributes guid, name, type and size.
+// The operators are "=" and "!=", which ignore case, and "~=", which
+// matches a regular expression.
+type Query struct {
+	steps []queryStep
+}
+
+type queryStep struct {
+	descendant bool
+	typ        string
+	filters    []queryFilter
+}
+
+type queryFilter struct {
+	attr  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// queryTypes maps the short names of node types to the names of their Go
+// types.
+var queryTypes = map[string]string{
+	"fv":     "firmwarevolume",
+	"region": "rawregion",
+	"bios":   "biosregion",
+	"me":     "meregion",
+	"ifd":    "flashdescriptor",
+	"flash":  "flashimage",
+	"pad":    "biospadding",
+	"oprom":  "optionrom",
+}
+
+var queryStepRE = regexp.MustCompile(`^(//?)([A-Za-z0-9_]+|\*)`)
+var queryFilterRE = regexp.MustCompile(`^\[\s*([a-z]+)\s*(=|!=|~=)\s*("(?:[^"\\]|\\.)*"|[^\]]*?)\s*\]`)
+
+// ParseQuery parses a query.
+func ParseQuery(q string) (*Query, error) {
+	query := &Query{}
+	s := strings.TrimSpace(q)
+	for s != "" {
+		m := queryStepRE.FindStringSubmatch(s)
+		if m == nil {
+			return nil, fmt.Errorf("query %q: expected a step at %q", q, s)
+		}
+		s = s[len(m[0]):]
+		step := queryStep{descendant: m[1] == "//", typ: strings.ToLower(m[2])}
+		if t, ok := queryTypes[step.typ]; ok {
+			step.typ = t
+		}
+		for strings.HasPrefix(s, "[") {
+			m := queryFilterRE.FindStringSubmatch(s)
+			if m == nil {
+				return nil, fmt.Errorf("query %q: bad filter at %q", q, s)
+			}
+			s = s[len(m[0]):]
+			f := queryFilter{attr: m[1], op: m[2], value: m[3]}
+			if strings.HasPrefix(f.value, `"`) {
+				v, err := strconv.Unquote(f.value)
+				if err != nil {
+					return nil, fmt.Errorf("query %q: %v", q, err)
+				}
+				f.value = v
+			}
+			switch f.attr {
+			case "guid", "name", "type", "size":
+			default:
+				return nil, fmt.Errorf("query %q: unknown attribute %q", q, f.attr)
+			}
+			if f.op == "~=" {
+				re, err := regexp.Compile(f.value)
+				if err != nil {
+					return nil, fmt.Errorf("query %q: %v", q, err)
+				}
+				f.re = re
+			}
+			step.filters = append(step.filters, f)
+		}
+		query.steps = append(query.steps, step)
+	}
+	if len(query.steps) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	return query, nil
+}
+
+// Select returns the nodes of the tree rooted at root the query selects,
+// in depth first order.
+func (q *Query) Select(root uefi.Firmware) []uefi.Firmware {
+	var r []uefi.Firmware
+	var walk func(path []uefi.Firmware)
+	walk = func(path []uefi.Firmware) {
+		if q.match(path) {
+			r = append(r, path[len(path)-1])
+		}
+		for _, c := range children(path[len(path)-1]) {
+			walk(append(path, c))
+		}
+	}
+	walk([]uefi.Firmware{root})
+	return r
+}
+
+// Predicate returns a FindPredicate matching the nodes the query selects.
+// Predicates only see nodes, so it learns the tree from the first node it
+// is called with, which the Find visitor makes the root of the search, and
+// learns it again whenever called with a node out of that tree.
+func (q *Query) Predicate() FindPredicate {
+	var parents map[uefi.Firmware]uefi.Firmware
+	var root uefi.Firmware
+	return func(f uefi.Firmware) bool {
+		if _, ok := parents[f]; !ok && f != root {
+			root, parents = f, map[uefi.Firmware]uefi.Firmware{}
+			var walk func(uefi.Firmware)
+			walk = func(n uefi.Firmware) {
+				for _, c := range children(n) {
+					parents[c] = n
+					walk(c)
+				}
+			}
+			walk(root)
+		}
+		path := []uefi.Firmware{f}
+		for n := f; n != root; {
+			n = parents[n]
+			path = append([]uefi.Firmware{n}, path...)
+		}
+		return q.match(path)
+	}
+}
+
+// FindQueryPredicate parses a query and returns its predicate.
+func FindQueryPredicate(q string) (FindPredicate, error) {
+	query, err := ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return query.Predicate(), nil
+}
+
+// match returns true if the last node of path, which starts at the root,
+// is selected.
+func (q *Query) match(path []uefi.Firmware) bool {
+	var m func(step, node int) bool
+	m = func(step, node int) bool {
+		if !q.steps[step].matchNode(path[node]) {
+			return false
+		}
+		if step == 0 {
+			return node == 0 || q.steps[0].descendant
+		}
+		if !q.steps[step].descendant {
+			return node > 0 && m(step-1, node-1)
+		}
+		for n := node - 1; n >= 0; n-- {
+			if m(step-1, n) {
+				return true
+			}
+		}
+		return false
+	}
+	return m(len(q.steps)-1, len(path)-1)
+}
+
+func (s *queryStep) matchNode(f uefi.Firmware) bool {
+	if s.typ != "*" && s.typ != strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi.")) {
+		return false
+	}
+	for _, filter := range s.filters {
+		if !filter.match(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter *queryFilter) match(f uefi.Firmware) bool {
+	values := queryAttr(f, filter.attr)
+	var ok bool
+	for _, v := range values {
+		switch filter.op {
+		case "~=":
+			ok = filter.re.MatchString(v)
+		default:
+			ok = strings.EqualFold(v, filter.value)
+		}
+		if ok {
+			break
+		}
+	}
+	if filter.op == "!=" {
+		return len(values) != 0 && !ok
+	}
+	return ok
+}
+
+// queryAttr returns the values the attribute of a node matches against.
+// Types also match without their EFI_FV_FILETYPE_ or EFI_SECTION_ prefix.
+func queryAttr(f uefi.Firmware, attr string) []string {
+	if attr == "size" {
+		return []string{strconv.Itoa(len(f.Buf())), fmt.Sprintf("%#x", len(f.Buf()))}
+	}
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		if attr == "guid" {
+			return []string{f.FVName.String()}
+		}
+		if attr == "type" {
+			return []string{f.FileSystemGUID.String()}
+		}
+	case *uefi.File:
+		switch attr {
+		case "guid":
+			return []string{f.Header.GUID.String()}
+		case "name":
+			return []string{fileName(f)}
+		case "type":
+			t := f.Header.Type.String()
+			return []string{t, strings.TrimPrefix(t, "EFI_FV_FILETYPE_")}
+		}
+	case *uefi.Section:
+		switch attr {
+		case "guid":
+			if f.Header.Type == uefi.SectionTypeGUIDDefined && f.TypeSpecific != nil {
+				if h, ok := f.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+					return []string{h.GUID.String()}
+				}
+			}
+		case "name":
+			return []string{f.String()}
+		case "type":
+			return []string{f.Type, strings.TrimPrefix(f.Type, "EFI_SECTION_")}
+		}
+	case *uefi.NVar:
+		switch attr {
+		case "guid":
+			return []string{f.GUID.String()}
+		case "name":
+			return []string{f.Name}
+		}
+	case *uefi.RawRegion:
+		if attr == "type" {
+			return []string{f.Type().String()}
+		}
+	}
+	return nil
+}
+
+// children returns the nodes a node applies visitors to.
+func children(f uefi.Firmware) []uefi.Firmware {
+	c := &childCollector{}
+	// Collecting cannot fail.
+	_ = f.ApplyChildren(c)
+	return c.children
+}
+
+type childCollector struct {
+	children []uefi.Firmware
+}
+
+func (c *childCollector) Run(f uefi.Firmware) error {
+	return f.ApplyChildren(c)
+}
+
+func (c *childCollector) Visit(f uefi.Firmware) error {
+	c.children = append(c.children, f)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestQuery(t *testing.T) {
+	f := parseImage(t)
+
+	var tests = []struct {
+		query string
+		match func(uefi.Firmware) bool
+		min   int
+		max   int
+	}{
+		{`//FV/File[type=DXE_CORE]`, func(f uefi.Firmware) bool {
+			file, ok := f.(*uefi.File)
+			return ok && file.Header.GUID == *dxeCoreGUID
+		}, 1, 1},
+		{`//File[name="DxeCore"]`, func(f uefi.Firmware) bool {
+			file, ok := f.(*uefi.File)
+			return ok && file.Header.GUID == *dxeCoreGUID
+		}, 1, 1},
+		{`//File[type=EFI_FV_FILETYPE_DRIVER][name~="^PciHost"]`, func(f uefi.Firmware) bool {
+			file, ok := f.(*uefi.File)
+			return ok && file.Header.Type == uefi.FVFileTypeDriver
+		}, 1, 10},
+		{`/BIOSRegion/FV`, func(f uefi.Firmware) bool {
+			_, ok := f.(*uefi.FirmwareVolume)
+			return ok
+		}, 1, 10},
+		{`//FV//FV/File[type!=DRIVER]`, func(f uefi.Firmware) bool {
+			file, ok := f.(*uefi.File)
+			return ok && file.Header.Type != uefi.FVFileTypeDriver
+		}, 1, 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			q, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes := q.Select(f)
+			if len(nodes) < test.min || len(nodes) > test.max {
+				t.Fatalf("got %d nodes, want between %d and %d", len(nodes), test.min, test.max)
+			}
+			for _, n := range nodes {
+				if !test.match(n) {
+					t.Errorf("unexpected node %s", nodeLabel(n))
+				}
+			}
+
+			// The predicate used through Find selects the same nodes.
+			pred, err := FindFilePredicate(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			find := &Find{Predicate: pred}
+			if err := find.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if len(find.Matches) != len(nodes) {
+				t.Errorf("Find matched %d nodes, Select %d", len(find.Matches), len(nodes))
+			}
+		})
+	}
+}
+
+func TestQueryRemove(t *testing.T) {
+	f := parseImage(t)
+	pred, err := FindFilePredicate(`//File[name=DxeCore]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Remove{Predicate: pred}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if results := find(t, f, dxeCoreGUID); len(results) != 0 {
+		t.Errorf("DxeCore was not removed")
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, q := range []string{
+		``,
+		`File`,
+		`//File[`,
+		`//File[colour=red]`,
+		`//File[name~="("]`,
+		`//File[name="unterminated]`,
+		`//File/`,
+	} {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want an error", q)
+		}
+	}
+}
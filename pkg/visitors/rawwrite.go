@@ -0,0 +1,52 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// writeImageRange writes b at offset of the image f, in the smallest node
+// holding those bytes, as the nodes above it are assembled from their
+// children. The checksum of a file without sections is updated, those of
+// the other nodes are when the image is assembled.
+func writeImageRange(f uefi.Firmware, offset uint64, b []byte) error {
+	buf := f.Buf()
+	end := offset + uint64(len(b))
+	if end > uint64(len(buf)) {
+		return fmt.Errorf("[%#x, %#x) is outside of the image (%#x)", offset, end, len(buf))
+	}
+	offsets, err := nodeOffsets(f)
+	if err != nil {
+		return err
+	}
+	var node uefi.Firmware
+	var nodeOffset uint64
+	for n, o := range offsets {
+		nb := n.Buf()
+		if o > offset || end > o+uint64(len(nb)) {
+			continue
+		}
+		// The offsets of the nodes in sections are not those of the
+		// image, only trust the nodes holding the same bytes.
+		if !bytes.Equal(nb[offset-o:end-o], buf[offset:end]) {
+			continue
+		}
+		if node == nil || len(nb) < len(node.Buf()) || (len(nb) == len(node.Buf()) && !hasChildren(n)) {
+			node, nodeOffset = n, o
+		}
+	}
+	if node == nil {
+		return fmt.Errorf("no node holds [%#x, %#x) of the image", offset, end)
+	}
+	copy(node.Buf()[offset-nodeOffset:], b)
+	if file, ok := node.(*uefi.File); ok && len(file.Sections) == 0 && file.NVarStore == nil {
+		return file.ChecksumAndAssemble(file.Buf()[file.DataOffset:])
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// applyXIPChildren assembles the elements of the BIOS region, recording by
+// how much the address of each volume moves. The executables of these
+// volumes run in place, at the address in flash the top of the region is
+// mapped below 4GiB at, so they move with the offset of their volume and
+// the size of the region too. The offsets of the volumes are updated, so
+// the next assembly rebases from there.
+func (v *Assemble) applyXIPChildren(r *uefi.BIOSRegion) error {
+	oldLen := uint64(len(r.Buf()))
+	if oldLen == 0 {
+		oldLen = r.Length
+	}
+	v.xipVolumes = map[*uefi.FirmwareVolume]uint64{}
+	var offset uint64
+	for _, e := range r.Elements {
+		fv, ok := e.Value.(*uefi.FirmwareVolume)
+		if ok {
+			// Addresses wrap around, the moves may be negative.
+			v.xipVolumes[fv] = (offset - r.Length) - (fv.FVOffset - oldLen)
+		}
+		if err := e.Value.Apply(v); err != nil {
+			return err
+		}
+		if ok {
+			fv.FVOffset = offset
+		}
+		offset += uint64(len(e.Value.Buf()))
+	}
+	return nil
+}
+
+// rebaseFile moves the PE32 and TE sections of a file which runs in place
+// as much as the file moved to offset in its volume, which moved by
+// fvMove, and reports if any changed. New files, whose offset is unknown,
+// keep their image base.
+func rebaseFile(f *uefi.File, offset, fvMove uint64) bool {
+	if !uefi.XIPTypes[f.Header.Type] || f.Offset == 0 || (f.Offset == offset && fvMove == 0) {
+		return false
+	}
+	var rebased bool
+	for _, s := range f.Sections {
+		if s.Header.Type != uefi.SectionTypePE32 && s.Header.Type != uefi.SectionTypeTE {
+			continue
+		}
+		img := s.Buf()[s.HeaderLen():]
+		addr, err := uefi.XIPAddress(img)
+		if err == nil {
+			var changed bool
+			changed, err = uefi.RebaseXIP(img, addr+fvMove+offset-f.Offset)
+			rebased = rebased || changed
+		}
+		if err != nil {
+			log.Warnf("not rebasing %v section of file %v: %v", s.Type, f.Header.GUID, err)
+		}
+	}
+	return rebased
+}
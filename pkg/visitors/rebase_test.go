@@ -0,0 +1,112 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// secImageBase returns the image base of the PE32(+) section of SEC.
+func secImageBase(t *testing.T, f uefi.Firmware) uint64 {
+	t.Helper()
+	file := find(t, f, testGUID)[0].(*uefi.File)
+	for _, s := range file.Sections {
+		if s.Header.Type != uefi.SectionTypePE32 {
+			continue
+		}
+		img := s.Buf()[s.HeaderLen():]
+		opt := binary.LittleEndian.Uint32(img[0x3c:]) + 4 + 20
+		if binary.LittleEndian.Uint16(img[opt:]) == 0x10b {
+			return uint64(binary.LittleEndian.Uint32(img[opt+28:]))
+		}
+		return binary.LittleEndian.Uint64(img[opt+24:])
+	}
+	t.Fatal("no PE32 section in SEC")
+	return 0
+}
+
+func TestAssembleRebase(t *testing.T) {
+	for _, rebase := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rebase=%v", rebase), func(t *testing.T) {
+			f := parseImage(t)
+			base := secImageBase(t, f)
+
+			// Move SEC 0x40 bytes up, taking them from the pad file
+			// after it.
+			var fv *uefi.FirmwareVolume
+			for _, e := range f.(*uefi.BIOSRegion).Elements {
+				if v, ok := e.Value.(*uefi.FirmwareVolume); ok && len(v.Files) == 3 && v.Files[0].Header.GUID == *testGUID {
+					fv = v
+				}
+			}
+			if fv == nil {
+				t.Fatal("no volume starting with SEC")
+			}
+			pad, err := uefi.CreateErasedPadFile(0x40, fv.GetErasePolarity())
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest, err := uefi.CreateErasedPadFile(fv.Files[1].Header.ExtendedSize-0x40, fv.GetErasePolarity())
+			if err != nil {
+				t.Fatal(err)
+			}
+			fv.Files = []*uefi.File{pad, fv.Files[0], rest, fv.Files[2]}
+			if err := (&Assemble{Options: &AssembleOptions{Rebase: rebase}}).Run(f); err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := uefi.Parse(f.Buf())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := base
+			if rebase {
+				want = base + 0x40
+			}
+			if got := secImageBase(t, parsed); got != want {
+				t.Errorf("got SEC based at %#x, want %#x", got, want)
+			}
+		})
+	}
+}
+
+func TestAssembleRebaseMovedVolume(t *testing.T) {
+	f := parseImage(t)
+	base := secImageBase(t, f)
+	r := f.(*uefi.BIOSRegion)
+
+	// Move the volume of SEC 0x10000 bytes down, by shrinking the volume
+	// before it and padding the region after it.
+	const move = 0x10000
+	fv := r.Elements[len(r.Elements)-2].Value.(*uefi.FirmwareVolume)
+	fv.Length -= move
+	fv.SetBuf(fv.Buf()[:fv.DataOffset])
+	fv.Blocks[0].Count = uint32(fv.Length / uint64(fv.Blocks[0].Size))
+	pad := make([]byte, move)
+	uefi.Erase(pad, fv.GetErasePolarity())
+	bp, err := uefi.NewBIOSPadding(pad, r.Length-move)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Elements = append(r.Elements, uefi.MakeTyped(bp))
+
+	// Assembling again must not move SEC again.
+	for i := 0; i < 2; i++ {
+		if err := (&Assemble{Options: &AssembleOptions{Rebase: true}}).Run(f); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := uefi.Parse(append([]byte{}, f.Buf()...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := secImageBase(t, parsed), base-move; got != want {
+			t.Errorf("assembly %d: got SEC based at %#x, want %#x", i, got, want)
+		}
+	}
+}
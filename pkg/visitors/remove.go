@@ -92,7 +92,7 @@ func (v *Remove) Visit(f uefi.Firmware) error {
 					m := m.(*uefi.File)
 					if v.Pad || m.Header.Type == uefi.FVFileTypePEIM {
 						// Create a new pad file of the exact same size
-						pf, err := uefi.CreatePadFile(m.Header.ExtendedSize)
+						pf, err := uefi.CreateErasedPadFile(m.Header.ExtendedSize, f.GetErasePolarity())
 						if err != nil {
 							return err
 						}
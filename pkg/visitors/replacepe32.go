@@ -7,12 +7,17 @@ package visitors
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
-// ReplacePE32 replaces PE32 sections with NewPE32 for all files matching Predicate.
+// ReplacePE32 replaces PE32 sections with NewPE32 for all files matching
+// Predicate, keeping the other sections, like the UI, version and
+// dependency ones, and the GUID of the file. A TE image replaces TE sections
+// instead, like the ones of PEIMs, and so does a PE32 image converted to TE
+// when the file has no PE32 section.
 type ReplacePE32 struct {
 	// Input
 	Predicate func(f uefi.Firmware) bool
@@ -20,12 +25,21 @@ type ReplacePE32 struct {
 
 	// Output
 	Matches []uefi.Firmware
+
+	sectionType uefi.SectionType
+	sections    []*uefi.Section
+	teSections  []*uefi.Section
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *ReplacePE32) Run(f uefi.Firmware) error {
-	// Check that we're actually replacing with a PE32 image
-	if !bytes.HasPrefix(v.NewPE32, []byte("MZ")) {
+	// Check that we're actually replacing with a PE32 or TE image
+	switch {
+	case bytes.HasPrefix(v.NewPE32, []byte("MZ")):
+		v.sectionType = uefi.SectionTypePE32
+	case bytes.HasPrefix(v.NewPE32, []byte("VZ")):
+		v.sectionType = uefi.SectionTypeTE
+	default:
 		return errors.New("supplied binary is not a valid pe32 image")
 	}
 
@@ -47,9 +61,28 @@ func (v *ReplacePE32) Run(f uefi.Firmware) error {
 	}
 
 	for _, m := range v.Matches {
+		v.sections, v.teSections = nil, nil
 		if err := m.Apply(v); err != nil {
 			return err
 		}
+		image, sections := v.NewPE32, v.sections
+		if len(sections) == 0 && v.sectionType == uefi.SectionTypePE32 && len(v.teSections) > 0 {
+			te, err := uefi.PEToTE(v.NewPE32)
+			if err != nil {
+				return err
+			}
+			image, sections = te, v.teSections
+		}
+		if file, ok := m.(*uefi.File); ok && len(sections) == 0 {
+			return fmt.Errorf("no %v section to replace in file %v", v.sectionType, file.Header.GUID)
+		}
+		for _, s := range sections {
+			s.SetBuf(image)
+			s.Encapsulated = nil // Should already be empty
+			if err := s.GenSecHeader(); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -62,12 +95,11 @@ func (v *ReplacePE32) Visit(f uefi.Firmware) error {
 		return f.ApplyChildren(v)
 
 	case *uefi.Section:
-		if f.Header.Type == uefi.SectionTypePE32 {
-			f.SetBuf(v.NewPE32)
-			f.Encapsulated = nil // Should already be empty
-			if err := f.GenSecHeader(); err != nil {
-				return err
-			}
+		switch f.Header.Type {
+		case v.sectionType:
+			v.sections = append(v.sections, f)
+		case uefi.SectionTypeTE:
+			v.teSections = append(v.teSections, f)
 		}
 		return f.ApplyChildren(v)
 
@@ -78,7 +110,7 @@ func (v *ReplacePE32) Visit(f uefi.Firmware) error {
 }
 
 func init() {
-	RegisterCLI("replace_pe32", "replace a pe32 given a GUID and new file", 2, func(args []string) (uefi.Visitor, error) {
+	RegisterCLI("replace_pe32", "replace the pe32 (or te) section of a file given a GUID and new file", 2, func(args []string) (uefi.Visitor, error) {
 		pred, err := FindFilePredicate(args[0])
 		if err != nil {
 			return nil, err
@@ -80,3 +80,35 @@ func TestErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestReplacePE32KeepsSections(t *testing.T) {
+	f := parseImage(t)
+	file := find(t, f, testGUID)[0].(*uefi.File)
+	var types []uefi.SectionType
+	for _, s := range file.Sections {
+		types = append(types, s.Header.Type)
+	}
+
+	// The file has no TE section for a TE image.
+	replace := &ReplacePE32{
+		Predicate: FindFileGUIDPredicate(*testGUID),
+		NewPE32:   []byte("VZbanana"),
+	}
+	if err := replace.Run(f); err == nil {
+		t.Errorf("replacing the missing TE section succeeded, want an error")
+	}
+
+	replace.NewPE32 = []byte("MZbanana")
+	if err := replace.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	file = find(t, f, testGUID)[0].(*uefi.File)
+	if len(file.Sections) != len(types) {
+		t.Fatalf("got %d sections, want %d", len(file.Sections), len(types))
+	}
+	for i, s := range file.Sections {
+		if s.Header.Type != types[i] {
+			t.Errorf("section %d: got %v, want %v", i, s.Header.Type, types[i])
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ResizeRegion sets the base and size of a region in the flash descriptor,
+// for example to shrink the ME region and grow the BIOS region. If Move is
+// set, the contents of the region are relocated as well.
+type ResizeRegion struct {
+	// Input
+	Type uefi.FlashRegionType
+	Base uint32
+	Size uint32
+	Move bool
+}
+
+// Run assembles the image and applies the visitor.
+func (v *ResizeRegion) Run(f uefi.Firmware) error {
+	if err := f.Apply(&Assemble{}); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit rewrites the descriptor and parses the resulting image.
+func (v *ResizeRegion) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return errNoFlashImage
+	}
+	buf, err := fi.ResizeRegion(v.Type, v.Base, v.Size, v.Move)
+	if err != nil {
+		return err
+	}
+	n, err := uefi.NewFlashImage(buf)
+	if err != nil {
+		return err
+	}
+	*fi = *n
+	return nil
+}
+
+func parseResizeRegion(args []string, move bool) (uefi.Visitor, error) {
+	rt, err := uefi.ParseFlashRegionType(args[0])
+	if err != nil {
+		return nil, err
+	}
+	base, err := strconv.ParseUint(args[1], 0, 32)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseUint(args[2], 0, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &ResizeRegion{
+		Type: rt,
+		Base: uint32(base),
+		Size: uint32(size),
+		Move: move,
+	}, nil
+}
+
+func init() {
+	RegisterCLI("resize_region", "set base and size in bytes of a region (e.g. ME) in the flash descriptor, size 0 removes it", 3, func(args []string) (uefi.Visitor, error) {
+		return parseResizeRegion(args, false)
+	})
+	RegisterCLI("move_region", "like resize_region, but also move the contents of the region", 3, func(args []string) (uefi.Visitor, error) {
+		return parseResizeRegion(args, true)
+	})
+}
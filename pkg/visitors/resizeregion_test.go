@@ -0,0 +1,45 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestResizeRegion(t *testing.T) {
+	buf := ifdImage(t)
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := parseResizeRegion([]string{"gbe", "0x3000", "0x2000"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.(*ResizeRegion).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	fi := f.(*uefi.FlashImage)
+	if got := fi.IFD.Region.FlashRegions[uefi.RegionTypeGBE]; got != (uefi.FlashRegion{Base: 3, Limit: 4}) {
+		t.Errorf("GbE region: got %v, want [0x3, 0x4)", &got)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	out := f.Buf()
+	if !bytes.Equal(out[0x3000:0x5000], buf[0x1000:0x3000]) || !bytes.Equal(out[0x8000:], buf[0x8000:]) {
+		t.Errorf("move_region: contents were not moved as expected")
+	}
+
+	if err := (&ResizeRegion{Type: uefi.RegionTypeGBE, Base: 0x7000, Size: 0x2000}).Run(f); err == nil {
+		t.Errorf("GbE overlapping BIOS: got nil, want error")
+	}
+	if _, err := parseResizeRegion([]string{"foo", "0", "0"}, false); err == nil {
+		t.Errorf("unknown region: got nil, want error")
+	}
+}
@@ -12,7 +12,11 @@ import (
 
 // Save calls Assemble, then outputs the top image to a file.
 type Save struct {
+	Cancelable
+
 	DirPath string
+	// Options of the assembly, nil for those of the context.
+	Options *AssembleOptions
 }
 
 // Run just applies the visitor.
@@ -23,7 +27,7 @@ func (v *Save) Run(f uefi.Firmware) error {
 // Visit calls the assemble visitor to make sure everything is reconstructed.
 // It then outputs the top level buffer to a file.
 func (v *Save) Visit(f uefi.Firmware) error {
-	a := &Assemble{}
+	a := &Assemble{Cancelable: v.Cancelable, Options: v.Options}
 	// Assemble the binary to make sure the top level buffer is correct
 	if err := f.Apply(a); err != nil {
 		return err
@@ -0,0 +1,168 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+// SetUI sets the name in the user interface section of all files matching
+// Predicate. Files without one get a user interface section after their
+// other sections.
+type SetUI struct {
+	// Input
+	Predicate FindPredicate
+	Name      string
+
+	// Output
+	Matches []uefi.Firmware
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetUI) Run(f uefi.Firmware) error {
+	matches, err := findFiles(f, v.Predicate)
+	v.Matches = matches
+	if err != nil {
+		return err
+	}
+	for _, m := range v.Matches {
+		if err := m.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Visit applies the SetUI visitor to any Firmware type.
+func (v *SetUI) Visit(f uefi.Firmware) error {
+	file, ok := f.(*uefi.File)
+	if !ok {
+		return nil
+	}
+	s, err := setSection(file, uefi.SectionTypeUserInterface, unicode.UTF8ToUCS2(v.Name))
+	if err != nil {
+		return err
+	}
+	s.Name = v.Name
+	return nil
+}
+
+// SetVersion sets the build number and the version string in the version
+// section of all files matching Predicate. Files without one get a version
+// section after their other sections.
+type SetVersion struct {
+	// Input
+	Predicate   FindPredicate
+	BuildNumber uint16
+	Version     string
+
+	// Output
+	Matches []uefi.Firmware
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetVersion) Run(f uefi.Firmware) error {
+	matches, err := findFiles(f, v.Predicate)
+	v.Matches = matches
+	if err != nil {
+		return err
+	}
+	for _, m := range v.Matches {
+		if err := m.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Visit applies the SetVersion visitor to any Firmware type.
+func (v *SetVersion) Visit(f uefi.Firmware) error {
+	file, ok := f.(*uefi.File)
+	if !ok {
+		return nil
+	}
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, v.BuildNumber)
+	s, err := setSection(file, uefi.SectionTypeVersion, append(data, unicode.UTF8ToUCS2(v.Version)...))
+	if err != nil {
+		return err
+	}
+	s.BuildNumber = v.BuildNumber
+	s.Version = v.Version
+	return nil
+}
+
+// findFiles returns the files matching the predicate, or an error if there
+// are none.
+func findFiles(f uefi.Firmware, pred FindPredicate) ([]uefi.Firmware, error) {
+	find := Find{
+		Predicate: pred,
+	}
+	if err := find.Run(f); err != nil {
+		return nil, err
+	}
+	if len(find.Matches) == 0 {
+		return nil, errors.New("no matches found")
+	}
+	return find.Matches, nil
+}
+
+// setSection replaces the data of the first section of the type among the
+// sections of the file, or appends a new section with the data.
+func setSection(f *uefi.File, t uefi.SectionType, data []byte) (*uefi.Section, error) {
+	if len(f.Sections) == 0 {
+		return nil, fmt.Errorf("file %v has no sections to add a %v section to", f.Header.GUID, t)
+	}
+	for _, s := range f.Sections {
+		if s.Header.Type == t {
+			s.SetBuf(data)
+			return s, s.GenSecHeader()
+		}
+	}
+	s, err := uefi.CreateSection(t, data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.GenSecHeader(); err != nil {
+		return nil, err
+	}
+	f.Sections = append(f.Sections, s)
+	return s, nil
+}
+
+func init() {
+	RegisterCLI("set_ui", "set the name in the UI section of files given a GUID or name", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFilePredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &SetUI{
+			Predicate: pred,
+			Name:      args[1],
+		}, nil
+	})
+
+	RegisterCLI("set_version", "set the build number and string of the version section of files given a GUID or name", 3, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFilePredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		build, err := strconv.ParseUint(args[1], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build number %q: %v", args[1], err)
+		}
+		return &SetVersion{
+			Predicate:   pred,
+			BuildNumber: uint16(build),
+			Version:     args[2],
+		}, nil
+	})
+}
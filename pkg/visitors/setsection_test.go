@@ -0,0 +1,67 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSetUIAndVersion(t *testing.T) {
+	f := parseImage(t)
+	file := find(t, f, dxeCoreGUID)[0].(*uefi.File)
+	// Drop the version section so it is added back.
+	var sections []*uefi.Section
+	for _, s := range file.Sections {
+		if s.Header.Type != uefi.SectionTypeVersion {
+			sections = append(sections, s)
+		}
+	}
+	file.Sections = sections
+
+	pred := FindFileGUIDPredicate(*dxeCoreGUID)
+	if err := (&SetUI{Predicate: pred, Name: "Stamped"}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&SetVersion{Predicate: pred, BuildNumber: 0x1234, Version: "1.2.3"}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Set it twice to update the section added.
+	if err := (&SetVersion{Predicate: pred, BuildNumber: 0x4321, Version: "3.2.1"}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = find(t, parsed, dxeCoreGUID)[0].(*uefi.File)
+	var ui, version *uefi.Section
+	for _, s := range file.Sections {
+		switch s.Header.Type {
+		case uefi.SectionTypeUserInterface:
+			ui = s
+		case uefi.SectionTypeVersion:
+			version = s
+		}
+	}
+	if ui == nil || ui.Name != "Stamped" {
+		t.Errorf("got UI section %v, want one named Stamped", ui)
+	}
+	if version == nil || version.BuildNumber != 0x4321 || version.Version != "3.2.1" {
+		t.Errorf("got version section %v, want build 0x4321 of 3.2.1", version)
+	}
+	if len(file.Sections) != len(sections)+1 {
+		t.Errorf("got %d sections, want %d and a version one", len(file.Sections), len(sections))
+	}
+
+	if err := (&SetUI{Predicate: FindFileTypePredicate(0x42), Name: "x"}).Run(f); err == nil {
+		t.Errorf("setting the UI of no file succeeded, want an error")
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+const shellHelp = `Commands:
+  ls [PATH]        list the children of a node, by index
+  cd [PATH]        go to a node, or to the root
+  pwd              print the path of the current node
+  cat PATH         hex dump a node
+  save FILE        assemble the image and write it to a file
+  begin            start a transaction, journaling the operations until
+                   commit or rollback
+  commit           end the transaction, keeping its operations if the
+                   image validates
+  rollback         end the transaction, undoing its operations
+  journal [FILE]   write the journal of the last transaction, to a file
+                   if given
+  help             print this help
+  exit             leave the shell
+  OPERATION...     apply utk operations to the current node
+
+Paths are separated by "/" and start at the root if they begin with "/".
+Their elements are "..", an index, or the label, GUID or name of a child.
+
+Operations:
+`
+
+// Shell is an interactive session on an image, which stays parsed between
+// commands. Operations apply to the current node, the root at first.
+type Shell struct {
+	Root uefi.Firmware
+	In   io.Reader
+	Out  io.Writer
+	// Prompt is written before reading each command.
+	Prompt string
+	// Context of the commands, with their options and Middleware, like
+	// ExecuteCLIContext takes. context.Background() if nil.
+	Context context.Context
+	// Options of the assemblies of the commands, nil for those of Context.
+	Options *AssembleOptions
+	// Format is the output format of the reports of the commands, "" for
+	// the one of Context.
+	Format string
+
+	// path holds the nodes from the root to the current node.
+	path []uefi.Firmware
+	// tx is the last transaction, which is open until committed or rolled
+	// back.
+	tx *Transaction
+}
+
+var errShellExit = errors.New("exit")
+
+// Run reads and runs commands until the input ends or exit. Errors of
+// commands are written out, and do not end the session.
+func (s *Shell) Run() error {
+	sc := bufio.NewScanner(s.In)
+	for {
+		fmt.Fprint(s.Out, s.Prompt)
+		if !sc.Scan() {
+			if s.Prompt != "" {
+				fmt.Fprintln(s.Out)
+			}
+			return sc.Err()
+		}
+		if err := s.Exec(sc.Text()); err == errShellExit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(s.Out, "error: %v\n", err)
+		}
+	}
+}
+
+// Exec runs one command.
+func (s *Shell) Exec(line string) error {
+	if len(s.path) == 0 {
+		s.path = []uefi.Firmware{s.Root}
+	}
+	args, err := splitShellLine(line)
+	if err != nil || len(args) == 0 {
+		return err
+	}
+	cur := s.path[len(s.path)-1]
+	switch args[0] {
+	case "exit", "quit":
+		return errShellExit
+	case "help":
+		fmt.Fprint(s.Out, shellHelp, ListCLI())
+		return nil
+	case "pwd":
+		fmt.Fprintln(s.Out, shellPathString(s.path))
+		return nil
+	case "ls", "cd", "cat":
+		if len(args) > 2 {
+			return fmt.Errorf("%s takes at most one path", args[0])
+		}
+		p := s.path
+		if len(args) == 2 {
+			if p, err = s.resolve(args[1]); err != nil {
+				return err
+			}
+		} else if args[0] == "cd" {
+			p = s.path[:1]
+		} else if args[0] == "cat" {
+			return errors.New("cat needs a path")
+		}
+		switch args[0] {
+		case "ls":
+			for i, c := range children(p[len(p)-1]) {
+				label := nodeLabel(c)
+				if file, ok := c.(*uefi.File); ok && fileName(file) != "" {
+					label += " " + fileName(file)
+				}
+				fmt.Fprintf(s.Out, "%4d  %-60s %#x\n", i, label, len(c.Buf()))
+			}
+		case "cd":
+			s.path = p
+		case "cat":
+			d := hex.Dumper(s.Out)
+			if _, err := d.Write(p[len(p)-1].Buf()); err != nil {
+				return err
+			}
+			return d.Close()
+		}
+		return nil
+	case "save":
+		if len(args) != 2 {
+			return errors.New("save needs a file")
+		}
+		if s.inTransaction() {
+			return errors.New("commit or roll back the transaction first")
+		}
+		return (&Save{DirPath: args[1], Options: s.Options}).Run(s.Root)
+	case "begin", "commit", "rollback":
+		if len(args) != 1 {
+			return fmt.Errorf("%s takes no arguments", args[0])
+		}
+		if args[0] == "begin" {
+			if s.inTransaction() {
+				return errors.New("a transaction is already open")
+			}
+			s.tx, err = Begin(s.context(), s.Root)
+			return err
+		}
+		if !s.inTransaction() {
+			return errors.New("no open transaction")
+		}
+		if args[0] == "commit" {
+			return s.tx.Commit()
+		}
+		// The nodes of the tree rolled back are new.
+		s.path = s.path[:1]
+		return s.tx.Rollback()
+	case "journal":
+		if len(args) > 2 {
+			return errors.New("journal takes at most one file")
+		}
+		if s.tx == nil {
+			return errors.New("no transaction")
+		}
+		if len(args) == 1 {
+			return s.tx.Journal.Write(s.Out)
+		}
+		out, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		if err := s.tx.Journal.Write(out); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	if s.inTransaction() {
+		return s.tx.Apply(cur, args)
+	}
+	v, err := ParseCLI(args)
+	if err != nil {
+		return err
+	}
+	return ExecuteCLIContext(s.context(), cur, v)
+}
+
+// context returns the context of the commands.
+func (s *Shell) context() context.Context {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if s.Options != nil {
+		ctx = WithAssembleOptions(ctx, s.Options)
+	}
+	if s.Format != FormatDefault {
+		ctx = WithOutputFormat(ctx, s.Format)
+	}
+	return ctx
+}
+
+// inTransaction reports whether a transaction is open.
+func (s *Shell) inTransaction() bool {
+	return s.tx != nil && !s.tx.Done()
+}
+
+// resolve returns the nodes from the root to the node at p.
+func (s *Shell) resolve(p string) ([]uefi.Firmware, error) {
+	path := append([]uefi.Firmware{}, s.path...)
+	if strings.HasPrefix(p, "/") {
+		path = path[:1]
+	}
+	for _, e := range strings.Split(p, "/") {
+		switch e {
+		case "", ".":
+			continue
+		case "..":
+			if len(path) > 1 {
+				path = path[:len(path)-1]
+			}
+			continue
+		}
+		c, err := shellChild(path[len(path)-1], e)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, c)
+	}
+	return path, nil
+}
+
+// shellChild returns the child of f at the index or with the label, GUID
+// or name e.
+func shellChild(f uefi.Firmware, e string) (uefi.Firmware, error) {
+	cs := children(f)
+	if i, err := strconv.Atoi(e); err == nil {
+		if i < 0 || i >= len(cs) {
+			return nil, fmt.Errorf("%s has no child %d", nodeLabel(f), i)
+		}
+		return cs[i], nil
+	}
+	for _, c := range cs {
+		if strings.EqualFold(nodeLabel(c), e) {
+			return c, nil
+		}
+		for _, attr := range []string{"guid", "name"} {
+			for _, v := range queryAttr(c, attr) {
+				if strings.EqualFold(v, e) {
+					return c, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s has no child %q", nodeLabel(f), e)
+}
+
+func shellPathString(path []uefi.Firmware) string {
+	var labels []string
+	for _, f := range path[1:] {
+		labels = append(labels, nodeLabel(f))
+	}
+	return "/" + strings.Join(labels, "/")
+}
+
+// splitShellLine splits a command line into arguments, separated by spaces
+// unless quoted with single or double quotes.
+func splitShellLine(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
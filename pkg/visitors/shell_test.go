@@ -0,0 +1,119 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestShell(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shell-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "out.rom")
+
+	f := parseImage(t)
+	in := strings.Join([]string{
+		"ls",
+		"cd 1/0/0",
+		"cd ..",
+		"pwd",
+		"cd /1/nosuchchild",
+		"cd /",
+		"cd 1/0/0/3/0/DxeCore",
+		"pwd",
+		"cd ..",
+		"remove DxeCore",
+		"pwd",
+		"save " + out,
+		"exit",
+		"pwd",
+	}, "\n")
+	var b bytes.Buffer
+	if err := (&Shell{Root: f, In: strings.NewReader(in), Out: &b}).Run(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if !strings.Contains(lines[0], "0  FirmwareVolume(") {
+		t.Errorf("got %q, want the volumes listed by index", lines[0])
+	}
+	var pwds []string
+	var errs int
+	for _, l := range lines {
+		if strings.HasPrefix(l, "/") {
+			pwds = append(pwds, l)
+		}
+		if strings.HasPrefix(l, "error: ") {
+			errs++
+		}
+	}
+	if len(pwds) != 3 {
+		t.Fatalf("got paths %q, want 3", pwds)
+	}
+	if !strings.HasPrefix(pwds[0], "/FirmwareVolume(48DB5E17") || strings.Count(pwds[0], "/") != 2 {
+		t.Errorf("got %q, want the file in the second volume", pwds[0])
+	}
+	if !strings.HasSuffix(pwds[1], "/File("+dxeCoreGUID.String()+")") {
+		t.Errorf("got %q, want the DXE core", pwds[1])
+	}
+	if !strings.HasSuffix(pwds[2], "/FirmwareVolume(7CB8BDC9-F8EB-4F34-AAEA-3EE4AF6516A1@0x0)") {
+		t.Errorf("got %q, want the volume of the DXE core", pwds[2])
+	}
+	if errs != 1 {
+		t.Errorf("got %d errors, want 1:\n%s", errs, b.String())
+	}
+
+	saved, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := uefi.Parse(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if find(t, image, dxeCoreGUID) != nil {
+		t.Errorf("the saved image still has the removed file")
+	}
+}
+
+func TestShellContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := parseImage(t)
+	var b bytes.Buffer
+	sh := &Shell{Root: f, In: strings.NewReader("remove DxeCore"), Out: &b, Context: ctx}
+	if err := sh.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), context.Canceled.Error()) {
+		t.Errorf("got %q, want the command canceled with the context of the shell", b.String())
+	}
+	if find(t, f, dxeCoreGUID) == nil {
+		t.Errorf("the command of a canceled context removed the file")
+	}
+}
+
+func TestSplitShellLine(t *testing.T) {
+	got, err := splitShellLine(` find  "a b"'c' '' x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"find", "a bc", "", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := splitShellLine(`find "a`); err == nil {
+		t.Errorf("splitting an unterminated quote succeeded, want an error")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// smmDispatchProtocols are the protocols through which SMM modules
+// register SMI handlers, from the PI specification, volume 4.
+var smmDispatchProtocols = []struct {
+	name string
+	guid *guid.GUID
+}{
+	{"SwDispatch2", guid.MustParse("18A3C6DC-5EEA-48C8-A1C1-B53389F98999")},
+	{"SwDispatch", guid.MustParse("E541B773-DD11-420C-B026-DF993653F8BF")},
+	{"SxDispatch2", guid.MustParse("456D2859-A84B-4E47-A2EE-3276D886997D")},
+	{"PeriodicTimerDispatch2", guid.MustParse("4CEC368E-8E8E-4D71-8BE1-958C45FC8A53")},
+	{"UsbDispatch2", guid.MustParse("EE9B8D90-C5A6-40A2-BDE2-52558D33CCA1")},
+	{"GpiDispatch2", guid.MustParse("25566B03-B577-4CBF-958C-ED663EA24380")},
+	{"StandbyButtonDispatch2", guid.MustParse("7300C4A1-43F2-4017-A51B-C81A7F40585B")},
+	{"PowerButtonDispatch2", guid.MustParse("1B1183FA-1823-46A7-8872-9C578755409D")},
+	{"IoTrapDispatch2", guid.MustParse("58DC368D-7BFA-4E77-ABBC-0E29418DF930")},
+}
+
+// SMMModule is an SMM or MM module and the SMI sources it handles.
+type SMMModule struct {
+	GUID guid.GUID
+	Name string `json:",omitempty"`
+	Type string
+	// Dispatchers are the SMI dispatch protocols the module refers to.
+	Dispatchers []string `json:",omitempty"`
+	// SwSMIs are the SW SMI numbers found next to calls to Register of the
+	// SW dispatch protocols. They are a heuristic: values may be missing
+	// or wrong.
+	SwSMIs []int `json:",omitempty"`
+}
+
+// SMMInventory lists the SMM and MM modules of the firmware and the SMI
+// handlers they register, which make up the attack surface of SMM.
+type SMMInventory struct {
+	Reporter
+
+	// Optionally write the inventory as JSON.
+	W io.Writer `json:"-"`
+
+	// Output
+	Modules []SMMModule
+
+	module *SMMModule
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SMMInventory) Run(f uefi.Firmware) error {
+	v.Modules = []SMMModule{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		b, err := marshalReport(v.Format, v.Modules)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	return nil
+}
+
+// Visit applies the SMMInventory visitor to any Firmware type.
+func (v *SMMInventory) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		if !isSMMFile(f.Header.Type) {
+			return f.ApplyChildren(v)
+		}
+		parent := v.module
+		v.module = &SMMModule{GUID: f.Header.GUID, Type: f.Header.Type.String()}
+		if err := f.ApplyChildren(v); err != nil {
+			return err
+		}
+		v.Modules = append(v.Modules, *v.module)
+		v.module = parent
+		return nil
+
+	case *uefi.Section:
+		if v.module == nil {
+			return f.ApplyChildren(v)
+		}
+		switch f.Header.Type {
+		case uefi.SectionTypeUserInterface:
+			v.module.Name = f.Name
+		case uefi.SectionTypePE32, uefi.SectionTypeTE:
+			v.inspectImage(f.Buf()[f.HeaderLen():])
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *SMMInventory) inspectImage(img []byte) {
+	swDispatch := false
+	for _, p := range smmDispatchProtocols {
+		if bytes.Contains(img, p.guid[:]) {
+			v.module.Dispatchers = append(v.module.Dispatchers, p.name)
+			swDispatch = swDispatch || p.name == "SwDispatch2" || p.name == "SwDispatch"
+		}
+	}
+	if swDispatch {
+		v.module.SwSMIs = mergeSwSMIs(v.module.SwSMIs, swSMIValues(img))
+	}
+}
+
+func isSMMFile(t uefi.FVFileType) bool {
+	switch t {
+	case uefi.FVFileTypeSMM, uefi.FVFileTypeCombinedSMMDXE, uefi.FVFileTypeSMMCore,
+		uefi.FVFileTypeSMMStandalone, uefi.FVFileTypeSMMCoreStandalone:
+		return true
+	}
+	return false
+}
+
+// swSMICallWindow is how far after the store of the SW SMI number the call
+// to Register is searched for.
+const swSMICallWindow = 0x40
+
+// swSMIValues returns the SW SMI numbers x64 code in img stores on the
+// stack, in the SwSmiInputValue of an EFI_SMM_SW_REGISTER_CONTEXT, shortly
+// before an indirect call through offset 0 of a register, where Register
+// is in the SW dispatch protocols.
+func swSMIValues(img []byte) []int {
+	// mov qword [rsp/rbp+disp], imm32 with their immediate offset.
+	stores := []struct {
+		prefix []byte
+		imm    int
+	}{
+		{[]byte{0x48, 0xc7, 0x44, 0x24}, 5},
+		{[]byte{0x48, 0xc7, 0x45}, 4},
+		{[]byte{0x48, 0xc7, 0x84, 0x24}, 8},
+		{[]byte{0x48, 0xc7, 0x85}, 7},
+	}
+	var values []int
+	for i := range img {
+		for _, s := range stores {
+			end := i + s.imm + 4
+			if end > len(img) || !bytes.HasPrefix(img[i:], s.prefix) {
+				continue
+			}
+			imm := img[i+s.imm : end]
+			if imm[0] == 0 || imm[1] != 0 || imm[2] != 0 || imm[3] != 0 {
+				continue
+			}
+			if registerCallFollows(img[end:]) {
+				values = append(values, int(imm[0]))
+			}
+		}
+	}
+	return mergeSwSMIs(nil, values)
+}
+
+// registerCallFollows returns true if code starts, within swSMICallWindow
+// bytes, with a call qword [reg] or call qword [reg+0].
+func registerCallFollows(code []byte) bool {
+	if len(code) > swSMICallWindow {
+		code = code[:swSMICallWindow]
+	}
+	for i := 0; i+1 < len(code); i++ {
+		if code[i] != 0xff {
+			continue
+		}
+		modrm := code[i+1]
+		rm := modrm & 7
+		mod := modrm >> 6
+		// rm 4 needs a SIB byte and rm 5 without displacement is RIP
+		// relative.
+		if rm == 4 || (modrm>>3)&7 != 2 {
+			continue
+		}
+		if (mod == 0 && rm != 5) || (mod == 1 && i+2 < len(code) && code[i+2] == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSwSMIs returns the sorted union of a and b.
+func mergeSwSMIs(a, b []int) []int {
+	seen := map[int]bool{}
+	var r []int
+	for _, x := range append(a, b...) {
+		if !seen[x] {
+			seen[x] = true
+			r = append(r, x)
+		}
+	}
+	sort.Ints(r)
+	return r
+}
+
+func init() {
+	RegisterCLI("smm_inventory", "list SMM modules, their SMI dispatchers and SW SMI numbers as JSON", 0, func(args []string) (uefi.Visitor, error) {
+		return &SMMInventory{
+			W: os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// smmImage returns code registering SW SMIs 0x42 and 0xa0 through
+// SwDispatch2, with a store of 0x55 not followed by a call and one of 0x66
+// followed by a RIP relative call.
+func smmImage() []byte {
+	var b bytes.Buffer
+	b.WriteString("MZ")
+	b.Write(smmDispatchProtocols[0].guid[:])
+	b.Write(smmDispatchProtocols[2].guid[:])
+	// mov qword [rsp+0x20], 0x42; lea r8, [rsp+0x20]; call qword [rax]
+	b.Write([]byte{0x48, 0xc7, 0x44, 0x24, 0x20, 0x42, 0, 0, 0})
+	b.Write([]byte{0x4c, 0x8d, 0x44, 0x24, 0x20, 0xff, 0x10})
+	// mov qword [rbp-0x10], 0x55; ret
+	b.Write([]byte{0x48, 0xc7, 0x45, 0xf0, 0x55, 0, 0, 0, 0xc3})
+	b.Write(bytes.Repeat([]byte{0x90}, swSMICallWindow))
+	// mov qword [rsp+0x28], 0x66; call qword [rip+0]
+	b.Write([]byte{0x48, 0xc7, 0x44, 0x24, 0x28, 0x66, 0, 0, 0, 0xff, 0x15, 0, 0, 0, 0})
+	b.Write(bytes.Repeat([]byte{0x90}, swSMICallWindow))
+	// mov qword [rbp+0x100], 0xa0; call qword [rbx+0]
+	b.Write([]byte{0x48, 0xc7, 0x85, 0, 1, 0, 0, 0xa0, 0, 0, 0, 0xff, 0x53, 0x00})
+	return b.Bytes()
+}
+
+func TestSMMInventory(t *testing.T) {
+	pe, err := uefi.CreateSection(uefi.SectionTypePE32, smmImage(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pe.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	smm := &uefi.File{Sections: []*uefi.Section{pe}}
+	smm.Header.GUID = *testGUID
+	smm.Header.Type = uefi.FVFileTypeSMM
+	dxe := &uefi.File{Sections: []*uefi.Section{pe}}
+	dxe.Header.Type = uefi.FVFileTypeDriver
+	fv := &uefi.FirmwareVolume{Files: []*uefi.File{dxe, smm}}
+
+	var b bytes.Buffer
+	v := &SMMInventory{W: &b}
+	if err := v.Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	want := []SMMModule{{
+		GUID:        *testGUID,
+		Type:        "EFI_FV_FILETYPE_MM",
+		Dispatchers: []string{"SwDispatch2", "SxDispatch2"},
+		SwSMIs:      []int{0x42, 0xa0},
+	}}
+	if !reflect.DeepEqual(v.Modules, want) {
+		t.Errorf("SMMInventory: got %+v, want %+v", v.Modules, want)
+	}
+	var modules []SMMModule
+	if err := json.Unmarshal(b.Bytes(), &modules); err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 1 {
+		t.Errorf("JSON: got %d modules, want 1", len(modules))
+	}
+}
+
+func TestSMMInventoryOVMF(t *testing.T) {
+	f := parseImage(t)
+
+	// This OVMF is built without SMM.
+	v := &SMMInventory{}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Modules) != 0 {
+		t.Errorf("SMMInventory: got %d modules, want none", len(v.Modules))
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// SoftStraps reports the PCH and processor soft straps of the descriptor,
+// decoding the fields known for the platform it is laid out for.
+type SoftStraps struct {
+	Reporter
+
+	// Optionally write the report, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Platform uefi.StrapPlatform
+	Straps   []uefi.SoftStrap
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SoftStraps) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	fmt.Fprintf(v.W, "Platform: %s\n", v.Platform)
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Strap\tValue\tField\tBits\tMeaning")
+	for _, s := range v.Straps {
+		fmt.Fprintf(w, "%s\t0x%08x\t\t\t\n", s.Name, s.Value)
+		for _, f := range s.Fields {
+			bits := fmt.Sprint(f.Bit)
+			if f.Width > 1 {
+				bits = fmt.Sprintf("%d:%d", f.Bit+f.Width-1, f.Bit)
+			}
+			fmt.Fprintf(w, "\t%#x\t%s\t%s\t%s\n", f.Value, f.Name, bits, f.Meaning)
+		}
+	}
+	return w.Flush()
+}
+
+// Visit decodes the soft straps of the descriptor of f.
+func (v *SoftStraps) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return errNoFlashImage
+	}
+	straps, err := fi.IFD.SoftStraps()
+	if err != nil {
+		return err
+	}
+	v.Platform, v.Straps = fi.IFD.StrapPlatform(), straps
+	return nil
+}
+
+func init() {
+	RegisterCLI("soft_straps", "print the PCH and processor soft straps of the descriptor, with the fields known for its platform", 0, func(args []string) (uefi.Visitor, error) {
+		return &SoftStraps{W: os.Stdout}, nil
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSoftStraps(t *testing.T) {
+	buf := ifdImage(t)
+	// 18 PCH straps at 0x100, a processor strap at 0x200 and the ICC table
+	// at 0x220, as on Cougar Point, with AltMeDisable set.
+	copy(buf[26:], []byte{0x10, 18, 0x20, 1, 0x22})
+	buf[0x128] = 0x80
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &SoftStraps{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.Platform != uefi.StrapPlatformCougarPoint || len(v.Straps) != 19 {
+		t.Errorf("got %d straps for %q, want 19 for %q", len(v.Straps), v.Platform, uefi.StrapPlatformCougarPoint)
+	}
+	for _, s := range []string{"Platform: 6/7 series (Cougar/Panther Point)\n", "PCHSTRP10  0x00000080", "0x1         AltMeDisable  7     ME disabled", "PROCSTRP0  0x"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestSoftStrapsNoDescriptor(t *testing.T) {
+	if err := (&SoftStraps{}).Run(parseImage(t)); err != errNoFlashImage {
+		t.Errorf("got %v, want %v", err, errNoFlashImage)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoFlashImage = errors.New("not a flash image with an Intel flash descriptor")
+
+// Split writes the descriptor and every region of a flash image into its
+// own file in DirPath, named like ifdtool -x does.
+type Split struct {
+	DirPath string
+}
+
+// Run assembles the image and applies the visitor.
+func (v *Split) Run(f uefi.Firmware) error {
+	if err := f.Apply(&Assemble{}); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit writes the region files.
+func (v *Split) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return errNoFlashImage
+	}
+	if err := os.MkdirAll(v.DirPath, 0755); err != nil {
+		return err
+	}
+	for n, b := range fi.SplitRegions() {
+		if err := os.WriteFile(filepath.Join(v.DirPath, n), b, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge replaces the descriptor and the regions of a flash image by the
+// files in DirPath which are named like those written by Split. Regions
+// without a file are kept.
+type Merge struct {
+	DirPath string
+}
+
+// Run assembles the image and applies the visitor.
+func (v *Merge) Run(f uefi.Firmware) error {
+	if err := f.Apply(&Assemble{}); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit merges the region files and parses the resulting image.
+func (v *Merge) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return errNoFlashImage
+	}
+	files := map[string][]byte{}
+	names := []string{uefi.DescriptorFileName}
+	for rt := uefi.RegionTypeBIOS; rt <= uefi.RegionTypePTT; rt++ {
+		names = append(names, uefi.RegionFileName(rt))
+	}
+	for _, n := range names {
+		b, err := os.ReadFile(filepath.Join(v.DirPath, n))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		files[n] = b
+	}
+	if len(files) == 0 {
+		return errors.New("no region files in " + v.DirPath)
+	}
+	buf, err := fi.MergeRegions(files)
+	if err != nil {
+		return err
+	}
+	n, err := uefi.NewFlashImage(buf)
+	if err != nil {
+		return err
+	}
+	*fi = *n
+	return nil
+}
+
+func init() {
+	RegisterCLI("split", "write the descriptor and each region of a flash image to a directory", 1, func(args []string) (uefi.Visitor, error) {
+		return &Split{
+			DirPath: args[0],
+		}, nil
+	})
+	RegisterCLI("merge", "replace descriptor and regions of a flash image by the files in a directory written by split", 1, func(args []string) (uefi.Visitor, error) {
+		return &Merge{
+			DirPath: args[0],
+		}, nil
+	})
+}
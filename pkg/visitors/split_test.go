@@ -0,0 +1,80 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ifdImage returns a 256KiB flash image with a descriptor, a GbE region at
+// 0x1000 and a BIOS region at 0x8000 holding the OVMF SEC volume.
+func ifdImage(t *testing.T) []byte {
+	fv, err := os.ReadFile("../../integration/roms/ovmfSECFV.fv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Repeat([]byte{0xff}, 0x40000)
+	copy(buf[0x8000:], fv)
+	copy(buf, make([]byte, uefi.FlashDescriptorLength))
+	copy(buf[16:], uefi.FlashSignature)
+	copy(buf[20:], []byte{0x03, 0x00, 0x04, 0x00, 0x08, 0x03})
+	for _, r := range []struct {
+		t           uefi.FlashRegionType
+		base, limit uint16
+	}{{uefi.RegionTypeBIOS, 8, 0x3f}, {uefi.RegionTypeGBE, 1, 2}} {
+		binary.LittleEndian.PutUint16(buf[0x44+4*int(r.t):], r.base)
+		binary.LittleEndian.PutUint16(buf[0x46+4*int(r.t):], r.limit)
+	}
+	return buf
+}
+
+func TestSplitMerge(t *testing.T) {
+	buf := ifdImage(t)
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := (&Split{DirPath: dir}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	gbe := filepath.Join(dir, "flashregion_3_gbe.bin")
+	b, err := os.ReadFile(gbe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, buf[0x1000:0x3000]) {
+		t.Errorf("split: wrong GbE region")
+	}
+
+	copy(b, "edited")
+	if err := os.WriteFile(gbe, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Merge{DirPath: dir}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{}, buf...)
+	copy(want[0x1000:], "edited")
+	if !bytes.Equal(f.Buf(), want) {
+		t.Errorf("merge: image differs from the expected one")
+	}
+
+	if err := os.WriteFile(gbe, b[:0x1000], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Merge{DirPath: dir}).Run(f); err == nil {
+		t.Errorf("merge of a truncated region: got nil, want error")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// DefaultGUIDPrefixLen is the number of hex digits of the file GUIDs Stats
+// groups files by, unless set.
+const DefaultGUIDPrefixLen = 4
+
+// StatsGroup is the number and total size of the nodes of a group.
+type StatsGroup struct {
+	Count int
+	Size  uint64
+}
+
+// Stats summarizes an image: the number and size of its files and sections
+// by type, of its sections by compression scheme and of its files by GUID
+// prefix, and the free space of its volumes. Sizes are those in the parent,
+// so nested nodes count in the size of their parents too.
+type Stats struct {
+	Cancelable
+	Reporter
+
+	// GUIDPrefixLen is the number of hex digits of the GUID prefixes files
+	// are grouped by, DefaultGUIDPrefixLen if 0.
+	GUIDPrefixLen int
+	// Optionally write result as JSON.
+	W io.Writer `json:"-"`
+
+	// Output
+	Size         uint64
+	Volumes      int
+	FreeSpace    uint64
+	FileTypes    map[string]StatsGroup
+	SectionTypes map[string]StatsGroup
+	Compression  map[string]StatsGroup
+	GUIDPrefixes map[string]StatsGroup
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Stats) Run(f uefi.Firmware) error {
+	v.Size = uint64(len(f.Buf()))
+	v.Volumes = 0
+	v.FreeSpace = 0
+	v.FileTypes = map[string]StatsGroup{}
+	v.SectionTypes = map[string]StatsGroup{}
+	v.Compression = map[string]StatsGroup{}
+	v.GUIDPrefixes = map[string]StatsGroup{}
+
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	return nil
+}
+
+// Visit applies the Stats visitor to any Firmware type.
+func (v *Stats) Visit(f uefi.Firmware) error {
+	add := func(m map[string]StatsGroup, key string) {
+		g := m[key]
+		g.Count++
+		g.Size += uint64(len(f.Buf()))
+		m[key] = g
+	}
+
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		v.Volumes++
+		v.FreeSpace += f.FreeSpace
+	case *uefi.File:
+		add(v.FileTypes, f.Type)
+		n := v.GUIDPrefixLen
+		if n <= 0 {
+			n = DefaultGUIDPrefixLen
+		}
+		g := f.Header.GUID.String()
+		if n > len(g) {
+			n = len(g)
+		}
+		add(v.GUIDPrefixes, g[:n])
+	case *uefi.Section:
+		add(v.SectionTypes, f.Type)
+		if c := compressionName(f); c != "" {
+			add(v.Compression, c)
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("stats", "count and size files and sections by type, compression and GUID prefix, and sum the free space", 0, func(args []string) (uefi.Visitor, error) {
+		return &Stats{
+			W: os.Stdout,
+		}, nil
+	})
+	RegisterCLI("stats_prefix", "like stats, grouping files by GUID prefixes of N hex digits", 1, func(args []string) (uefi.Visitor, error) {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("stats_prefix: bad prefix length %q", args[0])
+		}
+		return &Stats{
+			GUIDPrefixLen: n,
+			W:             os.Stdout,
+		}, nil
+	})
+}
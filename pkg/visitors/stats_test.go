@@ -0,0 +1,52 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	f := parseImage(t)
+	count := &Count{}
+	if err := count.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	stats := &Stats{}
+	if err := stats.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Volumes != count.FirmwareTypeCount["FirmwareVolume"] {
+		t.Errorf("got %d volumes, want %d", stats.Volumes, count.FirmwareTypeCount["FirmwareVolume"])
+	}
+	if stats.FreeSpace == 0 {
+		t.Error("got no free space")
+	}
+	for typ, n := range count.FileTypeCount {
+		if stats.FileTypes[typ].Count != n {
+			t.Errorf("%s: got %d files, want %d", typ, stats.FileTypes[typ].Count, n)
+		}
+	}
+	for typ, n := range count.SectionTypeCount {
+		if stats.SectionTypes[typ].Count != n {
+			t.Errorf("%s: got %d sections, want %d", typ, stats.SectionTypes[typ].Count, n)
+		}
+	}
+	if g := stats.Compression["LZMA"]; g.Count != 1 || g.Size == 0 {
+		t.Errorf("got LZMA compression %+v, want one section", g)
+	}
+
+	var files int
+	for prefix, g := range stats.GUIDPrefixes {
+		if len(prefix) != DefaultGUIDPrefixLen {
+			t.Errorf("prefix %q: got length %d, want %d", prefix, len(prefix), DefaultGUIDPrefixLen)
+		}
+		files += g.Count
+	}
+	if want := count.FirmwareTypeCount["File"]; files != want {
+		t.Errorf("got %d files by GUID prefix, want %d", files, want)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// SyncBackup copies the volumes the BIOS region boots from over their
+// backup, so dual BIOS boards do not restore the image as it was before
+// edits.
+type SyncBackup struct {
+	// Output
+	Synced int
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SyncBackup) Run(f uefi.Firmware) error {
+	v.Synced = 0
+	return f.Apply(v)
+}
+
+// Visit applies the SyncBackup visitor to any Firmware type.
+func (v *SyncBackup) Visit(f uefi.Firmware) error {
+	br, ok := f.(*uefi.BIOSRegion)
+	if !ok {
+		return f.ApplyChildren(v)
+	}
+	b := br.Backup()
+	if b == nil {
+		return nil
+	}
+	// The primary copy is read from the region, up to date.
+	if err := (&Assemble{}).Run(br); err != nil {
+		return err
+	}
+	buf := br.Buf()
+	end := b.PrimaryOffset + b.Length()
+	if end > uint64(len(buf)) {
+		return fmt.Errorf("primary copy of the BIOS backup at %#x ends at %#x, past the BIOS region of %#x bytes", b.PrimaryOffset, end, len(buf))
+	}
+	nb, err := uefi.NewBIOSBackup(buf[b.PrimaryOffset:end], b.Offset, b.PrimaryOffset)
+	if err != nil {
+		return err
+	}
+	for i, e := range br.Elements {
+		if e.Value == b {
+			br.Elements[i] = uefi.MakeTyped(nb)
+		}
+	}
+	copy(buf[b.Offset:], nb.Buf())
+	v.Synced++
+	return nil
+}
+
+func init() {
+	RegisterCLI("sync_backup", "copy the volumes the BIOS region boots from over their backup on dual BIOS images", 0, func(args []string) (uefi.Visitor, error) {
+		return &SyncBackup{}, nil
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSyncBackup(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := uefi.Parse(append(append([]byte{}, image...), image...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := f.(*uefi.BIOSRegion)
+	b := br.Backup()
+	if b == nil || br.Elements[0].Value != b {
+		t.Fatal("got no backup first in the region")
+	}
+
+	// Edit the primary copy only.
+	for _, e := range br.Elements[1:] {
+		if err := (&Remove{Predicate: FindFileGUIDPredicate(*testGUID), Pad: true}).Run(e.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(find(t, b, testGUID)); n != 1 {
+		t.Fatalf("got %d matches in the backup before syncing, want 1", n)
+	}
+
+	v := &SyncBackup{}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.Synced != 1 {
+		t.Errorf("synced %d backups, want 1", v.Synced)
+	}
+	b = br.Backup()
+	if n := len(find(t, b, testGUID)); n != 0 {
+		t.Errorf("got %d matches in the backup after syncing, want 0", n)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	buf := f.Buf()
+	if !bytes.Equal(buf[:len(image)], buf[len(image):]) {
+		t.Errorf("got a backup differing from its primary copy")
+	}
+	if bytes.Equal(buf[len(image):], image) {
+		t.Errorf("got the primary copy unchanged by the edit")
+	}
+}
+
+func TestSyncBackupNone(t *testing.T) {
+	v := &SyncBackup{}
+	if err := v.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	if v.Synced != 0 {
+		t.Errorf("synced %d backups of an image without any", v.Synced)
+	}
+}
@@ -6,17 +6,25 @@ package visitors
 
 import (
 	"bytes"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/knownguids"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
 // Table prints the GUIDS, types and sizes as a compact table.
 type Table struct {
+	Cancelable
+	Reporter
+
 	W         *tabwriter.Writer
 	Scan      bool
 	Layout    bool
@@ -25,8 +33,46 @@ type Table struct {
 	offset    uint64
 	curOffset uint64
 	printRow  func(v *Table, node, name, typez interface{}, offset, length uint64)
+	// rows collects the rows when the output format is set, or the table
+	// is customized.
+	rows *[]TableRow
+	// offsets collects the offsets of the nodes, if not nil.
+	offsets map[uefi.Firmware]uint64
+
+	// Columns selects the columns, in TableColumns, of a customized table.
+	// Customized tables are collected and written once complete.
+	Columns []string
+	// SortBy are the columns the rows of a customized table are sorted by,
+	// descending if prefixed by "-".
+	SortBy []string
+	// Separator writes a customized table as comma or tab separated values
+	// if set to ',' or '\t'.
+	Separator rune
+	// Out is where a customized table, or rows in the output format, are
+	// written, os.Stdout if nil.
+	Out io.Writer
+}
+
+// TableRow is a row of the table, as written in the output format.
+type TableRow struct {
+	Depth       int
+	Node        string
+	Name        string `json:",omitempty"`
+	Type        string `json:",omitempty"`
+	Offset      uint64
+	Size        uint64
+	UIName      string `json:",omitempty"`
+	Compression string `json:",omitempty"`
+	// Checksum is "ok" or "bad" for the nodes which have a checksum.
+	Checksum string `json:",omitempty"`
 }
 
+// TableColumns are the columns a customized table may have.
+var TableColumns = []string{"depth", "node", "name", "type", "offset", "size", "ui", "compression", "checksum"}
+
+// tableDefaultColumns are the columns of a customized table by default.
+var tableDefaultColumns = []string{"node", "name", "type", "offset", "size"}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Table) Run(f uefi.Firmware) error {
 	return f.Apply(v)
@@ -57,16 +103,41 @@ func (v *Table) Visit(f uefi.Firmware) error {
 		}
 		return v.printFirmware(f, "BIOS", "", "", offset, offset)
 	case *uefi.BIOSPadding:
-		return v.printFirmware(f, "BIOS Pad", "", "", v.offset+f.Offset, 0)
+		return v.printFirmware(f, "BIOS Pad", "", "", v.offset+f.Offset, v.offset+f.Offset)
+	case *uefi.BIOSBackup:
+		return v.printFirmware(f, "BIOS Backup", "", fmt.Sprintf("of %#x", v.offset+f.PrimaryOffset), v.offset+f.Offset, v.offset+f.Offset)
+	case *uefi.ECFirmware:
+		return v.printFirmware(f, "EC", string(f.Vendor), "", v.offset+f.Offset, 0)
 	case *uefi.NVarStore:
 		return v.printFirmware(f, "NVAR Store", "", "", v.curOffset, v.curOffset)
+	case *uefi.OptionROM:
+		return v.printFirmware(f, "OpROM", "", "", v.curOffset+f.Offset, v.curOffset+f.Offset)
+	case *uefi.VariableStore:
+		return v.printFirmware(f, "Var Store", "", f.Type, v.offset+f.Offset, v.offset+f.Offset)
+	case *uefi.Variable:
+		var name string
+		if f.GUID != nil {
+			name = f.GUID.String()
+		}
+		return v.printFirmware(f, "Var", name, f, v.offset+f.Offset, v.offset+f.Offset+f.DataOffset)
+	case *uefi.CompressedVolume:
+		// Reset offset to O for the decompressed volumes
+		return v.printFirmware(f, "Compressed FV", "", f.Compression, v.curOffset+f.Offset, 0)
+	case *uefi.OptionROMImage:
+		typez := f.PCIR.CodeType.String()
+		if f.Compressed() {
+			typez += " (compressed)"
+		}
+		return v.printFirmware(f, "OpROM Image", fmt.Sprintf("%04x:%04x", f.PCIR.VendorID, f.PCIR.DeviceID), typez, v.offset+f.Offset, 0)
 	case *uefi.NVar:
 		return v.printFirmware(f, "NVAR", f.GUID.String(), f, v.curOffset, v.curOffset+uint64(f.DataOffset))
 	case *uefi.MERegion:
 		if f.FRegion != nil {
 			offset = uint64(f.FRegion.BaseOffset())
 		}
-		return v.printFirmware(f, "ME", "", "", offset, offset)
+		return v.printFirmware(f, "ME", "", f.Kind(), offset, offset)
+	case *uefi.CSELayout:
+		return v.printFirmware(f, "CSE Layout", "", f.Version, v.offset, 0)
 	case *uefi.MEFPT:
 		return v.printFirmware(f, "$FPT", "", "", v.offset, 0)
 	case *uefi.RawRegion:
@@ -83,23 +154,58 @@ func indent(n int) string {
 	return strings.Repeat(" ", n)
 }
 
-func scanGUID(v *Table, b []byte) {
+// knownGUIDs are the GUIDs of knownguids in ascending order, so scans
+// report them alike on every run.
+var knownGUIDs = func() []guid.GUID {
+	gs := make([]guid.GUID, 0, len(knownguids.GUIDs))
 	for g := range knownguids.GUIDs {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return bytes.Compare(gs[i][:], gs[j][:]) < 0 })
+	return gs
+}()
+
+func scanGUID(v *Table, b []byte) {
+	for _, g := range knownGUIDs {
 		if bytes.Contains(b, g[:]) {
-			fmt.Fprintf(v.W, "%s\t(RAW)\t%s\n", indent(v.indent), g.String())
+			v.printScan("(RAW)", g.String())
 		}
 		if strings.Contains(string(b), g.String()) {
-			fmt.Fprintf(v.W, "%s\t(STRING)\t%s\n", indent(v.indent), g.String())
+			v.printScan("(STRING)", g.String())
 		}
 	}
 }
 
-func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, offset, dataOffset uint64) error {
+// printScan prints what a scan found in the node.
+func (v *Table) printScan(typez, found interface{}) {
+	if v.rows != nil {
+		*v.rows = append(*v.rows, TableRow{Depth: v.indent, Node: "Scan", Name: fmt.Sprint(found), Type: fmt.Sprint(typez)})
+		return
+	}
+	if typez == "" {
+		fmt.Fprintf(v.W, "%s\t%v\n", indent(v.indent), found)
+		return
+	}
+	fmt.Fprintf(v.W, "%s\t%v\t%v\n", indent(v.indent), typez, found)
+}
+
+func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, offset, dataOffset uint64) (err error) {
 	// Init: Print title and select printRow func
 	if v.W == nil {
-		v.W = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if v.Out == nil {
+			v.Out = os.Stdout
+		}
+		v.W = tabwriter.NewWriter(v.Out, 0, 0, 2, ' ', 0)
 		defer func() { v.W.Flush() }()
-		if v.Layout {
+		if v.Format != FormatDefault || v.customized() {
+			v.rows = &[]TableRow{}
+			v.printRow = printRowRecord
+			defer func() {
+				if err == nil {
+					err = v.writeRows()
+				}
+			}()
+		} else if v.Layout {
 			fmt.Fprintf(v.W, "%sNode\tGUID/Name/Type\tOffset\tSize\n", indent(v.indent))
 			v.printRow = printRowLayout
 		} else {
@@ -116,6 +222,12 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 		}
 	}
 	v.printRow(v, node, name, typez, offset, length)
+	if v.offsets != nil {
+		v.offsets[f] = offset
+	}
+	if v.rows != nil {
+		describeRow(&(*v.rows)[len(*v.rows)-1], f)
+	}
 	v2 := *v
 	v2.indent++
 	v2.offset = dataOffset
@@ -127,7 +239,7 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 			switch s.Header.Type {
 			case uefi.SectionTypeFirmwareVolumeImage:
 			case uefi.SectionTypeDXEDepEx, uefi.SectionTypePEIDepEx, uefi.SectionMMDepEx:
-				fmt.Fprintf(v.W, "%s\t%v\n", indent(v.indent), s.DepEx)
+				v.printScan("", s.DepEx)
 			default:
 				scanGUID(&v2, s.Buf())
 			}
@@ -159,6 +271,26 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 		v2.printRow(&v2, "GUIDStore", "", fmt.Sprintf("%d GUID", len(f.GUIDStore)), offset+f.GUIDStoreOffset, f.Length-f.GUIDStoreOffset)
 	case *uefi.MERegion:
 		v2.printRow(&v2, "Free", "", "", offset+f.FreeSpaceOffset, length-f.FreeSpaceOffset)
+	case *uefi.CSELayout:
+		// Print the partitions and the entries of the boot partitions
+		for _, p := range f.Partitions {
+			if p.Size == 0 {
+				continue
+			}
+			v2.printRow(&v2, p.Name, "", "", offset+uint64(p.Offset), uint64(p.Size))
+			if p.BPDT == nil {
+				continue
+			}
+			v3 := v2
+			v3.indent++
+			for _, e := range p.BPDT.Entries {
+				var eo uint64
+				if e.OffsetIsValid() {
+					eo = offset + uint64(p.Offset) + uint64(e.Offset)
+				}
+				v3.printRow(&v3, e.Type, "", "BPDT", eo, uint64(e.Size))
+			}
+		}
 	case *uefi.MEFPT:
 		// MERegion is not entered, simply print the $FPT content here
 		for _, p := range f.Entries {
@@ -182,6 +314,234 @@ func printRowLayout(v *Table, node, name, typez interface{}, offset, length uint
 	fmt.Fprintf(v.W, "%s%v\t%v\t%#08x\t%#08x\n", indent(v.indent), node, name, offset, length)
 }
 
+func printRowRecord(v *Table, node, name, typez interface{}, offset, length uint64) {
+	*v.rows = append(*v.rows, TableRow{
+		Depth:  v.indent,
+		Node:   fmt.Sprint(node),
+		Name:   fmt.Sprint(name),
+		Type:   fmt.Sprint(typez),
+		Offset: offset,
+		Size:   length,
+	})
+}
+
+// describeRow fills in the columns of the row of f which only customized
+// tables and the output formats have.
+func describeRow(r *TableRow, f uefi.Firmware) {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		r.Checksum = "bad"
+		if sum, err := uefi.Checksum16(f.Buf()[:f.HeaderLen]); err == nil && sum == 0 {
+			r.Checksum = "ok"
+		}
+	case *uefi.File:
+		r.UIName = fileName(f)
+		r.Checksum = "ok"
+		fh := &f.Header
+		if f.ChecksumHeader() != 0 {
+			r.Checksum = "bad"
+		} else if fh.Attributes.HasChecksum() {
+			if uefi.Checksum8(f.Buf()[f.HeaderLen():])+fh.Checksum.File != 0 {
+				r.Checksum = "bad"
+			}
+		} else if fh.Checksum.File != uefi.EmptyBodyChecksum {
+			r.Checksum = "bad"
+		}
+	case *uefi.Section:
+		if f.Header.Type == uefi.SectionTypeUserInterface {
+			r.UIName = f.Name
+		}
+		r.Compression = compressionName(f)
+	case *uefi.CompressedVolume:
+		r.Compression = f.Compression
+	}
+}
+
+// compressionName returns the compression scheme of a section, or "" if it
+// is not compressed.
+func compressionName(s *uefi.Section) string {
+	if s.Header.Type == uefi.SectionTypeCompression {
+		return s.Type
+	}
+	if s.TypeSpecific != nil {
+		if h, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+			return h.Compression
+		}
+	}
+	return ""
+}
+
+// customized returns true if the table has columns, sorting or separators.
+func (v *Table) customized() bool {
+	return len(v.Columns) != 0 || len(v.SortBy) != 0 || v.Separator != 0
+}
+
+// column returns the value of a column of the row, and whether it is a
+// number.
+func (r *TableRow) column(c string) (string, uint64, bool) {
+	switch c {
+	case "depth":
+		return "", uint64(r.Depth), true
+	case "node":
+		return r.Node, 0, false
+	case "name":
+		return r.Name, 0, false
+	case "type":
+		return r.Type, 0, false
+	case "offset":
+		return "", r.Offset, true
+	case "size":
+		return "", r.Size, true
+	case "ui":
+		return r.UIName, 0, false
+	case "compression":
+		return r.Compression, 0, false
+	case "checksum":
+		return r.Checksum, 0, false
+	}
+	return "", 0, false
+}
+
+// checkTableColumns returns an error if a column or sort key is unknown.
+func checkTableColumns(columns []string) error {
+	for _, c := range columns {
+		known := false
+		for _, k := range TableColumns {
+			known = known || strings.TrimPrefix(c, "-") == k
+		}
+		if !known {
+			return fmt.Errorf("unknown column %q, want one of %s", c, strings.Join(TableColumns, ", "))
+		}
+	}
+	return nil
+}
+
+// writeRows sorts the collected rows and writes them to v.Out.
+func (v *Table) writeRows() error {
+	if err := checkTableColumns(append(append([]string{}, v.Columns...), v.SortBy...)); err != nil {
+		return err
+	}
+	rows := *v.rows
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range v.SortBy {
+			c := strings.TrimPrefix(k, "-")
+			si, ni, _ := rows[i].column(c)
+			sj, nj, _ := rows[j].column(c)
+			if si == sj && ni == nj {
+				continue
+			}
+			less := si < sj || (si == sj && ni < nj)
+			if strings.HasPrefix(k, "-") {
+				return !less
+			}
+			return less
+		}
+		return false
+	})
+
+	columns := v.Columns
+	if len(columns) == 0 && (v.Format == FormatDefault || v.Separator != 0) {
+		columns = tableDefaultColumns
+	}
+	if len(columns) == 0 {
+		// Marshalling rows of strings and numbers cannot fail.
+		b, _ := marshalReport(v.Format, rows)
+		_, err := fmt.Fprintln(v.Out, string(b))
+		return err
+	}
+
+	switch {
+	case v.Separator != 0:
+		w := csv.NewWriter(v.Out)
+		w.Comma = v.Separator
+		w.Write(columns)
+		for _, r := range rows {
+			var record []string
+			for _, c := range columns {
+				s, n, isNum := r.column(c)
+				if isNum {
+					s = strconv.FormatUint(n, 10)
+				}
+				record = append(record, s)
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return w.Error()
+	case v.Format != FormatDefault:
+		var objs []orderedObject
+		for _, r := range rows {
+			var o orderedObject
+			for _, c := range columns {
+				if s, n, isNum := r.column(c); isNum {
+					o = o.set(c, n)
+				} else {
+					o = o.set(c, s)
+				}
+			}
+			objs = append(objs, o)
+		}
+		b, err := marshalReport(v.Format, objs)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.Out, string(b))
+		return err
+	}
+	fmt.Fprintln(v.W, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, r := range rows {
+		var cells []string
+		for _, c := range columns {
+			s, n, isNum := r.column(c)
+			switch {
+			case isNum && c == "depth":
+				s = strconv.FormatUint(n, 10)
+			case isNum:
+				s = fmt.Sprintf("%#08x", n)
+			case c == "node" && len(v.SortBy) == 0:
+				s = indent(r.Depth) + s
+			}
+			cells = append(cells, s)
+		}
+		fmt.Fprintln(v.W, strings.Join(cells, "\t"))
+	}
+	return nil
+}
+
+// parseTableOptions returns the customized Table of comma separated options.
+func parseTableOptions(opts string) (*Table, error) {
+	v := &Table{}
+	for _, opt := range strings.Split(opts, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		switch {
+		case opt == "csv":
+			v.Separator = ','
+		case opt == "tsv":
+			v.Separator = '\t'
+		case kv[0] == "col" && len(kv) == 2:
+			v.Columns = append(v.Columns, kv[1])
+		case kv[0] == "sort" && len(kv) == 2:
+			v.SortBy = append(v.SortBy, kv[1])
+		case kv[0] == "depth" && len(kv) == 2:
+			d, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("table_opts: bad depth %q: %v", kv[1], err)
+			}
+			v.Depth = d
+		default:
+			return nil, fmt.Errorf("table_opts: unknown option %q, want csv, tsv, col=COLUMN, sort=[-]COLUMN or depth=N", opt)
+		}
+	}
+	if err := checkTableColumns(append(append([]string{}, v.Columns...), v.SortBy...)); err != nil {
+		return nil, fmt.Errorf("table_opts: %v", err)
+	}
+	if !v.customized() {
+		// Still collect, so depth alone gives the customized table.
+		v.Columns = tableDefaultColumns
+	}
+	return v, nil
+}
+
 func printRowStd(v *Table, node, name, typez interface{}, offset, length uint64) {
 	fmt.Fprintf(v.W, "%s%v\t%v\t%v\t%#8x\n", indent(v.indent), node, name, typez, length)
 }
@@ -196,6 +556,9 @@ func init() {
 	RegisterCLI("layout-table-full", "print out offset and size information in a pretty table", 0, func(args []string) (uefi.Visitor, error) {
 		return &Table{Layout: true}, nil
 	})
+	RegisterCLI("table_opts", "print a table with comma separated options: col=COLUMN (repeatable), sort=[-]COLUMN (repeatable), depth=N, csv, tsv", 1, func(args []string) (uefi.Visitor, error) {
+		return parseTableOptions(args[0])
+	})
 	RegisterCLI("scan", "scan the table for GUIDs and print those found", 0, func(args []string) (uefi.Visitor, error) {
 		return &Table{Scan: true}, nil
 	})
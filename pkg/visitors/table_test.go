@@ -0,0 +1,113 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTableCSV(t *testing.T) {
+	f := parseImage(t)
+	for _, sep := range []rune{',', '\t'} {
+		var b bytes.Buffer
+		v := &Table{
+			Columns:   []string{"node", "ui", "size", "checksum"},
+			SortBy:    []string{"-size"},
+			Separator: sep,
+			Out:       &b,
+		}
+		if err := v.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		r := csv.NewReader(&b)
+		r.Comma = sep
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(records[0], " "); got != "node ui size checksum" {
+			t.Errorf("got header %q", got)
+		}
+		last := uint64(1 << 63)
+		var shell bool
+		for _, rec := range records[1:] {
+			size, err := strconv.ParseUint(rec[2], 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > last {
+				t.Errorf("rows not sorted by decreasing size: %d after %d", size, last)
+			}
+			last = size
+			if rec[0] == "File" && rec[3] != "ok" {
+				t.Errorf("file %s: got checksum %q, want ok", rec[1], rec[3])
+			}
+			shell = shell || rec[1] == "Shell"
+		}
+		if !shell {
+			t.Error("no row has the UI name Shell")
+		}
+	}
+}
+
+func TestTableColumnsFormat(t *testing.T) {
+	f := parseImage(t)
+	var b bytes.Buffer
+	v := &Table{Reporter: Reporter{Format: FormatJSON}, Columns: []string{"type", "compression"}, Out: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]string
+	if err := json.Unmarshal(b.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	var lzma int
+	for _, r := range rows {
+		if len(r) != 2 {
+			t.Errorf("got row %v, want the type and compression", r)
+		}
+		if r["compression"] == "LZMA" {
+			lzma++
+		}
+	}
+	if lzma != 1 {
+		t.Errorf("got %d LZMA sections, want 1", lzma)
+	}
+}
+
+func TestTableCompressedVolume(t *testing.T) {
+	s := compressedVolumeSection(t, 0)
+	var rows []TableRow
+	var b bytes.Buffer
+	if err := (&Table{Reporter: Reporter{Format: FormatJSON}, Out: &b}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) < 3 || rows[1].Node != "Compressed FV" || rows[1].Compression != "LZMA" || rows[1].Offset != s.CompressedVolume.Offset || rows[2].Node != "FV" {
+		t.Errorf("got rows %+v, want the section, the compressed volume and its volume", rows)
+	}
+}
+
+func TestParseTableOptions(t *testing.T) {
+	v, err := parseTableOptions("col=node,col=offset,sort=-offset,depth=2,tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Columns) != 2 || len(v.SortBy) != 1 || v.Depth != 2 || v.Separator != '\t' {
+		t.Errorf("got %+v", v)
+	}
+	for _, opts := range []string{"col=bogus", "sort=-bogus", "depth=x", "wide"} {
+		if _, err := parseTableOptions(opts); err == nil {
+			t.Errorf("%q: parsing succeeded, want an error", opts)
+		}
+	}
+}
@@ -91,6 +91,9 @@ func (v *TightenME) process() error {
 			f.FVOffset += offsetShift
 		case *uefi.BIOSPadding:
 			f.Offset += offsetShift
+		case *uefi.BIOSBackup:
+			f.Offset += offsetShift
+			f.PrimaryOffset += offsetShift
 		default:
 			return fmt.Errorf("unexpected Element at %d: %s", i, e.Type)
 		}
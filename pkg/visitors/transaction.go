@@ -0,0 +1,204 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// States of a Journal.
+const (
+	TransactionOpen       = "open"
+	TransactionCommitted  = "committed"
+	TransactionRolledBack = "rolled back"
+)
+
+var errTransactionDone = errors.New("the transaction is over")
+
+// JournalEntry is an operation of a Transaction and what it changed.
+type JournalEntry struct {
+	PlanStep
+	// Error is the error of the operation, which may have changed the tree
+	// anyway.
+	Error string `json:",omitempty"`
+}
+
+// Journal records the operations of a Transaction.
+type Journal struct {
+	Reporter
+
+	Entries []JournalEntry
+	State   string
+}
+
+// Write writes the journal to w, in the output format, or as text by
+// default.
+func (j *Journal) Write(w io.Writer) error {
+	if j.Format != FormatDefault {
+		b, err := marshalReport(j.Format, j)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+	for _, e := range j.Entries {
+		e.write(w)
+		if e.Error != "" {
+			fmt.Fprintf(w, "  %-8s %s\n", "error", e.Error)
+		}
+	}
+	_, err := fmt.Fprintf(w, "State: %s\n", j.State)
+	return err
+}
+
+// Transaction groups operations on a tree, to keep all of them or none.
+// Apply applies operations and records what each changes in the Journal,
+// as a dry run would, Commit keeps them if the image validates, and
+// Rollback restores the tree as it was at Begin.
+type Transaction struct {
+	Journal Journal
+
+	ctx  context.Context
+	root uefi.Firmware
+	// image is the image assembled at Begin, which Rollback parses again.
+	image []byte
+	// before is the tree of the image assembled after the last operation.
+	before uefi.Firmware
+}
+
+// Begin starts a transaction on the tree of root, whose operations run
+// with the options and Middleware of ctx. The tree is assembled.
+func Begin(ctx context.Context, root uefi.Firmware) (*Transaction, error) {
+	t := &Transaction{
+		Journal: Journal{Reporter: Reporter{Format: OutputFormatOf(ctx)}, Entries: []JournalEntry{}, State: TransactionOpen},
+		ctx:     ctx,
+		root:    root,
+	}
+	before, err := t.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	t.image, t.before = append([]byte{}, root.Buf()...), before
+	return t, nil
+}
+
+// snapshot assembles the tree and returns the tree of the image.
+func (t *Transaction) snapshot() (uefi.Firmware, error) {
+	if err := (&Assemble{Options: AssembleOptionsOf(t.ctx)}).Run(t.root); err != nil {
+		return nil, err
+	}
+	return uefi.ParseContext(t.ctx, append([]byte{}, t.root.Buf()...))
+}
+
+// Done reports whether the transaction was committed or rolled back.
+func (t *Transaction) Done() bool {
+	return t.Journal.State != TransactionOpen
+}
+
+// Apply applies the operations of the command line to f, the root or a
+// node of the tree, and journals what each changes. Operations writing
+// files, like save, are refused, as the changes are not kept yet.
+func (t *Transaction) Apply(f uefi.Firmware, args []string) error {
+	if t.Done() {
+		return errTransactionDone
+	}
+	v, err := ParseCLI(args)
+	if err != nil {
+		return err
+	}
+	for i := range v {
+		if o, ok := v[i].(Outputter); ok && len(o.Outputs()) != 0 {
+			return fmt.Errorf("%s writes files, commit the transaction first", args[0])
+		}
+	}
+
+	run := runFunc(t.ctx)
+	for i := range v {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+		n := visitorRegistry[args[0]].numArgs + 1
+		e := JournalEntry{PlanStep: PlanStep{Op: strings.Join(args[:n], " ")}}
+		args = args[n:]
+
+		prepare(t.ctx, v[i])
+		runErr := run(v[i], f)
+		after, err := t.snapshot()
+		if err != nil {
+			return fmt.Errorf("%s: %v", e.Op, err)
+		}
+		d := &Diff{New: after}
+		if err := d.Run(t.before); err != nil {
+			return err
+		}
+		e.Changes = d.Entries
+		e.FreeSpace = freeSpaceChanges(volumeFreeSpace(t.before), volumeFreeSpace(after))
+		if runErr != nil {
+			e.Error = runErr.Error()
+		}
+		t.Journal.Entries = append(t.Journal.Entries, e)
+		t.before = after
+		if runErr != nil {
+			return fmt.Errorf("%s: %w", e.Op, runErr)
+		}
+	}
+	return nil
+}
+
+// Validate returns the problems found in the image, none if it is valid.
+func (t *Transaction) Validate() ([]error, error) {
+	validate := &Validate{}
+	validate.SetContext(t.ctx)
+	if err := t.root.Apply(validate); err != nil {
+		return nil, err
+	}
+	return validate.Errors, nil
+}
+
+// Commit ends the transaction, keeping its operations, if the image
+// validates. It fails with ErrValidation otherwise, and the transaction
+// stays open, to be rolled back or fixed.
+func (t *Transaction) Commit() error {
+	if t.Done() {
+		return errTransactionDone
+	}
+	errs, err := t.Validate()
+	if err != nil {
+		return err
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%w: %d errors, first: %v", ErrValidation, len(errs), errs[0])
+	}
+	t.Journal.State = TransactionCommitted
+	return nil
+}
+
+// Rollback ends the transaction, restoring the tree as it was at Begin,
+// parsed again from the image assembled then. The nodes of the tree are
+// new, except for the root.
+func (t *Transaction) Rollback() error {
+	if t.Done() {
+		return errTransactionDone
+	}
+	f, err := uefi.ParseContext(t.ctx, append([]byte{}, t.image...))
+	if err != nil {
+		return err
+	}
+	dst, src := reflect.ValueOf(t.root), reflect.ValueOf(f)
+	if dst.Type() != src.Type() || dst.Kind() != reflect.Ptr {
+		return fmt.Errorf("cannot restore %T from %T", t.root, f)
+	}
+	dst.Elem().Set(src.Elem())
+	t.Journal.State = TransactionRolledBack
+	return nil
+}
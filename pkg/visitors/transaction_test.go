@@ -0,0 +1,105 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		commit bool
+		files  int
+		state  string
+	}{
+		{"commit", true, 0, TransactionCommitted},
+		{"rollback", false, 1, TransactionRolledBack},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := parseImage(t)
+			image := append([]byte{}, f.Buf()...)
+			tx, err := Begin(WithOutputFormat(context.Background(), FormatJSON), f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Apply(f, []string{"remove", testGUID.String(), "count"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Apply(f, []string{"save", "out.rom"}); err == nil {
+				t.Errorf("got no error for save in a transaction")
+			}
+			if test.commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Apply(f, []string{"count"}); err != errTransactionDone {
+				t.Errorf("got %v after the end of the transaction, want %v", err, errTransactionDone)
+			}
+			if got := find(t, f, testGUID); len(got) != test.files {
+				t.Errorf("got %d files, want %d", len(got), test.files)
+			}
+			if !test.commit {
+				if err := (&Assemble{}).Run(f); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(f.Buf(), image) {
+					t.Errorf("got a different image after the rollback")
+				}
+			}
+
+			var b bytes.Buffer
+			if err := tx.Journal.Write(&b); err != nil {
+				t.Fatal(err)
+			}
+			var j Journal
+			if err := json.Unmarshal(b.Bytes(), &j); err != nil {
+				t.Fatal(err)
+			}
+			if len(j.Entries) != 2 || j.State != test.state {
+				t.Fatalf("got %d entries %s, want 2 %s", len(j.Entries), j.State, test.state)
+			}
+			if e := j.Entries[0]; e.Op != "remove "+testGUID.String() || len(e.Changes) == 0 {
+				t.Errorf("got entry %+v, want the changes of the removal", e)
+			}
+			if e := j.Entries[1]; e.Op != "count" || len(e.Changes) != 0 {
+				t.Errorf("got entry %+v, want count without changes", e)
+			}
+		})
+	}
+}
+
+func TestShellTransaction(t *testing.T) {
+	f := parseImage(t)
+	in := strings.Join([]string{
+		"commit",
+		"begin",
+		"begin",
+		"remove DxeCore",
+		"journal",
+		"rollback",
+		"journal",
+	}, "\n")
+	var b bytes.Buffer
+	if err := (&Shell{Root: f, In: strings.NewReader(in), Out: &b}).Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"error: no open transaction", "error: a transaction is already open", "remove DxeCore\n", "  removed ", "State: open", "State: rolled back"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, b.String())
+		}
+	}
+	if find(t, f, dxeCoreGUID) == nil {
+		t.Errorf("the removed file is still gone after the rollback")
+	}
+}
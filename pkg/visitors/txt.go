@@ -0,0 +1,303 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit/consts"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var errNoSINIT = errors.New("no SINIT ACM found in the image")
+
+// TXTPolicy is the TXT policy record of the FIT.
+type TXTPolicy struct {
+	Version uint16
+	// Enabled is the TXT enable bit of a version 0 record.
+	Enabled bool `json:",omitempty"`
+	// IndexedIO is where the TXT enable bit of a version 1 record is, in
+	// the CMOS for instance.
+	IndexedIO *fit.EntryTXTPolicyRecordDataIndexedIO `json:",omitempty"`
+	Error     string                                 `json:",omitempty"`
+}
+
+// TXTACM is an authenticated code module found in the image.
+type TXTACM struct {
+	Offset uint64
+	Size   uint64
+	// FIT is true if an entry of the FIT points to the module.
+	FIT bool
+	// Type is BIOS or SINIT.
+	Type          string `json:",omitempty"`
+	Date          string
+	HeaderVersion uint32
+	TXTSVN        uint16
+	SESVN         uint16
+	// Version and Revision are those of the information table.
+	Version      uint8
+	Revision     string               `json:",omitempty"`
+	ChipsetIDs   []fit.ACMChipsetID   `json:",omitempty"`
+	ProcessorIDs []fit.ACMProcessorID `json:",omitempty"`
+	Error        string               `json:",omitempty"`
+	info         *fit.ACMInfo
+}
+
+// TXT reports the TXT policy record of the FIT and the authenticated code
+// modules, BIOS and SINIT ACMs, of the image, and replaces the SINIT ACM
+// with NewSINIT if set.
+type TXT struct {
+	Reporter
+
+	// NewSINIT is written over the SINIT ACMs of the image, which must
+	// have room for it, and the FIT entries pointing to them are updated.
+	NewSINIT []byte
+	// Optionally write the report, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Policy *TXTPolicy `json:",omitempty"`
+	ACMs   []TXTACM
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *TXT) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	switch p := v.Policy; {
+	case p == nil:
+		fmt.Fprintln(v.W, "TXT policy: no FIT entry")
+	case p.Error != "":
+		fmt.Fprintf(v.W, "TXT policy: version %d, %s\n", p.Version, p.Error)
+	case p.IndexedIO != nil:
+		fmt.Fprintf(v.W, "TXT policy: version %d, bit %d of index %#x at I/O ports %#x/%#x\n",
+			p.Version, p.IndexedIO.BitPosition, p.IndexedIO.Index, p.IndexedIO.IndexRegisterIOAddress, p.IndexedIO.DataRegisterIOAddress)
+	default:
+		fmt.Fprintf(v.W, "TXT policy: version %d, TXT enabled %v\n", p.Version, p.Enabled)
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Offset\tSize\tFIT\tType\tDate\tVersion\tRevision\tTXT SVN\tChipsets")
+	for _, a := range v.ACMs {
+		if a.Error != "" {
+			fmt.Fprintf(w, "%#x\t\t%v\t%s\n", a.Offset, a.FIT, a.Error)
+			continue
+		}
+		var chipsets []string
+		for _, c := range a.ChipsetIDs {
+			chipsets = append(chipsets, fmt.Sprintf("%04x:%04x", c.VendorID, c.DeviceID))
+		}
+		fmt.Fprintf(w, "%#x\t%#x\t%v\t%s\t%s\t%d\t%s\t%d\t%s\n",
+			a.Offset, a.Size, a.FIT, a.Type, a.Date, a.Version, a.Revision, a.TXTSVN, strings.Join(chipsets, " "))
+	}
+	return w.Flush()
+}
+
+// parseTXTACM parses the module at the start of buf.
+func parseTXTACM(buf []byte) TXTACM {
+	var a TXTACM
+	var common fit.EntrySACMDataCommon
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &common); err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	// The date is BCD encoded.
+	d := uint32(common.Date)
+	a.Date = fmt.Sprintf("%04x-%02x-%02x", d>>16, d>>8&0xff, d&0xff)
+	a.Size = common.Size.Size()
+	a.HeaderVersion = uint32(common.HeaderVersion)
+	a.TXTSVN, a.SESVN = uint16(common.TXTSVN), uint16(common.SESVN)
+	info, err := fit.ParseACMInfo(buf)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	a.info = info
+	a.Type = info.ChipsetACMType.String()
+	a.Version, a.Revision = info.ACMVersion, info.Revision()
+	a.ChipsetIDs, a.ProcessorIDs = info.ChipsetIDs, info.ProcessorIDs
+	return a
+}
+
+// Visit reports the TXT policy and ACMs of the image f and replaces the
+// SINIT ACM.
+func (v *TXT) Visit(f uefi.Firmware) error {
+	buf := f.Buf()
+	v.Policy = nil
+	v.ACMs = []TXTACM{}
+	var table fit.Table
+	if uint64(len(buf)) >= consts.FITPointerOffset {
+		table, _ = fit.GetTable(buf)
+	}
+	fitOffsets := map[uint64]bool{}
+	for _, hdr := range table {
+		switch hdr.Type() {
+		case fit.EntryTypeTXTPolicyRecord:
+			v.Policy = &TXTPolicy{Version: uint16(hdr.Version)}
+			entry, ok := hdr.GetEntry(buf).(*fit.EntryTXTPolicyRecord)
+			if !ok {
+				v.Policy.Error = "invalid entry"
+				break
+			}
+			data, err := entry.Parse()
+			if err != nil {
+				v.Policy.Error = err.Error()
+				break
+			}
+			switch d := data.(type) {
+			case fit.EntryTXTPolicyRecordDataFlatPointer:
+				v.Policy.Enabled = d.IsTXTEnabled()
+			case *fit.EntryTXTPolicyRecordDataIndexedIO:
+				v.Policy.IndexedIO = d
+			}
+		case fit.EntryTypeStartupACModuleEntry:
+			addr := hdr.Address.Pointer()
+			if addr >= consts.BasePhysAddr-uint64(len(buf)) && addr < consts.BasePhysAddr {
+				fitOffsets[fit.CalculateOffsetFromPhysAddr(addr, uint64(len(buf)))] = true
+			}
+		}
+	}
+
+	// ACMs are 4-byte aligned in the files of volumes, which are 8-byte
+	// aligned, or 4K aligned in the FIT.
+	found := map[uint64]bool{}
+	for off := 0; off+0x80 <= len(buf); off += 4 {
+		if binary.LittleEndian.Uint16(buf[off:]) != uint16(fit.ACModuleTypeChipset) ||
+			binary.LittleEndian.Uint32(buf[off+16:]) != uint32(fit.ACModuleVendorIntel) {
+			continue
+		}
+		a := parseTXTACM(buf[off:])
+		if a.Error != "" {
+			continue
+		}
+		a.Offset, a.FIT = uint64(off), fitOffsets[uint64(off)]
+		found[a.Offset] = true
+		v.ACMs = append(v.ACMs, a)
+		off += int(a.Size) - 4
+	}
+	for off := range fitOffsets {
+		if !found[off] {
+			a := parseTXTACM(buf[off:])
+			a.Offset, a.FIT = off, true
+			v.ACMs = append(v.ACMs, a)
+		}
+	}
+	sort.Slice(v.ACMs, func(i, j int) bool { return v.ACMs[i].Offset < v.ACMs[j].Offset })
+
+	if v.NewSINIT == nil {
+		return nil
+	}
+	return v.replaceSINIT(f, table)
+}
+
+// replaceSINIT writes NewSINIT over the SINIT ACMs of f and updates the FIT
+// entries pointing to them.
+func (v *TXT) replaceSINIT(f uefi.Firmware, table fit.Table) error {
+	info, err := fit.ParseACMInfo(v.NewSINIT)
+	if err != nil {
+		return fmt.Errorf("invalid SINIT ACM: %v", err)
+	}
+	if info.ChipsetACMType != fit.ChipsetACMTypeSINIT {
+		return fmt.Errorf("the ACM is a %v ACM, not a SINIT ACM", info.ChipsetACMType)
+	}
+	buf := f.Buf()
+	size := uint64(len(buf))
+	replaced, fitChanged := false, false
+	for i, a := range v.ACMs {
+		if a.info == nil || a.info.ChipsetACMType != fit.ChipsetACMTypeSINIT {
+			continue
+		}
+		// The new ACM may use the erased space following the old one.
+		room := a.Size
+		for a.Offset+room < size && buf[a.Offset+room] == 0xff {
+			room++
+		}
+		if uint64(len(v.NewSINIT)) > room {
+			return fmt.Errorf("the SINIT ACM (%#x bytes) does not fit in the %#x bytes at %#x", len(v.NewSINIT), room, a.Offset)
+		}
+		b := make([]byte, a.Size)
+		for j := range b {
+			b[j] = 0xff
+		}
+		if uint64(len(v.NewSINIT)) > a.Size {
+			b = v.NewSINIT
+		} else {
+			copy(b, v.NewSINIT)
+		}
+		if err := writeImageRange(f, a.Offset, b); err != nil {
+			return err
+		}
+		n := parseTXTACM(v.NewSINIT)
+		n.Offset, n.FIT = a.Offset, a.FIT
+		v.ACMs[i] = n
+		replaced = true
+
+		if !a.FIT {
+			continue
+		}
+		// The entries keep pointing to the ACM, whose size they do not
+		// hold: clear it and fix their checksums.
+		addr := fit.CalculatePhysAddrFromOffset(a.Offset, size)
+		for j := range table {
+			hdr := &table[j]
+			if hdr.Address.Pointer() != addr {
+				continue
+			}
+			hdr.Size.SetUint32(0)
+			if hdr.IsChecksumValid() {
+				hdr.Checksum = hdr.CalculateChecksum()
+			}
+			fitChanged = true
+		}
+	}
+	if !replaced {
+		return errNoSINIT
+	}
+	if !fitChanged {
+		return nil
+	}
+	start, _, err := fit.GetHeadersTableRangeFrom(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if _, err := table.WriteTo(&b); err != nil {
+		return err
+	}
+	return writeImageRange(f, start, b.Bytes())
+}
+
+func init() {
+	RegisterCLI("txt", "print the TXT policy record of the FIT and the versions and supported chipsets of the BIOS and SINIT ACMs", 0, func(args []string) (uefi.Visitor, error) {
+		return &TXT{W: os.Stdout}, nil
+	})
+	RegisterCLI("txt_sinit_replace", "txt_sinit_replace FILE\n replace the SINIT ACMs of the image with the ACM FILE and update the FIT entries pointing to them", 1, func(args []string) (uefi.Visitor, error) {
+		b, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &TXT{NewSINIT: b}, nil
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// testACM returns an ACM of size bytes and type t, its information table
+// at 0x200 and a chipset ID list at 0x300.
+func testACM(size int, t fit.ChipsetACMType, revision byte) []byte {
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint16(b[0:], uint16(fit.ACModuleTypeChipset))
+	binary.LittleEndian.PutUint32(b[4:], 0x40)
+	binary.LittleEndian.PutUint32(b[16:], uint32(fit.ACModuleVendorIntel))
+	binary.LittleEndian.PutUint32(b[20:], 0x20230115)
+	binary.LittleEndian.PutUint32(b[24:], uint32(size/4))
+	binary.LittleEndian.PutUint16(b[28:], 2)
+	binary.LittleEndian.PutUint32(b[124:], 0x40)
+	info := b[0x200:]
+	copy(info, fit.ACMInfoTableUUID[:])
+	info[16] = uint8(t)
+	info[17] = 3
+	binary.LittleEndian.PutUint32(info[20:], 0x300)
+	copy(info[36:], []byte{1, 1, 4, revision})
+	binary.LittleEndian.PutUint32(b[0x300:], 1)
+	binary.LittleEndian.PutUint16(b[0x308:], 0x8086)
+	binary.LittleEndian.PutUint16(b[0x30a:], 0xa0c0)
+	return b
+}
+
+// txtImage returns ifdImage with a BIOS ACM at 0x3c000 and a SINIT ACM at
+// 0x3c800, both in the FIT, and a TXT policy record enabling TXT.
+func txtImage(t *testing.T) []byte {
+	const base = 0xFFFC0000
+	buf := ifdImage(t)
+	copy(buf[0x3c000:], testACM(0x400, fit.ChipsetACMTypeBIOS, 1))
+	copy(buf[0x3c800:], testACM(0x400, fit.ChipsetACMTypeSINIT, 2))
+	table := buf[0x3f000:]
+	copy(table, make([]byte, 0x40))
+	copy(table, "_FIT_   ")
+	table[8] = 4
+	for i, off := range []uint64{0x3c000, 0x3c800} {
+		e := table[0x10*(i+1):]
+		binary.LittleEndian.PutUint64(e, base+off)
+		e[0xd] = 0x01
+		e[0xe] = byte(fit.EntryTypeStartupACModuleEntry)
+	}
+	// A SINIT entry with a size and a checksum.
+	table[0x28] = 0x40
+	table[0x2e] |= 0x80
+	e := table[0x30:]
+	binary.LittleEndian.PutUint64(e, 1<<63)
+	e[0xe] = byte(fit.EntryTypeTXTPolicyRecord)
+	binary.LittleEndian.PutUint64(buf[0x3ffc0:], base+0x3f000)
+	return buf
+}
+
+func TestTXT(t *testing.T) {
+	f, err := uefi.Parse(txtImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v := &TXT{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.Policy == nil || !v.Policy.Enabled {
+		t.Errorf("got TXT policy %+v, want TXT enabled", v.Policy)
+	}
+	if len(v.ACMs) != 2 {
+		t.Fatalf("got ACMs %+v, want 2", v.ACMs)
+	}
+	for _, s := range []string{
+		"TXT policy: version 0, TXT enabled true\n",
+		"0x3c000  0x400  true  BIOS   2023-01-15  1        1.4.1     2        8086:a0c0",
+		"0x3c800  0x400  true  SINIT  2023-01-15  1        1.4.2     2        8086:a0c0",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestTXTSINITReplace(t *testing.T) {
+	f, err := uefi.Parse(txtImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinit := testACM(0x800, fit.ChipsetACMTypeSINIT, 3)
+	if err := (&TXT{NewSINIT: sinit}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	buf := f.Buf()
+	if !bytes.Equal(buf[0x3c800:0x3d000], sinit) {
+		t.Errorf("SINIT ACM not replaced")
+	}
+	table, err := fit.GetTable(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr := table[2]; hdr.Size.Uint32() != 0 || hdr.Checksum != hdr.CalculateChecksum() {
+		t.Errorf("got SINIT entry %v, want its size cleared and its checksum fixed", &hdr)
+	}
+
+	v := &TXT{}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.ACMs) != 2 || v.ACMs[1].Revision != "1.4.3" || v.ACMs[0].Revision != "1.4.1" {
+		t.Errorf("got ACMs %+v, want the BIOS ACM 1.4.1 and the SINIT ACM 1.4.3", v.ACMs)
+	}
+
+	for _, acm := range [][]byte{
+		testACM(0x400, fit.ChipsetACMTypeBIOS, 3),
+		testACM(0x10000, fit.ChipsetACMTypeSINIT, 3),
+		[]byte("not an ACM"),
+	} {
+		if err := (&TXT{NewSINIT: acm}).Run(f); err == nil {
+			t.Errorf("replaced the SINIT ACM with a %#x bytes ACM, want an error", len(acm))
+		}
+	}
+}
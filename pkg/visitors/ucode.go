@@ -0,0 +1,278 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit/consts"
+	"github.com/linuxboot/fiano/pkg/intel/microcode"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// UcodePatch is an Intel microcode patch found in the image.
+type UcodePatch struct {
+	// Name is the file name of the patch, like MCExtractor names them:
+	// cpu906A3_plat80_ver00000424_2022-09-19_PRD_F6DDD45D.bin.
+	Name   string
+	Offset uint64
+	Size   uint32
+	CPUID  uint32
+	// PlatformIDs is the mask of the platform IDs of the patch.
+	PlatformIDs uint32
+	Revision    uint32
+	Date        string
+	// ExtendedCPUIDs are those of the extended signature table, if any.
+	ExtendedCPUIDs []uint32 `json:",omitempty"`
+	// FIT is true if a microcode entry of the FIT points to the patch.
+	FIT bool
+	// ChecksumOK is false if the header, data or extended signature
+	// table do not sum to 0.
+	ChecksumOK bool
+	// Error tells why a FIT entry does not point to a valid patch.
+	Error string `json:",omitempty"`
+}
+
+// Ucode lists the Intel microcode patches of the image, those the FIT
+// points to and those found by scanning the image for their headers, and
+// optionally extracts them to DirPath.
+type Ucode struct {
+	Reporter
+
+	DirPath string
+	// Optionally write the patches, as a table unless Format is set.
+	W io.Writer `json:"-"`
+
+	// Output
+	Patches []UcodePatch
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Ucode) Run(f uefi.Firmware) error {
+	if err := v.Visit(f); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.Format != "" {
+		b, err := marshalReport(v.Format, v.Patches)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	w := tabwriter.NewWriter(v.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Offset\tCPUID\tPlatform\tRevision\tDate\tSize\tFIT\tChecksum\tName")
+	for _, p := range v.Patches {
+		sum := "ok"
+		if !p.ChecksumOK {
+			sum = "BAD"
+		}
+		if p.Error != "" {
+			fmt.Fprintf(w, "%#x\t\t\t\t\t\t%v\t\t%s\n", p.Offset, p.FIT, p.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%#x\t%05X\t%02X\t%08X\t%s\t%#x\t%v\t%s\t%s\n",
+			p.Offset, p.CPUID, p.PlatformIDs, p.Revision, p.Date, p.Size, p.FIT, sum, p.Name)
+	}
+	return w.Flush()
+}
+
+// ucodeHeaderSize is the size of microcode.Header.
+const ucodeHeaderSize = 48
+
+// ucodeSum returns the sum of the little endian 32-bit words of b.
+func ucodeSum(b []byte) uint32 {
+	var sum uint32
+	for ; len(b) >= 4; b = b[4:] {
+		sum += binary.LittleEndian.Uint32(b)
+	}
+	return sum
+}
+
+// isBCD returns whether v is packed BCD in [min, max].
+func isBCD(v, min, max uint32) bool {
+	var n, m uint32 = 0, 1
+	for x := v; x != 0; x >>= 4 {
+		if x&0xf > 9 {
+			return false
+		}
+		n += (x & 0xf) * m
+		m *= 10
+	}
+	return n >= min && n <= max
+}
+
+// parseUcode returns the patch at the start of b, or an error if b does
+// not start with a patch header. Checksum errors are reported in the
+// patch.
+func parseUcode(b []byte) (*UcodePatch, error) {
+	if len(b) < ucodeHeaderSize {
+		return nil, fmt.Errorf("%d bytes left for a microcode header", len(b))
+	}
+	var h microcode.Header
+	// Reading from enough bytes cannot fail.
+	_ = binary.Read(bytes.NewReader(b), binary.LittleEndian, &h)
+	if h.HeaderVersion != 1 || h.HeaderLoaderRevision != 1 {
+		return nil, fmt.Errorf("no microcode header")
+	}
+	year, day, month := h.HeaderDate&0xffff, (h.HeaderDate>>16)&0xff, h.HeaderDate>>24
+	if !isBCD(year, 1990, 2099) || !isBCD(month, 1, 12) || !isBCD(day, 1, 31) {
+		return nil, fmt.Errorf("invalid microcode date %08x", h.HeaderDate)
+	}
+	dataSize, totalSize := h.HeaderDataSize, h.HeaderTotalSize
+	if dataSize == 0 {
+		dataSize, totalSize = microcode.DefaultDatasize, microcode.DefaultTotalSize
+	}
+	if dataSize%4 != 0 || totalSize%4 != 0 || uint64(totalSize) < uint64(dataSize)+ucodeHeaderSize {
+		return nil, fmt.Errorf("invalid microcode sizes %#x and %#x", dataSize, totalSize)
+	}
+	if uint64(totalSize) > uint64(len(b)) {
+		return nil, fmt.Errorf("microcode of %#x bytes ends past the image", totalSize)
+	}
+	p := &UcodePatch{
+		Size:        totalSize,
+		CPUID:       h.HeaderProcessorSignature,
+		PlatformIDs: h.HeaderProcessorFlags,
+		Revision:    h.HeaderRevision,
+		Date:        fmt.Sprintf("%04x-%02x-%02x", year, month, day),
+		ChecksumOK:  ucodeSum(b[:ucodeHeaderSize+dataSize]) == 0,
+	}
+	if ext := b[ucodeHeaderSize+dataSize : totalSize]; len(ext) >= 20 {
+		n := binary.LittleEndian.Uint32(ext)
+		if uint64(n)*12+20 > uint64(len(ext)) {
+			return nil, fmt.Errorf("%d extended signatures do not fit in the microcode", n)
+		}
+		p.ChecksumOK = p.ChecksumOK && ucodeSum(ext[:20+12*n]) == 0
+		for i := uint32(0); i < n; i++ {
+			p.ExtendedCPUIDs = append(p.ExtendedCPUIDs, binary.LittleEndian.Uint32(ext[20+12*i:]))
+		}
+	}
+	p.Name = fmt.Sprintf("cpu%05X_plat%02X_ver%08X_%s_PRD_%08X.bin",
+		p.CPUID, p.PlatformIDs, p.Revision, p.Date, h.HeaderChecksum)
+	return p, nil
+}
+
+// ucodeFITOffsets returns the offsets of the microcode entries of the FIT
+// of buf, if it has one.
+func ucodeFITOffsets(buf []byte) []uint64 {
+	size := uint64(len(buf))
+	if size < consts.FITPointerOffset {
+		return nil
+	}
+	table, err := fit.GetTable(buf)
+	if err != nil {
+		return nil
+	}
+	var offsets []uint64
+	for _, hdr := range table {
+		if hdr.Type() != fit.EntryTypeMicrocodeUpdateEntry {
+			continue
+		}
+		addr := hdr.Address.Pointer()
+		if addr < consts.BasePhysAddr-size || addr >= consts.BasePhysAddr {
+			continue
+		}
+		offsets = append(offsets, fit.CalculateOffsetFromPhysAddr(addr, size))
+	}
+	return offsets
+}
+
+// Visit lists the patches of the image f.
+func (v *Ucode) Visit(f uefi.Firmware) error {
+	buf := f.Buf()
+	v.Patches = []UcodePatch{}
+	found := map[uint64]int{}
+	// Patches are 16-byte aligned, in FIT or in the files of volumes.
+	for off := 0; off+ucodeHeaderSize <= len(buf); off += 16 {
+		if binary.LittleEndian.Uint32(buf[off:]) != 1 {
+			continue
+		}
+		p, err := parseUcode(buf[off:])
+		if err != nil {
+			continue
+		}
+		p.Offset = uint64(off)
+		found[p.Offset] = len(v.Patches)
+		v.Patches = append(v.Patches, *p)
+		off += int(p.Size) - 16
+	}
+	for _, off := range ucodeFITOffsets(buf) {
+		if i, ok := found[off]; ok {
+			v.Patches[i].FIT = true
+			continue
+		}
+		p := UcodePatch{Offset: off, FIT: true}
+		if q, err := parseUcode(buf[off:]); err != nil {
+			p.Error = err.Error()
+		} else {
+			// A patch the scan skipped, being unaligned or inside
+			// another.
+			p = *q
+			p.Offset, p.FIT = off, true
+		}
+		v.Patches = append(v.Patches, p)
+	}
+	sort.SliceStable(v.Patches, func(i, j int) bool { return v.Patches[i].Offset < v.Patches[j].Offset })
+	// Copies of a patch share its name, other patches with the same
+	// header, like corrupted ones, are named after their offset too.
+	first := map[string]*UcodePatch{}
+	for i := range v.Patches {
+		p := &v.Patches[i]
+		if p.Error != "" {
+			continue
+		}
+		q, ok := first[p.Name]
+		if !ok {
+			first[p.Name] = p
+		} else if q.Size != p.Size || !bytes.Equal(buf[q.Offset:q.Offset+uint64(q.Size)], buf[p.Offset:p.Offset+uint64(p.Size)]) {
+			p.Name = fmt.Sprintf("%s_%X.bin", strings.TrimSuffix(p.Name, ".bin"), p.Offset)
+		}
+	}
+
+	if v.DirPath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(v.DirPath, 0777); err != nil {
+		return err
+	}
+	for _, p := range v.Patches {
+		if p.Error != "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(v.DirPath, p.Name), buf[p.Offset:p.Offset+uint64(p.Size)], 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Outputs implements Outputter.
+func (v *Ucode) Outputs() []string {
+	if v.DirPath == "" {
+		return nil
+	}
+	return []string{v.DirPath}
+}
+
+func init() {
+	RegisterCLI("ucode", "print the CPUID, platform IDs, revision, date and checksum of the Intel microcode patches of the image and its FIT", 0, func(args []string) (uefi.Visitor, error) {
+		return &Ucode{W: os.Stdout}, nil
+	})
+	RegisterCLI("ucode_extract", "ucode_extract DIR\n like ucode, also extracting the patches to DIR named by CPUID, platform IDs, revision and date", 1, func(args []string) (uefi.Visitor, error) {
+		return &Ucode{DirPath: args[0], W: os.Stdout}, nil
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// testUcode is a patch for CPUID 906A3 with an extended signature table
+// adding 906A4.
+var testUcode = []byte("\x01\x00\x00\x00\x24\x04\x00\x00\x22\x20\x19\x09\xa3\x06\x09\x00\x31\xd4\xdd\xf6\x01\x00\x00\x00\x80\x00\x00\x00\x04\x00\x00\x00\x60\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00\x06\x5a\x21\x95\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa3\x06\x09\x00\x80\x00\x00\x00\xd9\x4b\x66\xb5\xa4\x06\x09\x00\x80\x00\x00\x00\xd8\x4b\x66\xb5")
+
+func TestUcode(t *testing.T) {
+	const base = 0xFFFC0000
+	buf := ifdImage(t)
+	// A patch in the GbE region, one with a bad checksum and an unaligned
+	// one after the FIT.
+	copy(buf[0x2000:], testUcode)
+	copy(buf[0x3d100:], testUcode)
+	buf[0x3d100+48] = 1
+	copy(buf[0x3d208:], testUcode)
+	table := buf[0x3d000:]
+	copy(table, make([]byte, 0x40))
+	copy(table, "_FIT_   ")
+	table[8] = 4
+	for i, off := range []uint64{0x2000, 0x3d208, 0x3e000} {
+		binary.LittleEndian.PutUint64(table[0x10*(i+1):], base+off)
+		table[0x10*(i+1)+0xe] = 0x01
+	}
+	binary.LittleEndian.PutUint64(buf[0x3ffc0:], base+0x3d000)
+	f, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	v := &Ucode{DirPath: dir, W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	const name = "cpu906A3_plat80_ver00000424_2022-09-19_PRD_F6DDD431.bin"
+	want := []UcodePatch{
+		{Name: name, Offset: 0x2000, Size: 0x60, CPUID: 0x906a3, PlatformIDs: 0x80, Revision: 0x424, Date: "2022-09-19",
+			ExtendedCPUIDs: []uint32{0x906a3, 0x906a4}, FIT: true, ChecksumOK: true},
+		{Name: "cpu906A3_plat80_ver00000424_2022-09-19_PRD_F6DDD431_3D100.bin", Offset: 0x3d100, Size: 0x60, CPUID: 0x906a3, PlatformIDs: 0x80, Revision: 0x424, Date: "2022-09-19",
+			ExtendedCPUIDs: []uint32{0x906a3, 0x906a4}},
+		{Name: name, Offset: 0x3d208, Size: 0x60, CPUID: 0x906a3, PlatformIDs: 0x80, Revision: 0x424, Date: "2022-09-19",
+			ExtendedCPUIDs: []uint32{0x906a3, 0x906a4}, FIT: true, ChecksumOK: true},
+		{Offset: 0x3e000, FIT: true, Error: "no microcode header"},
+	}
+	if !reflect.DeepEqual(v.Patches, want) {
+		t.Errorf("got patches %+v, want %+v", v.Patches, want)
+	}
+	if !strings.Contains(out.String(), "0x3d100  906A3  80        00000424  2022-09-19  0x60  false  BAD") {
+		t.Errorf("the table lacks the bad patch:\n%s", out.String())
+	}
+	for _, p := range want[:3] {
+		b, err := os.ReadFile(filepath.Join(dir, p.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, buf[p.Offset:p.Offset+0x60]) {
+			t.Errorf("extracted % x to %s, want the patch at %#x", b, p.Name, p.Offset)
+		}
+	}
+}
+
+func TestUcodeNone(t *testing.T) {
+	v := &Ucode{}
+	if err := v.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Patches) != 0 {
+		t.Errorf("got patches %v in OVMF, want none", v.Patches)
+	}
+}
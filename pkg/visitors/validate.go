@@ -14,15 +14,25 @@ import (
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
+// ErrValidation is the error of Validate for images with errors, when they
+// are written as Diagnostics.
+var ErrValidation = errors.New("validation failed")
+
 // Validate performs extra checks on the firmware image.
 type Validate struct {
+	Cancelable
+	Reporter
+
 	// An optional Writer for writing errors when validation is complete.
 	// When the writer it set, Run will also call os.Exit(1) upon finding
 	// an error.
 	W io.Writer
 
-	// List of validation errors.
+	// List of validation errors, *Diagnostic with the node they are about.
 	Errors []error
+
+	// path holds the nodes from the root to the visited node.
+	path []uefi.Firmware
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
@@ -31,17 +41,83 @@ func (v *Validate) Run(f uefi.Firmware) error {
 		return err
 	}
 
-	if v.W != nil && len(v.Errors) != 0 {
+	if v.Format != FormatDefault {
+		// A report is written even without errors, so scripts always get
+		// a document to read.
+		w := v.W
+		if w == nil {
+			w = os.Stdout
+		}
+		report := struct{ Errors []string }{Errors: []string{}}
 		for _, e := range v.Errors {
-			fmt.Println(e)
+			report.Errors = append(report.Errors, e.Error())
+		}
+		b, err := marshalReport(v.Format, report)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(b))
+	}
+
+	if w := DiagnosticsOf(v.Context()); w != nil && len(v.Errors) != 0 {
+		if err := v.writeDiagnostics(w, f); err != nil {
+			return err
+		}
+		if v.W == nil {
+			return fmt.Errorf("%w: %d errors", ErrValidation, len(v.Errors))
+		}
+		os.Exit(1)
+	}
+
+	if v.W != nil && len(v.Errors) != 0 {
+		if v.Format == FormatDefault {
+			for _, e := range v.Errors {
+				fmt.Println(e)
+			}
 		}
 		os.Exit(1)
 	}
 	return nil
 }
 
+// writeDiagnostics writes the errors as Diagnostics, with the offsets of
+// their nodes in f.
+func (v *Validate) writeDiagnostics(w io.Writer, f uefi.Firmware) error {
+	offsets, err := nodeOffsets(f)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.Errors {
+		d, ok := e.(*Diagnostic)
+		if !ok {
+			d = &Diagnostic{Level: "error", Code: "validate", Msg: e.Error()}
+		}
+		if o, ok := offsets[d.node]; ok && d.node != nil {
+			d.Offset = &o
+		}
+		if err := WriteDiagnostic(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Visit applies the Validate visitor to any Firmware type.
 func (v *Validate) Visit(f uefi.Firmware) error {
+	// The errors found about f become Diagnostics about it, once its
+	// children, whose errors come after, are done.
+	n := len(v.Errors)
+	v.path = append(v.path, f)
+	defer func() {
+		for i, e := range v.Errors[n:] {
+			if _, ok := e.(*Diagnostic); !ok {
+				v.Errors[n+i] = &Diagnostic{Level: "error", Code: diagnosticCode("validate", f),
+					Path: shellPathString(v.path), Msg: e.Error(), node: f}
+			}
+		}
+		v.path = v.path[:len(v.path)-1]
+	}()
+
 	// TODO: add more verification where needed
 	switch f := f.(type) {
 	case *uefi.FlashImage:
@@ -214,6 +290,8 @@ func (v *Validate) Visit(f uefi.Firmware) error {
 			v.Errors = append(v.Errors, err)
 		}
 
+		var first *uefi.FirmwareVolume
+		var firstIdx int
 		for i, e := range f.Elements {
 			if err := e.Value.Apply(v); err != nil {
 				return err
@@ -223,12 +301,16 @@ func (v *Validate) Visit(f uefi.Firmware) error {
 				// Not a firmware volume
 				continue
 			}
+			if first == nil {
+				first, firstIdx = f, i
+				continue
+			}
 			// We have to do this because they didn't put an encapsulating structure around the FVs.
 			// This means it's possible for different firmware volumes to report different erase polarities.
-			// Now we have to check to see if we're in some insane state.
-			if ep := f.GetErasePolarity(); ep != uefi.Attributes.ErasePolarity {
-				v.Errors = append(v.Errors, fmt.Errorf("erase polarity mismatch! fv 0 has %#x and fv %d has %#x",
-					uefi.Attributes.ErasePolarity, i, ep))
+			// Each is parsed and assembled with its own, but flash tools erase the whole region with one.
+			if ep := f.GetErasePolarity(); ep != first.GetErasePolarity() && !uefi.SuppressErasePolarityError {
+				v.Errors = append(v.Errors, fmt.Errorf("erase polarity mismatch! fv %d has %#x and fv %d has %#x",
+					firstIdx, first.GetErasePolarity(), i, ep))
 			}
 		}
 		return nil // We already traversed the children manually.
@@ -73,3 +73,34 @@ func TestValidateFile(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateErasePolarity(t *testing.T) {
+	defer func(s bool) { uefi.SuppressErasePolarityError = s }(uefi.SuppressErasePolarityError)
+
+	var elements []*uefi.TypedFirmware
+	for i := 0; i < 2; i++ {
+		fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, uefi.MakeTyped(fv))
+	}
+	// Only the attributes of the volume say its erase polarity.
+	elements[1].Value.(*uefi.FirmwareVolume).Attributes ^= 0x800
+	br := &uefi.BIOSRegion{Elements: elements}
+
+	for _, suppress := range []bool{false, true} {
+		uefi.SuppressErasePolarityError = suppress
+		v := &Validate{}
+		if err := v.Run(br); err != nil {
+			t.Fatal(err)
+		}
+		var msgs []string
+		if !suppress {
+			msgs = []string{"erase polarity mismatch! fv 0 has 0xff and fv 1 has 0x0"}
+		}
+		if len(v.Errors) != len(msgs) || len(msgs) != 0 && v.Errors[0].Error() != msgs[0] {
+			t.Errorf("suppressed %v: got errors %v, want %v", suppress, v.Errors, msgs)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/vulndb"
+)
+
+// VulnHit is a file matching a known vulnerable module.
+type VulnHit struct {
+	GUID        guid.GUID
+	Name        string `json:",omitempty"`
+	Version     string `json:",omitempty"`
+	ID          string
+	Severity    string
+	Description string
+	Reference   string `json:",omitempty"`
+}
+
+// VulnScan matches the files of the firmware against a database of known
+// vulnerable modules.
+type VulnScan struct {
+	Cancelable
+	Reporter
+
+	// Input
+	DB *vulndb.DB
+	// Optionally write the hits as JSON.
+	W io.Writer `json:"-"`
+
+	// Output
+	Hits []VulnHit
+
+	module *vulndb.Module
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *VulnScan) Run(f uefi.Firmware) error {
+	v.Hits = []VulnHit{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		b, err := marshalReport(v.Format, v.Hits)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	return nil
+}
+
+// Visit applies the VulnScan visitor to any Firmware type.
+func (v *VulnScan) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		// Files of nested volumes are modules of their own.
+		parent := v.module
+		v.module = &vulndb.Module{GUID: f.Header.GUID}
+		if err := f.ApplyChildren(v); err != nil {
+			return err
+		}
+		for _, e := range v.DB.Match(v.module) {
+			v.Hits = append(v.Hits, VulnHit{
+				GUID:        v.module.GUID,
+				Name:        v.module.Name,
+				Version:     v.module.Version,
+				ID:          e.ID,
+				Severity:    e.Severity,
+				Description: e.Description,
+				Reference:   e.Reference,
+			})
+		}
+		v.module = parent
+		return nil
+
+	case *uefi.Section:
+		if v.module == nil {
+			return f.ApplyChildren(v)
+		}
+		switch f.Header.Type {
+		case uefi.SectionTypeUserInterface:
+			v.module.Name = f.Name
+		case uefi.SectionTypeVersion:
+			v.module.Version = f.Version
+		case uefi.SectionTypePE32, uefi.SectionTypeTE:
+			// Images which are not valid PE32 or TE cannot match a digest.
+			if d, err := uefi.AuthenticodeDigest(f.Buf()[f.HeaderLen():], sha256.New()); err == nil {
+				v.module.SHA256 = append(v.module.SHA256, hex.EncodeToString(d))
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("vulnscan", "match files against the bundled database of known vulnerable modules", 0, func(args []string) (uefi.Visitor, error) {
+		db, err := vulndb.Bundled()
+		if err != nil {
+			return nil, err
+		}
+		return &VulnScan{
+			DB: db,
+			W:  os.Stdout,
+		}, nil
+	})
+	RegisterCLI("vulnscan_db", "match files against the bundled database extended with a JSON database", 1, func(args []string) (uefi.Visitor, error) {
+		db, err := vulndb.Bundled()
+		if err != nil {
+			return nil, err
+		}
+		if err := db.LoadFile(args[0]); err != nil {
+			return nil, err
+		}
+		return &VulnScan{
+			DB: db,
+			W:  os.Stdout,
+		}, nil
+	})
+}
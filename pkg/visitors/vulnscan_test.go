@@ -0,0 +1,68 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/vulndb"
+)
+
+func TestVulnScan(t *testing.T) {
+	f := parseImage(t)
+
+	// Find the digest of DxeCore to match it by hash.
+	a := &Authenticode{}
+	if err := a.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var digest string
+	for _, e := range a.Manifest {
+		if e.GUID == *dxeCoreGUID {
+			digest = e.SHA256
+		}
+	}
+
+	db, err := vulndb.Bundled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Load(strings.NewReader(`[
+		{"ID": "by-guid", "Severity": "Low", "GUIDs": ["` + testGUID.String() + `"]},
+		{"ID": "by-hash", "Severity": "High", "SHA256": ["` + digest + `"]},
+		{"ID": "by-name", "Severity": "High", "Names": ["DxeCore"], "Versions": ["0.0"]}
+	]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &VulnScan{DB: db, W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range v.Hits {
+		got = append(got, h.ID)
+		if h.ID == "by-hash" && (h.GUID != *dxeCoreGUID || h.Name != "DxeCore") {
+			t.Errorf("by-hash: got %+v, want DxeCore", h)
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "by-guid,by-hash" {
+		t.Errorf("hits: got %v, want by-guid and by-hash", got)
+	}
+
+	var hits []VulnHit
+	if err := json.Unmarshal(b.Bytes(), &hits); err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != len(v.Hits) {
+		t.Errorf("JSON: got %d hits, want %d", len(hits), len(v.Hits))
+	}
+}
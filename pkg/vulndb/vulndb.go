@@ -0,0 +1,145 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vulndb matches UEFI modules against a database of known
+// vulnerable ones, identified by file GUID, UI name, version or the
+// Authenticode digest of their image.
+package vulndb
+
+import (
+	"bytes"
+	_ "embed" // for the bundled database
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+//go:embed vulndb.json
+var bundled []byte
+
+// Entry is a known vulnerable module. A module matches if any of its
+// GUIDs, Names or SHA256 digests match and, when Versions is not empty,
+// its version section is one of them.
+type Entry struct {
+	ID          string
+	Severity    string
+	Description string
+	Reference   string `json:",omitempty"`
+
+	GUIDs []string `json:",omitempty"`
+	Names []string `json:",omitempty"`
+	// SHA256 holds hex encoded Authenticode SHA-256 digests of the PE32 or
+	// TE image, as found in dbx.
+	SHA256   []string `json:",omitempty"`
+	Versions []string `json:",omitempty"`
+
+	guids []guid.GUID
+}
+
+// Module is what the database knows to match of a firmware file.
+type Module struct {
+	GUID    guid.GUID
+	Name    string
+	Version string
+	// SHA256 holds hex encoded Authenticode SHA-256 digests of the images
+	// of the module.
+	SHA256 []string
+}
+
+// DB is a database of known vulnerable modules.
+type DB struct {
+	Entries []Entry
+}
+
+// Bundled returns the database shipped with fiano.
+func Bundled() (*DB, error) {
+	db := &DB{}
+	if err := db.Load(bytes.NewReader(bundled)); err != nil {
+		return nil, fmt.Errorf("bundled database: %v", err)
+	}
+	return db, nil
+}
+
+// Load appends the entries of the JSON list in r to the database.
+func (db *DB) Load(r io.Reader) error {
+	var entries []Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+	for i := range entries {
+		e := &entries[i]
+		if e.ID == "" {
+			return fmt.Errorf("entry without ID")
+		}
+		if len(e.GUIDs)+len(e.Names)+len(e.SHA256) == 0 {
+			return fmt.Errorf("%s: no GUID, name or digest to match", e.ID)
+		}
+		for _, s := range e.GUIDs {
+			g, err := guid.Parse(s)
+			if err != nil {
+				return fmt.Errorf("%s: %v", e.ID, err)
+			}
+			e.guids = append(e.guids, *g)
+		}
+	}
+	db.Entries = append(db.Entries, entries...)
+	return nil
+}
+
+// LoadFile appends the entries of the JSON file at path to the database.
+func (db *DB) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := db.Load(f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Match returns the entries matching m.
+func (db *DB) Match(m *Module) []Entry {
+	var r []Entry
+	for _, e := range db.Entries {
+		if e.match(m) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+func (e *Entry) match(m *Module) bool {
+	if len(e.Versions) != 0 && !containsFold(e.Versions, m.Version) {
+		return false
+	}
+	for _, g := range e.guids {
+		if g == m.GUID {
+			return true
+		}
+	}
+	if m.Name != "" && containsFold(e.Names, m.Name) {
+		return true
+	}
+	for _, s := range m.SHA256 {
+		if containsFold(e.SHA256, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(l []string, s string) bool {
+	for _, x := range l {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
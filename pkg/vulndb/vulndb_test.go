@@ -0,0 +1,71 @@
+// Copyright 2021 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vulndb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+var testGUID = *guid.MustParse("DF1CCEF6-F301-4A63-9661-FC6030DCC880")
+
+func TestBundled(t *testing.T) {
+	db, err := Bundled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Entries) == 0 {
+		t.Fatalf("empty bundled database")
+	}
+	if m := db.Match(&Module{Name: "SecureBackDoor"}); len(m) != 1 || m[0].ID != "CVE-2021-3971" {
+		t.Errorf("SecureBackDoor: got %v, want CVE-2021-3971", m)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	db := &DB{}
+	if err := db.Load(strings.NewReader(`[
+		{"ID": "guid", "GUIDs": ["DF1CCEF6-F301-4A63-9661-FC6030DCC880"]},
+		{"ID": "name", "Names": ["Foo"], "Versions": ["1.0", "1.1"]},
+		{"ID": "hash", "SHA256": ["ABCD"]}
+	]`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		m    Module
+		want string
+	}{
+		{Module{GUID: testGUID}, "guid"},
+		{Module{Name: "foo", Version: "1.1"}, "name"},
+		{Module{Name: "foo", Version: "1.2"}, ""},
+		{Module{Name: "foo"}, ""},
+		{Module{SHA256: []string{"1234", "abcd"}}, "hash"},
+		{Module{GUID: testGUID, SHA256: []string{"abcd"}}, "guid,hash"},
+		{Module{Name: "Bar"}, ""},
+	} {
+		var got []string
+		for _, e := range db.Match(&tt.m) {
+			got = append(got, e.ID)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Match(%+v): got %v, want %v", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, s := range []string{
+		`{}`,
+		`[{"Names": ["Foo"]}]`,
+		`[{"ID": "nothing to match"}]`,
+		`[{"ID": "bad GUID", "GUIDs": ["banana"]}]`,
+	} {
+		if err := (&DB{}).Load(strings.NewReader(s)); err == nil {
+			t.Errorf("Load(%s): got nil, want error", s)
+		}
+	}
+}